@@ -19,7 +19,9 @@ package auth
 
 import (
 	"context"
+	"time"
 
+	apimodel "github.com/polarismesh/specification/source/go/api/v1/model"
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
 	apiservice "github.com/polarismesh/specification/source/go/api/v1/service_manage"
 
@@ -44,6 +46,42 @@ type AuthChecker interface {
 	IsOpenClientAuth() bool
 }
 
+// ValidationResult 批量校验用户时单个用户的校验结果
+type ValidationResult struct {
+	// Index 用户在请求中的下标
+	Index int
+	// Name 用户名称
+	Name string
+	// Code 校验结果，校验通过时为 Code_ExecuteSuccess
+	Code apimodel.Code
+	// Info 校验不通过的原因
+	Info string
+}
+
+// ImportOption 批量导入用户的选项，零值时与创建用户的校验规则一致
+type ImportOption struct {
+	// HashAlgorithm 不为空时表示导入的密码已经是该算法的摘要，原样保存，目前仅支持 bcrypt
+	HashAlgorithm string
+	// SkipPasswordCheck 不校验明文密码的复杂度，用于导入历史系统中不满足规则的密码，必须同时设置 MustChangePassword
+	SkipPasswordCheck bool
+	// MustChangePassword 导入的用户必须先修改密码才能继续操作
+	MustChangePassword bool
+}
+
+// PermissionSummary 用户的有效权限汇总，由用户自身（包括默认策略）以及所在用户组关联的鉴权策略合并得到
+type PermissionSummary struct {
+	// UserID 用户 ID
+	UserID string
+	// Action 合并后的操作权限，任意一条策略为 READ_WRITE 时为 READ_WRITE，否则为 ONLY_READ
+	Action apisecurity.AuthAction
+	// StrategyIDs 参与合并的策略 ID，按照 ID 排序，为空时用户没有任何权限
+	StrategyIDs []string
+	// ReadResources 可以读取的资源，即所有策略关联的资源
+	ReadResources []model.StrategyResource
+	// WriteResources 可以修改的资源，即 READ_WRITE 策略关联的资源
+	WriteResources []model.StrategyResource
+}
+
 // UserServer 用户数据管理 server
 type UserServer interface {
 	// Initialize 初始化
@@ -52,10 +90,18 @@ type UserServer interface {
 	Name() string
 	// CreateUsers 批量创建用户
 	CreateUsers(ctx context.Context, users []*apisecurity.User) *apiservice.BatchWriteResponse
+	// AddUserWithHashedPassword 使用已经计算过摘要的密码创建用户，用于从其他系统迁移用户
+	AddUserWithHashedPassword(ctx context.Context, user *apisecurity.User, algorithm string) *apiservice.Response
+	// ImportUsers 从其他系统批量导入用户，可以跳过历史密码的复杂度校验
+	ImportUsers(ctx context.Context, users []*apisecurity.User, opt ImportOption) *apiservice.BatchWriteResponse
+	// ValidateUsers 按照创建用户的规则逐个校验用户，不写入任何数据，用于批量导入前的预检查
+	ValidateUsers(ctx context.Context, users []*model.User) []ValidationResult
 	// UpdateUser 更新用户信息
 	UpdateUser(ctx context.Context, user *apisecurity.User) *apiservice.Response
 	// UpdateUserPassword 更新用户密码
 	UpdateUserPassword(ctx context.Context, req *apisecurity.ModifyUserPassword) *apiservice.Response
+	// RenameUser 修改用户名称
+	RenameUser(ctx context.Context, userId, newName string) *apiservice.Response
 	// DeleteUsers 批量删除用户
 	DeleteUsers(ctx context.Context, users []*apisecurity.User) *apiservice.BatchWriteResponse
 	// GetUsers 查询用户列表
@@ -66,6 +112,15 @@ type UserServer interface {
 	UpdateUserToken(ctx context.Context, user *apisecurity.User) *apiservice.Response
 	// ResetUserToken 重置用户的token
 	ResetUserToken(ctx context.Context, user *apisecurity.User) *apiservice.Response
+	// ResetCredentials 同时重置用户的密码以及 token
+	ResetCredentials(ctx context.Context, userId, newPassword, newToken string) *apiservice.Response
+	// CreateScopedToken 为用户创建一个可以限制为只读以及设置有效期的附加 token
+	CreateScopedToken(ctx context.Context, userId, name string, scope model.UserTokenScope,
+		ttl time.Duration) (*model.UserToken, apimodel.Code)
+	// ListScopedTokens 查询用户有效的附加 token，不返回 token 的内容
+	ListScopedTokens(ctx context.Context, userId string) ([]*model.UserToken, apimodel.Code)
+	// RevokeScopedToken 撤销用户的附加 token
+	RevokeScopedToken(ctx context.Context, userId, tokenId string) apimodel.Code
 	// Login 登录动作
 	Login(req *apisecurity.LoginRequest) *apiservice.Response
 	GroupOperator
@@ -111,6 +166,8 @@ type StrategyServer interface {
 	GetStrategy(ctx context.Context, strategy *apisecurity.AuthStrategy) *apiservice.Response
 	// GetPrincipalResources 获取某个 principal 的所有可操作资源列表
 	GetPrincipalResources(ctx context.Context, query map[string]string) *apiservice.Response
+	// GetUserPermissionSummary 汇总用户的有效权限，包括默认策略以及从用户组继承的策略
+	GetUserPermissionSummary(ctx context.Context, userId string) (*PermissionSummary, apimodel.Code)
 	// GetAuthChecker 获取鉴权检查器
 	GetAuthChecker() AuthChecker
 	// AfterResourceOperation 操作完资源的后置处理逻辑
@@ -21,6 +21,7 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
@@ -46,12 +47,24 @@ var (
 // DefaultAuthChecker 北极星自带的默认鉴权中心
 type DefaultAuthChecker struct {
 	cacheMgn cachetypes.CacheManager
+	// storage 用于查询用户创建的受限 token
+	storage store.UserTokenStore
+	// scopedTokens 受限 token 的查询缓存
+	scopedTokens *scopedTokenCache
+	// loginRecorder 记录用户最近一次登录时间
+	loginRecorder *loginRecorder
 }
 
 func (d *DefaultAuthChecker) SetCacheMgr(mgr cachetypes.CacheManager) {
 	d.cacheMgn = mgr
 }
 
+// SetStorage 设置受限 token 的存储
+func (d *DefaultAuthChecker) SetStorage(s store.UserTokenStore) {
+	d.storage = s
+	d.scopedTokens = newScopedTokenCache(scopedTokenCacheTTL)
+}
+
 // Initialize 执行初始化动作
 func (d *DefaultAuthChecker) Initialize(options *auth.Config, s store.Store, cacheMgr cachetypes.CacheManager) error {
 	// 新版本鉴权策略配置均从auth.Option中迁移至auth.user.option及auth.strategy.option中
@@ -104,9 +117,21 @@ func (d *DefaultAuthChecker) Initialize(options *auth.Config, s store.Store, cac
 	}
 	AuthOption = cfg
 	d.cacheMgn = cacheMgr
+	d.storage = s
+	d.scopedTokens = newScopedTokenCache(scopedTokenCacheTTL)
+	// 重复初始化时停止之前的记录协程
+	d.Close()
+	d.loginRecorder = newLoginRecorder(s, defaultLoginRecordInterval)
 	return nil
 }
 
+// Close 停止后台记录登录时间的协程
+func (d *DefaultAuthChecker) Close() {
+	if d.loginRecorder != nil {
+		d.loginRecorder.close()
+	}
+}
+
 // Cache 获取缓存统一管理
 func (d *DefaultAuthChecker) Cache() cachetypes.CacheManager {
 	return d.cacheMgn
@@ -162,6 +187,9 @@ func (d *DefaultAuthChecker) checkMaintainPermission(preCtx *model.AcquireContex
 	if tokenInfo.Disable {
 		return false, model.ErrorTokenDisabled
 	}
+	if tokenInfo.IsReadOnly() {
+		return false, model.ErrorTokenReadOnly
+	}
 	if !tokenInfo.IsUserToken {
 		return false, errors.New("only user role can access maintain API")
 	}
@@ -178,6 +206,7 @@ func (d *DefaultAuthChecker) checkMaintainPermission(preCtx *model.AcquireContex
 //		case 1. 如果 token 被禁用
 //				a. 读操作，直接放通
 //				b. 写操作，快速失败
+//		case 2. 如果 token 为只读的受限 token，写操作快速失败
 //	step 3. 拉取token对应的操作者相关信息，注入到请求上下文中
 //	step 4. 进行权限检查
 func (d *DefaultAuthChecker) CheckPermission(authCtx *model.AcquireContext) (bool, error) {
@@ -194,6 +223,9 @@ func (d *DefaultAuthChecker) CheckPermission(authCtx *model.AcquireContext) (boo
 	if operatorInfo.Disable {
 		return false, model.ErrorTokenDisabled
 	}
+	if operatorInfo.IsReadOnly() {
+		return false, model.ErrorTokenReadOnly
+	}
 
 	log.Debug("[Auth][Checker] check permission args", utils.RequestID(authCtx.GetRequestContext()),
 		zap.String("method", authCtx.GetMethod()), zap.Any("resources", authCtx.GetAccessResources()))
@@ -239,9 +271,9 @@ func canDowngradeAnonymous(authCtx *model.AcquireContext, err error) bool {
 //   - 如果是访问权限控制相关模块（用户、用户组、权限策略），不得转为匿名用户
 func (d *DefaultAuthChecker) VerifyCredential(authCtx *model.AcquireContext) error {
 	reqId := utils.ParseRequestID(authCtx.GetRequestContext())
+	authToken := utils.ParseAuthToken(authCtx.GetRequestContext())
 
 	checkErr := func() error {
-		authToken := utils.ParseAuthToken(authCtx.GetRequestContext())
 		operator, err := d.decodeToken(authToken)
 		if err != nil {
 			log.Error("[Auth][Checker] decode token", zap.Error(err))
@@ -259,6 +291,7 @@ func (d *DefaultAuthChecker) VerifyCredential(authCtx *model.AcquireContext) err
 		ctx = context.WithValue(ctx, utils.ContextIsOwnerKey, isOwner)
 		ctx = context.WithValue(ctx, utils.ContextUserIDKey, operator.OperatorID)
 		ctx = context.WithValue(ctx, utils.ContextOwnerIDKey, ownerId)
+		ctx = context.WithValue(ctx, utils.ContextTokenScopeKey, operator.Scope)
 		authCtx.SetRequestContext(ctx)
 		d.parseOperatorInfo(operator, authCtx)
 		if operator.Disable {
@@ -269,6 +302,9 @@ func (d *DefaultAuthChecker) VerifyCredential(authCtx *model.AcquireContext) err
 	}()
 
 	if checkErr != nil {
+		if AuthOption.LogTokenFailures && authToken != "" {
+			logTokenFailure(authCtx, authToken, checkErr)
+		}
 		if !canDowngradeAnonymous(authCtx, checkErr) {
 			return checkErr
 		}
@@ -281,6 +317,39 @@ func (d *DefaultAuthChecker) VerifyCredential(authCtx *model.AcquireContext) err
 	return nil
 }
 
+// logTokenFailure 输出 token 校验失败的结构化日志，供安全审计系统采集，日志中的 token 只保留首尾部分
+func logTokenFailure(authCtx *model.AcquireContext, token string, err error) {
+	ctx := authCtx.GetRequestContext()
+	log.Warn("[Auth][Checker] token verification failed", utils.RequestID(ctx),
+		zap.String("event", "token_verification_failure"),
+		zap.String("token", maskToken(token)),
+		zap.String("source-ip", utils.ParseClientIP(ctx)),
+		zap.String("method", authCtx.GetMethod()),
+		zap.String("reason", tokenFailureReason(err)))
+}
+
+// tokenFailureReason token 校验失败的原因
+func tokenFailureReason(err error) string {
+	switch {
+	case errors.Is(err, model.ErrorTokenInvalid):
+		return "invalid"
+	case errors.Is(err, model.ErrorTokenExpired):
+		return "expired"
+	case errors.Is(err, model.ErrorTokenNotExist):
+		return "not_exist"
+	case errors.Is(err, model.ErrorNoUser):
+		return "user_not_found"
+	case errors.Is(err, model.ErrorNoUserGroup):
+		return "group_not_found"
+	case errors.Is(err, model.ErrorTokenDisabled):
+		return "disabled"
+	case errors.Is(err, model.ErrorTokenReadOnly):
+		return "read_only"
+	default:
+		return "unknown"
+	}
+}
+
 func (d *DefaultAuthChecker) parseOperatorInfo(operator OperatorInfo, authCtx *model.AcquireContext) {
 	ctx := authCtx.GetRequestContext()
 	if operator.IsUserToken {
@@ -320,6 +389,11 @@ func (d *DefaultAuthChecker) DecodeToken(t string) (OperatorInfo, error) {
 
 // decodeToken 解析 token 信息，如果 t == ""，直接返回一个空对象
 func (d *DefaultAuthChecker) decodeToken(t string) (OperatorInfo, error) {
+	return decodeToken(t)
+}
+
+// decodeToken 解析 token 信息，如果 t == ""，直接返回一个空对象
+func decodeToken(t string) (OperatorInfo, error) {
 	if t == "" {
 		return OperatorInfo{}, model.ErrorTokenInvalid
 	}
@@ -344,6 +418,12 @@ func (d *DefaultAuthChecker) decodeToken(t string) (OperatorInfo, error) {
 		OperatorID:  detail[1],
 		Role:        model.UnknownUserRole,
 	}
+	// 用户创建的受限 token，此时 OperatorID 需要在 checkToken 时根据 token 记录换成真正的用户 ID
+	if detail[0] == model.TokenForUserToken {
+		tokenInfo.IsUserToken = true
+		tokenInfo.TokenID = detail[1]
+		tokenInfo.OperatorID = ""
+	}
 	return tokenInfo, nil
 }
 
@@ -354,6 +434,10 @@ func (d *DefaultAuthChecker) checkToken(tokenInfo *OperatorInfo) (string, bool,
 		return "", false, nil
 	}
 
+	if tokenInfo.TokenID != "" {
+		return d.checkScopedToken(tokenInfo)
+	}
+
 	id := tokenInfo.OperatorID
 	if tokenInfo.IsUserToken {
 		user := d.Cache().User().GetUserByID(id)
@@ -366,11 +450,7 @@ func (d *DefaultAuthChecker) checkToken(tokenInfo *OperatorInfo) (string, bool,
 		}
 
 		tokenInfo.Disable = !user.TokenEnable
-		if user.Owner == "" {
-			return user.ID, true, nil
-		}
-
-		return user.Owner, false, nil
+		return user.OwnerID(), user.IsMainAccount(), nil
 	}
 	group := d.Cache().User().GetGroup(id)
 	if group == nil {
@@ -385,6 +465,43 @@ func (d *DefaultAuthChecker) checkToken(tokenInfo *OperatorInfo) (string, bool,
 	return group.Owner, false, nil
 }
 
+// checkScopedToken 对用户创建的受限 token 进行检查，并将 token 绑定的用户信息回填到 tokenInfo 中
+// return {owner-id} {is-owner} {error}
+func (d *DefaultAuthChecker) checkScopedToken(tokenInfo *OperatorInfo) (string, bool, error) {
+	if d.storage == nil || d.scopedTokens == nil {
+		return "", false, model.ErrorTokenNotExist
+	}
+	record, err := d.scopedTokens.get(d.storage, tokenInfo.TokenID)
+	if err != nil {
+		return "", false, err
+	}
+	if record == nil || !record.Valid || record.Token != tokenInfo.Origin {
+		return "", false, model.ErrorTokenNotExist
+	}
+	if record.IsExpired(time.Now()) {
+		return "", false, model.ErrorTokenExpired
+	}
+
+	user := d.Cache().User().GetUserByID(record.UserID)
+	if user == nil {
+		return "", false, model.ErrorNoUser
+	}
+
+	tokenInfo.OperatorID = user.ID
+	tokenInfo.Scope = record.Scope
+	// 用户自身的 token 被禁用时，其创建的受限 token 同样不能执行写操作
+	tokenInfo.Disable = !user.TokenEnable
+	return user.OwnerID(), user.IsMainAccount(), nil
+}
+
+// invalidateScopedToken 受限 token 被撤销后，删除其查询缓存
+func (d *DefaultAuthChecker) invalidateScopedToken(id string) {
+	if d == nil || d.scopedTokens == nil {
+		return
+	}
+	d.scopedTokens.invalidate(id)
+}
+
 func (d *DefaultAuthChecker) isResourceEditable(
 	principal model.Principal,
 	resourceType apisecurity.ResourceType,
@@ -20,6 +20,8 @@ package defaultauth_test
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -31,6 +33,7 @@ import (
 	"github.com/polarismesh/polaris/auth/defaultauth"
 	"github.com/polarismesh/polaris/cache"
 	cachetypes "github.com/polarismesh/polaris/cache/api"
+	commonlog "github.com/polarismesh/polaris/common/log"
 	"github.com/polarismesh/polaris/common/model"
 	"github.com/polarismesh/polaris/common/utils"
 	storemock "github.com/polarismesh/polaris/store/mock"
@@ -63,6 +66,7 @@ func Test_DefaultAuthChecker_VerifyCredential(t *testing.T) {
 	storage.EXPECT().GetServicesCount().AnyTimes().Return(uint32(1), nil)
 	storage.EXPECT().GetUnixSecond(gomock.Any()).AnyTimes().Return(time.Now().Unix(), nil)
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return([]*model.UserGroupDetail{}, nil)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -208,6 +212,93 @@ func Test_DefaultAuthChecker_VerifyCredential(t *testing.T) {
 	})
 }
 
+func Test_DefaultAuthChecker_LogTokenFailures(t *testing.T) {
+	reset(false)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	users := createMockUser(2)
+	storage := storemock.NewMockStore(ctrl)
+	storage.EXPECT().GetUnixSecond(gomock.Any()).AnyTimes().Return(time.Now().Unix(), nil)
+	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return([]*model.UserGroupDetail{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cacheMgn, err := cache.TestCacheInitialize(ctx, &cache.Config{}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = cacheMgn.OpenResourceCache(cachetypes.ConfigEntry{Name: cachetypes.UsersName})
+	_ = cacheMgn.TestUpdate()
+	t.Cleanup(func() {
+		cancel()
+		cacheMgn.Close()
+	})
+
+	checker := &defaultauth.DefaultAuthChecker{}
+	checker.SetCacheMgr(cacheMgn)
+
+	readLog := captureAuthLog(t)
+
+	token, err := defaultauth.TestCreateToken("not_exist_user", "")
+	assert.NoError(t, err)
+	verify := func() {
+		ctx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token)
+		ctx = context.WithValue(ctx, utils.ContextClientAddress, "10.0.0.1:8080")
+		authCtx := model.NewAcquireContext(
+			model.WithRequestContext(ctx),
+			model.WithModule(model.AuthModule),
+			model.WithMethod("Test_DefaultAuthChecker_LogTokenFailures"),
+		)
+		assert.Error(t, checker.VerifyCredential(authCtx))
+	}
+
+	t.Run("未开启时不输出校验失败日志", func(t *testing.T) {
+		verify()
+		assert.NotContains(t, readLog(), "token_verification_failure")
+	})
+
+	t.Run("开启后输出脱敏的结构化日志", func(t *testing.T) {
+		defaultauth.AuthOption.LogTokenFailures = true
+		t.Cleanup(func() {
+			defaultauth.AuthOption.LogTokenFailures = false
+		})
+		verify()
+
+		content := readLog()
+		assert.Contains(t, content, "token verification failed")
+		assert.Contains(t, content, `"event": "token_verification_failure"`)
+		assert.Contains(t, content, `"token": "`+token[:4]+"****"+token[len(token)-4:]+`"`)
+		assert.Contains(t, content, `"source-ip": "10.0.0.1"`)
+		assert.Contains(t, content, `"reason": "user_not_found"`)
+		assert.NotContains(t, content, token)
+	})
+}
+
+// captureAuthLog 将 auth 日志输出到临时文件，返回读取日志内容的函数，测试结束时恢复默认配置
+func captureAuthLog(t *testing.T) func() string {
+	logFile := filepath.Join(t.TempDir(), "auth.log")
+	err := commonlog.Configure(map[string]*commonlog.Options{
+		commonlog.AuthLoggerName: {
+			OutputPaths:      []string{logFile},
+			ErrorOutputPaths: []string{"stderr"},
+			OutputLevel:      "info",
+		},
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = commonlog.Configure(map[string]*commonlog.Options{
+			commonlog.AuthLoggerName: commonlog.DefaultOptions()[commonlog.AuthLoggerName],
+		})
+	})
+	return func() string {
+		_ = commonlog.FindScope(commonlog.AuthLoggerName).Sync()
+		content, err := os.ReadFile(logFile)
+		assert.NoError(t, err)
+		return string(content)
+	}
+}
+
 func Test_DefaultAuthChecker_CheckPermission_Write_NoStrict(t *testing.T) {
 	reset(false)
 	ctrl := gomock.NewController(t)
@@ -224,6 +315,7 @@ func Test_DefaultAuthChecker_CheckPermission_Write_NoStrict(t *testing.T) {
 	cfg, storage := initCache(ctrl)
 
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(groups, nil)
 	storage.EXPECT().GetStrategyDetailsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(strategies, nil)
 	storage.EXPECT().GetMoreNamespaces(gomock.Any()).AnyTimes().Return(namespaces, nil)
@@ -470,6 +562,7 @@ func Test_DefaultAuthChecker_CheckPermission_Write_Strict(t *testing.T) {
 	cfg, storage := initCache(ctrl)
 
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(groups, nil)
 	storage.EXPECT().GetStrategyDetailsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(strategies, nil)
 	storage.EXPECT().GetMoreNamespaces(gomock.Any()).AnyTimes().Return(namespaces, nil)
@@ -673,6 +766,7 @@ func Test_DefaultAuthChecker_CheckPermission_Read_NoStrict(t *testing.T) {
 	cfg, storage := initCache(ctrl)
 
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(groups, nil)
 	storage.EXPECT().GetStrategyDetailsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(strategies, nil)
 	storage.EXPECT().GetMoreNamespaces(gomock.Any()).AnyTimes().Return(namespaces, nil)
@@ -896,6 +990,7 @@ func Test_DefaultAuthChecker_CheckPermission_Read_Strict(t *testing.T) {
 	cfg, storage := initCache(ctrl)
 
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(groups, nil)
 	storage.EXPECT().GetStrategyDetailsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(strategies, nil)
 	storage.EXPECT().GetMoreNamespaces(gomock.Any()).AnyTimes().Return(namespaces, nil)
@@ -1114,6 +1209,7 @@ func Test_DefaultAuthChecker_Initialize(t *testing.T) {
 	storage := storemock.NewMockStore(ctrl)
 	storage.EXPECT().GetUnixSecond(gomock.Any()).AnyTimes().Return(time.Now().Unix(), nil)
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return([]*model.UserGroupDetail{}, nil)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -17,11 +17,29 @@
 
 package defaultauth
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // AuthOption 鉴权的配置信息
 var AuthOption = DefaultAuthConfig()
 
+const (
+	// NamePolicyDefault 名称只允许包含中文、英文字母、数字以及 _-.
+	NamePolicyDefault = "default"
+	// NamePolicyUnicode 名称允许包含任意语言的文字、数字以及 _-.
+	NamePolicyUnicode = "unicode"
+)
+
+// DefaultUserSources 未配置 userSources 时允许的用户来源
+var DefaultUserSources = []string{"Polaris", "LDAP", "OIDC"}
+
+// maxPasswordPepperVersionLen pepper 版本号的最大长度
+const maxPasswordPepperVersionLen = 16
+
 // AuthConfig 鉴权配置
 type AuthConfig struct {
 	// ConsoleOpen 控制台是否开启鉴权
@@ -37,6 +55,27 @@ type AuthConfig struct {
 	ConsoleStrict bool `json:"consoleStrict"`
 	// ClientStrict 是否启用鉴权的严格模式，即对于没有任何鉴权策略的资源，也必须带上正确的token才能操作, 默认关闭
 	ClientStrict bool `json:"clientStrict"`
+	// PasswordMaxAgeDays 密码的有效天数，超过后必须修改密码才能继续操作，小于等于 0 表示密码不过期
+	PasswordMaxAgeDays int `json:"passwordMaxAgeDays"`
+	// NamePolicy 用户、用户组以及鉴权策略名称的校验规则，可选 default、unicode，为空时等同于 default
+	NamePolicy string `json:"namePolicy"`
+	// PasswordPeppers 计算密码摘要时混入的 pepper，key 为版本号，pepper 只保存在配置中，不会写入存储
+	// 轮换时新增一个版本并修改 ActivePasswordPepper，旧版本需要保留到所有用户都修改过密码为止
+	PasswordPeppers map[string]string `json:"passwordPeppers"`
+	// ActivePasswordPepper 新计算的密码摘要使用的 pepper 版本，为空时不使用 pepper
+	ActivePasswordPepper string `json:"activePasswordPepper"`
+	// UserSources 创建用户时允许的用户来源，比较时不区分大小写，保存时统一为这里配置的写法，为空时使用 DefaultUserSources
+	UserSources []string `json:"userSources"`
+	// AllowUnknownUserSource 允许创建来源不在 UserSources 中的用户，此时来源按原样保存
+	AllowUnknownUserSource bool `json:"allowUnknownUserSource"`
+	// TokenLength 新生成的用户、用户组 token 中随机部分的长度，为 0 时使用 DefaultTokenLength
+	TokenLength int `json:"tokenLength"`
+	// LogTokenFailures token 校验失败时输出包含脱敏 token、来源 IP 以及失败原因的结构化日志，供安全审计系统采集
+	LogTokenFailures bool `json:"logTokenFailures"`
+	// PasswordPolicy 全局的密码规则，未单独配置规则的主账户及其子账户使用该规则
+	PasswordPolicy PasswordPolicy `json:"passwordPolicy"`
+	// OwnerPasswordPolicies 按照主账户 ID 单独配置的密码规则，对主账户自身及其子账户生效，完整替代全局的规则
+	OwnerPasswordPolicies map[string]PasswordPolicy `json:"ownerPasswordPolicies"`
 }
 
 // Verify 检查配置是否合法
@@ -49,9 +88,81 @@ func (cfg *AuthConfig) Verify() error {
 		return errors.New("[Auth][Config] salt len must 16 | 24 | 32")
 	}
 
+	switch cfg.NamePolicy {
+	case "", NamePolicyDefault, NamePolicyUnicode:
+	default:
+		return errors.New("[Auth][Config] namePolicy must be default | unicode")
+	}
+
+	for version, pepper := range cfg.PasswordPeppers {
+		// 版本号会记录在密码摘要中，需要控制长度避免超出存储中密码字段的长度
+		if version == "" || len(version) > maxPasswordPepperVersionLen || strings.Contains(version, ":") ||
+			pepper == "" {
+			return errors.New("[Auth][Config] passwordPeppers version must be 1 ~ 16 chars without ':', " +
+				"and pepper must not be empty")
+		}
+	}
+	if _, ok := cfg.PasswordPeppers[cfg.ActivePasswordPepper]; cfg.ActivePasswordPepper != "" && !ok {
+		return errors.New("[Auth][Config] activePasswordPepper not found in passwordPeppers")
+	}
+
+	if cfg.TokenLength != 0 && (cfg.TokenLength < minTokenLength || cfg.TokenLength > maxTokenLength) {
+		return fmt.Errorf("[Auth][Config] tokenLength must be %d ~ %d", minTokenLength, maxTokenLength)
+	}
+
+	if err := cfg.PasswordPolicy.verify(); err != nil {
+		return errors.New("[Auth][Config] passwordPolicy " + err.Error())
+	}
+	for owner, policy := range cfg.OwnerPasswordPolicies {
+		if owner == "" {
+			return errors.New("[Auth][Config] ownerPasswordPolicies must not contain empty owner")
+		}
+		if err := policy.verify(); err != nil {
+			return fmt.Errorf("[Auth][Config] ownerPasswordPolicies of owner %s %s", owner, err.Error())
+		}
+	}
+
+	sources := make(map[string]struct{}, len(cfg.UserSources))
+	for _, source := range cfg.UserSources {
+		key := strings.ToLower(strings.TrimSpace(source))
+		if key == "" {
+			return errors.New("[Auth][Config] userSources must not contain empty source")
+		}
+		if _, ok := sources[key]; ok {
+			return errors.New("[Auth][Config] userSources contains duplicated source: " + source)
+		}
+		sources[key] = struct{}{}
+	}
+
 	return nil
 }
 
+// PasswordMaxAge 密码的有效期，为 0 时表示密码不过期
+func (cfg *AuthConfig) PasswordMaxAge() time.Duration {
+	if cfg.PasswordMaxAgeDays <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.PasswordMaxAgeDays) * 24 * time.Hour
+}
+
+// NormalizeUserSource 将用户来源统一为配置中的写法，来源未知且不允许未知来源时返回 false，空的来源不做校验
+func (cfg *AuthConfig) NormalizeUserSource(source string) (string, bool) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return source, true
+	}
+	sources := cfg.UserSources
+	if len(sources) == 0 {
+		sources = DefaultUserSources
+	}
+	for _, known := range sources {
+		if strings.EqualFold(strings.TrimSpace(known), source) {
+			return strings.TrimSpace(known), true
+		}
+	}
+	return source, cfg.AllowUnknownUserSource
+}
+
 // DefaultAuthConfig 返回一个默认的鉴权配置
 func DefaultAuthConfig() *AuthConfig {
 	return &AuthConfig{
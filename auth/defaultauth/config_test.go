@@ -0,0 +1,93 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package defaultauth_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/auth/defaultauth"
+)
+
+func Test_AuthConfig_NormalizeUserSource(t *testing.T) {
+	t.Run("默认来源不区分大小写", func(t *testing.T) {
+		cfg := defaultauth.DefaultAuthConfig()
+		source, ok := cfg.NormalizeUserSource(" polaris ")
+		assert.True(t, ok)
+		assert.Equal(t, "Polaris", source)
+		source, ok = cfg.NormalizeUserSource("ldap")
+		assert.True(t, ok)
+		assert.Equal(t, "LDAP", source)
+	})
+
+	t.Run("空的来源不做校验", func(t *testing.T) {
+		source, ok := defaultauth.DefaultAuthConfig().NormalizeUserSource("")
+		assert.True(t, ok)
+		assert.Equal(t, "", source)
+	})
+
+	t.Run("未知的来源", func(t *testing.T) {
+		cfg := defaultauth.DefaultAuthConfig()
+		cfg.UserSources = []string{"Polaris", "Github"}
+		_, ok := cfg.NormalizeUserSource("LDAP")
+		assert.False(t, ok)
+		source, ok := cfg.NormalizeUserSource("GITHUB")
+		assert.True(t, ok)
+		assert.Equal(t, "Github", source)
+
+		cfg.AllowUnknownUserSource = true
+		source, ok = cfg.NormalizeUserSource(" LDAP ")
+		assert.True(t, ok)
+		assert.Equal(t, "LDAP", source)
+	})
+
+	t.Run("配置校验", func(t *testing.T) {
+		cfg := defaultauth.DefaultAuthConfig()
+		cfg.UserSources = []string{"Polaris", "LDAP"}
+		assert.NoError(t, cfg.Verify())
+		cfg.UserSources = []string{"Polaris", " "}
+		assert.Error(t, cfg.Verify())
+		cfg.UserSources = []string{"Polaris", "polaris"}
+		assert.Error(t, cfg.Verify())
+	})
+}
+
+func Test_AuthConfig_PasswordPolicyOf(t *testing.T) {
+	cfg := defaultauth.DefaultAuthConfig()
+	cfg.PasswordPolicy = defaultauth.PasswordPolicy{MinLength: 8}
+	cfg.OwnerPasswordPolicies = map[string]defaultauth.PasswordPolicy{
+		"owner-1": {MinLength: 12, MaxLength: 20},
+	}
+	assert.NoError(t, cfg.Verify())
+
+	// 未单独配置规则的主账户以及新建的主账户使用全局的规则
+	assert.Error(t, cfg.PasswordPolicyOf("owner-2").Check("polaris"))
+	assert.NoError(t, cfg.PasswordPolicyOf("owner-2").Check("polaris1"))
+	assert.NoError(t, cfg.PasswordPolicyOf("").Check("polaris1"))
+	assert.Error(t, cfg.PasswordPolicyOf("owner-1").Check("polaris1"))
+	assert.NoError(t, cfg.PasswordPolicyOf("owner-1").Check("polaris-pwd-1"))
+
+	cfg.OwnerPasswordPolicies["owner-2"] = defaultauth.PasswordPolicy{MinLength: 20, MaxLength: 10}
+	assert.Error(t, cfg.Verify())
+	cfg.OwnerPasswordPolicies = map[string]defaultauth.PasswordPolicy{"": {}}
+	assert.Error(t, cfg.Verify())
+	cfg.OwnerPasswordPolicies = nil
+	cfg.PasswordPolicy = defaultauth.PasswordPolicy{MaxLength: 100}
+	assert.Error(t, cfg.Verify())
+}
@@ -97,9 +97,12 @@ func (svr *GroupAuthAbility) GetGroup(ctx context.Context, req *apisecurity.User
 	return svr.target.GetGroup(ctx, req)
 }
 
-// GetGroupToken 获取用户组token
+// GetGroupToken 获取用户组token，只读的附加 token 不能获取
 func (svr *GroupAuthAbility) GetGroupToken(ctx context.Context, req *apisecurity.UserGroup) *apiservice.Response {
 	ctx, rsp := verifyAuth(ctx, ReadOp, NotOwner, svr.authMgn)
+	if rsp == nil {
+		rsp = verifyTokenWritable(ctx)
+	}
 	if rsp != nil {
 		return rsp
 	}
@@ -76,6 +76,7 @@ func newGroupTest(t *testing.T) *GroupTest {
 	storage.EXPECT().AddGroup(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().UpdateUser(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(append(users, newUsers...), nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(allGroups, nil)
 
 	cfg := &cache.Config{}
@@ -0,0 +1,171 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package defaultauth
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/polarismesh/polaris/store"
+)
+
+const (
+	// defaultLoginRecordInterval 同一个用户两次记录登录时间的最小间隔
+	defaultLoginRecordInterval = time.Minute
+	// loginRecordQueueSize 等待异步写入存储的登录记录的最大个数
+	loginRecordQueueSize = 1024
+)
+
+// loginRecorder 记录用户最近一次的登录时间
+// 每个请求都会进行 token 校验，为了避免每次请求都写一次存储，同一个用户在 interval 内只会记录一次，
+// 并且写存储由后台协程异步完成，不阻塞 token 校验，后台协程在 close 之后退出
+type loginRecorder struct {
+	storage  store.UserStore
+	interval time.Duration
+	// userid -> 上一次记录的时间，超过 interval 的记录由后台协程定期清理
+	lastRecords sync.Map
+	// pending 等待写入存储的登录记录
+	pending chan loginRecord
+	// inflight 已经入队但是尚未写入完成的登录记录
+	inflight sync.WaitGroup
+	// done 关闭后后台协程写完已经入队的记录后退出
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// loginRecord 一次待写入的登录记录，at 为占用记录机会时保存的时间
+type loginRecord struct {
+	userID string
+	at     time.Time
+}
+
+func newLoginRecorder(storage store.UserStore, interval time.Duration) *loginRecorder {
+	r := &loginRecorder{
+		storage:  storage,
+		interval: interval,
+		pending:  make(chan loginRecord, loginRecordQueueSize),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record 记录用户的登录时间，距离上一次记录不足 interval 时直接忽略
+func (r *loginRecorder) Record(userID string) {
+	if r == nil || r.storage == nil || userID == "" {
+		return
+	}
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	now := time.Now()
+	if !r.claim(userID, now) {
+		return
+	}
+	r.inflight.Add(1)
+	select {
+	case r.pending <- loginRecord{userID: userID, at: now}:
+	default:
+		// 队列已满时放弃本次记录，允许下一次请求重新尝试
+		r.inflight.Done()
+		r.lastRecords.CompareAndDelete(userID, now)
+		log.Warn("[Auth][Login] login record queue is full, skip", zap.String("user", userID))
+	}
+}
+
+// claim 原子地占用用户在当前 interval 内的记录机会，并发的请求中只有一个能够成功
+func (r *loginRecorder) claim(userID string, now time.Time) bool {
+	for {
+		last, loaded := r.lastRecords.LoadOrStore(userID, now)
+		if !loaded {
+			return true
+		}
+		if now.Sub(last.(time.Time)) < r.interval {
+			return false
+		}
+		if r.lastRecords.CompareAndSwap(userID, last, now) {
+			return true
+		}
+	}
+}
+
+// run 在后台依次将登录记录写入存储，并且每隔 interval 清理一次已经过期的记录
+func (r *loginRecorder) run() {
+	pruneInterval := r.interval
+	if pruneInterval <= 0 {
+		pruneInterval = defaultLoginRecordInterval
+	}
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case record := <-r.pending:
+			r.write(record)
+		case now := <-ticker.C:
+			r.prune(now)
+		case <-r.done:
+			// 退出前写入已经入队的记录
+			for {
+				select {
+				case record := <-r.pending:
+					r.write(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *loginRecorder) write(record loginRecord) {
+	defer r.inflight.Done()
+	if err := r.storage.UpdateLastLogin(record.userID); err != nil {
+		// 写入失败时允许下一次请求重新尝试，记录已经被更新的请求重新占用时不做处理
+		r.lastRecords.CompareAndDelete(record.userID, record.at)
+		log.Error("[Auth][Login] record user last login time", zap.String("user", record.userID),
+			zap.Error(err))
+	}
+}
+
+// prune 删除距离上一次记录已经超过 interval 的用户，这些用户的下一次请求本来就会重新记录
+func (r *loginRecorder) prune(now time.Time) {
+	r.lastRecords.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(time.Time)) >= r.interval {
+			r.lastRecords.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// close 停止后台协程，之后的登录不再记录
+func (r *loginRecorder) close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// wait 等待已经入队的登录记录全部写入完成
+func (r *loginRecorder) wait() {
+	r.inflight.Wait()
+}
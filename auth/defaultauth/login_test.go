@@ -0,0 +1,191 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package defaultauth_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/auth/defaultauth"
+	"github.com/polarismesh/polaris/common/model"
+	storemock "github.com/polarismesh/polaris/store/mock"
+)
+
+func Test_LoginRecorder(t *testing.T) {
+	t.Run("同一用户在间隔内只记录一次", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := storemock.NewMockStore(ctrl)
+		storage.EXPECT().UpdateLastLogin("u1").Times(2).Return(nil)
+		storage.EXPECT().UpdateLastLogin("u2").Times(1).Return(nil)
+
+		recorder := defaultauth.TestNewLoginRecorder(storage, 100*time.Millisecond)
+		defer recorder.Close()
+		recorder.Record("u1")
+		recorder.Record("u1")
+		recorder.Record("u2")
+		time.Sleep(150 * time.Millisecond)
+		recorder.Record("u1")
+		recorder.Wait()
+	})
+
+	t.Run("并发请求在间隔内只记录一次", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := storemock.NewMockStore(ctrl)
+		storage.EXPECT().UpdateLastLogin("u1").Times(1).Return(nil)
+
+		recorder := defaultauth.TestNewLoginRecorder(storage, time.Minute)
+		defer recorder.Close()
+		wg := sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recorder.Record("u1")
+			}()
+		}
+		wg.Wait()
+		recorder.Wait()
+	})
+
+	t.Run("写入存储不阻塞记录", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		release := make(chan struct{})
+		storage := storemock.NewMockStore(ctrl)
+		storage.EXPECT().UpdateLastLogin("u1").DoAndReturn(func(string) error {
+			<-release
+			return nil
+		})
+
+		recorder := defaultauth.TestNewLoginRecorder(storage, time.Minute)
+		defer recorder.Close()
+		done := make(chan struct{})
+		go func() {
+			recorder.Record("u1")
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("record is blocked by the store")
+		}
+		close(release)
+		recorder.Wait()
+	})
+
+	t.Run("写入失败后允许重试", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := storemock.NewMockStore(ctrl)
+		gomock.InOrder(
+			storage.EXPECT().UpdateLastLogin("u1").Return(errors.New("mock error")),
+			storage.EXPECT().UpdateLastLogin("u1").Return(nil),
+		)
+
+		recorder := defaultauth.TestNewLoginRecorder(storage, time.Minute)
+		defer recorder.Close()
+		recorder.Record("u1")
+		recorder.Wait()
+		recorder.Record("u1")
+		recorder.Wait()
+		recorder.Record("u1")
+		recorder.Wait()
+	})
+}
+
+func Test_LoginRecorderLifecycle(t *testing.T) {
+	t.Run("定期清理过期的登录记录", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := storemock.NewMockStore(ctrl)
+		storage.EXPECT().UpdateLastLogin(gomock.Any()).Times(2).Return(nil)
+
+		recorder := defaultauth.TestNewLoginRecorder(storage, 50*time.Millisecond)
+		defer recorder.Close()
+		recorder.Record("u1")
+		recorder.Record("u2")
+		recorder.Wait()
+		assert.Equal(t, 2, recorder.Tracked())
+		assert.Eventually(t, func() bool {
+			return recorder.Tracked() == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("关闭后不再记录", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := storemock.NewMockStore(ctrl)
+		storage.EXPECT().UpdateLastLogin("u1").Times(1).Return(nil)
+
+		recorder := defaultauth.TestNewLoginRecorder(storage, time.Minute)
+		recorder.Record("u1")
+		recorder.Close()
+		recorder.Close()
+		recorder.Wait()
+		recorder.Record("u2")
+		recorder.Wait()
+		assert.Equal(t, 1, recorder.Tracked())
+	})
+}
+
+func Test_LoadUserSecrets(t *testing.T) {
+	t.Run("缓存中包含密码以及token时不访问存储层", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := storemock.NewMockStore(ctrl)
+		user := &model.User{ID: "u1", Password: "p", Token: "t"}
+		ret, err := defaultauth.TestLoadUserSecrets(storage, user)
+		assert.NoError(t, err)
+		assert.Equal(t, user, ret)
+	})
+
+	t.Run("缓存未加载密码时从存储层获取", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := storemock.NewMockStore(ctrl)
+		saved := &model.User{ID: "u1", Password: "p", Token: "t"}
+		storage.EXPECT().GetUser("u1").Return(saved, nil)
+		ret, err := defaultauth.TestLoadUserSecrets(storage, &model.User{ID: "u1", Token: "t"})
+		assert.NoError(t, err)
+		assert.Equal(t, saved, ret)
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		ret, err := defaultauth.TestLoadUserSecrets(storemock.NewMockStore(ctrl), nil)
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+	})
+}
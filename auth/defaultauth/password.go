@@ -0,0 +1,144 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package defaultauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultPasswordMinLength 未配置密码规则时密码的最小长度
+	DefaultPasswordMinLength = 6
+	// DefaultPasswordMaxLength 未配置密码规则时密码的最大长度
+	DefaultPasswordMaxLength = 17
+	// maxPasswordLength 密码规则允许配置的最大长度，未使用 pepper 时 bcrypt 只处理密码的前 72 个字节
+	maxPasswordLength = 72
+)
+
+// PasswordPolicy 密码的复杂度规则，长度为 0 时使用默认值
+type PasswordPolicy struct {
+	// MinLength 密码的最小长度，为 0 时使用 DefaultPasswordMinLength
+	MinLength int `json:"minLength"`
+	// MaxLength 密码的最大长度，为 0 时使用 DefaultPasswordMaxLength
+	MaxLength int `json:"maxLength"`
+	// RequireDigit 密码至少包含一个数字
+	RequireDigit bool `json:"requireDigit"`
+	// RequireLetter 密码至少包含一个字母
+	RequireLetter bool `json:"requireLetter"`
+}
+
+// lengthRange 返回实际生效的长度范围
+func (p PasswordPolicy) lengthRange() (int, int) {
+	minLen, maxLen := p.MinLength, p.MaxLength
+	if minLen == 0 {
+		minLen = DefaultPasswordMinLength
+	}
+	if maxLen == 0 {
+		maxLen = DefaultPasswordMaxLength
+	}
+	return minLen, maxLen
+}
+
+// verify 检查规则本身是否合法
+func (p PasswordPolicy) verify() error {
+	minLen, maxLen := p.lengthRange()
+	if p.MinLength < 0 || p.MaxLength < 0 || maxLen > maxPasswordLength || minLen > maxLen {
+		return fmt.Errorf("password length range %d ~ %d is invalid, must be within 1 ~ %d",
+			minLen, maxLen, maxPasswordLength)
+	}
+	return nil
+}
+
+// Check 检查密码是否满足规则
+func (p PasswordPolicy) Check(password string) error {
+	minLen, maxLen := p.lengthRange()
+	if pLen := len(password); pLen < minLen || pLen > maxLen {
+		return fmt.Errorf("password len need %d ~ %d", minLen, maxLen)
+	}
+	if p.RequireDigit && strings.IndexFunc(password, unicode.IsDigit) < 0 {
+		return errors.New("password must contain at least one digit")
+	}
+	if p.RequireLetter && strings.IndexFunc(password, unicode.IsLetter) < 0 {
+		return errors.New("password must contain at least one letter")
+	}
+	return nil
+}
+
+// PasswordPolicyOf 返回主账户使用的密码规则，主账户单独配置了规则时使用该规则，否则使用全局的规则
+// owner 为空表示不属于任何已有的主账户，比如新建的主账户，此时使用全局的规则
+func (cfg *AuthConfig) PasswordPolicyOf(owner string) PasswordPolicy {
+	if policy, ok := cfg.OwnerPasswordPolicies[owner]; ok && owner != "" {
+		return policy
+	}
+	return cfg.PasswordPolicy
+}
+
+// pepperedPasswordPrefix 使用了 pepper 的密码摘要格式为 pepper:{version}:{bcrypt 摘要}
+// 没有该前缀的摘要为直接对明文密码计算的 bcrypt 摘要
+const pepperedPasswordPrefix = "pepper:"
+
+// HashPassword 计算密码的摘要，配置了 activePasswordPepper 时先使用对应版本的 pepper 对密码做 HMAC，
+// 并将 pepper 的版本记录在摘要中，便于轮换 pepper 后仍然可以校验旧的摘要
+func (cfg *AuthConfig) HashPassword(password string) (string, error) {
+	version := cfg.ActivePasswordPepper
+	if version == "" {
+		pwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(pwd), err
+	}
+	pepper, ok := cfg.PasswordPeppers[version]
+	if !ok {
+		return "", fmt.Errorf("password pepper(%s) not found", version)
+	}
+	pwd, err := bcrypt.GenerateFromPassword(pepperPassword(pepper, password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return pepperedPasswordPrefix + version + ":" + string(pwd), nil
+}
+
+// ComparePassword 校验密码与摘要是否匹配，按照摘要中记录的 pepper 版本选择 pepper，
+// 不匹配时返回 bcrypt.ErrMismatchedHashAndPassword
+func (cfg *AuthConfig) ComparePassword(hashed, password string) error {
+	if !strings.HasPrefix(hashed, pepperedPasswordPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	}
+	version, pwd, ok := strings.Cut(strings.TrimPrefix(hashed, pepperedPasswordPrefix), ":")
+	if !ok {
+		return errors.New("invalid peppered password hash")
+	}
+	pepper, ok := cfg.PasswordPeppers[version]
+	if !ok {
+		return fmt.Errorf("password pepper(%s) not found", version)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(pwd), pepperPassword(pepper, password))
+}
+
+// pepperPassword 使用 pepper 对密码做 HMAC-SHA256，base64 编码后的长度固定，不会触发 bcrypt 72 字节的截断
+func pepperPassword(pepper, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	_, _ = mac.Write([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
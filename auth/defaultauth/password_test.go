@@ -0,0 +1,77 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package defaultauth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/polarismesh/polaris/auth/defaultauth"
+)
+
+func Test_AuthConfig_PasswordPepper(t *testing.T) {
+	newConfig := func(active string, peppers map[string]string) *defaultauth.AuthConfig {
+		cfg := defaultauth.DefaultAuthConfig()
+		cfg.ActivePasswordPepper = active
+		cfg.PasswordPeppers = peppers
+		return cfg
+	}
+
+	t.Run("pepper正确时校验通过", func(t *testing.T) {
+		cfg := newConfig("v1", map[string]string{"v1": "pepper-1"})
+		hashed, err := cfg.HashPassword("polaris")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hashed, "pepper:v1:"))
+		assert.NoError(t, cfg.ComparePassword(hashed, "polaris"))
+		assert.ErrorIs(t, cfg.ComparePassword(hashed, "polaris1"), bcrypt.ErrMismatchedHashAndPassword)
+	})
+
+	t.Run("pepper错误时校验失败", func(t *testing.T) {
+		hashed, err := newConfig("v1", map[string]string{"v1": "pepper-1"}).HashPassword("polaris")
+		assert.NoError(t, err)
+		err = newConfig("v1", map[string]string{"v1": "pepper-2"}).ComparePassword(hashed, "polaris")
+		assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+		// 摘要中记录的版本不存在
+		assert.Error(t, newConfig("", nil).ComparePassword(hashed, "polaris"))
+	})
+
+	t.Run("轮换pepper后旧的摘要仍然可以校验", func(t *testing.T) {
+		hashed, err := newConfig("v1", map[string]string{"v1": "pepper-1"}).HashPassword("polaris")
+		assert.NoError(t, err)
+		plain, err := newConfig("", nil).HashPassword("polaris")
+		assert.NoError(t, err)
+
+		cfg := newConfig("v2", map[string]string{"v1": "pepper-1", "v2": "pepper-2"})
+		assert.NoError(t, cfg.ComparePassword(hashed, "polaris"))
+		assert.NoError(t, cfg.ComparePassword(plain, "polaris"))
+		rotated, err := cfg.HashPassword("polaris")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(rotated, "pepper:v2:"))
+		assert.NoError(t, cfg.ComparePassword(rotated, "polaris"))
+	})
+
+	t.Run("配置校验", func(t *testing.T) {
+		assert.NoError(t, newConfig("v1", map[string]string{"v1": "pepper-1"}).Verify())
+		assert.Error(t, newConfig("v2", map[string]string{"v1": "pepper-1"}).Verify())
+		assert.Error(t, newConfig("", map[string]string{"v:1": "pepper-1"}).Verify())
+		assert.Error(t, newConfig("", map[string]string{"v1": ""}).Verify())
+	})
+}
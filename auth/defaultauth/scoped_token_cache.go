@@ -0,0 +1,93 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package defaultauth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/store"
+)
+
+// scopedTokenCacheTTL 附加 token 查询结果的缓存时间
+// 本节点撤销的 token 立即失效，其他节点撤销的 token 最多在该时间之后失效
+const scopedTokenCacheTTL = 10 * time.Second
+
+// scopedTokenCache 缓存附加 token 的存储记录，避免每个携带附加 token 的请求都查询一次存储
+// 只缓存存在的 token，不存在的 token ID 每次都会查询存储，避免伪造的 token 占用缓存
+type scopedTokenCache struct {
+	ttl time.Duration
+	// token id -> *scopedTokenEntry
+	entries sync.Map
+	// lastSweep 上一次清理过期缓存的时间
+	lastSweep time.Time
+	sweepLock sync.Mutex
+}
+
+type scopedTokenEntry struct {
+	record   *model.UserToken
+	expireAt time.Time
+}
+
+func newScopedTokenCache(ttl time.Duration) *scopedTokenCache {
+	return &scopedTokenCache{ttl: ttl, lastSweep: time.Now()}
+}
+
+// get 获取附加 token 的记录，缓存不存在或者已经过期时从存储中查询
+func (c *scopedTokenCache) get(storage store.UserTokenStore, id string) (*model.UserToken, error) {
+	now := time.Now()
+	if v, ok := c.entries.Load(id); ok {
+		entry := v.(*scopedTokenEntry)
+		if now.Before(entry.expireAt) {
+			return entry.record, nil
+		}
+		c.entries.CompareAndDelete(id, entry)
+	}
+	c.sweep(now)
+
+	record, err := storage.GetUserTokenByID(id)
+	if err != nil || record == nil {
+		return record, err
+	}
+	c.entries.Store(id, &scopedTokenEntry{record: record, expireAt: now.Add(c.ttl)})
+	return record, nil
+}
+
+// invalidate 删除附加 token 的缓存，token 被撤销时调用
+func (c *scopedTokenCache) invalidate(id string) {
+	c.entries.Delete(id)
+}
+
+// sweep 每隔 ttl 清理一次已经过期的缓存，不再使用的 token 不会一直占用内存
+func (c *scopedTokenCache) sweep(now time.Time) {
+	c.sweepLock.Lock()
+	if now.Sub(c.lastSweep) < c.ttl {
+		c.sweepLock.Unlock()
+		return
+	}
+	c.lastSweep = now
+	c.sweepLock.Unlock()
+
+	c.entries.Range(func(key, value interface{}) bool {
+		if !now.Before(value.(*scopedTokenEntry).expireAt) {
+			c.entries.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
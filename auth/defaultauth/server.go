@@ -32,6 +32,7 @@ import (
 	cachetypes "github.com/polarismesh/polaris/cache/api"
 	api "github.com/polarismesh/polaris/common/api/v1"
 	"github.com/polarismesh/polaris/common/model"
+	commonstore "github.com/polarismesh/polaris/common/store"
 	"github.com/polarismesh/polaris/common/utils"
 	"github.com/polarismesh/polaris/plugin"
 	"github.com/polarismesh/polaris/store"
@@ -74,13 +75,17 @@ func (svr *Server) Login(req *apisecurity.LoginRequest) *apiservice.Response {
 	if ownerName == "" {
 		ownerName = username
 	}
-	user := svr.cacheMgn.User().GetUserByName(username, ownerName)
+	user, err := svr.loadUserSecrets(svr.cacheMgn.User().GetUserByName(username, ownerName))
+	if err != nil {
+		log.Error("[Auth][User] login load user from store", zap.String("name", username), zap.Error(err))
+		return api.NewAuthResponse(commonstore.StoreCode2APICode(err))
+	}
 	if user == nil {
 		return api.NewAuthResponse(apimodel.Code_NotFoundUser)
 	}
 
 	// TODO AES 解密操作，在进行密码比对计算
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.GetPassword().GetValue()))
+	err = AuthOption.ComparePassword(user.Password, req.GetPassword().GetValue())
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 			return api.NewAuthResponseWithMsg(
@@ -89,13 +94,30 @@ func (svr *Server) Login(req *apisecurity.LoginRequest) *apiservice.Response {
 		return api.NewAuthResponseWithMsg(apimodel.Code_ExecuteException, model.ErrorWrongUsernameOrPassword.Error())
 	}
 
-	return api.NewLoginResponse(apimodel.Code_ExecuteSuccess, &apisecurity.LoginResponse{
+	loginRsp := &apisecurity.LoginResponse{
 		UserId:  utils.NewStringValue(user.ID),
 		OwnerId: utils.NewStringValue(user.Owner),
 		Token:   utils.NewStringValue(user.Token),
 		Name:    utils.NewStringValue(user.Name),
 		Role:    utils.NewStringValue(model.UserRoleNames[user.Type]),
-	})
+	}
+
+	// 密码已过期时仍然返回登录信息，但是该用户只能调用修改密码的接口
+	if isPasswordExpired(user, AuthOption.PasswordMaxAge(), time.Now()) {
+		rsp := api.NewAuthResponseWithMsg(apimodel.Code_NotAllowedAccess, model.ErrorPasswordExpired.Error())
+		rsp.LoginResponse = loginRsp
+		return rsp
+	}
+
+	return api.NewLoginResponse(apimodel.Code_ExecuteSuccess, loginRsp)
+}
+
+// loadUserSecrets 存储层配置了用户缓存不加载密码或者 token 时，从存储层获取完整的用户信息
+func (svr *Server) loadUserSecrets(user *model.User) (*model.User, error) {
+	if user == nil || (user.Password != "" && user.Token != "") {
+		return user, nil
+	}
+	return svr.storage.GetUser(user.ID)
 }
 
 // RecordHistory Server对外提供history插件的简单封装
@@ -188,11 +210,7 @@ func (svr *Server) handleUserStrategy(userIds []string, afterCtx *model.AcquireC
 			return errors.New("not found target user")
 		}
 
-		ownerId := user.Owner
-		if ownerId == "" {
-			ownerId = user.ID
-		}
-		if err := svr.handlerModifyDefaultStrategy(userId, ownerId, model.PrincipalUser,
+		if err := svr.handlerModifyDefaultStrategy(userId, user.OwnerID(), model.PrincipalUser,
 			afterCtx, isRemove); err != nil {
 			return err
 		}
@@ -20,6 +20,7 @@ package defaultauth
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -31,6 +32,7 @@ import (
 	apiservice "github.com/polarismesh/specification/source/go/api/v1/service_manage"
 	"go.uber.org/zap"
 
+	"github.com/polarismesh/polaris/auth"
 	api "github.com/polarismesh/polaris/common/api/v1"
 	"github.com/polarismesh/polaris/common/model"
 	authcommon "github.com/polarismesh/polaris/common/model/auth"
@@ -422,6 +424,61 @@ func (svr *Server) GetPrincipalResources(ctx context.Context, query map[string]s
 	return api.NewStrategyResourcesResponse(apimodel.Code_ExecuteSuccess, tmp.Resources)
 }
 
+// GetUserPermissionSummary 汇总用户自身（包括默认策略）以及所在用户组关联的鉴权策略，得到用户的有效权限
+// 只有用户自身、用户所属的主账户以及超级账户可以查看
+func (svr *Server) GetUserPermissionSummary(ctx context.Context, userId string) (*auth.PermissionSummary,
+	apimodel.Code) {
+	if userId == "" {
+		return nil, apimodel.Code_EmptyQueryParameter
+	}
+	user := svr.cacheMgn.User().GetUserByID(userId)
+	if user == nil {
+		return nil, apimodel.Code_NotFoundUser
+	}
+	if !checkUserViewPermission(ctx, user) {
+		log.Error("[Auth][Strategy] get user permission summary denied", utils.ZapRequestID(utils.ParseRequestID(ctx)),
+			zap.String("user", userId), zap.String("operator", utils.ParseUserID(ctx)))
+		return nil, apimodel.Code_NotAllowedAccess
+	}
+
+	strategies := svr.cacheMgn.AuthStrategy().GetStrategyDetailsByUID(userId)
+	for _, groupId := range svr.cacheMgn.User().GetUserLinkGroupIds(userId) {
+		strategies = append(strategies, svr.cacheMgn.AuthStrategy().GetStrategyDetailsByGroupID(groupId)...)
+	}
+	return mergePermissionSummary(userId, strategies), apimodel.Code_ExecuteSuccess
+}
+
+// mergePermissionSummary 合并用户关联的鉴权策略，同一条策略同时通过用户以及用户组关联时只计算一次
+func mergePermissionSummary(userId string, strategies []*model.StrategyDetail) *auth.PermissionSummary {
+	var (
+		summary = &auth.PermissionSummary{UserID: userId, Action: apisecurity.AuthAction_ONLY_READ,
+			StrategyIDs: make([]string, 0, len(strategies))}
+		seen           = make(map[string]struct{}, len(strategies))
+		readResources  = make([]model.StrategyResource, 0, len(strategies))
+		writeResources = make([]model.StrategyResource, 0, len(strategies))
+	)
+	for _, strategy := range strategies {
+		if strategy == nil || !strategy.Valid {
+			continue
+		}
+		if _, ok := seen[strategy.ID]; ok {
+			continue
+		}
+		seen[strategy.ID] = struct{}{}
+		summary.StrategyIDs = append(summary.StrategyIDs, strategy.ID)
+
+		readResources = append(readResources, strategy.Resources...)
+		if strategy.Action == apisecurity.AuthAction_READ_WRITE.String() {
+			summary.Action = apisecurity.AuthAction_READ_WRITE
+			writeResources = append(writeResources, strategy.Resources...)
+		}
+	}
+	sort.Strings(summary.StrategyIDs)
+	summary.ReadResources = resourceDeduplication(readResources)
+	summary.WriteResources = resourceDeduplication(writeResources)
+	return summary
+}
+
 // enhancedAuthStrategy2Api
 func enhancedAuthStrategy2Api(s []*model.StrategyDetail, fn StrategyDetail2Api) []*apisecurity.AuthStrategy {
 	out := make([]*apisecurity.AuthStrategy, 0, len(s))
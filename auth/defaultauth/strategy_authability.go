@@ -143,6 +143,17 @@ func (svr *StrategyAuthAbility) GetPrincipalResources(ctx context.Context, query
 	return svr.target.GetPrincipalResources(ctx, query)
 }
 
+// GetUserPermissionSummary get the effective permission summary of the user.
+func (svr *StrategyAuthAbility) GetUserPermissionSummary(ctx context.Context,
+	userId string) (*auth.PermissionSummary, apimodel.Code) {
+	ctx, rsp := verifyAuth(ctx, ReadOp, NotOwner, svr.authMgn)
+	if rsp != nil {
+		return nil, apimodel.Code(rsp.GetCode().GetValue())
+	}
+
+	return svr.target.GetUserPermissionSummary(ctx, userId)
+}
+
 // GetAuthChecker 获取鉴权管理器
 func (svr *StrategyAuthAbility) GetAuthChecker() auth.AuthChecker {
 	return svr.authMgn
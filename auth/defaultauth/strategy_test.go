@@ -19,12 +19,14 @@ package defaultauth_test
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	apimodel "github.com/polarismesh/specification/source/go/api/v1/model"
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/protobuf/types/known/wrapperspb"
@@ -86,6 +88,7 @@ func newStrategyTest(t *testing.T) *StrategyTest {
 	storage.EXPECT().GetServicesCount().AnyTimes().Return(uint32(1), nil)
 	storage.EXPECT().GetUnixSecond(gomock.Any()).AnyTimes().Return(time.Now().Unix(), nil)
 	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(users, nil)
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(groups, nil)
 	storage.EXPECT().GetStrategyDetailsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(allStrategies, nil)
 	storage.EXPECT().GetMoreNamespaces(gomock.Any()).AnyTimes().Return(namespaces, nil)
@@ -196,6 +199,89 @@ func Test_GetPrincipalResources(t *testing.T) {
 	assert.Equal(t, 2, len(resources.Services), "need query 2 service resources")
 }
 
+func Test_GetUserPermissionSummary(t *testing.T) {
+	strategyTest := newStrategyTest(t)
+	defer strategyTest.Clean()
+
+	t.Run("查询自身的有效权限", func(t *testing.T) {
+		valCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, strategyTest.users[1].Token)
+		summary, code := strategyTest.svr.GetUserPermissionSummary(valCtx, strategyTest.users[1].ID)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+		assert.Equal(t, strategyTest.users[1].ID, summary.UserID)
+		assert.Equal(t, apisecurity.AuthAction_READ_WRITE, summary.Action)
+		assert.NotEmpty(t, summary.StrategyIDs)
+		assert.NotEmpty(t, summary.WriteResources)
+	})
+
+	t.Run("子账户不能查看其他子账户", func(t *testing.T) {
+		valCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, strategyTest.users[2].Token)
+		_, code := strategyTest.svr.GetUserPermissionSummary(valCtx, strategyTest.users[1].ID)
+		assert.Equal(t, apimodel.Code_NotAllowedAccess, code)
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		valCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, strategyTest.users[0].Token)
+		_, code := strategyTest.svr.GetUserPermissionSummary(valCtx, utils.NewUUID())
+		assert.Equal(t, apimodel.Code_NotFoundUser, code)
+	})
+}
+
+func Test_mergePermissionSummary(t *testing.T) {
+	resourceKeys := func(resources []model.StrategyResource) []string {
+		keys := make([]string, 0, len(resources))
+		for _, res := range resources {
+			keys = append(keys, fmt.Sprintf("%d/%s", res.ResType, res.ResID))
+		}
+		return keys
+	}
+	nsType, svcType := int32(apisecurity.ResourceType_Namespaces), int32(apisecurity.ResourceType_Services)
+
+	defaultStrategy := &model.StrategyDetail{
+		ID:      "s-default",
+		Action:  apisecurity.AuthAction_READ_WRITE.String(),
+		Default: true,
+		Valid:   true,
+		Resources: []model.StrategyResource{
+			{StrategyID: "s-default", ResType: nsType, ResID: "ns-1"},
+			{StrategyID: "s-default", ResType: svcType, ResID: "svc-1"},
+		},
+	}
+	groupStrategy := &model.StrategyDetail{
+		ID:     "s-group",
+		Action: apisecurity.AuthAction_ONLY_READ.String(),
+		Valid:  true,
+		Resources: []model.StrategyResource{
+			{StrategyID: "s-group", ResType: nsType, ResID: "ns-1"},
+			{StrategyID: "s-group", ResType: svcType, ResID: "svc-2"},
+		},
+	}
+
+	t.Run("默认读写策略与用户组只读策略合并", func(t *testing.T) {
+		// 同一条策略通过用户组重复关联时只计算一次
+		summary := defaultauth.TestMergePermissionSummary("u1",
+			[]*model.StrategyDetail{groupStrategy, defaultStrategy, groupStrategy})
+		assert.Equal(t, "u1", summary.UserID)
+		assert.Equal(t, apisecurity.AuthAction_READ_WRITE, summary.Action)
+		assert.Equal(t, []string{"s-default", "s-group"}, summary.StrategyIDs)
+		assert.ElementsMatch(t, []string{"0/ns-1", "1/svc-1", "1/svc-2"}, resourceKeys(summary.ReadResources))
+		assert.ElementsMatch(t, []string{"0/ns-1", "1/svc-1"}, resourceKeys(summary.WriteResources))
+	})
+
+	t.Run("只有只读策略", func(t *testing.T) {
+		summary := defaultauth.TestMergePermissionSummary("u1", []*model.StrategyDetail{groupStrategy})
+		assert.Equal(t, apisecurity.AuthAction_ONLY_READ, summary.Action)
+		assert.Equal(t, []string{"s-group"}, summary.StrategyIDs)
+		assert.Len(t, summary.ReadResources, 2)
+		assert.Empty(t, summary.WriteResources)
+	})
+
+	t.Run("没有任何策略", func(t *testing.T) {
+		summary := defaultauth.TestMergePermissionSummary("u1", nil)
+		assert.Empty(t, summary.StrategyIDs)
+		assert.Empty(t, summary.ReadResources)
+	})
+}
+
 func Test_CreateStrategy(t *testing.T) {
 
 	strategyTest := newStrategyTest(t)
@@ -19,12 +19,17 @@ package defaultauth
 
 import (
 	"context"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/wrappers"
+
+	"github.com/polarismesh/polaris/auth"
+	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/store"
 )
 
 func TestCheckPassword(password *wrappers.StringValue) error {
-	return checkPassword(password)
+	return checkPassword(password, "")
 }
 
 func TestCheckName(password *wrappers.StringValue) error {
@@ -42,3 +47,38 @@ func TestDecryptMessage(key []byte, message string) (string, error) {
 func TestParseStrategySearchArgs(ctx context.Context, searchFilters map[string]string) map[string]string {
 	return parseStrategySearchArgs(ctx, searchFilters)
 }
+
+// TestLoginRecorder 导出 loginRecorder 的方法，仅用于单元测试
+type TestLoginRecorder struct {
+	Record func(userID string)
+	Wait   func()
+	Close  func()
+	// Tracked 当前记录了上一次登录时间的用户个数
+	Tracked func() int
+}
+
+func TestNewLoginRecorder(storage store.UserStore, interval time.Duration) *TestLoginRecorder {
+	r := newLoginRecorder(storage, interval)
+	return &TestLoginRecorder{
+		Record: r.Record,
+		Wait:   r.wait,
+		Close:  r.close,
+		Tracked: func() int {
+			count := 0
+			r.lastRecords.Range(func(_, _ interface{}) bool {
+				count++
+				return true
+			})
+			return count
+		},
+	}
+}
+
+func TestLoadUserSecrets(storage store.Store, user *model.User) (*model.User, error) {
+	svr := &Server{storage: storage}
+	return svr.loadUserSecrets(user)
+}
+
+func TestMergePermissionSummary(userId string, strategies []*model.StrategyDetail) *auth.PermissionSummary {
+	return mergePermissionSummary(userId, strategies)
+}
@@ -25,8 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-
-	"github.com/google/uuid"
+	"strings"
 
 	"github.com/polarismesh/polaris/common/model"
 )
@@ -52,6 +51,12 @@ type OperatorInfo struct {
 	// Disable 标识用户 token 是否被禁用
 	Disable bool
 
+	// TokenID 如果当前是用户创建的受限 token，该值为 token 的 ID
+	TokenID string
+
+	// Scope 受限 token 的权限范围，为空表示不受限
+	Scope model.UserTokenScope
+
 	// 是否属于匿名操作者
 	Anonymous bool
 }
@@ -76,12 +81,17 @@ func IsSubAccount(t OperatorInfo) bool {
 }
 
 func (t *OperatorInfo) String() string {
-	return fmt.Sprintf("operator-id=%s, owner=%s, role=%d, is-user=%v, disable=%v",
-		t.OperatorID, t.OwnerID, t.Role, t.IsUserToken, t.Disable)
+	return fmt.Sprintf("operator-id=%s, owner=%s, role=%d, is-user=%v, disable=%v, token-id=%s, scope=%s",
+		t.OperatorID, t.OwnerID, t.Role, t.IsUserToken, t.Disable, t.TokenID, t.Scope)
+}
+
+// IsReadOnly 当前 token 是否只允许执行读操作
+func (t *OperatorInfo) IsReadOnly() bool {
+	return t.Scope == model.UserTokenReadOnly
 }
 
 const (
-	// TokenPattern token 的格式 随机字符串::[uid/xxx | groupid/xxx]
+	// TokenPattern token 的格式 随机字符串::[uid/xxx | groupid/xxx | utid/xxx]
 	TokenPattern string = "%s::%s"
 	// TokenSplit token 的分隔符
 	TokenSplit string = "::"
@@ -103,14 +113,32 @@ func createToken(uid, gid string) (string, error) {
 		return "", errors.New("uid and groupid not be empty at the same time")
 	}
 
-	var val string
 	if uid == "" {
-		val = fmt.Sprintf("%s/%s", model.TokenForUserGroup, gid)
-	} else {
-		val = fmt.Sprintf("%s/%s", model.TokenForUser, uid)
+		return generateToken(model.TokenForUserGroup, gid)
+	}
+	return generateToken(model.TokenForUser, uid)
+}
+
+// createScopedToken Create a scoped token issued by a user, bound to the user token record id
+func createScopedToken(tokenId string) (string, error) {
+	if tokenId == "" {
+		return "", errors.New("token id not be empty")
+	}
+	return generateToken(model.TokenForUserToken, tokenId)
+}
+
+// generateToken 生成 随机字符串::{kind}/{id} 格式的 token 并进行加密
+func generateToken(kind, id string) (string, error) {
+	val := fmt.Sprintf("%s/%s", kind, id)
+	random, err := currentTokenGenerator().Generate()
+	if err != nil {
+		return "", err
+	}
+	if random == "" || strings.Contains(random, TokenSplit) {
+		return "", errors.New("generated token must not be empty or contain " + TokenSplit)
 	}
 
-	token := fmt.Sprintf(TokenPattern, uuid.NewString()[8:16], val)
+	token := fmt.Sprintf(TokenPattern, random, val)
 	return encryptMessage([]byte(AuthOption.Salt), token)
 }
 
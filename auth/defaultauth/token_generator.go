@@ -0,0 +1,82 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package defaultauth
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+const (
+	// DefaultTokenLength 未配置 tokenLength 时 token 随机部分的长度
+	DefaultTokenLength = 16
+	// minTokenLength token 随机部分的最小长度
+	minTokenLength = 8
+	// maxTokenLength token 随机部分的最大长度，加密后的 token 需要能够保存在存储的 token 字段中
+	maxTokenLength = 48
+)
+
+// tokenCharset URL 安全的字符集，共 64 个字符，随机字节取低 6 位即可均匀的映射到字符集上
+const tokenCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// TokenGenerator 生成用户、用户组 token 中的随机部分，token 的强度由其决定
+type TokenGenerator interface {
+	// Generate 生成一个随机字符串，结果中不能包含 TokenSplit
+	Generate() (string, error)
+}
+
+// customTokenGenerator 通过 SetTokenGenerator 设置，为 nil 时使用 AuthOption.TokenLength 长度的随机字符串
+var customTokenGenerator TokenGenerator
+
+// SetTokenGenerator 替换生成 token 随机部分的 TokenGenerator，需要在服务启动阶段设置，传入 nil 时恢复默认的实现
+func SetTokenGenerator(generator TokenGenerator) {
+	customTokenGenerator = generator
+}
+
+// NewRandomTokenGenerator 创建使用 crypto/rand 生成 length 个 URL 安全字符的 TokenGenerator，
+// length 小于等于 0 时使用 DefaultTokenLength
+func NewRandomTokenGenerator(length int) TokenGenerator {
+	if length <= 0 {
+		length = DefaultTokenLength
+	}
+	return &randomTokenGenerator{length: length}
+}
+
+type randomTokenGenerator struct {
+	length int
+}
+
+// Generate 生成随机字符串
+func (g *randomTokenGenerator) Generate() (string, error) {
+	buf := make([]byte, g.length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate token: %v", err)
+	}
+	for i := range buf {
+		buf[i] = tokenCharset[buf[i]&0x3f]
+	}
+	return string(buf), nil
+}
+
+// currentTokenGenerator 当前使用的 TokenGenerator
+func currentTokenGenerator() TokenGenerator {
+	if customTokenGenerator != nil {
+		return customTokenGenerator
+	}
+	return NewRandomTokenGenerator(AuthOption.TokenLength)
+}
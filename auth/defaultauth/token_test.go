@@ -19,9 +19,11 @@ package defaultauth_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/polarismesh/polaris/auth/defaultauth"
@@ -91,3 +93,77 @@ func TestDecodeToken(t *testing.T) {
 
 	t.Log(v)
 }
+
+func Test_RandomTokenGenerator(t *testing.T) {
+	for _, length := range []int{0, 8, 32} {
+		generator := defaultauth.NewRandomTokenGenerator(length)
+		expectLen := length
+		if expectLen == 0 {
+			expectLen = defaultauth.DefaultTokenLength
+		}
+
+		seen := make(map[string]struct{}, 10000)
+		for i := 0; i < 10000; i++ {
+			token, err := generator.Generate()
+			assert.NoError(t, err)
+			assert.Equal(t, expectLen, len(token))
+			assert.Equal(t, "", strings.Trim(token, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"),
+				"token must be url safe: %s", token)
+			_, ok := seen[token]
+			assert.False(t, ok, "duplicated token: %s", token)
+			seen[token] = struct{}{}
+		}
+	}
+}
+
+type fixedTokenGenerator string
+
+func (g fixedTokenGenerator) Generate() (string, error) {
+	return string(g), nil
+}
+
+func Test_CreateTokenWithGenerator(t *testing.T) {
+	defaultauth.AuthOption = defaultauth.DefaultAuthConfig()
+	defaultauth.AuthOption.Salt = "polarismesh@2021"
+
+	t.Run("默认使用配置长度的随机串", func(t *testing.T) {
+		defaultauth.AuthOption.TokenLength = 24
+		defer func() {
+			defaultauth.AuthOption.TokenLength = 0
+		}()
+		token, err := defaultauth.TestCreateToken("u1", "")
+		assert.NoError(t, err)
+		val, err := defaultauth.TestDecryptMessage([]byte(defaultauth.AuthOption.Salt), token)
+		assert.NoError(t, err)
+		random, detail, ok := strings.Cut(val, defaultauth.TokenSplit)
+		assert.True(t, ok)
+		assert.Equal(t, 24, len(random))
+		assert.Equal(t, "uid/u1", detail)
+	})
+
+	t.Run("使用自定义的生成器", func(t *testing.T) {
+		defaultauth.SetTokenGenerator(fixedTokenGenerator("custom-random"))
+		defer defaultauth.SetTokenGenerator(nil)
+		token, err := defaultauth.TestCreateToken("", "g1")
+		assert.NoError(t, err)
+		val, err := defaultauth.TestDecryptMessage([]byte(defaultauth.AuthOption.Salt), token)
+		assert.NoError(t, err)
+		assert.Equal(t, "custom-random::groupid/g1", val)
+	})
+
+	t.Run("生成的随机串包含分隔符", func(t *testing.T) {
+		defaultauth.SetTokenGenerator(fixedTokenGenerator("a::b"))
+		defer defaultauth.SetTokenGenerator(nil)
+		_, err := defaultauth.TestCreateToken("u1", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("配置校验", func(t *testing.T) {
+		cfg := defaultauth.DefaultAuthConfig()
+		cfg.Salt = "polarismesh@2021"
+		cfg.TokenLength = 4
+		assert.Error(t, cfg.Verify())
+		cfg.TokenLength = 32
+		assert.NoError(t, cfg.Verify())
+	})
+}
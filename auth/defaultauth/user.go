@@ -29,14 +29,15 @@ import (
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
 	apiservice "github.com/polarismesh/specification/source/go/api/v1/service_manage"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 
+	"github.com/polarismesh/polaris/auth"
 	api "github.com/polarismesh/polaris/common/api/v1"
 	"github.com/polarismesh/polaris/common/model"
 	authcommon "github.com/polarismesh/polaris/common/model/auth"
 	commonstore "github.com/polarismesh/polaris/common/store"
 	commontime "github.com/polarismesh/polaris/common/time"
 	"github.com/polarismesh/polaris/common/utils"
+	"github.com/polarismesh/polaris/store"
 )
 
 type (
@@ -48,14 +49,52 @@ var (
 
 	// UserFilterAttributes 查询用户所能允许的参数查询列表
 	UserFilterAttributes = map[string]bool{
-		"id":         true,
-		"name":       true,
-		"owner":      true,
-		"source":     true,
-		"offset":     true,
-		"group_id":   true,
-		"limit":      true,
+		"id":       true,
+		"name":     true,
+		"owner":    true,
+		"source":   true,
+		"offset":   true,
+		"group_id": true,
+		"limit":    true,
+		// 按照用户组名称查询成员，非超级管理员只匹配本主账户下的用户组
+		"group_name": true,
 		"hide_admin": true,
+		// 不返回这些用户，多个用户 ID 以逗号分隔，如添加用户组成员时排除已经在用户组中的用户
+		"exclude_ids": true,
+		// 查询名称或者备注中包含该关键字的用户
+		"q": true,
+		// 查询在指定时间（unix 秒）之后没有登录过的用户
+		"last_login_before": true,
+		// 查询最近指定天数内没有登录过的用户
+		"inactive_since": true,
+		// 按照登录时间过滤时从未登录过的用户是否视为不活跃，默认为 true
+		"never_login_inactive": true,
+		// 查询在指定时间（unix 秒）之前或者最近指定天数内没有更换过 token 的用户，更换时间未知的用户同样返回
+		"token_rotated_before":   true,
+		"token_not_rotated_days": true,
+		// 查询是否加入了任意用户组的用户，取值为 true 或者 false
+		"has_group":    true,
+		"token_enable": true,
+		// 查询在指定时间（unix 秒）之后创建的用户
+		"created_after": true,
+		// 查询由指定操作者（用户 ID）创建的用户
+		"created_by": true,
+		// 查询最后一次由指定操作者（用户 ID）修改的用户
+		"modified_by": true,
+		// 查询在指定时间范围（unix 秒）内最后一次修改的用户
+		"modified_after":  true,
+		"modified_before": true,
+		// 同时查询已经删除的用户，仅超级管理员可用
+		"include_deleted": true,
+		// 查询在指定时间范围（unix 秒）内删除的用户，仅在 include_deleted 为 true 时生效
+		"deleted_after":  true,
+		"deleted_before": true,
+		"order_field":    true,
+		"order_type":     true,
+		// 按名称排序时使用的排序规则，如 utf8mb4_general_ci
+		"order_collation": true,
+		// 查询范围，取值为 owner（默认）或者 all，all 仅超级管理员可用
+		"scope": true,
 	}
 )
 
@@ -73,19 +112,154 @@ func (svr *Server) CreateUsers(ctx context.Context, req []*apisecurity.User) *ap
 
 // CreateUser 创建用户
 func (svr *Server) CreateUser(ctx context.Context, req *apisecurity.User) *apiservice.Response {
+	return svr.createUserWithCheck(ctx, req, auth.ImportOption{})
+}
+
+// AddUserWithHashedPassword 使用已经计算过摘要的密码创建用户，用于从其他系统迁移用户，密码摘要原样保存不再重复计算
+// algorithm 为密码摘要使用的算法，登录时需要能够校验该摘要，因此目前仅支持 bcrypt
+func (svr *Server) AddUserWithHashedPassword(ctx context.Context, req *apisecurity.User,
+	algorithm string) *apiservice.Response {
+	if algorithm != PasswordHashBcrypt {
+		return api.NewUserResponseWithMsg(apimodel.Code_InvalidParameter,
+			"unsupported password hash algorithm: "+algorithm, req)
+	}
+	return svr.createUserWithCheck(ctx, req, auth.ImportOption{HashAlgorithm: algorithm})
+}
+
+// ImportUsers 从其他系统批量导入用户，默认与创建用户一致校验密码的复杂度
+// 导入历史系统中不满足规则的密码时可以跳过校验，但是必须同时要求用户先修改密码
+func (svr *Server) ImportUsers(ctx context.Context, users []*apisecurity.User,
+	opt auth.ImportOption) *apiservice.BatchWriteResponse {
+	if opt.HashAlgorithm != "" && opt.HashAlgorithm != PasswordHashBcrypt {
+		return api.NewAuthBatchWriteResponseWithMsg(apimodel.Code_InvalidParameter,
+			"unsupported password hash algorithm: "+opt.HashAlgorithm)
+	}
+	if opt.SkipPasswordCheck && !opt.MustChangePassword {
+		return api.NewAuthBatchWriteResponseWithMsg(apimodel.Code_InvalidParameter,
+			"skip password check requires must_change_password")
+	}
+
+	batchResp := api.NewAuthBatchWriteResponse(apimodel.Code_ExecuteSuccess)
+	for i := range users {
+		api.Collect(batchResp, svr.createUserWithCheck(ctx, users[i], opt))
+	}
+	if opt.SkipPasswordCheck {
+		log.Info("[Auth][User] import users without password check", utils.RequestID(ctx),
+			zap.Int("count", len(users)))
+	}
+	return batchResp
+}
+
+// ValidateUsers 按照创建用户的规则逐个校验用户的名称、密码、owner 以及是否与已有的用户或者同一批次中的其他用户重名，
+// 只读取不写入任何数据。与创建用户一致，子账户只能属于当前操作者的 owner，未指定 owner 时使用当前操作者的 owner
+func (svr *Server) ValidateUsers(ctx context.Context, users []*model.User) []auth.ValidationResult {
+	var (
+		requestID = utils.ParseRequestID(ctx)
+		ctxOwner  = utils.ParseOwnerID(ctx)
+		isSub     = convertCreateUserRole(authcommon.ParseUserRole(ctx)) == model.SubAccountUserRole
+		owners    = make(map[string]*model.User)
+		seen      = make(map[string]int, len(users))
+		results   = make([]auth.ValidationResult, 0, len(users))
+	)
+	for i, user := range users {
+		ret := auth.ValidationResult{Index: i, Code: apimodel.Code_ExecuteSuccess}
+		if user == nil {
+			ret.Code = apimodel.Code_EmptyRequest
+			results = append(results, ret)
+			continue
+		}
+		ret.Name = user.Name
+		ret.Code, ret.Info = svr.validateUser(user, ctxOwner, isSub, owners, seen, i)
+		if ret.Code != apimodel.Code_ExecuteSuccess {
+			log.Info("[Auth][User] validate user failed", utils.ZapRequestID(requestID),
+				zap.Int("index", i), zap.String("name", user.Name), zap.String("code", ret.Code.String()),
+				zap.String("info", ret.Info))
+		}
+		results = append(results, ret)
+	}
+	return results
+}
+
+// validateUser 校验单个用户，owners 缓存已经查询过的主账户，seen 记录同一批次中已经出现过的 owner + 名称及其下标
+func (svr *Server) validateUser(user *model.User, ctxOwner string, isSub bool, owners map[string]*model.User,
+	seen map[string]int, index int) (apimodel.Code, string) {
+	ownerID := user.Owner
+	if ownerID == "" {
+		ownerID = ctxOwner
+	}
+	if err := checkName(utils.NewStringValue(user.Name)); err != nil {
+		return apimodel.Code_InvalidUserName, err.Error()
+	}
+	// 新建的主账户不属于任何已有的主账户，使用全局的密码规则
+	policyOwner := ownerID
+	if !isSub {
+		policyOwner = ""
+	}
+	if err := checkPassword(utils.NewStringValue(user.Password), policyOwner); err != nil {
+		return apimodel.Code_InvalidUserPassword, err.Error()
+	}
+	if err := checkOwner(utils.NewStringValue(ownerID)); err != nil {
+		return apimodel.Code_InvalidUserOwners, err.Error()
+	}
+	if source, ok := AuthOption.NormalizeUserSource(user.Source); !ok {
+		return apimodel.Code_InvalidParameter, "unknown user source: " + source
+	}
+
+	// 与创建用户一致，非子账户的 owner 为空，子账户的 owner 为当前操作者的 owner
+	if !isSub {
+		ownerID = ""
+	} else if ownerID != ctxOwner {
+		return apimodel.Code_InvalidUserOwners, "owner of sub account must be the operator's owner"
+	}
+	if ownerID != "" {
+		owner, ok := owners[ownerID]
+		if !ok {
+			var err error
+			if owner, err = svr.storage.GetUser(ownerID); err != nil {
+				return commonstore.StoreCode2APICode(err), err.Error()
+			}
+			owners[ownerID] = owner
+		}
+		if owner == nil {
+			return apimodel.Code_NotFoundOwnerUser, "owner not found: " + ownerID
+		}
+		if owner.Name == user.Name {
+			return apimodel.Code_UserExisted, "user name is equal to the owner"
+		}
+	}
+
+	key := ownerID + "/" + user.Name
+	if first, ok := seen[key]; ok {
+		return apimodel.Code_UserExisted, fmt.Sprintf("duplicate user name with index %d", first)
+	}
+	seen[key] = index
+
+	exist, err := svr.storage.GetUserByName(user.Name, ownerID)
+	if err != nil {
+		return commonstore.StoreCode2APICode(err), err.Error()
+	}
+	if exist != nil {
+		return apimodel.Code_UserExisted, "user already exists"
+	}
+	return apimodel.Code_ExecuteSuccess, ""
+}
+
+// createUserWithCheck 检查请求后创建用户，opt.HashAlgorithm 不为空时表示请求中的密码已经是对应算法的摘要
+func (svr *Server) createUserWithCheck(ctx context.Context, req *apisecurity.User,
+	opt auth.ImportOption) *apiservice.Response {
 	requestID := utils.ParseRequestID(ctx)
 	ownerID := utils.ParseOwnerID(ctx)
 	req.Owner = utils.NewStringValue(ownerID)
 
-	if checkErrResp := checkCreateUser(req); checkErrResp != nil {
-		return checkErrResp
-	}
-
 	// 如果创建的目标账户类型是非子账户，则 ownerId 需要设置为 “”
 	if convertCreateUserRole(authcommon.ParseUserRole(ctx)) != model.SubAccountUserRole {
 		ownerID = ""
 	}
 
+	if checkErrResp := checkCreateUser(req, opt, ownerID); checkErrResp != nil {
+		return checkErrResp
+	}
+
 	if ownerID != "" {
 		owner, err := svr.storage.GetUser(ownerID)
 		if err != nil {
@@ -112,18 +286,21 @@ func (svr *Server) CreateUser(ctx context.Context, req *apisecurity.User) *apise
 		return api.NewUserResponse(apimodel.Code_UserExisted, req)
 	}
 
-	return svr.createUser(ctx, req)
+	return svr.createUser(ctx, req, opt)
 }
 
-func (svr *Server) createUser(ctx context.Context, req *apisecurity.User) *apiservice.Response {
+func (svr *Server) createUser(ctx context.Context, req *apisecurity.User, opt auth.ImportOption) *apiservice.Response {
 	requestID := utils.ParseRequestID(ctx)
 
-	data, err := createUserModel(req, authcommon.ParseUserRole(ctx))
+	data, err := createUserModel(req, authcommon.ParseUserRole(ctx), opt.HashAlgorithm)
 
 	if err != nil {
 		log.Error("[Auth][User] create user model", utils.ZapRequestID(requestID), zap.Error(err))
 		return api.NewAuthResponse(apimodel.Code_ExecuteException)
 	}
+	data.MustChangePassword = opt.MustChangePassword
+	// 记录实际创建该用户的操作者，管理员代替主账户创建子账户时与 owner 不同
+	data.CreatedBy = utils.ParseUserID(ctx)
 
 	if err := svr.storage.AddUser(data); err != nil {
 		log.Error("[Auth][User] add user into store", utils.ZapRequestID(requestID), zap.Error(err))
@@ -173,6 +350,8 @@ func (svr *Server) UpdateUser(ctx context.Context, req *apisecurity.User) *apise
 		return api.NewUserResponse(apimodel.Code_NoNeedUpdate, req)
 	}
 
+	// 记录最后一次修改该用户的操作者，与 mtime 一同更新
+	data.ModifiedBy = utils.ParseUserID(ctx)
 	if err := svr.storage.UpdateUser(data); err != nil {
 		log.Error("[Auth][User] update user from store", utils.ZapRequestID(requestID),
 			zap.Error(err))
@@ -219,10 +398,13 @@ func (svr *Server) UpdateUserPassword(ctx context.Context, req *apisecurity.Modi
 		return api.NewAuthResponse(apimodel.Code_NoNeedUpdate)
 	}
 
+	data.ModifiedBy = utils.ParseUserID(ctx)
+	// 超级管理员修改密码时，按照存储配置可以不受密码最短使用时间的限制
+	data.AdminOperated = authcommon.ParseUserRole(ctx) == model.AdminUserRole
 	if err := svr.storage.UpdateUser(data); err != nil {
 		log.Error("[Auth][User] update user from store", utils.ZapRequestID(requestID),
 			zap.Error(err))
-		return api.NewAuthResponse(commonstore.StoreCode2APICode(err))
+		return api.NewAuthResponseWithMsg(commonstore.StoreCode2APICode(err), err.Error())
 	}
 
 	log.Info("[Auth][User] update user", utils.ZapRequestID(requestID),
@@ -231,6 +413,67 @@ func (svr *Server) UpdateUserPassword(ctx context.Context, req *apisecurity.Modi
 	return api.NewAuthResponse(apimodel.Code_ExecuteSuccess)
 }
 
+// RenameUser 修改用户名称，用户所在的用户组以及关联的鉴权策略保持不变
+func (svr *Server) RenameUser(ctx context.Context, userId, newName string) *apiservice.Response {
+	requestID := utils.ParseRequestID(ctx)
+	req := &apisecurity.User{
+		Id:   utils.NewStringValue(userId),
+		Name: utils.NewStringValue(newName),
+	}
+
+	if userId == "" {
+		return api.NewUserResponse(apimodel.Code_BadRequest, req)
+	}
+	if err := checkName(req.Name); err != nil {
+		return api.NewUserResponseWithMsg(apimodel.Code_InvalidUserName, err.Error(), req)
+	}
+
+	user, err := svr.storage.GetUser(userId)
+	if err != nil {
+		log.Error("[Auth][User] get user from store", utils.ZapRequestID(requestID), zap.Error(err))
+		return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
+	}
+	if user == nil {
+		return api.NewUserResponse(apimodel.Code_NotFoundUser, req)
+	}
+	if !checkUserViewPermission(ctx, user) {
+		log.Error("[Auth][User] rename user forbidden", utils.ZapRequestID(requestID),
+			zap.String("user-id", userId))
+		return api.NewUserResponse(apimodel.Code_NotAllowedAccess, req)
+	}
+	if user.Name == newName {
+		return api.NewUserResponse(apimodel.Code_NoNeedUpdate, req)
+	}
+
+	// 子账户不能与其主账户同名
+	if user.Owner != "" {
+		owner, err := svr.storage.GetUser(user.Owner)
+		if err != nil {
+			log.Error("[Auth][User] get owner user", utils.ZapRequestID(requestID), zap.Error(err),
+				zap.String("owner", user.Owner))
+			return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
+		}
+		if owner != nil && owner.Name == newName {
+			return api.NewUserResponse(apimodel.Code_UserExisted, req)
+		}
+	}
+
+	if err := svr.storage.RenameUser(userId, newName, utils.ParseUserID(ctx)); err != nil {
+		log.Error("[Auth][User] rename user from store", utils.ZapRequestID(requestID),
+			zap.String("user-id", userId), zap.Error(err))
+		if store.Code(err) == store.DuplicateEntryErr {
+			return api.NewUserResponse(apimodel.Code_UserExisted, req)
+		}
+		return api.NewUserResponseWithMsg(commonstore.StoreCode2APICode(err), err.Error(), req)
+	}
+
+	log.Info("[Auth][User] rename user", utils.ZapRequestID(requestID), zap.String("user-id", userId),
+		zap.String("old-name", user.Name), zap.String("new-name", newName))
+	svr.RecordHistory(userRecordEntry(ctx, req, user, model.OUpdate))
+
+	return api.NewUserResponse(apimodel.Code_ExecuteSuccess, req)
+}
+
 // DeleteUsers 批量删除用户
 func (svr *Server) DeleteUsers(ctx context.Context, reqs []*apisecurity.User) *apiservice.BatchWriteResponse {
 	resp := api.NewAuthBatchWriteResponse(apimodel.Code_ExecuteSuccess)
@@ -248,6 +491,7 @@ func (svr *Server) DeleteUsers(ctx context.Context, reqs []*apisecurity.User) *a
 // Case 2. 删除主账户，如果主账户下还存在子账户，必须先删除子账户，才能删除主账户
 // Case 3. 主账户角色下，只能删除自己创建的子账户
 // Case 4. 超级账户角色下，可以删除任意账户
+// Case 5. 重复删除已经被删除的用户视为成功，删除不存在的用户返回 NotFoundUser
 func (svr *Server) DeleteUser(ctx context.Context, req *apisecurity.User) *apiservice.Response {
 	requestID := utils.ParseRequestID(ctx)
 	user, err := svr.storage.GetUser(req.Id.GetValue())
@@ -256,7 +500,7 @@ func (svr *Server) DeleteUser(ctx context.Context, req *apisecurity.User) *apise
 		return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
 	}
 	if user == nil {
-		return api.NewUserResponse(apimodel.Code_ExecuteSuccess, req)
+		return svr.deleteMissingUser(ctx, req)
 	}
 
 	if !checkUserViewPermission(ctx, user) {
@@ -282,6 +526,7 @@ func (svr *Server) DeleteUser(ctx context.Context, req *apisecurity.User) *apise
 		}
 	}
 
+	user.ModifiedBy = utils.ParseUserID(ctx)
 	if err := svr.storage.DeleteUser(user); err != nil {
 		log.Error("[Auth][User] delete user from store", utils.ZapRequestID(requestID), zap.Error(err))
 		return api.NewAuthResponse(commonstore.StoreCode2APICode(err))
@@ -294,6 +539,32 @@ func (svr *Server) DeleteUser(ctx context.Context, req *apisecurity.User) *apise
 	return api.NewUserResponse(apimodel.Code_ExecuteSuccess, req)
 }
 
+// deleteMissingUser 要删除的用户不是有效用户时，已经被删除的用户视为删除成功，使得超时后重试删除得到相同的结果，
+// 从未存在过或者已经被物理清理的用户返回 NotFoundUser
+func (svr *Server) deleteMissingUser(ctx context.Context, req *apisecurity.User) *apiservice.Response {
+	requestID := utils.ParseRequestID(ctx)
+	_, users, err := svr.storage.GetUsers(map[string]string{
+		"id":              req.GetId().GetValue(),
+		"include_deleted": strconv.FormatBool(true),
+	}, 0, 1)
+	if err != nil {
+		log.Error("[Auth][User] get deleted user from store", utils.ZapRequestID(requestID), zap.Error(err))
+		return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
+	}
+	if len(users) == 0 {
+		return api.NewUserResponse(apimodel.Code_NotFoundUser, req)
+	}
+	if !checkUserViewPermission(ctx, users[0]) {
+		log.Error("[Auth][User] delete user forbidden", utils.ZapRequestID(requestID),
+			zap.String("id", req.GetId().GetValue()))
+		return api.NewUserResponse(apimodel.Code_NotAllowedAccess, req)
+	}
+
+	log.Info("[Auth][User] user already deleted", utils.ZapRequestID(requestID),
+		zap.String("id", req.GetId().GetValue()))
+	return api.NewUserResponse(apimodel.Code_ExecuteSuccess, req)
+}
+
 // GetUsers 查询用户列表
 func (svr *Server) GetUsers(ctx context.Context, query map[string]string) *apiservice.BatchQueryResponse {
 	requestID := utils.ParseRequestID(ctx)
@@ -315,11 +586,34 @@ func (svr *Server) GetUsers(ctx context.Context, query map[string]string) *apise
 		searchFilters[key] = value
 	}
 
+	scope := searchFilters["scope"]
+	delete(searchFilters, "scope")
+	isAdmin := authcommon.ParseUserRole(ctx) == model.AdminUserRole
+
 	searchFilters["hide_admin"] = strconv.FormatBool(true)
+	switch scope {
+	case "", UserQueryScopeOwner:
+	case UserQueryScopeAll:
+		if !isAdmin {
+			log.Error("[Auth][User] only admin can search users of all owners",
+				utils.ZapRequestID(requestID), zap.String("operator", utils.ParseUserID(ctx)))
+			return api.NewAuthBatchQueryResponse(apimodel.Code_NotAllowedAccess)
+		}
+		// 跨主账户搜索，忽略调用方传入的 owner 范围，仍然不返回超级管理员
+		delete(searchFilters, "owner")
+		log.Info("[Auth][User] admin search users of all owners", utils.ZapRequestID(requestID),
+			zap.String("operator", utils.ParseUserID(ctx)), zap.Any("query", searchFilters))
+	default:
+		return api.NewAuthBatchQueryResponseWithMsg(apimodel.Code_InvalidParameter, "invalid scope: "+scope)
+	}
 	// 如果不是超级管理员，查看数据有限制
-	if authcommon.ParseUserRole(ctx) != model.AdminUserRole {
+	if !isAdmin {
 		// 设置 owner 参数，只能查看对应 owner 下的用户
 		searchFilters["owner"] = utils.ParseOwnerID(ctx)
+		// 已经删除的用户只对超级管理员可见
+		delete(searchFilters, "include_deleted")
+		// 只能查看本主账户下用户组的成员
+		searchFilters["group_owner"] = utils.ParseOwnerID(ctx)
 	}
 
 	var (
@@ -368,6 +662,12 @@ func (svr *Server) GetUserToken(ctx context.Context, req *apisecurity.User) *api
 		return api.NewAuthResponse(apimodel.Code_InvalidParameter)
 	}
 
+	user, err := svr.loadUserSecrets(user)
+	if err != nil {
+		log.Error("[Auth][User] get user token from store", zap.String("id", req.GetId().GetValue()), zap.Error(err))
+		return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
+	}
+
 	if user == nil {
 		return api.NewUserResponse(apimodel.Code_NotFoundUser, req)
 	}
@@ -414,6 +714,7 @@ func (svr *Server) UpdateUserToken(ctx context.Context, req *apisecurity.User) *
 
 	user.TokenEnable = req.TokenEnable.GetValue()
 
+	user.ModifiedBy = utils.ParseUserID(ctx)
 	if err := svr.storage.UpdateUser(user); err != nil {
 		log.Error("[Auth][User] update user token into store",
 			utils.ZapRequestID(requestID), zap.Error(err))
@@ -455,6 +756,7 @@ func (svr *Server) ResetUserToken(ctx context.Context, req *apisecurity.User) *a
 
 	user.Token = newToken
 
+	user.ModifiedBy = utils.ParseUserID(ctx)
 	if err := svr.storage.UpdateUser(user); err != nil {
 		log.Error("[Auth][User] update user token into store", utils.ZapRequestID(requestID), zap.Error(err))
 		return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
@@ -469,6 +771,176 @@ func (svr *Server) ResetUserToken(ctx context.Context, req *apisecurity.User) *a
 	return api.NewUserResponse(apimodel.Code_ExecuteSuccess, req)
 }
 
+// ResetCredentials 在同一个事务中同时重置用户的密码以及 token，用于账户泄露后的处置
+// newToken 为空时自动生成，否则必须是签发给该用户的 token
+func (svr *Server) ResetCredentials(ctx context.Context, userId, newPassword, newToken string) *apiservice.Response {
+	requestID := utils.ParseRequestID(ctx)
+	req := &apisecurity.User{Id: utils.NewStringValue(userId)}
+
+	if userId == "" {
+		return api.NewUserResponse(apimodel.Code_BadRequest, req)
+	}
+	// 密码规则取决于用户所属的主账户，查询到用户之后再校验
+	if newPassword == "" {
+		return api.NewUserResponseWithMsg(apimodel.Code_InvalidUserPassword, utils.EmptyErrString, req)
+	}
+	if newToken != "" {
+		info, err := decodeToken(newToken)
+		if err != nil || !info.IsUserToken || info.OperatorID != userId {
+			return api.NewUserResponseWithMsg(apimodel.Code_InvalidUserToken,
+				"token is not issued for user "+userId, req)
+		}
+	}
+
+	user, err := svr.storage.GetUser(userId)
+	if err != nil {
+		log.Error("[Auth][User] get user from store", utils.ZapRequestID(requestID), zap.Error(err))
+		return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
+	}
+	if user == nil {
+		return api.NewUserResponse(apimodel.Code_NotFoundUser, req)
+	}
+	if !checkUserViewPermission(ctx, user) {
+		return api.NewUserResponse(apimodel.Code_NotAllowedAccess, req)
+	}
+	if err := checkPassword(utils.NewStringValue(newPassword), passwordPolicyOwner(user)); err != nil {
+		return api.NewUserResponseWithMsg(apimodel.Code_InvalidUserPassword, err.Error(), req)
+	}
+
+	if newToken == "" {
+		if newToken, err = createUserToken(userId); err != nil {
+			log.Error("[Auth][User] create user token", utils.ZapRequestID(requestID), zap.Error(err))
+			return api.NewUserResponse(apimodel.Code_ExecuteException, req)
+		}
+	}
+	pwd, err := AuthOption.HashPassword(newPassword)
+	if err != nil {
+		log.Error("[Auth][User] hash user password", utils.ZapRequestID(requestID), zap.Error(err))
+		return api.NewUserResponse(apimodel.Code_ExecuteException, req)
+	}
+
+	if err := svr.storage.ResetUserCredentials(userId, pwd, newToken, utils.ParseUserID(ctx)); err != nil {
+		log.Error("[Auth][User] reset user credentials into store", utils.ZapRequestID(requestID),
+			zap.String("user-id", userId), zap.Error(err))
+		return api.NewUserResponse(commonstore.StoreCode2APICode(err), req)
+	}
+
+	log.Info("[Auth][User] reset user credentials", utils.ZapRequestID(requestID), zap.String("id", userId))
+	svr.RecordHistory(userRecordEntry(ctx, req, user, model.OUpdate))
+
+	req.AuthToken = utils.NewStringValue(newToken)
+	return api.NewUserResponse(apimodel.Code_ExecuteSuccess, req)
+}
+
+// CreateScopedToken 为用户创建一个按照名称区分的附加 token，可以限制为只读以及设置有效期，ttl 为 0 表示永不过期
+// 只有用户自身、用户所属的主账户以及超级账户可以创建，token 的内容只会在创建时返回
+func (svr *Server) CreateScopedToken(ctx context.Context, userId, name string, scope model.UserTokenScope,
+	ttl time.Duration) (*model.UserToken, apimodel.Code) {
+	requestID := utils.ParseRequestID(ctx)
+	if userId == "" {
+		return nil, apimodel.Code_BadRequest
+	}
+	if err := checkName(utils.NewStringValue(name)); err != nil {
+		return nil, apimodel.Code_InvalidParameter
+	}
+	if !scope.IsValid() || ttl < 0 {
+		return nil, apimodel.Code_InvalidParameter
+	}
+
+	user, code := svr.loadOperableUser(ctx, userId)
+	if code != apimodel.Code_ExecuteSuccess {
+		return nil, code
+	}
+
+	token := &model.UserToken{
+		ID:     utils.NewUUID(),
+		UserID: user.ID,
+		Name:   name,
+		Scope:  scope,
+		Valid:  true,
+	}
+	if ttl > 0 {
+		token.ExpireTime = time.Now().Add(ttl)
+	}
+	value, err := createScopedToken(token.ID)
+	if err != nil {
+		log.Error("[Auth][User] create scoped token", utils.ZapRequestID(requestID), zap.Error(err))
+		return nil, apimodel.Code_ExecuteException
+	}
+	token.Token = value
+
+	if err := svr.storage.AddUserToken(token); err != nil {
+		log.Error("[Auth][User] add scoped token into store", utils.ZapRequestID(requestID),
+			zap.String("user", userId), zap.String("name", name), zap.Error(err))
+		return nil, commonstore.StoreCode2APICode(err)
+	}
+
+	log.Info("[Auth][User] create scoped token", utils.ZapRequestID(requestID), zap.String("user", userId),
+		zap.String("token-id", token.ID), zap.String("scope", string(scope)))
+	svr.RecordHistory(userRecordEntry(ctx, &apisecurity.User{Id: utils.NewStringValue(userId)}, user,
+		model.OUpdateToken))
+	return token, apimodel.Code_ExecuteSuccess
+}
+
+// ListScopedTokens 查询用户有效的附加 token，不返回 token 的内容
+func (svr *Server) ListScopedTokens(ctx context.Context, userId string) ([]*model.UserToken, apimodel.Code) {
+	if userId == "" {
+		return nil, apimodel.Code_BadRequest
+	}
+	if _, code := svr.loadOperableUser(ctx, userId); code != apimodel.Code_ExecuteSuccess {
+		return nil, code
+	}
+
+	tokens, err := svr.storage.ListUserTokens(userId)
+	if err != nil {
+		log.Error("[Auth][User] list scoped tokens from store", utils.ZapRequestID(utils.ParseRequestID(ctx)),
+			zap.String("user", userId), zap.Error(err))
+		return nil, commonstore.StoreCode2APICode(err)
+	}
+	return tokens, apimodel.Code_ExecuteSuccess
+}
+
+// RevokeScopedToken 撤销用户的附加 token，撤销后立即失效
+func (svr *Server) RevokeScopedToken(ctx context.Context, userId, tokenId string) apimodel.Code {
+	requestID := utils.ParseRequestID(ctx)
+	if userId == "" || tokenId == "" {
+		return apimodel.Code_BadRequest
+	}
+	user, code := svr.loadOperableUser(ctx, userId)
+	if code != apimodel.Code_ExecuteSuccess {
+		return code
+	}
+
+	if err := svr.storage.RevokeUserToken(userId, tokenId); err != nil {
+		log.Error("[Auth][User] revoke scoped token", utils.ZapRequestID(requestID),
+			zap.String("user", userId), zap.String("token-id", tokenId), zap.Error(err))
+		return commonstore.StoreCode2APICode(err)
+	}
+	svr.authMgn.invalidateScopedToken(tokenId)
+
+	log.Info("[Auth][User] revoke scoped token", utils.ZapRequestID(requestID), zap.String("user", userId),
+		zap.String("token-id", tokenId))
+	svr.RecordHistory(userRecordEntry(ctx, &apisecurity.User{Id: utils.NewStringValue(userId)}, user,
+		model.OUpdateToken))
+	return apimodel.Code_ExecuteSuccess
+}
+
+// loadOperableUser 从存储中加载用户，并检查当前操作者是否可以操作该用户
+func (svr *Server) loadOperableUser(ctx context.Context, userId string) (*model.User, apimodel.Code) {
+	user, err := svr.storage.GetUser(userId)
+	if err != nil {
+		log.Error("[Auth][User] get user from store", utils.ZapRequestID(utils.ParseRequestID(ctx)), zap.Error(err))
+		return nil, commonstore.StoreCode2APICode(err)
+	}
+	if user == nil {
+		return nil, apimodel.Code_NotFoundUser
+	}
+	if !checkUserViewPermission(ctx, user) {
+		return nil, apimodel.Code_NotAllowedAccess
+	}
+	return user, apimodel.Code_ExecuteSuccess
+}
+
 // checkUserViewPermission 检查是否可以操作该用户
 // Case 1: 如果是自己操作自己，通过
 // Case 2: 如果是主账户操作自己的子账户，通过
@@ -546,8 +1018,9 @@ func userRecordEntry(ctx context.Context, req *apisecurity.User, md *model.User,
 	return entry
 }
 
-// checkCreateUser 检查创建用户的请求
-func checkCreateUser(req *apisecurity.User) *apiservice.Response {
+// checkCreateUser 检查创建用户的请求，hashAlgorithm 不为空时按照对应算法检查密码摘要的格式，
+// 否则按照 policyOwner 对应的密码规则检查密码，新建主账户时 policyOwner 为空
+func checkCreateUser(req *apisecurity.User, opt auth.ImportOption, policyOwner string) *apiservice.Response {
 	if req == nil {
 		return api.NewUserResponse(apimodel.Code_EmptyRequest, req)
 	}
@@ -556,13 +1029,33 @@ func checkCreateUser(req *apisecurity.User) *apiservice.Response {
 		return api.NewUserResponse(apimodel.Code_InvalidUserName, req)
 	}
 
-	if err := checkPassword(req.Password); err != nil {
-		return api.NewUserResponse(apimodel.Code_InvalidUserPassword, req)
+	switch {
+	case opt.HashAlgorithm != "":
+		if err := checkHashedPassword(req.Password, opt.HashAlgorithm); err != nil {
+			return api.NewUserResponseWithMsg(apimodel.Code_InvalidUserPassword, err.Error(), req)
+		}
+	case opt.SkipPasswordCheck:
+		// 历史密码不校验复杂度，但是不允许为空
+		if req.GetPassword().GetValue() == "" {
+			return api.NewUserResponse(apimodel.Code_InvalidUserPassword, req)
+		}
+	default:
+		if err := checkPassword(req.Password, policyOwner); err != nil {
+			return api.NewUserResponse(apimodel.Code_InvalidUserPassword, req)
+		}
 	}
 
 	if err := checkOwner(req.Owner); err != nil {
 		return api.NewUserResponse(apimodel.Code_InvalidUserOwners, req)
 	}
+
+	source, ok := AuthOption.NormalizeUserSource(req.GetSource().GetValue())
+	if !ok {
+		return api.NewUserResponseWithMsg(apimodel.Code_InvalidParameter, "unknown user source: "+source, req)
+	}
+	if req.GetSource() != nil {
+		req.Source = utils.NewStringValue(source)
+	}
 	return nil
 }
 
@@ -574,7 +1067,7 @@ func checkUpdateUser(req *apisecurity.User) *apiservice.Response {
 
 	// 如果本次请求需要修改密码的话
 	if req.GetPassword() != nil {
-		if err := checkPassword(req.Password); err != nil {
+		if err := checkPassword(req.Password, ""); err != nil {
 			return api.NewUserResponseWithMsg(apimodel.Code_InvalidUserPassword, err.Error(), req)
 		}
 	}
@@ -601,7 +1094,7 @@ func updateUserPasswordAttribute(
 	isAdmin bool, user *model.User, req *apisecurity.ModifyUserPassword) (*model.User, bool, error) {
 	needUpdate := false
 
-	if err := checkPassword(req.NewPassword); err != nil {
+	if err := checkPassword(req.NewPassword, passwordPolicyOwner(user)); err != nil {
 		return nil, false, err
 	}
 
@@ -610,19 +1103,19 @@ func updateUserPasswordAttribute(
 			return nil, false, errors.New("original password is empty")
 		}
 
-		err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.GetOldPassword().GetValue()))
+		err := AuthOption.ComparePassword(user.Password, req.GetOldPassword().GetValue())
 		if err != nil {
 			return nil, false, errors.New("original password match failed")
 		}
 	}
 
 	if req.GetNewPassword().GetValue() != "" {
-		pwd, err := bcrypt.GenerateFromPassword([]byte(req.GetNewPassword().GetValue()), bcrypt.DefaultCost)
+		pwd, err := AuthOption.HashPassword(req.GetNewPassword().GetValue())
 		if err != nil {
 			return nil, false, err
 		}
 		needUpdate = true
-		user.Password = string(pwd)
+		user.Password = pwd
 		// newToken, err := createUserToken(user.ID)
 		// if err != nil {
 		// 	return nil, false, err
@@ -633,11 +1126,14 @@ func updateUserPasswordAttribute(
 	return user, needUpdate, nil
 }
 
-// createUserModel 创建用户模型
-func createUserModel(req *apisecurity.User, role model.UserRoleType) (*model.User, error) {
-	pwd, err := bcrypt.GenerateFromPassword([]byte(req.GetPassword().GetValue()), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, err
+// createUserModel 创建用户模型，hashAlgorithm 不为空时请求中的密码已经是摘要，直接保存
+func createUserModel(req *apisecurity.User, role model.UserRoleType, hashAlgorithm string) (*model.User, error) {
+	pwd := req.GetPassword().GetValue()
+	if hashAlgorithm == "" {
+		var err error
+		if pwd, err = AuthOption.HashPassword(pwd); err != nil {
+			return nil, err
+		}
 	}
 
 	id := utils.NewUUID()
@@ -648,7 +1144,7 @@ func createUserModel(req *apisecurity.User, role model.UserRoleType) (*model.Use
 	user := &model.User{
 		ID:          id,
 		Name:        req.GetName().GetValue(),
-		Password:    string(pwd),
+		Password:    pwd,
 		Owner:       req.GetOwner().GetValue(),
 		Source:      req.GetSource().GetValue(),
 		Valid:       true,
@@ -657,6 +1153,8 @@ func createUserModel(req *apisecurity.User, role model.UserRoleType) (*model.Use
 		CreateTime:  time.Now(),
 		ModifyTime:  time.Now(),
 		TokenEnable: true,
+		// 创建用户时设置的密码同样计入密码有效期
+		PasswordSetTime: time.Now(),
 	}
 
 	// 如果不是子账户的话，owner 就是自己
@@ -19,6 +19,7 @@ package defaultauth
 
 import (
 	"context"
+	"time"
 
 	apimodel "github.com/polarismesh/specification/source/go/api/v1/model"
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
@@ -27,6 +28,7 @@ import (
 	"github.com/polarismesh/polaris/auth"
 	cachetypes "github.com/polarismesh/polaris/cache/api"
 	api "github.com/polarismesh/polaris/common/api/v1"
+	"github.com/polarismesh/polaris/common/model"
 	"github.com/polarismesh/polaris/plugin"
 	"github.com/polarismesh/polaris/store"
 )
@@ -93,10 +95,58 @@ func (svr *UserAuthAbility) CreateUsers(ctx context.Context, req []*apisecurity.
 	return svr.target.CreateUsers(ctx, req)
 }
 
+// AddUserWithHashedPassword 使用密码摘要创建用户，只能由超级账户 or 主账户调用
+func (svr *UserAuthAbility) AddUserWithHashedPassword(ctx context.Context, user *apisecurity.User,
+	algorithm string) *apiservice.Response {
+	ctx, rsp := verifyAuth(ctx, WriteOp, MustOwner, svr.authMgn)
+	if rsp != nil {
+		rsp.User = user
+		return rsp
+	}
+
+	return svr.target.AddUserWithHashedPassword(ctx, user, algorithm)
+}
+
+// ImportUsers 批量导入用户，只能由超级账户 or 主账户调用
+func (svr *UserAuthAbility) ImportUsers(ctx context.Context, users []*apisecurity.User,
+	opt auth.ImportOption) *apiservice.BatchWriteResponse {
+	ctx, rsp := verifyAuth(ctx, WriteOp, MustOwner, svr.authMgn)
+	if rsp != nil {
+		resp := api.NewAuthBatchWriteResponse(apimodel.Code_ExecuteSuccess)
+		api.Collect(resp, rsp)
+		return resp
+	}
+
+	return svr.target.ImportUsers(ctx, users, opt)
+}
+
+// ValidateUsers 校验待创建的用户，与创建用户一样只能由超级账户 or 主账户调用
+func (svr *UserAuthAbility) ValidateUsers(ctx context.Context, users []*model.User) []auth.ValidationResult {
+	ctx, rsp := verifyAuth(ctx, ReadOp, MustOwner, svr.authMgn)
+	if rsp != nil {
+		results := make([]auth.ValidationResult, 0, len(users))
+		for i, user := range users {
+			ret := auth.ValidationResult{Index: i, Code: apimodel.Code(rsp.GetCode().GetValue()),
+				Info: rsp.GetInfo().GetValue()}
+			if user != nil {
+				ret.Name = user.Name
+			}
+			results = append(results, ret)
+		}
+		return results
+	}
+
+	return svr.target.ValidateUsers(ctx, users)
+}
+
 // UpdateUser 更新用户，任意账户均可以操作
 // 用户token被禁止也只是表示不能对北极星资源执行写操作，但是改用户信息还是可以执行的
+// 只读的附加 token 不能修改用户信息
 func (svr *UserAuthAbility) UpdateUser(ctx context.Context, user *apisecurity.User) *apiservice.Response {
 	ctx, rsp := verifyAuth(ctx, ReadOp, NotOwner, svr.authMgn)
+	if rsp == nil {
+		rsp = verifyTokenWritable(ctx)
+	}
 	if rsp != nil {
 		rsp.User = user
 		return rsp
@@ -108,7 +158,10 @@ func (svr *UserAuthAbility) UpdateUser(ctx context.Context, user *apisecurity.Us
 // UpdateUserPassword 更新用户信息
 func (svr *UserAuthAbility) UpdateUserPassword(
 	ctx context.Context, req *apisecurity.ModifyUserPassword) *apiservice.Response {
-	ctx, rsp := verifyAuth(ctx, ReadOp, NotOwner, svr.authMgn)
+	ctx, rsp := verifyAuthForPasswordChange(ctx, svr.authMgn)
+	if rsp == nil {
+		rsp = verifyTokenWritable(ctx)
+	}
 	if rsp != nil {
 		return rsp
 	}
@@ -116,6 +169,16 @@ func (svr *UserAuthAbility) UpdateUserPassword(
 	return svr.target.UpdateUserPassword(ctx, req)
 }
 
+// RenameUser 修改用户名称，只能由超级账户 or 主账户操作
+func (svr *UserAuthAbility) RenameUser(ctx context.Context, userId, newName string) *apiservice.Response {
+	ctx, rsp := verifyAuth(ctx, WriteOp, MustOwner, svr.authMgn)
+	if rsp != nil {
+		return rsp
+	}
+
+	return svr.target.RenameUser(ctx, userId, newName)
+}
+
 // DeleteUsers 批量删除用户，只能由超级账户 or 主账户操作
 func (svr *UserAuthAbility) DeleteUsers(
 	ctx context.Context, reqs []*apisecurity.User) *apiservice.BatchWriteResponse {
@@ -150,9 +213,12 @@ func (svr *UserAuthAbility) GetUsers(ctx context.Context, filter map[string]stri
 	return svr.target.GetUsers(ctx, filter)
 }
 
-// GetUserToken 获取用户token，任意账户均可以操作
+// GetUserToken 获取用户token，任意账户均可以操作，只读的附加 token 不能获取
 func (svr *UserAuthAbility) GetUserToken(ctx context.Context, user *apisecurity.User) *apiservice.Response {
 	ctx, rsp := verifyAuth(ctx, ReadOp, NotOwner, svr.authMgn)
+	if rsp == nil {
+		rsp = verifyTokenWritable(ctx)
+	}
 	if rsp != nil {
 		return rsp
 	}
@@ -182,6 +248,48 @@ func (svr *UserAuthAbility) ResetUserToken(ctx context.Context, user *apisecurit
 	return svr.target.ResetUserToken(ctx, user)
 }
 
+// ResetCredentials 同时重置用户的密码以及 token，只能由超级账户 or 主账户操作
+func (svr *UserAuthAbility) ResetCredentials(
+	ctx context.Context, userId, newPassword, newToken string) *apiservice.Response {
+	ctx, rsp := verifyAuth(ctx, WriteOp, MustOwner, svr.authMgn)
+	if rsp != nil {
+		return rsp
+	}
+
+	return svr.target.ResetCredentials(ctx, userId, newPassword, newToken)
+}
+
+// CreateScopedToken 创建用户的附加 token，只读的附加 token 不能再创建新的 token
+func (svr *UserAuthAbility) CreateScopedToken(ctx context.Context, userId, name string,
+	scope model.UserTokenScope, ttl time.Duration) (*model.UserToken, apimodel.Code) {
+	ctx, rsp := verifyAuth(ctx, WriteOp, NotOwner, svr.authMgn)
+	if rsp != nil {
+		return nil, apimodel.Code(rsp.GetCode().GetValue())
+	}
+
+	return svr.target.CreateScopedToken(ctx, userId, name, scope, ttl)
+}
+
+// ListScopedTokens 查询用户的附加 token，任意账户均可以操作
+func (svr *UserAuthAbility) ListScopedTokens(ctx context.Context, userId string) ([]*model.UserToken, apimodel.Code) {
+	ctx, rsp := verifyAuth(ctx, ReadOp, NotOwner, svr.authMgn)
+	if rsp != nil {
+		return nil, apimodel.Code(rsp.GetCode().GetValue())
+	}
+
+	return svr.target.ListScopedTokens(ctx, userId)
+}
+
+// RevokeScopedToken 撤销用户的附加 token
+func (svr *UserAuthAbility) RevokeScopedToken(ctx context.Context, userId, tokenId string) apimodel.Code {
+	ctx, rsp := verifyAuth(ctx, WriteOp, NotOwner, svr.authMgn)
+	if rsp != nil {
+		return apimodel.Code(rsp.GetCode().GetValue())
+	}
+
+	return svr.target.RevokeScopedToken(ctx, userId, tokenId)
+}
+
 // Login login Servers
 func (svr *UserAuthAbility) Login(req *apisecurity.LoginRequest) *apiservice.Response {
 	return svr.target.Login(req)
@@ -19,13 +19,18 @@ package defaultauth_test
 
 import (
 	"context"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	apimodel "github.com/polarismesh/specification/source/go/api/v1/model"
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/polarismesh/polaris/auth"
 	"github.com/polarismesh/polaris/auth/defaultauth"
@@ -35,6 +40,7 @@ import (
 	commonlog "github.com/polarismesh/polaris/common/log"
 	"github.com/polarismesh/polaris/common/model"
 	"github.com/polarismesh/polaris/common/utils"
+	"github.com/polarismesh/polaris/store"
 	storemock "github.com/polarismesh/polaris/store/mock"
 )
 
@@ -55,6 +61,10 @@ type UserTest struct {
 
 	svr *defaultauth.UserAuthAbility
 
+	// cacheUsers 为 GetUsersForCache 返回的存储侧数据，每次返回副本，避免与缓存共享同一个对象
+	cacheLock  sync.Mutex
+	cacheUsers []*model.User
+
 	cancel context.CancelFunc
 	ctrl   *gomock.Controller
 }
@@ -82,9 +92,21 @@ func newUserTest(t *testing.T) *UserTest {
 		Name: "create-user-2",
 	}, nil)
 
-	allUsers := append(append(users, newUsers...), admin)
-
-	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(allUsers, nil)
+	userTest := &UserTest{
+		cacheUsers: append(append(append([]*model.User{}, users...), newUsers...), admin),
+	}
+	storage.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(mtime time.Time, firstUpdate bool) ([]*model.User, error) {
+			userTest.cacheLock.Lock()
+			defer userTest.cacheLock.Unlock()
+			ret := make([]*model.User, 0, len(userTest.cacheUsers))
+			for i := range userTest.cacheUsers {
+				user := *userTest.cacheUsers[i]
+				ret = append(ret, &user)
+			}
+			return ret, nil
+		})
+	storage.EXPECT().UpdateLastLogin(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).AnyTimes().Return(groups, nil)
 	storage.EXPECT().UpdateUser(gomock.Any()).AnyTimes().Return(nil)
 	storage.EXPECT().DeleteUser(gomock.Any()).AnyTimes().Return(nil)
@@ -107,6 +129,7 @@ func newUserTest(t *testing.T) *UserTest {
 
 	checker := &defaultauth.DefaultAuthChecker{}
 	checker.SetCacheMgr(cacheMgn)
+	checker.SetStorage(storage)
 
 	_ = cache.TestRun(ctx, cacheMgn)
 	svr := defaultauth.NewUserAuthAbility(
@@ -114,22 +137,41 @@ func newUserTest(t *testing.T) *UserTest {
 		defaultauth.NewServer(storage, nil, cacheMgn, checker),
 	)
 
-	return &UserTest{
-		admin:    admin,
-		ownerOne: users[0],
-		ownerTwo: newUsers[0],
+	userTest.admin = admin
+	userTest.ownerOne = users[0]
+	userTest.ownerTwo = newUsers[0]
+
+	userTest.users = users
+	userTest.newUsers = newUsers
+	userTest.groups = groups
 
-		users:    users,
-		newUsers: newUsers,
-		groups:   groups,
+	userTest.storage = storage
+	userTest.cacheMgn = cacheMgn
+	userTest.checker = checker
+	userTest.svr = svr
 
-		storage:  storage,
-		cacheMgn: cacheMgn,
-		checker:  checker,
-		svr:      svr,
+	userTest.cancel = cancel
+	userTest.ctrl = ctrl
+	return userTest
+}
 
-		cancel: cancel,
-		ctrl:   ctrl,
+// updateCacheUser 修改存储侧的用户数据并立即刷新缓存
+func (g *UserTest) updateCacheUser(t *testing.T, id string, update func(user *model.User)) {
+	g.cacheLock.Lock()
+	for i := range g.cacheUsers {
+		if g.cacheUsers[i].ID == id {
+			user := *g.cacheUsers[i]
+			update(&user)
+			g.cacheUsers[i] = &user
+		}
+	}
+	g.cacheLock.Unlock()
+
+	// 第一次刷新可能合并到修改前就已开始的后台刷新中，第二次刷新一定会读取到修改后的数据
+	for i := 0; i < 2; i++ {
+		if err := g.cacheMgn.TestUpdate(); err != nil {
+			t.Fatal(err)
+		}
 	}
 }
 
@@ -260,9 +302,12 @@ func Test_server_CreateUsers(t *testing.T) {
 	})
 
 	t.Run("主账户创建账户-token被禁用-失败", func(t *testing.T) {
-		userTest.users[0].TokenEnable = false
-		// 让 cache 可以刷新到
-		time.Sleep(time.Second)
+		userTest.updateCacheUser(t, userTest.users[0].ID, func(user *model.User) {
+			user.TokenEnable = false
+		})
+		defer userTest.updateCacheUser(t, userTest.users[0].ID, func(user *model.User) {
+			user.TokenEnable = true
+		})
 
 		createUsersReq := []*apisecurity.User{
 			{
@@ -277,9 +322,6 @@ func Test_server_CreateUsers(t *testing.T) {
 
 		t.Logf("CreateUsers resp : %+v", resp)
 		assert.Equal(t, api.TokenDisabled, resp.Responses[0].Code.GetValue(), "create users must fail")
-
-		userTest.users[0].TokenEnable = true
-		time.Sleep(time.Second)
 	})
 
 	t.Run("子主账户创建账户-失败", func(t *testing.T) {
@@ -315,6 +357,61 @@ func Test_server_CreateUsers(t *testing.T) {
 	})
 }
 
+func Test_server_VerifyTokenState(t *testing.T) {
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	createUsersReq := []*apisecurity.User{
+		{
+			Id:       &wrappers.StringValue{Value: utils.NewUUID()},
+			Name:     &wrappers.StringValue{Value: "create-user-2"},
+			Password: &wrappers.StringValue{Value: "create-user-2"},
+		},
+	}
+	verify := func(token string) uint32 {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token)
+		resp := userTest.svr.CreateUsers(reqCtx, createUsersReq)
+		return resp.Responses[0].GetCode().GetValue()
+	}
+
+	t.Run("无法解析的token", func(t *testing.T) {
+		assert.Equal(t, api.AuthTokenVerifyException, verify("invalid-token"))
+	})
+
+	t.Run("token对应的用户不存在", func(t *testing.T) {
+		token, err := defaultauth.TestCreateToken(utils.NewUUID(), "")
+		assert.NoError(t, err)
+		assert.Equal(t, api.TokenNotExisted, verify(token))
+	})
+
+	t.Run("token已经被重置", func(t *testing.T) {
+		token, err := defaultauth.TestCreateToken(userTest.ownerOne.ID, "")
+		assert.NoError(t, err)
+		assert.NotEqual(t, userTest.ownerOne.Token, token)
+		assert.Equal(t, api.TokenNotExisted, verify(token))
+	})
+
+	t.Run("token已经被禁用", func(t *testing.T) {
+		userTest.updateCacheUser(t, userTest.ownerOne.ID, func(user *model.User) {
+			user.TokenEnable = false
+		})
+		defer userTest.updateCacheUser(t, userTest.ownerOne.ID, func(user *model.User) {
+			user.TokenEnable = true
+		})
+		assert.Equal(t, api.TokenDisabled, verify(userTest.ownerOne.Token))
+	})
+
+	t.Run("密码已经过期", func(t *testing.T) {
+		userTest.updateCacheUser(t, userTest.ownerOne.ID, func(user *model.User) {
+			user.MustChangePassword = true
+		})
+		defer userTest.updateCacheUser(t, userTest.ownerOne.ID, func(user *model.User) {
+			user.MustChangePassword = false
+		})
+		assert.Equal(t, api.NotAllowedAccess, verify(userTest.ownerOne.Token))
+	})
+}
+
 func Test_server_UpdateUser(t *testing.T) {
 
 	userTest := newUserTest(t)
@@ -568,6 +665,124 @@ func Test_server_UpdateUserPassword(t *testing.T) {
 	})
 }
 
+func Test_server_RenameUser(t *testing.T) {
+
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.users[0].Token)
+
+	t.Run("主账户修改子账户名称-成功", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.users[1].ID)).Return(userTest.users[1], nil)
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.users[0].ID)).Return(userTest.users[0], nil)
+		userTest.storage.EXPECT().RenameUser(gomock.Eq(userTest.users[1].ID), gomock.Eq("rename-user-1"),
+			gomock.Any()).Return(nil)
+
+		resp := userTest.svr.RenameUser(reqCtx, userTest.users[1].ID, "rename-user-1")
+		assert.Equal(t, api.ExecuteSuccess, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("主账户修改子账户名称-同名用户已存在", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.users[2].ID)).Return(userTest.users[2], nil)
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.users[0].ID)).Return(userTest.users[0], nil)
+		userTest.storage.EXPECT().RenameUser(gomock.Eq(userTest.users[2].ID), gomock.Eq(userTest.users[3].Name),
+			gomock.Any()).
+			Return(store.NewStatusError(store.DuplicateEntryErr, "user name existed"))
+
+		resp := userTest.svr.RenameUser(reqCtx, userTest.users[2].ID, userTest.users[3].Name)
+		assert.Equal(t, api.UserExisted, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("主账户修改子账户名称-与主账户同名", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.users[2].ID)).Return(userTest.users[2], nil)
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.users[0].ID)).Return(userTest.users[0], nil)
+
+		resp := userTest.svr.RenameUser(reqCtx, userTest.users[2].ID, userTest.users[0].Name)
+		assert.Equal(t, api.UserExisted, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("主账户修改子账户名称-名称非法", func(t *testing.T) {
+		for _, name := range []string{"", "polariadmin", "invalid name!"} {
+			resp := userTest.svr.RenameUser(reqCtx, userTest.users[2].ID, name)
+			assert.Equal(t, api.InvalidUserName, resp.Code.GetValue(), name)
+		}
+	})
+
+	t.Run("子账户修改名称-失败", func(t *testing.T) {
+		subCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.users[1].Token)
+		resp := userTest.svr.RenameUser(subCtx, userTest.users[1].ID, "rename-user-2")
+		assert.Equal(t, api.OperationRoleException, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+}
+
+func Test_server_ResetCredentials(t *testing.T) {
+
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.users[0].Token)
+	subUser := userTest.users[1]
+
+	t.Run("主账户重置子账户的密码以及token-自动生成token", func(t *testing.T) {
+		var savedPassword, savedToken string
+		userTest.storage.EXPECT().GetUser(gomock.Eq(subUser.ID)).Return(subUser, nil)
+		userTest.storage.EXPECT().ResetUserCredentials(gomock.Eq(subUser.ID), gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(userId, password, token, modifiedBy string) error {
+				savedPassword, savedToken = password, token
+				return nil
+			})
+
+		resp := userTest.svr.ResetCredentials(reqCtx, subUser.ID, "new-password-1", "")
+		assert.Equal(t, api.ExecuteSuccess, resp.Code.GetValue(), resp.GetInfo().GetValue())
+		// 密码以摘要的形式保存，新的 token 签发给该用户并返回给调用方
+		assert.NoError(t, defaultauth.AuthOption.ComparePassword(savedPassword, "new-password-1"))
+		assert.NotEqual(t, subUser.Token, savedToken)
+		assert.Equal(t, savedToken, resp.GetUser().GetAuthToken().GetValue())
+		plain, err := defaultauth.TestDecryptMessage([]byte(defaultauth.AuthOption.Salt), savedToken)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasSuffix(plain, model.TokenForUser+"/"+subUser.ID), plain)
+	})
+
+	t.Run("主账户重置子账户的密码以及token-指定token", func(t *testing.T) {
+		newToken, err := defaultauth.TestCreateToken(subUser.ID, "")
+		assert.NoError(t, err)
+		userTest.storage.EXPECT().GetUser(gomock.Eq(subUser.ID)).Return(subUser, nil)
+		userTest.storage.EXPECT().ResetUserCredentials(gomock.Eq(subUser.ID), gomock.Any(), gomock.Eq(newToken),
+			gomock.Any()).
+			Return(nil)
+
+		resp := userTest.svr.ResetCredentials(reqCtx, subUser.ID, "new-password-2", newToken)
+		assert.Equal(t, api.ExecuteSuccess, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("指定的token不属于该用户", func(t *testing.T) {
+		resp := userTest.svr.ResetCredentials(reqCtx, subUser.ID, "new-password-3", userTest.users[2].Token)
+		assert.Equal(t, api.InvalidUserToken, resp.Code.GetValue(), resp.GetInfo().GetValue())
+		resp = userTest.svr.ResetCredentials(reqCtx, subUser.ID, "new-password-3", "not-a-token")
+		assert.Equal(t, api.InvalidUserToken, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("密码非法", func(t *testing.T) {
+		resp := userTest.svr.ResetCredentials(reqCtx, subUser.ID, "", "")
+		assert.Equal(t, api.InvalidUserPassword, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("用户不存在或者已经删除", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Eq(subUser.ID)).Return(subUser, nil)
+		userTest.storage.EXPECT().ResetUserCredentials(gomock.Eq(subUser.ID), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(store.NewStatusError(store.NotFoundUser, "user not found"))
+
+		resp := userTest.svr.ResetCredentials(reqCtx, subUser.ID, "new-password-4", "")
+		assert.Equal(t, api.NotFoundUser, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("子账户重置密码以及token-失败", func(t *testing.T) {
+		subCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, subUser.Token)
+		resp := userTest.svr.ResetCredentials(subCtx, subUser.ID, "new-password-5", "")
+		assert.Equal(t, api.OperationRoleException, resp.Code.GetValue(), resp.GetInfo().GetValue())
+	})
+}
+
 func Test_server_DeleteUser(t *testing.T) {
 	t.Run("主账户删除自己", func(t *testing.T) {
 		userTest := newUserTest(t)
@@ -678,6 +893,26 @@ func Test_server_DeleteUser(t *testing.T) {
 		assert.True(t, resp.GetCode().Value == api.SubAccountExisted, resp.Info.GetValue())
 	})
 
+	t.Run("主账户删除其他主账户下已经删除的用户", func(t *testing.T) {
+		userTest := newUserTest(t)
+		t.Cleanup(func() {
+			userTest.Clean()
+		})
+
+		deleted := *userTest.newUsers[1]
+		deleted.Valid = false
+		userTest.storage.EXPECT().GetUser(gomock.Eq(deleted.ID)).Return(nil, nil)
+		userTest.storage.EXPECT().GetUsers(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			uint32(1), []*model.User{&deleted}, nil)
+
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.users[0].Token)
+		resp := userTest.svr.DeleteUser(reqCtx, &apisecurity.User{
+			Id: utils.NewStringValue(deleted.ID),
+		})
+
+		assert.True(t, resp.GetCode().Value == api.NotAllowedAccess, resp.Info.GetValue())
+	})
+
 	t.Run("子账户删除用户", func(t *testing.T) {
 		userTest := newUserTest(t)
 		t.Cleanup(func() {
@@ -749,6 +984,85 @@ func Test_server_GetUserToken(t *testing.T) {
 	})
 }
 
+func Test_server_GetUsersScope(t *testing.T) {
+
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	allUsers := append(append([]*model.User{userTest.admin}, userTest.users...), userTest.newUsers...)
+	userTest.storage.EXPECT().GetUsers(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(filters map[string]string, offset, limit uint32) (uint32, []*model.User, error) {
+			ret := make([]*model.User, 0, len(allUsers))
+			for _, user := range allUsers {
+				if filters["hide_admin"] == "true" && user.Type == model.AdminUserRole {
+					continue
+				}
+				if owner := filters["owner"]; owner != "" && user.ID != owner && user.Owner != owner {
+					continue
+				}
+				ret = append(ret, user)
+			}
+			return uint32(len(ret)), ret, nil
+		})
+	owners := func(users []*apisecurity.User) map[string]struct{} {
+		ret := map[string]struct{}{}
+		for _, user := range users {
+			ret[user.GetOwner().GetValue()] = struct{}{}
+		}
+		return ret
+	}
+
+	t.Run("超级管理员跨主账户搜索用户", func(t *testing.T) {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.admin.Token)
+
+		resp := userTest.svr.GetUsers(reqCtx, map[string]string{
+			"scope": defaultauth.UserQueryScopeAll,
+			"owner": userTest.ownerOne.ID,
+		})
+		assert.True(t, resp.GetCode().Value == api.ExecuteSuccess, resp.Info.GetValue())
+		assert.Equal(t, len(allUsers)-1, int(resp.GetAmount().GetValue()))
+		assert.Contains(t, owners(resp.GetUsers()), userTest.ownerOne.ID)
+		assert.Contains(t, owners(resp.GetUsers()), userTest.ownerTwo.ID)
+		for _, user := range resp.GetUsers() {
+			assert.NotEqual(t, userTest.admin.ID, user.GetId().GetValue())
+		}
+	})
+
+	t.Run("超级管理员默认范围按照owner过滤", func(t *testing.T) {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.admin.Token)
+
+		resp := userTest.svr.GetUsers(reqCtx, map[string]string{"owner": userTest.ownerOne.ID})
+		assert.True(t, resp.GetCode().Value == api.ExecuteSuccess, resp.Info.GetValue())
+		assert.Equal(t, len(userTest.users), int(resp.GetAmount().GetValue()))
+		assert.NotContains(t, owners(resp.GetUsers()), userTest.ownerTwo.ID)
+	})
+
+	t.Run("主账户无法跨主账户搜索用户", func(t *testing.T) {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.ownerOne.Token)
+
+		resp := userTest.svr.GetUsers(reqCtx, map[string]string{"scope": defaultauth.UserQueryScopeAll})
+		assert.True(t, resp.GetCode().Value == api.NotAllowedAccess, resp.Info.GetValue())
+
+		resp = userTest.svr.GetUsers(reqCtx, map[string]string{"owner": userTest.ownerTwo.ID})
+		assert.True(t, resp.GetCode().Value == api.ExecuteSuccess, resp.Info.GetValue())
+		assert.NotContains(t, owners(resp.GetUsers()), userTest.ownerTwo.ID)
+	})
+
+	t.Run("子账户无法跨主账户搜索用户", func(t *testing.T) {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.users[1].Token)
+
+		resp := userTest.svr.GetUsers(reqCtx, map[string]string{"scope": defaultauth.UserQueryScopeAll})
+		assert.True(t, resp.GetCode().Value == api.NotAllowedAccess, resp.Info.GetValue())
+	})
+
+	t.Run("非法的查询范围", func(t *testing.T) {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.admin.Token)
+
+		resp := userTest.svr.GetUsers(reqCtx, map[string]string{"scope": "global"})
+		assert.True(t, resp.GetCode().Value == api.InvalidParameter, resp.Info.GetValue())
+	})
+}
+
 func Test_server_RefreshUserToken(t *testing.T) {
 
 	userTest := newUserTest(t)
@@ -764,6 +1078,10 @@ func Test_server_RefreshUserToken(t *testing.T) {
 		})
 
 		assert.True(t, resp.GetCode().Value == api.ExecuteSuccess, resp.Info.GetValue())
+		// 后续用例使用刷新后的 token
+		userTest.updateCacheUser(t, userTest.users[0].ID, func(user *model.User) {
+			user.Token = userTest.users[0].Token
+		})
 	})
 
 	t.Run("子账户刷新自己的Token", func(t *testing.T) {
@@ -936,6 +1254,63 @@ func Test_AuthServer_NormalOperateUser(t *testing.T) {
 		assert.Equal(t, 0, int(qresp.Size.GetValue()))
 	})
 
+	t.Run("重复删除已经删除的用户", func(t *testing.T) {
+		resp := suit.UserServer().DeleteUsers(suit.DefaultCtx, []*apisecurity.User{users[3]})
+		if !respSuccess(resp) {
+			t.Fatal(resp.GetInfo().GetValue())
+		}
+	})
+
+	t.Run("删除不存在的用户", func(t *testing.T) {
+		resp := suit.UserServer().DeleteUsers(suit.DefaultCtx, []*apisecurity.User{{
+			Id: utils.NewStringValue(utils.NewUUID()),
+		}})
+		assert.Equal(t, api.NotFoundUser, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("无法查询其他主账户用户组下的成员", func(t *testing.T) {
+		saved, err := suit.Storage.GetUser(users[0].GetId().GetValue())
+		assert.NoError(t, err)
+		for _, owner := range []string{saved.Owner, "other-owner"} {
+			err := suit.Storage.AddGroup(&model.UserGroupDetail{
+				UserGroup: &model.UserGroup{
+					ID:          "group-of-" + owner,
+					Name:        "group-of-" + owner,
+					Owner:       owner,
+					Token:       "token-of-" + owner,
+					TokenEnable: true,
+					Valid:       true,
+				},
+				UserIds: map[string]struct{}{users[0].GetId().GetValue(): {}},
+			})
+			assert.NoError(t, err)
+		}
+
+		qresp := suit.UserServer().GetUsers(suit.DefaultCtx, map[string]string{"group_id": "group-of-" + saved.Owner})
+		if !respSuccess(qresp) {
+			t.Fatal(qresp.GetInfo().GetValue())
+		}
+		assert.Equal(t, 1, int(qresp.Amount.GetValue()))
+
+		qresp = suit.UserServer().GetUsers(suit.DefaultCtx, map[string]string{"group_id": "group-of-other-owner"})
+		if !respSuccess(qresp) {
+			t.Fatal(qresp.GetInfo().GetValue())
+		}
+		assert.Equal(t, 0, int(qresp.Amount.GetValue()))
+	})
+
+	t.Run("非超级管理员无法查询已经删除的用户", func(t *testing.T) {
+		qresp := suit.UserServer().GetUsers(suit.DefaultCtx, map[string]string{
+			"id":              users[3].GetId().GetValue(),
+			"include_deleted": "true",
+			"deleted_after":   strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10),
+		})
+		if !respSuccess(qresp) {
+			t.Fatal(qresp.GetInfo().GetValue())
+		}
+		assert.Equal(t, 0, int(qresp.Amount.GetValue()))
+	})
+
 	t.Run("正常更新用户Token", func(t *testing.T) {
 		resp := suit.UserServer().ResetUserToken(suit.DefaultCtx, users[0])
 
@@ -952,3 +1327,523 @@ func Test_AuthServer_NormalOperateUser(t *testing.T) {
 		assert.Equal(t, resp.GetUser().GetAuthToken().GetValue(), qresp.GetUser().GetAuthToken().GetValue())
 	})
 }
+
+func Test_AuthServer_AddUserWithHashedPassword(t *testing.T) {
+	suit := &AuthTestSuit{}
+	if err := suit.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		suit.cleanAllAuthStrategy()
+		suit.cleanAllUser()
+		suit.cleanAllUserGroup()
+		suit.Destroy()
+	})
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("migrated-pwd"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	t.Run("密码摘要原样保存并且可以登录", func(t *testing.T) {
+		req := &apisecurity.User{
+			Name:     utils.NewStringValue("migrated-user"),
+			Password: utils.NewStringValue(string(hashed)),
+			Source:   utils.NewStringValue("Polaris"),
+		}
+		resp := suit.UserServer().AddUserWithHashedPassword(suit.DefaultCtx, req, defaultauth.PasswordHashBcrypt)
+		if !respSuccess(resp) {
+			t.Fatal(resp.GetInfo().GetValue())
+		}
+
+		saved, err := suit.Storage.GetUser(resp.GetUser().GetId().GetValue())
+		assert.NoError(t, err)
+		assert.Equal(t, string(hashed), saved.Password)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(saved.Password), []byte("migrated-pwd")))
+
+		owner, err := suit.Storage.GetUser(saved.OwnerID())
+		assert.NoError(t, err)
+		_ = suit.CacheMgr().TestUpdate()
+		loginResp := suit.UserServer().Login(&apisecurity.LoginRequest{
+			Owner:    utils.NewStringValue(owner.Name),
+			Name:     utils.NewStringValue("migrated-user"),
+			Password: utils.NewStringValue("migrated-pwd"),
+		})
+		assert.True(t, respSuccess(loginResp), loginResp.GetInfo().GetValue())
+	})
+
+	t.Run("非法的密码摘要", func(t *testing.T) {
+		req := &apisecurity.User{
+			Name:     utils.NewStringValue("migrated-user-1"),
+			Password: utils.NewStringValue("123456"),
+			Source:   utils.NewStringValue("Polaris"),
+		}
+		resp := suit.UserServer().AddUserWithHashedPassword(suit.DefaultCtx, req, defaultauth.PasswordHashBcrypt)
+		assert.Equal(t, api.InvalidUserPassword, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("不支持的摘要算法", func(t *testing.T) {
+		req := &apisecurity.User{
+			Name:     utils.NewStringValue("migrated-user-2"),
+			Password: utils.NewStringValue(string(hashed)),
+			Source:   utils.NewStringValue("Polaris"),
+		}
+		resp := suit.UserServer().AddUserWithHashedPassword(suit.DefaultCtx, req, "md5")
+		assert.Equal(t, api.InvalidParameter, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+}
+
+func Test_AuthServer_ImportUsers(t *testing.T) {
+	suit := &AuthTestSuit{}
+	if err := suit.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		suit.cleanAllAuthStrategy()
+		suit.cleanAllUser()
+		suit.cleanAllUserGroup()
+		suit.Destroy()
+	})
+
+	newImportUser := func(name, password string) *apisecurity.User {
+		return &apisecurity.User{
+			Name:     utils.NewStringValue(name),
+			Password: utils.NewStringValue(password),
+			Source:   utils.NewStringValue("Polaris"),
+		}
+	}
+
+	t.Run("严格模式-弱密码导入失败", func(t *testing.T) {
+		resp := suit.UserServer().ImportUsers(suit.DefaultCtx, []*apisecurity.User{
+			newImportUser("import-strict-1", "123"),
+			newImportUser("import-strict-2", "import-strict-2"),
+		}, auth.ImportOption{})
+		assert.Len(t, resp.GetResponses(), 2)
+		assert.Equal(t, api.InvalidUserPassword, resp.GetResponses()[0].GetCode().GetValue())
+		assert.Equal(t, api.ExecuteSuccess, resp.GetResponses()[1].GetCode().GetValue())
+
+		saved, err := suit.Storage.GetUser(resp.GetResponses()[1].GetUser().GetId().GetValue())
+		assert.NoError(t, err)
+		assert.False(t, saved.MustChangePassword)
+	})
+
+	t.Run("宽松模式-未要求修改密码-失败", func(t *testing.T) {
+		resp := suit.UserServer().ImportUsers(suit.DefaultCtx, []*apisecurity.User{
+			newImportUser("import-lenient-0", "123"),
+		}, auth.ImportOption{SkipPasswordCheck: true})
+		assert.Equal(t, api.InvalidParameter, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+		assert.Empty(t, resp.GetResponses())
+	})
+
+	t.Run("宽松模式-弱密码导入成功并且必须修改密码", func(t *testing.T) {
+		resp := suit.UserServer().ImportUsers(suit.DefaultCtx, []*apisecurity.User{
+			newImportUser("import-lenient-1", "123"),
+			newImportUser("import-lenient-2", ""),
+		}, auth.ImportOption{SkipPasswordCheck: true, MustChangePassword: true})
+		assert.Len(t, resp.GetResponses(), 2)
+		assert.Equal(t, api.ExecuteSuccess, resp.GetResponses()[0].GetCode().GetValue(),
+			resp.GetResponses()[0].GetInfo().GetValue())
+		assert.Equal(t, api.InvalidUserPassword, resp.GetResponses()[1].GetCode().GetValue())
+
+		saved, err := suit.Storage.GetUser(resp.GetResponses()[0].GetUser().GetId().GetValue())
+		assert.NoError(t, err)
+		assert.True(t, saved.MustChangePassword)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(saved.Password), []byte("123")))
+	})
+
+	t.Run("不支持的摘要算法", func(t *testing.T) {
+		resp := suit.UserServer().ImportUsers(suit.DefaultCtx, []*apisecurity.User{
+			newImportUser("import-hash-1", "import-hash-1"),
+		}, auth.ImportOption{HashAlgorithm: "md5"})
+		assert.Equal(t, api.InvalidParameter, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+}
+
+func Test_AuthServer_ValidateUsers(t *testing.T) {
+	suit := &AuthTestSuit{}
+	if err := suit.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		suit.cleanAllAuthStrategy()
+		suit.cleanAllUser()
+		suit.cleanAllUserGroup()
+		suit.Destroy()
+	})
+
+	resp := suit.UserServer().CreateUsers(suit.DefaultCtx, []*apisecurity.User{{
+		Name:     utils.NewStringValue("validate-existing"),
+		Password: utils.NewStringValue("validate-pwd"),
+		Source:   utils.NewStringValue("Polaris"),
+	}})
+	if !respSuccess(resp) {
+		t.Fatal(resp.GetInfo().GetValue())
+	}
+
+	users := []*model.User{
+		{Name: "validate-new", Password: "validate-pwd", Source: "Polaris"},
+		{Name: "", Password: "validate-pwd", Source: "Polaris"},
+		{Name: "validate-short-pwd", Password: "123", Source: "Polaris"},
+		{Name: "validate-existing", Password: "validate-pwd", Source: "Polaris"},
+		{Name: "validate-new", Password: "validate-pwd", Source: "Polaris"},
+		nil,
+	}
+
+	t.Run("逐个返回校验结果", func(t *testing.T) {
+		results := suit.UserServer().ValidateUsers(suit.DefaultCtx, users)
+		assert.Equal(t, len(users), len(results))
+		expect := []apimodel.Code{
+			apimodel.Code_ExecuteSuccess,
+			apimodel.Code_InvalidUserName,
+			apimodel.Code_InvalidUserPassword,
+			apimodel.Code_UserExisted,
+			apimodel.Code_UserExisted,
+			apimodel.Code_EmptyRequest,
+		}
+		for i := range expect {
+			assert.Equal(t, i, results[i].Index)
+			assert.Equal(t, expect[i], results[i].Code, results[i].Info)
+		}
+		assert.Contains(t, results[4].Info, "index 0")
+
+		// 校验不写入任何数据
+		saved, err := suit.Storage.GetUserByName("validate-new", "")
+		assert.NoError(t, err)
+		assert.Nil(t, saved)
+	})
+
+	t.Run("未携带token", func(t *testing.T) {
+		results := suit.UserServer().ValidateUsers(context.Background(), users[:1])
+		assert.Equal(t, 1, len(results))
+		assert.Equal(t, apimodel.Code_EmptyAutToken, results[0].Code)
+		assert.Equal(t, "validate-new", results[0].Name)
+	})
+}
+
+func Test_server_PasswordExpired(t *testing.T) {
+
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	defaultauth.AuthOption.PasswordMaxAgeDays = 30
+	defer func() {
+		defaultauth.AuthOption.PasswordMaxAgeDays = 0
+	}()
+
+	expiredUser := userTest.users[1]
+	userTest.updateCacheUser(t, expiredUser.ID, func(user *model.User) {
+		user.PasswordSetTime = time.Now().Add(-31 * 24 * time.Hour)
+	})
+
+	t.Run("密码过期的用户登录时被标记", func(t *testing.T) {
+		resp := userTest.svr.Login(&apisecurity.LoginRequest{
+			Owner:    utils.NewStringValue(userTest.ownerOne.Name),
+			Name:     utils.NewStringValue(expiredUser.Name),
+			Password: utils.NewStringValue("polaris"),
+		})
+		assert.Equal(t, api.NotAllowedAccess, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+		assert.Contains(t, resp.GetInfo().GetValue(), model.ErrorPasswordExpired.Error())
+		// 仍然返回 token，用于调用修改密码的接口
+		assert.Equal(t, expiredUser.Token, resp.GetLoginResponse().GetToken().GetValue())
+	})
+
+	t.Run("密码未过期的用户正常登录", func(t *testing.T) {
+		resp := userTest.svr.Login(&apisecurity.LoginRequest{
+			Owner:    utils.NewStringValue(userTest.ownerOne.Name),
+			Name:     utils.NewStringValue(userTest.users[2].Name),
+			Password: utils.NewStringValue("polaris"),
+		})
+		assert.Equal(t, api.ExecuteSuccess, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("密码过期的用户不能调用其他接口", func(t *testing.T) {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, expiredUser.Token)
+		resp := userTest.svr.GetUserToken(reqCtx, &apisecurity.User{
+			Id: utils.NewStringValue(expiredUser.ID),
+		})
+		assert.Equal(t, api.NotAllowedAccess, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("密码过期的用户可以修改密码", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Any()).Return(expiredUser, nil)
+
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, expiredUser.Token)
+		resp := userTest.svr.UpdateUserPassword(reqCtx, &apisecurity.ModifyUserPassword{
+			Id:          utils.NewStringValue(expiredUser.ID),
+			OldPassword: utils.NewStringValue("polaris"),
+			NewPassword: utils.NewStringValue("polaris@2021"),
+		})
+		assert.Equal(t, api.ExecuteSuccess, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("被标记为必须修改密码的用户", func(t *testing.T) {
+		flagUser := userTest.users[3]
+		userTest.updateCacheUser(t, flagUser.ID, func(user *model.User) {
+			user.MustChangePassword = true
+		})
+
+		resp := userTest.svr.Login(&apisecurity.LoginRequest{
+			Owner:    utils.NewStringValue(userTest.ownerOne.Name),
+			Name:     utils.NewStringValue(flagUser.Name),
+			Password: utils.NewStringValue("polaris"),
+		})
+		assert.Equal(t, api.NotAllowedAccess, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+}
+
+func Test_server_CreateUserSource(t *testing.T) {
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	newReq := func(source string) []*apisecurity.User {
+		return []*apisecurity.User{
+			{
+				Id:       &wrappers.StringValue{Value: utils.NewUUID()},
+				Name:     &wrappers.StringValue{Value: "create-user-1"},
+				Password: &wrappers.StringValue{Value: "create-user-1"},
+				Source:   &wrappers.StringValue{Value: source},
+			},
+		}
+	}
+	reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.ownerOne.Token)
+
+	t.Run("来源统一为配置中的写法", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.ownerOne.ID)).Return(userTest.ownerOne, nil)
+		req := newReq(" ldap ")
+		resp := userTest.svr.CreateUsers(reqCtx, req)
+		assert.Equal(t, api.ExecuteSuccess, resp.Code.GetValue(), resp.GetInfo().GetValue())
+		assert.Equal(t, "LDAP", req[0].GetSource().GetValue())
+	})
+
+	t.Run("拒绝未知的来源", func(t *testing.T) {
+		resp := userTest.svr.CreateUsers(reqCtx, newReq("unknown"))
+		assert.Equal(t, api.InvalidParameter, resp.Responses[0].Code.GetValue(), "create users must fail")
+	})
+
+	t.Run("允许未知的来源", func(t *testing.T) {
+		defaultauth.AuthOption.AllowUnknownUserSource = true
+		defer func() {
+			defaultauth.AuthOption.AllowUnknownUserSource = false
+		}()
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.ownerOne.ID)).Return(userTest.ownerOne, nil)
+		req := newReq("unknown")
+		resp := userTest.svr.CreateUsers(reqCtx, req)
+		assert.Equal(t, api.ExecuteSuccess, resp.Code.GetValue(), resp.GetInfo().GetValue())
+		assert.Equal(t, "unknown", req[0].GetSource().GetValue())
+	})
+}
+
+func Test_server_CreateUserOwnerPasswordPolicy(t *testing.T) {
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	defaultauth.AuthOption.OwnerPasswordPolicies = map[string]defaultauth.PasswordPolicy{
+		userTest.ownerOne.ID: {MinLength: 12, MaxLength: 32, RequireDigit: true},
+		userTest.ownerTwo.ID: {MaxLength: 10},
+	}
+	defer func() {
+		defaultauth.AuthOption.OwnerPasswordPolicies = nil
+	}()
+
+	newReq := func(password string) []*apisecurity.User {
+		return []*apisecurity.User{
+			{
+				Id:       &wrappers.StringValue{Value: utils.NewUUID()},
+				Name:     &wrappers.StringValue{Value: "create-user-1"},
+				Password: &wrappers.StringValue{Value: password},
+			},
+		}
+	}
+	ownerOneCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.ownerOne.Token)
+	ownerTwoCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.ownerTwo.Token)
+
+	t.Run("满足一个主账户的规则但不满足另一个主账户的规则", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.ownerOne.ID)).Return(userTest.ownerOne, nil)
+		resp := userTest.svr.CreateUsers(ownerOneCtx, newReq("polaris-pwd-1"))
+		assert.Equal(t, api.ExecuteSuccess, resp.Code.GetValue(), resp.GetInfo().GetValue())
+
+		resp = userTest.svr.CreateUsers(ownerTwoCtx, newReq("polaris-pwd-1"))
+		assert.Equal(t, api.InvalidUserPassword, resp.Responses[0].Code.GetValue(), "create users must fail")
+	})
+
+	t.Run("反过来同样按照各自主账户的规则校验", func(t *testing.T) {
+		userTest.storage.EXPECT().GetUser(gomock.Eq(userTest.ownerTwo.ID)).Return(userTest.ownerTwo, nil)
+		resp := userTest.svr.CreateUsers(ownerTwoCtx, newReq("polaris1"))
+		assert.Equal(t, api.ExecuteSuccess, resp.Code.GetValue(), resp.GetInfo().GetValue())
+
+		resp = userTest.svr.CreateUsers(ownerOneCtx, newReq("polaris1"))
+		assert.Equal(t, api.InvalidUserPassword, resp.Responses[0].Code.GetValue(), "create users must fail")
+		// 长度满足但是缺少数字
+		resp = userTest.svr.CreateUsers(ownerOneCtx, newReq("polaris-password"))
+		assert.Equal(t, api.InvalidUserPassword, resp.Responses[0].Code.GetValue(), "create users must fail")
+	})
+}
+
+func Test_server_LogTokenFailures(t *testing.T) {
+
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	readLog := captureAuthLog(t)
+	defaultauth.AuthOption.LogTokenFailures = true
+
+	owner := userTest.ownerOne
+	saved := map[string]*model.UserToken{}
+	userTest.storage.EXPECT().GetUser(gomock.Eq(owner.ID)).AnyTimes().Return(owner, nil)
+	userTest.storage.EXPECT().AddUserToken(gomock.Any()).AnyTimes().DoAndReturn(func(token *model.UserToken) error {
+		saved[token.ID] = token
+		return nil
+	})
+	userTest.storage.EXPECT().GetUserTokenByID(gomock.Any()).AnyTimes().DoAndReturn(
+		func(id string) (*model.UserToken, error) {
+			return saved[id], nil
+		})
+	ownerCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, owner.Token)
+
+	t.Run("只读的附加token执行写操作", func(t *testing.T) {
+		token, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "ci", model.UserTokenReadOnly, 0)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token.Token)
+		_, code = userTest.svr.CreateScopedToken(reqCtx, owner.ID, "other", model.UserTokenReadOnly, 0)
+		assert.Equal(t, apimodel.Code_NotAllowedAccess, code)
+
+		content := readLog()
+		assert.Contains(t, content, `"event": "token_verification_failure"`)
+		assert.Contains(t, content, `"reason": "read_only"`)
+		assert.NotContains(t, content, token.Token)
+	})
+
+	t.Run("被禁用的token执行写操作", func(t *testing.T) {
+		userTest.updateCacheUser(t, owner.ID, func(user *model.User) {
+			user.TokenEnable = false
+		})
+		defer userTest.updateCacheUser(t, owner.ID, func(user *model.User) {
+			user.TokenEnable = true
+		})
+
+		_, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "disabled", model.UserTokenReadOnly, 0)
+		assert.Equal(t, apimodel.Code_TokenDisabled, code)
+
+		content := readLog()
+		assert.Contains(t, content, `"reason": "disabled"`)
+		assert.NotContains(t, content, owner.Token)
+	})
+}
+
+func Test_server_ScopedToken(t *testing.T) {
+
+	userTest := newUserTest(t)
+	defer userTest.Clean()
+
+	owner := userTest.ownerOne
+	saved := map[string]*model.UserToken{}
+	userTest.storage.EXPECT().GetUser(gomock.Eq(owner.ID)).AnyTimes().Return(owner, nil)
+	userTest.storage.EXPECT().AddUserToken(gomock.Any()).AnyTimes().DoAndReturn(func(token *model.UserToken) error {
+		saved[token.ID] = token
+		return nil
+	})
+	lookups := 0
+	userTest.storage.EXPECT().GetUserTokenByID(gomock.Any()).AnyTimes().DoAndReturn(
+		func(id string) (*model.UserToken, error) {
+			lookups++
+			return saved[id], nil
+		})
+	userTest.storage.EXPECT().ListUserTokens(gomock.Eq(owner.ID)).AnyTimes().Return([]*model.UserToken{}, nil)
+
+	ownerCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, owner.Token)
+
+	t.Run("参数不合法", func(t *testing.T) {
+		_, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "ci", "admin", 0)
+		assert.Equal(t, apimodel.Code_InvalidParameter, code)
+		_, code = userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "ci", model.UserTokenReadOnly, -time.Second)
+		assert.Equal(t, apimodel.Code_InvalidParameter, code)
+	})
+
+	t.Run("子账户不能为其他用户创建附加token", func(t *testing.T) {
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, userTest.users[1].Token)
+		_, code := userTest.svr.CreateScopedToken(reqCtx, owner.ID, "ci", model.UserTokenReadOnly, 0)
+		assert.Equal(t, apimodel.Code_NotAllowedAccess, code)
+	})
+
+	t.Run("只读的附加token只能执行读操作", func(t *testing.T) {
+		token, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "ci", model.UserTokenReadOnly, time.Hour)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+		assert.NotEmpty(t, token.Token)
+		assert.Equal(t, owner.ID, token.UserID)
+
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token.Token)
+		_, code = userTest.svr.ListScopedTokens(reqCtx, owner.ID)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+
+		_, code = userTest.svr.CreateScopedToken(reqCtx, owner.ID, "other", model.UserTokenReadWrite, 0)
+		assert.Equal(t, apimodel.Code_NotAllowedAccess, code)
+		assert.Equal(t, apimodel.Code_NotAllowedAccess,
+			userTest.svr.RevokeScopedToken(reqCtx, owner.ID, token.ID))
+
+		resp := userTest.svr.UpdateUser(reqCtx, &apisecurity.User{
+			Id:      utils.NewStringValue(owner.ID),
+			Comment: utils.NewStringValue("read only"),
+		})
+		assert.Equal(t, api.NotAllowedAccess, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+		resp = userTest.svr.GetUserToken(reqCtx, &apisecurity.User{Id: utils.NewStringValue(owner.ID)})
+		assert.Equal(t, api.NotAllowedAccess, resp.GetCode().GetValue(), resp.GetInfo().GetValue())
+	})
+
+	t.Run("读写的附加token可以执行写操作", func(t *testing.T) {
+		token, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "deploy", model.UserTokenReadWrite, 0)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token.Token)
+		_, code = userTest.svr.CreateScopedToken(reqCtx, owner.ID, "other", model.UserTokenReadOnly, 0)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+	})
+
+	t.Run("附加token的查询结果被缓存", func(t *testing.T) {
+		token, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "cached", model.UserTokenReadOnly, 0)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+
+		before := lookups
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token.Token)
+		for i := 0; i < 3; i++ {
+			_, code = userTest.svr.ListScopedTokens(reqCtx, owner.ID)
+			assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+		}
+		assert.Equal(t, before+1, lookups)
+	})
+
+	t.Run("过期的附加token不能使用", func(t *testing.T) {
+		token, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "expired", model.UserTokenReadOnly, time.Hour)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+		saved[token.ID].ExpireTime = time.Now().Add(-time.Second)
+
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token.Token)
+		_, code = userTest.svr.ListScopedTokens(reqCtx, owner.ID)
+		assert.Equal(t, apimodel.Code_TokenNotExisted, code)
+
+		// 过期的 token 与不存在的 token 返回不同的结果
+		expiredResp := userTest.svr.GetUserToken(reqCtx, &apisecurity.User{Id: utils.NewStringValue(owner.ID)})
+		assert.Contains(t, expiredResp.GetInfo().GetValue(), model.ErrorTokenExpired.Error())
+
+		unknown, err := defaultauth.TestCreateToken(utils.NewUUID(), "")
+		assert.NoError(t, err)
+		unknownCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, unknown)
+		unknownResp := userTest.svr.GetUserToken(unknownCtx, &apisecurity.User{Id: utils.NewStringValue(owner.ID)})
+		assert.Equal(t, api.TokenNotExisted, unknownResp.GetCode().GetValue())
+		assert.NotContains(t, unknownResp.GetInfo().GetValue(), model.ErrorTokenExpired.Error())
+		assert.NotEqual(t, expiredResp.GetInfo().GetValue(), unknownResp.GetInfo().GetValue())
+	})
+
+	t.Run("撤销附加token", func(t *testing.T) {
+		token, code := userTest.svr.CreateScopedToken(ownerCtx, owner.ID, "revoked", model.UserTokenReadOnly, 0)
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, code)
+		userTest.storage.EXPECT().RevokeUserToken(gomock.Eq(owner.ID), gomock.Eq(token.ID)).DoAndReturn(
+			func(userId, id string) error {
+				delete(saved, id)
+				return nil
+			})
+		assert.Equal(t, apimodel.Code_ExecuteSuccess, userTest.svr.RevokeScopedToken(ownerCtx, owner.ID, token.ID))
+
+		reqCtx := context.WithValue(context.Background(), utils.ContextAuthTokenKey, token.Token)
+		_, code = userTest.svr.ListScopedTokens(reqCtx, owner.ID)
+		assert.Equal(t, apimodel.Code_TokenNotExisted, code)
+	})
+}
@@ -20,16 +20,21 @@ package defaultauth
 import (
 	"context"
 	"errors"
+	"fmt"
 	"regexp"
+	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/golang/protobuf/ptypes/wrappers"
 	apimodel "github.com/polarismesh/specification/source/go/api/v1/model"
 	apiservice "github.com/polarismesh/specification/source/go/api/v1/service_manage"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 
 	api "github.com/polarismesh/polaris/common/api/v1"
 	"github.com/polarismesh/polaris/common/model"
+	authcommon "github.com/polarismesh/polaris/common/model/auth"
 	"github.com/polarismesh/polaris/common/utils"
 )
 
@@ -44,6 +49,18 @@ var (
 	ReadOp = false
 )
 
+const (
+	// PasswordHashBcrypt bcrypt 算法计算的密码摘要
+	PasswordHashBcrypt = "bcrypt"
+)
+
+const (
+	// UserQueryScopeOwner 查询用户列表的默认范围，非超级管理员只能查询所属主账户下的用户
+	UserQueryScopeOwner = "owner"
+	// UserQueryScopeAll 超级管理员跨主账户搜索用户，忽略 owner 过滤条件
+	UserQueryScopeAll = "all"
+)
+
 var (
 	regNameStr = regexp.MustCompile("^[\u4E00-\u9FA5A-Za-z0-9_\\-.]+$")
 	regEmail   = regexp.MustCompile(`^\w+([-+.]\w+)*@\w+([-.]\w+)*\.\w+([-.]\w+)*$`)
@@ -67,15 +84,30 @@ func checkName(name *wrappers.StringValue) error {
 		return errors.New("name too long")
 	}
 
-	if ok := regNameStr.MatchString(name.GetValue()); !ok {
+	if ok := matchNamePolicy(name.GetValue()); !ok {
 		return errors.New("name contains invalid character")
 	}
 
 	return nil
 }
 
-// checkPassword 密码检查
-func checkPassword(password *wrappers.StringValue) error {
+// matchNamePolicy 按照配置的 NamePolicy 检查名称中的字符
+func matchNamePolicy(name string) bool {
+	if AuthOption.NamePolicy != NamePolicyUnicode {
+		return regNameStr.MatchString(name)
+	}
+	// 允许任意语言的文字，控制字符、空白、emoji 等其他字符依然不允许
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// checkPassword 按照 owner 对应的密码规则检查密码，owner 为空时使用全局的密码规则
+func checkPassword(password *wrappers.StringValue, owner string) error {
 	if password == nil {
 		return errors.New(utils.NilErrString)
 	}
@@ -84,11 +116,36 @@ func checkPassword(password *wrappers.StringValue) error {
 		return errors.New(utils.EmptyErrString)
 	}
 
-	if pLen := len(password.GetValue()); pLen < 6 || pLen > 17 {
-		return errors.New("password len need 6 ~ 17")
+	return AuthOption.PasswordPolicyOf(owner).Check(password.GetValue())
+}
+
+// passwordPolicyOwner 用户的密码规则所属的主账户，子账户为其 owner，主账户为自身
+func passwordPolicyOwner(user *model.User) string {
+	if user.Type == model.SubAccountUserRole {
+		return user.Owner
+	}
+	return user.ID
+}
+
+// checkHashedPassword 检查迁移用户时传入的密码摘要格式
+func checkHashedPassword(password *wrappers.StringValue, algorithm string) error {
+	if password == nil {
+		return errors.New(utils.NilErrString)
 	}
 
-	return nil
+	if password.GetValue() == "" {
+		return errors.New(utils.EmptyErrString)
+	}
+
+	switch algorithm {
+	case PasswordHashBcrypt:
+		if _, err := bcrypt.Cost([]byte(password.GetValue())); err != nil {
+			return fmt.Errorf("invalid bcrypt password hash: %w", err)
+		}
+		return nil
+	default:
+		return errors.New("unsupported password hash algorithm: " + algorithm)
+	}
 }
 
 // checkOwner 检查用户的 owner 信息
@@ -142,9 +199,28 @@ func checkEmail(email *wrappers.StringValue) error {
 	return nil
 }
 
+// maskToken 只保留 token 首尾各 4 个字符，长度不超过 8 的 token 全部隐藏
+func maskToken(token string) string {
+	if len(token) <= 8 {
+		return "****"
+	}
+	return token[:4] + "****" + token[len(token)-4:]
+}
+
 // verifyAuth 用于 user、group 以及 strategy 模块的鉴权工作检查
 func verifyAuth(ctx context.Context, isWrite bool,
 	needOwner bool, authMgn *DefaultAuthChecker) (context.Context, *apiservice.Response) {
+	return doVerifyAuth(ctx, isWrite, needOwner, false, authMgn)
+}
+
+// verifyAuthForPasswordChange 修改密码时的鉴权，密码已过期的用户也允许操作
+func verifyAuthForPasswordChange(ctx context.Context, authMgn *DefaultAuthChecker) (context.Context,
+	*apiservice.Response) {
+	return doVerifyAuth(ctx, ReadOp, NotOwner, true, authMgn)
+}
+
+func doVerifyAuth(ctx context.Context, isWrite bool, needOwner bool, allowPasswordExpired bool,
+	authMgn *DefaultAuthChecker) (context.Context, *apiservice.Response) {
 	reqId := utils.ParseRequestID(ctx)
 	authToken := utils.ParseAuthToken(ctx)
 
@@ -165,7 +241,7 @@ func verifyAuth(ctx context.Context, isWrite bool,
 	if err := authMgn.VerifyCredential(authCtx); err != nil {
 		log.Error("[Auth][Server] verify auth token", utils.ZapRequestID(reqId),
 			zap.Error(err))
-		return nil, api.NewAuthResponse(apimodel.Code_AuthTokenForbidden)
+		return nil, credentialErrResponse(err)
 	}
 
 	tokenInfo := authCtx.GetAttachment(model.TokenDetailInfoKey).(OperatorInfo)
@@ -173,9 +249,21 @@ func verifyAuth(ctx context.Context, isWrite bool,
 	if isWrite && tokenInfo.Disable {
 		log.Error("[Auth][Server] token is disabled", utils.ZapRequestID(reqId),
 			zap.String("operation", authCtx.GetMethod()))
+		if AuthOption.LogTokenFailures {
+			logTokenFailure(authCtx, authToken, model.ErrorTokenDisabled)
+		}
 		return nil, api.NewAuthResponse(apimodel.Code_TokenDisabled)
 	}
 
+	if isWrite && tokenInfo.IsReadOnly() {
+		log.Error("[Auth][Server] token is read only", utils.ZapRequestID(reqId),
+			zap.String("token-id", tokenInfo.TokenID))
+		if AuthOption.LogTokenFailures {
+			logTokenFailure(authCtx, authToken, model.ErrorTokenReadOnly)
+		}
+		return nil, api.NewAuthResponseWithMsg(apimodel.Code_NotAllowedAccess, model.ErrorTokenReadOnly.Error())
+	}
+
 	if !tokenInfo.IsUserToken {
 		log.Error("[Auth][Server] only user role can access this API", utils.ZapRequestID(reqId))
 		return nil, api.NewAuthResponse(apimodel.Code_OperationRoleForbidden)
@@ -186,5 +274,56 @@ func verifyAuth(ctx context.Context, isWrite bool,
 		return nil, api.NewAuthResponse(apimodel.Code_OperationRoleForbidden)
 	}
 
+	// 密码已过期的用户只能进行修改密码的操作
+	if !allowPasswordExpired && authMgn.cacheMgn != nil {
+		user := authMgn.cacheMgn.User().GetUserByID(tokenInfo.OperatorID)
+		if user != nil && isPasswordExpired(user, AuthOption.PasswordMaxAge(), time.Now()) {
+			log.Error("[Auth][Server] user password expired", utils.ZapRequestID(reqId),
+				zap.String("user", tokenInfo.OperatorID))
+			return nil, api.NewAuthResponseWithMsg(apimodel.Code_NotAllowedAccess, model.ErrorPasswordExpired.Error())
+		}
+	}
+
+	authMgn.loginRecorder.Record(tokenInfo.OperatorID)
 	return authCtx.GetRequestContext(), nil
 }
+
+// verifyTokenWritable 按照读操作鉴权、但是会修改数据或者返回完整权限 token 的接口，不允许只读的附加 token 调用
+func verifyTokenWritable(ctx context.Context) *apiservice.Response {
+	if authcommon.ParseTokenScope(ctx) != model.UserTokenReadOnly {
+		return nil
+	}
+	log.Error("[Auth][Server] token is read only", utils.RequestID(ctx))
+	return api.NewAuthResponseWithMsg(apimodel.Code_NotAllowedAccess, model.ErrorTokenReadOnly.Error())
+}
+
+// credentialErrResponse token 校验失败时返回的结果，区分以下几种情况：
+//
+//	case 1. token 对应的用户或用户组不存在，token 已经被重置：TokenNotExisted
+//	case 2. 附加 token 已经过期：TokenNotExisted，并在 info 中说明 token 已经过期
+//	case 3. token 无法解析等其他情况：AuthTokenForbidden
+//
+// token 被禁用时校验本身是成功的，由 verifyAuth 在写操作时返回 TokenDisabled
+func credentialErrResponse(err error) *apiservice.Response {
+	switch {
+	case errors.Is(err, model.ErrorTokenExpired):
+		return api.NewAuthResponseWithMsg(apimodel.Code_TokenNotExisted, model.ErrorTokenExpired.Error())
+	case errors.Is(err, model.ErrorTokenNotExist), errors.Is(err, model.ErrorNoUser),
+		errors.Is(err, model.ErrorNoUserGroup):
+		return api.NewAuthResponse(apimodel.Code_TokenNotExisted)
+	default:
+		return api.NewAuthResponse(apimodel.Code_AuthTokenForbidden)
+	}
+}
+
+// isPasswordExpired 用户被标记为必须修改密码，或者密码超过了有效期
+// 密码修改时间未知的用户不参与有效期的计算
+func isPasswordExpired(user *model.User, maxAge time.Duration, now time.Time) bool {
+	if user.MustChangePassword {
+		return true
+	}
+	if maxAge <= 0 || user.PasswordSetTime.IsZero() {
+		return false
+	}
+	return now.Sub(user.PasswordSetTime) > maxAge
+}
@@ -18,9 +18,11 @@
 package defaultauth_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/polarismesh/polaris/auth/defaultauth"
 	"github.com/polarismesh/polaris/common/utils"
@@ -105,6 +107,41 @@ func Test_checkPassword(t *testing.T) {
 	}
 }
 
+func Test_checkNamePolicy(t *testing.T) {
+	defer func(policy string) {
+		defaultauth.AuthOption.NamePolicy = policy
+	}(defaultauth.AuthOption.NamePolicy)
+
+	t.Run("默认只允许中文以及英文字母", func(t *testing.T) {
+		defaultauth.AuthOption.NamePolicy = defaultauth.NamePolicyDefault
+		assert.NoError(t, defaultauth.TestCheckName(utils.NewStringValue("测试用户_1")))
+		assert.Error(t, defaultauth.TestCheckName(utils.NewStringValue("José")))
+		assert.Error(t, defaultauth.TestCheckName(utils.NewStringValue("Дмитрий")))
+	})
+
+	t.Run("unicode 允许任意语言的文字", func(t *testing.T) {
+		defaultauth.AuthOption.NamePolicy = defaultauth.NamePolicyUnicode
+		assert.NoError(t, defaultauth.TestCheckName(utils.NewStringValue("José")))
+		assert.NoError(t, defaultauth.TestCheckName(utils.NewStringValue("Дмитрий-1")))
+		assert.NoError(t, defaultauth.TestCheckName(utils.NewStringValue("測試_user.1")))
+		// 控制字符、空白以及 emoji 依然不允许
+		assert.Error(t, defaultauth.TestCheckName(utils.NewStringValue("José\n")))
+		assert.Error(t, defaultauth.TestCheckName(utils.NewStringValue("José Luis")))
+		assert.Error(t, defaultauth.TestCheckName(utils.NewStringValue("José😀")))
+		// 保留的名称以及长度限制
+		assert.Error(t, defaultauth.TestCheckName(utils.NewStringValue("polariadmin")))
+		assert.Error(t, defaultauth.TestCheckName(utils.NewStringValue(strings.Repeat("é", utils.MaxNameLength+1))))
+	})
+
+	t.Run("非法的配置", func(t *testing.T) {
+		cfg := defaultauth.DefaultAuthConfig()
+		cfg.NamePolicy = "ascii"
+		assert.Error(t, cfg.Verify())
+		cfg.NamePolicy = defaultauth.NamePolicyUnicode
+		assert.NoError(t, cfg.Verify())
+	})
+}
+
 func Test_checkName(t *testing.T) {
 	type args struct {
 		name *wrappers.StringValue
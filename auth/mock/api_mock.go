@@ -7,8 +7,10 @@ package mock
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	model0 "github.com/polarismesh/specification/source/go/api/v1/model"
 	security "github.com/polarismesh/specification/source/go/api/v1/security"
 	service_manage "github.com/polarismesh/specification/source/go/api/v1/service_manage"
 
@@ -551,6 +553,35 @@ func (m *MockUserServer) EXPECT() *MockUserServerMockRecorder {
 	return m.recorder
 }
 
+// AddUserWithHashedPassword mocks base method.
+func (m *MockUserServer) AddUserWithHashedPassword(ctx context.Context, user *security.User, algorithm string) *service_manage.Response {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddUserWithHashedPassword", ctx, user, algorithm)
+	ret0, _ := ret[0].(*service_manage.Response)
+	return ret0
+}
+
+// AddUserWithHashedPassword indicates an expected call of AddUserWithHashedPassword.
+func (mr *MockUserServerMockRecorder) AddUserWithHashedPassword(ctx, user, algorithm interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUserWithHashedPassword", reflect.TypeOf((*MockUserServer)(nil).AddUserWithHashedPassword), ctx, user, algorithm)
+}
+
+// CreateScopedToken mocks base method.
+func (m *MockUserServer) CreateScopedToken(ctx context.Context, userId, name string, scope model.UserTokenScope, ttl time.Duration) (*model.UserToken, model0.Code) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateScopedToken", ctx, userId, name, scope, ttl)
+	ret0, _ := ret[0].(*model.UserToken)
+	ret1, _ := ret[1].(model0.Code)
+	return ret0, ret1
+}
+
+// CreateScopedToken indicates an expected call of CreateScopedToken.
+func (mr *MockUserServerMockRecorder) CreateScopedToken(ctx, userId, name, scope, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateScopedToken", reflect.TypeOf((*MockUserServer)(nil).CreateScopedToken), ctx, userId, name, scope, ttl)
+}
+
 // CreateUsers mocks base method.
 func (m *MockUserServer) CreateUsers(ctx context.Context, users []*security.User) *service_manage.BatchWriteResponse {
 	m.ctrl.T.Helper()
@@ -607,6 +638,49 @@ func (mr *MockUserServerMockRecorder) GetUsers(ctx, query interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsers", reflect.TypeOf((*MockUserServer)(nil).GetUsers), ctx, query)
 }
 
+// ImportUsers mocks base method.
+func (m *MockUserServer) ImportUsers(ctx context.Context, users []*security.User, opt auth.ImportOption) *service_manage.BatchWriteResponse {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportUsers", ctx, users, opt)
+	ret0, _ := ret[0].(*service_manage.BatchWriteResponse)
+	return ret0
+}
+
+// ImportUsers indicates an expected call of ImportUsers.
+func (mr *MockUserServerMockRecorder) ImportUsers(ctx, users, opt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportUsers", reflect.TypeOf((*MockUserServer)(nil).ImportUsers), ctx, users, opt)
+}
+
+// ListScopedTokens mocks base method.
+func (m *MockUserServer) ListScopedTokens(ctx context.Context, userId string) ([]*model.UserToken, model0.Code) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListScopedTokens", ctx, userId)
+	ret0, _ := ret[0].([]*model.UserToken)
+	ret1, _ := ret[1].(model0.Code)
+	return ret0, ret1
+}
+
+// ListScopedTokens indicates an expected call of ListScopedTokens.
+func (mr *MockUserServerMockRecorder) ListScopedTokens(ctx, userId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListScopedTokens", reflect.TypeOf((*MockUserServer)(nil).ListScopedTokens), ctx, userId)
+}
+
+// ResetCredentials mocks base method.
+func (m *MockUserServer) ResetCredentials(ctx context.Context, userId, newPassword, newToken string) *service_manage.Response {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCredentials", ctx, userId, newPassword, newToken)
+	ret0, _ := ret[0].(*service_manage.Response)
+	return ret0
+}
+
+// ResetCredentials indicates an expected call of ResetCredentials.
+func (mr *MockUserServerMockRecorder) ResetCredentials(ctx, userId, newPassword, newToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCredentials", reflect.TypeOf((*MockUserServer)(nil).ResetCredentials), ctx, userId, newPassword, newToken)
+}
+
 // ResetUserToken mocks base method.
 func (m *MockUserServer) ResetUserToken(ctx context.Context, user *security.User) *service_manage.Response {
 	m.ctrl.T.Helper()
@@ -635,6 +709,34 @@ func (mr *MockUserServerMockRecorder) UpdateUser(ctx, user interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockUserServer)(nil).UpdateUser), ctx, user)
 }
 
+// RenameUser mocks base method.
+func (m *MockUserServer) RenameUser(ctx context.Context, userId, newName string) *service_manage.Response {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameUser", ctx, userId, newName)
+	ret0, _ := ret[0].(*service_manage.Response)
+	return ret0
+}
+
+// RenameUser indicates an expected call of RenameUser.
+func (mr *MockUserServerMockRecorder) RenameUser(ctx, userId, newName interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameUser", reflect.TypeOf((*MockUserServer)(nil).RenameUser), ctx, userId, newName)
+}
+
+// RevokeScopedToken mocks base method.
+func (m *MockUserServer) RevokeScopedToken(ctx context.Context, userId, tokenId string) model0.Code {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeScopedToken", ctx, userId, tokenId)
+	ret0, _ := ret[0].(model0.Code)
+	return ret0
+}
+
+// RevokeScopedToken indicates an expected call of RevokeScopedToken.
+func (mr *MockUserServerMockRecorder) RevokeScopedToken(ctx, userId, tokenId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeScopedToken", reflect.TypeOf((*MockUserServer)(nil).RevokeScopedToken), ctx, userId, tokenId)
+}
+
 // UpdateUserPassword mocks base method.
 func (m *MockUserServer) UpdateUserPassword(ctx context.Context, req *security.ModifyUserPassword) *service_manage.Response {
 	m.ctrl.T.Helper()
@@ -663,6 +765,20 @@ func (mr *MockUserServerMockRecorder) UpdateUserToken(ctx, user interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserToken", reflect.TypeOf((*MockUserServer)(nil).UpdateUserToken), ctx, user)
 }
 
+// ValidateUsers mocks base method.
+func (m *MockUserServer) ValidateUsers(ctx context.Context, users []*model.User) []auth.ValidationResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ValidateUsers", ctx, users)
+	ret0, _ := ret[0].([]auth.ValidationResult)
+	return ret0
+}
+
+// ValidateUsers indicates an expected call of ValidateUsers.
+func (mr *MockUserServerMockRecorder) ValidateUsers(ctx, users interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateUsers", reflect.TypeOf((*MockUserServer)(nil).ValidateUsers), ctx, users)
+}
+
 // MockGroupOperator is a mock of GroupOperator interface.
 type MockGroupOperator struct {
 	ctrl     *gomock.Controller
@@ -891,6 +1007,21 @@ func (mr *MockStrategyServerMockRecorder) GetStrategy(ctx, strategy interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStrategy", reflect.TypeOf((*MockStrategyServer)(nil).GetStrategy), ctx, strategy)
 }
 
+// GetUserPermissionSummary mocks base method.
+func (m *MockStrategyServer) GetUserPermissionSummary(ctx context.Context, userId string) (*auth.PermissionSummary, model0.Code) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserPermissionSummary", ctx, userId)
+	ret0, _ := ret[0].(*auth.PermissionSummary)
+	ret1, _ := ret[1].(model0.Code)
+	return ret0, ret1
+}
+
+// GetUserPermissionSummary indicates an expected call of GetUserPermissionSummary.
+func (mr *MockStrategyServerMockRecorder) GetUserPermissionSummary(ctx, userId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserPermissionSummary", reflect.TypeOf((*MockStrategyServer)(nil).GetUserPermissionSummary), ctx, userId)
+}
+
 // UpdateStrategies mocks base method.
 func (m *MockStrategyServer) UpdateStrategies(ctx context.Context, reqs []*security.ModifyAuthStrategy) *service_manage.BatchWriteResponse {
 	m.ctrl.T.Helper()
@@ -515,6 +515,8 @@ type (
 		IsUserInGroup(userId, groupId string) bool
 		// IsOwner
 		IsOwner(id string) bool
+		// GetMainAccount 获取用户所属的主账户，主账户返回自身
+		GetMainAccount(userId string) *model.User
 		// GetUserLinkGroupIds
 		GetUserLinkGroupIds(id string) []string
 	}
@@ -180,12 +180,19 @@ func (uc *userCache) handlerUserCacheUpdate(lastMimes map[string]time.Time, ret
 		}
 
 		owner := ownerSupplier(user)
+		if owner == nil {
+			// 所属的主账户不存在，只能通过用户 ID 进行查找
+			log.Warn("[Cache][User] owner of user not found", zap.String("id", user.ID),
+				zap.String("owner", user.Owner))
+		}
 		if !user.Valid {
 			// 删除 user-id -> user 的缓存
 			// 删除 username + ownername -> user 的缓存
 			// 删除 user-id -> group-ids 的缓存
 			uc.users.Delete(user.ID)
-			uc.name2Users.Delete(fmt.Sprintf(NameLinkOwnerTemp, owner.Name, user.Name))
+			if owner != nil {
+				uc.name2Users.Delete(fmt.Sprintf(NameLinkOwnerTemp, owner.Name, user.Name))
+			}
 			// uc.user2Groups.Delete(user.ID)
 			ret.userDel++
 		} else {
@@ -195,7 +202,9 @@ func (uc *userCache) handlerUserCacheUpdate(lastMimes map[string]time.Time, ret
 				ret.userAdd++
 			}
 			uc.users.Store(user.ID, user)
-			uc.name2Users.Store(fmt.Sprintf(NameLinkOwnerTemp, owner.Name, user.Name), user)
+			if owner != nil {
+				uc.name2Users.Store(fmt.Sprintf(NameLinkOwnerTemp, owner.Name, user.Name), user)
+			}
 		}
 	}
 
@@ -294,6 +303,22 @@ func (uc *userCache) IsOwner(id string) bool {
 	return ut == model.AdminUserRole || ut == model.OwnerUserRole
 }
 
+// GetMainAccount 获取用户所属的主账户，主账户返回自身，用户或者其所属的主账户不存在时返回 nil
+func (uc *userCache) GetMainAccount(userId string) *model.User {
+	user := uc.GetUserByID(userId)
+	if user == nil {
+		return nil
+	}
+	if user.IsMainAccount() {
+		return user
+	}
+	owner := uc.GetUserByID(user.Owner)
+	if owner == nil || !owner.IsMainAccount() {
+		return nil
+	}
+	return owner
+}
+
 func (uc *userCache) IsUserInGroup(userId, groupId string) bool {
 	group := uc.GetGroup(groupId)
 	if group == nil {
@@ -117,6 +117,78 @@ func genModelUserGroups(users []*model.User) []*model.UserGroupDetail {
 	return out
 }
 
+// 超级账户需要参与 token 校验，增量同步时不能被过滤，但也不会挂到任何主账户名下
+func TestUserCache_AdminInIncrementalSync(t *testing.T) {
+	ctrl, store, uc := newTestUserCache(t)
+	defer ctrl.Finish()
+
+	users := genModelUsers(10)
+	admin := &model.User{
+		ID:         "admin-user",
+		Name:       "polaris",
+		Password:   "polaris",
+		Source:     "Polaris",
+		Type:       model.AdminUserRole,
+		Token:      "admin-token",
+		Valid:      true,
+		ModifyTime: time.Now(),
+	}
+
+	store.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).Return(append(users, admin), nil).Times(1)
+	store.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+	assert.NoError(t, uc.Update())
+
+	// 增量批次中只有超级账户的变更
+	updated := *admin
+	updated.Token = "admin-token-new"
+	updated.ModifyTime = time.Now().Add(time.Second)
+	store.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).Return([]*model.User{&updated}, nil).Times(1)
+	store.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+	assert.NoError(t, uc.Update())
+
+	assert.Equal(t, "admin-token-new", uc.GetAdmin().Token)
+	assert.Equal(t, "admin-token-new", uc.GetUserByID(admin.ID).Token)
+	assert.Nil(t, uc.GetUserByName(admin.Name, users[0].Name))
+}
+
+func TestUserCache_GetMainAccount(t *testing.T) {
+	ctrl, store, uc := newTestUserCache(t)
+	defer ctrl.Finish()
+
+	users := genModelUsers(10)
+	orphan := &model.User{
+		ID:     "orphan-user",
+		Name:   "orphan-user",
+		Owner:  "not-exist-owner",
+		Source: "Polaris",
+		Type:   model.SubAccountUserRole,
+		Token:  "orphan-user",
+		Valid:  true,
+	}
+	store.EXPECT().GetUsersForCache(gomock.Any(), gomock.Any()).Return(append(users, orphan), nil).Times(1)
+	store.EXPECT().GetGroupsForCache(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+	assert.NoError(t, uc.Update())
+
+	t.Run("主账户返回自身", func(t *testing.T) {
+		assert.True(t, users[0].IsMainAccount())
+		assert.Equal(t, users[0].ID, users[0].OwnerID())
+		assert.Equal(t, users[0].ID, uc.GetMainAccount(users[0].ID).ID)
+	})
+
+	t.Run("子账户返回所属的主账户", func(t *testing.T) {
+		assert.False(t, users[1].IsMainAccount())
+		assert.Equal(t, users[0].ID, users[1].OwnerID())
+		assert.Equal(t, users[0].ID, uc.GetMainAccount(users[1].ID).ID)
+	})
+
+	t.Run("所属主账户不存在的子账户", func(t *testing.T) {
+		assert.False(t, orphan.IsMainAccount())
+		assert.NotNil(t, uc.GetUserByID(orphan.ID))
+		assert.Nil(t, uc.GetMainAccount(orphan.ID))
+		assert.Nil(t, uc.GetMainAccount("not-exist-user"))
+	})
+}
+
 func TestUserCache_UpdateNormal(t *testing.T) {
 	ctrl, store, uc := newTestUserCache(t)
 
@@ -2379,6 +2379,20 @@ func (mr *MockUserCacheMockRecorder) GetGroup(id interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGroup", reflect.TypeOf((*MockUserCache)(nil).GetGroup), id)
 }
 
+// GetMainAccount mocks base method.
+func (m *MockUserCache) GetMainAccount(userId string) *model.User {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMainAccount", userId)
+	ret0, _ := ret[0].(*model.User)
+	return ret0
+}
+
+// GetMainAccount indicates an expected call of GetMainAccount.
+func (mr *MockUserCacheMockRecorder) GetMainAccount(userId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMainAccount", reflect.TypeOf((*MockUserCache)(nil).GetMainAccount), userId)
+}
+
 // GetUserByID mocks base method.
 func (m *MockUserCache) GetUserByID(id string) *model.User {
 	m.ctrl.T.Helper()
@@ -49,6 +49,13 @@ func NewAuthBatchWriteResponse(code apimodel.Code) *apiservice.BatchWriteRespons
 	}
 }
 
+// NewAuthBatchWriteResponseWithMsg 创建带详细信息的批量回复
+func NewAuthBatchWriteResponseWithMsg(code apimodel.Code, msg string) *apiservice.BatchWriteResponse {
+	resp := NewAuthBatchWriteResponse(code)
+	resp.Info.Value += ": " + msg
+	return resp
+}
+
 // NewAuthBatchQueryResponse 创建批量查询回复
 func NewAuthBatchQueryResponse(code apimodel.Code) *apiservice.BatchQueryResponse {
 	return &apiservice.BatchQueryResponse{
@@ -18,6 +18,8 @@
 package model
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
@@ -42,6 +44,9 @@ var (
 	// ErrorWrongUsernameOrPassword 用户或者密码错误
 	ErrorWrongUsernameOrPassword error = errors.New("name or password is wrong")
 
+	// ErrorPasswordExpired 密码已过期，需要先修改密码
+	ErrorPasswordExpired error = errors.New("password expired, please change password")
+
 	// ErrorTokenNotExist token 不存在
 	ErrorTokenNotExist error = errors.New("token not exist")
 
@@ -50,10 +55,16 @@ var (
 
 	// ErrorTokenDisabled token 已经被禁用
 	ErrorTokenDisabled error = errors.New("token already disabled")
+
+	// ErrorTokenExpired 用户的附加 token 已经过期
+	ErrorTokenExpired error = errors.New("token already expired")
+
+	// ErrorTokenReadOnly 只读的附加 token 不能执行写操作
+	ErrorTokenReadOnly error = errors.New("token is read only")
 )
 
 func ConvertToErrCode(err error) apimodel.Code {
-	if errors.Is(err, ErrorTokenNotExist) {
+	if errors.Is(err, ErrorTokenNotExist) || errors.Is(err, ErrorTokenExpired) {
 		return apimodel.Code_TokenNotExisted
 	}
 
@@ -78,6 +89,7 @@ const (
 	TokenDetailInfoKey string = "TokenInfo"
 	TokenForUser       string = "uid"
 	TokenForUserGroup  string = "groupid"
+	TokenForUserToken  string = "utid"
 
 	ResourceAttachmentKey string = "resource_attachment"
 )
@@ -218,6 +230,134 @@ type User struct {
 	Comment     string
 	CreateTime  time.Time
 	ModifyTime  time.Time
+	// LastLoginTime 最近一次 token 校验通过的时间，从未登录过时为零值
+	LastLoginTime time.Time
+	// PasswordSetTime 最近一次修改密码的时间，零值表示未知，不参与密码过期计算
+	PasswordSetTime time.Time
+	// MustChangePassword 用户必须先修改密码才能继续操作
+	MustChangePassword bool
+	// TokenRotatedTime 最近一次更换 token 的时间，零值表示未知，比如升级前创建的用户
+	// 按照 ID 或者名称查询单个用户时返回，列表查询只用于过滤，不保证返回
+	TokenRotatedTime time.Time
+	// DeleteTime 用户被删除的时间，未删除或者删除时间未知时为零值
+	DeleteTime time.Time
+	// CreatedBy 创建该用户的操作者的用户 ID，为空时表示未知，比如升级前创建的用户
+	// 按照 ID 或者名称查询单个用户时返回，列表查询只用于过滤，不保证返回
+	CreatedBy string
+	// ModifiedBy 最后一次修改该用户的操作者的用户 ID，为空时表示未知，更新用户时由调用方设置
+	// 只用于写入以及列表查询的过滤，查询用户时不保证返回
+	ModifiedBy string
+	// AdminOperated 本次写入由超级管理员发起，只在写入时由调用方设置，不会持久化
+	AdminOperated bool
+	// GroupIDs 用户所属的有效用户组 ID，只在开启 cacheWithGroups 时由 GetUsersForCache 返回
+	GroupIDs []string
+}
+
+// IsMainAccount 是否为主账户，超级账户同样视为主账户，主账户的 owner 为空或者为自身
+func (u *User) IsMainAccount() bool {
+	if u.Type == SubAccountUserRole {
+		return false
+	}
+	return u.Owner == "" || u.Owner == u.ID
+}
+
+// Revision 根据用户资料计算的版本号，用户资料不变时版本号不变，可以作为 ETag 使用
+// 登录时间、修改时间等不属于用户资料的字段不参与计算
+func (u *User) Revision() string {
+	fields := []string{u.ID, u.Name, u.Password, u.Owner, u.Source, u.Mobile, u.Email,
+		strconv.Itoa(int(u.Type)), u.Token, strconv.FormatBool(u.TokenEnable), u.Comment,
+		strconv.FormatBool(u.MustChangePassword)}
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "%q", fields)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// UserChangeOp 用户变更的操作类型
+type UserChangeOp string
+
+const (
+	// UserChangeCreate 新增用户
+	UserChangeCreate UserChangeOp = "create"
+	// UserChangeUpdate 修改用户
+	UserChangeUpdate UserChangeOp = "update"
+	// UserChangeDelete 删除用户
+	UserChangeDelete UserChangeOp = "delete"
+)
+
+// UserChange 用户的一条变更记录，Seq 严格递增且与变更提交的顺序一致
+type UserChange struct {
+	Seq    uint64
+	Op     UserChangeOp
+	UserID string
+	// Payload 变更后的用户快照，JSON 格式，不包含密码以及 token
+	Payload    string
+	CreateTime time.Time
+}
+
+// UserTokenScope 用户附加 token 的权限范围
+type UserTokenScope string
+
+const (
+	// UserTokenReadOnly 只能执行读操作
+	UserTokenReadOnly UserTokenScope = "read_only"
+	// UserTokenReadWrite 与用户自身的 token 拥有相同的权限
+	UserTokenReadWrite UserTokenScope = "read_write"
+)
+
+// IsValid 是否为支持的权限范围
+func (s UserTokenScope) IsValid() bool {
+	return s == UserTokenReadOnly || s == UserTokenReadWrite
+}
+
+// UserToken 用户的附加 token，一个用户可以有多个按照名称区分的 token，供只读或者有效期受限的集成场景使用
+type UserToken struct {
+	ID     string
+	UserID string
+	// Name 在用户有效的附加 token 中唯一
+	Name  string
+	Token string
+	Scope UserTokenScope
+	// ExpireTime 过期时间，零值表示永不过期
+	ExpireTime time.Time
+	// Valid 为 false 表示 token 已经被撤销
+	Valid      bool
+	CreateTime time.Time
+	ModifyTime time.Time
+}
+
+// IsExpired token 在 now 时是否已经过期
+func (t *UserToken) IsExpired(now time.Time) bool {
+	return !t.ExpireTime.IsZero() && !now.Before(t.ExpireTime)
+}
+
+// UserWithOwner 用户信息以及所属主账户的名称
+type UserWithOwner struct {
+	*User
+	// OwnerName 所属主账户的名称，主账户为自身的名称，主账户不存在时为空
+	OwnerName string
+}
+
+// NewUserWithOwner 组装用户以及主账户名称，主账户自身没有查询到 owner 名称时使用自身的名称
+func NewUserWithOwner(user *User, ownerName string) *UserWithOwner {
+	if ownerName == "" && user.OwnerID() == user.ID {
+		ownerName = user.Name
+	}
+	return &UserWithOwner{User: user, OwnerName: ownerName}
+}
+
+// UserWithSubCount 主账户以及其下有效子账户的个数
+type UserWithSubCount struct {
+	*User
+	// SubCount 有效子账户的个数，不包含主账户自身
+	SubCount uint32
+}
+
+// OwnerID 用户所属主账户的 ID，主账户返回自身的 ID
+func (u *User) OwnerID() string {
+	if u.IsMainAccount() || u.Owner == "" {
+		return u.ID
+	}
+	return u.Owner
 }
 
 // UserGroupDetail 用户组详细（带用户列表）
@@ -270,6 +410,15 @@ type UserGroupRelation struct {
 	ModifyTime time.Time
 }
 
+// UserGroupLink 单条用户-用户组关联关系，用于 cache 增量维护用户与用户组的映射
+type UserGroupLink struct {
+	UserID  string
+	GroupID string
+	// Valid 为 false 表示该关联关系已经被移除
+	Valid      bool
+	ModifyTime time.Time
+}
+
 // StrategyDetail 鉴权策略详细
 type StrategyDetail struct {
 	ID         string
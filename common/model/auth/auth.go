@@ -33,3 +33,13 @@ func ParseUserRole(ctx context.Context) model.UserRoleType {
 	role, _ := ctx.Value(utils.ContextUserRoleIDKey).(model.UserRoleType)
 	return role
 }
+
+// ParseTokenScope 从ctx中解析当前请求使用的 token 的权限范围，为空表示不受限
+func ParseTokenScope(ctx context.Context) model.UserTokenScope {
+	if ctx == nil {
+		return ""
+	}
+
+	scope, _ := ctx.Value(utils.ContextTokenScopeKey).(model.UserTokenScope)
+	return scope
+}
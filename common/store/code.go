@@ -33,6 +33,18 @@ var storeCodeAPICodeMap = map[store.StatusCode]apimodel.Code{
 	store.NotFoundTagConfigOrService: apimodel.Code_NotFoundTagConfigOrService,
 	store.ExistReleasedConfig:        apimodel.Code_ExistReleasedConfig,
 	store.DuplicateEntryErr:          apimodel.Code_ExistedResource,
+	store.NotFoundResource:           apimodel.Code_NotFoundResource,
+	store.NotFoundUser:               apimodel.Code_NotFoundUser,
+	store.AffectedRowsNotMatch:       apimodel.Code_DataConflict,
+	store.InvalidParameter:           apimodel.Code_InvalidParameter,
+	store.UnfilteredQueryErr:         apimodel.Code_InvalidParameter,
+	store.InvalidUserOwner:           apimodel.Code_InvalidUserOwners,
+	store.NotModified:                apimodel.Code_DataNoChange,
+	store.PasswordTooRecent:          apimodel.Code_InvalidUserPassword,
+	// api 中没有专门的超时错误码，使用 ExecuteException 和 StoreLayerException 区分，表示可以稍后重试
+	store.Timeout: apimodel.Code_ExecuteException,
+	// 写事务过多时按照限流处理，客户端稍后重试
+	store.Busy: apimodel.Code_APIRateLimit,
 }
 
 // StoreCode2APICode store code to api code
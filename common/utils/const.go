@@ -57,6 +57,8 @@ const (
 	ContextOwnerIDKey = StringContext(HeaderOwnerIDKey)
 	// ContextUserRoleIDKey user role key
 	ContextUserRoleIDKey = StringContext(HeaderUserRoleKey)
+	// ContextTokenScopeKey scope of the user token in use
+	ContextTokenScopeKey = StringContext("X-Polaris-Token-Scope")
 	// ContextAuthContextKey auth context key
 	ContextAuthContextKey = StringContext("X-Polaris-AuthContext")
 	// ContextUserNameKey users name key
@@ -18,6 +18,7 @@
 package store
 
 import (
+	"context"
 	"time"
 
 	"github.com/polarismesh/polaris/common/model"
@@ -37,6 +38,8 @@ type Store interface {
 	StartTx() (Tx, error)
 	// StartReadTx 开启一个原子事务
 	StartReadTx() (Tx, error)
+	// WithTx 在同一个事务中执行 fn，fn 返回 error 时回滚，否则提交
+	WithTx(ctx context.Context, fn func(TxStore) error) error
 	// NamespaceStore Service namespace interface
 	NamespaceStore
 	// NamingModuleStore Service Registration Discovery Module Storage Interface
@@ -18,6 +18,8 @@
 package store
 
 import (
+	"context"
+	"io"
 	"time"
 
 	"github.com/polarismesh/polaris/common/model"
@@ -25,25 +27,144 @@ import (
 
 // UserStore User-related operation interface
 type UserStore interface {
-	// AddUser Create a user
-	AddUser(user *model.User) error
-	// UpdateUser Update user
-	UpdateUser(user *model.User) error
-	// DeleteUser delete users
-	DeleteUser(user *model.User) error
+	UserReader
+	UserWriter
+}
+
+// UserReader The read-only part of UserStore, hand it to the components that must not modify users,
+// such as the cache and the console
+type UserReader interface {
 	// GetSubCount Number of getting a child account
 	GetSubCount(user *model.User) (uint32, error)
 	// GetUser Obtain user
 	GetUser(id string) (*model.User, error)
+	// GetUserIfModified Obtain user unless its revision equals etag, in which case an error
+	// with the NotModified code is returned
+	GetUserIfModified(id, etag string) (*model.User, error)
+	// GetUserTx Obtain user in the given transaction
+	GetUserTx(tx Tx, id string) (*model.User, error)
 	// GetUserByName Get a unique user according to Name + Owner
 	GetUserByName(name, ownerId string) (*model.User, error)
 	// GetUserByIDS Get users according to USER IDS batch
 	GetUserByIds(ids []string) ([]*model.User, error)
-	// GetUsers Query user list
+	// GetUserByIdsWithContext Get users according to USER IDS batch, stop querying once ctx is canceled
+	GetUserByIdsWithContext(ctx context.Context, ids []string) ([]*model.User, error)
+	// GetUsers Query user list, the filters are parsed by ParseUserQuery
 	GetUsers(filters map[string]string, offset uint32, limit uint32) (uint32, []*model.User, error)
+	// QueryUsers Query user list with the typed query
+	QueryUsers(query *UserQuery, offset uint32, limit uint32) (uint32, []*model.User, error)
+	// QueryUsersWithOwner Query user list with the typed query, the name of each user's owner is resolved as well
+	QueryUsersWithOwner(query *UserQuery, offset uint32, limit uint32) (uint32, []*model.UserWithOwner, error)
+	// GetUsersByStrategyID Query the users linked to the strategy, the admin user is excluded
+	GetUsersByStrategyID(strategyID string, offset uint32, limit uint32) (uint32, []*model.User, error)
+	// GetAddableUsersForGroup Query the active users under the owner that are not members of the group yet,
+	// the admin user is excluded, NotFoundUserGroup is returned if the group does not belong to the owner
+	GetAddableUsersForGroup(groupId, ownerId string, offset uint32, limit uint32) (uint32, []*model.User, error)
+	// GetMainAccountsWithSubCounts Query the active main accounts, i.e. the users whose owner is themselves, ordered
+	// by ctime, each annotated with the number of its active sub accounts, the admin user is excluded
+	GetMainAccountsWithSubCounts(offset uint32, limit uint32) (uint32, []*model.UserWithSubCount, error)
+	// GetRecentlyModifiedUsers Get the most recently modified users ordered by mtime desc, the admin user is excluded,
+	// limit is capped to MaxRecentlyModifiedUsers
+	GetRecentlyModifiedUsers(limit uint32) ([]*model.User, error)
 	// GetUsersForCache Used to refresh user cache
 	// 此方法用于 cache 增量更新，需要注意 mtime 应为数据库时间戳
+	// 超级账户需要参与 token 校验，因此不同于 GetUsers，这里不会过滤超级账户
 	GetUsersForCache(mtime time.Time, firstUpdate bool) ([]*model.User, error)
+	// FindDuplicateTokens Find the active users sharing the same token, each group contains the ids of the users
+	// sharing one token
+	FindDuplicateTokens() ([][]string, error)
+	// FindDuplicateUserNames Find the active users sharing the same name under the same owner, each group contains
+	// the ids of the users sharing one (name, owner) pair and needs to be reconciled before adding the unique index
+	FindDuplicateUserNames() ([][]string, error)
+	// FindWeakTokens Find the active users whose token entropy is lower than minEntropyBits
+	FindWeakTokens(minEntropyBits float64) ([]string, error)
+	// AuditDefaultStrategies Find the active users whose default strategy is missing, deleted or not linked to
+	// the user, the ids are sorted and can be repaired by RebuildDefaultStrategy
+	AuditDefaultStrategies() ([]string, error)
+	// GetUserCountsBySource Count the active users of each source, the admin user is excluded
+	GetUserCountsBySource() (map[string]uint32, error)
+	// GetUserCountsByType Count the active users of each user type, i.e. main accounts and sub accounts,
+	// the admin user is excluded
+	GetUserCountsByType() (map[model.UserRoleType]uint32, error)
+	// GetUserChanges Get the user changes whose seq is greater than sinceSeq in seq order,
+	// the changes are only recorded when the userChangeLog store option is enabled. With the userChangeCompact
+	// option only the latest change of each user within the read is returned, so fewer than limit changes may be
+	// returned while the last seq of the read is still included
+	GetUserChanges(sinceSeq uint64, limit uint32) ([]*model.UserChange, error)
+	// ExportUsersSnapshot Write all users, including the deleted ones, to w as JSON lines of UserSnapshot in id
+	// order. The values are written as stored, the token stays encrypted when token encryption is enabled. The
+	// export reads a single consistent snapshot, the writes committed during the export are not included
+	ExportUsersSnapshot(ctx context.Context, w io.Writer) error
+}
+
+// UserWriter The part of UserStore that modifies users
+type UserWriter interface {
+	// AddUser Create a user
+	AddUser(user *model.User) error
+	// AddUserAndReturn Create a user and return the stored record, including the fields generated by the store
+	AddUserAndReturn(user *model.User) (*model.User, error)
+	// AddUserTx Create a user in the given transaction
+	AddUserTx(tx Tx, user *model.User) error
+	// BatchAddUser Create users and their default strategies in one transaction
+	BatchAddUser(users []*model.User) error
+	// BatchAddUsersBestEffort Create users one by one, each user and its default strategy are written in its own
+	// transaction so that a failed user does not block the others, the results are in the same order as users
+	BatchAddUsersBestEffort(users []*model.User) []BatchItemResult
+	// UpdateUser Update user
+	UpdateUser(user *model.User) error
+	// UpdateUserTx Update user in the given transaction
+	UpdateUserTx(tx Tx, user *model.User) error
+	// DeleteUser delete users
+	DeleteUser(user *model.User) error
+	// DeleteUserTx delete users in the given transaction
+	DeleteUserTx(tx Tx, user *model.User) error
+	// BatchDeleteUsersBestEffort Delete users one by one, each in its own transaction so that a failed user does not
+	// block the others, the results are in the same order as users
+	BatchDeleteUsersBestEffort(users []*model.User) []BatchItemResult
+	// SoftDeleteUser Soft delete the user, its user group relations are removed as well when cascadeGroups is true,
+	// otherwise only the user is flagged and the relations are kept for RecoverUser
+	SoftDeleteUser(user *model.User, cascadeGroups bool) error
+	// RecoverUser Restore a soft deleted user, the user group relations it still has become effective again
+	// and the default strategy removed on delete is recreated, modifiedBy is recorded as the operator
+	RecoverUser(userId, modifiedBy string) error
+	// UpdateLastLogin Record the time when the user last passed token verification
+	// 该操作不会更新用户的 mtime，避免触发 cache 的增量刷新
+	UpdateLastLogin(userId string) error
+	// RenameUser Modify the name of the user, the new name must be unique under the same owner,
+	// modifiedBy is recorded as the operator
+	RenameUser(userId, newName, modifiedBy string) error
+	// SwapUserNames Swap the names of two users under the same owner in one transaction,
+	// a temporary name is used so that the unique constraint of names is not violated in between
+	SwapUserNames(userIdA, userIdB, modifiedBy string) error
+	// ResetUserCredentials Replace the password and token of an active user in one transaction,
+	// the password must already be hashed
+	ResetUserCredentials(userId, password, token, modifiedBy string) error
+	// RebuildDefaultStrategy Recreate the default strategy of an active user and link the user to it,
+	// broken remnants are removed first, nothing is created if the user already has a valid default strategy
+	RebuildDefaultStrategy(userId string) error
+	// PurgeDeletedUsers Physically remove the users which were soft deleted before the given time, together with
+	// all the group relations of these users and the relations removed before the given time. The time must leave
+	// the caches enough time to sync the deletions and removals
+	PurgeDeletedUsers(deletedBefore time.Time) (uint32, error)
+	// SetUsersTokenEnable Enable or disable the token of the given active users, return the number of users changed
+	SetUsersTokenEnable(ids []string, enable bool, modifiedBy string) (uint32, error)
+	// SetUsersComment Set the comment of the given active users in bulk, return the number of users changed
+	SetUsersComment(ids []string, comment, modifiedBy string) (uint32, error)
+}
+
+// UserTokenStore Storage of the scoped tokens of users, besides its own token a user may own several named
+// tokens with a limited scope and an optional expire time
+type UserTokenStore interface {
+	// AddUserToken Create a scoped token for an active user, the name must be unique among the valid tokens
+	// of the user
+	AddUserToken(token *model.UserToken) error
+	// GetUserTokenByID Get a valid scoped token by id, nil is returned if it does not exist or was revoked.
+	// It is used to verify tokens, so it always reads the master database
+	GetUserTokenByID(id string) (*model.UserToken, error)
+	// ListUserTokens List the valid scoped tokens of the user ordered by create time, token values are not returned
+	ListUserTokens(userId string) ([]*model.UserToken, error)
+	// RevokeUserToken Revoke a valid scoped token of the user, NotFoundResource is returned if there is none
+	RevokeUserToken(userId, id string) error
 }
 
 // GroupStore User group storage operation interface
@@ -70,6 +191,16 @@ type GroupStore interface {
 	// GetUserGroupsForCache Refresh of getting user groups for cache
 	// 此方法用于 cache 增量更新，需要注意 mtime 应为数据库时间戳
 	GetGroupsForCache(mtime time.Time, firstUpdate bool) ([]*model.UserGroupDetail, error)
+
+	// GetUserGroupRelationsForCache Get the changed user-group relations for cache, removed relations are included
+	// 此方法用于 cache 增量更新，需要注意 mtime 应为数据库时间戳
+	GetUserGroupRelationsForCache(mtime time.Time, firstUpdate bool) ([]*model.UserGroupLink, error)
+
+	// FindOrphanedGroupRelations Find the valid user-group relations whose user no longer exists
+	FindOrphanedGroupRelations() ([]*model.UserGroupLink, error)
+
+	// RepairOrphanedGroupRelations Remove the orphaned user-group relations, return the removed relations
+	RepairOrphanedGroupRelations() ([]*model.UserGroupLink, error)
 }
 
 // StrategyStore Authentication policy related storage operation interface
@@ -0,0 +1,42 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserReaderWriter(t *testing.T) {
+	reader := reflect.TypeOf((*UserReader)(nil)).Elem()
+	writer := reflect.TypeOf((*UserWriter)(nil)).Elem()
+	full := reflect.TypeOf((*UserStore)(nil)).Elem()
+
+	// 只读的 UserReader 不能包含任何写方法
+	for i := 0; i < writer.NumMethod(); i++ {
+		name := writer.Method(i).Name
+		_, ok := reader.MethodByName(name)
+		assert.False(t, ok, "UserReader should not have write method %s", name)
+	}
+	// UserStore 由两者组成，没有额外的方法
+	assert.Equal(t, full.NumMethod(), reader.NumMethod()+writer.NumMethod())
+	assert.True(t, full.Implements(reader))
+	assert.True(t, full.Implements(writer))
+}
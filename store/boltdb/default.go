@@ -18,6 +18,7 @@
 package boltdb
 
 import (
+	"context"
 	"time"
 
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
@@ -115,6 +116,21 @@ func (m *boltStore) Initialize(c *store.Config) error {
 		_ = handler.Close()
 		return err
 	}
+	m.userStore.cacheExcludePassword, _ = c.Option["cacheExcludePassword"].(bool)
+	m.userStore.cacheExcludeToken, _ = c.Option["cacheExcludeToken"].(bool)
+	m.userStore.cacheProjection, _ = c.Option["cacheProjection"].(bool)
+	m.userStore.cacheWithGroups, _ = c.Option["cacheWithGroups"].(bool)
+	reuse, err := store.ParseDefaultStrategyConflict(c.Option["defaultStrategyConflict"])
+	if err != nil {
+		_ = handler.Close()
+		return err
+	}
+	m.userStore.reuseDefaultStrategy = reuse
+	m.userStore.changeLog, _ = c.Option["userChangeLog"].(bool)
+	m.userStore.changeCompact, _ = c.Option["userChangeCompact"].(bool)
+	m.userStore.passwordAge = store.ParsePasswordAgePolicy(c.Option)
+	m.userStore.cascadeSubAccounts, _ = c.Option["userDeleteCascadeSubAccounts"].(bool)
+	m.groupStore.reuseDefaultStrategy = reuse
 
 	if loadFile, ok := c.Option["loadFile"].(string); ok {
 		if err := m.loadByFile(loadFile); err != nil {
@@ -373,6 +389,15 @@ func (m *boltStore) StartReadTx() (store.Tx, error) {
 	return m.handler.StartTx()
 }
 
+// WithTx 在同一个事务中执行 fn，fn 返回 error 时回滚事务
+func (m *boltStore) WithTx(ctx context.Context, fn func(store.TxStore) error) error {
+	tx, err := m.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	return store.RunWithTx(ctx, tx, store.NewTxStore(tx, m.userStore), fn)
+}
+
 func init() {
 	s := &boltStore{}
 	_ = store.RegisterStore(s)
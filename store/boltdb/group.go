@@ -52,6 +52,13 @@ const (
 	GroupFieldCreateTime  string = "CreateTime"
 	GroupFieldModifyTime  string = "ModifyTime"
 	GroupFieldUserIds     string = "UserIds"
+
+	// 用户-用户组关联关系的变更记录，用于 cache 增量更新
+	tblGroupRelation string = "user_group_relation"
+
+	GroupRelationFieldUserID     string = "UserID"
+	GroupRelationFieldRemoved    string = "Removed"
+	GroupRelationFieldModifyTime string = "ModifyTime"
 )
 
 type groupForStore struct {
@@ -67,9 +74,20 @@ type groupForStore struct {
 	UserIds     map[string]string
 }
 
+// groupRelationForStore 单条用户-用户组关联关系
+// 注意 Valid 字段会被 saveValue 强制设置为 true，因此使用 Removed 标记关联关系是否已经被移除
+type groupRelationForStore struct {
+	GroupID    string
+	UserID     string
+	Removed    bool
+	ModifyTime time.Time
+}
+
 // groupStore
 type groupStore struct {
 	handler BoltHandler
+	// reuseDefaultStrategy 同名的默认策略属于其他用户组时复用该策略，否则返回 DataConflictErr
+	reuseDefaultStrategy bool
 }
 
 // AddGroup add a group
@@ -114,8 +132,14 @@ func (gs *groupStore) addGroup(tx *bolt.Tx, group *model.UserGroupDetail) error
 		return err
 	}
 
+	if err := saveGroupRelations(tx, group.ID, group.UserIds, true); err != nil {
+		log.Error("[Store][Group] save usergroup relations", zap.Error(err),
+			zap.String("name", group.Name), zap.String("owner", group.Owner))
+		return err
+	}
+
 	if err := createDefaultStrategy(tx, model.PrincipalGroup, data.ID, data.Name,
-		data.Owner); err != nil {
+		data.Owner, gs.reuseDefaultStrategy); err != nil {
 		log.Error("[Store][Group] add usergroup default strategy", zap.Error(err),
 			zap.String("name", group.Name), zap.String("owner", group.Owner))
 
@@ -184,6 +208,15 @@ func (gs *groupStore) updateGroup(group *model.ModifyUserGroup) error {
 		return err
 	}
 
+	if err := saveGroupRelations(tx, ret.ID, toIdSet(group.AddUserIds), true); err != nil {
+		log.Error("[Store][Group] save usergroup relations", zap.Error(err), zap.String("id", ret.ID))
+		return err
+	}
+	if err := saveGroupRelations(tx, ret.ID, toIdSet(group.RemoveUserIds), false); err != nil {
+		log.Error("[Store][Group] remove usergroup relations", zap.Error(err), zap.String("id", ret.ID))
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Error("[Store][Group] update usergroup tx commit",
 			zap.Error(err), zap.String("id", ret.ID))
@@ -224,6 +257,18 @@ func (gs *groupStore) deleteGroup(group *model.UserGroupDetail) error {
 		_ = tx.Rollback()
 	}()
 
+	values := make(map[string]interface{})
+	if err := loadValues(tx, tblGroup, []string{group.ID}, &groupForStore{}, values); err != nil {
+		log.Error("[Store][Group] get usergroup by id", zap.Error(err), zap.String("id", group.ID))
+		return err
+	}
+	if saved, ok := values[group.ID].(*groupForStore); ok {
+		if err := saveGroupRelations(tx, group.ID, convertForGroupDetail(saved).UserIds, false); err != nil {
+			log.Error("[Store][Group] remove usergroup relations", zap.Error(err), zap.String("id", group.ID))
+			return err
+		}
+	}
+
 	properties := make(map[string]interface{})
 	properties[GroupFieldValid] = false
 	properties[GroupFieldModifyTime] = time.Now()
@@ -480,6 +525,196 @@ func (gs *groupStore) GetGroupsForCache(mtime time.Time, firstUpdate bool) ([]*m
 	return groups, nil
 }
 
+// GetUserGroupRelationsForCache 查询用户-用户组关联关系的变化，主要用于Cache更新
+// 首次加载时直接根据有效的用户组生成关联关系，增量更新时返回包括已移除在内的关联关系变更记录
+func (gs *groupStore) GetUserGroupRelationsForCache(mtime time.Time,
+	firstUpdate bool) ([]*model.UserGroupLink, error) {
+	if firstUpdate {
+		groups, err := gs.handler.LoadValuesByFilter(tblGroup, []string{GroupFieldValid}, &groupForStore{},
+			func(m map[string]interface{}) bool {
+				valid, _ := m[GroupFieldValid].(bool)
+				return valid
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		links := make([]*model.UserGroupLink, 0, len(groups))
+		for k := range groups {
+			group := groups[k].(*groupForStore)
+			for uid := range group.UserIds {
+				links = append(links, &model.UserGroupLink{
+					UserID:     uid,
+					GroupID:    group.ID,
+					Valid:      true,
+					ModifyTime: group.ModifyTime,
+				})
+			}
+		}
+		return links, nil
+	}
+
+	ret, err := gs.handler.LoadValuesByFilter(tblGroupRelation, []string{GroupRelationFieldModifyTime},
+		&groupRelationForStore{}, func(m map[string]interface{}) bool {
+			mt, _ := m[GroupRelationFieldModifyTime].(time.Time)
+			return !mt.Before(mtime)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]*model.UserGroupLink, 0, len(ret))
+	for k := range ret {
+		val := ret[k].(*groupRelationForStore)
+		links = append(links, &model.UserGroupLink{
+			UserID:     val.UserID,
+			GroupID:    val.GroupID,
+			Valid:      !val.Removed,
+			ModifyTime: val.ModifyTime,
+		})
+	}
+	return links, nil
+}
+
+// FindOrphanedGroupRelations 查询用户已经不存在的用户-用户组关联关系
+func (gs *groupStore) FindOrphanedGroupRelations() ([]*model.UserGroupLink, error) {
+	proxy, err := gs.handler.StartTx()
+	if err != nil {
+		return nil, err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	links, _, err := findOrphanedGroupRelations(tx)
+	if err != nil {
+		log.Error("[Store][Group] find orphaned user group relations", zap.Error(err))
+		return nil, err
+	}
+	return links, nil
+}
+
+// RepairOrphanedGroupRelations 移除用户已经不存在的用户-用户组关联关系，同时更新用户组的 ModifyTime 触发缓存刷新
+func (gs *groupStore) RepairOrphanedGroupRelations() ([]*model.UserGroupLink, error) {
+	proxy, err := gs.handler.StartTx()
+	if err != nil {
+		return nil, err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	links, groups, err := findOrphanedGroupRelations(tx)
+	if err != nil {
+		log.Error("[Store][Group] find orphaned user group relations", zap.Error(err))
+		return nil, err
+	}
+	if len(links) == 0 {
+		return links, nil
+	}
+
+	orphans := make(map[string]map[string]struct{}, len(groups))
+	for i := range links {
+		if _, ok := orphans[links[i].GroupID]; !ok {
+			orphans[links[i].GroupID] = make(map[string]struct{})
+		}
+		orphans[links[i].GroupID][links[i].UserID] = struct{}{}
+	}
+	now := time.Now()
+	for groupId, userIds := range orphans {
+		group := groups[groupId]
+		for userId := range userIds {
+			delete(group.UserIds, userId)
+		}
+		group.ModifyTime = now
+		if err := saveValue(tx, tblGroup, group.ID, group); err != nil {
+			log.Error("[Store][Group] update usergroup", zap.Error(err), zap.String("id", group.ID))
+			return nil, err
+		}
+		if err := saveGroupRelations(tx, group.ID, userIds, false); err != nil {
+			log.Error("[Store][Group] remove usergroup relations", zap.Error(err), zap.String("id", group.ID))
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][Group] repair orphaned user group relations tx commit", zap.Error(err))
+		return nil, err
+	}
+	for i := range links {
+		links[i].Valid = false
+		links[i].ModifyTime = now
+	}
+	log.Info("[Store][Group] repair orphaned user group relations", zap.Int("count", len(links)))
+	return links, nil
+}
+
+// findOrphanedGroupRelations 查询有效用户组中已经不存在或者已经被删除的用户，同时返回有效的用户组
+func findOrphanedGroupRelations(tx *bolt.Tx) ([]*model.UserGroupLink, map[string]*groupForStore, error) {
+	users := make(map[string]interface{})
+	if err := loadValuesByFilter(tx, tblUser, []string{UserFieldValid}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[UserFieldValid].(bool)
+			return valid
+		}, users); err != nil {
+		return nil, nil, err
+	}
+	values := make(map[string]interface{})
+	if err := loadValuesByFilter(tx, tblGroup, []string{GroupFieldValid}, &groupForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[GroupFieldValid].(bool)
+			return valid
+		}, values); err != nil {
+		return nil, nil, err
+	}
+
+	links := make([]*model.UserGroupLink, 0)
+	groups := make(map[string]*groupForStore, len(values))
+	for _, v := range values {
+		group := v.(*groupForStore)
+		groups[group.ID] = group
+		for userId := range group.UserIds {
+			if _, ok := users[userId]; ok {
+				continue
+			}
+			links = append(links, &model.UserGroupLink{
+				UserID:     userId,
+				GroupID:    group.ID,
+				Valid:      true,
+				ModifyTime: group.ModifyTime,
+			})
+		}
+	}
+	return links, groups, nil
+}
+
+// saveGroupRelations 记录用户-用户组关联关系的变更
+func saveGroupRelations(tx *bolt.Tx, groupId string, userIds map[string]struct{}, valid bool) error {
+	now := time.Now()
+	for uid := range userIds {
+		relation := &groupRelationForStore{
+			GroupID:    groupId,
+			UserID:     uid,
+			Removed:    !valid,
+			ModifyTime: now,
+		}
+		if err := saveValue(tx, tblGroupRelation, groupId+"/"+uid, relation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toIdSet(ids []string) map[string]struct{} {
+	ret := make(map[string]struct{}, len(ids))
+	for i := range ids {
+		ret[ids[i]] = struct{}{}
+	}
+	return ret
+}
+
 // cleanInValidGroup 清理无效的用户组数据
 func (gs *groupStore) cleanInValidGroup(tx *bolt.Tx, name, owner string) error {
 	log.Infof("[Store][User] clean usergroup(%s)", name)
@@ -19,6 +19,7 @@ package boltdb
 
 import (
 	"fmt"
+	"sort"
 	"testing"
 	"time"
 
@@ -263,3 +264,119 @@ func Test_groupStore_GetGroups(t *testing.T) {
 		}
 	})
 }
+
+func Test_groupStore_GetUserGroupRelationsForCache(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_group", func(t *testing.T, handler BoltHandler) {
+		gs := &groupStore{handler: handler}
+
+		groups := createTestUserGroup(2)
+		for i := range groups {
+			if err := gs.AddGroup(groups[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		collect := func(links []*model.UserGroupLink) map[string]bool {
+			ret := make(map[string]bool, len(links))
+			for i := range links {
+				ret[links[i].GroupID+"/"+links[i].UserID] = links[i].Valid
+			}
+			return ret
+		}
+
+		// 首次加载返回所有有效的关联关系
+		links, err := gs.GetUserGroupRelationsForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, len(links))
+
+		time.Sleep(10 * time.Millisecond)
+		since := time.Now()
+		time.Sleep(10 * time.Millisecond)
+
+		// 增量更新只返回发生变化的关联关系，包括已经被移除的关联关系
+		assert.NoError(t, gs.UpdateGroup(&model.ModifyUserGroup{
+			ID:            groups[0].ID,
+			Token:         groups[0].Token,
+			TokenEnable:   true,
+			AddUserIds:    []string{"user_new"},
+			RemoveUserIds: []string{"user_0"},
+		}))
+		links, err = gs.GetUserGroupRelationsForCache(since, false)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{
+			"test_group_0/user_new": true,
+			"test_group_0/user_0":   false,
+		}, collect(links))
+
+		// 删除用户组后，该用户组下的关联关系全部被移除
+		assert.NoError(t, gs.DeleteGroup(groups[1]))
+		links, err = gs.GetUserGroupRelationsForCache(since, false)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{
+			"test_group_0/user_new": true,
+			"test_group_0/user_0":   false,
+			"test_group_1/user_0":   false,
+			"test_group_1/user_1":   false,
+		}, collect(links))
+
+		links, err = gs.GetUserGroupRelationsForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{
+			"test_group_0/user_1":   true,
+			"test_group_0/user_new": true,
+		}, collect(links))
+	})
+}
+
+func Test_groupStore_OrphanedGroupRelations(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_group", func(t *testing.T, handler BoltHandler) {
+		gs := &groupStore{handler: handler}
+		us := &userStore{handler: handler}
+
+		// user_1 没有写入，模拟已经被物理删除的用户
+		users := createTestUsers(3)
+		assert.NoError(t, us.AddUser(users[0]))
+		assert.NoError(t, us.AddUser(users[2]))
+
+		groups := createTestUserGroup(1)
+		groups[0].UserIds = buildUserIds(users)
+		assert.NoError(t, gs.AddGroup(groups[0]))
+		// 已经被删除的用户同样视为孤立的关联关系
+		assert.NoError(t, us.DeleteUser(users[2]))
+
+		orphans := func(links []*model.UserGroupLink) []string {
+			ret := make([]string, 0, len(links))
+			for i := range links {
+				ret = append(ret, links[i].GroupID+"/"+links[i].UserID)
+			}
+			sort.Strings(ret)
+			return ret
+		}
+
+		links, err := gs.FindOrphanedGroupRelations()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test_group_0/user_1", "test_group_0/user_2"}, orphans(links))
+
+		since := time.Now()
+		links, err = gs.RepairOrphanedGroupRelations()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test_group_0/user_1", "test_group_0/user_2"}, orphans(links))
+
+		links, err = gs.FindOrphanedGroupRelations()
+		assert.NoError(t, err)
+		assert.Empty(t, links)
+
+		group, err := gs.GetGroup(groups[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]struct{}{users[0].ID: {}}, group.UserIds)
+		assert.False(t, group.ModifyTime.Before(since))
+
+		// 移除的关联关系会同步给缓存的增量更新
+		links, err = gs.GetUserGroupRelationsForCache(since, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test_group_0/user_1", "test_group_0/user_2"}, orphans(links))
+		for i := range links {
+			assert.False(t, links[i].Valid)
+		}
+	})
+}
@@ -736,7 +736,19 @@ func (ss *strategyStore) cleanInvalidStrategy(tx *bolt.Tx, name, owner string) e
 	return deleteValues(tx, tblStrategy, keys)
 }
 
-func createDefaultStrategy(tx *bolt.Tx, role model.PrincipalType, principalId, name, owner string) error {
+// reuseConflict 为 true 时，同名的默认策略属于其他 principal 则将当前 principal 关联到该策略上，否则返回 DataConflictErr
+func createDefaultStrategy(tx *bolt.Tx, role model.PrincipalType, principalId, name, owner string,
+	reuseConflict bool) error {
+	// 同名的默认策略仍然有效时，若关联的就是当前 principal 则直接复用，否则按照配置复用或者明确报错
+	reuse, err := checkDefaultStrategyConflict(tx, role, principalId, model.BuildDefaultStrategyName(role, name), owner,
+		reuseConflict)
+	if err != nil {
+		return err
+	}
+	if reuse {
+		return nil
+	}
+
 	strategy := &model.StrategyDetail{
 		ID:        utils.NewUUID(),
 		Name:      model.BuildDefaultStrategyName(role, name),
@@ -758,6 +770,175 @@ func createDefaultStrategy(tx *bolt.Tx, role model.PrincipalType, principalId, n
 	return saveValue(tx, tblStrategy, strategy.ID, convertForStrategyStore(strategy))
 }
 
+// cleanBrokenDefaultStrategy 清理 principal 默认策略的残留数据，返回是否需要重新创建默认策略
+// principal 保存在策略中，因此残留数据只有没有关联任何 principal 的同名默认策略
+func cleanBrokenDefaultStrategy(tx *bolt.Tx, role model.PrincipalType, principalId, name, owner string) (bool, error) {
+	fields := []string{StrategyFieldName, StrategyFieldOwner, StrategyFieldDefault, StrategyFieldValid,
+		StrategyFieldUsersPrincipal, StrategyFieldGroupsPrincipal}
+	values := make(map[string]interface{})
+	err := loadValuesByFilter(tx, tblStrategy, fields, &strategyForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[StrategyFieldValid].(bool)
+			isDefault, _ := m[StrategyFieldDefault].(bool)
+			return valid && isDefault
+		}, values)
+	if err != nil {
+		log.Error("[Store][Strategy] load default auth_strategy", zap.Error(err), zap.String("principal", principalId))
+		return false, err
+	}
+
+	defaultName := model.BuildDefaultStrategyName(role, name)
+	orphans := make([]string, 0, 1)
+	for id, val := range values {
+		strategy := val.(*strategyForStore)
+		principals := strategy.Users
+		if role == model.PrincipalGroup {
+			principals = strategy.Groups
+		}
+		if _, ok := principals[principalId]; ok {
+			return false, nil
+		}
+		if strategy.Name == defaultName && strategy.Owner == owner && len(strategy.Users) == 0 &&
+			len(strategy.Groups) == 0 {
+			orphans = append(orphans, id)
+		}
+	}
+	if len(orphans) == 0 {
+		return true, nil
+	}
+	log.Info("[Store][Strategy] remove orphaned default strategy", zap.Strings("id", orphans),
+		zap.String("principal", principalId), zap.String("name", name))
+	return true, deleteValues(tx, tblStrategy, orphans)
+}
+
+// checkDefaultStrategyConflict 检查是否已经存在同名且有效的默认策略，返回是否已经复用该策略
+func checkDefaultStrategyConflict(tx *bolt.Tx, role model.PrincipalType, principalId, name, owner string,
+	reuseConflict bool) (bool, error) {
+	fields := []string{StrategyFieldName, StrategyFieldOwner, StrategyFieldDefault, StrategyFieldValid}
+	values := make(map[string]interface{})
+
+	err := loadValuesByFilter(tx, tblStrategy, fields, &strategyForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[StrategyFieldValid].(bool)
+			if ok && !valid {
+				return false
+			}
+			isDefault, _ := m[StrategyFieldDefault].(bool)
+			saveName, _ := m[StrategyFieldName].(string)
+			saveOwner, _ := m[StrategyFieldOwner].(string)
+			return isDefault && saveName == name && saveOwner == owner
+		}, values)
+	if err != nil {
+		log.Error("[Store][Strategy] load same name default auth_strategy", zap.Error(err),
+			zap.String("name", name), zap.String("owner", owner))
+		return false, err
+	}
+
+	for id, val := range values {
+		strategy := val.(*strategyForStore)
+		principals := strategy.Users
+		if role == model.PrincipalGroup {
+			principals = strategy.Groups
+		}
+		if _, ok := principals[principalId]; !ok {
+			if !reuseConflict {
+				return false, store.NewStatusError(store.DataConflictErr, fmt.Sprintf(
+					"default strategy(%s) of owner(%s) already exists and belongs to other principal, "+
+						"set defaultStrategyConflict to reuse to share it", name, owner))
+			}
+			log.Warn("[Store][Strategy] default strategy belongs to other principal, reuse it",
+				zap.String("id", id), zap.String("principal", principalId), zap.String("name", name))
+			if principals == nil {
+				principals = make(map[string]string)
+			}
+			principals[principalId] = ""
+			if role == model.PrincipalGroup {
+				strategy.Groups = principals
+			} else {
+				strategy.Users = principals
+			}
+			strategy.Revision = utils.NewUUID()
+			strategy.ModifyTime = time.Now()
+			if err := saveValue(tx, tblStrategy, id, strategy); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+		log.Info("[Store][Strategy] reuse existing default strategy", zap.String("id", id),
+			zap.String("principal", principalId), zap.String("name", name))
+		return true, nil
+	}
+	return false, nil
+}
+
+// renameDefaultStrategy principal 改名时同步修改其默认策略的名称，同时更新策略的 revision 以及修改时间触发缓存刷新
+// 只修改关联了该 principal 并且名称由原名称生成的默认策略，没有这样的策略时直接跳过
+func renameDefaultStrategy(tx *bolt.Tx, role model.PrincipalType, principalId, oldName, newName string) error {
+	oldStrategyName := model.BuildDefaultStrategyName(role, oldName)
+	newStrategyName := model.BuildDefaultStrategyName(role, newName)
+	if oldStrategyName == newStrategyName {
+		return nil
+	}
+
+	fields := []string{StrategyFieldName, StrategyFieldDefault, StrategyFieldValid}
+	values := make(map[string]interface{})
+	err := loadValuesByFilter(tx, tblStrategy, fields, &strategyForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[StrategyFieldValid].(bool)
+			if ok && !valid {
+				return false
+			}
+			isDefault, _ := m[StrategyFieldDefault].(bool)
+			saveName, _ := m[StrategyFieldName].(string)
+			return isDefault && (saveName == oldStrategyName || saveName == newStrategyName)
+		}, values)
+	if err != nil {
+		log.Error("[Store][Strategy] load default auth_strategy", zap.Error(err), zap.String("principal", principalId))
+		return err
+	}
+
+	var (
+		targetId string
+		target   *strategyForStore
+	)
+	for id, val := range values {
+		strategy := val.(*strategyForStore)
+		principals := strategy.Users
+		if role == model.PrincipalGroup {
+			principals = strategy.Groups
+		}
+		if _, ok := principals[principalId]; ok && strategy.Name == oldStrategyName {
+			targetId, target = id, strategy
+			break
+		}
+	}
+	if target == nil {
+		return nil
+	}
+	// 仍然有效的同名默认策略属于其他 principal，不能覆盖
+	for id, val := range values {
+		strategy := val.(*strategyForStore)
+		if id != targetId && strategy.Name == newStrategyName && strategy.Owner == target.Owner {
+			return store.NewStatusError(store.DataConflictErr, fmt.Sprintf(
+				"default strategy(%s) of owner(%s) already exists and belongs to other principal",
+				newStrategyName, target.Owner))
+		}
+	}
+
+	properties := map[string]interface{}{
+		StrategyFieldName:       newStrategyName,
+		StrategyFieldRevision:   utils.NewUUID(),
+		StrategyFieldModifyTime: time.Now(),
+	}
+	if err := updateValue(tx, tblStrategy, targetId, properties); err != nil {
+		log.Error("[Store][Strategy] rename default strategy", zap.Error(err), zap.String("id", targetId))
+		return err
+	}
+	log.Info("[Store][Strategy] rename default strategy", zap.String("id", targetId),
+		zap.String("principal", principalId), zap.String("name", newStrategyName))
+	return nil
+}
+
 func cleanLinkStrategy(tx *bolt.Tx, role model.PrincipalType, principalId, owner string) error {
 
 	fields := []string{StrategyFieldDefault, StrategyFieldUsersPrincipal, StrategyFieldGroupsPrincipal}
@@ -18,7 +18,11 @@
 package boltdb
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"time"
@@ -63,6 +67,20 @@ const (
 	UserFieldMobile string = "Mobile"
 	// UserFieldEmail 用户邮箱信息
 	UserFieldEmail string = "Email"
+	// UserFieldLastLoginTime 用户最近一次登录时间
+	UserFieldLastLoginTime string = "LastLoginTime"
+	// UserFieldPasswordSetTime 用户最近一次修改密码的时间
+	UserFieldPasswordSetTime string = "PasswordSetTime"
+	// UserFieldMustChangePassword 用户是否必须修改密码
+	UserFieldMustChangePassword string = "MustChangePassword"
+	// UserFieldTokenRotatedTime 用户最近一次更换 token 的时间
+	UserFieldTokenRotatedTime string = "TokenRotatedTime"
+	// UserFieldDeleteTime 用户被删除的时间
+	UserFieldDeleteTime string = "DeleteTime"
+	// UserFieldCreatedBy 创建用户的操作者
+	UserFieldCreatedBy string = "CreatedBy"
+	// UserFieldModifiedBy 最后一次修改用户的操作者
+	UserFieldModifiedBy string = "ModifiedBy"
 )
 
 var (
@@ -70,9 +88,32 @@ var (
 	ErrMultipleUserFound = errors.New("multiple user found")
 )
 
+var (
+	_ store.UserReader = (*userStore)(nil)
+	_ store.UserWriter = (*userStore)(nil)
+)
+
 // userStore
 type userStore struct {
 	handler BoltHandler
+	// cacheExcludePassword GetUsersForCache 不加载用户密码
+	cacheExcludePassword bool
+	// cacheExcludeToken GetUsersForCache 不加载用户 token，仅在不使用缓存进行 token 校验时开启
+	cacheExcludeToken bool
+	// cacheProjection GetUsersForCache 只保留缓存需要的字段
+	cacheProjection bool
+	// cacheWithGroups GetUsersForCache 同时返回用户所属的有效用户组 ID
+	cacheWithGroups bool
+	// reuseDefaultStrategy 同名的默认策略属于其他用户时复用该策略，否则返回 DataConflictErr
+	reuseDefaultStrategy bool
+	// changeLog 记录用户的变更
+	changeLog bool
+	// changeCompact 读取用户变更时，同一个用户的多条变更只返回最后一条
+	changeCompact bool
+	// passwordAge 两次修改密码之间的最短间隔，零值时不限制
+	passwordAge store.PasswordAgePolicy
+	// cascadeSubAccounts 删除主账户时级联删除其子账户，并清理子账户的默认策略
+	cascadeSubAccounts bool
 }
 
 // AddUser 添加用户
@@ -84,10 +125,115 @@ func (us *userStore) AddUser(user *model.User) error {
 		user.Owner == "" || user.Token == "" {
 		return store.NewStatusError(store.EmptyParamsErr, "add user missing some params")
 	}
+	if err := store.CheckUserOwner(user); err != nil {
+		return err
+	}
 
 	return us.addUser(user)
 }
 
+// AddUserAndReturn 添加用户，并在同一个事务中读取写入后的用户返回
+func (us *userStore) AddUserAndReturn(user *model.User) (*model.User, error) {
+	initUser(user)
+
+	if user.ID == "" || user.Name == "" || user.Source == "" ||
+		user.Owner == "" || user.Token == "" {
+		return nil, store.NewStatusError(store.EmptyParamsErr, "add user missing some params")
+	}
+	if err := store.CheckUserOwner(user); err != nil {
+		return nil, err
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return nil, err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := us.addUserTx(tx, user); err != nil {
+		return nil, err
+	}
+	created, err := us.getUser(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] save user tx commit fail", zap.Error(err),
+			zap.String("name", user.Name))
+		return nil, err
+	}
+	return created, nil
+}
+
+// AddUserTx 在外部事务中添加用户
+func (us *userStore) AddUserTx(tx store.Tx, user *model.User) error {
+	initUser(user)
+
+	if user.ID == "" || user.Name == "" || user.Source == "" ||
+		user.Owner == "" || user.Token == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "add user missing some params")
+	}
+	if err := store.CheckUserOwner(user); err != nil {
+		return err
+	}
+
+	return us.addUserTx(tx.GetDelegateTx().(*bolt.Tx), user)
+}
+
+// BatchAddUsersBestEffort 逐个添加用户，每个用户在单独的事务中写入，某个用户失败时不影响其他用户
+// 返回的结果与 users 一一对应
+func (us *userStore) BatchAddUsersBestEffort(users []*model.User) []store.BatchItemResult {
+	results, failed := store.BestEffortUsers(users, us.AddUser)
+	if failed > 0 {
+		log.Warn("[Store][User] batch add user best effort", zap.Int("count", len(users)), zap.Int("failed", failed))
+	}
+	return results
+}
+
+// BatchAddUser 在同一个事务中批量添加用户
+func (us *userStore) BatchAddUser(users []*model.User) error {
+	for i := range users {
+		initUser(users[i])
+		if users[i].ID == "" || users[i].Name == "" || users[i].Source == "" ||
+			users[i].Owner == "" || users[i].Token == "" {
+			return store.NewStatusError(store.EmptyParamsErr, "batch add user missing some params")
+		}
+		if err := store.CheckUserOwner(users[i]); err != nil {
+			return err
+		}
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for i := range users {
+		if err := us.addUserTx(tx, users[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] batch save user tx commit fail", zap.Error(err), zap.Int("count", len(users)))
+		return err
+	}
+	return nil
+}
+
 func (us *userStore) addUser(user *model.User) error {
 	proxy, err := us.handler.StartTx()
 	if err != nil {
@@ -99,29 +245,41 @@ func (us *userStore) addUser(user *model.User) error {
 		_ = tx.Rollback()
 	}()
 
+	if err := us.addUserTx(tx, user); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] save user tx commit fail", zap.Error(err),
+			zap.String("name", user.Name))
+		return err
+	}
+	return nil
+}
+
+func (us *userStore) addUserTx(tx *bolt.Tx, user *model.User) error {
 	owner := user.Owner
 	if owner == "" {
 		owner = user.ID
 	}
 
+	if err := checkUserTokenConflict(tx, user.ID, user.Token); err != nil {
+		return err
+	}
+
 	// 添加用户信息
 	if err := us.addUserMain(tx, user); err != nil {
 		return err
 	}
 
 	// 添加用户的默认策略
-	if err := createDefaultStrategy(tx, model.PrincipalUser, user.ID, user.Name, owner); err != nil {
+	if err := createDefaultStrategy(tx, model.PrincipalUser, user.ID, user.Name, owner,
+		us.reuseDefaultStrategy); err != nil {
 		log.Error("[Store][User] create user default strategy fail", zap.Error(err),
 			zap.String("name", user.Name))
 		return err
 	}
-
-	if err := tx.Commit(); err != nil {
-		log.Error("[Store][User] save user tx commit fail", zap.Error(err),
-			zap.String("name", user.Name))
-		return err
-	}
-	return nil
+	return us.recordUserChanges(tx, model.UserChangeCreate, user.ID)
 }
 
 func (us *userStore) addUserMain(tx *bolt.Tx, user *model.User) error {
@@ -139,6 +297,50 @@ func (us *userStore) UpdateUser(user *model.User) error {
 		return store.NewStatusError(store.EmptyParamsErr, "update user missing some params")
 	}
 
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := us.updateUserTx(tx, user); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] update user tx commit", zap.Error(err), zap.String("id", user.ID))
+		return err
+	}
+	return nil
+}
+
+// UpdateUserTx 在外部事务中更新用户
+func (us *userStore) UpdateUserTx(tx store.Tx, user *model.User) error {
+	if user.ID == "" || user.Token == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "update user missing some params")
+	}
+
+	return us.updateUserTx(tx.GetDelegateTx().(*bolt.Tx), user)
+}
+
+func (us *userStore) updateUserTx(tx *bolt.Tx, user *model.User) error {
+	saved := make(map[string]interface{})
+	if err := loadValues(tx, tblUser, []string{user.ID}, &userForStore{}, saved); err != nil {
+		log.Error("[Store][User] get user by id", zap.Error(err), zap.String("id", user.ID))
+		return err
+	}
+	val, ok := saved[user.ID].(*userForStore)
+	if !ok || !val.Valid {
+		return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", user.ID))
+	}
+	if err := checkUserTokenConflict(tx, user.ID, user.Token); err != nil {
+		return err
+	}
+
 	properties := make(map[string]interface{})
 	properties[UserFieldComment] = user.Comment
 	properties[UserFieldToken] = user.Token
@@ -147,14 +349,26 @@ func (us *userStore) UpdateUser(user *model.User) error {
 	properties[UserFieldMobile] = user.Mobile
 	properties[UserFieldPassword] = user.Password
 	properties[UserFieldModifyTime] = time.Now()
+	properties[UserFieldModifiedBy] = user.ModifiedBy
+	// token 发生变化时记录 token 的更换时间
+	if val.Token != user.Token {
+		properties[UserFieldTokenRotatedTime] = time.Now()
+	}
 
-	err := us.handler.UpdateValue(tblUser, user.ID, properties)
-	if err != nil {
+	// 密码发生变化时重置密码修改时间并清除强制修改密码标记
+	if val.Password != user.Password {
+		if err := us.passwordAge.Check(converToUserModel(val), user.AdminOperated, time.Now()); err != nil {
+			return err
+		}
+		properties[UserFieldPasswordSetTime] = time.Now()
+		properties[UserFieldMustChangePassword] = false
+	}
+
+	if err := updateValue(tx, tblUser, user.ID, properties); err != nil {
 		log.Error("[Store][User] update user fail", zap.Error(err), zap.String("id", user.ID))
 		return err
 	}
-
-	return nil
+	return us.recordUserChanges(tx, model.UserChangeUpdate, user.ID)
 }
 
 // DeleteUser 删除用户
@@ -166,6 +380,177 @@ func (us *userStore) DeleteUser(user *model.User) error {
 	return us.deleteUser(user)
 }
 
+// BatchDeleteUsersBestEffort 逐个删除用户，每个用户在单独的事务中删除，某个用户失败时不影响其他用户
+// 返回的结果与 users 一一对应
+func (us *userStore) BatchDeleteUsersBestEffort(users []*model.User) []store.BatchItemResult {
+	results, failed := store.BestEffortUsers(users, us.DeleteUser)
+	if failed > 0 {
+		log.Warn("[Store][User] batch delete user best effort", zap.Int("count", len(users)),
+			zap.Int("failed", failed))
+	}
+	return results
+}
+
+// DeleteUserTx 在外部事务中删除用户
+func (us *userStore) DeleteUserTx(tx store.Tx, user *model.User) error {
+	if user.ID == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "delete user missing some params")
+	}
+
+	return us.deleteUserTx(tx.GetDelegateTx().(*bolt.Tx), user)
+}
+
+// SoftDeleteUser 删除用户，cascadeGroups 为 true 时同时将用户从所在的用户组中移除，
+// 否则保留用户组中的成员关系，恢复用户后成员关系随之恢复
+func (us *userStore) SoftDeleteUser(user *model.User, cascadeGroups bool) error {
+	if user.ID == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "delete user missing some params")
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := us.deleteUserTx(tx, user); err != nil {
+		return err
+	}
+	if cascadeGroups {
+		if err := removeUserFromGroups(tx, user.ID); err != nil {
+			log.Error("[Store][User] remove user from usergroups", zap.Error(err), zap.String("id", user.ID))
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] soft delete user tx commit", zap.Error(err), zap.String("id", user.ID))
+		return err
+	}
+	return nil
+}
+
+// loadUserGroups 查询用户所在的有效用户组
+func loadUserGroups(tx *bolt.Tx, userId string) (map[string]interface{}, error) {
+	groups := make(map[string]interface{})
+	err := loadValuesByFilter(tx, tblGroup, []string{GroupFieldUserIds, GroupFieldValid}, &groupForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[GroupFieldValid].(bool)
+			if !valid {
+				return false
+			}
+			userIds, _ := m[GroupFieldUserIds].(map[string]string)
+			_, exist := userIds[userId]
+			return exist
+		}, groups)
+	return groups, err
+}
+
+// removeUserFromGroups 将用户从所在的用户组中移除，同时更新用户组的 ModifyTime 触发缓存刷新
+func removeUserFromGroups(tx *bolt.Tx, userId string) error {
+	groups, err := loadUserGroups(tx, userId)
+	if err != nil {
+		return err
+	}
+	for _, v := range groups {
+		group := v.(*groupForStore)
+		delete(group.UserIds, userId)
+		group.ModifyTime = time.Now()
+		if err := saveValue(tx, tblGroup, group.ID, group); err != nil {
+			return err
+		}
+		if err := saveGroupRelations(tx, group.ID, toIdSet([]string{userId}), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecoverUser 恢复已经被删除的用户，用户组中保留的成员关系重新生效，同时重建删除时清理掉的默认策略
+func (us *userStore) RecoverUser(userId, modifiedBy string) error {
+	if userId == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "recover user missing user id")
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ret := make(map[string]interface{})
+	if err := loadValues(tx, tblUser, []string{userId}, &userForStore{}, ret); err != nil {
+		log.Error("[Store][User] get user by id", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	saved, ok := ret[userId].(*userForStore)
+	if !ok || saved.Valid {
+		return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("deleted user(%s) not found", userId))
+	}
+	if err := checkUserTokenConflict(tx, userId, saved.Token); err != nil {
+		return err
+	}
+
+	properties := make(map[string]interface{})
+	properties[UserFieldValid] = true
+	properties[UserFieldModifyTime] = time.Now()
+	properties[UserFieldModifiedBy] = modifiedBy
+	properties[UserFieldDeleteTime] = time.Time{}
+	if err := updateValue(tx, tblUser, userId, properties); err != nil {
+		log.Error("[Store][User] recover user by id", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+
+	// 刷新用户所在用户组的 ModifyTime，触发 cache 重新加载成员关系
+	groups, err := loadUserGroups(tx, userId)
+	if err != nil {
+		return err
+	}
+	for id := range groups {
+		if err := updateValue(tx, tblGroup, id, map[string]interface{}{
+			GroupFieldModifyTime: time.Now(),
+		}); err != nil {
+			return err
+		}
+		if err := saveGroupRelations(tx, id, toIdSet([]string{userId}), true); err != nil {
+			return err
+		}
+	}
+
+	owner := saved.Owner
+	if owner == "" {
+		owner = userId
+	}
+	rebuild, err := cleanBrokenDefaultStrategy(tx, model.PrincipalUser, userId, saved.Name, owner)
+	if err != nil {
+		return err
+	}
+	if rebuild {
+		if err := createDefaultStrategy(tx, model.PrincipalUser, userId, saved.Name, owner,
+			us.reuseDefaultStrategy); err != nil {
+			log.Error("[Store][User] rebuild user default strategy fail", zap.Error(err), zap.String("id", userId))
+			return err
+		}
+	}
+	if err := us.recordUserChanges(tx, model.UserChangeCreate, userId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] recover user tx commit", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	return nil
+}
+
 func (us *userStore) deleteUser(user *model.User) error {
 	proxy, err := us.handler.StartTx()
 	if err != nil {
@@ -177,9 +562,23 @@ func (us *userStore) deleteUser(user *model.User) error {
 		_ = tx.Rollback()
 	}()
 
+	if err := us.deleteUserTx(tx, user); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] delete user tx commit", zap.Error(err), zap.String("id", user.ID))
+		return err
+	}
+	return nil
+}
+
+func (us *userStore) deleteUserTx(tx *bolt.Tx, user *model.User) error {
 	properties := make(map[string]interface{})
 	properties[UserFieldValid] = false
 	properties[UserFieldModifyTime] = time.Now()
+	properties[UserFieldModifiedBy] = user.ModifiedBy
+	properties[UserFieldDeleteTime] = time.Now()
 
 	if err := updateValue(tx, tblUser, user.ID, properties); err != nil {
 		log.Error("[Store][User] delete user by id", zap.Error(err), zap.String("id", user.ID))
@@ -194,12 +593,53 @@ func (us *userStore) deleteUser(user *model.User) error {
 	if err := cleanLinkStrategy(tx, model.PrincipalUser, user.ID, user.Owner); err != nil {
 		return err
 	}
+	if us.cascadeSubAccounts && user.Type != model.SubAccountUserRole {
+		if err := us.deleteSubAccountsTx(tx, user); err != nil {
+			return err
+		}
+	}
+	return us.recordUserChanges(tx, model.UserChangeDelete, user.ID)
+}
 
-	if err := tx.Commit(); err != nil {
-		log.Error("[Store][User] delete user tx commit", zap.Error(err), zap.String("id", user.ID))
+// deleteSubAccountsTx 删除主账户时级联删除其有效的子账户，并清理子账户的默认策略
+func (us *userStore) deleteSubAccountsTx(tx *bolt.Tx, user *model.User) error {
+	values := make(map[string]interface{})
+	err := loadValuesByFilter(tx, tblUser, []string{UserFieldOwner, UserFieldValid}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			if ok && !valid {
+				return false
+			}
+			owner, _ := m[UserFieldOwner].(string)
+			return owner == user.ID
+		}, values)
+	if err != nil {
+		log.Error("[Store][User] load sub-accounts", zap.Error(err), zap.String("owner", user.ID))
 		return err
 	}
-	return nil
+
+	subIds := make([]string, 0, len(values))
+	for id := range values {
+		properties := make(map[string]interface{})
+		properties[UserFieldValid] = false
+		properties[UserFieldModifyTime] = time.Now()
+		properties[UserFieldModifiedBy] = user.ModifiedBy
+		properties[UserFieldDeleteTime] = time.Now()
+		if err := updateValue(tx, tblUser, id, properties); err != nil {
+			log.Error("[Store][User] delete sub-account", zap.Error(err), zap.String("id", id))
+			return err
+		}
+		// 子账户的默认策略以所属主账户的 ID 作为 owner
+		if err := cleanLinkStrategy(tx, model.PrincipalUser, id, user.ID); err != nil {
+			return err
+		}
+		subIds = append(subIds, id)
+	}
+	if len(subIds) != 0 {
+		log.Info("[Store][User] cascade delete sub-accounts", zap.String("owner", user.ID),
+			zap.Int("count", len(subIds)))
+	}
+	return us.recordUserChanges(tx, model.UserChangeDelete, subIds...)
 }
 
 // GetUser 获取用户
@@ -220,6 +660,23 @@ func (us *userStore) GetUser(id string) (*model.User, error) {
 	return us.getUser(tx, id)
 }
 
+// GetUserIfModified 获取用户，用户的版本号与 etag 一致时返回 NotModified
+func (us *userStore) GetUserIfModified(id, etag string) (*model.User, error) {
+	user, err := us.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.CheckUserModified(user, etag); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserTx 在外部事务中获取用户
+func (us *userStore) GetUserTx(tx store.Tx, id string) (*model.User, error) {
+	return us.getUser(tx.GetDelegateTx().(*bolt.Tx), id)
+}
+
 // GetUser 获取用户
 func (us *userStore) getUser(tx *bolt.Tx, id string) (*model.User, error) {
 	if id == "" {
@@ -311,6 +768,14 @@ func (us *userStore) GetUserByIds(ids []string) ([]*model.User, error) {
 	return users, nil
 }
 
+// GetUserByIdsWithContext 通过用户ID批量获取用户，boltdb 为本地读取，只在读取前检查 ctx 是否已经取消
+func (us *userStore) GetUserByIdsWithContext(ctx context.Context, ids []string) ([]*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return us.GetUserByIds(ids)
+}
+
 // GetSubCount 获取子账户的个数
 func (us *userStore) GetSubCount(user *model.User) (uint32, error) {
 	ownerId := user.ID
@@ -335,93 +800,266 @@ func (us *userStore) GetSubCount(user *model.User) (uint32, error) {
 
 // GetUsers 获取用户列表
 func (us *userStore) GetUsers(filters map[string]string, offset uint32, limit uint32) (uint32, []*model.User, error) {
-	if _, ok := filters["group_id"]; ok {
-		return us.getGroupUsers(filters, offset, limit)
+	query, err := store.ParseUserQuery(filters)
+	if err != nil {
+		return 0, nil, err
+	}
+	return us.QueryUsers(query, offset, limit)
+}
+
+// QueryUsers 按照类型化的查询条件查询用户列表
+func (us *userStore) QueryUsers(query *store.UserQuery, offset uint32, limit uint32) (uint32,
+	[]*model.User, error) {
+	limit, err := store.CheckUserPage(offset, limit, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := query.CheckValues(); err != nil {
+		return 0, nil, err
+	}
+	var namedGroupUsers map[string]struct{}
+	if query.GroupName != "" {
+		if namedGroupUsers, err = us.loadNamedGroupUserIds(query.GroupName, query.GroupOwner); err != nil {
+			return 0, nil, err
+		}
+	}
+	if query.GroupID != "" {
+		// 用户组下的用户必然加入了用户组
+		if query.HasGroup != nil && !*query.HasGroup {
+			return 0, []*model.User{}, nil
+		}
+		return us.getGroupUsers(query, namedGroupUsers, offset, limit)
+	}
+
+	return us.getUsers(query, namedGroupUsers, offset, limit)
+}
+
+// QueryUsersWithOwner 查询用户列表，同时查询出每个用户所属主账户的名称
+func (us *userStore) QueryUsersWithOwner(query *store.UserQuery, offset uint32, limit uint32) (uint32,
+	[]*model.UserWithOwner, error) {
+	total, users, err := us.QueryUsers(query, offset, limit)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	return us.getUsers(filters, offset, limit)
+	ownerIds := make([]string, 0, len(users))
+	for i := range users {
+		if users[i].Owner != "" {
+			ownerIds = append(ownerIds, users[i].Owner)
+		}
+	}
+	owners, err := us.GetUserByIds(ownerIds)
+	if err != nil {
+		return 0, nil, err
+	}
+	ownerNames := make(map[string]string, len(owners))
+	for i := range owners {
+		ownerNames[owners[i].ID] = owners[i].Name
+	}
+
+	ret := make([]*model.UserWithOwner, 0, len(users))
+	for i := range users {
+		ret = append(ret, model.NewUserWithOwner(users[i], ownerNames[users[i].Owner]))
+	}
+	return total, ret, nil
 }
 
-// getUsers
-// "name":   1,
-// "owner":  1,
-// "source": 1,
-func (us *userStore) getUsers(filters map[string]string, offset uint32, limit uint32) (uint32, []*model.User, error) {
-	fields := []string{UserFieldID, UserFieldName, UserFieldOwner, UserFieldSource, UserFieldValid, UserFieldType}
+// getUsers 查询用户列表，namedGroupUsers 不为 nil 时只返回其中的用户
+func (us *userStore) getUsers(query *store.UserQuery, namedGroupUsers map[string]struct{}, offset uint32,
+	limit uint32) (uint32, []*model.User, error) {
+	var groupUsers map[string]struct{}
+	if query.HasGroup != nil {
+		var err error
+		if groupUsers, err = us.loadGroupUserIds(); err != nil {
+			return 0, nil, err
+		}
+	}
+	var subtree map[string]struct{}
+	if query.Owner != "" && query.OwnerRecursive {
+		var err error
+		if subtree, err = us.loadOwnerSubtree(query.Owner); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	excluded := toUserIdSet(query.ExcludeIDs)
+
+	fields := []string{UserFieldID, UserFieldName, UserFieldOwner, UserFieldSource, UserFieldValid, UserFieldType,
+		UserFieldTokenEnable, UserFieldCreateTime, UserFieldLastLoginTime, UserFieldComment, UserFieldDeleteTime,
+		UserFieldCreatedBy, UserFieldModifyTime, UserFieldModifiedBy, UserFieldTokenRotatedTime}
 	ret, err := us.handler.LoadValuesByFilter(tblUser, fields, &userForStore{},
 		func(m map[string]interface{}) bool {
 
 			valid, ok := m[UserFieldValid].(bool)
-			if ok && !valid {
+			if ok && !valid && !query.IncludeDeleted {
 				return false
 			}
 
-			saveId, _ := m[UserFieldID].(string)
-			saveName, _ := m[UserFieldName].(string)
-			saveOwner, _ := m[UserFieldOwner].(string)
-			saveSource, _ := m[UserFieldSource].(string)
+			user := &userForStore{Valid: !ok || valid}
+			user.ID, _ = m[UserFieldID].(string)
+			user.Name, _ = m[UserFieldName].(string)
+			user.Owner, _ = m[UserFieldOwner].(string)
+			user.Source, _ = m[UserFieldSource].(string)
+			user.Comment, _ = m[UserFieldComment].(string)
+			user.TokenEnable, _ = m[UserFieldTokenEnable].(bool)
+			user.CreateTime, _ = m[UserFieldCreateTime].(time.Time)
+			user.LastLoginTime, _ = m[UserFieldLastLoginTime].(time.Time)
+			user.DeleteTime, _ = m[UserFieldDeleteTime].(time.Time)
+			user.CreatedBy, _ = m[UserFieldCreatedBy].(string)
+			user.ModifyTime, _ = m[UserFieldModifyTime].(time.Time)
+			user.ModifiedBy, _ = m[UserFieldModifiedBy].(string)
+			user.TokenRotatedTime, _ = m[UserFieldTokenRotatedTime].(time.Time)
 			saveType, _ := m[UserFieldType].(int64)
+			user.Type = int(saveType)
 
-			// 超级账户不做展示
-			if model.UserRoleType(saveType) == model.AdminUserRole &&
-				strings.Compare("true", filters["hide_admin"]) == 0 {
+			if _, ok := excluded[user.ID]; ok {
 				return false
 			}
-
-			if name, ok := filters["name"]; ok {
-				if utils.IsPrefixWildName(name) {
-					if !strings.Contains(saveName, name[:len(name)-1]) {
-						return false
-					}
-				} else {
-					if saveName != name {
-						return false
-					}
-				}
+			if !matchNamedGroupUser(namedGroupUsers, user.ID) {
+				return false
 			}
-
-			if owner, ok := filters["owner"]; ok {
-				if owner != saveOwner && saveId != owner {
-					return false
-				}
+			if !matchUserQuery(query, user) {
+				return false
 			}
-
-			if source, ok := filters["source"]; ok {
-				if source != saveSource {
+			if subtree != nil {
+				// 主账户自身以及整棵子账户树
+				if _, ok := subtree[user.ID]; !ok {
 					return false
 				}
+			} else if query.Owner != "" && query.Owner != user.Owner && query.Owner != user.ID {
+				// 主账户自身以及其下的直接子账户
+				return false
 			}
-
-			if queryId, ok := filters["id"]; ok {
-				if queryId != saveId {
+			if groupUsers != nil {
+				if _, ok := groupUsers[user.ID]; ok != *query.HasGroup {
 					return false
 				}
 			}
-
 			return true
 		})
 
 	if err != nil {
-		log.Error("[Store][User] get users", zap.Error(err), zap.Any("filters", filters))
+		log.Error("[Store][User] get users", zap.Error(err), zap.Any("query", query))
 		return 0, nil, err
 	}
 	if len(ret) == 0 {
 		return 0, nil, nil
 	}
 
-	return uint32(len(ret)), doUserPage(ret, offset, limit), nil
+	return uint32(len(ret)), doUserPage(ret, query.Order, offset, limit), nil
 }
 
-// getGroupUsers 获取某个用户组下的所有用户列表数据信息
-func (us *userStore) getGroupUsers(filters map[string]string, offset uint32, limit uint32) (uint32,
-	[]*model.User, error) {
+// loadOwnerSubtree 按照 owner 逐级展开，返回 owner 自身及其下所有层级子账户的 ID 集合，只沿着有效的用户展开
+func (us *userStore) loadOwnerSubtree(owner string) (map[string]struct{}, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldID, UserFieldOwner, UserFieldValid},
+		&userForStore{}, func(m map[string]interface{}) bool {
+			valid, _ := m[UserFieldValid].(bool)
+			return valid
+		})
+	if err != nil {
+		log.Error("[Store][User] load owner subtree", zap.String("owner", owner), zap.Error(err))
+		return nil, err
+	}
+
+	children := make(map[string][]string, len(ret))
+	for _, v := range ret {
+		user := v.(*userForStore)
+		if user.Owner != "" && user.Owner != user.ID {
+			children[user.Owner] = append(children[user.Owner], user.ID)
+		}
+	}
+	// 已经展开过的用户不再重复展开，避免 owner 成环时无限循环
+	subtree := map[string]struct{}{owner: {}}
+	pending := []string{owner}
+	for len(pending) != 0 {
+		id := pending[0]
+		pending = pending[1:]
+		for _, child := range children[id] {
+			if _, ok := subtree[child]; ok {
+				continue
+			}
+			subtree[child] = struct{}{}
+			pending = append(pending, child)
+		}
+	}
+	return subtree, nil
+}
+
+// loadGroupUserIds 获取加入了任意一个有效用户组的用户 ID 集合
+func (us *userStore) loadGroupUserIds() (map[string]struct{}, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblGroup, []string{GroupFieldValid}, &groupForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[GroupFieldValid].(bool)
+			return valid
+		})
+	if err != nil {
+		log.Error("[Store][User] load user groups", zap.Error(err))
+		return nil, err
+	}
+
+	userIds := make(map[string]struct{})
+	for _, v := range ret {
+		for userId := range v.(*groupForStore).UserIds {
+			userIds[userId] = struct{}{}
+		}
+	}
+	return userIds, nil
+}
+
+// loadNamedGroupUserIds 获取名称为 name 的有效用户组下的用户 ID 集合，同名的用户组可能属于不同的主账户，
+// owner 不为空时只匹配该主账户下的用户组
+func (us *userStore) loadNamedGroupUserIds(name, owner string) (map[string]struct{}, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblGroup, []string{GroupFieldValid, GroupFieldName, GroupFieldOwner},
+		&groupForStore{}, func(m map[string]interface{}) bool {
+			valid, _ := m[GroupFieldValid].(bool)
+			saveName, _ := m[GroupFieldName].(string)
+			saveOwner, _ := m[GroupFieldOwner].(string)
+			return valid && saveName == name && (owner == "" || saveOwner == owner)
+		})
+	if err != nil {
+		log.Error("[Store][User] load user groups by name", zap.String("name", name), zap.Error(err))
+		return nil, err
+	}
+
+	userIds := make(map[string]struct{})
+	for _, v := range ret {
+		for userId := range v.(*groupForStore).UserIds {
+			userIds[userId] = struct{}{}
+		}
+	}
+	return userIds, nil
+}
+
+// matchNamedGroupUser 按照用户组名称过滤，namedGroupUsers 为 nil 时表示不过滤
+func matchNamedGroupUser(namedGroupUsers map[string]struct{}, userId string) bool {
+	if namedGroupUsers == nil {
+		return true
+	}
+	_, ok := namedGroupUsers[userId]
+	return ok
+}
 
-	groupId := filters["group_id"]
-	delete(filters, "group_id")
+// matchUserName 用户名称过滤，name* 表示模糊查询，多个名称以逗号分隔时命中任意一个即可
+func matchUserName(saveName, name string) bool {
+	if utils.IsPrefixWildName(name) {
+		return strings.Contains(saveName, name[:len(name)-1])
+	}
+	for _, item := range strings.Split(name, ",") {
+		if item == saveName {
+			return true
+		}
+	}
+	return false
+}
+
+// getGroupUsers 获取某个用户组下的所有用户列表数据信息，namedGroupUsers 不为 nil 时只返回其中的用户
+func (us *userStore) getGroupUsers(query *store.UserQuery, namedGroupUsers map[string]struct{}, offset uint32,
+	limit uint32) (uint32, []*model.User, error) {
+	groupId := query.GroupID
 
 	ret, err := us.handler.LoadValues(tblGroup, []string{groupId}, &groupForStore{})
 	if err != nil {
-		log.Error("[Store][User] get user groups", zap.Error(err), zap.Any("filters", filters))
+		log.Error("[Store][User] get user groups", zap.Error(err), zap.Any("query", query))
 		return 0, nil, err
 	}
 	if len(ret) == 0 {
@@ -431,6 +1069,10 @@ func (us *userStore) getGroupUsers(filters map[string]string, offset uint32, lim
 		return 0, nil, ErrorMultipleGroupFound
 	}
 	group := ret[groupId].(*groupForStore)
+	// 用户组不属于指定的主账户时不返回其成员
+	if query.GroupOwner != "" && query.GroupOwner != group.Owner {
+		return 0, nil, nil
+	}
 
 	userIds := make([]string, 0, len(group.UserIds))
 	for k := range group.UserIds {
@@ -443,40 +1085,27 @@ func (us *userStore) getGroupUsers(filters map[string]string, offset uint32, lim
 		return 0, nil, err
 	}
 
+	excluded := toUserIdSet(query.ExcludeIDs)
 	predicate := func(user *userForStore) bool {
 		if !user.Valid {
 			return false
 		}
-
-		if model.UserRoleType(user.Type) == model.AdminUserRole {
+		if _, ok := excluded[user.ID]; ok {
 			return false
 		}
-
-		if name, ok := filters["name"]; ok {
-			if utils.IsPrefixWildName(name) {
-				if !strings.Contains(user.Name, name[:len(name)-1]) {
-					return false
-				}
-			} else {
-				if user.Name != name {
-					return false
-				}
-			}
+		if !matchNamedGroupUser(namedGroupUsers, user.ID) {
+			return false
 		}
 
-		if owner, ok := filters["owner"]; ok {
-			if owner != user.Owner {
-				return false
-			}
+		if model.UserRoleType(user.Type) == model.AdminUserRole {
+			return false
 		}
 
-		if source, ok := filters["source"]; ok {
-			if source != user.Source {
-				return false
-			}
+		if query.Owner != "" && query.Owner != user.Owner {
+			return false
 		}
 
-		return true
+		return matchUserQuery(query, user)
 	}
 
 	users := make(map[string]interface{})
@@ -487,93 +1116,1099 @@ func (us *userStore) getGroupUsers(filters map[string]string, offset uint32, lim
 		}
 	}
 
-	return uint32(len(ret)), doUserPage(users, offset, limit), err
+	return uint32(len(users)), doUserPage(users, query.Order, offset, limit), err
 }
 
-// GetUsersForCache 获取所有用户信息
-func (us *userStore) GetUsersForCache(mtime time.Time, firstUpdate bool) ([]*model.User, error) {
-	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldModifyTime}, &userForStore{},
-		func(m map[string]interface{}) bool {
-			mt := m[UserFieldModifyTime].(time.Time)
-			isBefore := mt.Before(mtime)
-			return !isBefore
-		})
-	if err != nil {
-		log.Error("[Store][User] get users for cache", zap.Error(err))
-		return nil, err
+// matchUserQuery 判断用户是否满足查询条件，owner 以及 has_group 的匹配方式与查询场景相关，由调用方处理
+func matchUserQuery(query *store.UserQuery, user *userForStore) bool {
+	// 超级账户不做展示
+	if query.HideAdmin && model.UserRoleType(user.Type) == model.AdminUserRole {
+		return false
+	}
+	if query.ID != "" && query.ID != user.ID {
+		return false
+	}
+	if query.Name != "" && !matchUserName(user.Name, query.Name) {
+		return false
+	}
+	if query.Source != "" && query.Source != user.Source {
+		return false
+	}
+	if query.CreatedBy != "" && query.CreatedBy != user.CreatedBy {
+		return false
+	}
+	if query.ModifiedBy != "" && query.ModifiedBy != user.ModifiedBy {
+		return false
+	}
+	if query.Keyword != "" && !containsFold(user.Name, query.Keyword) && !containsFold(user.Comment, query.Keyword) {
+		return false
+	}
+	if query.TokenEnable != nil && *query.TokenEnable != user.TokenEnable {
+		return false
+	}
+	if !query.CreatedAfter.IsZero() && user.CreateTime.Before(query.CreatedAfter) {
+		return false
+	}
+	if !query.ModifiedAfter.IsZero() && user.ModifyTime.Before(query.ModifiedAfter) {
+		return false
+	}
+	if !query.ModifiedBefore.IsZero() && !user.ModifyTime.Before(query.ModifiedBefore) {
+		return false
+	}
+	if query.HasDeleteTimeRange() {
+		// 未删除以及删除时间未知的用户均不满足条件，与 MySQL 中 deleted_at 为 NULL 时的行为保持一致
+		deleteTime := normalizeLoginTime(user.DeleteTime)
+		if user.Valid || deleteTime.IsZero() {
+			return false
+		}
+		if !query.DeletedAfter.IsZero() && deleteTime.Before(query.DeletedAfter) {
+			return false
+		}
+		if !query.DeletedBefore.IsZero() && !deleteTime.Before(query.DeletedBefore) {
+			return false
+		}
+	}
+	if !query.TokenRotatedBefore.IsZero() {
+		// token 更换时间未知的用户同样视为在该时间之后没有更换过 token
+		rotated := normalizeLoginTime(user.TokenRotatedTime)
+		if !rotated.IsZero() && !rotated.Before(query.TokenRotatedBefore) {
+			return false
+		}
 	}
+	if !query.LastLoginBefore.IsZero() {
+		lastLogin := normalizeLoginTime(user.LastLoginTime)
+		// 从未登录过的用户同样视为在该时间之后没有登录，除非指定了不返回从未登录过的用户
+		if lastLogin.IsZero() {
+			if query.ExcludeNeverLogin {
+				return false
+			}
+		} else if !lastLogin.Before(query.LastLoginBefore) {
+			return false
+		}
+	}
+	return true
+}
 
-	users := make([]*model.User, 0, len(ret))
-	for k := range ret {
-		val := ret[k]
-		users = append(users, converToUserModel(val.(*userForStore)))
+// toUserIdSet 将用户 ID 列表转换为集合，便于逐个用户判断
+func toUserIdSet(ids []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
 	}
+	return set
+}
 
-	return users, nil
+// containsFold 不区分大小写判断 s 中是否包含 substr，与 MySQL 默认排序规则下的 LIKE 行为保持一致
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
 
-// doPage 进行分页
-func doUserPage(ret map[string]interface{}, offset, limit uint32) []*model.User {
-	users := make([]*model.User, 0, len(ret))
-	beginIndex := offset
-	endIndex := beginIndex + limit
-	totalCount := uint32(len(ret))
+// GetAddableUsersForGroup 查询主账户下还没有加入该用户组的有效用户，用于编辑用户组成员
+// 用户组不存在或者不属于该主账户时返回 NotFoundUserGroup
+func (us *userStore) GetAddableUsersForGroup(groupId, ownerId string, offset uint32, limit uint32) (uint32,
+	[]*model.User, error) {
+	if groupId == "" || ownerId == "" {
+		return 0, nil, store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"get addable users missing some params, group is %s, owner is %s", groupId, ownerId))
+	}
+	values, err := us.handler.LoadValues(tblGroup, []string{groupId}, &groupForStore{})
+	if err != nil {
+		log.Error("[Store][User] get group members", zap.String("group", groupId), zap.Error(err))
+		return 0, nil, store.Error(err)
+	}
+	group, ok := values[groupId].(*groupForStore)
+	if !ok || !group.Valid || group.Owner != ownerId {
+		return 0, nil, store.NewStatusError(store.NotFoundUserGroup, fmt.Sprintf(
+			"group(%s) of owner(%s) not found", groupId, ownerId))
+	}
 
-	if totalCount == 0 {
-		return users
+	members := make([]string, 0, len(group.UserIds))
+	for id := range group.UserIds {
+		members = append(members, id)
 	}
-	if beginIndex >= endIndex {
-		return users
+	return us.QueryUsers(&store.UserQuery{Owner: ownerId, ExcludeIDs: members, HideAdmin: true}, offset, limit)
+}
+
+// GetUsersByStrategyID 查询关联到某个鉴权策略的用户列表，不包含超级账户
+func (us *userStore) GetUsersByStrategyID(strategyID string, offset uint32, limit uint32) (uint32,
+	[]*model.User, error) {
+	if strategyID == "" {
+		return 0, nil, store.NewStatusError(store.EmptyParamsErr, "strategy id is missing")
 	}
-	if beginIndex >= totalCount {
-		return users
+	limit, err := store.CheckUserPage(offset, limit, 0)
+	if err != nil {
+		return 0, nil, err
 	}
-	if endIndex > totalCount {
-		endIndex = totalCount
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return 0, nil, err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	strategy, err := loadStrategyById(tx, strategyID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if strategy == nil || len(strategy.Users) == 0 {
+		return 0, []*model.User{}, nil
+	}
+
+	userIds := make([]string, 0, len(strategy.Users))
+	for id := range strategy.Users {
+		userIds = append(userIds, id)
+	}
+
+	ret := make(map[string]interface{})
+	if err := loadValues(tx, tblUser, userIds, &userForStore{}, ret); err != nil {
+		log.Error("[Store][User] get users by strategy", zap.Error(err), zap.String("strategy", strategyID))
+		return 0, nil, err
 	}
+
+	users := make(map[string]interface{}, len(ret))
 	for k := range ret {
-		users = append(users, converToUserModel(ret[k].(*userForStore)))
+		user := ret[k].(*userForStore)
+		if !user.Valid || model.UserRoleType(user.Type) == model.AdminUserRole {
+			continue
+		}
+		users[k] = user
+	}
+
+	return uint32(len(users)), doUserPage(users, nil, offset, limit), nil
+}
+
+// GetMainAccountsWithSubCounts 按照 ctime 分页查询有效的主账户（owner 为自身）以及每个主账户下有效子账户的个数，不包含超级账户
+func (us *userStore) GetMainAccountsWithSubCounts(offset uint32, limit uint32) (uint32,
+	[]*model.UserWithSubCount, error) {
+	limit, err := store.CheckUserPage(offset, limit, 0)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	sort.Slice(users, func(i, j int) bool {
-		return users[i].ModifyTime.After(users[j].ModifyTime)
+	fields := []string{UserFieldValid}
+	ret, err := us.handler.LoadValuesByFilter(tblUser, fields, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[UserFieldValid].(bool)
+			return valid
+		})
+	if err != nil {
+		log.Error("[Store][User] list account accounts with sub counts", zap.Error(err))
+		return 0, nil, err
+	}
+
+	mains := make([]*model.UserWithSubCount, 0)
+	subCounts := make(map[string]uint32)
+	for k := range ret {
+		user := ret[k].(*userForStore)
+		if user.ID != user.Owner {
+			subCounts[user.Owner]++
+			continue
+		}
+		if model.UserRoleType(user.Type) == model.AdminUserRole {
+			continue
+		}
+		mains = append(mains, &model.UserWithSubCount{User: converToUserModel(user)})
+	}
+	sort.Slice(mains, func(i, j int) bool {
+		if !mains[i].CreateTime.Equal(mains[j].CreateTime) {
+			return mains[i].CreateTime.Before(mains[j].CreateTime)
+		}
+		return mains[i].ID < mains[j].ID
 	})
 
-	return users[beginIndex:endIndex]
+	total := uint32(len(mains))
+	if offset >= total {
+		return total, []*model.UserWithSubCount{}, nil
+	}
+	mains = mains[offset:min(offset+limit, total)]
+	for _, account := range mains {
+		account.SubCount = subCounts[account.ID]
+	}
+	return total, mains, nil
 }
 
-func converToUserStore(user *model.User) *userForStore {
-	return &userForStore{
-		ID:          user.ID,
-		Name:        user.Name,
-		Password:    user.Password,
-		Owner:       user.Owner,
-		Source:      user.Source,
-		Type:        int(user.Type),
-		Token:       user.Token,
-		TokenEnable: user.TokenEnable,
-		Valid:       user.Valid,
-		Comment:     user.Comment,
-		CreateTime:  user.CreateTime,
-		ModifyTime:  user.ModifyTime,
+// GetRecentlyModifiedUsers 按照 mtime 倒序获取最近修改过的用户，不包含超级账户
+func (us *userStore) GetRecentlyModifiedUsers(limit uint32) ([]*model.User, error) {
+	if limit == 0 {
+		return []*model.User{}, nil
+	}
+	if limit > store.MaxRecentlyModifiedUsers {
+		limit = store.MaxRecentlyModifiedUsers
+	}
+
+	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldValid, UserFieldType}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[UserFieldValid].(bool)
+			saveType, _ := m[UserFieldType].(int64)
+			return valid && model.UserRoleType(saveType) != model.AdminUserRole
+		})
+	if err != nil {
+		log.Error("[Store][User] get recently modified users", zap.Error(err))
+		return nil, err
 	}
+
+	return doUserPage(ret, &store.UserOrder{Field: "mtime", Desc: true}, 0, limit), nil
 }
 
-func converToUserModel(user *userForStore) *model.User {
-	return &model.User{
-		ID:          user.ID,
-		Name:        user.Name,
-		Password:    user.Password,
-		Owner:       user.Owner,
-		Source:      user.Source,
-		Type:        model.UserRoleType(user.Type),
-		Token:       user.Token,
-		TokenEnable: user.TokenEnable,
-		Valid:       user.Valid,
-		Comment:     user.Comment,
-		CreateTime:  user.CreateTime,
-		ModifyTime:  user.ModifyTime,
+// GetUserCountsBySource 按照用户来源统计有效用户的个数，不包含超级账户
+func (us *userStore) GetUserCountsBySource() (map[string]uint32, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldValid, UserFieldType}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[UserFieldValid].(bool)
+			saveType, _ := m[UserFieldType].(int64)
+			return valid && model.UserRoleType(saveType) != model.AdminUserRole
+		})
+	if err != nil {
+		log.Error("[Store][User] get user counts by source", zap.Error(err))
+		return nil, err
+	}
+
+	counts := make(map[string]uint32)
+	for _, v := range ret {
+		counts[v.(*userForStore).Source]++
+	}
+	return counts, nil
+}
+
+// GetUserCountsByType 按照用户类型统计有效的用户数量，不包含超级账户
+func (us *userStore) GetUserCountsByType() (map[model.UserRoleType]uint32, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldValid, UserFieldType}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[UserFieldValid].(bool)
+			saveType, _ := m[UserFieldType].(int64)
+			return valid && model.UserRoleType(saveType) != model.AdminUserRole
+		})
+	if err != nil {
+		log.Error("[Store][User] get user counts by type", zap.Error(err))
+		return nil, err
+	}
+
+	counts := make(map[model.UserRoleType]uint32)
+	for _, v := range ret {
+		counts[model.UserRoleType(v.(*userForStore).Type)]++
+	}
+	return counts, nil
+}
+
+// GetUsersForCache 获取所有用户信息
+func (us *userStore) GetUsersForCache(mtime time.Time, firstUpdate bool) ([]*model.User, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldModifyTime}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			mt := m[UserFieldModifyTime].(time.Time)
+			isBefore := mt.Before(mtime)
+			return !isBefore
+		})
+	if err != nil {
+		log.Error("[Store][User] get users for cache", zap.Error(err))
+		return nil, err
+	}
+
+	users := make([]*model.User, 0, len(ret))
+	for k := range ret {
+		user := converToUserModel(ret[k].(*userForStore))
+		if us.cacheProjection {
+			user = &model.User{
+				ID:          user.ID,
+				Name:        user.Name,
+				Owner:       user.Owner,
+				Token:       user.Token,
+				TokenEnable: user.TokenEnable,
+				Type:        user.Type,
+				ModifyTime:  user.ModifyTime,
+				Valid:       user.Valid,
+				// 密码过期的校验基于缓存中的用户
+				PasswordSetTime:    user.PasswordSetTime,
+				MustChangePassword: user.MustChangePassword,
+			}
+		}
+		// 不需要加载的敏感字段直接置空，避免落入缓存
+		if us.cacheExcludePassword {
+			user.Password = ""
+		}
+		if us.cacheExcludeToken {
+			user.Token = ""
+		}
+		users = append(users, user)
+	}
+
+	if err := us.fillUserGroupsForCache(users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ExportUsersSnapshot 在同一个只读事务中读取全部用户（包括已删除的用户）后按照 ID 顺序写出，每个用户一行 JSON
+// 只读事务读取的是开启时的快照，写出时事务已经结束，导出期间并发的写入既不会出现在结果中也不会被阻塞
+func (us *userStore) ExportUsersSnapshot(ctx context.Context, w io.Writer) error {
+	values := make(map[string]interface{})
+	err := us.handler.Execute(false, func(tx *bolt.Tx) error {
+		return loadValuesByFilter(tx, tblUser, []string{UserFieldID}, &userForStore{},
+			func(m map[string]interface{}) bool {
+				return true
+			}, values)
+	})
+	if err != nil {
+		log.Error("[Store][User] export users snapshot", zap.Error(err))
+		return err
+	}
+
+	ids := make([]string, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	encoder := json.NewEncoder(w)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return store.Error(err)
+		}
+		if err := encoder.Encode(store.NewUserSnapshot(converToUserModel(values[id].(*userForStore)))); err != nil {
+			log.Error("[Store][User] export users snapshot write", zap.String("id", id), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// fillUserGroupsForCache 开启 cacheWithGroups 时，根据有效用户组中的成员得到用户所属的用户组
+func (us *userStore) fillUserGroupsForCache(users []*model.User) error {
+	if !us.cacheWithGroups || len(users) == 0 {
+		return nil
+	}
+
+	values, err := us.handler.LoadValuesByFilter(tblGroup, []string{GroupFieldValid}, &groupForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[GroupFieldValid].(bool)
+			return valid
+		})
+	if err != nil {
+		log.Error("[Store][User] list user groups for cache", zap.Error(err))
+		return err
+	}
+
+	user2Groups := make(map[string][]string, len(users))
+	for _, v := range values {
+		group := v.(*groupForStore)
+		for userId := range group.UserIds {
+			user2Groups[userId] = append(user2Groups[userId], group.ID)
+		}
+	}
+	for _, user := range users {
+		if !user.Valid {
+			continue
+		}
+		groupIds := user2Groups[user.ID]
+		sort.Strings(groupIds)
+		user.GroupIDs = groupIds
+	}
+	return nil
+}
+
+// UpdateLastLogin 记录用户最近一次登录时间，不修改 ModifyTime
+func (us *userStore) UpdateLastLogin(userId string) error {
+	if userId == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "update last login missing user id")
+	}
+
+	properties := map[string]interface{}{
+		UserFieldLastLoginTime: time.Now(),
+	}
+	if err := us.handler.UpdateValue(tblUser, userId, properties); err != nil {
+		log.Error("[Store][User] update user last login time", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	return nil
+}
+
+// RenameUser 修改用户名称，重名校验、名称更新以及默认策略的改名在同一个事务中完成
+func (us *userStore) RenameUser(userId, newName, modifiedBy string) error {
+	if userId == "" || newName == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "rename user missing some params")
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	user, err := us.getUser(tx, userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", userId))
+	}
+
+	fields := []string{UserFieldID, UserFieldName, UserFieldOwner, UserFieldValid}
+	conflicts := make(map[string]interface{})
+	if err := loadValuesByFilter(tx, tblUser, fields, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			if ok && !valid {
+				return false
+			}
+			saveId, _ := m[UserFieldID].(string)
+			saveName, _ := m[UserFieldName].(string)
+			saveOwner, _ := m[UserFieldOwner].(string)
+			return saveId != userId && saveName == newName && saveOwner == user.Owner
+		}, conflicts); err != nil {
+		log.Error("[Store][User] rename user check name", zap.Error(err), zap.String("name", newName))
+		return err
+	}
+	if len(conflicts) != 0 {
+		return store.NewStatusError(store.DuplicateEntryErr, fmt.Sprintf(
+			"user name(%s) existed in owner(%s)", newName, user.Owner))
+	}
+
+	properties := map[string]interface{}{
+		UserFieldName:       newName,
+		UserFieldModifyTime: time.Now(),
+		UserFieldModifiedBy: modifiedBy,
+	}
+	if err := updateValue(tx, tblUser, userId, properties); err != nil {
+		log.Error("[Store][User] rename user fail", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	if err := renameDefaultStrategy(tx, model.PrincipalUser, userId, user.Name, newName); err != nil {
+		return err
+	}
+	if err := us.recordUserChanges(tx, model.UserChangeUpdate, userId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] rename user tx commit", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	return nil
+}
+
+// SwapUserNames 在同一个事务中交换同一个 owner 下两个用户以及其默认策略的名称
+func (us *userStore) SwapUserNames(userIdA, userIdB, modifiedBy string) error {
+	if userIdA == "" || userIdB == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "swap user names missing some params")
+	}
+	if userIdA == userIdB {
+		return store.NewStatusError(store.InvalidParameter, fmt.Sprintf(
+			"swap user names requires two different users, id is %s", userIdA))
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	users := make([]*model.User, 0, 2)
+	for _, id := range []string{userIdA, userIdB} {
+		user, err := us.getUser(tx, id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", id))
+		}
+		users = append(users, user)
+	}
+	if users[0].Owner != users[1].Owner {
+		return store.NewStatusError(store.InvalidParameter, fmt.Sprintf(
+			"user(%s) and user(%s) belong to different owners", userIdA, userIdB))
+	}
+
+	// boltdb 不存在名称的唯一约束，直接在同一个事务中交换名称即可
+	for i, user := range users {
+		properties := map[string]interface{}{
+			UserFieldName:       users[1-i].Name,
+			UserFieldModifyTime: time.Now(),
+			UserFieldModifiedBy: modifiedBy,
+		}
+		if err := updateValue(tx, tblUser, user.ID, properties); err != nil {
+			log.Error("[Store][User] swap user names", zap.Error(err), zap.String("id", user.ID))
+			return err
+		}
+	}
+	// 默认策略改名时会检查同名策略，先改为临时名称再交换，避免与对方尚未改名的默认策略冲突
+	placeholder := "swap-" + utils.NewUUID()
+	for _, step := range [][3]string{
+		{userIdA, users[0].Name, placeholder},
+		{userIdB, users[1].Name, users[0].Name},
+		{userIdA, placeholder, users[1].Name},
+	} {
+		if err := renameDefaultStrategy(tx, model.PrincipalUser, step[0], step[1], step[2]); err != nil {
+			return err
+		}
+	}
+	if err := us.recordUserChanges(tx, model.UserChangeUpdate, userIdA, userIdB); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] swap user names tx commit", zap.Error(err), zap.String("id-a", userIdA),
+			zap.String("id-b", userIdB))
+		return err
+	}
+	return nil
+}
+
+// ResetUserCredentials 在同一个事务中替换用户的密码以及 token，password 为已经计算过摘要的密码
+func (us *userStore) ResetUserCredentials(userId, password, token, modifiedBy string) error {
+	if userId == "" || password == "" || token == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "reset user credentials missing some params")
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	user, err := us.getUser(tx, userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", userId))
+	}
+	if err := checkUserTokenConflict(tx, userId, token); err != nil {
+		return err
+	}
+
+	properties := map[string]interface{}{
+		UserFieldPassword:   password,
+		UserFieldToken:      token,
+		UserFieldModifyTime: time.Now(),
+		UserFieldModifiedBy: modifiedBy,
+	}
+	// 与 updateUserTx 一致，密码发生变化时重置密码修改时间并清除强制修改密码标记，token 发生变化时记录更换时间
+	if user.Password != password {
+		properties[UserFieldPasswordSetTime] = time.Now()
+		properties[UserFieldMustChangePassword] = false
+	}
+	if user.Token != token {
+		properties[UserFieldTokenRotatedTime] = time.Now()
+	}
+	if err := updateValue(tx, tblUser, userId, properties); err != nil {
+		log.Error("[Store][User] reset user credentials fail", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	if err := us.recordUserChanges(tx, model.UserChangeUpdate, userId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] reset user credentials tx commit", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	return nil
+}
+
+// RebuildDefaultStrategy 重建用户的默认策略，先清理残留的数据再通过 createDefaultStrategy 重新创建，整个过程在同一个事务中
+// 用户已经关联了有效的默认策略时不做任何修改，因此可以重复调用
+func (us *userStore) RebuildDefaultStrategy(userId string) error {
+	if userId == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "rebuild default strategy missing user id")
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	user, err := us.getUser(tx, userId)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", userId))
+	}
+	owner := user.Owner
+	if owner == "" {
+		owner = user.ID
+	}
+
+	rebuild, err := cleanBrokenDefaultStrategy(tx, model.PrincipalUser, userId, user.Name, owner)
+	if err != nil {
+		return err
+	}
+	if !rebuild {
+		return nil
+	}
+	if err := createDefaultStrategy(tx, model.PrincipalUser, userId, user.Name, owner,
+		us.reuseDefaultStrategy); err != nil {
+		log.Error("[Store][User] rebuild user default strategy fail", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] rebuild default strategy tx commit", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	return nil
+}
+
+// PurgeDeletedUsers 物理删除在指定时间之前被逻辑删除的用户
+func (us *userStore) PurgeDeletedUsers(deletedBefore time.Time) (uint32, error) {
+	fields := []string{UserFieldValid, UserFieldDeleteTime, UserFieldModifyTime}
+	ret, err := us.handler.LoadValuesByFilter(tblUser, fields, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			if !ok || valid {
+				return false
+			}
+			// 没有记录删除时间的存量数据，删除时会同时更新 ModifyTime，以此作为近似的删除时间
+			deleteTime, _ := m[UserFieldDeleteTime].(time.Time)
+			if normalizeLoginTime(deleteTime).IsZero() {
+				deleteTime, _ = m[UserFieldModifyTime].(time.Time)
+			}
+			return deleteTime.Before(deletedBefore)
+		})
+	if err != nil {
+		log.Error("[Store][User] load deleted users", zap.Error(err))
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(ret))
+	for id := range ret {
+		ids = append(ids, id)
+	}
+	if len(ids) != 0 {
+		if err := us.handler.DeleteValues(tblUser, ids); err != nil {
+			log.Error("[Store][User] purge deleted users", zap.Error(err), zap.Strings("ids", ids))
+			return 0, err
+		}
+	}
+	if err := us.purgeGroupRelations(ret, deletedBefore); err != nil {
+		return 0, err
+	}
+	return uint32(len(ids)), nil
+}
+
+// purgeGroupRelations 清理被清理的用户的关联关系，以及在 deletedBefore 之前被移除的关联关系
+func (us *userStore) purgeGroupRelations(purged map[string]interface{}, deletedBefore time.Time) error {
+	fields := []string{GroupRelationFieldUserID, GroupRelationFieldRemoved, GroupRelationFieldModifyTime}
+	ret, err := us.handler.LoadValuesByFilter(tblGroupRelation, fields, &groupRelationForStore{},
+		func(m map[string]interface{}) bool {
+			userId, _ := m[GroupRelationFieldUserID].(string)
+			if _, ok := purged[userId]; ok {
+				return true
+			}
+			removed, _ := m[GroupRelationFieldRemoved].(bool)
+			mtime, _ := m[GroupRelationFieldModifyTime].(time.Time)
+			return removed && mtime.Before(deletedBefore)
+		})
+	if err != nil {
+		log.Error("[Store][User] load group relations to purge", zap.Error(err))
+		return err
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ret))
+	for key := range ret {
+		keys = append(keys, key)
+	}
+	if err := us.handler.DeleteValues(tblGroupRelation, keys); err != nil {
+		log.Error("[Store][User] purge group relations", zap.Error(err), zap.Strings("keys", keys))
+		return err
+	}
+	return nil
+}
+
+// SetUsersTokenEnable 批量启用或者禁用用户的 token，只更新状态发生变化的有效用户，返回发生变化的用户数量
+func (us *userStore) SetUsersTokenEnable(ids []string, enable bool, modifiedBy string) (uint32, error) {
+	if len(ids) == 0 {
+		return 0, store.NewStatusError(store.EmptyParamsErr, "set users token enable missing user ids")
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return 0, err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	idSet := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		idSet[id] = struct{}{}
+	}
+	fields := []string{UserFieldID, UserFieldValid, UserFieldTokenEnable}
+	ret := make(map[string]interface{})
+	if err := loadValuesByFilter(tx, tblUser, fields, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			if ok && !valid {
+				return false
+			}
+			saveId, _ := m[UserFieldID].(string)
+			if _, ok := idSet[saveId]; !ok {
+				return false
+			}
+			tokenEnable, _ := m[UserFieldTokenEnable].(bool)
+			return tokenEnable != enable
+		}, ret); err != nil {
+		log.Error("[Store][User] load users to set token enable", zap.Error(err), zap.Strings("ids", ids))
+		return 0, err
+	}
+
+	properties := map[string]interface{}{
+		UserFieldTokenEnable: enable,
+		UserFieldModifyTime:  time.Now(),
+		UserFieldModifiedBy:  modifiedBy,
+	}
+	for id := range ret {
+		if err := updateValue(tx, tblUser, id, properties); err != nil {
+			log.Error("[Store][User] set user token enable", zap.Error(err), zap.String("id", id))
+			return 0, err
+		}
+	}
+	changed := make([]string, 0, len(ret))
+	for id := range ret {
+		changed = append(changed, id)
+	}
+	sort.Strings(changed)
+	if err := us.recordUserChanges(tx, model.UserChangeUpdate, changed...); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] set users token enable tx commit", zap.Error(err), zap.Strings("ids", ids))
+		return 0, err
+	}
+	return uint32(len(ret)), nil
+}
+
+// SetUsersComment 批量设置用户的备注，只更新备注发生变化的有效用户，返回发生变化的用户数量
+func (us *userStore) SetUsersComment(ids []string, comment, modifiedBy string) (uint32, error) {
+	if len(ids) == 0 {
+		return 0, store.NewStatusError(store.EmptyParamsErr, "set users comment missing user ids")
+	}
+	if err := store.CheckUserComment(comment); err != nil {
+		return 0, err
+	}
+
+	proxy, err := us.handler.StartTx()
+	if err != nil {
+		return 0, err
+	}
+	tx := proxy.GetDelegateTx().(*bolt.Tx)
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	idSet := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		idSet[id] = struct{}{}
+	}
+	fields := []string{UserFieldID, UserFieldValid, UserFieldComment}
+	ret := make(map[string]interface{})
+	if err := loadValuesByFilter(tx, tblUser, fields, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			if ok && !valid {
+				return false
+			}
+			saveId, _ := m[UserFieldID].(string)
+			if _, ok := idSet[saveId]; !ok {
+				return false
+			}
+			saveComment, _ := m[UserFieldComment].(string)
+			return saveComment != comment
+		}, ret); err != nil {
+		log.Error("[Store][User] load users to set comment", zap.Error(err), zap.Strings("ids", ids))
+		return 0, err
+	}
+
+	properties := map[string]interface{}{
+		UserFieldComment:    comment,
+		UserFieldModifyTime: time.Now(),
+		UserFieldModifiedBy: modifiedBy,
+	}
+	for id := range ret {
+		if err := updateValue(tx, tblUser, id, properties); err != nil {
+			log.Error("[Store][User] set user comment", zap.Error(err), zap.String("id", id))
+			return 0, err
+		}
+	}
+	changed := make([]string, 0, len(ret))
+	for id := range ret {
+		changed = append(changed, id)
+	}
+	sort.Strings(changed)
+	if err := us.recordUserChanges(tx, model.UserChangeUpdate, changed...); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] set users comment tx commit", zap.Error(err), zap.Strings("ids", ids))
+		return 0, err
+	}
+	return uint32(len(ret)), nil
+}
+
+// FindDuplicateTokens 查询共用同一个 token 的有效用户，只读的诊断接口
+func (us *userStore) FindDuplicateTokens() ([][]string, error) {
+	userTokens, err := us.loadUserTokens()
+	if err != nil {
+		return nil, err
+	}
+	return store.DuplicateTokenGroups(userTokens), nil
+}
+
+// FindDuplicateUserNames 查询同一个 owner 下名称相同的有效用户，只读的诊断接口
+func (us *userStore) FindDuplicateUserNames() ([][]string, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldValid}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			return !ok || valid
+		})
+	if err != nil {
+		log.Error("[Store][User] find duplicate user names", zap.Error(err))
+		return nil, err
+	}
+	userNames := make(map[string]store.UserNameKey, len(ret))
+	for _, v := range ret {
+		user := v.(*userForStore)
+		userNames[user.ID] = store.UserNameKey{Name: user.Name, Owner: user.Owner}
+	}
+	return store.DuplicateUserNameGroups(userNames), nil
+}
+
+// FindWeakTokens 查询 token 的熵低于 minEntropyBits 的有效用户，只读的诊断接口
+func (us *userStore) FindWeakTokens(minEntropyBits float64) ([]string, error) {
+	if err := store.CheckMinEntropyBits(minEntropyBits); err != nil {
+		return nil, err
+	}
+	userTokens, err := us.loadUserTokens()
+	if err != nil {
+		return nil, err
+	}
+	return store.WeakTokenUsers(userTokens, minEntropyBits), nil
+}
+
+// AuditDefaultStrategies 查询缺少默认策略的有效用户，只读的诊断接口
+// 默认策略不存在、已经被删除、名称与用户名不一致或者没有关联到用户时均视为缺少
+func (us *userStore) AuditDefaultStrategies() ([]string, error) {
+	users, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldValid}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			return !ok || valid
+		})
+	if err != nil {
+		log.Error("[Store][User] audit default strategies load users", zap.Error(err))
+		return nil, err
+	}
+	strategies, err := us.handler.LoadValuesByFilter(tblStrategy, []string{StrategyFieldValid, StrategyFieldDefault},
+		&strategyForStore{}, func(m map[string]interface{}) bool {
+			valid, _ := m[StrategyFieldValid].(bool)
+			isDefault, _ := m[StrategyFieldDefault].(bool)
+			return valid && isDefault
+		})
+	if err != nil {
+		log.Error("[Store][User] audit default strategies load strategies", zap.Error(err))
+		return nil, err
+	}
+
+	userNames := make(map[string]string, len(users))
+	for _, v := range users {
+		user := v.(*userForStore)
+		userNames[user.ID] = user.Name
+	}
+	linked := make(map[string][]string)
+	for _, v := range strategies {
+		strategy := v.(*strategyForStore)
+		for userId := range strategy.Users {
+			linked[userId] = append(linked[userId], strategy.Name)
+		}
+	}
+	return store.MissingDefaultStrategyUsers(userNames, linked), nil
+}
+
+// loadUserTokens 读取全部有效用户的 token，返回用户 ID 到 token 的映射
+func (us *userStore) loadUserTokens() (map[string]string, error) {
+	ret, err := us.handler.LoadValuesByFilter(tblUser, []string{UserFieldValid}, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			return !ok || valid
+		})
+	if err != nil {
+		log.Error("[Store][User] load user tokens", zap.Error(err))
+		return nil, err
+	}
+	userTokens := make(map[string]string, len(ret))
+	for _, v := range ret {
+		user := v.(*userForStore)
+		userTokens[user.ID] = user.Token
+	}
+	return userTokens, nil
+}
+
+// checkUserTokenConflict 用户的 token 需要全局唯一，否则无法根据 token 确定唯一的用户
+func checkUserTokenConflict(tx *bolt.Tx, userId, token string) error {
+	fields := []string{UserFieldID, UserFieldToken, UserFieldValid}
+	conflicts := make(map[string]interface{})
+	if err := loadValuesByFilter(tx, tblUser, fields, &userForStore{},
+		func(m map[string]interface{}) bool {
+			valid, ok := m[UserFieldValid].(bool)
+			if ok && !valid {
+				return false
+			}
+			saveId, _ := m[UserFieldID].(string)
+			saveToken, _ := m[UserFieldToken].(string)
+			return saveId != userId && saveToken == token
+		}, conflicts); err != nil {
+		log.Error("[Store][User] check user token conflict", zap.Error(err), zap.String("id", userId))
+		return err
+	}
+	if len(conflicts) != 0 {
+		return store.NewStatusError(store.DataConflictErr, fmt.Sprintf(
+			"user(%s) token conflicts with other users", userId))
+	}
+	return nil
+}
+
+// doPage 进行分页
+func doUserPage(ret map[string]interface{}, order *store.UserOrder, offset, limit uint32) []*model.User {
+	users := make([]*model.User, 0, len(ret))
+	beginIndex := offset
+	endIndex := beginIndex + limit
+	totalCount := uint32(len(ret))
+
+	if totalCount == 0 {
+		return users
+	}
+	if beginIndex >= endIndex {
+		return users
+	}
+	if beginIndex >= totalCount {
+		return users
+	}
+	if endIndex > totalCount {
+		endIndex = totalCount
+	}
+	for k := range ret {
+		users = append(users, converToUserModel(ret[k].(*userForStore)))
+	}
+
+	sortUsers(users, order)
+
+	return users[beginIndex:endIndex]
+}
+
+// sortUsers 按照排序参数对用户列表排序，未指定排序参数时按 mtime 倒序
+// boltdb 不支持数据库的排序规则，对于忽略大小写的排序规则统一转换为小写后再比较
+func sortUsers(users []*model.User, order *store.UserOrder) {
+	if order == nil {
+		sort.Slice(users, func(i, j int) bool {
+			return users[i].ModifyTime.After(users[j].ModifyTime)
+		})
+		return
+	}
+
+	compare := func(a, b *model.User) int {
+		if order.Field == "name" {
+			an, bn := a.Name, b.Name
+			if order.CaseInsensitive() {
+				an, bn = strings.ToLower(an), strings.ToLower(bn)
+			}
+			if c := strings.Compare(an, bn); c != 0 {
+				return c
+			}
+		} else if !a.ModifyTime.Equal(b.ModifyTime) {
+			if a.ModifyTime.Before(b.ModifyTime) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.ID, b.ID)
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if order.Desc {
+			return compare(users[i], users[j]) > 0
+		}
+		return compare(users[i], users[j]) < 0
+	})
+}
+
+func converToUserStore(user *model.User) *userForStore {
+	return &userForStore{
+		ID:                 user.ID,
+		Name:               user.Name,
+		Password:           user.Password,
+		Owner:              user.Owner,
+		Source:             user.Source,
+		Type:               int(user.Type),
+		Token:              user.Token,
+		TokenEnable:        user.TokenEnable,
+		Valid:              user.Valid,
+		Comment:            user.Comment,
+		CreateTime:         user.CreateTime,
+		ModifyTime:         user.ModifyTime,
+		LastLoginTime:      user.LastLoginTime,
+		PasswordSetTime:    user.PasswordSetTime,
+		MustChangePassword: user.MustChangePassword,
+		TokenRotatedTime:   user.TokenRotatedTime,
+		DeleteTime:         user.DeleteTime,
+		CreatedBy:          user.CreatedBy,
+		ModifiedBy:         user.ModifiedBy,
+	}
+}
+
+func converToUserModel(user *userForStore) *model.User {
+	return &model.User{
+		ID:                 user.ID,
+		Name:               user.Name,
+		Password:           user.Password,
+		Owner:              user.Owner,
+		Source:             user.Source,
+		Type:               model.UserRoleType(user.Type),
+		Token:              user.Token,
+		TokenEnable:        user.TokenEnable,
+		Valid:              user.Valid,
+		Comment:            user.Comment,
+		CreateTime:         user.CreateTime,
+		ModifyTime:         user.ModifyTime,
+		LastLoginTime:      normalizeLoginTime(user.LastLoginTime),
+		PasswordSetTime:    normalizeLoginTime(user.PasswordSetTime),
+		MustChangePassword: user.MustChangePassword,
+		TokenRotatedTime:   normalizeLoginTime(user.TokenRotatedTime),
+		DeleteTime:         normalizeLoginTime(user.DeleteTime),
+		CreatedBy:          user.CreatedBy,
+		ModifiedBy:         user.ModifiedBy,
+	}
+}
+
+// normalizeLoginTime 未设置的时间字段保存的是 time.Time 零值，经过编解码后不再是零值，这里统一还原
+func normalizeLoginTime(t time.Time) time.Time {
+	if t.Unix() <= 0 {
+		return time.Time{}
 	}
+	return t
 }
 
 func initUser(user *model.User) {
@@ -582,6 +2217,8 @@ func initUser(user *model.User) {
 		user.Valid = true
 		user.CreateTime = tn
 		user.ModifyTime = tn
+		user.TokenRotatedTime = tn
+		user.ModifiedBy = user.CreatedBy
 	}
 }
 
@@ -600,4 +2237,17 @@ type userForStore struct {
 	Comment     string
 	CreateTime  time.Time
 	ModifyTime  time.Time
+	// LastLoginTime 最近一次登录时间
+	LastLoginTime time.Time
+	// PasswordSetTime 最近一次修改密码的时间
+	PasswordSetTime    time.Time
+	MustChangePassword bool
+	// TokenRotatedTime 最近一次更换 token 的时间
+	TokenRotatedTime time.Time
+	// DeleteTime 用户被删除的时间
+	DeleteTime time.Time
+	// CreatedBy 创建用户的操作者
+	CreatedBy string
+	// ModifiedBy 最后一次修改用户的操作者
+	ModifiedBy string
 }
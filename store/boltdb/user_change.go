@@ -0,0 +1,130 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package boltdb
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/store"
+)
+
+const (
+	// tblUserChange 用户变更记录，key 为补零后的序号，保证按照 key 遍历的顺序与序号一致
+	tblUserChange string = "user_change"
+)
+
+type userChangeForStore struct {
+	Seq        uint64
+	Op         string
+	UserID     string
+	Payload    string
+	CreateTime time.Time
+}
+
+// userChangeKey 序号补零到 uint64 的最大位数
+func userChangeKey(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// GetUserChanges 按照序号顺序查询 sinceSeq 之后的用户变更记录
+// 开启了 userChangeCompact 时，同一个用户在本次读取范围内的多条变更只返回最后一条
+func (us *userStore) GetUserChanges(sinceSeq uint64, limit uint32) ([]*model.UserChange, error) {
+	changes := make([]*model.UserChange, 0)
+	err := us.handler.Execute(false, func(tx *bolt.Tx) error {
+		typBucket := tx.Bucket([]byte(tblUserChange))
+		if typBucket == nil {
+			return nil
+		}
+		cursor := typBucket.Cursor()
+		k, _ := cursor.Seek([]byte(userChangeKey(sinceSeq + 1)))
+		for ; k != nil && uint32(len(changes)) < limit; k, _ = cursor.Next() {
+			bucket := typBucket.Bucket(k)
+			if bucket == nil {
+				continue
+			}
+			val, err := deserializeObject(bucket, &userChangeForStore{})
+			if err != nil {
+				return err
+			}
+			change := val.(*userChangeForStore)
+			changes = append(changes, &model.UserChange{
+				Seq:        change.Seq,
+				Op:         model.UserChangeOp(change.Op),
+				UserID:     change.UserID,
+				Payload:    change.Payload,
+				CreateTime: change.CreateTime,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("[Store][User] get user changes", zap.Uint64("since", sinceSeq), zap.Error(err))
+		return nil, err
+	}
+	if us.changeCompact {
+		return store.CompactUserChanges(changes), nil
+	}
+	return changes, nil
+}
+
+// recordUserChanges 开启了 userChangeLog 时，在同一个事务中记录用户变更后的快照
+// boltdb 的写事务是串行的，序号的顺序即为事务提交的顺序
+func (us *userStore) recordUserChanges(tx *bolt.Tx, op model.UserChangeOp, ids ...string) error {
+	if !us.changeLog || len(ids) == 0 {
+		return nil
+	}
+	saved := make(map[string]interface{}, len(ids))
+	if err := loadValues(tx, tblUser, ids, &userForStore{}, saved); err != nil {
+		return err
+	}
+	typBucket, err := tx.CreateBucketIfNotExists([]byte(tblUserChange))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		user := &model.User{ID: id}
+		if val, ok := saved[id].(*userForStore); ok {
+			user = converToUserModel(val)
+		}
+		payload, err := store.EncodeUserChangePayload(user)
+		if err != nil {
+			return err
+		}
+		seq, err := typBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		change := &userChangeForStore{
+			Seq:        seq,
+			Op:         string(op),
+			UserID:     id,
+			Payload:    payload,
+			CreateTime: time.Now(),
+		}
+		if err := saveValue(tx, tblUserChange, userChangeKey(seq), change); err != nil {
+			log.Error("[Store][User] record user change", zap.String("id", id), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
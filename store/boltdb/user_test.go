@@ -18,15 +18,24 @@
 package boltdb
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/common/utils"
+	"github.com/polarismesh/polaris/store"
 )
 
 func createTestUsers(num int) []*model.User {
@@ -40,7 +49,7 @@ func createTestUsers(num int) []*model.User {
 			Owner:       "polaris",
 			Source:      "Polaris",
 			Type:        model.SubAccountUserRole,
-			Token:       "polaris",
+			Token:       fmt.Sprintf("polaris_token_%d", i),
 			TokenEnable: true,
 			Valid:       true,
 			Comment:     "",
@@ -71,8 +80,10 @@ func Test_userStore_AddUser(t *testing.T) {
 
 		users[0].CreateTime = tn
 		users[0].ModifyTime = tn
+		users[0].TokenRotatedTime = tn
 		ret.CreateTime = tn
 		ret.ModifyTime = tn
+		ret.TokenRotatedTime = tn
 
 		if !assert.Equal(t, users[0], ret) {
 			t.FailNow()
@@ -80,6 +91,109 @@ func Test_userStore_AddUser(t *testing.T) {
 	})
 }
 
+func Test_userStore_AddUserAndReturn(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(2)
+		users[0].CreateTime = time.Time{}
+		users[0].ModifyTime = time.Time{}
+
+		ret, err := us.AddUserAndReturn(users[0])
+		assert.NoError(t, err)
+		assert.Equal(t, users[0].ID, ret.ID)
+		assert.Equal(t, users[0].Token, ret.Token)
+		assert.True(t, ret.Valid)
+		assert.False(t, ret.CreateTime.IsZero())
+		assert.False(t, ret.ModifyTime.IsZero())
+
+		saved, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, saved, ret)
+
+		// token 冲突时不返回用户
+		users[1].Token = users[0].Token
+		ret, err = us.AddUserAndReturn(users[1])
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.Nil(t, ret)
+	})
+}
+
+func Test_userStore_BatchAddUser(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(200)
+		assert.NoError(t, us.BatchAddUser(users))
+
+		strategyIds := make(map[string]struct{}, len(users))
+		for _, user := range users {
+			ret, err := us.GetUser(user.ID)
+			assert.NoError(t, err)
+			assert.NotNil(t, ret)
+
+			strategy, err := ss.GetDefaultStrategyDetailByPrincipal(user.ID, model.PrincipalUser)
+			assert.NoError(t, err)
+			assert.NotNil(t, strategy)
+			assert.NotEmpty(t, strategy.Revision)
+			strategyIds[strategy.ID] = struct{}{}
+		}
+		assert.Len(t, strategyIds, len(users))
+
+		// 批量中任意一个用户写入失败时整体回滚
+		others := createTestUsers(2)
+		others[0].ID, others[0].Name, others[0].Token = "batch_user", "batch_user", "batch_token"
+		// 与已经存在的 user_1 使用相同的 token
+		others[1].ID, others[1].Name = "batch_user_1", "batch_user_1"
+		err := us.BatchAddUser(others)
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		ret, err := us.GetUser("batch_user")
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+	})
+}
+
+func Test_userStore_BatchUsersBestEffort(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(4)
+		// 与 user_0 使用相同的 token
+		users[2].Token = users[0].Token
+		// 缺少 token
+		users[3].Token = ""
+
+		results := us.BatchAddUsersBestEffort(users)
+		assert.Len(t, results, len(users))
+		for i := range users {
+			assert.Equal(t, users[i].ID, results[i].ID)
+		}
+		assert.NoError(t, results[0].Err)
+		assert.NoError(t, results[1].Err)
+		assert.Equal(t, store.DataConflictErr, store.Code(results[2].Err))
+		assert.Equal(t, store.EmptyParamsErr, store.Code(results[3].Err))
+
+		for i, exist := range []bool{true, true, false, false} {
+			ret, err := us.GetUser(users[i].ID)
+			assert.NoError(t, err)
+			assert.Equal(t, exist, ret != nil)
+		}
+
+		results = us.BatchDeleteUsersBestEffort([]*model.User{users[0], {Name: "missing_id"}, users[1]})
+		assert.Len(t, results, 3)
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, store.EmptyParamsErr, store.Code(results[1].Err))
+		assert.NoError(t, results[2].Err)
+
+		for i := 0; i < 2; i++ {
+			ret, err := us.GetUser(users[i].ID)
+			assert.NoError(t, err)
+			assert.Nil(t, ret)
+		}
+	})
+}
+
 func Test_userStore_UpdateUser(t *testing.T) {
 	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
 		us := &userStore{handler: handler}
@@ -105,8 +219,10 @@ func Test_userStore_UpdateUser(t *testing.T) {
 
 		users[0].CreateTime = tn
 		users[0].ModifyTime = tn
+		users[0].TokenRotatedTime = tn
 		ret.CreateTime = tn
 		ret.ModifyTime = tn
+		ret.TokenRotatedTime = tn
 
 		if !assert.Equal(t, users[0], ret) {
 			t.FailNow()
@@ -114,6 +230,28 @@ func Test_userStore_UpdateUser(t *testing.T) {
 	})
 }
 
+func Test_userStore_UpdateUserNotFound(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(2)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		assert.NoError(t, us.DeleteUser(users[1]))
+
+		users[0].Comment = "update active user"
+		assert.NoError(t, us.UpdateUser(users[0]))
+
+		users[1].Comment = "update deleted user"
+		assert.Equal(t, store.NotFoundUser, store.Code(us.UpdateUser(users[1])))
+
+		missing := createTestUsers(1)[0]
+		missing.ID = "not_exist"
+		assert.Equal(t, store.NotFoundUser, store.Code(us.UpdateUser(missing)))
+	})
+}
+
 func Test_userStore_DeleteUser(t *testing.T) {
 	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
 		us := &userStore{handler: handler}
@@ -148,6 +286,63 @@ func Test_userStore_DeleteUser(t *testing.T) {
 	})
 }
 
+func Test_userStore_DeleteUserCascadeSubAccounts(t *testing.T) {
+	newMainAccount := func() *model.User {
+		return &model.User{ID: "polaris", Name: "polaris", Password: "polaris", Owner: "polaris", Source: "Polaris",
+			Type: model.OwnerUserRole, Token: "polaris_token", TokenEnable: true, Valid: true}
+	}
+	prepare := func(t *testing.T, us *userStore) []*model.User {
+		assert.NoError(t, us.AddUser(newMainAccount()))
+		users := createTestUsers(3)
+		// 属于其他主账户的子账户不受影响
+		users[2].Owner = "other_owner"
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		return users
+	}
+
+	t.Run("开启级联时清理子账户及其默认策略", func(t *testing.T) {
+		CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+			us := &userStore{handler: handler, cascadeSubAccounts: true}
+			ss := &strategyStore{handler: handler}
+			users := prepare(t, us)
+
+			assert.NoError(t, us.DeleteUser(newMainAccount()))
+
+			for i, deleted := range []bool{true, true, false} {
+				ret, err := us.GetUser(users[i].ID)
+				assert.NoError(t, err)
+				assert.Equal(t, deleted, ret == nil, users[i].ID)
+
+				// 默认策略不存在时返回错误
+				_, err = ss.GetDefaultStrategyDetailByPrincipal(users[i].ID, model.PrincipalUser)
+				assert.Equal(t, deleted, err != nil, users[i].ID)
+			}
+		})
+	})
+
+	t.Run("关闭级联时保留子账户", func(t *testing.T) {
+		CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+			us := &userStore{handler: handler}
+			ss := &strategyStore{handler: handler}
+			users := prepare(t, us)
+
+			assert.NoError(t, us.DeleteUser(newMainAccount()))
+
+			for i := range users {
+				ret, err := us.GetUser(users[i].ID)
+				assert.NoError(t, err)
+				assert.NotNil(t, ret)
+
+				strategy, err := ss.GetDefaultStrategyDetailByPrincipal(users[i].ID, model.PrincipalUser)
+				assert.NoError(t, err)
+				assert.NotNil(t, strategy)
+			}
+		})
+	})
+}
+
 func Test_userStore_GetUserByName(t *testing.T) {
 	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
 		us := &userStore{handler: handler}
@@ -167,8 +362,10 @@ func Test_userStore_GetUserByName(t *testing.T) {
 
 		users[0].CreateTime = tn
 		users[0].ModifyTime = tn
+		users[0].TokenRotatedTime = tn
 		ret.CreateTime = tn
 		ret.ModifyTime = tn
+		ret.TokenRotatedTime = tn
 
 		if !assert.Equal(t, users[0], ret) {
 			t.FailNow()
@@ -205,8 +402,10 @@ func Test_userStore_GetUserByIds(t *testing.T) {
 
 			users[i].CreateTime = tn
 			users[i].ModifyTime = tn
+			users[i].TokenRotatedTime = tn
 			users[j].CreateTime = tn
 			users[j].ModifyTime = tn
+			users[j].TokenRotatedTime = tn
 
 			return strings.Compare(users[i].ID, users[j].ID) < 0
 		})
@@ -215,8 +414,10 @@ func Test_userStore_GetUserByIds(t *testing.T) {
 
 			ret[i].CreateTime = tn
 			ret[i].ModifyTime = tn
+			ret[i].TokenRotatedTime = tn
 			ret[j].CreateTime = tn
 			ret[j].ModifyTime = tn
+			ret[j].TokenRotatedTime = tn
 
 			return strings.Compare(ret[i].ID, ret[j].ID) < 0
 		})
@@ -303,6 +504,8 @@ func Test_userStore_GetUsers(t *testing.T) {
 		admins[0].ID = "admin"
 		admins[0].Name = "admin"
 		admins[0].Type = model.AdminUserRole
+		admins[0].Owner = admins[0].ID
+		admins[0].Token = "admin_token"
 
 		if err := us.AddUser(admins[0]); err != nil {
 			t.Fatal(err)
@@ -334,6 +537,41 @@ func Test_userStore_GetUsers(t *testing.T) {
 	})
 }
 
+func Test_userStore_GetUsersByNames(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(5)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 多个名称以逗号分隔，精确匹配
+		total, ret, err := us.GetUsers(map[string]string{
+			"name": users[0].Name + "," + users[2].Name + ",not_exist",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		names := []string{ret[0].Name, ret[1].Name}
+		assert.ElementsMatch(t, []string{users[0].Name, users[2].Name}, names)
+
+		// 带通配符时仍然为模糊匹配，逗号作为普通字符处理
+		total, _, err = us.GetUsers(map[string]string{
+			"name": users[0].Name + "," + users[2].Name + "*",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+
+		total, _, err = us.GetUsers(map[string]string{
+			"name": "user_*",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(len(users)), total)
+	})
+}
+
 func Test_userStore_GetUsersByGroup(t *testing.T) {
 	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
 		us := &userStore{handler: handler}
@@ -370,3 +608,2086 @@ func Test_userStore_GetUsersByGroup(t *testing.T) {
 		}
 	})
 }
+
+func Test_userStore_GetUsersExcludeIds(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+
+		users := createTestUsers(6)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		group := createTestUserGroup(1)[0]
+		group.UserIds = map[string]struct{}{users[1].ID: {}, users[2].ID: {}, users[3].ID: {}}
+		assert.NoError(t, gs.AddGroup(group))
+
+		excluded := map[string]struct{}{users[1].ID: {}, users[3].ID: {}}
+		excludeIds := users[1].ID + "," + users[3].ID
+		total, ret, err := us.GetUsers(map[string]string{"exclude_ids": excludeIds}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(len(users)-len(excluded)), total)
+		for _, user := range ret {
+			_, ok := excluded[user.ID]
+			assert.False(t, ok, user.ID)
+		}
+
+		// 与其他查询条件同时生效
+		total, ret, err = us.GetUsers(map[string]string{"exclude_ids": excludeIds, "group_id": group.ID}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, users[2].ID, ret[0].ID)
+
+		// 排除的用户为空时不过滤
+		total, _, err = us.GetUsers(map[string]string{"exclude_ids": ""}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(len(users)), total)
+	})
+}
+
+func Test_userStore_GetUsersPageBounds(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(3)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// offset 等于上限以及 limit 超过上限时正常查询
+		total, ret, err := us.GetUsers(map[string]string{}, store.DefaultUserQueryMaxOffset, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(len(users)), total)
+		assert.Empty(t, ret)
+		total, ret, err = us.GetUsers(map[string]string{}, 0, math.MaxUint32)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(len(users)), total)
+		assert.Equal(t, len(users), len(ret))
+
+		// offset 超过上限
+		_, _, err = us.GetUsers(map[string]string{}, store.DefaultUserQueryMaxOffset+1, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		_, _, err = us.GetUsersByStrategyID("s1", math.MaxUint32, math.MaxUint32)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+	})
+}
+
+func Test_userStore_GetUsersControlCharacters(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(1)
+		assert.NoError(t, us.AddUser(users[0]))
+
+		_, _, err := us.GetUsers(map[string]string{"name": users[0].Name + "\x00"}, 0, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		_, _, err = us.QueryUsers(&store.UserQuery{Owner: "polaris\r\n"}, 0, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+
+		total, _, err := us.GetUsers(map[string]string{"name": users[0].Name}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+	})
+}
+
+func Test_userStore_GetUsersByGroupOwner(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+
+		users := createTestUsers(3)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		groups := createTestUserGroup(1)
+		groups[0].UserIds = buildUserIds(users)
+		assert.NoError(t, gs.AddGroup(groups[0]))
+
+		// 用户组的主账户为 polaris
+		total, ret, err := us.GetUsers(map[string]string{
+			"group_id":    groups[0].ID,
+			"group_owner": "polaris",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), total)
+		assert.Equal(t, 3, len(ret))
+
+		// 其他主账户无法查看该用户组的成员
+		total, ret, err = us.GetUsers(map[string]string{
+			"group_id":    groups[0].ID,
+			"group_owner": "other-owner",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+		assert.Empty(t, ret)
+	})
+}
+
+func Test_userStore_GetUsersByGroupName(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+
+		users := createTestUsers(4)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		// 不同主账户下存在同名的用户组 dev，user_1 同时属于这两个用户组
+		groups := createTestUserGroup(3)
+		groups[0].Name, groups[0].UserIds = "dev", buildUserIds(users[0:2])
+		groups[1].Name, groups[1].Owner, groups[1].UserIds = "dev", "other-owner", buildUserIds(users[1:3])
+		groups[2].Name, groups[2].UserIds = "ops", buildUserIds(users[3:4])
+		for i := range groups {
+			assert.NoError(t, gs.AddGroup(groups[i]))
+		}
+
+		ids := func(ret []*model.User) []string {
+			out := make([]string, 0, len(ret))
+			for i := range ret {
+				out = append(out, ret[i].ID)
+			}
+			return out
+		}
+
+		// 未指定用户组的主账户时返回所有同名用户组的成员，同时属于多个用户组的用户只返回一次
+		total, ret, err := us.GetUsers(map[string]string{"group_name": "dev"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), total)
+		assert.ElementsMatch(t, []string{"user_0", "user_1", "user_2"}, ids(ret))
+
+		total, ret, err = us.GetUsers(map[string]string{"group_name": "dev", "group_owner": "other-owner"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.ElementsMatch(t, []string{"user_1", "user_2"}, ids(ret))
+
+		total, ret, err = us.GetUsers(map[string]string{"group_name": "dev", "group_id": groups[0].ID}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.ElementsMatch(t, []string{"user_0", "user_1"}, ids(ret))
+
+		total, _, err = us.GetUsers(map[string]string{"group_name": "dev", "group_id": groups[2].ID}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+
+		total, _, err = us.GetUsers(map[string]string{"group_name": "not-exist"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+
+		// 已删除的用户组不参与匹配
+		assert.NoError(t, gs.DeleteGroup(groups[1]))
+		total, ret, err = us.GetUsers(map[string]string{"group_name": "dev"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.ElementsMatch(t, []string{"user_0", "user_1"}, ids(ret))
+	})
+}
+
+func Test_userStore_GetUsersByGroupAndName(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+
+		users := createTestUsers(10)
+		for i := 0; i < 4; i++ {
+			users[i].Name = fmt.Sprintf("foo_%d", i)
+		}
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		// 用户组中包含 foo_0 ~ foo_2 以及 user_4 ~ user_5，foo_3 不在用户组中
+		groups := createTestUserGroup(1)
+		groups[0].UserIds = buildUserIds(append(users[:3:3], users[4:6]...))
+		assert.NoError(t, gs.AddGroup(groups[0]))
+
+		for _, key := range []string{"name", "user_name"} {
+			total, ret, err := us.GetUsers(map[string]string{"group_id": groups[0].ID, key: "foo*"}, 0, 2)
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(3), total)
+			assert.Len(t, ret, 2)
+			for i := range ret {
+				assert.True(t, strings.HasPrefix(ret[i].Name, "foo_"))
+			}
+		}
+
+		total, ret, err := us.GetUsers(map[string]string{"group_id": groups[0].ID, "name": "foo_1,user_4,foo_3"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.Len(t, ret, 2)
+	})
+}
+
+func Test_userStore_QueryUsers(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+
+		users := createTestUsers(10)
+		for i := range users {
+			users[i].TokenEnable = i%2 == 0
+			if i >= 5 {
+				users[i].Source = "Other"
+			}
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		users[3].Comment = "On-call for the Payments team"
+		users[7].Comment = "payments reviewer"
+		for _, i := range []int{3, 7} {
+			assert.NoError(t, us.UpdateUser(users[i]))
+		}
+		groups := createTestUserGroup(1)
+		groups[0].UserIds = buildUserIds(users[:4])
+		assert.NoError(t, gs.AddGroup(groups[0]))
+
+		enable, hasGroup := true, false
+		cases := []struct {
+			name    string
+			filters map[string]string
+			query   *store.UserQuery
+			want    int
+		}{
+			{
+				name:    "名称模糊查询",
+				filters: map[string]string{"name": "user_*", "owner": "polaris"},
+				query:   &store.UserQuery{Name: "user_*", Owner: "polaris"},
+				want:    10,
+			},
+			{
+				name:    "多个条件组合",
+				filters: map[string]string{"source": "Other", "token_enable": "true", "hide_admin": "true"},
+				query:   &store.UserQuery{Source: "Other", TokenEnable: &enable, HideAdmin: true},
+				want:    2,
+			},
+			{
+				name:    "没有加入用户组的用户",
+				filters: map[string]string{"has_group": "false", "name": "user_1,user_5,user_7"},
+				query:   &store.UserQuery{HasGroup: &hasGroup, Name: "user_1,user_5,user_7"},
+				want:    2,
+			},
+			{
+				name:    "备注中包含关键字",
+				filters: map[string]string{"q": "PAYMENTS"},
+				query:   &store.UserQuery{Keyword: "PAYMENTS"},
+				want:    2,
+			},
+			{
+				name:    "名称中包含关键字",
+				filters: map[string]string{"q": "er_9"},
+				query:   &store.UserQuery{Keyword: "er_9"},
+				want:    1,
+			},
+			{
+				name:    "用户组下按照关键字搜索",
+				filters: map[string]string{"group_id": groups[0].ID, "q": "payments"},
+				query:   &store.UserQuery{GroupID: groups[0].ID, Keyword: "payments"},
+				want:    1,
+			},
+			{
+				name:    "用户组下的用户",
+				filters: map[string]string{"group_id": groups[0].ID, "user_name": "user_*", "token_enable": "true"},
+				query:   &store.UserQuery{GroupID: groups[0].ID, Name: "user_*", TokenEnable: &enable},
+				want:    2,
+			},
+			{
+				name:    "创建时间",
+				filters: map[string]string{"created_after": strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+				query:   &store.UserQuery{CreatedAfter: time.Unix(time.Now().Add(time.Hour).Unix(), 0)},
+				want:    0,
+			},
+		}
+		for _, c := range cases {
+			total, byMap, err := us.GetUsers(c.filters, 0, 100)
+			assert.NoError(t, err, c.name)
+			assert.Equal(t, uint32(c.want), total, c.name)
+
+			typedTotal, byQuery, err := us.QueryUsers(c.query, 0, 100)
+			assert.NoError(t, err, c.name)
+			assert.Equal(t, total, typedTotal, c.name)
+			assert.ElementsMatch(t, byMap, byQuery, c.name)
+		}
+
+		_, _, err := us.GetUsers(map[string]string{"password": "x"}, 0, 100)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		_, _, err = us.GetUsers(map[string]string{"token_enable": "yes"}, 0, 100)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_GetUsersByHasGroup(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+
+		// 用户组中只包含 user_0
+		groups := createTestUserGroup(1)
+		assert.NoError(t, gs.AddGroup(groups[0]))
+
+		users := createTestUsers(3)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		total, ret, err := us.GetUsers(map[string]string{"has_group": "true"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, "user_0", ret[0].ID)
+
+		total, ret, err = us.GetUsers(map[string]string{"has_group": "false"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		for i := range ret {
+			assert.NotEqual(t, "user_0", ret[i].ID)
+		}
+
+		total, _, err = us.GetUsers(map[string]string{"has_group": "false", "group_id": groups[0].ID}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+
+		_, _, err = us.GetUsers(map[string]string{"has_group": "yes"}, 0, 100)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_GetMainAccountsWithSubCounts(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		// 主账户 main_i 下有 i 个子账户，另外每个主账户下有一个已经删除的子账户不参与计数
+		now := time.Now()
+		admin := createTestUsers(1)[0]
+		admin.ID, admin.Name, admin.Owner, admin.Type = "admin", "admin", "admin", model.AdminUserRole
+		admin.Token = "polaris_token_admin"
+		assert.NoError(t, us.AddUser(admin))
+		for i := 0; i < 3; i++ {
+			account := createTestUsers(1)[0]
+			account.ID = fmt.Sprintf("main_%d", i)
+			account.Name, account.Owner, account.Type = account.ID, account.ID, model.OwnerUserRole
+			account.Token = "polaris_token_" + account.ID
+			account.CreateTime = now.Add(time.Duration(i) * time.Second)
+			assert.NoError(t, us.AddUser(account))
+			for j := 0; j <= i; j++ {
+				sub := createTestUsers(1)[0]
+				sub.ID = fmt.Sprintf("%s_sub_%d", account.ID, j)
+				sub.Name, sub.Owner = sub.ID, account.ID
+				sub.Token = "polaris_token_" + sub.ID
+				assert.NoError(t, us.AddUser(sub))
+				if j == i {
+					assert.NoError(t, us.DeleteUser(sub))
+				}
+			}
+		}
+
+		total, users, err := us.GetMainAccountsWithSubCounts(0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), total)
+		assert.Len(t, users, 3)
+		for i := range users {
+			assert.Equal(t, fmt.Sprintf("main_%d", i), users[i].ID)
+			assert.Equal(t, uint32(i), users[i].SubCount)
+		}
+
+		total, users, err = us.GetMainAccountsWithSubCounts(2, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), total)
+		assert.Len(t, users, 1)
+		assert.Equal(t, "main_2", users[0].ID)
+		assert.Equal(t, uint32(2), users[0].SubCount)
+
+		_, users, err = us.GetMainAccountsWithSubCounts(3, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+	})
+}
+
+func Test_userStore_GetRecentlyModifiedUsers(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(5)
+		users[0].Type = model.AdminUserRole
+		users[0].Owner = users[0].ID
+		users[0].Owner = users[0].ID
+		base := time.Now().Add(-time.Hour)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+			assert.NoError(t, handler.UpdateValue(tblUser, users[i].ID, map[string]interface{}{
+				UserFieldModifyTime: base.Add(time.Duration(i) * time.Minute),
+			}))
+		}
+
+		ret, err := us.GetRecentlyModifiedUsers(3)
+		assert.NoError(t, err)
+		assert.Len(t, ret, 3)
+		for i, id := range []string{"user_4", "user_3", "user_2"} {
+			assert.Equal(t, id, ret[i].ID)
+		}
+
+		// 超级账户不返回，limit 超过上限时按上限处理
+		ret, err = us.GetRecentlyModifiedUsers(store.MaxRecentlyModifiedUsers * 10)
+		assert.NoError(t, err)
+		assert.Len(t, ret, 4)
+		assert.Equal(t, "user_1", ret[3].ID)
+
+		ret, err = us.GetRecentlyModifiedUsers(0)
+		assert.NoError(t, err)
+		assert.Empty(t, ret)
+	})
+}
+
+func Test_userStore_GetUsersForCacheExcludeSecrets(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		users := createTestUsers(2)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		// 默认加载密码以及 token
+		ret, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Len(t, ret, 2)
+		for i := range ret {
+			assert.NotEmpty(t, ret[i].Password)
+			assert.NotEmpty(t, ret[i].Token)
+		}
+
+		us.cacheExcludePassword = true
+		us.cacheExcludeToken = true
+		ret, err = us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Len(t, ret, 2)
+		for i := range ret {
+			assert.Empty(t, ret[i].Password)
+			assert.Empty(t, ret[i].Token)
+		}
+
+		// 其他接口不受影响
+		user, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, users[0].Token, user.Token)
+	})
+}
+
+func Test_userStore_GetAddableUsersForGroup(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+		users := createTestUsers(4)
+		users[3].Owner = "other"
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		group := createTestUserGroup(1)[0]
+		group.UserIds = buildUserIds(users[:1])
+		assert.NoError(t, gs.AddGroup(group))
+
+		// 排除用户组当前的成员以及其他主账户下的用户
+		total, ret, err := us.GetAddableUsersForGroup(group.ID, "polaris", 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.ElementsMatch(t, []string{users[1].ID, users[2].ID}, []string{ret[0].ID, ret[1].ID})
+
+		total, ret, err = us.GetAddableUsersForGroup(group.ID, "polaris", 1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.Len(t, ret, 1)
+
+		_, _, err = us.GetAddableUsersForGroup(group.ID, "other", 0, 100)
+		assert.Equal(t, store.NotFoundUserGroup, store.Code(err))
+		_, _, err = us.GetAddableUsersForGroup("", "polaris", 0, 100)
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+	})
+}
+
+func Test_userStore_GetUsersForCacheWithGroups(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+		users := createTestUsers(3)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		groups := createTestUserGroup(3)
+		groups[0].UserIds = buildUserIds(users[:2])
+		groups[1].UserIds = buildUserIds(users[:1])
+		groups[2].UserIds = buildUserIds(users[2:])
+		for i := range groups {
+			assert.NoError(t, gs.AddGroup(groups[i]))
+		}
+		assert.NoError(t, gs.DeleteGroup(groups[2]))
+
+		// 默认不返回用户组
+		ret, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		for i := range ret {
+			assert.Nil(t, ret[i].GroupIDs)
+		}
+
+		us.cacheWithGroups = true
+		ret, err = us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Len(t, ret, 3)
+		user2Groups := map[string][]string{}
+		for i := range ret {
+			user2Groups[ret[i].ID] = ret[i].GroupIDs
+		}
+		assert.Equal(t, map[string][]string{
+			users[0].ID: {groups[0].ID, groups[1].ID},
+			users[1].ID: {groups[0].ID},
+			users[2].ID: nil,
+		}, user2Groups)
+	})
+}
+
+func Test_userStore_GetUsersForCacheProjection(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler, cacheProjection: true}
+		users := createTestUsers(1)
+		users[0].Comment = "comment"
+		users[0].MustChangePassword = true
+		users[0].PasswordSetTime = time.Now()
+		assert.NoError(t, us.AddUser(users[0]))
+
+		ret, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Len(t, ret, 1)
+		user := ret[0]
+		assert.Equal(t, users[0].ID, user.ID)
+		assert.Equal(t, users[0].Name, user.Name)
+		assert.Equal(t, users[0].Owner, user.Owner)
+		assert.Equal(t, users[0].Token, user.Token)
+		assert.Equal(t, users[0].Type, user.Type)
+		assert.True(t, user.TokenEnable)
+		assert.True(t, user.Valid)
+		assert.False(t, user.ModifyTime.IsZero())
+
+		assert.Empty(t, user.Password)
+		assert.Empty(t, user.Comment)
+		assert.Empty(t, user.Source)
+		assert.True(t, user.CreateTime.IsZero())
+		assert.True(t, user.LastLoginTime.IsZero())
+		// 密码过期的校验依赖这两个字段
+		assert.False(t, user.PasswordSetTime.IsZero())
+		assert.True(t, user.MustChangePassword)
+	})
+}
+
+func Test_userStore_UpdateLastLogin(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(3)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ret, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.True(t, ret.LastLoginTime.IsZero())
+		mtime := ret.ModifyTime
+
+		assert.NoError(t, us.UpdateLastLogin(users[0].ID))
+		assert.NoError(t, us.UpdateLastLogin(users[1].ID))
+
+		ret, err = us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.False(t, ret.LastLoginTime.IsZero())
+		// 记录登录时间不能修改 mtime
+		assert.Equal(t, mtime.UnixNano(), ret.ModifyTime.UnixNano())
+
+		// 登录时间都早于该时间点，三个用户都满足条件
+		total, _, err := us.GetUsers(map[string]string{
+			"last_login_before": strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10),
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, int(total))
+
+		// 刚刚登录过的用户被排除，只剩下从未登录过的用户
+		total, ret2, err := us.GetUsers(map[string]string{
+			"last_login_before": strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10),
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, int(total))
+		assert.Equal(t, users[2].ID, ret2[0].ID)
+
+		_, _, err = us.GetUsers(map[string]string{
+			"last_login_before": "abc",
+		}, 0, 100)
+		assert.Error(t, err)
+	})
+}
+
+func Test_boltStore_WithTx(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		s := &boltStore{
+			handler:   handler,
+			userStore: &userStore{handler: handler},
+			grayStore: &grayStore{handler: handler},
+		}
+
+		users := createTestUsers(2)
+
+		// 任意一步失败，用户以及关联的记录都需要回滚
+		mockErr := errors.New("mock error")
+		err := s.WithTx(context.Background(), func(ts store.TxStore) error {
+			if err := ts.AddUser(users[0]); err != nil {
+				return err
+			}
+			if err := s.CreateGrayResourceTx(ts.Tx(), &model.GrayResource{
+				Name:  users[0].ID,
+				Valid: true,
+			}); err != nil {
+				return err
+			}
+			return mockErr
+		})
+		assert.ErrorIs(t, err, mockErr)
+
+		ret, err := s.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+		grays, err := s.GetMoreGrayResouces(true, time.Time{})
+		assert.NoError(t, err)
+		assert.Empty(t, grays)
+
+		err = s.WithTx(context.Background(), func(ts store.TxStore) error {
+			if err := ts.AddUser(users[1]); err != nil {
+				return err
+			}
+			// 事务内可以读到尚未提交的数据
+			saved, err := ts.GetUser(users[1].ID)
+			if err != nil {
+				return err
+			}
+			assert.NotNil(t, saved)
+			return s.CreateGrayResourceTx(ts.Tx(), &model.GrayResource{
+				Name:  users[1].ID,
+				Valid: true,
+			})
+		})
+		assert.NoError(t, err)
+
+		ret, err = s.GetUser(users[1].ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, ret)
+		grays, err = s.GetMoreGrayResouces(true, time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(grays))
+
+		// ctx 已经取消时不执行 fn
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = s.WithTx(ctx, func(ts store.TxStore) error {
+			t.Fatal("fn should not be called")
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func Test_userStore_GetUsersOrderByName(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		names := []string{"bob", "Alice", "carol", "alice2", "Bob2"}
+		users := createTestUsers(len(names))
+		for i := range users {
+			users[i].Name = names[i]
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		collectNames := func(users []*model.User) []string {
+			ret := make([]string, 0, len(users))
+			for i := range users {
+				ret = append(ret, users[i].Name)
+			}
+			return ret
+		}
+
+		_, ret, err := us.GetUsers(map[string]string{
+			"order_field":     "name",
+			"order_collation": "utf8mb4_general_ci",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Alice", "alice2", "bob", "Bob2", "carol"}, collectNames(ret))
+
+		_, ret, err = us.GetUsers(map[string]string{
+			"order_field":     "name",
+			"order_type":      "desc",
+			"order_collation": "utf8mb4_general_ci",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"carol", "Bob2", "bob", "alice2", "Alice"}, collectNames(ret))
+
+		// 二进制排序规则下大写字母排在小写字母前面
+		_, ret, err = us.GetUsers(map[string]string{
+			"order_field": "name",
+		}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Alice", "Bob2", "alice2", "bob", "carol"}, collectNames(ret))
+
+		_, _, err = us.GetUsers(map[string]string{
+			"order_field":     "name",
+			"order_collation": "latin1_swedish_ci; DROP TABLE user",
+		}, 0, 100)
+		assert.Error(t, err)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_UpdateUserPassword(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(1)
+		setTime := time.Now().Add(-time.Hour)
+		users[0].PasswordSetTime = setTime
+		users[0].MustChangePassword = true
+		if err := us.AddUser(users[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		// 密码没有变化时不修改密码相关的字段
+		users[0].Comment = "update comment"
+		assert.NoError(t, us.UpdateUser(users[0]))
+		ret, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.True(t, ret.MustChangePassword)
+		assert.Equal(t, setTime.UnixNano(), ret.PasswordSetTime.UnixNano())
+
+		// 修改密码后重置密码修改时间并清除强制修改密码标记
+		users[0].Password = "new-password"
+		assert.NoError(t, us.UpdateUser(users[0]))
+		ret, err = us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.False(t, ret.MustChangePassword)
+		assert.True(t, ret.PasswordSetTime.After(setTime))
+	})
+}
+
+func Test_userStore_PasswordAge(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler, passwordAge: store.PasswordAgePolicy{MinAge: time.Hour, AdminBypass: true}}
+
+		users := createTestUsers(2)
+		users[0].PasswordSetTime = time.Now().Add(-10 * time.Minute)
+		users[1].PasswordSetTime = time.Now().Add(-2 * time.Hour)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 未达到最短使用时间时拒绝修改密码，已保存的密码不变
+		users[0].Password = "new-password"
+		err := us.UpdateUser(users[0])
+		assert.Equal(t, store.PasswordTooRecent, store.Code(err))
+		ret, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "user_0", ret.Password)
+
+		// 超级管理员发起的修改不受限制
+		users[0].AdminOperated = true
+		assert.NoError(t, us.UpdateUser(users[0]))
+
+		// 超过最短使用时间后允许修改密码
+		users[1].Password = "new-password"
+		assert.NoError(t, us.UpdateUser(users[1]))
+		ret, err = us.GetUser(users[1].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "new-password", ret.Password)
+	})
+}
+
+func Test_userStore_GetUsersByStrategyID(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(4)
+		admin := createTestUsers(1)[0]
+		admin.ID = "admin"
+		admin.Name = "admin"
+		admin.Token = "admin_token"
+		admin.Type = model.AdminUserRole
+		admin.Owner = admin.ID
+		users = append(users, admin)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// user_0、user_1 以及超级账户关联到策略上，user_2、user_3 没有关联
+		principals := make([]model.Principal, 0, 4)
+		for _, id := range []string{users[0].ID, users[1].ID, admin.ID} {
+			principals = append(principals, model.Principal{
+				StrategyID:    "strategy-users",
+				PrincipalID:   id,
+				PrincipalRole: model.PrincipalUser,
+			})
+		}
+		principals = append(principals, model.Principal{
+			StrategyID:    "strategy-users",
+			PrincipalID:   users[2].ID,
+			PrincipalRole: model.PrincipalGroup,
+		})
+		err := ss.AddStrategy(&model.StrategyDetail{
+			ID:         "strategy-users",
+			Name:       "strategy-users",
+			Action:     apisecurity.AuthAction_READ_WRITE.String(),
+			Principals: principals,
+			Owner:      "polaris",
+			Valid:      true,
+			Revision:   utils.NewUUID(),
+		})
+		assert.NoError(t, err)
+
+		total, ret, err := us.GetUsersByStrategyID("strategy-users", 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, int(total))
+		ids := []string{ret[0].ID, ret[1].ID}
+		assert.ElementsMatch(t, []string{users[0].ID, users[1].ID}, ids)
+
+		total, ret, err = us.GetUsersByStrategyID("strategy-users", 1, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, int(total))
+		assert.Equal(t, 1, len(ret))
+
+		total, ret, err = us.GetUsersByStrategyID("strategy-not-exist", 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, int(total))
+		assert.Empty(t, ret)
+
+		_, _, err = us.GetUsersByStrategyID("", 0, 100)
+		assert.Error(t, err)
+	})
+}
+
+func Test_userStore_RenameUser(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(2)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 修改名称后仍然可以通过 ID 以及新名称查到同一个用户
+		assert.NoError(t, us.RenameUser(users[0].ID, "rename_user_0", "admin"))
+		ret, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "rename_user_0", ret.Name)
+		assert.Equal(t, users[0].Token, ret.Token)
+		ret, err = us.GetUserByName("rename_user_0", users[0].Owner)
+		assert.NoError(t, err)
+		assert.Equal(t, users[0].ID, ret.ID)
+
+		// 同一个 owner 下名称冲突
+		err = us.RenameUser(users[0].ID, users[1].Name, "admin")
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+		ret, err = us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "rename_user_0", ret.Name)
+
+		err = us.RenameUser("not_exist_user", "rename_user_1", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+
+		err = us.RenameUser(users[0].ID, "", "admin")
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+	})
+}
+
+func Test_userStore_RenameUserDefaultStrategy(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(2)
+		assert.NoError(t, us.AddUser(users[0]))
+		before, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+
+		// 默认策略随着用户一起改名，并且更新 revision 触发缓存刷新
+		assert.NoError(t, us.RenameUser(users[0].ID, "rename_user_0", "admin"))
+		after, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.Equal(t, before.ID, after.ID)
+		assert.Equal(t, model.BuildDefaultStrategyName(model.PrincipalUser, "rename_user_0"), after.Name)
+		assert.NotEqual(t, before.Revision, after.Revision)
+
+		// 使用原名称重新创建用户时创建新的默认策略，不会与改名的用户冲突或者共用
+		users[1].Name = users[0].Name
+		assert.NoError(t, us.AddUser(users[1]))
+		recreated, err := ss.GetDefaultStrategyDetailByPrincipal(users[1].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.NotEqual(t, after.ID, recreated.ID)
+		assert.Equal(t, before.Name, recreated.Name)
+	})
+}
+
+func Test_userStore_SwapUserNames(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(3)
+		users[2].Owner = "other_owner"
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		assert.NoError(t, us.SwapUserNames(users[0].ID, users[1].ID, "admin"))
+		for _, pair := range [][2]int{{0, 1}, {1, 0}} {
+			ret, err := us.GetUser(users[pair[0]].ID)
+			assert.NoError(t, err)
+			assert.Equal(t, users[pair[1]].Name, ret.Name)
+			ret, err = us.GetUserByName(users[pair[1]].Name, users[pair[1]].Owner)
+			assert.NoError(t, err)
+			assert.Equal(t, users[pair[0]].ID, ret.ID)
+			// 默认策略的名称随着用户名称一起交换
+			strategy, err := ss.GetDefaultStrategyDetailByPrincipal(users[pair[0]].ID, model.PrincipalUser)
+			assert.NoError(t, err)
+			assert.Equal(t, model.BuildDefaultStrategyName(model.PrincipalUser, users[pair[1]].Name), strategy.Name)
+		}
+
+		err := us.SwapUserNames(users[0].ID, users[2].ID, "admin")
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		err = us.SwapUserNames(users[0].ID, "not_exist_user", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		err = us.SwapUserNames(users[0].ID, users[0].ID, "admin")
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+	})
+}
+
+// exportWriterFunc 将函数适配为 io.Writer
+type exportWriterFunc func(p []byte) (int, error)
+
+func (f exportWriterFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func Test_userStore_ExportUsersSnapshot(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(4)
+		for i := range users[:3] {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		// 写出第一个用户时修改用户，导出的结果仍然是开始导出时的状态
+		buf := &bytes.Buffer{}
+		mutated := false
+		w := exportWriterFunc(func(p []byte) (int, error) {
+			if !mutated {
+				mutated = true
+				assert.NoError(t, us.SwapUserNames(users[0].ID, users[1].ID, "admin"))
+				assert.NoError(t, us.DeleteUser(users[2]))
+				assert.NoError(t, us.AddUser(users[3]))
+			}
+			return buf.Write(p)
+		})
+		assert.NoError(t, us.ExportUsersSnapshot(context.Background(), w))
+		assert.True(t, mutated)
+
+		decoder := json.NewDecoder(buf)
+		exported := make([]*store.UserSnapshot, 0, 3)
+		for decoder.More() {
+			user := &store.UserSnapshot{}
+			assert.NoError(t, decoder.Decode(user))
+			exported = append(exported, user)
+		}
+		assert.Len(t, exported, 3)
+		for i := range exported {
+			assert.Equal(t, users[i].ID, exported[i].ID)
+			assert.Equal(t, users[i].Name, exported[i].Name)
+			assert.Equal(t, users[i].Token, exported[i].Token)
+			assert.True(t, exported[i].Valid)
+		}
+
+		// 再次导出可以看到修改后的状态
+		buf.Reset()
+		assert.NoError(t, us.ExportUsersSnapshot(context.Background(), buf))
+		exported = exported[:0]
+		decoder = json.NewDecoder(buf)
+		for decoder.More() {
+			user := &store.UserSnapshot{}
+			assert.NoError(t, decoder.Decode(user))
+			exported = append(exported, user)
+		}
+		assert.Len(t, exported, 4)
+		assert.Equal(t, users[1].Name, exported[0].Name)
+		assert.Equal(t, users[0].Name, exported[1].Name)
+		assert.False(t, exported[2].Valid)
+		assert.Equal(t, users[3].ID, exported[3].ID)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Error(t, us.ExportUsersSnapshot(ctx, &bytes.Buffer{}))
+	})
+}
+
+func Test_userStore_TokenConflict(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(2)
+		if err := us.AddUser(users[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		// 新增用户的 token 与已有用户重复
+		users[1].Token = users[0].Token
+		err := us.AddUser(users[1])
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		ret, err := us.GetUser(users[1].ID)
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+
+		// 更新用户的 token 与已有用户重复
+		users[1].Token = "polaris_token_other"
+		assert.NoError(t, us.AddUser(users[1]))
+		users[1].Token = users[0].Token
+		err = us.UpdateUser(users[1])
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+
+		// 用户自身的 token 不视为冲突
+		assert.NoError(t, us.UpdateUser(users[0]))
+	})
+}
+
+func Test_userStore_RecreateUserDefaultStrategy(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(1)
+		if err := us.AddUser(users[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		// 删除后使用相同的 ID 以及名称重建用户，默认策略需要重新创建
+		assert.NoError(t, us.DeleteUser(users[0]))
+		assert.NoError(t, us.AddUser(users[0]))
+		strategy, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.NotNil(t, strategy)
+
+		// 用户数据被直接移除（例如导入数据）时，仍然有效的同名默认策略关联的是同一个用户，直接复用
+		assert.NoError(t, handler.DeleteValues(tblUser, []string{users[0].ID}))
+		assert.NoError(t, us.AddUser(users[0]))
+		reuse, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.Equal(t, strategy.ID, reuse.ID)
+
+		// 同名的默认策略属于其他用户时，明确返回冲突错误
+		assert.NoError(t, handler.DeleteValues(tblUser, []string{users[0].ID}))
+		users[0].ID = "other_user_id"
+		users[0].Token = "other_user_token"
+		err = us.AddUser(users[0])
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.Contains(t, err.Error(), "defaultStrategyConflict")
+
+		// 配置为复用时，导入的用户关联到已有的同名默认策略上
+		us.reuseDefaultStrategy = true
+		assert.NoError(t, us.AddUser(users[0]))
+		shared, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.Equal(t, strategy.ID, shared.ID)
+		assert.NotEqual(t, reuse.Revision, shared.Revision)
+	})
+}
+
+func Test_userStore_RebuildDefaultStrategy(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(2)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		origin, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+
+		// 默认策略被误删后重建
+		assert.NoError(t, handler.DeleteValues(tblStrategy, []string{origin.ID}))
+		_, err = ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.Error(t, err)
+		assert.NoError(t, us.RebuildDefaultStrategy(users[0].ID))
+		rebuilt, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.NotNil(t, rebuilt)
+		assert.NotEqual(t, origin.ID, rebuilt.ID)
+		assert.Equal(t, origin.Name, rebuilt.Name)
+
+		// 重复调用不会修改已有的默认策略
+		assert.NoError(t, us.RebuildDefaultStrategy(users[0].ID))
+		again, err := ss.GetDefaultStrategyDetailByPrincipal(users[0].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.Equal(t, rebuilt.ID, again.ID)
+
+		// 默认策略丢失了关联关系时，移除该策略后重新创建
+		broken, err := ss.GetDefaultStrategyDetailByPrincipal(users[1].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.NoError(t, handler.UpdateValue(tblStrategy, broken.ID, map[string]interface{}{
+			StrategyFieldUsersPrincipal: map[string]string{},
+		}))
+		assert.NoError(t, us.RebuildDefaultStrategy(users[1].ID))
+		rebuilt, err = ss.GetDefaultStrategyDetailByPrincipal(users[1].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.NotEqual(t, broken.ID, rebuilt.ID)
+		removed, err := ss.GetStrategyDetail(broken.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, removed)
+
+		assert.NoError(t, us.DeleteUser(users[1]))
+		err = us.RebuildDefaultStrategy(users[1].ID)
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+	})
+}
+
+func Test_userStore_DeleteAndPurgeUsers(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(3)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		start := time.Now()
+		assert.NoError(t, us.DeleteUser(users[0]))
+		assert.NoError(t, us.DeleteUser(users[1]))
+
+		// 删除时记录删除时间，未删除的用户删除时间为零值
+		ret, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		deleteTimes := map[string]time.Time{}
+		for i := range ret {
+			deleteTimes[ret[i].ID] = ret[i].DeleteTime
+		}
+		assert.False(t, deleteTimes[users[0].ID].Before(start))
+		assert.False(t, deleteTimes[users[1].ID].Before(start))
+		assert.True(t, deleteTimes[users[2].ID].IsZero())
+
+		// 删除时间晚于清理时间点的用户不会被清理
+		count, err := us.PurgeDeletedUsers(start)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), count)
+
+		count, err = us.PurgeDeletedUsers(time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), count)
+
+		ret, err = us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(ret))
+		assert.Equal(t, users[2].ID, ret[0].ID)
+	})
+}
+
+func Test_userStore_PurgeGroupRelations(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+
+		users := createTestUsers(2)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		groups := createTestUserGroup(2)
+		for i := range groups {
+			assert.NoError(t, gs.AddGroup(groups[i]))
+		}
+
+		start := time.Now()
+		assert.NoError(t, gs.UpdateGroup(&model.ModifyUserGroup{
+			ID:            groups[0].ID,
+			Token:         groups[0].Token,
+			TokenEnable:   true,
+			RemoveUserIds: []string{users[1].ID},
+		}))
+		assert.NoError(t, us.DeleteUser(users[0]))
+
+		relationKeys := func() []string {
+			ret, err := handler.LoadValuesByFilter(tblGroupRelation, []string{GroupRelationFieldUserID},
+				&groupRelationForStore{}, func(m map[string]interface{}) bool {
+					return true
+				})
+			assert.NoError(t, err)
+			keys := make([]string, 0, len(ret))
+			for key := range ret {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			return keys
+		}
+
+		// 移除时间晚于清理时间点的关联关系不会被清理
+		_, err := us.PurgeDeletedUsers(start)
+		assert.NoError(t, err)
+		assert.Len(t, relationKeys(), 4)
+
+		// 被清理的用户的关联关系，以及已经移除的关联关系一起被清理
+		count, err := us.PurgeDeletedUsers(time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), count)
+		assert.Equal(t, []string{groups[1].ID + "/" + users[1].ID}, relationKeys())
+	})
+}
+
+func Test_userStore_SetUsersTokenEnable(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(4)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.NoError(t, us.DeleteUser(users[3]))
+
+		// 冻结部分用户，已删除以及不存在的用户不会被更新
+		count, err := us.SetUsersTokenEnable([]string{users[0].ID, users[2].ID, users[3].ID, "not_exist"}, false, "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), count)
+
+		for i, enable := range []bool{false, true, false} {
+			user, err := us.GetUser(users[i].ID)
+			assert.NoError(t, err)
+			assert.Equal(t, enable, user.TokenEnable, users[i].ID)
+		}
+
+		// 状态没有变化的用户不计入更新数量
+		count, err = us.SetUsersTokenEnable([]string{users[0].ID, users[1].ID}, false, "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), count)
+
+		_, err = us.SetUsersTokenEnable(nil, false, "admin")
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+	})
+}
+
+func Test_userStore_SetUsersComment(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(4)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.NoError(t, us.DeleteUser(users[3]))
+
+		// 只为部分用户设置备注，已删除以及不存在的用户不会被更新
+		count, err := us.SetUsersComment([]string{users[0].ID, users[2].ID, users[3].ID, "not_exist"}, "migrated 2024-Q1", "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), count)
+
+		for i, comment := range []string{"migrated 2024-Q1", users[1].Comment, "migrated 2024-Q1"} {
+			user, err := us.GetUser(users[i].ID)
+			assert.NoError(t, err)
+			assert.Equal(t, comment, user.Comment, users[i].ID)
+		}
+
+		// 备注没有变化的用户不计入更新数量
+		count, err = us.SetUsersComment([]string{users[0].ID, users[1].ID}, "migrated 2024-Q1", "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), count)
+
+		_, err = us.SetUsersComment([]string{users[0].ID}, strings.Repeat("a", store.MaxUserCommentLength+1), "admin")
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+
+		_, err = us.SetUsersComment(nil, "comment", "admin")
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+	})
+}
+
+func Test_userStore_GetUserCountsBySource(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(7)
+		users[0].Type = model.AdminUserRole
+		users[0].Owner = users[0].ID
+		users[0].Owner = users[0].ID
+		for i, source := range []string{"Polaris", "LDAP", "LDAP", "LDAP", "OIDC", "OIDC", "LDAP"} {
+			users[i].Source = source
+		}
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.NoError(t, us.DeleteUser(users[6]))
+
+		// 超级账户以及已删除的用户不参与统计
+		counts, err := us.GetUserCountsBySource()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]uint32{"LDAP": 3, "OIDC": 2}, counts)
+	})
+}
+
+func Test_userStore_GetUserCountsByType(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(7)
+		users[0].Type = model.AdminUserRole
+		users[0].Owner = users[0].ID
+		for _, user := range users[1:3] {
+			user.Type = model.OwnerUserRole
+			user.Owner = user.ID
+		}
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.NoError(t, us.DeleteUser(users[6]))
+
+		// 超级账户以及已删除的用户不参与统计
+		counts, err := us.GetUserCountsByType()
+		assert.NoError(t, err)
+		assert.Equal(t, map[model.UserRoleType]uint32{model.OwnerUserRole: 2, model.SubAccountUserRole: 3}, counts)
+	})
+}
+
+func Test_userStore_FindTokenDiagnostics(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(5)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 写入时会检查 token 冲突，这里直接修改存量数据模拟历史遗留的重复以及弱 token
+		assert.NoError(t, handler.UpdateValue(tblUser, users[1].ID, map[string]interface{}{
+			UserFieldToken: users[0].Token,
+		}))
+		assert.NoError(t, handler.UpdateValue(tblUser, users[2].ID, map[string]interface{}{
+			UserFieldToken: "aaaaaaaaaaaaaaaa",
+		}))
+		assert.NoError(t, handler.UpdateValue(tblUser, users[3].ID, map[string]interface{}{
+			UserFieldToken: "aaaaaaaaaaaaaaaa",
+		}))
+		// 已删除用户的 token 不参与检查
+		assert.NoError(t, handler.UpdateValue(tblUser, users[4].ID, map[string]interface{}{
+			UserFieldToken: users[0].Token,
+			UserFieldValid: false,
+		}))
+
+		groups, err := us.FindDuplicateTokens()
+		assert.NoError(t, err)
+		expect := [][]string{{users[0].ID, users[1].ID}, {users[2].ID, users[3].ID}}
+		sort.Slice(expect, func(i, j int) bool {
+			return expect[i][0] < expect[j][0]
+		})
+		for i := range expect {
+			sort.Strings(expect[i])
+		}
+		assert.Equal(t, expect, groups)
+
+		ids, err := us.FindWeakTokens(16)
+		assert.NoError(t, err)
+		weak := []string{users[2].ID, users[3].ID}
+		sort.Strings(weak)
+		assert.Equal(t, weak, ids)
+
+		_, err = us.FindWeakTokens(-1)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_FindDuplicateUserNames(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(6)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		groups, err := us.FindDuplicateUserNames()
+		assert.NoError(t, err)
+		assert.Empty(t, groups)
+
+		// 写入时会检查重名，这里直接修改存量数据模拟历史遗留的重名用户
+		assert.NoError(t, handler.UpdateValue(tblUser, users[1].ID, map[string]interface{}{
+			UserFieldName: users[0].Name,
+		}))
+		// 名称相同但是 owner 不同的用户不视为重复
+		assert.NoError(t, handler.UpdateValue(tblUser, users[2].ID, map[string]interface{}{
+			UserFieldName:  users[0].Name,
+			UserFieldOwner: "other-owner",
+		}))
+		assert.NoError(t, handler.UpdateValue(tblUser, users[4].ID, map[string]interface{}{
+			UserFieldName: users[3].Name,
+		}))
+		// 已删除的用户不参与检查
+		assert.NoError(t, handler.UpdateValue(tblUser, users[5].ID, map[string]interface{}{
+			UserFieldName:  users[0].Name,
+			UserFieldValid: false,
+		}))
+
+		groups, err = us.FindDuplicateUserNames()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{users[0].ID, users[1].ID}, {users[3].ID, users[4].ID}}, groups)
+	})
+}
+
+func Test_userStore_AuditDefaultStrategies(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(3)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		ids, err := us.AuditDefaultStrategies()
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+
+		// 删除 user_1 的默认策略
+		strategy, err := ss.GetDefaultStrategyDetailByPrincipal(users[1].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.NoError(t, ss.DeleteStrategy(strategy.ID))
+		// 已删除的用户不参与检查
+		assert.NoError(t, us.DeleteUser(users[2]))
+
+		ids, err = us.AuditDefaultStrategies()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{users[1].ID}, ids)
+
+		// 重建后不再报告
+		assert.NoError(t, us.RebuildDefaultStrategy(users[1].ID))
+		ids, err = us.AuditDefaultStrategies()
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+	})
+}
+
+func Test_userStore_ResetUserCredentials(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(3)
+		users[0].MustChangePassword = true
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.NoError(t, us.DeleteUser(users[2]))
+		before, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+
+		// 密码以及 token 同时更新
+		assert.NoError(t, us.ResetUserCredentials(users[0].ID, "new-pwd", "new-token", "admin"))
+		user, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "new-pwd", user.Password)
+		assert.Equal(t, "new-token", user.Token)
+		assert.False(t, user.MustChangePassword)
+		assert.True(t, user.ModifyTime.After(before.ModifyTime))
+
+		// token 与其他用户冲突时密码同样不会被修改
+		err = us.ResetUserCredentials(users[0].ID, "other-pwd", users[1].Token, "admin")
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		user, err = us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "new-pwd", user.Password)
+		assert.Equal(t, "new-token", user.Token)
+
+		// 已删除以及不存在的用户
+		err = us.ResetUserCredentials(users[2].ID, "new-pwd", "deleted-token", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		err = us.ResetUserCredentials("not_exist", "new-pwd", "missing-token", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+	})
+}
+
+func Test_userStore_TokenRotatedTime(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(3)
+		for i := range users {
+			if err := us.AddUser(users[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 创建用户时记录 token 的更换时间
+		user, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.False(t, user.TokenRotatedTime.IsZero())
+
+		// 模拟很久之前更换过 token 的用户以及更换时间未知的存量用户
+		stale := time.Unix(time.Now().Add(-100*24*time.Hour).Unix(), 0)
+		assert.NoError(t, handler.UpdateValue(tblUser, users[0].ID, map[string]interface{}{
+			UserFieldTokenRotatedTime: stale,
+		}))
+		assert.NoError(t, handler.UpdateValue(tblUser, users[1].ID, map[string]interface{}{
+			UserFieldTokenRotatedTime: time.Time{},
+		}))
+
+		total, ret, err := us.GetUsers(map[string]string{"token_not_rotated_days": "90"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.ElementsMatch(t, []string{users[0].ID, users[1].ID}, []string{ret[0].ID, ret[1].ID})
+
+		// token 没有变化时不更新更换时间
+		user, err = us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		user.Comment = "new comment"
+		user.Password = "new-pwd"
+		assert.NoError(t, us.UpdateUser(user))
+		assert.NoError(t, us.ResetUserCredentials(users[0].ID, "other-pwd", user.Token, "admin"))
+		user, err = us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, stale.Unix(), user.TokenRotatedTime.Unix())
+
+		// 更新用户时 token 发生变化
+		user.Token = "rotated-token"
+		assert.NoError(t, us.UpdateUser(user))
+		user, err = us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.True(t, user.TokenRotatedTime.After(stale))
+
+		// 重置凭据时 token 发生变化
+		assert.NoError(t, us.ResetUserCredentials(users[1].ID, "new-pwd", "reset-token", "admin"))
+		user, err = us.GetUser(users[1].ID)
+		assert.NoError(t, err)
+		assert.False(t, user.TokenRotatedTime.IsZero())
+
+		total, _, err = us.GetUsers(map[string]string{"token_not_rotated_days": "90"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+
+		_, _, err = us.GetUsers(map[string]string{"token_not_rotated_days": "-1"}, 0, 100)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_QueryUsersWithOwner(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		owner := &model.User{
+			ID:          "polaris",
+			Name:        "polaris-main",
+			Password:    "polaris",
+			Owner:       "polaris",
+			Source:      "Polaris",
+			Type:        model.OwnerUserRole,
+			Token:       "polaris_token",
+			TokenEnable: true,
+			Valid:       true,
+		}
+		assert.NoError(t, us.AddUser(owner))
+		users := createTestUsers(3)
+		users[2].Owner = "missing_owner"
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		total, ret, err := us.QueryUsersWithOwner(&store.UserQuery{}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(4), total)
+		ownerNames := map[string]string{}
+		for i := range ret {
+			ownerNames[ret[i].ID] = ret[i].OwnerName
+		}
+		// 主账户解析为自身的名称，主账户不存在时为空
+		assert.Equal(t, map[string]string{
+			"polaris": "polaris-main",
+			"user_0":  "polaris-main",
+			"user_1":  "polaris-main",
+			"user_2":  "",
+		}, ownerNames)
+	})
+}
+
+func Test_userStore_UserChangeLog(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler, changeLog: true}
+
+		users := createTestUsers(3)
+		assert.NoError(t, us.AddUser(users[0]))
+		assert.NoError(t, us.BatchAddUser(users[1:]))
+		users[0].Comment = "updated"
+		assert.NoError(t, us.UpdateUser(users[0]))
+		assert.NoError(t, us.RenameUser(users[1].ID, "renamed", "admin"))
+		_, err := us.SetUsersTokenEnable([]string{users[2].ID, users[1].ID}, false, "admin")
+		assert.NoError(t, err)
+		assert.NoError(t, us.DeleteUser(users[2]))
+
+		// 变更按照提交的顺序记录
+		changes, err := us.GetUserChanges(0, 100)
+		assert.NoError(t, err)
+		type record struct {
+			op model.UserChangeOp
+			id string
+		}
+		records := make([]record, 0, len(changes))
+		for i := range changes {
+			assert.Equal(t, uint64(i+1), changes[i].Seq)
+			records = append(records, record{op: changes[i].Op, id: changes[i].UserID})
+		}
+		assert.Equal(t, []record{
+			{model.UserChangeCreate, users[0].ID},
+			{model.UserChangeCreate, users[1].ID},
+			{model.UserChangeCreate, users[2].ID},
+			{model.UserChangeUpdate, users[0].ID},
+			{model.UserChangeUpdate, users[1].ID},
+			{model.UserChangeUpdate, users[1].ID},
+			{model.UserChangeUpdate, users[2].ID},
+			{model.UserChangeDelete, users[2].ID},
+		}, records)
+		assert.Contains(t, changes[3].Payload, `"comment":"updated"`)
+		assert.Contains(t, changes[4].Payload, `"name":"renamed"`)
+		assert.Contains(t, changes[7].Payload, `"valid":false`)
+		assert.NotContains(t, changes[0].Payload, users[0].Token)
+
+		// 增量读取
+		incr, err := us.GetUserChanges(3, 2)
+		assert.NoError(t, err)
+		assert.Len(t, incr, 2)
+		assert.Equal(t, uint64(4), incr[0].Seq)
+		assert.Equal(t, uint64(5), incr[1].Seq)
+		incr, err = us.GetUserChanges(8, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, incr)
+
+		// 未开启时不记录
+		us.changeLog = false
+		assert.NoError(t, us.UpdateUser(users[0]))
+		incr, err = us.GetUserChanges(8, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, incr)
+	})
+}
+
+func Test_userStore_SoftDeleteAndRecoverUser(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+		gs := &groupStore{handler: handler}
+		ss := &strategyStore{handler: handler}
+
+		users := createTestUsers(2)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		groups := createTestUserGroup(1)
+		groups[0].UserIds = buildUserIds(users)
+		assert.NoError(t, gs.AddGroup(groups[0]))
+
+		// 级联删除时用户从用户组中移除
+		assert.NoError(t, us.SoftDeleteUser(users[0], true))
+		group, err := gs.GetGroup(groups[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]struct{}{users[1].ID: {}}, group.UserIds)
+
+		// 保留关联关系时只标记用户为删除
+		assert.NoError(t, us.SoftDeleteUser(users[1], false))
+		ret, err := us.GetUser(users[1].ID)
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+		group, err = gs.GetGroup(groups[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]struct{}{users[1].ID: {}}, group.UserIds)
+
+		// 恢复后成员关系以及默认策略随之恢复
+		since := time.Now()
+		assert.NoError(t, us.RecoverUser(users[1].ID, "admin"))
+		ret, err = us.GetUser(users[1].ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, ret)
+		assert.True(t, ret.DeleteTime.IsZero())
+		group, err = gs.GetGroup(groups[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]struct{}{users[1].ID: {}}, group.UserIds)
+		assert.False(t, group.ModifyTime.Before(since))
+		strategy, err := ss.GetDefaultStrategyDetailByPrincipal(users[1].ID, model.PrincipalUser)
+		assert.NoError(t, err)
+		assert.NotNil(t, strategy)
+
+		// 未删除的用户不能恢复
+		err = us.RecoverUser(users[1].ID, "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+	})
+}
+
+func Test_userStore_UserChangeCompact(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler, changeLog: true, changeCompact: true}
+
+		users := createTestUsers(2)
+		assert.NoError(t, us.BatchAddUser(users))
+		for i := 0; i < 3; i++ {
+			users[0].Comment = fmt.Sprintf("updated-%d", i)
+			assert.NoError(t, us.UpdateUser(users[0]))
+		}
+		assert.NoError(t, us.RenameUser(users[1].ID, "renamed", "admin"))
+
+		// 同一个用户的多次修改只保留最后一条
+		changes, err := us.GetUserChanges(0, 100)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 2)
+		assert.Equal(t, users[0].ID, changes[0].UserID)
+		assert.Equal(t, uint64(5), changes[0].Seq)
+		assert.Equal(t, model.UserChangeUpdate, changes[0].Op)
+		assert.Contains(t, changes[0].Payload, `"comment":"updated-2"`)
+		assert.Equal(t, users[1].ID, changes[1].UserID)
+		assert.Equal(t, uint64(6), changes[1].Seq)
+		assert.Contains(t, changes[1].Payload, `"name":"renamed"`)
+
+		// 只在本次读取的范围内合并
+		changes, err = us.GetUserChanges(0, 3)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 2)
+		assert.Equal(t, uint64(2), changes[0].Seq)
+		assert.Equal(t, uint64(3), changes[1].Seq)
+		assert.Equal(t, users[0].ID, changes[1].UserID)
+	})
+}
+
+func Test_userStore_QueryUsersByDeleteTime(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(4)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		base := time.Unix(1700000000, 0)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, us.DeleteUser(users[i]))
+			// 删除时间依次为 base - 1h、base + 1h、base + 3h
+			assert.NoError(t, handler.UpdateValue(tblUser, users[i].ID, map[string]interface{}{
+				UserFieldDeleteTime: base.Add(time.Duration(2*i-1) * time.Hour),
+			}))
+		}
+
+		idsOf := func(ret []*model.User) []string {
+			ids := make([]string, 0, len(ret))
+			for i := range ret {
+				ids = append(ids, ret[i].ID)
+			}
+			return ids
+		}
+
+		total, ret, err := us.GetUsers(map[string]string{
+			"include_deleted": "true",
+			"deleted_after":   strconv.FormatInt(base.Unix(), 10),
+			"deleted_before":  strconv.FormatInt(base.Add(2*time.Hour).Unix(), 10),
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, []string{users[1].ID}, idsOf(ret))
+		assert.False(t, ret[0].Valid)
+
+		total, _, err = us.GetUsers(map[string]string{
+			"include_deleted": "true",
+			"deleted_after":   strconv.FormatInt(base.Unix(), 10),
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+
+		// 同时返回未删除以及已经删除的用户
+		total, _, err = us.GetUsers(map[string]string{"include_deleted": "true"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(4), total)
+
+		// 未指定 include_deleted 时忽略删除时间
+		total, ret, err = us.GetUsers(map[string]string{
+			"deleted_after": strconv.FormatInt(base.Unix(), 10),
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, []string{users[3].ID}, idsOf(ret))
+	})
+}
+
+func Test_userStore_QueryUsersOwnerRecursive(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		owner := &model.User{
+			ID:       "polaris",
+			Name:     "polaris-main",
+			Password: "polaris",
+			Owner:    "polaris",
+			Source:   "Polaris",
+			Type:     model.OwnerUserRole,
+			Token:    "polaris_token",
+		}
+		assert.NoError(t, us.AddUser(owner))
+		// polaris -> user_0 -> user_2、user_3，polaris -> user_1，user_4 属于其他主账户
+		users := createTestUsers(5)
+		users[2].Owner = "user_0"
+		users[3].Owner = "user_0"
+		users[4].Owner = "other"
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		queryIds := func(query *store.UserQuery) []string {
+			query.Order = &store.UserOrder{Field: "name"}
+			total, ret, err := us.QueryUsers(query, 0, 100)
+			assert.NoError(t, err)
+			assert.Equal(t, int(total), len(ret))
+			ids := make([]string, 0, len(ret))
+			for i := range ret {
+				ids = append(ids, ret[i].ID)
+			}
+			return ids
+		}
+
+		// 只返回直接子账户
+		assert.Equal(t, []string{"polaris", "user_0", "user_1"}, queryIds(&store.UserQuery{Owner: "polaris"}))
+		// 返回整棵子账户树
+		assert.Equal(t, []string{"polaris", "user_0", "user_1", "user_2", "user_3"},
+			queryIds(&store.UserQuery{Owner: "polaris", OwnerRecursive: true}))
+		// 从中间层级开始展开
+		assert.Equal(t, []string{"user_0", "user_2", "user_3"},
+			queryIds(&store.UserQuery{Owner: "user_0", OwnerRecursive: true}))
+
+		// 已经删除的子账户不再展开
+		assert.NoError(t, us.DeleteUser(users[0]))
+		assert.Equal(t, []string{"polaris", "user_1"},
+			queryIds(&store.UserQuery{Owner: "polaris", OwnerRecursive: true}))
+	})
+}
+
+func Test_userStore_AddUserOwnerInvariant(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		// 主账户的 owner 需要为自身
+		main := &model.User{ID: "m1", Name: "main-1", Password: "p", Owner: "m2", Source: "Polaris",
+			Type: model.OwnerUserRole, Token: "main_token"}
+		assert.Equal(t, store.InvalidUserOwner, store.Code(us.AddUser(main)))
+		main.Owner = main.ID
+		assert.NoError(t, us.AddUser(main))
+
+		// 子账户的 owner 不能为自身
+		sub := &model.User{ID: "s1", Name: "sub-1", Password: "p", Owner: "s1", Source: "Polaris",
+			Type: model.SubAccountUserRole, Token: "sub_token"}
+		assert.Equal(t, store.InvalidUserOwner, store.Code(us.AddUser(sub)))
+		assert.Equal(t, store.InvalidUserOwner, store.Code(us.BatchAddUser([]*model.User{sub})))
+		ret, err := us.GetUser(sub.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+
+		sub.Owner = main.ID
+		assert.NoError(t, us.AddUser(sub))
+	})
+}
+
+func Test_userStore_GetUserIfModified(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(1)
+		assert.NoError(t, us.AddUser(users[0]))
+		ret, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		etag := ret.Revision()
+
+		// 用户未变化时返回 NotModified
+		ret, err = us.GetUserIfModified(users[0].ID, etag)
+		assert.Equal(t, store.NotModified, store.Code(err))
+		assert.Nil(t, ret)
+
+		// 只记录登录时间不影响版本号
+		assert.NoError(t, us.UpdateLastLogin(users[0].ID))
+		_, err = us.GetUserIfModified(users[0].ID, etag)
+		assert.Equal(t, store.NotModified, store.Code(err))
+
+		// 修改用户后版本号变化，返回最新的用户
+		users[0].Comment = "changed"
+		assert.NoError(t, us.UpdateUser(users[0]))
+		ret, err = us.GetUserIfModified(users[0].ID, etag)
+		assert.NoError(t, err)
+		assert.Equal(t, "changed", ret.Comment)
+		assert.NotEqual(t, etag, ret.Revision())
+
+		// etag 为空以及用户不存在时不返回 NotModified
+		ret, err = us.GetUserIfModified(users[0].ID, "")
+		assert.NoError(t, err)
+		assert.NotNil(t, ret)
+		ret, err = us.GetUserIfModified("not_exist", etag)
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+	})
+}
+
+func Test_userStore_QueryUsersInactiveSince(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		// user_0 40 天前登录过，user_1 1 天前登录过，user_2 从未登录过
+		users := createTestUsers(3)
+		users[0].LastLoginTime = time.Now().Add(-40 * 24 * time.Hour)
+		users[1].LastLoginTime = time.Now().Add(-24 * time.Hour)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		_, ret, err := us.GetUsers(map[string]string{"inactive_since": "30"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, buildUserIds([]*model.User{users[0], users[2]}), buildUserIds(ret))
+
+		_, ret, err = us.GetUsers(map[string]string{"inactive_since": "30", "never_login_inactive": "false"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, buildUserIds(users[:1]), buildUserIds(ret))
+
+		_, ret, err = us.GetUsers(map[string]string{"inactive_since": "0", "never_login_inactive": "false"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, buildUserIds(users[:2]), buildUserIds(ret))
+
+		_, _, err = us.GetUsers(map[string]string{"inactive_since": "-1"}, 0, 100)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_CreatedBy(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(3)
+		users[0].CreatedBy = "admin"
+		users[1].CreatedBy = "polaris"
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		ret, err := us.GetUser(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "admin", ret.CreatedBy)
+		ret, err = us.GetUser(users[2].ID)
+		assert.NoError(t, err)
+		assert.Empty(t, ret.CreatedBy)
+
+		total, list, err := us.GetUsers(map[string]string{"created_by": "admin"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, users[0].ID, list[0].ID)
+	})
+}
+
+func Test_userStore_ModifiedBy(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(3)
+		users[2].CreatedBy = "op"
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+		for _, user := range users[:2] {
+			user.Comment = "changed"
+			user.ModifiedBy = "op"
+			assert.NoError(t, us.UpdateUser(user))
+		}
+		// 改名记录本次的操作者，不再归属于之前的操作者
+		assert.NoError(t, us.RenameUser(users[1].ID, "renamed", "admin"))
+		total, list, err := us.GetUsers(map[string]string{"modified_by": "admin"}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, users[1].ID, list[0].ID)
+
+		now := time.Now()
+		total, list, err = us.GetUsers(map[string]string{"modified_by": "op",
+			"modified_after":  strconv.FormatInt(now.Add(-time.Hour).Unix(), 10),
+			"modified_before": strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.ElementsMatch(t, []string{users[0].ID, users[2].ID}, []string{list[0].ID, list[1].ID})
+
+		total, _, err = us.GetUsers(map[string]string{"modified_by": "op",
+			"modified_after": strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+	})
+}
+
+func Test_userStore_UserToken(t *testing.T) {
+	CreateTableDBHandlerAndRun(t, "test_user", func(t *testing.T, handler BoltHandler) {
+		us := &userStore{handler: handler}
+
+		users := createTestUsers(2)
+		for i := range users {
+			assert.NoError(t, us.AddUser(users[i]))
+		}
+
+		expire := time.Now().Add(time.Hour).Truncate(time.Second)
+		tokens := []*model.UserToken{
+			{ID: "token-1", UserID: users[0].ID, Name: "ci", Token: "value-1", Scope: model.UserTokenReadOnly,
+				ExpireTime: expire},
+			{ID: "token-2", UserID: users[0].ID, Name: "deploy", Token: "value-2", Scope: model.UserTokenReadWrite},
+		}
+		for _, token := range tokens {
+			assert.NoError(t, us.AddUserToken(token))
+		}
+
+		err := us.AddUserToken(&model.UserToken{ID: "token-3", UserID: users[0].ID, Name: "ci", Token: "value-3",
+			Scope: model.UserTokenReadOnly})
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+		err = us.AddUserToken(&model.UserToken{ID: "token-4", UserID: "not-exist", Name: "ci", Token: "value-4",
+			Scope: model.UserTokenReadOnly})
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+
+		ret, err := us.GetUserTokenByID("token-1")
+		assert.NoError(t, err)
+		assert.Equal(t, "value-1", ret.Token)
+		assert.Equal(t, model.UserTokenReadOnly, ret.Scope)
+		assert.True(t, expire.Equal(ret.ExpireTime))
+
+		list, err := us.ListUserTokens(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(list))
+		assert.ElementsMatch(t, []string{"token-1", "token-2"}, []string{list[0].ID, list[1].ID})
+		assert.Empty(t, list[0].Token)
+
+		// 只能撤销属于自己的 token
+		assert.Equal(t, store.NotFoundResource, store.Code(us.RevokeUserToken(users[1].ID, "token-1")))
+		assert.NoError(t, us.RevokeUserToken(users[0].ID, "token-1"))
+		assert.Equal(t, store.NotFoundResource, store.Code(us.RevokeUserToken(users[0].ID, "token-1")))
+
+		ret, err = us.GetUserTokenByID("token-1")
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+		list, err = us.ListUserTokens(users[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(list))
+
+		// 撤销后名称可以重新使用
+		assert.NoError(t, us.AddUserToken(&model.UserToken{ID: "token-5", UserID: users[0].ID, Name: "ci",
+			Token: "value-5", Scope: model.UserTokenReadOnly}))
+	})
+}
@@ -0,0 +1,193 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package boltdb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/store"
+)
+
+const (
+	// tblUserToken 用户的附加 token
+	tblUserToken string = "user_token"
+
+	UserTokenFieldUserID     string = "UserID"
+	UserTokenFieldName       string = "Name"
+	UserTokenFieldValid      string = "Valid"
+	UserTokenFieldModifyTime string = "ModifyTime"
+)
+
+type userTokenForStore struct {
+	ID         string
+	UserID     string
+	Name       string
+	Token      string
+	Scope      string
+	ExpireTime time.Time
+	Valid      bool
+	CreateTime time.Time
+	ModifyTime time.Time
+}
+
+// AddUserToken 为有效用户创建附加 token，同一个用户有效的附加 token 之间名称不能重复
+func (us *userStore) AddUserToken(token *model.UserToken) error {
+	if token.ID == "" || token.UserID == "" || token.Name == "" || token.Token == "" || token.Scope == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"add user token missing some params, id is %s, user is %s, name is %s", token.ID, token.UserID, token.Name))
+	}
+
+	err := us.handler.Execute(true, func(tx *bolt.Tx) error {
+		user, err := us.getUser(tx, token.UserID)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", token.UserID))
+		}
+
+		existed := make(map[string]interface{})
+		fields := []string{UserTokenFieldUserID, UserTokenFieldName, UserTokenFieldValid}
+		if err := loadValuesByFilter(tx, tblUserToken, fields, &userTokenForStore{},
+			func(m map[string]interface{}) bool {
+				valid, _ := m[UserTokenFieldValid].(bool)
+				return valid && m[UserTokenFieldUserID] == token.UserID && m[UserTokenFieldName] == token.Name
+			}, existed); err != nil {
+			return err
+		}
+		if len(existed) != 0 {
+			return store.NewStatusError(store.DuplicateEntryErr, fmt.Sprintf(
+				"user token name(%s) existed in user(%s)", token.Name, token.UserID))
+		}
+
+		now := time.Now()
+		return saveValue(tx, tblUserToken, token.ID, &userTokenForStore{
+			ID:         token.ID,
+			UserID:     token.UserID,
+			Name:       token.Name,
+			Token:      token.Token,
+			Scope:      string(token.Scope),
+			ExpireTime: token.ExpireTime,
+			Valid:      true,
+			CreateTime: now,
+			ModifyTime: now,
+		})
+	})
+	if err != nil {
+		log.Error("[Store][User] add user token", zap.String("id", token.ID), zap.String("user", token.UserID),
+			zap.Error(err))
+		return store.Error(err)
+	}
+	return nil
+}
+
+// GetUserTokenByID 根据 ID 获取有效的附加 token
+func (us *userStore) GetUserTokenByID(id string) (*model.UserToken, error) {
+	if id == "" {
+		return nil, store.NewStatusError(store.EmptyParamsErr, "get user token missing id")
+	}
+	ret, err := us.handler.LoadValues(tblUserToken, []string{id}, &userTokenForStore{})
+	if err != nil {
+		log.Error("[Store][User] get user token", zap.String("id", id), zap.Error(err))
+		return nil, store.Error(err)
+	}
+	val, ok := ret[id].(*userTokenForStore)
+	if !ok || !val.Valid {
+		return nil, nil
+	}
+	return converToUserTokenModel(val), nil
+}
+
+// ListUserTokens 按照创建时间查询用户有效的附加 token，不返回 token 的内容
+func (us *userStore) ListUserTokens(userId string) ([]*model.UserToken, error) {
+	if userId == "" {
+		return nil, store.NewStatusError(store.EmptyParamsErr, "list user tokens missing user id")
+	}
+	fields := []string{UserTokenFieldUserID, UserTokenFieldValid}
+	ret, err := us.handler.LoadValuesByFilter(tblUserToken, fields, &userTokenForStore{},
+		func(m map[string]interface{}) bool {
+			valid, _ := m[UserTokenFieldValid].(bool)
+			return valid && m[UserTokenFieldUserID] == userId
+		})
+	if err != nil {
+		log.Error("[Store][User] list user tokens", zap.String("user", userId), zap.Error(err))
+		return nil, store.Error(err)
+	}
+
+	tokens := make([]*model.UserToken, 0, len(ret))
+	for _, v := range ret {
+		token := converToUserTokenModel(v.(*userTokenForStore))
+		token.Token = ""
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreateTime.Equal(tokens[j].CreateTime) {
+			return tokens[i].CreateTime.Before(tokens[j].CreateTime)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// RevokeUserToken 撤销用户有效的附加 token，token 不存在、已经被撤销或者不属于该用户时返回 NotFoundResource
+func (us *userStore) RevokeUserToken(userId, id string) error {
+	if userId == "" || id == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"revoke user token missing some params, id is %s, user is %s", id, userId))
+	}
+	err := us.handler.Execute(true, func(tx *bolt.Tx) error {
+		ret := make(map[string]interface{})
+		if err := loadValues(tx, tblUserToken, []string{id}, &userTokenForStore{}, ret); err != nil {
+			return err
+		}
+		val, ok := ret[id].(*userTokenForStore)
+		if !ok || !val.Valid || val.UserID != userId {
+			return store.NewStatusError(store.NotFoundResource, fmt.Sprintf(
+				"token(%s) of user(%s) not found", id, userId))
+		}
+		return updateValue(tx, tblUserToken, id, map[string]interface{}{
+			UserTokenFieldValid:      false,
+			UserTokenFieldModifyTime: time.Now(),
+		})
+	})
+	if err != nil {
+		log.Error("[Store][User] revoke user token", zap.String("id", id), zap.String("user", userId), zap.Error(err))
+		return store.Error(err)
+	}
+	return nil
+}
+
+func converToUserTokenModel(token *userTokenForStore) *model.UserToken {
+	return &model.UserToken{
+		ID:         token.ID,
+		UserID:     token.UserID,
+		Name:       token.Name,
+		Token:      token.Token,
+		Scope:      model.UserTokenScope(token.Scope),
+		ExpireTime: token.ExpireTime,
+		Valid:      token.Valid,
+		CreateTime: token.CreateTime,
+		ModifyTime: token.ModifyTime,
+	}
+}
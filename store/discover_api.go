@@ -41,6 +41,8 @@ type NamingModuleStore interface {
 	ToolStore
 	// UserStore 用户接口
 	UserStore
+	// UserTokenStore 用户附加 token 接口
+	UserTokenStore
 	// GroupStore 用户组接口
 	GroupStore
 	// StrategyStore 鉴权策略接口
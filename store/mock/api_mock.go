@@ -5,6 +5,8 @@
 package mock
 
 import (
+	context "context"
+	io "io"
 	reflect "reflect"
 	time "time"
 
@@ -148,6 +150,49 @@ func (mr *MockStoreMockRecorder) AddUser(user interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUser", reflect.TypeOf((*MockStore)(nil).AddUser), user)
 }
 
+// AddUserAndReturn mocks base method.
+func (m *MockStore) AddUserAndReturn(user *model.User) (*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddUserAndReturn", user)
+	ret0, _ := ret[0].(*model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddUserAndReturn indicates an expected call of AddUserAndReturn.
+func (mr *MockStoreMockRecorder) AddUserAndReturn(user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUserAndReturn", reflect.TypeOf((*MockStore)(nil).AddUserAndReturn), user)
+}
+
+// AddUserToken mocks base method.
+func (m *MockStore) AddUserToken(token *model.UserToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddUserToken", token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddUserToken indicates an expected call of AddUserToken.
+func (mr *MockStoreMockRecorder) AddUserToken(token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUserToken", reflect.TypeOf((*MockStore)(nil).AddUserToken), token)
+}
+
+// AddUserTx mocks base method.
+func (m *MockStore) AddUserTx(tx store.Tx, user *model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddUserTx", tx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddUserTx indicates an expected call of AddUserTx.
+func (mr *MockStoreMockRecorder) AddUserTx(tx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddUserTx", reflect.TypeOf((*MockStore)(nil).AddUserTx), tx, user)
+}
+
 // AppendServiceContractInterfaces mocks base method.
 func (m *MockStore) AppendServiceContractInterfaces(contract *model.EnrichServiceContract) error {
 	m.ctrl.T.Helper()
@@ -162,6 +207,21 @@ func (mr *MockStoreMockRecorder) AppendServiceContractInterfaces(contract interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendServiceContractInterfaces", reflect.TypeOf((*MockStore)(nil).AppendServiceContractInterfaces), contract)
 }
 
+// AuditDefaultStrategies mocks base method.
+func (m *MockStore) AuditDefaultStrategies() ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuditDefaultStrategies")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AuditDefaultStrategies indicates an expected call of AuditDefaultStrategies.
+func (mr *MockStoreMockRecorder) AuditDefaultStrategies() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuditDefaultStrategies", reflect.TypeOf((*MockStore)(nil).AuditDefaultStrategies))
+}
+
 // BatchAddClients mocks base method.
 func (m *MockStore) BatchAddClients(clients []*model.Client) error {
 	m.ctrl.T.Helper()
@@ -190,6 +250,48 @@ func (mr *MockStoreMockRecorder) BatchAddInstances(instances interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchAddInstances", reflect.TypeOf((*MockStore)(nil).BatchAddInstances), instances)
 }
 
+// BatchAddUsersBestEffort mocks base method.
+func (m *MockStore) BatchAddUsersBestEffort(users []*model.User) []store.BatchItemResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BatchAddUsersBestEffort", users)
+	ret0, _ := ret[0].([]store.BatchItemResult)
+	return ret0
+}
+
+// BatchAddUsersBestEffort indicates an expected call of BatchAddUsersBestEffort.
+func (mr *MockStoreMockRecorder) BatchAddUsersBestEffort(users interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchAddUsersBestEffort", reflect.TypeOf((*MockStore)(nil).BatchAddUsersBestEffort), users)
+}
+
+// BatchDeleteUsersBestEffort mocks base method.
+func (m *MockStore) BatchDeleteUsersBestEffort(users []*model.User) []store.BatchItemResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BatchDeleteUsersBestEffort", users)
+	ret0, _ := ret[0].([]store.BatchItemResult)
+	return ret0
+}
+
+// BatchDeleteUsersBestEffort indicates an expected call of BatchDeleteUsersBestEffort.
+func (mr *MockStoreMockRecorder) BatchDeleteUsersBestEffort(users interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchDeleteUsersBestEffort", reflect.TypeOf((*MockStore)(nil).BatchDeleteUsersBestEffort), users)
+}
+
+// BatchAddUser mocks base method.
+func (m *MockStore) BatchAddUser(users []*model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BatchAddUser", users)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BatchAddUser indicates an expected call of BatchAddUser.
+func (mr *MockStoreMockRecorder) BatchAddUser(users interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchAddUser", reflect.TypeOf((*MockStore)(nil).BatchAddUser), users)
+}
+
 // BatchAppendInstanceMetadata mocks base method.
 func (m *MockStore) BatchAppendInstanceMetadata(requests []*store.InstanceMetadataRequest) error {
 	m.ctrl.T.Helper()
@@ -872,6 +974,20 @@ func (mr *MockStoreMockRecorder) DeleteUser(user interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockStore)(nil).DeleteUser), user)
 }
 
+// DeleteUserTx mocks base method.
+func (m *MockStore) DeleteUserTx(tx store.Tx, user *model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUserTx", tx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUserTx indicates an expected call of DeleteUserTx.
+func (mr *MockStoreMockRecorder) DeleteUserTx(tx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserTx", reflect.TypeOf((*MockStore)(nil).DeleteUserTx), tx, user)
+}
+
 // Destroy mocks base method.
 func (m *MockStore) Destroy() error {
 	m.ctrl.T.Helper()
@@ -928,6 +1044,80 @@ func (mr *MockStoreMockRecorder) EnableRouting(conf interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableRouting", reflect.TypeOf((*MockStore)(nil).EnableRouting), conf)
 }
 
+// ExportUsersSnapshot mocks base method.
+func (m *MockStore) ExportUsersSnapshot(ctx context.Context, w io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportUsersSnapshot", ctx, w)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportUsersSnapshot indicates an expected call of ExportUsersSnapshot.
+func (mr *MockStoreMockRecorder) ExportUsersSnapshot(ctx, w interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportUsersSnapshot", reflect.TypeOf((*MockStore)(nil).ExportUsersSnapshot), ctx, w)
+}
+
+// FindDuplicateTokens mocks base method.
+func (m *MockStore) FindDuplicateTokens() ([][]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindDuplicateTokens")
+	ret0, _ := ret[0].([][]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindDuplicateTokens indicates an expected call of FindDuplicateTokens.
+func (mr *MockStoreMockRecorder) FindDuplicateTokens() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindDuplicateTokens", reflect.TypeOf((*MockStore)(nil).FindDuplicateTokens))
+}
+
+// FindOrphanedGroupRelations mocks base method.
+func (m *MockStore) FindOrphanedGroupRelations() ([]*model.UserGroupLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindOrphanedGroupRelations")
+	ret0, _ := ret[0].([]*model.UserGroupLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindOrphanedGroupRelations indicates an expected call of FindOrphanedGroupRelations.
+func (mr *MockStoreMockRecorder) FindOrphanedGroupRelations() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindOrphanedGroupRelations", reflect.TypeOf((*MockStore)(nil).FindOrphanedGroupRelations))
+}
+
+// FindDuplicateUserNames mocks base method.
+func (m *MockStore) FindDuplicateUserNames() ([][]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindDuplicateUserNames")
+	ret0, _ := ret[0].([][]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindDuplicateUserNames indicates an expected call of FindDuplicateUserNames.
+func (mr *MockStoreMockRecorder) FindDuplicateUserNames() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindDuplicateUserNames", reflect.TypeOf((*MockStore)(nil).FindDuplicateUserNames))
+}
+
+// FindWeakTokens mocks base method.
+func (m *MockStore) FindWeakTokens(minEntropyBits float64) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindWeakTokens", minEntropyBits)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindWeakTokens indicates an expected call of FindWeakTokens.
+func (mr *MockStoreMockRecorder) FindWeakTokens(minEntropyBits interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindWeakTokens", reflect.TypeOf((*MockStore)(nil).FindWeakTokens), minEntropyBits)
+}
+
 // GenNextL5Sid mocks base method.
 func (m *MockStore) GenNextL5Sid(layoutID uint32) (string, error) {
 	m.ctrl.T.Helper()
@@ -943,6 +1133,22 @@ func (mr *MockStoreMockRecorder) GenNextL5Sid(layoutID interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenNextL5Sid", reflect.TypeOf((*MockStore)(nil).GenNextL5Sid), layoutID)
 }
 
+// GetAddableUsersForGroup mocks base method.
+func (m *MockStore) GetAddableUsersForGroup(groupId, ownerId string, offset, limit uint32) (uint32, []*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAddableUsersForGroup", groupId, ownerId, offset, limit)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].([]*model.User)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetAddableUsersForGroup indicates an expected call of GetAddableUsersForGroup.
+func (mr *MockStoreMockRecorder) GetAddableUsersForGroup(groupId, ownerId, offset, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAddableUsersForGroup", reflect.TypeOf((*MockStore)(nil).GetAddableUsersForGroup), groupId, ownerId, offset, limit)
+}
+
 // GetCircuitBreakerRules mocks base method.
 func (m *MockStore) GetCircuitBreakerRules(filter map[string]string, offset, limit uint32) (uint32, []*model.CircuitBreakerRule, error) {
 	m.ctrl.T.Helper()
@@ -1338,6 +1544,22 @@ func (mr *MockStoreMockRecorder) GetL5Extend(serviceID interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetL5Extend", reflect.TypeOf((*MockStore)(nil).GetL5Extend), serviceID)
 }
 
+// GetMainAccountsWithSubCounts mocks base method.
+func (m *MockStore) GetMainAccountsWithSubCounts(offset, limit uint32) (uint32, []*model.UserWithSubCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMainAccountsWithSubCounts", offset, limit)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].([]*model.UserWithSubCount)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetMainAccountsWithSubCounts indicates an expected call of GetMainAccountsWithSubCounts.
+func (mr *MockStoreMockRecorder) GetMainAccountsWithSubCounts(offset, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMainAccountsWithSubCounts", reflect.TypeOf((*MockStore)(nil).GetMainAccountsWithSubCounts), offset, limit)
+}
+
 // GetMoreClients mocks base method.
 func (m *MockStore) GetMoreClients(mtime time.Time, firstUpdate bool) (map[string]*model.Client, error) {
 	m.ctrl.T.Helper()
@@ -1594,6 +1816,21 @@ func (mr *MockStoreMockRecorder) GetRateLimitsForCache(mtime, firstUpdate interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRateLimitsForCache", reflect.TypeOf((*MockStore)(nil).GetRateLimitsForCache), mtime, firstUpdate)
 }
 
+// GetRecentlyModifiedUsers mocks base method.
+func (m *MockStore) GetRecentlyModifiedUsers(limit uint32) ([]*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecentlyModifiedUsers", limit)
+	ret0, _ := ret[0].([]*model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecentlyModifiedUsers indicates an expected call of GetRecentlyModifiedUsers.
+func (mr *MockStoreMockRecorder) GetRecentlyModifiedUsers(limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecentlyModifiedUsers", reflect.TypeOf((*MockStore)(nil).GetRecentlyModifiedUsers), limit)
+}
+
 // GetRoutingConfigV2WithID mocks base method.
 func (m *MockStore) GetRoutingConfigV2WithID(id string) (*model.RouterConfig, error) {
 	m.ctrl.T.Helper()
@@ -1973,6 +2210,21 @@ func (mr *MockStoreMockRecorder) GetUserByIds(ids interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByIds", reflect.TypeOf((*MockStore)(nil).GetUserByIds), ids)
 }
 
+// GetUserByIdsWithContext mocks base method.
+func (m *MockStore) GetUserByIdsWithContext(ctx context.Context, ids []string) ([]*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByIdsWithContext", ctx, ids)
+	ret0, _ := ret[0].([]*model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByIdsWithContext indicates an expected call of GetUserByIdsWithContext.
+func (mr *MockStoreMockRecorder) GetUserByIdsWithContext(ctx, ids interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByIdsWithContext", reflect.TypeOf((*MockStore)(nil).GetUserByIdsWithContext), ctx, ids)
+}
+
 // GetUserByName mocks base method.
 func (m *MockStore) GetUserByName(name, ownerId string) (*model.User, error) {
 	m.ctrl.T.Helper()
@@ -1988,6 +2240,111 @@ func (mr *MockStoreMockRecorder) GetUserByName(name, ownerId interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByName", reflect.TypeOf((*MockStore)(nil).GetUserByName), name, ownerId)
 }
 
+// GetUserChanges mocks base method.
+func (m *MockStore) GetUserChanges(sinceSeq uint64, limit uint32) ([]*model.UserChange, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserChanges", sinceSeq, limit)
+	ret0, _ := ret[0].([]*model.UserChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserChanges indicates an expected call of GetUserChanges.
+func (mr *MockStoreMockRecorder) GetUserChanges(sinceSeq, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserChanges", reflect.TypeOf((*MockStore)(nil).GetUserChanges), sinceSeq, limit)
+}
+
+// GetUserCountsBySource mocks base method.
+func (m *MockStore) GetUserCountsBySource() (map[string]uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserCountsBySource")
+	ret0, _ := ret[0].(map[string]uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserCountsBySource indicates an expected call of GetUserCountsBySource.
+func (mr *MockStoreMockRecorder) GetUserCountsBySource() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserCountsBySource", reflect.TypeOf((*MockStore)(nil).GetUserCountsBySource))
+}
+
+// GetUserCountsByType mocks base method.
+func (m *MockStore) GetUserCountsByType() (map[model.UserRoleType]uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserCountsByType")
+	ret0, _ := ret[0].(map[model.UserRoleType]uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserCountsByType indicates an expected call of GetUserCountsByType.
+func (mr *MockStoreMockRecorder) GetUserCountsByType() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserCountsByType", reflect.TypeOf((*MockStore)(nil).GetUserCountsByType))
+}
+
+// GetUserGroupRelationsForCache mocks base method.
+func (m *MockStore) GetUserGroupRelationsForCache(mtime time.Time, firstUpdate bool) ([]*model.UserGroupLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserGroupRelationsForCache", mtime, firstUpdate)
+	ret0, _ := ret[0].([]*model.UserGroupLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserGroupRelationsForCache indicates an expected call of GetUserGroupRelationsForCache.
+func (mr *MockStoreMockRecorder) GetUserGroupRelationsForCache(mtime, firstUpdate interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserGroupRelationsForCache", reflect.TypeOf((*MockStore)(nil).GetUserGroupRelationsForCache), mtime, firstUpdate)
+}
+
+// GetUserIfModified mocks base method.
+func (m *MockStore) GetUserIfModified(id, etag string) (*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserIfModified", id, etag)
+	ret0, _ := ret[0].(*model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserIfModified indicates an expected call of GetUserIfModified.
+func (mr *MockStoreMockRecorder) GetUserIfModified(id, etag interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserIfModified", reflect.TypeOf((*MockStore)(nil).GetUserIfModified), id, etag)
+}
+
+// GetUserTokenByID mocks base method.
+func (m *MockStore) GetUserTokenByID(id string) (*model.UserToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserTokenByID", id)
+	ret0, _ := ret[0].(*model.UserToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserTokenByID indicates an expected call of GetUserTokenByID.
+func (mr *MockStoreMockRecorder) GetUserTokenByID(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserTokenByID", reflect.TypeOf((*MockStore)(nil).GetUserTokenByID), id)
+}
+
+// GetUserTx mocks base method.
+func (m *MockStore) GetUserTx(tx store.Tx, id string) (*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserTx", tx, id)
+	ret0, _ := ret[0].(*model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserTx indicates an expected call of GetUserTx.
+func (mr *MockStoreMockRecorder) GetUserTx(tx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserTx", reflect.TypeOf((*MockStore)(nil).GetUserTx), tx, id)
+}
+
 // GetUsers mocks base method.
 func (m *MockStore) GetUsers(filters map[string]string, offset, limit uint32) (uint32, []*model.User, error) {
 	m.ctrl.T.Helper()
@@ -2004,6 +2361,22 @@ func (mr *MockStoreMockRecorder) GetUsers(filters, offset, limit interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsers", reflect.TypeOf((*MockStore)(nil).GetUsers), filters, offset, limit)
 }
 
+// GetUsersByStrategyID mocks base method.
+func (m *MockStore) GetUsersByStrategyID(strategyID string, offset, limit uint32) (uint32, []*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUsersByStrategyID", strategyID, offset, limit)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].([]*model.User)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetUsersByStrategyID indicates an expected call of GetUsersByStrategyID.
+func (mr *MockStoreMockRecorder) GetUsersByStrategyID(strategyID, offset, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersByStrategyID", reflect.TypeOf((*MockStore)(nil).GetUsersByStrategyID), strategyID, offset, limit)
+}
+
 // GetUsersForCache mocks base method.
 func (m *MockStore) GetUsersForCache(mtime time.Time, firstUpdate bool) ([]*model.User, error) {
 	m.ctrl.T.Helper()
@@ -2152,6 +2525,21 @@ func (mr *MockStoreMockRecorder) ListLeaderElections() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLeaderElections", reflect.TypeOf((*MockStore)(nil).ListLeaderElections))
 }
 
+// ListUserTokens mocks base method.
+func (m *MockStore) ListUserTokens(userId string) ([]*model.UserToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserTokens", userId)
+	ret0, _ := ret[0].([]*model.UserToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserTokens indicates an expected call of ListUserTokens.
+func (mr *MockStoreMockRecorder) ListUserTokens(userId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserTokens", reflect.TypeOf((*MockStore)(nil).ListUserTokens), userId)
+}
+
 // LockConfigFile mocks base method.
 func (m *MockStore) LockConfigFile(tx store.Tx, file *model.ConfigFileKey) (*model.ConfigFile, error) {
 	m.ctrl.T.Helper()
@@ -2195,6 +2583,21 @@ func (mr *MockStoreMockRecorder) Name() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Name", reflect.TypeOf((*MockStore)(nil).Name))
 }
 
+// PurgeDeletedUsers mocks base method.
+func (m *MockStore) PurgeDeletedUsers(deletedBefore time.Time) (uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeDeletedUsers", deletedBefore)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeletedUsers indicates an expected call of PurgeDeletedUsers.
+func (mr *MockStoreMockRecorder) PurgeDeletedUsers(deletedBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeDeletedUsers", reflect.TypeOf((*MockStore)(nil).PurgeDeletedUsers), deletedBefore)
+}
+
 // QueryAllConfigFileTemplates mocks base method.
 func (m *MockStore) QueryAllConfigFileTemplates() ([]*model.ConfigFileTemplate, error) {
 	m.ctrl.T.Helper()
@@ -2242,6 +2645,66 @@ func (mr *MockStoreMockRecorder) QueryConfigFiles(filter, offset, limit interfac
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryConfigFiles", reflect.TypeOf((*MockStore)(nil).QueryConfigFiles), filter, offset, limit)
 }
 
+// QueryUsers mocks base method.
+func (m *MockStore) QueryUsers(query *store.UserQuery, offset, limit uint32) (uint32, []*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryUsers", query, offset, limit)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].([]*model.User)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// QueryUsers indicates an expected call of QueryUsers.
+func (mr *MockStoreMockRecorder) QueryUsers(query, offset, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryUsers", reflect.TypeOf((*MockStore)(nil).QueryUsers), query, offset, limit)
+}
+
+// QueryUsersWithOwner mocks base method.
+func (m *MockStore) QueryUsersWithOwner(query *store.UserQuery, offset, limit uint32) (uint32, []*model.UserWithOwner, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryUsersWithOwner", query, offset, limit)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].([]*model.UserWithOwner)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// QueryUsersWithOwner indicates an expected call of QueryUsersWithOwner.
+func (mr *MockStoreMockRecorder) QueryUsersWithOwner(query, offset, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryUsersWithOwner", reflect.TypeOf((*MockStore)(nil).QueryUsersWithOwner), query, offset, limit)
+}
+
+// RebuildDefaultStrategy mocks base method.
+func (m *MockStore) RebuildDefaultStrategy(userId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RebuildDefaultStrategy", userId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RebuildDefaultStrategy indicates an expected call of RebuildDefaultStrategy.
+func (mr *MockStoreMockRecorder) RebuildDefaultStrategy(userId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RebuildDefaultStrategy", reflect.TypeOf((*MockStore)(nil).RebuildDefaultStrategy), userId)
+}
+
+// RecoverUser mocks base method.
+func (m *MockStore) RecoverUser(userId, modifiedBy string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecoverUser", userId, modifiedBy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecoverUser indicates an expected call of RecoverUser.
+func (mr *MockStoreMockRecorder) RecoverUser(userId, modifiedBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecoverUser", reflect.TypeOf((*MockStore)(nil).RecoverUser), userId, modifiedBy)
+}
+
 // ReleaseLeaderElection mocks base method.
 func (m *MockStore) ReleaseLeaderElection(key string) error {
 	m.ctrl.T.Helper()
@@ -2270,6 +2733,63 @@ func (mr *MockStoreMockRecorder) RemoveStrategyResources(resources interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveStrategyResources", reflect.TypeOf((*MockStore)(nil).RemoveStrategyResources), resources)
 }
 
+// RenameUser mocks base method.
+func (m *MockStore) RenameUser(userId, newName, modifiedBy string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameUser", userId, newName, modifiedBy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameUser indicates an expected call of RenameUser.
+func (mr *MockStoreMockRecorder) RenameUser(userId, newName, modifiedBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameUser", reflect.TypeOf((*MockStore)(nil).RenameUser), userId, newName, modifiedBy)
+}
+
+// RepairOrphanedGroupRelations mocks base method.
+func (m *MockStore) RepairOrphanedGroupRelations() ([]*model.UserGroupLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RepairOrphanedGroupRelations")
+	ret0, _ := ret[0].([]*model.UserGroupLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RepairOrphanedGroupRelations indicates an expected call of RepairOrphanedGroupRelations.
+func (mr *MockStoreMockRecorder) RepairOrphanedGroupRelations() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RepairOrphanedGroupRelations", reflect.TypeOf((*MockStore)(nil).RepairOrphanedGroupRelations))
+}
+
+// ResetUserCredentials mocks base method.
+func (m *MockStore) ResetUserCredentials(userId, password, token, modifiedBy string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetUserCredentials", userId, password, token, modifiedBy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetUserCredentials indicates an expected call of ResetUserCredentials.
+func (mr *MockStoreMockRecorder) ResetUserCredentials(userId, password, token, modifiedBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetUserCredentials", reflect.TypeOf((*MockStore)(nil).ResetUserCredentials), userId, password, token, modifiedBy)
+}
+
+// RevokeUserToken mocks base method.
+func (m *MockStore) RevokeUserToken(userId, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserToken", userId, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserToken indicates an expected call of RevokeUserToken.
+func (mr *MockStoreMockRecorder) RevokeUserToken(userId, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserToken", reflect.TypeOf((*MockStore)(nil).RevokeUserToken), userId, id)
+}
+
 // SetInstanceHealthStatus mocks base method.
 func (m *MockStore) SetInstanceHealthStatus(instanceID string, flag int, revision string) error {
 	m.ctrl.T.Helper()
@@ -2299,6 +2819,50 @@ func (mr *MockStoreMockRecorder) SetL5Extend(serviceID, meta interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetL5Extend", reflect.TypeOf((*MockStore)(nil).SetL5Extend), serviceID, meta)
 }
 
+// SetUsersComment mocks base method.
+func (m *MockStore) SetUsersComment(ids []string, comment, modifiedBy string) (uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUsersComment", ids, comment, modifiedBy)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetUsersComment indicates an expected call of SetUsersComment.
+func (mr *MockStoreMockRecorder) SetUsersComment(ids, comment, modifiedBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUsersComment", reflect.TypeOf((*MockStore)(nil).SetUsersComment), ids, comment, modifiedBy)
+}
+
+// SetUsersTokenEnable mocks base method.
+func (m *MockStore) SetUsersTokenEnable(ids []string, enable bool, modifiedBy string) (uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUsersTokenEnable", ids, enable, modifiedBy)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetUsersTokenEnable indicates an expected call of SetUsersTokenEnable.
+func (mr *MockStoreMockRecorder) SetUsersTokenEnable(ids, enable, modifiedBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUsersTokenEnable", reflect.TypeOf((*MockStore)(nil).SetUsersTokenEnable), ids, enable, modifiedBy)
+}
+
+// SoftDeleteUser mocks base method.
+func (m *MockStore) SoftDeleteUser(user *model.User, cascadeGroups bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SoftDeleteUser", user, cascadeGroups)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SoftDeleteUser indicates an expected call of SoftDeleteUser.
+func (mr *MockStoreMockRecorder) SoftDeleteUser(user, cascadeGroups interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SoftDeleteUser", reflect.TypeOf((*MockStore)(nil).SoftDeleteUser), user, cascadeGroups)
+}
+
 // StartLeaderElection mocks base method.
 func (m *MockStore) StartLeaderElection(key string) error {
 	m.ctrl.T.Helper()
@@ -2343,6 +2907,20 @@ func (mr *MockStoreMockRecorder) StartTx() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartTx", reflect.TypeOf((*MockStore)(nil).StartTx))
 }
 
+// SwapUserNames mocks base method.
+func (m *MockStore) SwapUserNames(userIdA, userIdB, modifiedBy string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SwapUserNames", userIdA, userIdB, modifiedBy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SwapUserNames indicates an expected call of SwapUserNames.
+func (mr *MockStoreMockRecorder) SwapUserNames(userIdA, userIdB, modifiedBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SwapUserNames", reflect.TypeOf((*MockStore)(nil).SwapUserNames), userIdA, userIdB, modifiedBy)
+}
+
 // UpdateCircuitBreakerRule mocks base method.
 func (m *MockStore) UpdateCircuitBreakerRule(cbRule *model.CircuitBreakerRule) error {
 	m.ctrl.T.Helper()
@@ -2427,6 +3005,20 @@ func (mr *MockStoreMockRecorder) UpdateInstance(instance interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateInstance", reflect.TypeOf((*MockStore)(nil).UpdateInstance), instance)
 }
 
+// UpdateLastLogin mocks base method.
+func (m *MockStore) UpdateLastLogin(userId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateLastLogin", userId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateLastLogin indicates an expected call of UpdateLastLogin.
+func (mr *MockStoreMockRecorder) UpdateLastLogin(userId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLastLogin", reflect.TypeOf((*MockStore)(nil).UpdateLastLogin), userId)
+}
+
 // UpdateNamespace mocks base method.
 func (m *MockStore) UpdateNamespace(namespace *model.Namespace) error {
 	m.ctrl.T.Helper()
@@ -2595,6 +3187,34 @@ func (mr *MockStoreMockRecorder) UpdateUser(user interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockStore)(nil).UpdateUser), user)
 }
 
+// UpdateUserTx mocks base method.
+func (m *MockStore) UpdateUserTx(tx store.Tx, user *model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserTx", tx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUserTx indicates an expected call of UpdateUserTx.
+func (mr *MockStoreMockRecorder) UpdateUserTx(tx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserTx", reflect.TypeOf((*MockStore)(nil).UpdateUserTx), tx, user)
+}
+
+// WithTx mocks base method.
+func (m *MockStore) WithTx(ctx context.Context, fn func(store.TxStore) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithTx", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WithTx indicates an expected call of WithTx.
+func (mr *MockStoreMockRecorder) WithTx(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithTx", reflect.TypeOf((*MockStore)(nil).WithTx), ctx, fn)
+}
+
 // MockNamespaceStore is a mock of NamespaceStore interface.
 type MockNamespaceStore struct {
 	ctrl     *gomock.Controller
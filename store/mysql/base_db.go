@@ -20,6 +20,8 @@ package sqldb
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -32,12 +34,36 @@ import (
 // db抛出的异常，需要重试的字符串组
 var errMsg = []string{"Deadlock", "bad connection", "invalid connection"}
 
+// authLockOrder 用户、用户组以及鉴权策略相关表在同一事务中的加锁顺序。
+// 修改多张表的事务需要按照该顺序执行写语句（以及 SELECT ... FOR UPDATE），避免与其他事务交叉加锁导致死锁，
+// 依然出现的死锁由 RetryTransaction 重试兜底
+var authLockOrder = []string{
+	"user",
+	"user_group_relation",
+	"user_group",
+	"auth_strategy",
+	"auth_strategy_resource",
+	"auth_principal",
+	"user_change_seq",
+	"user_change_log",
+}
+
+// 只读库无法连接时的异常，命中后降级到主库读取
+var connErrMsg = []string{"bad connection", "invalid connection", "connection refused", "dial tcp",
+	"no such host", "i/o timeout", "broken pipe"}
+
 // BaseDB 对sql.DB的封装
 type BaseDB struct {
 	*sql.DB
 	cfg            *dbConfig
 	isolationLevel sql.IsolationLevel
 	parsePwd       plugin.ParsePassword
+	// queryTimeout 单次 Query/QueryRow/Exec 的超时时间，小于等于 0 表示不设置超时
+	queryTimeout time.Duration
+	// fallback 只读库无法连接时降级使用的主库，仅在 slave 上设置
+	fallback *BaseDB
+	// trace 开启链路追踪时语句所属的 span，每条语句作为其子 span
+	trace *traceSpan
 }
 
 // dbConfig store的配置
@@ -51,11 +77,17 @@ type dbConfig struct {
 	maxIdleConns     int
 	connMaxLifetime  int
 	txIsolationLevel int
+	queryTimeout     int
+	// connRetryMaxWait 启动时等待数据库可连接的最长时间，小于等于 0 表示不重试
+	connRetryMaxWait time.Duration
+	// connRetryInterval 首次重试的间隔，之后每次翻倍，最大不超过 connRetryMaxInterval
+	connRetryInterval    time.Duration
+	connRetryMaxInterval time.Duration
 }
 
 // NewBaseDB 新建一个BaseDB
 func NewBaseDB(cfg *dbConfig, parsePwd plugin.ParsePassword) (*BaseDB, error) {
-	baseDb := &BaseDB{cfg: cfg, parsePwd: parsePwd}
+	baseDb := &BaseDB{cfg: cfg, parsePwd: parsePwd, queryTimeout: time.Second * time.Duration(cfg.queryTimeout)}
 	if cfg.txIsolationLevel > 0 {
 		baseDb.isolationLevel = sql.IsolationLevel(cfg.txIsolationLevel)
 		log.Infof("[Store][database] use isolation level: %s", baseDb.isolationLevel.String())
@@ -89,8 +121,9 @@ func (b *BaseDB) openDatabase() error {
 		log.Errorf("[Store][database] sql open err: %s", err.Error())
 		return err
 	}
-	if pingErr := db.Ping(); pingErr != nil {
+	if pingErr := b.pingDatabase(db); pingErr != nil {
 		log.Errorf("[Store][database] database ping err: %s", pingErr.Error())
+		_ = db.Close()
 		return pingErr
 	}
 	if c.maxOpenConns > 0 {
@@ -110,6 +143,77 @@ func (b *BaseDB) openDatabase() error {
 	return nil
 }
 
+// pingDatabase 检查数据库是否可以连接，数据库未就绪时按照指数退避重试，直到超过 connRetryMaxWait
+func (b *BaseDB) pingDatabase(db *sql.DB) error {
+	c := b.cfg
+	deadline := time.Now().Add(c.connRetryMaxWait)
+	interval := c.connRetryInterval
+	for i := 1; ; i++ {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return err
+		}
+		if interval < wait {
+			wait = interval
+		}
+		log.Warnf("[Store][database] database(%s) is not ready, retry(%d) after %s, err: %s",
+			c.dbAddr, i, wait, err.Error())
+		time.Sleep(wait)
+		interval *= 2
+		if interval > c.connRetryMaxInterval {
+			interval = c.connRetryMaxInterval
+		}
+	}
+}
+
+// WithQueryTimeout 返回使用指定超时时间的 BaseDB，用于单次调用覆盖默认的超时时间，timeout 小于等于 0 表示不设置超时
+func (b *BaseDB) WithQueryTimeout(timeout time.Duration) *BaseDB {
+	db := *b
+	db.queryTimeout = timeout
+	return &db
+}
+
+// WithIsolationLevel 返回开启事务时使用指定隔离级别的 BaseDB，level 为 sql.LevelDefault 时使用数据库的默认隔离级别
+func (b *BaseDB) WithIsolationLevel(level sql.IsolationLevel) *BaseDB {
+	db := *b
+	db.isolationLevel = level
+	return &db
+}
+
+// withTrace 返回在 span 下为每条语句创建子 span 的 BaseDB，span 为 nil 时返回自身
+func (b *BaseDB) withTrace(span *traceSpan) *BaseDB {
+	if b == nil || span == nil {
+		return b
+	}
+	db := *b
+	db.trace = span
+	return &db
+}
+
+// queryContext 基于 parent 生成单次调用使用的 context
+// Query/QueryRow 返回的结果在调用方读取完毕前需要保持 context 有效，因此调用成功时不能立即 cancel，
+// 由超时时间到达后自动释放
+func (b *BaseDB) queryContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if b.queryTimeout <= 0 {
+		return parent, func() {}
+	}
+	return context.WithTimeout(parent, b.queryTimeout)
+}
+
+// wrapTimeoutErr 超时后不同驱动返回的错误不尽相同，统一转为 context.DeadlineExceeded 便于调用方识别，
+// 同时避免驱动返回的 invalid connection 等错误触发重试
+func (b *BaseDB) wrapTimeoutErr(ctx context.Context, query string, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	log.Errorf("[Store][database] query(%s) timeout after %s, err: %s", query, b.queryTimeout, err.Error())
+	return fmt.Errorf("query timeout after %s: %w", b.queryTimeout, context.DeadlineExceeded)
+}
+
 // Exec 重写db.Exec函数 提供重试功能
 func (b *BaseDB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	var (
@@ -119,16 +223,26 @@ func (b *BaseDB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	)
 	defer reportCallMetrics("Exec", start, err)
 
+	span := b.trace.statement("mysql.Exec", query)
 	Retry("exec "+query, func() error {
-		result, err = b.DB.Exec(query, args...)
+		ctx, cancel := b.queryContext(context.Background())
+		defer cancel()
+		result, err = b.DB.ExecContext(ctx, query, args...)
+		err = b.wrapTimeoutErr(ctx, query, err)
 		return err
 	})
+	span.finish(err)
 
 	return result, err
 }
 
 // Query 重写db.Query函数
 func (b *BaseDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return b.QueryWithContext(context.Background(), query, args...)
+}
+
+// QueryWithContext 与 Query 一致，ctx 取消后查询随之中止
+func (b *BaseDB) QueryWithContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
 	var (
 		rows  *sql.Rows
 		err   error
@@ -136,11 +250,22 @@ func (b *BaseDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
 	)
 	defer reportCallMetrics("Query", start, err)
 
+	span := b.trace.statement("mysql.Query", query)
 	Retry("query "+query, func() error {
-		rows, err = b.DB.Query(query, args...)
+		queryCtx, cancel := b.queryContext(ctx)
+		rows, err = b.DB.QueryContext(queryCtx, query, args...)
+		if err != nil {
+			err = b.wrapTimeoutErr(queryCtx, query, err)
+			cancel()
+		}
 		return err
 	})
+	span.finish(err)
 
+	if b.needFallback(err) {
+		b.reportFallback("Query", query, err)
+		return b.fallback.QueryWithContext(ctx, query, args...)
+	}
 	return rows, err
 }
 
@@ -153,12 +278,22 @@ func (b *BaseDB) QueryRow(query string, args ...interface{}) *sql.Row {
 	)
 	defer reportCallMetrics("QueryRow", start, err)
 
+	span := b.trace.statement("mysql.QueryRow", query)
 	Retry("query "+query, func() error {
-		row = b.DB.QueryRow(query, args...)
+		ctx, cancel := b.queryContext(context.Background())
+		row = b.DB.QueryRowContext(ctx, query, args...)
 		err = row.Err()
+		if err != nil {
+			cancel()
+		}
 		return row.Err()
 	})
+	span.finish(err)
 
+	if b.needFallback(err) {
+		b.reportFallback("QueryRow", query, err)
+		return b.fallback.QueryRow(query, args...)
+	}
 	return row
 }
 
@@ -171,17 +306,53 @@ func (b *BaseDB) Begin() (*BaseTx, error) {
 		start  = time.Now()
 	)
 	if b.isolationLevel > 0 {
-		option = &sql.TxOptions{Isolation: sql.IsolationLevel(b.isolationLevel)}
+		option = &sql.TxOptions{Isolation: b.isolationLevel}
 	}
 
 	defer reportCallMetrics("Begin", start, err)
 
+	span := b.trace.statement("mysql.Begin", "BEGIN")
 	Retry("begin", func() error {
 		tx, err = b.DB.BeginTx(context.Background(), option)
 		return err
 	})
+	span.finish(err)
+
+	if b.needFallback(err) {
+		b.reportFallback("Begin", "begin", err)
+		return b.fallback.Begin()
+	}
+	return &BaseTx{Tx: tx, trace: b.trace}, err
+}
+
+// needFallback 只读库无法连接时需要降级到主库
+func (b *BaseDB) needFallback(err error) bool {
+	if err == nil || b.fallback == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	for _, msg := range connErrMsg {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
 
-	return &BaseTx{Tx: tx}, err
+// reportFallback 记录只读库降级到主库的日志以及监控
+func (b *BaseDB) reportFallback(label, query string, err error) {
+	log.Warnf("[Store][database] slave is unavailable, fallback to master, query(%s) err: %s", query, err.Error())
+	plugin.GetStatis().ReportCallMetrics(metrics.CallMetric{
+		Type:             metrics.StoreCallMetric,
+		API:              "SlaveFallback" + label,
+		Protocol:         "MySQL",
+		Code:             int(store.Code(store.Error(err))),
+		Times:            1,
+		Success:          false,
+		TrafficDirection: metrics.TrafficDirectionOutBound,
+	})
 }
 
 func reportCallMetrics(label string, start time.Time, err error) {
@@ -205,6 +376,32 @@ func reportCallMetrics(label string, start time.Time, err error) {
 // BaseTx 对sql.Tx的封装
 type BaseTx struct {
 	*sql.Tx
+	// trace 开启链路追踪时事务所属的 span，事务内的每条语句作为其子 span
+	trace *traceSpan
+}
+
+// Exec 重写tx.Exec函数，开启链路追踪时为语句创建子 span
+func (b *BaseTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	span := b.trace.statement("mysql.Tx.Exec", query)
+	result, err := b.Tx.Exec(query, args...)
+	span.finish(err)
+	return result, err
+}
+
+// Query 重写tx.Query函数，开启链路追踪时为语句创建子 span
+func (b *BaseTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	span := b.trace.statement("mysql.Tx.Query", query)
+	rows, err := b.Tx.Query(query, args...)
+	span.finish(err)
+	return rows, err
+}
+
+// QueryRow 重写tx.QueryRow函数，开启链路追踪时为语句创建子 span
+func (b *BaseTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	span := b.trace.statement("mysql.Tx.QueryRow", query)
+	row := b.Tx.QueryRow(query, args...)
+	span.finish(row.Err())
+	return row
 }
 
 // Commit .
@@ -214,7 +411,9 @@ func (b *BaseTx) Commit() error {
 		err   error
 	)
 	defer reportCallMetrics("Commit", start, err)
+	span := b.trace.statement("mysql.Tx.Commit", "COMMIT")
 	err = b.Tx.Commit()
+	span.finish(err)
 	return err
 }
 
@@ -18,12 +18,21 @@
 package sqldb
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	apimodel "github.com/polarismesh/specification/source/go/api/v1/model"
 	. "github.com/smartystreets/goconvey/convey"
+
+	commonstore "github.com/polarismesh/polaris/common/store"
+	"github.com/polarismesh/polaris/store"
 )
 
 // TestRetry 测试retry
@@ -145,3 +154,241 @@ func TestBatchOperation(t *testing.T) {
 		So(num, ShouldEqual, 0)
 	})
 }
+
+// TestBaseDB_QueryTimeout 测试单次查询超时
+func TestBaseDB_QueryTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	baseDB := &BaseDB{DB: db, queryTimeout: 50 * time.Millisecond}
+
+	Convey("查询超过超时时间返回超时错误", t, func() {
+		mock.ExpectQuery("SELECT COUNT").WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		_, err := baseDB.Query("SELECT COUNT(*) FROM user")
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+
+		mock.ExpectExec("UPDATE user").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+		_, err = baseDB.Exec("UPDATE user SET flag = 1")
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(store.Code(store.Error(err)), ShouldEqual, store.Timeout)
+		So(commonstore.StoreCode2APICode(store.Error(err)), ShouldEqual, apimodel.Code_ExecuteException)
+	})
+
+	Convey("MySQL 的 max_execution_time 超时同样归类为超时", t, func() {
+		mock.ExpectQuery("SELECT COUNT").WillReturnError(&mysql.MySQLError{Number: 3024,
+			Message: "Query execution was interrupted, maximum statement execution time exceeded"})
+		_, err := baseDB.Query("SELECT COUNT(*) FROM user")
+		So(store.Code(store.Error(err)), ShouldEqual, store.Timeout)
+
+		mock.ExpectQuery("SELECT COUNT").WillReturnError(errors.New("Unknown column 'xxx'"))
+		_, err = baseDB.Query("SELECT COUNT(*) FROM user")
+		So(store.Code(store.Error(err)), ShouldEqual, store.Unknown)
+	})
+
+	Convey("单次调用可以覆盖默认的超时时间", t, func() {
+		mock.ExpectQuery("SELECT COUNT").WillDelayFor(100 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		var count int
+		err := baseDB.WithQueryTimeout(time.Second).QueryRow("SELECT COUNT(*) FROM user").Scan(&count)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+	})
+}
+
+// TestBaseDB_SlaveFallback 测试只读库无法连接时降级到主库
+func TestBaseDB_SlaveFallback(t *testing.T) {
+	masterDB, masterMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slaveDB, slaveMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = masterDB.Close()
+		_ = slaveDB.Close()
+	}()
+	master := &BaseDB{DB: masterDB}
+	slave := &BaseDB{DB: slaveDB, fallback: master}
+	connErr := errors.New("dial tcp 127.0.0.1:3306: connect: connection refused")
+
+	Convey("只读库无法连接时从主库读取", t, func() {
+		slaveMock.ExpectQuery("SELECT COUNT").WillReturnError(connErr)
+		masterMock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		count, err := queryEntryCount(slave, "SELECT COUNT(*) FROM user", nil)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+
+		slaveMock.ExpectQuery("SELECT id").WillReturnError(connErr)
+		masterMock.ExpectQuery("SELECT id").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("u1"))
+		rows, err := slave.Query("SELECT id FROM user")
+		So(err, ShouldBeNil)
+		So(rows.Next(), ShouldBeTrue)
+		_ = rows.Close()
+
+		So(slaveMock.ExpectationsWereMet(), ShouldBeNil)
+		So(masterMock.ExpectationsWereMet(), ShouldBeNil)
+	})
+
+	Convey("非连接类的错误不降级", t, func() {
+		slaveMock.ExpectQuery("SELECT COUNT").WillReturnError(errors.New("Unknown column 'xxx'"))
+		_, err := queryEntryCount(slave, "SELECT COUNT(*) FROM user", nil)
+		So(err, ShouldNotBeNil)
+		So(slaveMock.ExpectationsWereMet(), ShouldBeNil)
+		So(masterMock.ExpectationsWereMet(), ShouldBeNil)
+	})
+
+	Convey("主库没有降级配置时直接返回错误", t, func() {
+		masterMock.ExpectQuery("SELECT COUNT").WillReturnError(connErr)
+		_, err := queryEntryCount(master, "SELECT COUNT(*) FROM user", nil)
+		So(err, ShouldNotBeNil)
+		So(masterMock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+// TestBaseDB_ConnRetry 测试启动时数据库未就绪的重试
+func TestBaseDB_ConnRetry(t *testing.T) {
+	cfg := &dbConfig{
+		dbType:               "sqlmock",
+		dbUser:               "polaris",
+		dbPwd:                "polaris",
+		dbAddr:               "127.0.0.1:3306",
+		dbName:               "polaris_server",
+		connRetryInterval:    time.Millisecond * 10,
+		connRetryMaxInterval: time.Millisecond * 40,
+	}
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s", cfg.dbUser, cfg.dbPwd, cfg.dbAddr, cfg.dbName)
+	connErr := errors.New("dial tcp 127.0.0.1:3306: connect: connection refused")
+
+	Convey("数据库在几次重试后可以连接", t, func() {
+		db, mock, err := sqlmock.NewWithDSN(dsn+"?retry=ok", sqlmock.MonitorPingsOption(true))
+		So(err, ShouldBeNil)
+		defer func() { _ = db.Close() }()
+		for i := 0; i < 3; i++ {
+			mock.ExpectPing().WillReturnError(connErr)
+		}
+		mock.ExpectPing()
+
+		c := *cfg
+		c.dbName += "?retry=ok"
+		c.connRetryMaxWait = time.Second * 5
+		baseDB, err := NewBaseDB(&c, nil)
+		So(err, ShouldBeNil)
+		So(baseDB, ShouldNotBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+
+	Convey("超过最大等待时间后返回错误", t, func() {
+		db, mock, err := sqlmock.NewWithDSN(dsn+"?retry=timeout", sqlmock.MonitorPingsOption(true))
+		So(err, ShouldBeNil)
+		defer func() { _ = db.Close() }()
+		for i := 0; i < 100; i++ {
+			mock.ExpectPing().WillReturnError(connErr)
+		}
+
+		c := *cfg
+		c.dbName += "?retry=timeout"
+		c.connRetryMaxWait = time.Millisecond * 100
+		start := time.Now()
+		_, err = NewBaseDB(&c, nil)
+		So(err, ShouldNotBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, c.connRetryMaxWait)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+
+	Convey("未配置最大等待时间时不重试", t, func() {
+		db, mock, err := sqlmock.NewWithDSN(dsn+"?retry=none", sqlmock.MonitorPingsOption(true))
+		So(err, ShouldBeNil)
+		defer func() { _ = db.Close() }()
+		mock.ExpectPing().WillReturnError(connErr)
+
+		c := *cfg
+		c.dbName += "?retry=none"
+		_, err = NewBaseDB(&c, nil)
+		So(err, ShouldNotBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+// txOptionsConnector 记录开启事务时使用的隔离级别的 driver.Connector
+type txOptionsConnector struct {
+	levels []driver.IsolationLevel
+}
+
+func (c *txOptionsConnector) Connect(context.Context) (driver.Conn, error) {
+	return &txOptionsConn{connector: c}, nil
+}
+
+func (c *txOptionsConnector) Driver() driver.Driver {
+	return nil
+}
+
+type txOptionsConn struct {
+	connector *txOptionsConnector
+}
+
+func (c *txOptionsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *txOptionsConn) Close() error {
+	return nil
+}
+
+func (c *txOptionsConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *txOptionsConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.connector.levels = append(c.connector.levels, opts.Isolation)
+	return c, nil
+}
+
+func (c *txOptionsConn) Commit() error {
+	return nil
+}
+
+func (c *txOptionsConn) Rollback() error {
+	return nil
+}
+
+// TestStableStore_UserTxIsolationLevel 测试用户 store 的事务隔离级别
+func TestStableStore_UserTxIsolationLevel(t *testing.T) {
+	begin := func(db *BaseDB) {
+		tx, err := db.Begin()
+		So(err, ShouldBeNil)
+		So(tx.Rollback(), ShouldBeNil)
+	}
+
+	Convey("未配置时使用数据库的默认隔离级别", t, func() {
+		connector := &txOptionsConnector{}
+		db := sql.OpenDB(connector)
+		defer func() { _ = db.Close() }()
+		master := &BaseDB{DB: db}
+		s := &stableStore{master: master, slave: master}
+		s.newStore()
+
+		begin(s.userStore.master)
+		So(connector.levels, ShouldResemble, []driver.IsolationLevel{driver.IsolationLevel(sql.LevelDefault)})
+	})
+
+	Convey("配置后用户的事务使用指定的隔离级别，其他 store 不受影响", t, func() {
+		connector := &txOptionsConnector{}
+		db := sql.OpenDB(connector)
+		defer func() { _ = db.Close() }()
+		master := &BaseDB{DB: db}
+		s := &stableStore{master: master, slave: master, userTxIsolationLevel: int(sql.LevelReadCommitted)}
+		s.newStore()
+
+		begin(s.userStore.master)
+		begin(s.groupStore.master)
+		So(connector.levels, ShouldResemble, []driver.IsolationLevel{
+			driver.IsolationLevel(sql.LevelReadCommitted), driver.IsolationLevel(sql.LevelDefault)})
+	})
+}
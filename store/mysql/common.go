@@ -30,6 +30,9 @@ import (
 // QueryHandler is the interface that wraps the basic Query method.
 type QueryHandler func(query string, args ...interface{}) (*sql.Rows, error)
 
+// QueryRowHandler is the interface that wraps the basic QueryRow method.
+type QueryRowHandler func(query string, args ...interface{}) *sql.Row
+
 // BatchHandler 批量查询数据的回调函数
 type BatchHandler func(objects []interface{}) error
 
@@ -136,6 +139,8 @@ func serviceAliasFilter2Where(filter map[string]string) map[string]string {
 }
 
 // checkDataBaseAffectedRows 检查数据库处理返回的行数
+// 期望影响 1 行但实际没有影响任何行时，说明目标数据不存在，返回 NotFoundResource；
+// 其余不匹配的情况一般是并发修改等异常导致的，返回 AffectedRowsNotMatch
 func checkDataBaseAffectedRows(result sql.Result, counts ...int64) error {
 	n, err := result.RowsAffected()
 	if err != nil {
@@ -150,6 +155,9 @@ func checkDataBaseAffectedRows(result sql.Result, counts ...int64) error {
 	}
 
 	log.Errorf("[Store][Database] get rows affected result(%d) is not match expect(%+v)", n, counts)
+	if n == 0 && len(counts) == 1 && counts[0] == 1 {
+		return store.NewStatusError(store.NotFoundResource, "no rows affected, resource not found")
+	}
 	return store.NewStatusError(store.AffectedRowsNotMatch, "affected rows not matched")
 }
 
@@ -163,6 +171,15 @@ func timeToTimestamp(t time.Time) int64 {
 	return ts
 }
 
+// stringsToArgs 将字符串列表转换为 SQL 参数
+func stringsToArgs(values []string) []interface{} {
+	args := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		args = append(args, value)
+	}
+	return args
+}
+
 func toUnderscoreName(name string) string {
 	var buf bytes.Buffer
 	for i, token := range name {
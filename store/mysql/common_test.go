@@ -20,10 +20,39 @@ package sqldb
 import (
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/store"
 )
 
 func TestToUnderscoreName(t *testing.T) {
 	assert.Equal(t, "dst_service", toUnderscoreName("dstService"))
 	assert.Equal(t, "src_service", toUnderscoreName("srcService"))
 }
+
+func Test_checkDataBaseAffectedRows(t *testing.T) {
+	// 期望影响 1 行但没有影响任何行，说明数据不存在
+	err := checkDataBaseAffectedRows(sqlmock.NewResult(0, 0), 1)
+	assert.Equal(t, store.NotFoundResource, store.Code(err))
+
+	// 影响的行数超过预期，一般是并发修改导致的异常
+	err = checkDataBaseAffectedRows(sqlmock.NewResult(0, 2), 1)
+	assert.Equal(t, store.AffectedRowsNotMatch, store.Code(err))
+
+	// 允许多个期望值时，没有影响任何行也属于不匹配
+	err = checkDataBaseAffectedRows(sqlmock.NewResult(0, 0), 1, 2)
+	assert.Equal(t, store.AffectedRowsNotMatch, store.Code(err))
+
+	assert.NoError(t, checkDataBaseAffectedRows(sqlmock.NewResult(0, 1), 1))
+}
+
+func Test_checkServiceAffectedRows(t *testing.T) {
+	err := checkServiceAffectedRows(sqlmock.NewResult(0, 0), 1)
+	assert.Equal(t, store.NotFoundResource, store.Code(err))
+
+	err = checkServiceAffectedRows(sqlmock.NewResult(0, 2), 1)
+	assert.Equal(t, store.AffectedRowsNotMatch, store.Code(err))
+
+	assert.NoError(t, checkServiceAffectedRows(sqlmock.NewResult(0, 1), 1))
+}
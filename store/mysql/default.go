@@ -18,8 +18,11 @@
 package sqldb
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
@@ -34,6 +37,12 @@ const (
 	STORENAME = "defaultStore"
 	// DefaultConnMaxLifetime default maximum connection lifetime
 	DefaultConnMaxLifetime = 60 * 30 // 默认是30分钟
+	// DefaultQueryTimeout default timeout of a single query, in seconds
+	DefaultQueryTimeout = 60 // 默认是1分钟
+	// DefaultConnRetryInterval default interval of the first connection retry on startup, in seconds
+	DefaultConnRetryInterval = 1
+	// DefaultConnRetryMaxInterval default maximum interval between connection retries on startup, in seconds
+	DefaultConnRetryMaxInterval = 30
 	// emptyEnableTime 规则禁用时启用时间的默认值
 	emptyEnableTime = "STR_TO_DATE('1980-01-01 00:00:01', '%Y-%m-%d %H:%i:%s')"
 )
@@ -78,7 +87,39 @@ type stableStore struct {
 	master *BaseDB
 	// 备数据库，提供只读
 	slave *BaseDB
-	start bool
+	// 用户 token 的加解密，未配置时 token 明文存储
+	tokenCipher *tokenCipher
+	// 用户缓存不加载的敏感字段
+	cacheExcludePassword bool
+	cacheExcludeToken    bool
+	cacheProjection      bool
+	cacheWithGroups      bool
+	reuseDefaultStrategy bool
+	userChangeLog        bool
+	userChangeCompact    bool
+	userTxIsolationLevel int
+	userQueryConcurrency int
+	userQueryMaxOffset   uint32
+	userQueryGuard       store.UserQueryGuard
+	userPasswordAge      store.PasswordAgePolicy
+	userWriteGate        *txGate
+	nameCaseSensitive    bool
+	recursiveCTE         bool
+	readAfterWrite       *readAfterWrite
+	archiveInvalidUser   bool
+	// 删除主账户时级联删除子账户
+	userDeleteCascadeSubAccounts bool
+	// 添加用户前清理同名的已删除用户，关闭后只在写入冲突时清理
+	userCleanInvalidOnAdd bool
+	start                 bool
+}
+
+// SetTracer 设置用户存储层链路追踪使用的 Tracer，为 nil 时关闭链路追踪
+// 需要在 Initialize 之后、对外提供服务之前调用
+func (s *stableStore) SetTracer(tracer Tracer) {
+	if s.userStore != nil {
+		s.userStore.tracer = tracer
+	}
 }
 
 // Name 实现Name函数
@@ -96,6 +137,50 @@ func (s *stableStore) Initialize(conf *store.Config) error {
 	if err != nil {
 		return err
 	}
+	tokenCipher, err := parseTokenCipher(conf.Option["tokenEncryption"])
+	if err != nil {
+		return err
+	}
+	s.tokenCipher = tokenCipher
+	s.cacheExcludePassword, _ = conf.Option["cacheExcludePassword"].(bool)
+	s.cacheExcludeToken, _ = conf.Option["cacheExcludeToken"].(bool)
+	s.cacheProjection, _ = conf.Option["cacheProjection"].(bool)
+	s.cacheWithGroups, _ = conf.Option["cacheWithGroups"].(bool)
+	s.userChangeLog, _ = conf.Option["userChangeLog"].(bool)
+	s.userChangeCompact, _ = conf.Option["userChangeCompact"].(bool)
+	s.userTxIsolationLevel, _ = conf.Option["userTxIsolationLevel"].(int)
+	s.userQueryConcurrency, _ = conf.Option["userQueryConcurrency"].(int)
+	if maxOffset, _ := conf.Option["userQueryMaxOffset"].(int); maxOffset > 0 {
+		s.userQueryMaxOffset = uint32(maxOffset)
+	}
+	s.userQueryGuard.RequireFilter, _ = conf.Option["userQueryRequireFilter"].(bool)
+	if maxLimit, _ := conf.Option["userUnfilteredQueryMaxLimit"].(int); maxLimit > 0 {
+		s.userQueryGuard.MaxUnfilteredLimit = uint32(maxLimit)
+	}
+	s.userPasswordAge = store.ParsePasswordAgePolicy(conf.Option)
+	// 用户 store 同时执行的写事务个数上限，超过后排队等待 userWriteTxWait 毫秒，仍然没有名额时返回 store.Busy
+	userMaxWriteTx, _ := conf.Option["userMaxWriteTx"].(int)
+	userWriteTxWait, _ := conf.Option["userWriteTxWait"].(int)
+	s.userWriteGate = newTxGate(userMaxWriteTx, time.Duration(userWriteTxWait)*time.Millisecond)
+	// 写入用户、用户组后的一段时间（秒）内原本读只读库的请求改为读主库
+	readAfterWriteWindow, _ := conf.Option["readAfterWriteWindow"].(int)
+	s.readAfterWrite = newReadAfterWrite(time.Duration(readAfterWriteWindow) * time.Second)
+	s.archiveInvalidUser, _ = conf.Option["archiveInvalidUser"].(bool)
+	s.userDeleteCascadeSubAccounts, _ = conf.Option["userDeleteCascadeSubAccounts"].(bool)
+	// 默认在添加用户前清理同名的已删除用户，关闭后只在写入冲突时清理
+	s.userCleanInvalidOnAdd = true
+	if cleanOnAdd, ok := conf.Option["userCleanInvalidOnAdd"].(bool); ok {
+		s.userCleanInvalidOnAdd = cleanOnAdd
+	}
+	// 默认的建表脚本使用 utf8mb4_bin，用户名称区分大小写
+	s.nameCaseSensitive = true
+	if caseSensitive, ok := conf.Option["userNameCaseSensitive"].(bool); ok {
+		s.nameCaseSensitive = caseSensitive
+	}
+	if s.reuseDefaultStrategy, err = store.ParseDefaultStrategyConflict(
+		conf.Option["defaultStrategyConflict"]); err != nil {
+		return err
+	}
 	master, err := NewBaseDB(masterConfig, plugin.GetParsePassword())
 	if err != nil {
 		return err
@@ -108,6 +193,8 @@ func (s *stableStore) Initialize(conf *store.Config) error {
 		if err != nil {
 			return err
 		}
+		// 只读库无法连接时降级到主库读取
+		slave.fallback = master
 		s.slave = slave
 	}
 	// 如果slave为空，意味着slaveConfig为空，用master数据库替代
@@ -117,6 +204,19 @@ func (s *stableStore) Initialize(conf *store.Config) error {
 
 	log.Infof("[Store][database] connect the database successfully")
 
+	if err := schemaCheck(s.master); err != nil {
+		log.Errorf("[Store][database] check database schema err: %s", err.Error())
+		return err
+	}
+	if s.tokenCipher != nil {
+		if err := schemaVersionCheck(s.master, "tokenEncryption", tokenEncryptionSchemaVersion); err != nil {
+			log.Errorf("[Store][database] check database schema version err: %s", err.Error())
+			return err
+		}
+	}
+	userNameCollationCheck(s.master, s.nameCaseSensitive)
+	s.recursiveCTE = supportRecursiveCTE(s.master)
+
 	s.start = true
 	s.newStore()
 	return nil
@@ -186,6 +286,26 @@ func parseStoreConfig(opts interface{}) (*dbConfig, error) {
 	if isolationLevel, _ := obj["txIsolationLevel"].(int); isolationLevel > 0 {
 		c.txIsolationLevel = isolationLevel
 	}
+	// 单次查询的超时时间，配置为负数时表示不设置超时
+	c.queryTimeout = DefaultQueryTimeout
+	if queryTimeout, ok := obj["queryTimeout"].(int); ok && queryTimeout != 0 {
+		c.queryTimeout = queryTimeout
+	}
+	// 启动时数据库未就绪的重试，默认不重试
+	if maxWait, _ := obj["connRetryMaxWait"].(int); maxWait > 0 {
+		c.connRetryMaxWait = time.Second * time.Duration(maxWait)
+	}
+	c.connRetryInterval = time.Second * DefaultConnRetryInterval
+	if interval, _ := obj["connRetryInterval"].(int); interval > 0 {
+		c.connRetryInterval = time.Second * time.Duration(interval)
+	}
+	c.connRetryMaxInterval = time.Second * DefaultConnRetryMaxInterval
+	if maxInterval, _ := obj["connRetryMaxInterval"].(int); maxInterval > 0 {
+		c.connRetryMaxInterval = time.Second * time.Duration(maxInterval)
+	}
+	if c.connRetryMaxInterval < c.connRetryInterval {
+		c.connRetryMaxInterval = c.connRetryInterval
+	}
 	return c, nil
 }
 
@@ -270,12 +390,31 @@ func (s *stableStore) newStore() {
 
 	s.adminStore = newAdminStore(s.master)
 	s.toolStore = &toolStore{db: s.master}
-	s.userStore = &userStore{master: s.master, slave: s.slave}
-	s.groupStore = &groupStore{master: s.master, slave: s.slave}
+	s.userStore = &userStore{master: s.userMaster(), slave: s.slave, tokenCipher: s.tokenCipher,
+		cacheExcludePassword: s.cacheExcludePassword, cacheExcludeToken: s.cacheExcludeToken,
+		cacheProjection: s.cacheProjection, cacheWithGroups: s.cacheWithGroups,
+		reuseDefaultStrategy: s.reuseDefaultStrategy, changeLog: s.userChangeLog, changeCompact: s.userChangeCompact,
+		queryConcurrency: s.userQueryConcurrency, consistency: s.readAfterWrite, archiveInvalidUser: s.archiveInvalidUser,
+		queryMaxOffset: s.userQueryMaxOffset, queryGuard: s.userQueryGuard, recursiveCTE: s.recursiveCTE,
+		nameCaseInsensitive: !s.nameCaseSensitive, passwordAge: s.userPasswordAge, writeGate: s.userWriteGate,
+		cascadeSubAccounts: s.userDeleteCascadeSubAccounts, cleanInvalidOnConflict: !s.userCleanInvalidOnAdd}
+	s.groupStore = &groupStore{master: s.master, slave: s.slave, reuseDefaultStrategy: s.reuseDefaultStrategy,
+		consistency: s.readAfterWrite}
 	s.strategyStore = &strategyStore{master: s.master, slave: s.slave}
 	s.grayStore = &grayStore{master: s.master, slave: s.slave}
 }
 
+// userMaster 用户 store 使用的主库，配置了 userTxIsolationLevel 时用户相关的事务使用该隔离级别，
+// 否则与其他 store 一致，使用 txIsolationLevel 或者数据库的默认隔离级别
+func (s *stableStore) userMaster() *BaseDB {
+	if s.userTxIsolationLevel <= 0 {
+		return s.master
+	}
+	level := sql.IsolationLevel(s.userTxIsolationLevel)
+	log.Infof("[Store][database] user store use isolation level: %s", level.String())
+	return s.master.WithIsolationLevel(level)
+}
+
 func buildEtimeStr(enable bool) string {
 	etimeStr := "sysdate()"
 	if !enable {
@@ -283,3 +422,13 @@ func buildEtimeStr(enable bool) string {
 	}
 	return etimeStr
 }
+
+// WithTx 在同一个事务中执行 fn，fn 返回 error 时回滚事务
+func (s *stableStore) WithTx(ctx context.Context, fn func(store.TxStore) error) error {
+	tx, err := s.StartTx()
+	if err != nil {
+		log.Errorf("[Store][database] database begin err: %s", err.Error())
+		return store.Error(err)
+	}
+	return store.RunWithTx(ctx, tx, store.NewTxStore(tx, s.userStore), fn)
+}
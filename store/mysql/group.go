@@ -54,10 +54,21 @@ var (
 type groupStore struct {
 	master *BaseDB
 	slave  *BaseDB
+	// reuseDefaultStrategy 同名的默认策略属于其他用户组时复用该策略，否则返回 DataConflictErr
+	reuseDefaultStrategy bool
+	// consistency 写入后的一段时间内读主库，与 userStore 共享
+	consistency *readAfterWrite
+}
+
+// readDB 原本读只读库的请求使用的数据库
+func (u *groupStore) readDB() *BaseDB {
+	return u.consistency.route(u.master, u.slave)
 }
 
 // AddGroup 创建一个用户组
 func (u *groupStore) AddGroup(group *model.UserGroupDetail) error {
+	defer u.consistency.markWrite()
+
 	if group.ID == "" || group.Name == "" || group.Token == "" {
 		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
 			"add usergroup missing some params, groupId is %s, name is %s", group.ID, group.Name))
@@ -106,7 +117,8 @@ func (u *groupStore) addGroup(group *model.UserGroupDetail) error {
 		return err
 	}
 
-	if err := createDefaultStrategy(tx, model.PrincipalGroup, group.ID, group.Name, group.Owner); err != nil {
+	if err := createDefaultStrategy(tx, model.PrincipalGroup, group.ID, group.Name, group.Owner,
+		u.reuseDefaultStrategy); err != nil {
 		log.Errorf("[Store][Group] add usergroup default strategy err: %s", err.Error())
 		return err
 	}
@@ -120,6 +132,8 @@ func (u *groupStore) addGroup(group *model.UserGroupDetail) error {
 
 // UpdateGroup 更新用户组
 func (u *groupStore) UpdateGroup(group *model.ModifyUserGroup) error {
+	defer u.consistency.markWrite()
+
 	if group.ID == "" {
 		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
 			"update usergroup missing some params, groupId is %s", group.ID))
@@ -182,6 +196,8 @@ func (u *groupStore) updateGroup(group *model.ModifyUserGroup) error {
 
 // DeleteGroup 删除用户组
 func (u *groupStore) DeleteGroup(group *model.UserGroupDetail) error {
+	defer u.consistency.markWrite()
+
 	if group.ID == "" || group.Name == "" {
 		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
 			"delete usergroup missing some params, groupId is %s", group.ID))
@@ -202,9 +218,8 @@ func (u *groupStore) deleteUserGroup(group *model.UserGroupDetail) error {
 
 	defer func() { _ = tx.Rollback() }()
 
-	if _, err = tx.Exec("DELETE FROM user_group_relation WHERE group_id = ?", []interface{}{
-		group.ID,
-	}...); err != nil {
+	if _, err = tx.Exec("UPDATE user_group_relation SET flag = 1, mtime = sysdate() WHERE group_id = ? AND flag = 0",
+		[]interface{}{group.ID}...); err != nil {
 		log.Errorf("[Store][Group] clean usergroup relation err: %s", err.Error())
 		return err
 	}
@@ -385,10 +400,10 @@ func (u *groupStore) listSimpleGroups(filters map[string]string, offset uint32,
 func (u *groupStore) listGroupByUser(filters map[string]string, offset uint32, limit uint32) (uint32,
 	[]*model.UserGroup, error) {
 	countSql := "SELECT COUNT(*) FROM user_group_relation ul LEFT JOIN user_group ug ON " +
-		" ul.group_id = ug.id WHERE ug.flag = 0 "
+		" ul.group_id = ug.id WHERE ug.flag = 0 AND ul.flag = 0 "
 	getSql := "SELECT ug.id, ug.name, ug.owner, ug.comment, ug.token, ug.token_enable, UNIX_TIMESTAMP(ug.ctime), " +
 		" UNIX_TIMESTAMP(ug.mtime), ug.flag " +
-		" FROM user_group_relation ul LEFT JOIN user_group ug ON ul.group_id = ug.id WHERE ug.flag = 0 "
+		" FROM user_group_relation ul LEFT JOIN user_group ug ON ul.group_id = ug.id WHERE ug.flag = 0 AND ul.flag = 0 "
 
 	args := make([]interface{}, 0)
 
@@ -456,7 +471,7 @@ func (u *groupStore) collectGroupsFromRows(handler QueryHandler, querySql string
 
 // GetGroupsForCache .
 func (u *groupStore) GetGroupsForCache(mtime time.Time, firstUpdate bool) ([]*model.UserGroupDetail, error) {
-	tx, err := u.slave.Begin()
+	tx, err := u.readDB().Begin()
 	if err != nil {
 		return nil, store.Error(err)
 	}
@@ -500,6 +515,136 @@ func (u *groupStore) GetGroupsForCache(mtime time.Time, firstUpdate bool) ([]*mo
 	return ret, nil
 }
 
+// GetUserGroupRelationsForCache 查询用户-用户组关联关系的变化，主要用于Cache更新
+// 首次加载时只返回有效的关联关系，增量更新时会返回已经被移除的关联关系，用于 cache 剔除数据
+func (u *groupStore) GetUserGroupRelationsForCache(mtime time.Time,
+	firstUpdate bool) ([]*model.UserGroupLink, error) {
+	args := make([]interface{}, 0)
+	querySql := "SELECT user_id, group_id, flag, UNIX_TIMESTAMP(mtime) FROM user_group_relation "
+	if firstUpdate {
+		querySql += " WHERE flag = 0"
+	} else {
+		querySql += " WHERE mtime >= FROM_UNIXTIME(?)"
+		args = append(args, timeToTimestamp(mtime))
+	}
+
+	links, err := collectGroupLinks(u.readDB().Query, querySql, args...)
+	if err != nil {
+		log.Error("[Store][Group] list user group relations for cache", zap.Error(err))
+		return nil, store.Error(err)
+	}
+	return links, nil
+}
+
+// orphanedGroupRelationSql 查询用户已经不存在或者已经被删除的有效关联关系
+const orphanedGroupRelationSql = `
+	SELECT ugr.user_id, ugr.group_id, ugr.flag, UNIX_TIMESTAMP(ugr.mtime)
+	FROM user_group_relation ugr
+		LEFT JOIN user u ON u.id = ugr.user_id AND u.flag = 0
+	WHERE ugr.flag = 0 AND u.id IS NULL
+	`
+
+// FindOrphanedGroupRelations 查询用户已经不存在的用户-用户组关联关系
+func (u *groupStore) FindOrphanedGroupRelations() ([]*model.UserGroupLink, error) {
+	links, err := collectGroupLinks(u.master.Query, orphanedGroupRelationSql)
+	if err != nil {
+		log.Error("[Store][Group] find orphaned user group relations", zap.Error(err))
+		return nil, store.Error(err)
+	}
+	return links, nil
+}
+
+// RepairOrphanedGroupRelations 软删除用户已经不存在的用户-用户组关联关系，同时更新用户组的 mtime 触发缓存刷新
+func (u *groupStore) RepairOrphanedGroupRelations() ([]*model.UserGroupLink, error) {
+	defer u.consistency.markWrite()
+
+	var links []*model.UserGroupLink
+	err := RetryTransaction("repairOrphanedGroupRelations", func() error {
+		var err error
+		links, err = u.repairOrphanedGroupRelations()
+		return err
+	})
+	if err != nil {
+		return nil, store.Error(err)
+	}
+	return links, nil
+}
+
+func (u *groupStore) repairOrphanedGroupRelations() ([]*model.UserGroupLink, error) {
+	tx, err := u.master.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	links, err := collectGroupLinks(tx.Query, orphanedGroupRelationSql+" FOR UPDATE")
+	if err != nil {
+		log.Error("[Store][Group] find orphaned user group relations", zap.Error(err))
+		return nil, err
+	}
+	if len(links) == 0 {
+		return links, nil
+	}
+
+	groupIds := make([]interface{}, 0, len(links))
+	seen := make(map[string]struct{}, len(links))
+	for i := range links {
+		if _, err := tx.Exec("UPDATE user_group_relation SET flag = 1, mtime = sysdate() "+
+			"WHERE group_id = ? AND user_id = ? AND flag = 0", links[i].GroupID, links[i].UserID); err != nil {
+			log.Error("[Store][Group] remove orphaned user group relation", zap.String("group", links[i].GroupID),
+				zap.String("user", links[i].UserID), zap.Error(err))
+			return nil, err
+		}
+		if _, ok := seen[links[i].GroupID]; !ok {
+			seen[links[i].GroupID] = struct{}{}
+			groupIds = append(groupIds, links[i].GroupID)
+		}
+	}
+	if _, err := tx.Exec("UPDATE user_group SET mtime = sysdate() WHERE id IN ("+
+		placeholders(len(groupIds))+")", groupIds...); err != nil {
+		log.Error("[Store][Group] update usergroup mtime", zap.Error(err))
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][Group] repair orphaned user group relations tx commit", zap.Error(err))
+		return nil, err
+	}
+	for i := range links {
+		links[i].Valid = false
+	}
+	log.Info("[Store][Group] repair orphaned user group relations", zap.Int("count", len(links)))
+	return links, nil
+}
+
+// collectGroupLinks 读取 user_id, group_id, flag, mtime 组成的关联关系
+func collectGroupLinks(handler QueryHandler, querySql string, args ...interface{}) ([]*model.UserGroupLink, error) {
+	rows, err := handler(querySql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]*model.UserGroupLink, 0)
+	for rows.Next() {
+		var (
+			flag       int
+			modifyTime int64
+			link       = &model.UserGroupLink{}
+		)
+		if err := rows.Scan(&link.UserID, &link.GroupID, &flag, &modifyTime); err != nil {
+			return nil, err
+		}
+		link.Valid = flag == 0
+		link.ModifyTime = time.Unix(modifyTime, 0)
+		ret = append(ret, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 func (u *groupStore) addGroupRelation(tx *BaseTx, groupId string, userIds []string) error {
 	if groupId == "" {
 		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
@@ -512,16 +657,12 @@ func (u *groupStore) addGroupRelation(tx *BaseTx, groupId string, userIds []stri
 
 	for i := range userIds {
 		uid := userIds[i]
-		addSql := "INSERT INTO user_group_relation (group_id, user_id) VALUE (?,?)"
+		// 已经被移除的关联关系重新生效
+		addSql := "INSERT INTO user_group_relation (group_id, user_id, flag) VALUE (?,?,0) " +
+			" ON DUPLICATE KEY UPDATE mtime = IF(flag = 0, mtime, sysdate()), flag = 0"
 		args := []interface{}{groupId, uid}
-		_, err := tx.Exec(addSql, args...)
-		if err != nil {
-			err = store.Error(err)
-			// 之前的用户已经存在，直接忽略
-			if store.Code(err) == store.DuplicateEntryErr {
-				continue
-			}
-			return err
+		if _, err := tx.Exec(addSql, args...); err != nil {
+			return store.Error(err)
 		}
 	}
 	return nil
@@ -539,7 +680,7 @@ func (u *groupStore) removeGroupRelation(tx *BaseTx, groupId string, userIds []s
 
 	for i := range userIds {
 		uid := userIds[i]
-		addSql := "DELETE FROM user_group_relation WHERE group_id = ? AND user_id = ?"
+		addSql := "UPDATE user_group_relation SET flag = 1, mtime = sysdate() WHERE group_id = ? AND user_id = ? AND flag = 0"
 		args := []interface{}{groupId, uid}
 		if _, err := tx.Exec(addSql, args...); err != nil {
 			return err
@@ -554,8 +695,8 @@ func (u *groupStore) getGroupLinkUserIds(groupId string) (map[string]struct{}, e
 	ids := make(map[string]struct{})
 
 	// 拉取该分组下的所有 user
-	idRows, err := u.slave.Query("SELECT user_id FROM user u JOIN user_group_relation ug ON "+
-		" u.id = ug.user_id WHERE ug.group_id = ?", groupId)
+	idRows, err := u.readDB().Query("SELECT user_id FROM user u JOIN user_group_relation ug ON "+
+		" u.id = ug.user_id WHERE ug.group_id = ? AND ug.flag = 0", groupId)
 	if err != nil {
 		return nil, err
 	}
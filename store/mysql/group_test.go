@@ -0,0 +1,156 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/common/model"
+)
+
+func newTestGroupStore(t *testing.T) (*groupStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	baseDB := &BaseDB{DB: db}
+	return &groupStore{master: baseDB, slave: baseDB}, mock
+}
+
+func Test_groupStore_GetUserGroupRelationsForCache(t *testing.T) {
+	relationColumns := []string{"user_id", "group_id", "flag", "mtime"}
+
+	t.Run("首次加载只返回有效的关联关系", func(t *testing.T) {
+		gs, mock := newTestGroupStore(t)
+		mock.ExpectQuery(`SELECT user_id, group_id, flag, UNIX_TIMESTAMP\(mtime\) FROM user_group_relation +WHERE flag = 0`).
+			WillReturnRows(sqlmock.NewRows(relationColumns).
+				AddRow("u1", "g1", 0, 1700000000).
+				AddRow("u2", "g1", 0, 1700000000))
+
+		links, err := gs.GetUserGroupRelationsForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.UserGroupLink{
+			{UserID: "u1", GroupID: "g1", Valid: true, ModifyTime: time.Unix(1700000000, 0)},
+			{UserID: "u2", GroupID: "g1", Valid: true, ModifyTime: time.Unix(1700000000, 0)},
+		}, links)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("增量更新返回新增以及移除的关联关系", func(t *testing.T) {
+		gs, mock := newTestGroupStore(t)
+		mock.ExpectQuery(`FROM user_group_relation +WHERE mtime >= FROM_UNIXTIME\(\?\)`).
+			WithArgs(int64(1700000000)).
+			WillReturnRows(sqlmock.NewRows(relationColumns).
+				AddRow("u3", "g1", 0, 1700000010).
+				AddRow("u1", "g1", 1, 1700000020))
+
+		links, err := gs.GetUserGroupRelationsForCache(time.Unix(1700000000, 0), false)
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.UserGroupLink{
+			{UserID: "u3", GroupID: "g1", Valid: true, ModifyTime: time.Unix(1700000010, 0)},
+			{UserID: "u1", GroupID: "g1", Valid: false, ModifyTime: time.Unix(1700000020, 0)},
+		}, links)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_groupStore_GroupRelationSoftDelete(t *testing.T) {
+	gs, mock := newTestGroupStore(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO user_group_relation \(group_id, user_id, flag\) VALUE \(\?,\?,0\) +`+
+		`ON DUPLICATE KEY UPDATE mtime = IF\(flag = 0, mtime, sysdate\(\)\), flag = 0`).
+		WithArgs("g1", "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_group_relation SET flag = 1, mtime = sysdate\(\) `+
+		`WHERE group_id = \? AND user_id = \? AND flag = 0`).
+		WithArgs("g1", "u2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	tx, err := gs.master.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, gs.addGroupRelation(tx, "g1", []string{"u1"}))
+	assert.NoError(t, gs.removeGroupRelation(tx, "g1", []string{"u2"}))
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_groupStore_OrphanedGroupRelations(t *testing.T) {
+	relationColumns := []string{"user_id", "group_id", "flag", "mtime"}
+
+	t.Run("查询用户已经不存在的关联关系", func(t *testing.T) {
+		gs, mock := newTestGroupStore(t)
+		mock.ExpectQuery(`SELECT ugr.user_id, ugr.group_id, ugr.flag, UNIX_TIMESTAMP\(ugr.mtime\) ` +
+			`FROM user_group_relation ugr +LEFT JOIN user u ON u.id = ugr.user_id AND u.flag = 0 ` +
+			`+WHERE ugr.flag = 0 AND u.id IS NULL`).
+			WillReturnRows(sqlmock.NewRows(relationColumns).AddRow("u1", "g1", 0, 1700000000))
+
+		links, err := gs.FindOrphanedGroupRelations()
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.UserGroupLink{
+			{UserID: "u1", GroupID: "g1", Valid: true, ModifyTime: time.Unix(1700000000, 0)},
+		}, links)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("软删除孤立的关联关系并刷新用户组", func(t *testing.T) {
+		gs, mock := newTestGroupStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`WHERE ugr.flag = 0 AND u.id IS NULL +FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows(relationColumns).
+				AddRow("u1", "g1", 0, 1700000000).
+				AddRow("u1", "g2", 0, 1700000000).
+				AddRow("u2", "g1", 0, 1700000000))
+		for _, link := range [][]string{{"g1", "u1"}, {"g2", "u1"}, {"g1", "u2"}} {
+			mock.ExpectExec(`UPDATE user_group_relation SET flag = 1, mtime = sysdate\(\) `+
+				`WHERE group_id = \? AND user_id = \? AND flag = 0`).
+				WithArgs(link[0], link[1]).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec(`UPDATE user_group SET mtime = sysdate\(\) WHERE id IN \(\?,\?\)`).
+			WithArgs("g1", "g2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		links, err := gs.RepairOrphanedGroupRelations()
+		assert.NoError(t, err)
+		assert.Len(t, links, 3)
+		for i := range links {
+			assert.False(t, links[i].Valid)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("没有孤立的关联关系", func(t *testing.T) {
+		gs, mock := newTestGroupStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`WHERE ugr.flag = 0 AND u.id IS NULL +FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows(relationColumns))
+		mock.ExpectRollback()
+
+		links, err := gs.RepairOrphanedGroupRelations()
+		assert.NoError(t, err)
+		assert.Empty(t, links)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
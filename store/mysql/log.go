@@ -18,6 +18,11 @@
 package sqldb
 
 import (
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
 	commonlog "github.com/polarismesh/polaris/common/log"
 )
 
@@ -25,3 +30,71 @@ var (
 	log      = commonlog.GetScopeOrDefaultByName(commonlog.StoreLoggerName)
 	cacheLog = commonlog.GetScopeOrDefaultByName(commonlog.CacheLoggerName)
 )
+
+var (
+	// userOpLogLevels 用户存储各个操作的日志级别覆盖配置，key 为操作名称（如 DeleteUser），value 为日志级别
+	// 采用写时复制，读路径不加锁
+	userOpLogLevels atomic.Value
+	// userOpLogLevelsLock 串行化 userOpLogLevels 的修改
+	userOpLogLevelsLock sync.Mutex
+)
+
+func init() {
+	userOpLogLevels.Store(map[string]commonlog.Level{})
+}
+
+// SetUserOpLogLevel 运行时调整用户存储某个操作的日志级别，用于排查问题时只放大指定操作的日志，
+// 未配置的操作默认以 debug 级别输出
+func SetUserOpLogLevel(op string, level commonlog.Level) {
+	userOpLogLevelsLock.Lock()
+	defer userOpLogLevelsLock.Unlock()
+
+	old := userOpLogLevels.Load().(map[string]commonlog.Level)
+	levels := make(map[string]commonlog.Level, len(old)+1)
+	for k, v := range old {
+		levels[k] = v
+	}
+	levels[op] = level
+	userOpLogLevels.Store(levels)
+}
+
+// ResetUserOpLogLevel 清除用户存储某个操作的日志级别覆盖配置，恢复为 debug 级别
+func ResetUserOpLogLevel(op string) {
+	userOpLogLevelsLock.Lock()
+	defer userOpLogLevelsLock.Unlock()
+
+	old := userOpLogLevels.Load().(map[string]commonlog.Level)
+	if _, ok := old[op]; !ok {
+		return
+	}
+	levels := make(map[string]commonlog.Level, len(old))
+	for k, v := range old {
+		if k != op {
+			levels[k] = v
+		}
+	}
+	userOpLogLevels.Store(levels)
+}
+
+// getUserOpLogLevel 获取用户存储某个操作的日志级别
+func getUserOpLogLevel(op string) commonlog.Level {
+	if level, ok := userOpLogLevels.Load().(map[string]commonlog.Level)[op]; ok {
+		return level
+	}
+	return commonlog.DebugLevel
+}
+
+// logUserOp 按照操作对应的日志级别输出用户存储的操作日志
+func logUserOp(op string, msg string, fields ...zap.Field) {
+	fields = append(fields, zap.String("op", op))
+	switch getUserOpLogLevel(op) {
+	case commonlog.DebugLevel:
+		log.Debug(msg, fields...)
+	case commonlog.InfoLevel:
+		log.Info(msg, fields...)
+	case commonlog.WarnLevel:
+		log.Warn(msg, fields...)
+	case commonlog.ErrorLevel:
+		log.Error(msg, fields...)
+	}
+}
@@ -0,0 +1,55 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// readAfterWrite 写入后的一段时间内将原本读只读库的请求路由到主库，避免只读库的复制延迟导致读不到刚写入的数据
+// 写入时间只在当前节点内记录，因此只保证写入请求所在节点上的读己之写
+type readAfterWrite struct {
+	// window 写入后读请求使用主库的时长，小于等于 0 时不生效
+	window time.Duration
+	// lastWrite 最近一次写入的时间，unix 纳秒
+	lastWrite atomic.Int64
+}
+
+func newReadAfterWrite(window time.Duration) *readAfterWrite {
+	return &readAfterWrite{window: window}
+}
+
+// markWrite 记录一次写入，在写入完成后调用
+func (r *readAfterWrite) markWrite() {
+	if r == nil || r.window <= 0 {
+		return
+	}
+	r.lastWrite.Store(time.Now().UnixNano())
+}
+
+// route 选择读请求使用的数据库，最近一次写入在 window 内时使用主库，否则使用只读库
+func (r *readAfterWrite) route(master, slave *BaseDB) *BaseDB {
+	if r == nil || r.window <= 0 || master == slave {
+		return slave
+	}
+	if time.Since(time.Unix(0, r.lastWrite.Load())) < r.window {
+		return master
+	}
+	return slave
+}
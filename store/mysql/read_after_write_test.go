@@ -0,0 +1,172 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/store"
+)
+
+func Test_userStore_ReadAfterWrite(t *testing.T) {
+	newStore := func(t *testing.T, window time.Duration) (*userStore, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+		masterDB, master, err := sqlmock.New()
+		assert.NoError(t, err)
+		slaveDB, slave, err := sqlmock.New()
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			_ = masterDB.Close()
+			_ = slaveDB.Close()
+		})
+		master.MatchExpectationsInOrder(false)
+		slave.MatchExpectationsInOrder(false)
+		return &userStore{master: &BaseDB{DB: masterDB}, slave: &BaseDB{DB: slaveDB}, queryConcurrency: 2,
+			consistency: newReadAfterWrite(window)}, master, slave
+	}
+	ids := newTestUserIds(batchQuerySize + 1)
+	// 只读库存在复制延迟，还没有同步到刚写入的用户
+	expectLaggingSlave := func(slave sqlmock.Sqlmock) {
+		for i := 0; i < 2; i++ {
+			slave.ExpectQuery(`FROM user u +WHERE u.flag = 0 AND u.id IN \(`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}
+	}
+	expectWrite := func(master sqlmock.Sqlmock) {
+		master.ExpectBegin()
+		master.ExpectExec(`UPDATE user SET token_enable = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+		master.ExpectCommit()
+	}
+
+	t.Run("写入后读取主库", func(t *testing.T) {
+		us, master, slave := newStore(t, time.Minute)
+		expectLaggingSlave(slave)
+		users, err := us.GetUserByIdsWithContext(context.Background(), ids)
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+
+		expectWrite(master)
+		_, err = us.SetUsersTokenEnable(ids[:1], false, "admin")
+		assert.NoError(t, err)
+		expectUserIdsChunk(master, ids[:batchQuerySize])
+		expectUserIdsChunk(master, ids[batchQuerySize:])
+		users, err = us.GetUserByIdsWithContext(context.Background(), ids)
+		assert.NoError(t, err)
+		assert.Equal(t, len(ids), len(users))
+
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+
+	t.Run("超过时间窗口后恢复读取只读库", func(t *testing.T) {
+		us, master, slave := newStore(t, 50*time.Millisecond)
+		expectWrite(master)
+		_, err := us.SetUsersTokenEnable(ids[:1], false, "admin")
+		assert.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		expectLaggingSlave(slave)
+		_, err = us.GetUserByIdsWithContext(context.Background(), ids)
+		assert.NoError(t, err)
+
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+
+	t.Run("未开启时始终读取只读库", func(t *testing.T) {
+		us, master, slave := newStore(t, 0)
+		expectWrite(master)
+		_, err := us.SetUsersTokenEnable(ids[:1], false, "admin")
+		assert.NoError(t, err)
+
+		expectLaggingSlave(slave)
+		_, err = us.GetUserByIdsWithContext(context.Background(), ids)
+		assert.NoError(t, err)
+
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_QueryUsersConsistency(t *testing.T) {
+	newStore := func(t *testing.T) (*userStore, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+		masterDB, master, err := sqlmock.New()
+		assert.NoError(t, err)
+		slaveDB, slave, err := sqlmock.New()
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			_ = masterDB.Close()
+			_ = slaveDB.Close()
+		})
+		return &userStore{master: &BaseDB{DB: masterDB}, slave: &BaseDB{DB: slaveDB},
+			consistency: newReadAfterWrite(time.Minute)}, master, slave
+	}
+	expectList := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM user`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+
+	t.Run("默认读主库", func(t *testing.T) {
+		us, master, slave := newStore(t)
+		expectList(master)
+		_, _, err := us.GetUsers(map[string]string{"name": "u1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+
+	t.Run("strong读主库", func(t *testing.T) {
+		us, master, slave := newStore(t)
+		expectList(master)
+		_, _, err := us.GetUsers(map[string]string{"name": "u1", "consistency": "strong"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+
+	t.Run("eventual读只读库", func(t *testing.T) {
+		us, master, slave := newStore(t)
+		expectList(slave)
+		_, _, err := us.GetUsers(map[string]string{"name": "u1", "consistency": "eventual"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+
+	t.Run("eventual在写入后的时间窗口内读主库", func(t *testing.T) {
+		us, master, slave := newStore(t)
+		us.consistency.markWrite()
+		expectList(master)
+		_, _, err := us.QueryUsers(&store.UserQuery{Name: "u1", Consistency: store.ReadEventual}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+
+	t.Run("不支持的取值", func(t *testing.T) {
+		us, master, slave := newStore(t)
+		_, _, err := us.GetUsers(map[string]string{"consistency": "weak"}, 0, 10)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		assert.NoError(t, master.ExpectationsWereMet())
+		assert.NoError(t, slave.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,269 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polarismesh/polaris/store"
+)
+
+// requiredSchema 用户、用户组以及鉴权策略相关 SQL 所依赖的表以及字段
+var requiredSchema = map[string][]string{
+	"user": {"id", "name", "name_lower", "password", "owner", "source", "mobile", "email", "token", "token_enable",
+		"user_type", "comment", "flag", "ctime", "mtime", "last_login_time", "password_set_time",
+		"must_change_password", "token_rotated_time", "deleted_at", "created_by", "modified_by"},
+	"user_token":             {"id", "user_id", "name", "token", "scope", "expire_time", "flag", "ctime", "mtime"},
+	"user_group":             {"id", "name", "owner", "token", "comment", "token_enable", "flag", "ctime", "mtime"},
+	"user_group_relation":    {"user_id", "group_id", "flag", "ctime", "mtime"},
+	"auth_strategy":          {"id", "name", "action", "owner", "comment", "default", "revision", "flag", "ctime", "mtime"},
+	"auth_principal":         {"strategy_id", "principal_id", "principal_role"},
+	"auth_strategy_resource": {"strategy_id", "res_type", "res_id", "ctime", "mtime"},
+	"schema_migrations":      {"version", "description", "applied_at"},
+}
+
+// tokenEncryptionSchemaVersion 开启 token 加密所需的最低 schema 版本，user 以及该版本引入的 user_token 表
+// 中的 token 均会加密落库
+const tokenEncryptionSchemaVersion = "v1.19.0"
+
+// SchemaMigration 已经执行的 schema 变更
+type SchemaMigration struct {
+	// Version 变更后的 schema 版本，例如 v1.19.0
+	Version     string
+	Description string
+	AppliedAt   time.Time
+}
+
+// SchemaCheck 校验当前连接的数据库是否包含 store 所依赖的表以及字段，
+// 避免 schema 与代码不一致时在查询阶段才出现难以排查的 Scan 错误
+func (s *stableStore) SchemaCheck() error {
+	return schemaCheck(s.master)
+}
+
+func schemaCheck(db *BaseDB) error {
+	querySql := "SELECT TABLE_NAME, COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE()"
+	rows, err := db.Query(querySql)
+	if err != nil {
+		log.Errorf("[Store][database] query information_schema err: %s", err.Error())
+		return store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	existColumns := make(map[string]map[string]struct{})
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return store.Error(err)
+		}
+		if _, ok := existColumns[table]; !ok {
+			existColumns[table] = make(map[string]struct{})
+		}
+		existColumns[table][column] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return store.Error(err)
+	}
+
+	missingTables := make([]string, 0)
+	missingColumns := make([]string, 0)
+	for table, columns := range requiredSchema {
+		exist, ok := existColumns[table]
+		if !ok {
+			missingTables = append(missingTables, table)
+			continue
+		}
+		for _, column := range columns {
+			if _, ok := exist[column]; !ok {
+				missingColumns = append(missingColumns, table+"."+column)
+			}
+		}
+	}
+	if len(missingTables) == 0 && len(missingColumns) == 0 {
+		return nil
+	}
+
+	sort.Strings(missingTables)
+	sort.Strings(missingColumns)
+	return fmt.Errorf("database schema does not match, missing tables: [%s], missing columns: [%s], "+
+		"please apply the sql scripts under store/mysql/scripts", strings.Join(missingTables, ", "),
+		strings.Join(missingColumns, ", "))
+}
+
+// GetAppliedMigrations 查询已经执行的 schema 变更，按照执行时间排序
+func (s *stableStore) GetAppliedMigrations() ([]*SchemaMigration, error) {
+	return getAppliedMigrations(s.master)
+}
+
+// CurrentSchemaVersion 当前的 schema 版本，即已经执行的 schema 变更中的最大版本，没有执行任何变更时返回空字符串
+func (s *stableStore) CurrentSchemaVersion() (string, error) {
+	return currentSchemaVersion(s.master)
+}
+
+func getAppliedMigrations(db *BaseDB) ([]*SchemaMigration, error) {
+	querySql := "SELECT version, description, UNIX_TIMESTAMP(applied_at) FROM schema_migrations " +
+		" ORDER BY applied_at, version"
+	rows, err := db.Query(querySql)
+	if err != nil {
+		log.Errorf("[Store][database] query schema migrations err: %s", err.Error())
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	migrations := make([]*SchemaMigration, 0)
+	for rows.Next() {
+		var (
+			migration SchemaMigration
+			appliedAt int64
+		)
+		if err := rows.Scan(&migration.Version, &migration.Description, &appliedAt); err != nil {
+			return nil, store.Error(err)
+		}
+		migration.AppliedAt = time.Unix(appliedAt, 0)
+		migrations = append(migrations, &migration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return migrations, nil
+}
+
+func currentSchemaVersion(db *BaseDB) (string, error) {
+	migrations, err := getAppliedMigrations(db)
+	if err != nil {
+		return "", err
+	}
+	current := ""
+	for _, migration := range migrations {
+		if current == "" || compareSchemaVersion(migration.Version, current) > 0 {
+			current = migration.Version
+		}
+	}
+	return current, nil
+}
+
+// schemaVersionCheck 校验当前的 schema 版本不低于 feature 所需的版本
+func schemaVersionCheck(db *BaseDB, feature string, least string) error {
+	current, err := currentSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current != "" && compareSchemaVersion(current, least) >= 0 {
+		return nil
+	}
+	return fmt.Errorf("%s requires database schema version %s or later, but the current version is [%s], "+
+		"please apply the sql scripts under store/mysql/scripts", feature, least, current)
+}
+
+// compareSchemaVersion 按照数值逐段比较 v1.19.0 格式的版本号，无法解析的段视为 0
+func compareSchemaVersion(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var va, vb int
+		if i < len(pa) {
+			va, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			vb, _ = strconv.Atoi(pb[i])
+		}
+		if va != vb {
+			if va > vb {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+// isCaseInsensitiveCollation 排序规则是否忽略大小写，例如 utf8mb4_general_ci、utf8mb4_0900_ai_ci
+func isCaseInsensitiveCollation(collation string) bool {
+	return strings.HasSuffix(strings.ToLower(collation), "_ci")
+}
+
+// userNameCollationCheck 用户名称的唯一性校验以及按名称查询依赖 user.name 字段的排序规则，
+// 与配置的 userNameCaseSensitive 不一致时只大小写不同的名称会被视为重复或者不同的用户，此时输出告警，不影响启动
+func userNameCollationCheck(db *BaseDB, caseSensitive bool) {
+	var collation sql.NullString
+	querySql := "SELECT COLLATION_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() " +
+		" AND TABLE_NAME = ? AND COLUMN_NAME = ?"
+	if err := db.QueryRow(querySql, "user", "name").Scan(&collation); err != nil {
+		log.Warnf("[Store][database] query collation of user.name err: %s", err.Error())
+		return
+	}
+	if isCaseInsensitiveCollation(collation.String) != caseSensitive {
+		return
+	}
+	expect := "case-sensitive"
+	if !caseSensitive {
+		expect = "case-insensitive"
+	}
+	log.Warnf("[Store][database] collation of user.name is %s, but user names are expected to be %s, "+
+		"names that only differ in case may be treated inconsistently, please check userNameCaseSensitive "+
+		"or alter the collation of user.name", collation.String, expect)
+}
+
+// supportRecursiveCTE 数据库是否支持 WITH RECURSIVE，MySQL 8.0 以及 MariaDB 10.2.2 开始支持，查询失败时视为不支持
+func supportRecursiveCTE(db *BaseDB) bool {
+	var version string
+	if err := db.QueryRow("SELECT VERSION()").Scan(&version); err != nil {
+		log.Warnf("[Store][database] query database version err: %s", err.Error())
+		return false
+	}
+	supported := versionSupportRecursiveCTE(version)
+	if !supported {
+		log.Warnf("[Store][database] database version %s does not support WITH RECURSIVE, "+
+			"recursive owner query of users is disabled", version)
+	}
+	return supported
+}
+
+// versionSupportRecursiveCTE 解析 VERSION() 返回的版本号，例如 8.0.33、5.7.44-log、10.6.12-MariaDB
+func versionSupportRecursiveCTE(version string) bool {
+	least := []int{8, 0, 0}
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		least = []int{10, 2, 2}
+	}
+	// 去除 -log、-MariaDB 等后缀
+	if i := strings.IndexFunc(version, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	for i := range least {
+		if i >= len(parts) {
+			return false
+		}
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return false
+		}
+		if v != least[i] {
+			return v > least[i]
+		}
+	}
+	return true
+}
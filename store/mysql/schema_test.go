@@ -0,0 +1,225 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	commonlog "github.com/polarismesh/polaris/common/log"
+)
+
+func newSchemaRows(skipTables map[string]bool, skipColumns map[string]bool) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME"})
+	for table, columns := range requiredSchema {
+		if skipTables[table] {
+			continue
+		}
+		for _, column := range columns {
+			if skipColumns[table+"."+column] {
+				continue
+			}
+			rows.AddRow(table, column)
+		}
+	}
+	return rows
+}
+
+func Test_schemaCheck(t *testing.T) {
+	t.Run("schema完整", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT TABLE_NAME, COLUMN_NAME FROM information_schema.COLUMNS`).
+			WillReturnRows(newSchemaRows(nil, nil))
+
+		assert.NoError(t, schemaCheck(us.master))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("缺少表以及字段", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT TABLE_NAME, COLUMN_NAME FROM information_schema.COLUMNS`).
+			WillReturnRows(newSchemaRows(map[string]bool{"auth_principal": true},
+				map[string]bool{"user.token_enable": true, "user.user_type": true}))
+
+		err := schemaCheck(us.master)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "missing tables: [auth_principal]")
+		assert.Contains(t, err.Error(), "missing columns: [user.token_enable, user.user_type]")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_schemaMigrations(t *testing.T) {
+	migrationSql := `SELECT version, description, UNIX_TIMESTAMP\(applied_at\) FROM schema_migrations +` +
+		`ORDER BY applied_at, version`
+	newMigrationRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"version", "description", "applied_at"}).
+			AddRow("v1.8.0", "delta v1_7_0-v1_8_0", 1600000000).
+			AddRow("v1.19.0", "delta v1_18_0-v1_19_0", 1700000000).
+			AddRow("v1.9.0", "hotfix", 1700000100)
+	}
+
+	t.Run("查询已经执行的schema变更", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(migrationSql).WillReturnRows(newMigrationRows())
+
+		migrations, err := getAppliedMigrations(us.master)
+		assert.NoError(t, err)
+		assert.Len(t, migrations, 3)
+		assert.Equal(t, &SchemaMigration{Version: "v1.19.0", Description: "delta v1_18_0-v1_19_0",
+			AppliedAt: time.Unix(1700000000, 0)}, migrations[1])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("按照版本号数值取最大版本", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(migrationSql).WillReturnRows(newMigrationRows())
+
+		version, err := currentSchemaVersion(us.master)
+		assert.NoError(t, err)
+		assert.Equal(t, "v1.19.0", version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("schema版本满足特性要求", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(migrationSql).WillReturnRows(newMigrationRows())
+
+		assert.NoError(t, schemaVersionCheck(us.master, "tokenEncryption", tokenEncryptionSchemaVersion))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("schema版本低于特性要求", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(migrationSql).WillReturnRows(sqlmock.NewRows([]string{"version", "description",
+			"applied_at"}).AddRow("v1.18.0", "", 1600000000))
+
+		err := schemaVersionCheck(us.master, "tokenEncryption", tokenEncryptionSchemaVersion)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "tokenEncryption requires database schema version v1.19.0 or later, "+
+			"but the current version is [v1.18.0]")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("没有执行任何schema变更", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(migrationSql).WillReturnRows(sqlmock.NewRows([]string{"version", "description",
+			"applied_at"}))
+
+		version, err := currentSchemaVersion(us.master)
+		assert.NoError(t, err)
+		assert.Empty(t, version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询失败", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(migrationSql).WillReturnError(errors.New("table schema_migrations doesn't exist"))
+
+		_, err := currentSchemaVersion(us.master)
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_compareSchemaVersion(t *testing.T) {
+	assert.Equal(t, 1, compareSchemaVersion("v1.19.0", "v1.8.0"))
+	assert.Equal(t, -1, compareSchemaVersion("v1.18.0", "v1.19.0"))
+	assert.Equal(t, 0, compareSchemaVersion("v1.19", "v1.19.0"))
+	assert.Equal(t, 1, compareSchemaVersion("v1.19.1", "v1.19.0"))
+}
+
+func Test_userNameCollationCheck(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "store.log")
+	err := commonlog.Configure(map[string]*commonlog.Options{
+		log.Name(): {
+			OutputPaths:      []string{logFile},
+			ErrorOutputPaths: []string{"stderr"},
+			OutputLevel:      "info",
+		},
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = commonlog.Configure(map[string]*commonlog.Options{
+			log.Name(): commonlog.DefaultOptions()[log.Name()],
+		})
+	})
+	readLog := func() string {
+		_ = log.Sync()
+		content, err := os.ReadFile(logFile)
+		assert.NoError(t, err)
+		return string(content)
+	}
+	collationSql := `SELECT COLLATION_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE\(\) +` +
+		`AND TABLE_NAME = \? AND COLUMN_NAME = \?`
+
+	t.Run("排序规则与期望一致", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(collationSql).WithArgs("user", "name").
+			WillReturnRows(sqlmock.NewRows([]string{"COLLATION_NAME"}).AddRow("utf8mb4_bin"))
+		mock.ExpectQuery(collationSql).WithArgs("user", "name").
+			WillReturnRows(sqlmock.NewRows([]string{"COLLATION_NAME"}).AddRow("utf8mb4_0900_ai_ci"))
+
+		userNameCollationCheck(us.master, true)
+		userNameCollationCheck(us.master, false)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NotContains(t, readLog(), "collation of user.name")
+	})
+
+	t.Run("期望区分大小写但是排序规则忽略大小写", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(collationSql).
+			WillReturnRows(sqlmock.NewRows([]string{"COLLATION_NAME"}).AddRow("utf8mb4_general_ci"))
+
+		userNameCollationCheck(us.master, true)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Contains(t, readLog(), "collation of user.name is utf8mb4_general_ci, "+
+			"but user names are expected to be case-sensitive")
+	})
+
+	t.Run("查询失败时只告警", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(collationSql).WillReturnError(errors.New("access denied"))
+
+		userNameCollationCheck(us.master, true)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Contains(t, readLog(), "query collation of user.name err: access denied")
+	})
+}
+
+func Test_versionSupportRecursiveCTE(t *testing.T) {
+	for version, expect := range map[string]bool{
+		"8.0.33":          true,
+		"8.4.0-log":       true,
+		"5.7.44-log":      false,
+		"5.6.51":          false,
+		"10.6.12-MariaDB": true,
+		"10.2.2-MariaDB":  true,
+		"10.2.1-MariaDB":  false,
+		"10.1.48-MariaDB": false,
+		"":                false,
+	} {
+		assert.Equal(t, expect, versionSupportRecursiveCTE(version), version)
+	}
+}
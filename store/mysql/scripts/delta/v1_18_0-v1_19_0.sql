@@ -0,0 +1,142 @@
+/*
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+--
+-- Database: `polaris_server`
+--
+USE `polaris_server`;
+
+-- 用户最近一次登录时间
+ALTER TABLE user
+ADD COLUMN `last_login_time` TIMESTAMP NULL DEFAULT NULL COMMENT 'Last time the account token passed verification';
+
+-- 密码过期以及强制修改密码
+ALTER TABLE user
+ADD COLUMN `password_set_time` TIMESTAMP NULL DEFAULT NULL COMMENT 'Last time the password was changed';
+
+ALTER TABLE user
+ADD COLUMN `must_change_password` TINYINT(4) NOT NULL DEFAULT 0 COMMENT 'Whether the user must change the password before using other APIs';
+
+-- 存量用户的密码修改时间未知，以升级时间作为起点计算密码有效期
+UPDATE user SET password_set_time = sysdate(), mtime = mtime WHERE password_set_time IS NULL;
+
+-- 用户删除时间，存量已删除用户的删除时间未知，以 mtime 作为近似值
+ALTER TABLE user
+ADD COLUMN `deleted_at` TIMESTAMP NULL DEFAULT NULL COMMENT 'Time when the account was deleted, NULL if it is not deleted';
+
+UPDATE user SET deleted_at = mtime, mtime = mtime WHERE flag = 1 AND deleted_at IS NULL;
+
+-- 用户名称的小写形式，store 配置 userNameCaseSensitive 为 false 时按照该字段查找用户，返回的名称保持原样
+ALTER TABLE user
+ADD COLUMN `name_lower` VARCHAR(100) NOT NULL DEFAULT '' COMMENT 'Lower case user name, used to look up users case-insensitively' AFTER `name`;
+
+UPDATE user SET name_lower = LOWER(name), mtime = mtime;
+
+ALTER TABLE user
+ADD KEY `name_lower` (`name_lower`, `owner`);
+
+-- 需要同一个主账户下的用户名称忽略大小写唯一时，处理存量数据中只有大小写不同的用户（包括已删除的用户）后改为唯一索引：
+-- ALTER TABLE user DROP KEY `name_lower`, ADD UNIQUE KEY `name_lower` (`name_lower`, `owner`);
+
+-- 创建用户的操作者，存量用户的创建者未知，保持为空
+ALTER TABLE user
+ADD COLUMN `created_by` VARCHAR(128) NOT NULL DEFAULT '' COMMENT 'ID of the operator who created the account, empty if unknown';
+
+-- 最后一次修改用户的操作者，与 mtime 一同更新，存量用户以及无法确定操作者的修改保持为空
+ALTER TABLE user
+ADD COLUMN `modified_by` VARCHAR(128) NOT NULL DEFAULT '' COMMENT 'ID of the operator who last modified the account, empty if unknown';
+
+-- 用户最近一次更换 token 的时间，存量用户的更换时间未知，保持为 NULL，按照未更换时间过滤时视为长期未更换
+ALTER TABLE user
+ADD COLUMN `token_rotated_time` TIMESTAMP NULL DEFAULT NULL COMMENT 'Last time the token was changed, NULL if it is unknown';
+
+-- 用户-用户组关联关系改为逻辑删除，便于 cache 增量剔除已经移除的关联关系
+ALTER TABLE user_group_relation
+ADD COLUMN `flag` TINYINT(4) NOT NULL DEFAULT 0 COMMENT 'Whether the relation is valid, 0 is valid, 1 is removed';
+
+-- 用户 token 全局唯一，执行前需要先处理存量数据中 token 重复的用户（包括已删除的用户），否则添加索引会失败
+ALTER TABLE user
+ADD UNIQUE KEY `token` (`token`);
+
+-- 用户变更记录，store 开启 userChangeLog 后在用户变更的事务中写入，供下游系统按照序号增量消费
+CREATE TABLE `user_change_log`
+(
+    `seq`     BIGINT UNSIGNED NOT NULL COMMENT 'Sequence of the change, allocated in commit order',
+    `op`      VARCHAR(16)     NOT NULL COMMENT 'Change type: create, update or delete',
+    `user_id` VARCHAR(128)    NOT NULL COMMENT 'User ID',
+    `payload` TEXT            NOT NULL COMMENT 'User snapshot after the change in JSON, without password and token',
+    `ctime`   TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time',
+    PRIMARY KEY (`seq`),
+    KEY `ctime` (`ctime`)
+) ENGINE = InnoDB;
+
+-- user_change_log 的序号分配，只有一行数据
+CREATE TABLE `user_change_seq`
+(
+    `id`  INT             NOT NULL,
+    `seq` BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT 'Last allocated sequence of user_change_log',
+    PRIMARY KEY (`id`)
+) ENGINE = InnoDB;
+
+INSERT INTO user_change_seq(`id`, `seq`) VALUES (1, 0);
+
+-- 已删除用户的归档，store 开启 archiveInvalidUser 后，新用户回收同名的已删除用户前将其复制到该表，不包含密码以及 token
+CREATE TABLE `user_archive`
+(
+    `archive_id`  BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `id`          VARCHAR(128)    NOT NULL COMMENT 'User ID',
+    `name`        VARCHAR(100)    NOT NULL COMMENT 'user name',
+    `owner`       VARCHAR(128)    NOT NULL COMMENT 'Main account ID',
+    `source`      VARCHAR(32)     NOT NULL COMMENT 'Account source',
+    `mobile`      VARCHAR(12)     NOT NULL DEFAULT '' COMMENT 'Account mobile phone number',
+    `email`       VARCHAR(64)     NOT NULL DEFAULT '' COMMENT 'Account mailbox',
+    `user_type`   INT             NOT NULL COMMENT 'Account type',
+    `comment`     VARCHAR(255)    NOT NULL COMMENT 'describe',
+    `ctime`       TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time of the user',
+    `mtime`       TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Last updated time of the user',
+    `deleted_at`  TIMESTAMP       NULL DEFAULT NULL COMMENT 'Time when the account was deleted',
+    `archived_at` TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Time when the account was archived',
+    PRIMARY KEY (`archive_id`),
+    KEY `id` (`id`),
+    KEY `name` (`name`, `owner`)
+) ENGINE = InnoDB;
+
+-- 用户的附加 token，一个用户可以有多个按照名称区分、权限范围以及有效期受限的 token
+CREATE TABLE `user_token`
+(
+    `id`          VARCHAR(128) NOT NULL COMMENT 'Token ID',
+    `user_id`     VARCHAR(128) NOT NULL COMMENT 'ID of the user the token belongs to',
+    `name`        VARCHAR(100) NOT NULL COMMENT 'Token name, unique among the valid tokens of the user',
+    `token`       VARCHAR(255) NOT NULL COMMENT 'Token information',
+    `scope`       VARCHAR(32)  NOT NULL COMMENT 'Capability of the token: read_only or read_write',
+    `expire_time` TIMESTAMP    NULL DEFAULT NULL COMMENT 'Expire time of the token, NULL means never expire',
+    `flag`        TINYINT(4)   NOT NULL DEFAULT '0' COMMENT 'Whether the token is valid, 0 is valid, 1 is revoked',
+    `ctime`       TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time',
+    `mtime`       TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'Last updated time',
+    PRIMARY KEY (`id`),
+    KEY `user_id` (`user_id`, `name`)
+) ENGINE = InnoDB;
+
+-- 已执行的 schema 变更，之后的 delta 脚本执行完成后写入对应的版本，store 按照其中的最大版本判断是否支持依赖新字段的特性
+CREATE TABLE `schema_migrations`
+(
+    `version`     VARCHAR(32)  NOT NULL COMMENT 'Schema version after the migration, e.g. v1.19.0',
+    `description` VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'Description of the migration',
+    `applied_at`  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Time when the migration was applied',
+    PRIMARY KEY (`version`)
+) ENGINE = InnoDB;
+
+INSERT INTO schema_migrations(`version`, `description`) VALUES ('v1.19.0', 'delta v1_18_0-v1_19_0');
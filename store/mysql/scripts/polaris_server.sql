@@ -559,6 +559,7 @@ CREATE TABLE `user`
 (
     `id`           VARCHAR(128) NOT NULL COMMENT 'User ID',
     `name`         VARCHAR(100) NOT NULL COMMENT 'user name',
+    `name_lower`   VARCHAR(100) NOT NULL DEFAULT '' COMMENT 'Lower case user name, used to look up users case-insensitively',
     `password`     VARCHAR(100) NOT NULL COMMENT 'user password',
     `owner`        VARCHAR(128) NOT NULL COMMENT 'Main account ID',
     `source`       VARCHAR(32)  NOT NULL COMMENT 'Account source',
@@ -571,12 +572,80 @@ CREATE TABLE `user`
     `flag`         TINYINT(4)   NOT NULL DEFAULT '0' COMMENT 'Whether the rules are valid, 0 is valid, 1 is invalid, it is deleted',
     `ctime`        TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time',
     `mtime`        TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'Last updated time',
+    `last_login_time` TIMESTAMP NULL DEFAULT NULL COMMENT 'Last time the account token passed verification',
+    `password_set_time` TIMESTAMP NULL DEFAULT NULL COMMENT 'Last time the password was changed',
+    `must_change_password` TINYINT(4) NOT NULL DEFAULT 0 COMMENT 'Whether the user must change the password before using other APIs',
+    `token_rotated_time` TIMESTAMP NULL DEFAULT NULL COMMENT 'Last time the token was changed, NULL if it is unknown',
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL COMMENT 'Time when the account was deleted, NULL if it is not deleted',
+    `created_by` VARCHAR(128) NOT NULL DEFAULT '' COMMENT 'ID of the operator who created the account, empty if unknown',
+    `modified_by` VARCHAR(128) NOT NULL DEFAULT '' COMMENT 'ID of the operator who last modified the account, empty if unknown',
     PRIMARY KEY (`id`),
     UNIQUE KEY (`name`, `owner`),
+    UNIQUE KEY `token` (`token`),
+    KEY `name_lower` (`name_lower`, `owner`),
     KEY `owner` (`owner`),
     KEY `mtime` (`mtime`)
 ) ENGINE = InnoDB;
 
+/* 用户变更记录，store 开启 userChangeLog 后在用户变更的事务中写入，供下游系统按照序号增量消费 */
+CREATE TABLE `user_change_log`
+(
+    `seq`     BIGINT UNSIGNED NOT NULL COMMENT 'Sequence of the change, allocated in commit order',
+    `op`      VARCHAR(16)     NOT NULL COMMENT 'Change type: create, update or delete',
+    `user_id` VARCHAR(128)    NOT NULL COMMENT 'User ID',
+    `payload` TEXT            NOT NULL COMMENT 'User snapshot after the change in JSON, without password and token',
+    `ctime`   TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time',
+    PRIMARY KEY (`seq`),
+    KEY `ctime` (`ctime`)
+) ENGINE = InnoDB;
+
+/* user_change_log 的序号分配，只有一行数据 */
+CREATE TABLE `user_change_seq`
+(
+    `id`  INT             NOT NULL,
+    `seq` BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT 'Last allocated sequence of user_change_log',
+    PRIMARY KEY (`id`)
+) ENGINE = InnoDB;
+
+INSERT INTO user_change_seq(`id`, `seq`) VALUES (1, 0);
+
+/* 已删除用户的归档，store 开启 archiveInvalidUser 后，新用户回收同名的已删除用户前将其复制到该表，不包含密码以及 token */
+CREATE TABLE `user_archive`
+(
+    `archive_id`  BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `id`          VARCHAR(128)    NOT NULL COMMENT 'User ID',
+    `name`        VARCHAR(100)    NOT NULL COMMENT 'user name',
+    `owner`       VARCHAR(128)    NOT NULL COMMENT 'Main account ID',
+    `source`      VARCHAR(32)     NOT NULL COMMENT 'Account source',
+    `mobile`      VARCHAR(12)     NOT NULL DEFAULT '' COMMENT 'Account mobile phone number',
+    `email`       VARCHAR(64)     NOT NULL DEFAULT '' COMMENT 'Account mailbox',
+    `user_type`   INT             NOT NULL COMMENT 'Account type',
+    `comment`     VARCHAR(255)    NOT NULL COMMENT 'describe',
+    `ctime`       TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time of the user',
+    `mtime`       TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Last updated time of the user',
+    `deleted_at`  TIMESTAMP       NULL DEFAULT NULL COMMENT 'Time when the account was deleted',
+    `archived_at` TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Time when the account was archived',
+    PRIMARY KEY (`archive_id`),
+    KEY `id` (`id`),
+    KEY `name` (`name`, `owner`)
+) ENGINE = InnoDB;
+
+/* 用户的附加 token，一个用户可以有多个按照名称区分、权限范围以及有效期受限的 token */
+CREATE TABLE `user_token`
+(
+    `id`          VARCHAR(128) NOT NULL COMMENT 'Token ID',
+    `user_id`     VARCHAR(128) NOT NULL COMMENT 'ID of the user the token belongs to',
+    `name`        VARCHAR(100) NOT NULL COMMENT 'Token name, unique among the valid tokens of the user',
+    `token`       VARCHAR(255) NOT NULL COMMENT 'Token information',
+    `scope`       VARCHAR(32)  NOT NULL COMMENT 'Capability of the token: read_only or read_write',
+    `expire_time` TIMESTAMP    NULL DEFAULT NULL COMMENT 'Expire time of the token, NULL means never expire',
+    `flag`        TINYINT(4)   NOT NULL DEFAULT '0' COMMENT 'Whether the token is valid, 0 is valid, 1 is revoked',
+    `ctime`       TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time',
+    `mtime`       TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'Last updated time',
+    PRIMARY KEY (`id`),
+    KEY `user_id` (`user_id`, `name`)
+) ENGINE = InnoDB;
+
 CREATE TABLE `user_group`
 (
     `id`           VARCHAR(128) NOT NULL COMMENT 'User group ID',
@@ -598,6 +667,7 @@ CREATE TABLE `user_group_relation`
 (
     `user_id`  VARCHAR(128) NOT NULL COMMENT 'User ID',
     `group_id` VARCHAR(128) NOT NULL COMMENT 'User group ID',
+    `flag`     TINYINT(4)   NOT NULL DEFAULT 0 COMMENT 'Whether the relation is valid, 0 is valid, 1 is removed',
     `ctime`    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Create time',
     `mtime`    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'Last updated time',
     PRIMARY KEY (`user_id`, `group_id`),
@@ -644,6 +714,7 @@ CREATE TABLE `auth_strategy_resource`
 -- Create a default master account, password is Polarismesh @ 2021
 INSERT INTO `user` (`id`,
                     `name`,
+                    `name_lower`,
                     `password`,
                     `source`,
                     `token`,
@@ -654,6 +725,7 @@ INSERT INTO `user` (`id`,
                     `email`,
                     `owner`)
 VALUES ('65e4789a6d5b49669adf1e9e8387549c',
+        'polaris',
         'polaris',
         '$2a$10$3izWuZtE5SBdAtSZci.gs.iZ2pAn9I8hEqYrC6gwJp1dyjqQnrrum',
         'Polaris',
@@ -930,3 +1002,14 @@ CREATE TABLE `gray_resource`
     `flag`        TINYINT(4)            DEFAULT 0 COMMENT '逻辑删除标志位, 0 位有效, 1 为逻辑删除',
     PRIMARY KEY (`name`)
 ) ENGINE = InnoDB COMMENT = '灰度资源表';
+
+/* 已执行的 schema 变更，升级时执行 delta 脚本会写入对应的版本，store 按照其中的最大版本判断是否支持依赖新字段的特性 */
+CREATE TABLE `schema_migrations`
+(
+    `version`     VARCHAR(32)  NOT NULL COMMENT 'Schema version after the migration, e.g. v1.19.0',
+    `description` VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'Description of the migration',
+    `applied_at`  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'Time when the migration was applied',
+    PRIMARY KEY (`version`)
+) ENGINE = InnoDB;
+
+INSERT INTO schema_migrations(`version`, `description`) VALUES ('v1.19.0', 'initial schema');
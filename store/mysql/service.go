@@ -213,9 +213,10 @@ func (ss *serviceStore) updateServiceAlias(alias *model.Service, needUpdateOwner
 	}
 
 	if err := checkServiceAffectedRows(result, 1); err != nil {
-		if store.Code(err) == store.AffectedRowsNotMatch {
+		if store.Code(err) == store.NotFoundResource {
 			return store.NewStatusError(store.NotFoundService, "not found service")
 		}
+		return err
 	}
 	return nil
 }
@@ -232,6 +233,9 @@ func checkServiceAffectedRows(result sql.Result, count int64) error {
 		return nil
 	}
 	log.Errorf("[Store][ServiceAlias] get rows affected result(%d) is not match expect(%d)", n, count)
+	if n == 0 && count == 1 {
+		return store.NewStatusError(store.NotFoundResource, "no rows affected, resource not found")
+	}
 	return store.NewStatusError(store.AffectedRowsNotMatch, "affected rows not match")
 }
 
@@ -53,6 +53,14 @@ func boolToInt(v bool) int {
 	return 0
 }
 
+// likeEscaper 转义 LIKE 中的通配符以及转义字符本身，使关键字按照字面量匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike 转义 LIKE 查询的关键字，使用 MySQL 默认的转义字符反斜杠
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
 // genFilterSQL 根据filter生成where相关的语句
 func genFilterSQL(filter map[string]string) (string, []interface{}) {
 	if len(filter) == 0 {
@@ -131,13 +131,10 @@ func (s *strategyStore) updateStrategy(strategy *model.ModifyStrategyDetail) err
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	// 调整 principal 信息
-	if err := s.addStrategyPrincipals(tx, strategy.ID, strategy.AddPrincipals); err != nil {
-		log.Errorf("[Store][Strategy] add strategy principal err: %s", err.Error())
-		return err
-	}
-	if err := s.deleteStrategyPrincipals(tx, strategy.ID, strategy.RemovePrincipals); err != nil {
-		log.Errorf("[Store][Strategy] remove strategy principal err: %s", err.Error())
+	// 按照 authLockOrder 的顺序，先保存策略主信息，再调整鉴权资源以及 principal 信息
+	saveMainSql := "UPDATE auth_strategy SET action = ?, comment = ?, mtime = sysdate() WHERE id = ?"
+	if _, err = tx.Exec(saveMainSql, []interface{}{strategy.Action, strategy.Comment, strategy.ID}...); err != nil {
+		log.Error("[Store][Strategy] update strategy main info", zap.Error(err))
 		return err
 	}
 
@@ -151,10 +148,13 @@ func (s *strategyStore) updateStrategy(strategy *model.ModifyStrategyDetail) err
 		return err
 	}
 
-	// 保存策略主信息
-	saveMainSql := "UPDATE auth_strategy SET action = ?, comment = ?, mtime = sysdate() WHERE id = ?"
-	if _, err = tx.Exec(saveMainSql, []interface{}{strategy.Action, strategy.Comment, strategy.ID}...); err != nil {
-		log.Error("[Store][Strategy] update strategy main info", zap.Error(err))
+	// 调整 principal 信息
+	if err := s.addStrategyPrincipals(tx, strategy.ID, strategy.AddPrincipals); err != nil {
+		log.Errorf("[Store][Strategy] add strategy principal err: %s", err.Error())
+		return err
+	}
+	if err := s.deleteStrategyPrincipals(tx, strategy.ID, strategy.RemovePrincipals); err != nil {
+		log.Errorf("[Store][Strategy] remove strategy principal err: %s", err.Error())
 		return err
 	}
 
@@ -806,31 +806,30 @@ func (s *strategyStore) cleanInvalidStrategy(name, owner string) error {
 	return nil
 }
 
-// cleanLinkStrategy 清理与自己相关联的鉴权信息
-// step 1. 清理用户/用户组默认策略所关联的所有资源信息（直接走delete删除）
-// step 2. 清理用户/用户组默认策略
+// cleanLinkStrategy 清理与自己相关联的鉴权信息，语句按照 authLockOrder 的顺序执行
+// step 1. 清理用户/用户组默认策略，同时调整所关联的其他鉴权策略的 mtime
+// step 2. 清理用户/用户组默认策略所关联的所有资源信息（直接走delete删除）
 // step 3. 清理用户/用户组所关联的其他鉴权策略的关联关系（直接走delete删除）
 func cleanLinkStrategy(tx *BaseTx, role model.PrincipalType, principalId, owner string) error {
+	// 主账户的 owner 为空，创建默认策略时使用的是自身的 ID 作为 owner，这里需要保持一致
+	if owner == "" {
+		owner = principalId
+	}
+	return cleanLinkStrategies(tx, role, []string{principalId}, owner)
+}
 
-	// 清理默认策略对应的所有鉴权关联资源
-	removeResSql := `
-		 DELETE FROM auth_strategy_resource
-		 WHERE strategy_id IN (
-				 SELECT DISTINCT ag.id
-				 FROM auth_strategy ag
-				 WHERE ag.default = 1
-					 AND ag.owner = ?
-					 AND ag.id IN (
-						 SELECT DISTINCT strategy_id
-						 FROM auth_principal
-						 WHERE principal_id = ?
-							 AND principal_role = ?
-					 )
-			 )
-		 `
-
-	if _, err := tx.Exec(removeResSql, []interface{}{owner, principalId, role}...); err != nil {
-		return err
+// cleanLinkStrategies 清理同一个 owner 下的一批用户/用户组相关联的鉴权信息，步骤与 cleanLinkStrategy 一致
+func cleanLinkStrategies(tx *BaseTx, role model.PrincipalType, principalIds []string, owner string) error {
+	if len(principalIds) == 0 {
+		return nil
+	}
+	inSql := placeholders(len(principalIds))
+	// withIds 按照 before、principalIds、after 的顺序拼接 SQL 参数
+	withIds := func(before []interface{}, after ...interface{}) []interface{} {
+		args := make([]interface{}, 0, len(before)+len(principalIds)+len(after))
+		args = append(args, before...)
+		args = append(args, stringsToArgs(principalIds)...)
+		return append(args, after...)
 	}
 
 	// 清理默认策略
@@ -840,27 +839,48 @@ func cleanLinkStrategy(tx *BaseTx, role model.PrincipalType, principalId, owner
 		 WHERE ag.id IN (
 				 SELECT DISTINCT strategy_id
 				 FROM auth_principal
-				 WHERE principal_id = ?
+				 WHERE principal_id IN (` + inSql + `)
 					 AND principal_role = ?
 			 )
 			 AND ag.default = 1
 			 AND ag.owner = ?
 	 `
 
-	if _, err := tx.Exec(cleanaRuleSql, []interface{}{principalId, role, owner}...); err != nil {
+	if _, err := tx.Exec(cleanaRuleSql, withIds(nil, role, owner)...); err != nil {
 		return err
 	}
 
 	// 调整所关联的鉴权策略的 mtime 数据，保证cache刷新可以获取到变更的数据信息
 	updateStrategySql := "UPDATE auth_strategy SET mtime = sysdate()  WHERE id IN (SELECT DISTINCT " +
-		" strategy_id FROM auth_principal WHERE principal_id = ? AND principal_role = ?)"
-	if _, err := tx.Exec(updateStrategySql, []interface{}{principalId, role}...); err != nil {
+		" strategy_id FROM auth_principal WHERE principal_id IN (" + inSql + ") AND principal_role = ?)"
+	if _, err := tx.Exec(updateStrategySql, withIds(nil, role)...); err != nil {
+		return err
+	}
+
+	// 清理默认策略对应的所有鉴权关联资源，默认策略此时已经被标记为删除，因此这里不再过滤 flag
+	removeResSql := `
+		 DELETE FROM auth_strategy_resource
+		 WHERE strategy_id IN (
+				 SELECT DISTINCT ag.id
+				 FROM auth_strategy ag
+				 WHERE ag.default = 1
+					 AND ag.owner = ?
+					 AND ag.id IN (
+						 SELECT DISTINCT strategy_id
+						 FROM auth_principal
+						 WHERE principal_id IN (` + inSql + `)
+							 AND principal_role = ?
+					 )
+			 )
+		 `
+
+	if _, err := tx.Exec(removeResSql, withIds([]interface{}{owner}, role)...); err != nil {
 		return err
 	}
 
 	// 清理所在的所有鉴权principal
-	cleanPrincipalSql := "DELETE FROM auth_principal WHERE principal_id = ? AND principal_role = ?"
-	if _, err := tx.Exec(cleanPrincipalSql, []interface{}{principalId, role}...); err != nil {
+	cleanPrincipalSql := "DELETE FROM auth_principal WHERE principal_id IN (" + inSql + ") AND principal_role = ?"
+	if _, err := tx.Exec(cleanPrincipalSql, withIds(nil, role)...); err != nil {
 		return err
 	}
 
@@ -0,0 +1,173 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// encryptedTokenPrefix 加密后的 token 前缀，完整格式为 enc:v2:{key id}:{base64(nonce + 密文)}
+	encryptedTokenPrefix = "enc:v2:"
+	// legacyEncryptedTokenPrefix v1 版本直接使用加密密钥计算 nonce，只用于解密存量数据
+	legacyEncryptedTokenPrefix = "enc:v1:"
+	// tokenNonceKeyLabel 从加密密钥派生计算 nonce 的 HMAC 密钥时使用的标签，避免同一个密钥同时用于 AES-GCM 以及 HMAC
+	tokenNonceKeyLabel = "polaris token nonce v2"
+	// maxTokenKeyIDLen key id 的最大长度，避免加密后的 token 超出字段长度
+	maxTokenKeyIDLen = 16
+)
+
+// tokenCipher 用户 token 落库时的加解密，使用 AES-GCM
+// 新写入的 token 使用 activeKey 加密，历史 key 仅用于解密，以支持 key 的轮换
+// 注意按照 token 进行的唯一索引以及等值比较只能匹配 activeKey 加密的数据，轮换 key 之后旧 key 加密的数据
+// 在下一次写入该用户时才会使用新的 key 重新加密，在此之前同一个 token 的重复需要通过 FindDuplicateTokens 发现
+type tokenCipher struct {
+	activeKey string
+	aeads     map[string]cipher.AEAD
+	nonceKeys map[string][]byte
+}
+
+// newTokenCipher 创建 tokenCipher，keys 为 key id 到密钥的映射，密钥长度需要为 16、24 或者 32 字节
+func newTokenCipher(activeKey string, keys map[string][]byte) (*tokenCipher, error) {
+	if _, ok := keys[activeKey]; !ok {
+		return nil, fmt.Errorf("token encryption active key(%s) not found", activeKey)
+	}
+	tc := &tokenCipher{
+		activeKey: activeKey,
+		aeads:     make(map[string]cipher.AEAD, len(keys)),
+		nonceKeys: make(map[string][]byte, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || len(id) > maxTokenKeyIDLen || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid token encryption key id(%s)", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token encryption key(%s): %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		tc.aeads[id] = aead
+		if tc.nonceKeys[id], err = deriveTokenNonceKey(key, id); err != nil {
+			return nil, err
+		}
+	}
+	return tc, nil
+}
+
+// deriveTokenNonceKey 通过 HKDF-SHA256 从加密密钥派生计算 nonce 的 HMAC 密钥，key id 作为 salt，每个 key 独立派生
+func deriveTokenNonceKey(key []byte, id string) ([]byte, error) {
+	nonceKey := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, []byte(id), []byte(tokenNonceKeyLabel)), nonceKey); err != nil {
+		return nil, fmt.Errorf("derive token nonce key(%s): %w", id, err)
+	}
+	return nonceKey, nil
+}
+
+// parseTokenCipher 解析 token 加密的配置，未配置时返回 nil，表示 token 明文存储
+//
+//	tokenEncryption:
+//	  activeKey: k2
+//	  keys:
+//	    k1: base64 encoded key
+//	    k2: base64 encoded key
+func parseTokenCipher(opt interface{}) (*tokenCipher, error) {
+	if opt == nil {
+		return nil, nil
+	}
+	obj, _ := opt.(map[interface{}]interface{})
+	activeKey, _ := obj["activeKey"].(string)
+	entries, _ := obj["keys"].(map[interface{}]interface{})
+	if activeKey == "" || len(entries) == 0 {
+		return nil, errors.New("token encryption config must contain activeKey and keys")
+	}
+
+	keys := make(map[string][]byte, len(entries))
+	for id, val := range entries {
+		encoded, _ := val.(string)
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("token encryption key(%v) is not base64 encoded: %w", id, err)
+		}
+		keys[fmt.Sprintf("%v", id)] = key
+	}
+	return newTokenCipher(activeKey, keys)
+}
+
+// Encrypt 使用 activeKey 加密 token
+// nonce 由 token 的 HMAC 生成，同一个 key 下相同的 token 加密结果一致，从而保证 token 唯一索引依然有效，
+// 其他 key 或者 v1 版本加密的同一个 token 结果不同，参见 tokenCipher 的说明
+func (tc *tokenCipher) Encrypt(token string) (string, error) {
+	if tc == nil || token == "" {
+		return token, nil
+	}
+	aead := tc.aeads[tc.activeKey]
+	mac := hmac.New(sha256.New, tc.nonceKeys[tc.activeKey])
+	_, _ = mac.Write([]byte(token))
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	sealed := aead.Seal(nonce, nonce, []byte(token), []byte(tc.activeKey))
+	return encryptedTokenPrefix + tc.activeKey + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密 token，未加密的 token 原样返回，便于存量数据平滑迁移
+// nonce 保存在密文中，因此 v1 以及 v2 版本的数据使用相同的方式解密
+func (tc *tokenCipher) Decrypt(token string) (string, error) {
+	prefix := encryptedTokenPrefix
+	if strings.HasPrefix(token, legacyEncryptedTokenPrefix) {
+		prefix = legacyEncryptedTokenPrefix
+	}
+	if !strings.HasPrefix(token, prefix) {
+		return token, nil
+	}
+	if tc == nil {
+		return "", errors.New("token is encrypted but token encryption is not configured")
+	}
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(token, prefix), ":")
+	if !ok {
+		return "", errors.New("invalid encrypted token format")
+	}
+	aead, ok := tc.aeads[keyID]
+	if !ok {
+		return "", fmt.Errorf("token encryption key(%s) not found", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted token length")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("decrypt token with key(%s): %w", keyID, err)
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,221 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/common/model"
+)
+
+func newTestTokenCipher(t *testing.T, activeKey string, keyIds ...string) *tokenCipher {
+	keys := make(map[string][]byte, len(keyIds))
+	for i, id := range keyIds {
+		keys[id] = bytes.Repeat([]byte{byte('a' + i)}, 32)
+	}
+	tc, err := newTokenCipher(activeKey, keys)
+	assert.NoError(t, err)
+	return tc
+}
+
+func Test_tokenCipher_EncryptDecrypt(t *testing.T) {
+	t.Run("加解密往返", func(t *testing.T) {
+		tc := newTestTokenCipher(t, "k1", "k1")
+		encrypted, err := tc.Encrypt("polaris-token")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(encrypted, "enc:v2:k1:"))
+		assert.NotContains(t, encrypted, "polaris-token")
+		assert.LessOrEqual(t, len(encrypted), 255)
+
+		// 同一个 key 下加密结果固定，保证 token 唯一索引有效
+		again, err := tc.Encrypt("polaris-token")
+		assert.NoError(t, err)
+		assert.Equal(t, encrypted, again)
+
+		plain, err := tc.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "polaris-token", plain)
+	})
+
+	t.Run("轮换key后可以解密旧key加密的数据", func(t *testing.T) {
+		old := newTestTokenCipher(t, "k1", "k1")
+		encrypted, err := old.Encrypt("polaris-token")
+		assert.NoError(t, err)
+
+		rotated := newTestTokenCipher(t, "k2", "k1", "k2")
+		plain, err := rotated.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "polaris-token", plain)
+
+		reEncrypted, err := rotated.Encrypt(plain)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(reEncrypted, "enc:v2:k2:"))
+	})
+
+	t.Run("nonce使用派生的密钥计算", func(t *testing.T) {
+		tc := newTestTokenCipher(t, "k1", "k1", "k2")
+		aesKey := bytes.Repeat([]byte{'a'}, 32)
+		assert.NotEqual(t, aesKey, tc.nonceKeys["k1"])
+		assert.NotEqual(t, tc.nonceKeys["k1"], tc.nonceKeys["k2"])
+
+		encrypted, err := tc.Encrypt("polaris-token")
+		assert.NoError(t, err)
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, "enc:v2:k1:"))
+		assert.NoError(t, err)
+		nonceSize := tc.aeads["k1"].NonceSize()
+		mac := hmac.New(sha256.New, tc.nonceKeys["k1"])
+		_, _ = mac.Write([]byte("polaris-token"))
+		assert.Equal(t, mac.Sum(nil)[:nonceSize], sealed[:nonceSize])
+		legacy := hmac.New(sha256.New, aesKey)
+		_, _ = legacy.Write([]byte("polaris-token"))
+		assert.NotEqual(t, legacy.Sum(nil)[:nonceSize], sealed[:nonceSize])
+	})
+
+	t.Run("可以解密v1版本加密的数据", func(t *testing.T) {
+		tc := newTestTokenCipher(t, "k1", "k1")
+		aesKey := bytes.Repeat([]byte{'a'}, 32)
+		aead := tc.aeads["k1"]
+		mac := hmac.New(sha256.New, aesKey)
+		_, _ = mac.Write([]byte("polaris-token"))
+		nonce := mac.Sum(nil)[:aead.NonceSize()]
+		legacy := "enc:v1:k1:" + base64.StdEncoding.EncodeToString(
+			aead.Seal(nonce, nonce, []byte("polaris-token"), []byte("k1")))
+
+		plain, err := tc.Decrypt(legacy)
+		assert.NoError(t, err)
+		assert.Equal(t, "polaris-token", plain)
+	})
+
+	t.Run("未知的key", func(t *testing.T) {
+		old := newTestTokenCipher(t, "k1", "k1")
+		encrypted, err := old.Encrypt("polaris-token")
+		assert.NoError(t, err)
+
+		_, err = newTestTokenCipher(t, "k2", "k2").Decrypt(encrypted)
+		assert.Error(t, err)
+		_, err = (*tokenCipher)(nil).Decrypt(encrypted)
+		assert.Error(t, err)
+	})
+
+	t.Run("明文token原样返回", func(t *testing.T) {
+		plain, err := newTestTokenCipher(t, "k1", "k1").Decrypt("polaris-token")
+		assert.NoError(t, err)
+		assert.Equal(t, "polaris-token", plain)
+
+		var tc *tokenCipher
+		encrypted, err := tc.Encrypt("polaris-token")
+		assert.NoError(t, err)
+		assert.Equal(t, "polaris-token", encrypted)
+	})
+}
+
+func Test_parseTokenCipher(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{'a'}, 32))
+
+	tc, err := parseTokenCipher(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tc)
+
+	tc, err = parseTokenCipher(map[interface{}]interface{}{
+		"activeKey": "k1",
+		"keys":      map[interface{}]interface{}{"k1": key},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", tc.activeKey)
+
+	_, err = parseTokenCipher(map[interface{}]interface{}{
+		"activeKey": "k2",
+		"keys":      map[interface{}]interface{}{"k1": key},
+	})
+	assert.Error(t, err)
+
+	_, err = parseTokenCipher(map[interface{}]interface{}{
+		"activeKey": "k1",
+		"keys":      map[interface{}]interface{}{"k1": "short"},
+	})
+	assert.Error(t, err)
+}
+
+func Test_userStore_EncryptedToken(t *testing.T) {
+	t.Run("写入时加密", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.tokenCipher = newTestTokenCipher(t, "k1", "k1")
+		encrypted, err := us.tokenCipher.Encrypt("t")
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).
+			WithArgs("p", "p", encrypted, "t", "p", encrypted, "", 0, "", "", "", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		user := &model.User{ID: "u1", Name: "u1", Token: "t", Password: "p"}
+		assert.NoError(t, us.UpdateUser(user))
+		assert.Equal(t, "t", user.Token)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("轮换key后旧key加密的token不再与新的密文相等", func(t *testing.T) {
+		// 已知限制：等值比较以及唯一索引只匹配 activeKey 加密的数据，旧 key 加密的行在下一次写入时重新加密，
+		// 因此即使 token 没有变化，token_rotated_time 也会在这一次写入时刷新
+		us, mock := newTestUserStore(t)
+		stored, err := newTestTokenCipher(t, "k1", "k1").Encrypt("t")
+		assert.NoError(t, err)
+		us.tokenCipher = newTestTokenCipher(t, "k2", "k1", "k2")
+		encrypted, err := us.tokenCipher.Encrypt("t")
+		assert.NoError(t, err)
+		assert.NotEqual(t, stored, encrypted)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).
+			WithArgs("p", "p", encrypted, "t", "p", encrypted, "", 0, "", "", "", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		user := &model.User{ID: "u1", Name: "u1", Token: "t", Password: "p"}
+		assert.NoError(t, us.UpdateUser(user))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("读取时解密", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		encrypted, err := newTestTokenCipher(t, "k1", "k1").Encrypt("t")
+		assert.NoError(t, err)
+		us.tokenCipher = newTestTokenCipher(t, "k2", "k1", "k2")
+
+		mock.ExpectQuery(`FROM user u`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time",
+				"password_set_time", "must_change_password", "deleted_at", "created_by", "token_rotated_time"}).
+				AddRow("u1", "u1", "", "", "", "Polaris", encrypted, 1, 20, 1600000000, 1600000000, 0,
+					"", "", 0, 0, 0, 0, "", 0))
+
+		user, err := us.GetUser("u1")
+		assert.NoError(t, err)
+		assert.Equal(t, "t", user.Token)
+	})
+}
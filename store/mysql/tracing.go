@@ -0,0 +1,99 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+const (
+	// traceAttrSystem span 中记录数据库类型的属性
+	traceAttrSystem = "db.system"
+	// traceAttrOperation span 中记录存储层方法名的属性
+	traceAttrOperation = "db.operation"
+	// traceAttrStatement span 中记录 SQL 语句的属性
+	traceAttrStatement = "db.statement"
+)
+
+// sqlStringLiteral SQL 中的字符串字面量，记录到 span 前替换为占位符
+var sqlStringLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`)
+
+// Tracer 存储层链路追踪的扩展点，可以基于 OpenTelemetry 等实现适配，需要并发安全
+type Tracer interface {
+	// Start 创建名称为 name 的 span，ctx 中存在 span 时作为其子 span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 一次调用对应的 span
+type Span interface {
+	// SetAttribute 设置 span 的属性
+	SetAttribute(key, value string)
+	// RecordError 记录错误并将 span 的状态置为失败
+	RecordError(err error)
+	// End 结束 span
+	End()
+}
+
+// traceSpan 对 Span 的封装，未开启链路追踪时为 nil，所有方法都可以在 nil 上调用
+type traceSpan struct {
+	ctx    context.Context
+	tracer Tracer
+	span   Span
+}
+
+// startTraceSpan 创建 span，tracer 为 nil 时返回 nil
+func startTraceSpan(ctx context.Context, tracer Tracer, name string) *traceSpan {
+	if tracer == nil {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	spanCtx, span := tracer.Start(ctx, name)
+	span.SetAttribute(traceAttrSystem, "mysql")
+	return &traceSpan{ctx: spanCtx, tracer: tracer, span: span}
+}
+
+// statement 为一条 SQL 语句创建子 span
+func (s *traceSpan) statement(name, query string) *traceSpan {
+	if s == nil {
+		return nil
+	}
+	child := startTraceSpan(s.ctx, s.tracer, name)
+	child.span.SetAttribute(traceAttrStatement, sanitizeStatement(query))
+	return child
+}
+
+// finish 记录调用结果并结束 span
+func (s *traceSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+	}
+	s.span.End()
+}
+
+// sanitizeStatement 去除 SQL 中的字符串字面量并合并空白字符，参数均通过占位符传递，不会记录到 span 中
+func sanitizeStatement(query string) string {
+	query = sqlStringLiteral.ReplaceAllString(query, "?")
+	return strings.Join(strings.Fields(query), " ")
+}
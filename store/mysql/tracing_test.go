@@ -0,0 +1,177 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/store"
+)
+
+type fakeSpanKey struct{}
+
+type fakeSpan struct {
+	tracer *fakeTracer
+	name   string
+	parent string
+	attrs  map[string]string
+	err    error
+	ended  bool
+}
+
+func (s *fakeSpan) SetAttribute(key, value string) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *fakeSpan) RecordError(err error) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.err = err
+}
+
+func (s *fakeSpan) End() {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.ended = true
+}
+
+// fakeTracer 按照创建顺序记录全部的 span
+type fakeTracer struct {
+	lock  sync.Mutex
+	spans []*fakeSpan
+}
+
+func (f *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &fakeSpan{tracer: f, name: name, attrs: map[string]string{}}
+	if parent, ok := ctx.Value(fakeSpanKey{}).(*fakeSpan); ok {
+		span.parent = parent.name
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.spans = append(f.spans, span)
+	return context.WithValue(ctx, fakeSpanKey{}, span), span
+}
+
+func (f *fakeTracer) names() []string {
+	names := make([]string, 0, len(f.spans))
+	for _, span := range f.spans {
+		names = append(names, span.name)
+	}
+	return names
+}
+
+func Test_userStore_Tracing(t *testing.T) {
+	resetSql := `UPDATE user SET +password_set_time = IF\(password = \?, password_set_time, sysdate\(\)\), +` +
+		`must_change_password = IF\(password = \?, must_change_password, 0\), +` +
+		`token_rotated_time = IF\(token = \? OR token = \?, token_rotated_time, sysdate\(\)\), +` +
+		`password = \?, token = \?, mtime = sysdate\(\), modified_by = \? WHERE id = \? AND flag = 0`
+
+	t.Run("单条语句", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		tracer := &fakeTracer{}
+		us.tracer = tracer
+		mock.ExpectExec(`UPDATE user SET last_login_time = sysdate\(\), mtime = mtime WHERE id = \? AND flag = 0`).
+			WithArgs("u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, us.UpdateLastLogin("u1"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, []string{"UserStore.UpdateLastLogin", "mysql.Exec"}, tracer.names())
+		op, stmt := tracer.spans[0], tracer.spans[1]
+		assert.Equal(t, "UpdateLastLogin", op.attrs[traceAttrOperation])
+		assert.Equal(t, "mysql", op.attrs[traceAttrSystem])
+		assert.Equal(t, "UserStore.UpdateLastLogin", stmt.parent)
+		assert.Equal(t, "UPDATE user SET last_login_time = sysdate(), mtime = mtime WHERE id = ? AND flag = 0",
+			stmt.attrs[traceAttrStatement])
+		for _, span := range tracer.spans {
+			assert.True(t, span.ended, span.name)
+			assert.NoError(t, span.err, span.name)
+		}
+	})
+
+	t.Run("事务内的语句", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		tracer := &fakeTracer{}
+		us.tracer = tracer
+		mock.ExpectBegin()
+		mock.ExpectExec(resetSql).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.ResetUserCredentials("u1", "new-pwd", "new-token", "admin"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, []string{"UserStore.ResetUserCredentials", "mysql.Begin", "mysql.Tx.Exec",
+			"mysql.Tx.Commit"}, tracer.names())
+		for _, span := range tracer.spans[1:] {
+			assert.Equal(t, "UserStore.ResetUserCredentials", span.parent, span.name)
+			assert.True(t, span.ended, span.name)
+			assert.NoError(t, span.err, span.name)
+		}
+		assert.NotContains(t, tracer.spans[2].attrs[traceAttrStatement], "new-pwd")
+	})
+
+	t.Run("语句失败", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		tracer := &fakeTracer{}
+		us.tracer = tracer
+		mock.ExpectBegin()
+		mock.ExpectExec(resetSql).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'new-token' for key 'user.token'"))
+		mock.ExpectRollback()
+
+		err := us.ResetUserCredentials("u1", "new-pwd", "new-token", "admin")
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, []string{"UserStore.ResetUserCredentials", "mysql.Begin", "mysql.Tx.Exec"}, tracer.names())
+		assert.Equal(t, store.DataConflictErr, store.Code(tracer.spans[0].err))
+		assert.Error(t, tracer.spans[2].err)
+		for _, span := range tracer.spans {
+			assert.True(t, span.ended, span.name)
+		}
+	})
+
+	t.Run("链接调用方的请求上下文", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		tracer := &fakeTracer{}
+		us.tracer = tracer
+		mock.ExpectQuery(`SELECT .* FROM user u WHERE u.flag = 0 AND u.id IN \(\?\)`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		ctx, _ := tracer.Start(context.Background(), "request")
+		_, _ = us.GetUserByIdsWithContext(ctx, []string{"u1"})
+
+		assert.Equal(t, []string{"request", "UserStore.GetUserByIdsWithContext", "mysql.Query"}, tracer.names())
+		assert.Equal(t, "request", tracer.spans[1].parent)
+		assert.Equal(t, "UserStore.GetUserByIdsWithContext", tracer.spans[2].parent)
+	})
+}
+
+func Test_sanitizeStatement(t *testing.T) {
+	assert.Equal(t, "SELECT id FROM user WHERE name = ? AND owner = ?",
+		sanitizeStatement("SELECT id FROM user\n\t WHERE name = 'it''s \\'me' AND owner = ?"))
+}
@@ -0,0 +1,74 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/polarismesh/polaris/store"
+)
+
+// txGate 限制同时执行的写事务个数，避免突发的大量写请求耗尽数据库连接导致其他请求跟着超时
+// 名额只在当前节点内计数，集群总的写事务个数为各节点上限之和
+type txGate struct {
+	// slots 容量为允许同时执行的写事务个数
+	slots chan struct{}
+	// wait 没有空闲名额时排队等待的最长时长，小于等于 0 时直接返回 store.Busy
+	wait time.Duration
+}
+
+// newTxGate limit 小于等于 0 时不限制写事务个数，返回 nil
+func newTxGate(limit int, wait time.Duration) *txGate {
+	if limit <= 0 {
+		return nil
+	}
+	return &txGate{slots: make(chan struct{}, limit), wait: wait}
+}
+
+// acquire 获取一个写事务名额，成功后需要调用 release 归还，名额已满且等待超时时返回 store.Busy
+func (g *txGate) acquire(label string) error {
+	if g == nil {
+		return nil
+	}
+	select {
+	case g.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if g.wait > 0 {
+		timer := time.NewTimer(g.wait)
+		defer timer.Stop()
+		select {
+		case g.slots <- struct{}{}:
+			return nil
+		case <-timer.C:
+		}
+	}
+	log.Warnf("[Store][database] %s rejected, %d write transactions are running", label, cap(g.slots))
+	return store.NewStatusError(store.Busy, fmt.Sprintf(
+		"%s rejected, too many concurrent write transactions", label))
+}
+
+// release 归还 acquire 获取的名额
+func (g *txGate) release() {
+	if g == nil {
+		return
+	}
+	<-g.slots
+}
@@ -0,0 +1,112 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris/store"
+)
+
+func Test_userStore_WriteGate(t *testing.T) {
+	newStore := func(t *testing.T, limit int, wait time.Duration) (*userStore, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		return &userStore{master: &BaseDB{DB: db}, slave: &BaseDB{DB: db},
+			writeGate: newTxGate(limit, wait)}, mock
+	}
+	expectWrite := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET token_enable = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	t.Run("名额已满时直接拒绝", func(t *testing.T) {
+		us, mock := newStore(t, 1, 0)
+		assert.NoError(t, us.writeGate.acquire("test"))
+
+		_, err := us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+		assert.Error(t, err)
+		assert.Equal(t, store.Busy, store.Code(err))
+
+		// 名额归还后恢复写入
+		us.writeGate.release()
+		expectWrite(mock)
+		_, err = us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("排队等待空闲名额", func(t *testing.T) {
+		us, mock := newStore(t, 1, time.Second)
+		assert.NoError(t, us.writeGate.acquire("test"))
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			us.writeGate.release()
+		}()
+
+		expectWrite(mock)
+		_, err := us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("排队超时", func(t *testing.T) {
+		us, mock := newStore(t, 1, 20*time.Millisecond)
+		assert.NoError(t, us.writeGate.acquire("test"))
+		defer us.writeGate.release()
+
+		start := time.Now()
+		_, err := us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+		assert.Equal(t, store.Busy, store.Code(err))
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("事务结束后归还名额", func(t *testing.T) {
+		us, mock := newStore(t, 1, 0)
+		for i := 0; i < 3; i++ {
+			expectWrite(mock)
+			_, err := us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+			assert.NoError(t, err)
+		}
+		mock.ExpectBegin().WillReturnError(sqlmock.ErrCancelled)
+		_, err := us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+		assert.Error(t, err)
+		assert.NotEqual(t, store.Busy, store.Code(err))
+
+		expectWrite(mock)
+		_, err = us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("未配置上限时不限制", func(t *testing.T) {
+		us, mock := newStore(t, 0, 0)
+		assert.Nil(t, us.writeGate)
+		expectWrite(mock)
+		_, err := us.SetUsersTokenEnable([]string{"u1"}, false, "admin")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -18,19 +18,72 @@
 package sqldb
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
 	apisecurity "github.com/polarismesh/specification/source/go/api/v1/security"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/polarismesh/polaris/common/model"
 	"github.com/polarismesh/polaris/common/utils"
 	"github.com/polarismesh/polaris/store"
 )
 
+const (
+	// cleanInValidUserSql 清理同名的已删除用户，%s 为匹配名称使用的字段
+	cleanInValidUserSql = "delete from user where %s = ? and owner = ? and flag = 1"
+	// archiveInValidUserSql 将即将清理的已删除用户复制到 user_archive，不包含密码以及 token，需要拼接用户名称的条件
+	archiveInValidUserSql = "INSERT INTO user_archive(`id`, `name`, `owner`, `source`, `mobile`, `email`, " +
+		"`user_type`, `comment`, `ctime`, `mtime`, `deleted_at`, `archived_at`) " +
+		"SELECT id, name, owner, source, mobile, email, user_type, comment, ctime, mtime, deleted_at, sysdate() " +
+		"FROM user WHERE flag = 1 AND "
+	// batchInsertSize 批量写入时单条多行 INSERT 的最大行数
+	batchInsertSize = 500
+	// batchQuerySize 按照 ID 批量查询时单条 SQL 中 IN 的最大 ID 个数
+	batchQuerySize = 1000
+	// subAccountCleanBatch 级联删除子账户时每批处理的子账户个数
+	subAccountCleanBatch = 100
+
+	// LastLoginBeforeAttribute 查询在指定时间（unix 秒）之前最后一次登录的用户
+	LastLoginBeforeAttribute string = "last_login_before"
+	// InactiveSinceAttribute 查询最近指定天数内没有登录过的用户
+	InactiveSinceAttribute string = "inactive_since"
+	// NeverLoginInactiveAttribute 按照登录时间过滤时从未登录过的用户是否视为不活跃，默认为 true
+	NeverLoginInactiveAttribute string = "never_login_inactive"
+	// HasGroupAttribute 按照用户是否加入了任意一个有效的用户组进行过滤，取值为 true 或者 false
+	HasGroupAttribute string = "has_group"
+
+	// userListColumns 用户列表查询的列，{p} 替换为 user 表列名的前缀
+	userListColumns = "{p}id, {p}name, {p}password, {p}owner, {p}comment, {p}source, {p}token, {p}token_enable, " +
+		"{p}user_type, UNIX_TIMESTAMP({p}ctime), UNIX_TIMESTAMP({p}mtime), {p}flag, {p}mobile, {p}email, " +
+		"IFNULL(UNIX_TIMESTAMP({p}last_login_time), 0), IFNULL(UNIX_TIMESTAMP({p}password_set_time), 0), " +
+		"{p}must_change_password, IFNULL(UNIX_TIMESTAMP({p}deleted_at), 0)"
+	// userDetailColumns 查询单个用户详情时追加在 userListColumns 之后的列
+	userDetailColumns = ", u.created_by, IFNULL(UNIX_TIMESTAMP(u.token_rotated_time), 0)"
+	// ownerSubtreeQuery 递归查询 owner 下所有层级的有效子账户 ID，只沿着有效的用户展开
+	ownerSubtreeQuery = "WITH RECURSIVE sub_user (id) AS (" +
+		" SELECT id FROM user WHERE owner = ? AND id != owner AND flag = 0" +
+		" UNION SELECT c.id FROM user c INNER JOIN sub_user s ON c.owner = s.id WHERE c.id != c.owner AND c.flag = 0" +
+		") SELECT id FROM sub_user"
+	// groupNameSubQuery 名称为指定值的有效用户组下的用户 ID
+	groupNameSubQuery = "SELECT gnr.user_id FROM user_group_relation gnr " +
+		" INNER JOIN user_group gn ON gn.id = gnr.group_id " +
+		" WHERE gnr.flag = 0 AND gn.flag = 0 AND gn.name = ?"
+	// tokenRotatedTimeSet token 发生变化时记录更换时间，参数依次为加密后以及明文的新 token
+	// 存量的 token 可能是明文存储的，两者任意一个与当前的取值相同时都视为没有变化
+	tokenRotatedTimeSet = " token_rotated_time = IF(token = ? OR token = ?, token_rotated_time, sysdate()), "
+	// hasGroupSubQuery 用户存在有效的用户组关联关系
+	hasGroupSubQuery = " EXISTS (SELECT 1 FROM user_group_relation ugr " +
+		" INNER JOIN user_group ug ON ug.id = ugr.group_id " +
+		" WHERE ugr.user_id = user.id AND ugr.flag = 0 AND ug.flag = 0) "
+)
+
 var (
 	// 用户查询相关属性对应关系
 	userAttributeMapping = map[string]string{
@@ -48,30 +101,375 @@ var (
 	}
 )
 
+var (
+	_ store.UserReader = (*userStore)(nil)
+	_ store.UserWriter = (*userStore)(nil)
+)
+
 type userStore struct {
 	master *BaseDB
 	slave  *BaseDB
+	// tokenCipher 为 nil 时 token 明文存储
+	tokenCipher *tokenCipher
+	// cacheExcludePassword GetUsersForCache 不加载用户密码
+	cacheExcludePassword bool
+	// cacheExcludeToken GetUsersForCache 不加载用户 token，仅在不使用缓存进行 token 校验时开启
+	cacheExcludeToken bool
+	// cacheProjection GetUsersForCache 只查询缓存需要的字段，减少大批量增量同步时的数据传输
+	cacheProjection bool
+	// cacheWithGroups GetUsersForCache 同时返回用户所属的有效用户组 ID
+	cacheWithGroups bool
+	// reuseDefaultStrategy 同名的默认策略属于其他用户时复用该策略，否则返回 DataConflictErr
+	reuseDefaultStrategy bool
+	// changeLog 在 user_change_log 中记录用户的变更
+	changeLog bool
+	// changeCompact 读取用户变更时，同一个用户的多条变更只返回最后一条
+	changeCompact bool
+	// nameCaseInsensitive 按照名称查找以及清理同名用户时忽略大小写，匹配 name_lower 字段
+	nameCaseInsensitive bool
+	// queryConcurrency 按照 ID 批量查询用户时并发执行的分批个数，小于等于 1 时串行查询
+	queryConcurrency int
+	// consistency 写入后的一段时间内读主库，与 groupStore 共享
+	consistency *readAfterWrite
+	// archiveInvalidUser 清理同名的已删除用户前先将其归档到 user_archive，保留审计记录
+	archiveInvalidUser bool
+	// queryMaxOffset 分页查询用户时允许的最大 offset，为 0 时使用 store.DefaultUserQueryMaxOffset
+	queryMaxOffset uint32
+	// queryGuard 拦截未设置任何过滤条件的用户列表查询，零值时不拦截
+	queryGuard store.UserQueryGuard
+	// passwordAge 两次修改密码之间的最短间隔，零值时不限制
+	passwordAge store.PasswordAgePolicy
+	// writeGate 限制同时执行的写事务个数，为 nil 时不限制
+	writeGate *txGate
+	// cascadeSubAccounts 删除主账户时级联删除其子账户，并清理子账户的默认策略以及鉴权关联关系
+	cascadeSubAccounts bool
+	// cleanInvalidOnConflict 添加单个用户时不再预先清理同名的已删除用户，只在写入与其冲突时清理后重新写入
+	cleanInvalidOnConflict bool
+	// recursiveCTE 数据库支持 WITH RECURSIVE，按照 owner 递归查询子账户树时依赖该能力
+	recursiveCTE bool
+	// tracer 为 nil 时不开启链路追踪
+	tracer Tracer
+	// trace 当前调用所属的 span，仅在 traceOp 返回的 userStore 上设置
+	trace *traceSpan
+}
+
+// traceOp 开启链路追踪时为存储层方法 op 创建 span，ctx 为调用方的请求上下文，嵌套调用时作为当前 span 的子 span
+// 返回的 userStore 在该 span 下为每条语句创建子 span，未开启链路追踪时返回自身
+func (u *userStore) traceOp(ctx context.Context, op string) (*userStore, *traceSpan) {
+	if u.tracer == nil {
+		return u, nil
+	}
+	if u.trace != nil {
+		ctx = u.trace.ctx
+	}
+	span := startTraceSpan(ctx, u.tracer, "UserStore."+op)
+	span.span.SetAttribute(traceAttrOperation, op)
+
+	traced := *u
+	traced.master = u.master.withTrace(span)
+	traced.slave = u.slave.withTrace(span)
+	traced.trace = span
+	return &traced, span
+}
+
+// retryTransaction 获取写事务名额后通过 RetryTransaction 执行 handle，重试期间一直占用该名额
+func (u *userStore) retryTransaction(label string, handle func() error) error {
+	if err := u.writeGate.acquire(label); err != nil {
+		return err
+	}
+	defer u.writeGate.release()
+	return RetryTransaction(label, handle)
+}
+
+// writeTransaction 获取写事务名额后在主库的事务中执行 handle
+func (u *userStore) writeTransaction(label string, handle func(*BaseTx) error) error {
+	if err := u.writeGate.acquire(label); err != nil {
+		return err
+	}
+	defer u.writeGate.release()
+	return u.master.processWithTransaction(label, handle)
+}
+
+// readDB 原本读只读库的请求使用的数据库
+func (u *userStore) readDB() *BaseDB {
+	return u.consistency.route(u.master, u.slave)
+}
+
+// queryDB 用户列表查询使用的数据库，ReadEventual 时与 readDB 一致，否则读主库
+func (u *userStore) queryDB(query *store.UserQuery) *BaseDB {
+	if query.Consistency == store.ReadEventual {
+		return u.readDB()
+	}
+	return u.master
 }
 
 // AddUser 添加用户
-func (u *userStore) AddUser(user *model.User) error {
+func (u *userStore) AddUser(user *model.User) (err error) {
+	u, span := u.traceOp(context.Background(), "AddUser")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
 	if user.ID == "" || user.Name == "" || user.Token == "" || user.Password == "" {
 		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
 			"add user missing some params, id is %s, name is %s", user.ID, user.Name))
 	}
+	if err := store.CheckUserOwner(user); err != nil {
+		return err
+	}
 
 	// 先清理无效数据
-	if err := u.cleanInValidUser(user.Name, user.Owner); err != nil {
-		return err
+	if !u.cleanInvalidOnConflict {
+		if err := u.cleanInValidUser(user.Name, user.Owner); err != nil {
+			return err
+		}
 	}
 
-	err := RetryTransaction("addUser", func() error {
+	err = u.retryTransaction("addUser", func() error {
 		return u.addUser(user)
 	})
+	if err == nil {
+		logUserOp("AddUser", "[Store][User] add user", zap.String("id", user.ID), zap.String("name", user.Name))
+	}
+
+	return store.Error(err)
+}
+
+// AddUserAndReturn 添加用户，并在同一个事务中读取写入后的用户，返回包含创建、修改时间等数据库生成字段的完整信息
+func (u *userStore) AddUserAndReturn(user *model.User) (_ *model.User, err error) {
+	u, span := u.traceOp(context.Background(), "AddUserAndReturn")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if user.ID == "" || user.Name == "" || user.Token == "" || user.Password == "" {
+		return nil, store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"add user missing some params, id is %s, name is %s", user.ID, user.Name))
+	}
+	if err := store.CheckUserOwner(user); err != nil {
+		return nil, err
+	}
+
+	// 先清理无效数据
+	if !u.cleanInvalidOnConflict {
+		if err := u.cleanInValidUser(user.Name, user.Owner); err != nil {
+			return nil, err
+		}
+	}
+
+	var created *model.User
+	err = u.retryTransaction("addUser", func() error {
+		var err error
+		created, err = u.addUserAndReturn(user)
+		return err
+	})
+	if err != nil {
+		return nil, store.Error(err)
+	}
+	logUserOp("AddUser", "[Store][User] add user", zap.String("id", user.ID), zap.String("name", user.Name))
+	return created, nil
+}
+
+func (u *userStore) addUserAndReturn(user *model.User) (*model.User, error) {
+	tx, err := u.master.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	if err := u.addUserTx(tx, user); err != nil {
+		return nil, err
+	}
+	created, err := u.getCreatedUser(tx, user.ID)
+	if err != nil {
+		log.Error("[Store][User] load created user", zap.String("id", user.ID), zap.Error(err))
+		return nil, err
+	}
 
+	if err := tx.Commit(); err != nil {
+		log.Errorf("[Store][User] add user tx commit err: %s", err.Error())
+		return nil, err
+	}
+	return created, nil
+}
+
+// getCreatedUser 在写入用户的事务中读取用户，不受只读库同步延迟的影响
+func (u *userStore) getCreatedUser(tx *BaseTx, id string) (*model.User, error) {
+	getSql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "") + " FROM user WHERE id = ?"
+	rows, err := tx.Query(getSql, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, store.NewStatusError(store.NotFoundUser, fmt.Sprintf("created user(%s) not found", id))
+	}
+	user, err := fetchRown2User(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.decryptToken(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// AddUserTx 在外部事务中添加用户
+func (u *userStore) AddUserTx(tx store.Tx, user *model.User) (err error) {
+	u, span := u.traceOp(context.Background(), "AddUserTx")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if tx == nil {
+		return ErrTxIsNil
+	}
+	if user.ID == "" || user.Name == "" || user.Token == "" || user.Password == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"add user missing some params, id is %s, name is %s", user.ID, user.Name))
+	}
+	if err := store.CheckUserOwner(user); err != nil {
+		return err
+	}
+
+	dbTx := tx.GetDelegateTx().(*BaseTx)
+	if !u.cleanInvalidOnConflict {
+		if _, err := u.cleanInValidUserTx(dbTx, user.Name, user.Owner); err != nil {
+			log.Errorf("[Store][User] clean user(%s) err: %s", user.Name, err.Error())
+			return store.Error(err)
+		}
+	}
+	return u.addUserTx(dbTx, user)
+}
+
+// BatchAddUser 在同一个事务中批量添加用户，用户及其默认策略均使用多行 INSERT 写入
+func (u *userStore) BatchAddUser(users []*model.User) (err error) {
+	u, span := u.traceOp(context.Background(), "BatchAddUser")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	for _, user := range users {
+		if user.ID == "" || user.Name == "" || user.Token == "" || user.Password == "" {
+			return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+				"batch add user missing some params, id is %s, name is %s", user.ID, user.Name))
+		}
+		if err := store.CheckUserOwner(user); err != nil {
+			return err
+		}
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	err = u.retryTransaction("batchAddUser", func() error {
+		return u.batchAddUser(users)
+	})
+	if err == nil {
+		logUserOp("BatchAddUser", "[Store][User] batch add user", zap.Int("count", len(users)))
+	}
 	return store.Error(err)
 }
 
+// BatchAddUsersBestEffort 逐个添加用户，每个用户及其默认策略在单独的事务中写入，某个用户失败时不影响其他用户
+// 返回的结果与 users 一一对应
+func (u *userStore) BatchAddUsersBestEffort(users []*model.User) []store.BatchItemResult {
+	u, span := u.traceOp(context.Background(), "BatchAddUsersBestEffort")
+	defer func() { span.finish(nil) }()
+
+	results, failed := store.BestEffortUsers(users, u.AddUser)
+	logUserOp("BatchAddUsersBestEffort", "[Store][User] batch add user best effort", zap.Int("count", len(users)),
+		zap.Int("failed", failed))
+	return results
+}
+
+func (u *userStore) batchAddUser(users []*model.User) error {
+	tx, err := u.master.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	principals := make([]defaultStrategyPrincipal, 0, len(users))
+	for start := 0; start < len(users); start += batchInsertSize {
+		chunk := users[start:min(start+batchInsertSize, len(users))]
+		if err := u.batchAddUserTx(tx, chunk); err != nil {
+			return err
+		}
+		for _, user := range chunk {
+			principals = append(principals, defaultStrategyPrincipal{ID: user.ID, Name: user.Name, Owner: user.Owner})
+		}
+	}
+
+	if err := createDefaultStrategies(tx, model.PrincipalUser, principals, u.reuseDefaultStrategy); err != nil {
+		log.Error("[Auth][User] batch create default strategy", zap.Error(err))
+		return store.Error(err)
+	}
+
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	if err := u.recordUserChanges(tx, model.UserChangeCreate, ids); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Errorf("[Store][User] batch add user tx commit err: %s", err.Error())
+		return store.Error(err)
+	}
+	return nil
+}
+
+// batchAddUserTx 清理（开启了 archiveInvalidUser 时先归档）同名的无效用户后，使用一条多行 INSERT 写入用户
+func (u *userStore) batchAddUserTx(tx *BaseTx, users []*model.User) error {
+	cleanArgs := make([]interface{}, 0, 2*len(users))
+	addArgs := make([]interface{}, 0, 15*len(users))
+	tokens := make([]string, 0, len(users))
+	for _, user := range users {
+		token, err := u.tokenCipher.Encrypt(user.Token)
+		if err != nil {
+			log.Error("[Store][User] encrypt user token", zap.String("id", user.ID), zap.Error(err))
+			return store.Error(err)
+		}
+		tokens = append(tokens, token)
+		_, name := u.userNameKey(user.Name)
+		cleanArgs = append(cleanArgs, name, user.Owner)
+		addArgs = append(addArgs, user.ID, user.Name, strings.ToLower(user.Name), user.Password, user.Owner,
+			user.Source, token, user.Comment, 0, user.Type, user.Mobile, user.Email, boolToInt(user.MustChangePassword),
+			user.CreatedBy, user.CreatedBy)
+	}
+
+	nameColumn, _ := u.userNameKey("")
+	nameCond := "(" + nameColumn + ", owner) IN (" + repeatPlaceholders("(?,?)", len(users)) + ")"
+	if u.archiveInvalidUser {
+		if _, err := tx.Exec(archiveInValidUserSql+nameCond, cleanArgs...); err != nil {
+			log.Errorf("[Store][User] batch archive user err: %s", err.Error())
+			return store.Error(err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM user WHERE flag = 1 AND "+nameCond, cleanArgs...); err != nil {
+		log.Errorf("[Store][User] batch clean user err: %s", err.Error())
+		return store.Error(err)
+	}
+
+	addSql := "INSERT INTO user(`id`, `name`, `name_lower`, `password`, `owner`, `source`, `token`, " +
+		" `comment`, `flag`, `user_type`, " +
+		" `ctime`, `mtime`, `mobile`, `email`, `password_set_time`, `must_change_password`, `created_by`, " +
+		" `modified_by`, `token_rotated_time`) VALUES " +
+		repeatPlaceholders("(?,?,?,?,?,?,?,?,?,?,sysdate(),sysdate(),?,?,sysdate(),?,?,?,sysdate())", len(users))
+	if _, err := tx.Exec(addSql, addArgs...); err != nil {
+		return convertBatchUserTokenConflict(users, tokens, err)
+	}
+	return nil
+}
+
 func (u *userStore) addUser(user *model.User) error {
 
 	tx, err := u.master.Begin()
@@ -81,26 +479,61 @@ func (u *userStore) addUser(user *model.User) error {
 
 	defer func() { _ = tx.Rollback() }()
 
-	addSql := "INSERT INTO user(`id`, `name`, `password`, `owner`, `source`, `token`, " +
+	if err := u.addUserTx(tx, user); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Errorf("[Store][User] add user tx commit err: %s", err.Error())
+		return store.Error(err)
+	}
+	return nil
+}
+
+func (u *userStore) addUserTx(tx *BaseTx, user *model.User) error {
+	addSql := "INSERT INTO user(`id`, `name`, `name_lower`, `password`, `owner`, `source`, `token`, " +
 		" `comment`, `flag`, `user_type`, " +
-		" `ctime`, `mtime`, `mobile`, `email`) VALUES (?,?,?,?,?,?,?,?,?,sysdate(),sysdate(),?,?)"
+		" `ctime`, `mtime`, `mobile`, `email`, `password_set_time`, `must_change_password`, `created_by`, " +
+		" `modified_by`, `token_rotated_time`) VALUES (?,?,?,?,?,?,?,?,?,?,sysdate(),sysdate(),?,?,sysdate(),?,?,?," +
+		"sysdate())"
+
+	token, err := u.tokenCipher.Encrypt(user.Token)
+	if err != nil {
+		log.Error("[Store][User] encrypt user token", zap.String("id", user.ID), zap.Error(err))
+		return store.Error(err)
+	}
 
-	_, err = tx.Exec(addSql, []interface{}{
+	addArgs := []interface{}{
 		user.ID,
 		user.Name,
+		strings.ToLower(user.Name),
 		user.Password,
 		user.Owner,
 		user.Source,
-		user.Token,
+		token,
 		user.Comment,
 		0,
 		user.Type,
 		user.Mobile,
 		user.Email,
-	}...)
-
+		boolToInt(user.MustChangePassword),
+		user.CreatedBy,
+		user.CreatedBy,
+	}
+	_, err = tx.Exec(addSql, addArgs...)
+	if err != nil && u.cleanInvalidOnConflict && isUserNameConflict(err) {
+		// 名称与已删除的用户冲突时清理后重新写入，与有效用户冲突时清理不到任何数据，返回原来的错误
+		cleaned, cleanErr := u.cleanInValidUserTx(tx, user.Name, user.Owner)
+		if cleanErr != nil {
+			log.Errorf("[Store][User] clean user(%s) err: %s", user.Name, cleanErr.Error())
+			return store.Error(cleanErr)
+		}
+		if cleaned > 0 {
+			_, err = tx.Exec(addSql, addArgs...)
+		}
+	}
 	if err != nil {
-		return store.Error(err)
+		return convertUserTokenConflict(user.ID, err)
 	}
 
 	owner := user.Owner
@@ -108,32 +541,53 @@ func (u *userStore) addUser(user *model.User) error {
 		owner = user.ID
 	}
 
-	if err := createDefaultStrategy(tx, model.PrincipalUser, user.ID, user.Name, user.Owner); err != nil {
+	if err := createDefaultStrategy(tx, model.PrincipalUser, user.ID, user.Name, user.Owner,
+		u.reuseDefaultStrategy); err != nil {
 		log.Error("[Auth][User] create default strategy", zap.Error(err))
 		return store.Error(err)
 	}
-
-	if err := tx.Commit(); err != nil {
-		log.Errorf("[Store][User] add user tx commit err: %s", err.Error())
-		return store.Error(err)
-	}
-	return nil
+	return u.recordUserChanges(tx, model.UserChangeCreate, []string{user.ID})
 }
 
 // UpdateUser 更新用户信息
-func (u *userStore) UpdateUser(user *model.User) error {
+func (u *userStore) UpdateUser(user *model.User) (err error) {
+	u, span := u.traceOp(context.Background(), "UpdateUser")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
 	if user.ID == "" || user.Name == "" || user.Token == "" || user.Password == "" {
 		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
 			"update user missing some params, id is %s, name is %s", user.ID, user.Name))
 	}
 
-	err := RetryTransaction("updateUser", func() error {
+	err = u.retryTransaction("updateUser", func() error {
 		return u.updateUser(user)
 	})
+	if err == nil {
+		logUserOp("UpdateUser", "[Store][User] update user", zap.String("id", user.ID))
+	}
 
 	return store.Error(err)
 }
 
+// UpdateUserTx 在外部事务中更新用户信息
+func (u *userStore) UpdateUserTx(tx store.Tx, user *model.User) (err error) {
+	u, span := u.traceOp(context.Background(), "UpdateUserTx")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if tx == nil {
+		return ErrTxIsNil
+	}
+	if user.ID == "" || user.Name == "" || user.Token == "" || user.Password == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"update user missing some params, id is %s, name is %s", user.ID, user.Name))
+	}
+	return store.Error(u.updateUserTx(tx.GetDelegateTx().(*BaseTx), user))
+}
+
 func (u *userStore) updateUser(user *model.User) error {
 
 	tx, err := u.master.Begin()
@@ -143,209 +597,2076 @@ func (u *userStore) updateUser(user *model.User) error {
 
 	defer func() { _ = tx.Rollback() }()
 
+	if err := u.updateUserTx(tx, user); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Errorf("[Store][User] update user tx commit err: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (u *userStore) updateUserTx(tx *BaseTx, user *model.User) error {
+	if err := u.checkPasswordAge(tx, user); err != nil {
+		return err
+	}
+
 	tokenEnable := 1
 	if !user.TokenEnable {
 		tokenEnable = 0
 	}
 
-	modifySql := "UPDATE user SET password = ?, token = ?, comment = ?, token_enable = ?, mobile = ?, email = ?, " +
-		" mtime = sysdate() WHERE id = ? AND flag = 0"
+	// 密码发生变化时重置密码修改时间并清除强制修改密码标记，token 发生变化时记录 token 的更换时间
+	// 注意 MySQL 按照从左到右的顺序执行 SET，这些字段必须在 password 以及 token 之前赋值
+	modifySql := "UPDATE user SET " +
+		" password_set_time = IF(password = ?, password_set_time, sysdate()), " +
+		" must_change_password = IF(password = ?, must_change_password, 0), " +
+		tokenRotatedTimeSet +
+		" password = ?, token = ?, comment = ?, token_enable = ?, mobile = ?, email = ?, " +
+		" mtime = sysdate(), modified_by = ? WHERE id = ? AND flag = 0"
+
+	token, err := u.tokenCipher.Encrypt(user.Token)
+	if err != nil {
+		log.Error("[Store][User] encrypt user token", zap.String("id", user.ID), zap.Error(err))
+		return store.Error(err)
+	}
 
-	_, err = tx.Exec(modifySql, []interface{}{
+	result, err := tx.Exec(modifySql, []interface{}{
 		user.Password,
+		user.Password,
+		token,
 		user.Token,
+		user.Password,
+		token,
 		user.Comment,
 		tokenEnable,
 		user.Mobile,
 		user.Email,
+		user.ModifiedBy,
 		user.ID,
 	}...)
-
 	if err != nil {
+		return convertUserTokenConflict(user.ID, err)
+	}
+	if err := checkUserAffectedRows(tx, result, user.ID); err != nil {
 		return err
 	}
+	return u.recordUserChanges(tx, model.UserChangeUpdate, []string{user.ID})
+}
 
-	if err := tx.Commit(); err != nil {
-		log.Errorf("[Store][User] update user tx commit err: %s", err.Error())
+// checkPasswordAge 配置了密码的最短使用时间并且密码发生变化时，锁定用户并检查距离上一次修改密码的时间
+func (u *userStore) checkPasswordAge(tx *BaseTx, user *model.User) error {
+	if u.passwordAge.MinAge <= 0 {
+		return nil
+	}
+	var (
+		saved              = &model.User{ID: user.ID}
+		pwdSetTime         int64
+		mustChangePassword int
+	)
+	querySql := "SELECT password, IFNULL(UNIX_TIMESTAMP(password_set_time), 0), must_change_password " +
+		"FROM user WHERE id = ? AND flag = 0 FOR UPDATE"
+	if err := tx.QueryRow(querySql, user.ID).Scan(&saved.Password, &pwdSetTime, &mustChangePassword); err != nil {
+		if err == sql.ErrNoRows {
+			return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", user.ID))
+		}
+		return store.Error(err)
+	}
+	if saved.Password == user.Password {
+		return nil
+	}
+	saved.PasswordSetTime = unixToOptionalTime(pwdSetTime)
+	saved.MustChangePassword = mustChangePassword == 1
+	return u.passwordAge.Check(saved, user.AdminOperated, time.Now())
+}
+
+// checkUserAffectedRows 更新单个用户后检查影响行数，用户不存在或者已经删除时返回 NotFoundUser
+// MySQL 默认返回的是实际发生变化的行数，数据没有变化时同样为 0，因此需要再确认一次用户是否存在
+func checkUserAffectedRows(tx *BaseTx, result sql.Result, userId string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
+	if n > 0 {
+		return nil
+	}
 
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM user WHERE id = ? AND flag = 0", userId).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", userId))
+	}
 	return nil
 }
 
-// DeleteUser delete user by user id
-func (u *userStore) DeleteUser(user *model.User) error {
-	if user.ID == "" || user.Name == "" {
-		return store.NewStatusError(store.EmptyParamsErr, "delete user id parameter missing")
+// convertUserTokenConflict user 表的 token 字段存在唯一索引，命中该索引的主键冲突需要转为数据冲突错误
+func convertUserTokenConflict(userId string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if isUserTokenConflict(err) {
+		return store.NewStatusError(store.DataConflictErr, fmt.Sprintf(
+			"user(%s) token conflicts with other users", userId))
 	}
-
-	err := RetryTransaction("deleteUser", func() error {
-		return u.deleteUser(user)
-	})
-
 	return store.Error(err)
 }
 
-// deleteUser Specific deletion user steps
-// step 1. Delete the user-associated policy information
-//
-//	a. Delete the user's default policy
-//	b. Update the latest update time of related policies, make the Cache mechanism
-//	c. Delete the association relationship of the user and policy
-//
-// step 2. Delete the user group associated with this user
-func (u *userStore) deleteUser(user *model.User) error {
-	tx, err := u.master.Begin()
-	if err != nil {
-		return err
+// convertBatchUserTokenConflict 多行 INSERT 命中 token 唯一索引时，根据报错中的重复值找到冲突的用户，
+// tokens 为各个用户实际写入的 token，无法确定冲突的用户时错误中不指明用户
+func convertBatchUserTokenConflict(users []*model.User, tokens []string, err error) error {
+	if !isUserTokenConflict(err) {
+		return store.Error(err)
+	}
+	if entry, ok := duplicateEntryValue(err.Error()); ok {
+		for i, token := range tokens {
+			if token == entry {
+				return convertUserTokenConflict(users[i].ID, err)
+			}
+		}
 	}
+	return store.NewStatusError(store.DataConflictErr, "the token of one of the users conflicts with other users")
+}
 
-	defer func() { _ = tx.Rollback() }()
+// isUserTokenConflict MySQL 5.7 的报错为 for key 'token'，MySQL 8.0 的报错为 for key 'user.token'
+func isUserTokenConflict(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "Duplicate entry") &&
+		(strings.Contains(msg, "for key 'token'") || strings.Contains(msg, "for key 'user.token'"))
+}
 
-	if err := cleanLinkStrategy(tx, model.PrincipalUser, user.ID, user.Owner); err != nil {
-		return err
+// duplicateEntryValue 解析 Duplicate entry '{value}' for key '{key}' 报错中的重复值
+func duplicateEntryValue(msg string) (string, bool) {
+	_, rest, ok := strings.Cut(msg, "Duplicate entry '")
+	if !ok {
+		return "", false
 	}
-
-	if _, err = tx.Exec("UPDATE user SET flag = 1 WHERE id = ?", user.ID); err != nil {
-		log.Error("[Store][User] update set user flag", zap.Error(err))
-		return err
+	end := strings.LastIndex(rest, "' for key '")
+	if end < 0 {
+		return "", false
 	}
+	return rest[:end], true
+}
 
-	if _, err = tx.Exec("UPDATE user_group SET mtime = sysdate() WHERE id IN (SELECT DISTINCT group_id FROM "+
-		" user_group_relation WHERE user_id = ?)", user.ID); err != nil {
-		log.Error("[Store][User] update usergroup mtime", zap.Error(err))
-		return err
+// isUserNameConflict 写入的用户与已有用户的 owner + 名称唯一索引冲突，
+// 名称不区分大小写时 name_lower 上可能同样建立了唯一索引
+func isUserNameConflict(err error) bool {
+	msg := err.Error()
+	if !strings.Contains(msg, "Duplicate entry") {
+		return false
+	}
+	for _, key := range []string{"'name'", "'user.name'", "'name_lower'", "'user.name_lower'"} {
+		if strings.Contains(msg, "for key "+key) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteUser delete user by user id
+func (u *userStore) DeleteUser(user *model.User) (err error) {
+	u, span := u.traceOp(context.Background(), "DeleteUser")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if user.ID == "" || user.Name == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "delete user id parameter missing")
+	}
+
+	err = u.retryTransaction("deleteUser", func() error {
+		return u.deleteUser(user, true)
+	})
+	if err == nil {
+		logUserOp("DeleteUser", "[Store][User] delete user", zap.String("id", user.ID), zap.String("name", user.Name))
+	}
+
+	return store.Error(err)
+}
+
+// BatchDeleteUsersBestEffort 逐个删除用户，每个用户在单独的事务中删除，某个用户失败时不影响其他用户
+// 返回的结果与 users 一一对应
+func (u *userStore) BatchDeleteUsersBestEffort(users []*model.User) []store.BatchItemResult {
+	u, span := u.traceOp(context.Background(), "BatchDeleteUsersBestEffort")
+	defer func() { span.finish(nil) }()
+
+	results, failed := store.BestEffortUsers(users, u.DeleteUser)
+	logUserOp("BatchDeleteUsersBestEffort", "[Store][User] batch delete user best effort",
+		zap.Int("count", len(users)), zap.Int("failed", failed))
+	return results
+}
+
+// SoftDeleteUser 删除用户，cascadeGroups 为 false 时只标记用户为删除，保留用户-用户组的关联关系，
+// 恢复用户后用户组的成员关系随之恢复
+func (u *userStore) SoftDeleteUser(user *model.User, cascadeGroups bool) (err error) {
+	u, span := u.traceOp(context.Background(), "SoftDeleteUser")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if user.ID == "" || user.Name == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "delete user id parameter missing")
+	}
+
+	err = u.retryTransaction("softDeleteUser", func() error {
+		return u.deleteUser(user, cascadeGroups)
+	})
+	if err == nil {
+		logUserOp("SoftDeleteUser", "[Store][User] soft delete user", zap.String("id", user.ID),
+			zap.String("name", user.Name), zap.Bool("cascade", cascadeGroups))
+	}
+	return store.Error(err)
+}
+
+// deleteUser Specific deletion user steps, statements follow authLockOrder
+// step 1. Mark the user as deleted
+// step 2. Delete the user group relations of this user and refresh the mtime of these user groups
+// step 3. Delete the user-associated policy information
+//
+//	a. Delete the user's default policy
+//	b. Update the latest update time of related policies, make the Cache mechanism
+//	c. Delete the association relationship of the user and policy
+func (u *userStore) deleteUser(user *model.User, cascadeGroups bool) error {
+	tx, err := u.master.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	if err := u.deleteUserTx(tx, user, cascadeGroups); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] delete user tx commit", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DeleteUserTx 在外部事务中删除用户
+func (u *userStore) DeleteUserTx(tx store.Tx, user *model.User) (err error) {
+	u, span := u.traceOp(context.Background(), "DeleteUserTx")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if tx == nil {
+		return ErrTxIsNil
+	}
+	if user.ID == "" || user.Name == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "delete user id parameter missing")
+	}
+	return store.Error(u.deleteUserTx(tx.GetDelegateTx().(*BaseTx), user, true))
+}
+
+func (u *userStore) deleteUserTx(tx *BaseTx, user *model.User, cascadeGroups bool) error {
+	if _, err := tx.Exec("UPDATE user SET flag = 1, deleted_at = sysdate(), modified_by = ? WHERE id = ?",
+		user.ModifiedBy, user.ID); err != nil {
+		log.Error("[Store][User] update set user flag", zap.Error(err))
+		return err
+	}
+	if cascadeGroups {
+		if err := deleteUserGroupRelations(tx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := cleanLinkStrategy(tx, model.PrincipalUser, user.ID, user.Owner); err != nil {
+		return err
+	}
+	if u.cascadeSubAccounts && user.Type != model.SubAccountUserRole {
+		if err := u.deleteSubAccountsTx(tx, user); err != nil {
+			return err
+		}
+	}
+	return u.recordUserChanges(tx, model.UserChangeDelete, []string{user.ID})
+}
+
+// deleteSubAccountsTx 删除主账户时级联删除其有效的子账户，按批清理子账户的用户-用户组关联关系、默认策略以及鉴权关联关系
+func (u *userStore) deleteSubAccountsTx(tx *BaseTx, user *model.User) error {
+	subIds, err := lockSubAccountIds(tx, user.ID)
+	if err != nil {
+		log.Error("[Store][User] lock sub-accounts", zap.String("owner", user.ID), zap.Error(err))
+		return err
+	}
+	for start := 0; start < len(subIds); start += subAccountCleanBatch {
+		batch := subIds[start:min(start+subAccountCleanBatch, len(subIds))]
+		if _, err := tx.Exec("UPDATE user SET flag = 1, deleted_at = sysdate(), modified_by = ? WHERE id IN ("+
+			placeholders(len(batch))+")", append([]interface{}{user.ModifiedBy}, stringsToArgs(batch)...)...); err != nil {
+			log.Error("[Store][User] update set sub-accounts flag", zap.String("owner", user.ID), zap.Error(err))
+			return err
+		}
+		if err := deleteUserGroupRelations(tx, batch...); err != nil {
+			return err
+		}
+		// 子账户的默认策略以所属主账户的 ID 作为 owner
+		if err := cleanLinkStrategies(tx, model.PrincipalUser, batch, user.ID); err != nil {
+			log.Error("[Store][User] clean sub-accounts strategy", zap.String("owner", user.ID), zap.Error(err))
+			return err
+		}
+	}
+	if len(subIds) != 0 {
+		logUserOp("DeleteUser", "[Store][User] cascade delete sub-accounts", zap.String("owner", user.ID),
+			zap.Int("count", len(subIds)))
+	}
+	return u.recordUserChanges(tx, model.UserChangeDelete, subIds)
+}
+
+// lockSubAccountIds 锁定主账户下有效的子账户，返回子账户 ID
+func lockSubAccountIds(tx *BaseTx, ownerId string) ([]string, error) {
+	rows, err := tx.Query("SELECT id FROM user WHERE owner = ? AND flag = 0 FOR UPDATE", ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make([]string, 0, 4)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// deleteUserGroupRelations 删除用户的用户-用户组关联关系，并更新所在用户组的 mtime
+func deleteUserGroupRelations(tx *BaseTx, userIds ...string) error {
+	// 先锁定用户的关联关系并记录所在的用户组，再更新用户组，保证 user_group_relation 先于 user_group 加锁
+	groupIds, err := lockUserGroupIds(tx, userIds...)
+	if err != nil {
+		log.Error("[Store][User] lock usergroup relation", zap.Error(err))
+		return err
+	}
+	if len(groupIds) != 0 {
+		if _, err := tx.Exec("UPDATE user_group_relation SET flag = 1, mtime = sysdate() WHERE user_id IN ("+
+			placeholders(len(userIds))+") AND flag = 0", stringsToArgs(userIds)...); err != nil {
+			log.Error("[Store][User] delete usergroup relation", zap.Error(err))
+			return err
+		}
+		if _, err := tx.Exec("UPDATE user_group SET mtime = sysdate() WHERE id IN ("+
+			placeholders(len(groupIds))+")", groupIds...); err != nil {
+			log.Error("[Store][User] update usergroup mtime", zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// lockUserGroupIds 锁定用户有效的用户-用户组关联关系，返回所在的用户组 ID
+func lockUserGroupIds(tx *BaseTx, userIds ...string) ([]interface{}, error) {
+	rows, err := tx.Query("SELECT group_id FROM user_group_relation WHERE user_id IN ("+placeholders(len(userIds))+
+		") AND flag = 0 FOR UPDATE", stringsToArgs(userIds)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	groupIds := make([]interface{}, 0, 4)
+	for rows.Next() {
+		var groupId string
+		if err := rows.Scan(&groupId); err != nil {
+			return nil, err
+		}
+		groupIds = append(groupIds, groupId)
+	}
+	return groupIds, rows.Err()
+}
+
+// RecoverUser 恢复已经被删除的用户，保留下来的用户-用户组关联关系重新生效，同时重建删除时清理掉的默认策略
+func (u *userStore) RecoverUser(userId, modifiedBy string) (err error) {
+	u, span := u.traceOp(context.Background(), "RecoverUser")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if userId == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "recover user missing user id")
+	}
+
+	err = u.retryTransaction("recoverUser", func() error {
+		return u.recoverUser(userId, modifiedBy)
+	})
+	if err != nil {
+		log.Error("[Store][User] recover user", zap.String("id", userId), zap.Error(err))
+		return store.Error(err)
+	}
+	logUserOp("RecoverUser", "[Store][User] recover user", zap.String("id", userId))
+	return nil
+}
+
+// recoverUser 恢复用户的具体步骤，语句的加锁顺序遵循 authLockOrder
+// step 1. 取消用户的删除标记
+// step 2. 刷新保留下来的用户-用户组关联关系以及所在用户组的 mtime，触发 cache 重新加载成员关系
+// step 3. 重建用户的默认策略
+func (u *userStore) recoverUser(userId, modifiedBy string) error {
+	tx, err := u.master.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	var name, owner string
+	querySql := "SELECT name, owner FROM user WHERE id = ? AND flag = 1 FOR UPDATE"
+	if err := tx.QueryRow(querySql, userId).Scan(&name, &owner); err != nil {
+		if err == sql.ErrNoRows {
+			return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("deleted user(%s) not found", userId))
+		}
+		return err
+	}
+	if _, err := tx.Exec("UPDATE user SET flag = 0, deleted_at = NULL, mtime = sysdate(), modified_by = ? WHERE id = ?",
+		modifiedBy, userId); err != nil {
+		log.Error("[Store][User] update unset user flag", zap.Error(err))
+		return err
+	}
+
+	groupIds, err := lockUserGroupIds(tx, userId)
+	if err != nil {
+		log.Error("[Store][User] lock usergroup relation", zap.Error(err))
+		return err
+	}
+	if len(groupIds) != 0 {
+		if _, err := tx.Exec("UPDATE user_group_relation SET mtime = sysdate() WHERE user_id = ? AND flag = 0",
+			userId); err != nil {
+			log.Error("[Store][User] refresh usergroup relation", zap.Error(err))
+			return err
+		}
+		if _, err := tx.Exec("UPDATE user_group SET mtime = sysdate() WHERE id IN ("+
+			placeholders(len(groupIds))+")", groupIds...); err != nil {
+			log.Error("[Store][User] update usergroup mtime", zap.Error(err))
+			return err
+		}
+	}
+
+	if owner == "" {
+		owner = userId
+	}
+	rebuild, err := cleanBrokenDefaultStrategy(tx, model.PrincipalUser, userId, name, owner)
+	if err != nil {
+		return err
+	}
+	if rebuild {
+		if err := createDefaultStrategy(tx, model.PrincipalUser, userId, name, owner,
+			u.reuseDefaultStrategy); err != nil {
+			return err
+		}
+	}
+	if err := u.recordUserChanges(tx, model.UserChangeCreate, []string{userId}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("[Store][User] recover user tx commit", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetSubCount get user's sub count
+func (u *userStore) GetSubCount(user *model.User) (_ uint32, err error) {
+	u, span := u.traceOp(context.Background(), "GetSubCount")
+	defer func() { span.finish(err) }()
+
+	countSql := "SELECT COUNT(*) FROM user WHERE owner = ? AND flag = 0"
+	count, err := queryEntryCount(u.master, countSql, []interface{}{user.ID})
+
+	if err != nil {
+		log.Error("[Store][User] count sub-account", zap.String("owner", user.Owner), zap.Error(err))
+	}
+
+	return count, err
+}
+
+// GetUser get user by user id
+func (u *userStore) GetUser(id string) (_ *model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetUser")
+	defer func() { span.finish(err) }()
+
+	logUserOp("GetUser", "[Store][User] get user", zap.String("id", id))
+	return u.getUser(u.master.QueryRow, id)
+}
+
+// GetUserIfModified 根据用户 ID 获取用户，用户的版本号与 etag 一致时返回 NotModified
+func (u *userStore) GetUserIfModified(id, etag string) (_ *model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetUserIfModified")
+	defer func() { span.finish(err) }()
+
+	logUserOp("GetUserIfModified", "[Store][User] get user if modified", zap.String("id", id))
+	user, err := u.getUser(u.master.QueryRow, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.CheckUserModified(user, etag); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserTx 在外部事务中根据用户 ID 获取用户
+func (u *userStore) GetUserTx(tx store.Tx, id string) (_ *model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetUserTx")
+	defer func() { span.finish(err) }()
+
+	if tx == nil {
+		return nil, ErrTxIsNil
+	}
+	return u.getUser(tx.GetDelegateTx().(*BaseTx).QueryRow, id)
+}
+
+func (u *userStore) getUser(queryRow QueryRowHandler, id string) (*model.User, error) {
+	getSql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "u.") + userDetailColumns +
+		" FROM user u WHERE u.flag = 0 AND u.id = ?"
+	return u.scanUserDetail(queryRow(getSql, id))
+}
+
+// scanUserDetail 读取按照 userListColumns 以及 userDetailColumns 查询的单个有效用户，用户不存在时返回 nil
+func (u *userStore) scanUserDetail(row *sql.Row) (*model.User, error) {
+	var (
+		createdBy        string
+		tokenRotatedTime int64
+	)
+	user, err := fetchRown2User(row, &createdBy, &tokenRotatedTime)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, nil
+		default:
+			return nil, store.Error(err)
+		}
+	}
+	if err := u.decryptToken(user); err != nil {
+		return nil, err
+	}
+	user.CreatedBy = createdBy
+	user.TokenRotatedTime = unixToOptionalTime(tokenRotatedTime)
+	return user, nil
+}
+
+// GetUserByName 根据用户名、owner 获取用户
+func (u *userStore) GetUserByName(name, ownerId string) (_ *model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetUserByName")
+	defer func() { span.finish(err) }()
+
+	getSql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "u.") + userDetailColumns +
+		" FROM user u WHERE u.flag = 0 AND u.{name} = ? AND u.owner = ?"
+	nameColumn, nameKey := u.userNameKey(name)
+	getSql = strings.Replace(getSql, "{name}", nameColumn, 1)
+
+	logUserOp("GetUserByName", "[Store][User] get user by name", zap.String("name", name),
+		zap.String("owner", ownerId))
+	return u.scanUserDetail(u.master.QueryRow(getSql, nameKey, ownerId))
+}
+
+// GetUserByIds Get user list data according to user ID
+func (u *userStore) GetUserByIds(ids []string) ([]*model.User, error) {
+	return u.GetUserByIdsWithContext(context.Background(), ids)
+}
+
+// GetUserByIdsWithContext 按照 batchQuerySize 分批查询用户，重复的 ID 只查询一次
+// 配置了 userQueryConcurrency 并且需要分多批时，在只读库上并发执行各个分批后合并结果，
+// ctx 取消或者任意一个分批失败时，尚未完成的分批随之停止
+func (u *userStore) GetUserByIdsWithContext(ctx context.Context, ids []string) (_ []*model.User, err error) {
+	u, span := u.traceOp(ctx, "GetUserByIdsWithContext")
+	defer func() { span.finish(err) }()
+
+	ids = distinctIds(ids)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	logUserOp("GetUserByIds", "[Store][User] get user by ids", zap.Int("count", len(ids)))
+
+	if u.queryConcurrency <= 1 || len(ids) <= batchQuerySize {
+		users := make([]*model.User, 0, len(ids))
+		for start := 0; start < len(ids); start += batchQuerySize {
+			chunk, err := u.getUserByIdsChunk(ctx, u.master, ids[start:min(start+batchQuerySize, len(ids))])
+			if err != nil {
+				return nil, err
+			}
+			users = append(users, chunk...)
+		}
+		return users, nil
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(u.queryConcurrency)
+	chunks := make([][]*model.User, (len(ids)+batchQuerySize-1)/batchQuerySize)
+	for i := range chunks {
+		index, start := i, i*batchQuerySize
+		chunkIds := ids[start:min(start+batchQuerySize, len(ids))]
+		group.Go(func() error {
+			chunk, err := u.getUserByIdsChunk(groupCtx, u.readDB(), chunkIds)
+			chunks[index] = chunk
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	users := make([]*model.User, 0, len(ids))
+	for _, chunk := range chunks {
+		users = append(users, chunk...)
+	}
+	return users, nil
+}
+
+// getUserByIdsChunk 查询一个分批内的用户
+func (u *userStore) getUserByIdsChunk(ctx context.Context, db *BaseDB, ids []string) ([]*model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	args := make([]interface{}, 0, len(ids))
+	for index := range ids {
+		args = append(args, ids[index])
+	}
+	getSql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "u.") + " FROM user u " +
+		" WHERE u.flag = 0 AND u.id IN (" + placeholders(len(ids)) + ")"
+
+	rows, err := db.QueryWithContext(ctx, getSql, args...)
+	if err != nil {
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make([]*model.User, 0, len(ids))
+	for rows.Next() {
+		user, err := fetchRown2User(rows)
+		if err != nil {
+			log.Errorf("[Store][User] fetch user rows scan err: %s", err.Error())
+			return nil, store.Error(err)
+		}
+		if err := u.decryptToken(user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return users, nil
+}
+
+// distinctIds 去除重复以及空的 ID，保持原有的顺序
+func distinctIds(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	ret := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+// GetUsers Query user list information
+// Case 1. From the user's perspective, normal query conditions
+// Case 2. From the perspective of the user group, query is the list of users involved under a user group.
+func (u *userStore) GetUsers(filters map[string]string, offset uint32, limit uint32) (_ uint32,
+	_ []*model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetUsers")
+	defer func() { span.finish(err) }()
+
+	query, err := store.ParseUserQuery(filters)
+	if err != nil {
+		return 0, nil, err
+	}
+	return u.QueryUsers(query, offset, limit)
+}
+
+// QueryUsers 按照类型化的查询条件查询用户列表
+func (u *userStore) QueryUsers(query *store.UserQuery, offset uint32, limit uint32) (_ uint32,
+	_ []*model.User, err error) {
+	u, span := u.traceOp(context.Background(), "QueryUsers")
+	defer func() { span.finish(err) }()
+
+	if limit, err = store.CheckUserPage(offset, limit, u.queryMaxOffset); err != nil {
+		return 0, nil, err
+	}
+	if err := query.CheckValues(); err != nil {
+		return 0, nil, err
+	}
+	if err := u.queryGuard.Check(query, limit); err != nil {
+		return 0, nil, err
+	}
+	if err := u.checkOwnerRecursive(query); err != nil {
+		return 0, nil, err
+	}
+	if query.GroupID != "" {
+		// 用户组下的用户必然加入了用户组
+		if query.HasGroup != nil && !*query.HasGroup {
+			return 0, []*model.User{}, nil
+		}
+		return u.listGroupUsers(query, offset, limit)
+	}
+	return u.listUsers(query, offset, limit)
+}
+
+// checkOwnerRecursive 按照 owner 递归查询子账户树需要数据库支持 WITH RECURSIVE
+func (u *userStore) checkOwnerRecursive(query *store.UserQuery) error {
+	if !query.OwnerRecursive || query.Owner == "" || query.GroupID != "" || u.recursiveCTE {
+		return nil
+	}
+	return store.NewStatusError(store.InvalidParameter,
+		"owner_recursive requires WITH RECURSIVE support, which needs MySQL 8.0 or MariaDB 10.2.2 and above")
+}
+
+// listUsers Query user list information
+func (u *userStore) listUsers(query *store.UserQuery, offset uint32, limit uint32) (uint32, []*model.User, error) {
+	conds, args := buildUserConditions(query, "")
+	where := userFlagCondition(query, "")
+	countSql := "SELECT COUNT(*) FROM user WHERE " + where + " " + conds
+	getSql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "") + " FROM user WHERE " + where + " " + conds
+
+	db := u.queryDB(query)
+	count, err := queryEntryCount(db, countSql, args)
+	if err != nil {
+		return 0, nil, store.Error(err)
+	}
+
+	getSql += genUserOrderSQL(query.Order, "") + " LIMIT ? , ?"
+	getArgs := append(args, offset, limit)
+
+	users, err := u.collectUsers("GetUsers", db.Query, getSql, getArgs)
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, users, nil
+}
+
+// listGroupUsers Check the user information under a user group
+func (u *userStore) listGroupUsers(query *store.UserQuery, offset uint32, limit uint32) (uint32,
+	[]*model.User, error) {
+	if query.GroupID == "" {
+		return 0, nil, store.NewStatusError(store.EmptyParamsErr, "group_id is missing")
+	}
+
+	from, args := groupUsersFrom(query)
+	conds, condArgs := buildUserConditions(query, "u.")
+	args = append(args, condArgs...)
+	querySql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "u.") + " FROM " + from +
+		" WHERE ug.flag = 0 " + conds
+	countSql := `
+		  SELECT COUNT(*)
+		  FROM ` + from + `
+		  WHERE ug.flag = 0 
+	  ` + conds
+
+	db := u.queryDB(query)
+	count, err := queryEntryCount(db, countSql, args)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	querySql += genUserOrderSQL(query.Order, "u.") + " LIMIT ? , ?"
+	args = append(args, offset, limit)
+
+	users, err := u.collectUsers("GetUsers", db.Query, querySql, args)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return count, users, nil
+}
+
+// QueryUsersWithOwner 查询用户列表，通过 user 表自关联同时查询出每个用户所属主账户的名称
+func (u *userStore) QueryUsersWithOwner(query *store.UserQuery, offset uint32, limit uint32) (_ uint32,
+	_ []*model.UserWithOwner, err error) {
+	u, span := u.traceOp(context.Background(), "QueryUsersWithOwner")
+	defer func() { span.finish(err) }()
+
+	if limit, err = store.CheckUserPage(offset, limit, u.queryMaxOffset); err != nil {
+		return 0, nil, err
+	}
+	if err := query.CheckValues(); err != nil {
+		return 0, nil, err
+	}
+	if err := u.queryGuard.Check(query, limit); err != nil {
+		return 0, nil, err
+	}
+	if err := u.checkOwnerRecursive(query); err != nil {
+		return 0, nil, err
+	}
+	from, where, prefix := "user", userFlagCondition(query, "user."), "user."
+	var args []interface{}
+	if query.GroupID != "" {
+		// 用户组下的用户必然加入了用户组
+		if query.HasGroup != nil && !*query.HasGroup {
+			return 0, []*model.UserWithOwner{}, nil
+		}
+		from, args = groupUsersFrom(query)
+		where, prefix = "ug.flag = 0", "u."
+	}
+
+	conds, condArgs := buildUserConditions(query, prefix)
+	args = append(args, condArgs...)
+	db := u.queryDB(query)
+	count, err := queryEntryCount(db, "SELECT COUNT(*) FROM "+from+" WHERE "+where+" "+conds, args)
+	if err != nil {
+		return 0, nil, store.Error(err)
+	}
+
+	querySql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", prefix) + ", IFNULL(o.name, '') FROM " +
+		from + " LEFT JOIN user o ON o.id = " + prefix + "owner AND o.flag = 0 WHERE " + where + " " + conds +
+		genUserOrderSQL(query.Order, prefix) + " LIMIT ? , ?"
+	args = append(args, offset, limit)
+
+	logUserOp("QueryUsersWithOwner", "[Store][User] list user with owner", zap.String("query sql", querySql),
+		zap.Any("args", args))
+	rows, err := db.Query(querySql, args...)
+	if err != nil {
+		log.Error("[Store][User] list user with owner", zap.String("query sql", querySql), zap.Error(err))
+		return 0, nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make([]*model.UserWithOwner, 0)
+	for rows.Next() {
+		var ownerName string
+		user, err := fetchRown2User(rows, &ownerName)
+		if err != nil {
+			log.Errorf("[Store][User] fetch user rows scan err: %s", err.Error())
+			return 0, nil, store.Error(err)
+		}
+		if err := u.decryptToken(user); err != nil {
+			return 0, nil, err
+		}
+		users = append(users, model.NewUserWithOwner(user, ownerName))
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, store.Error(err)
+	}
+	return count, users, nil
+}
+
+// groupUsersFrom 查询用户组下的用户时的 FROM 子句以及参数，user_group_relation 表的别名为 ug，user 表的别名为 u
+// 指定了 GroupOwner 时关联 user_group 表，只允许查询属于该主账户的用户组
+func groupUsersFrom(query *store.UserQuery) (string, []interface{}) {
+	from := "user_group_relation ug "
+	args := make([]interface{}, 0, 1)
+	if query.GroupOwner != "" {
+		from += "INNER JOIN user_group g ON g.id = ug.group_id AND g.flag = 0 AND g.owner = ? "
+		args = append(args, query.GroupOwner)
+	}
+	return from + "LEFT JOIN user u ON ug.user_id = u.id AND u.flag = 0", args
+}
+
+// buildUserConditions 根据 UserQuery 生成用户列表的查询条件以及参数，条件的顺序是固定的
+// prefix 为 user 表列名的前缀，指定了 GroupID 时查询用户组下的用户，此时 user_group_relation 表的别名为 ug
+func buildUserConditions(query *store.UserQuery, prefix string) (string, []interface{}) {
+	var (
+		conds   strings.Builder
+		args    = make([]interface{}, 0, 4)
+		inGroup = query.GroupID != ""
+	)
+	add := func(cond string, vals ...interface{}) {
+		conds.WriteString(" AND " + cond + " ")
+		args = append(args, vals...)
+	}
+
+	if inGroup {
+		add("ug.group_id = ?", query.GroupID)
+	}
+	if query.GroupName != "" {
+		// 同名的用户组可能属于不同的主账户，指定了 GroupOwner 时只匹配该主账户下的用户组
+		if query.GroupOwner != "" {
+			add(prefix+"id IN ("+groupNameSubQuery+" AND gn.owner = ?)", query.GroupName, query.GroupOwner)
+		} else {
+			add(prefix+"id IN ("+groupNameSubQuery+")", query.GroupName)
+		}
+	}
+	if query.HideAdmin {
+		add(prefix + "user_type != 0")
+	}
+	if query.ID != "" {
+		add(prefix+"id = ?", query.ID)
+	}
+	// 排除的用户较多时拆分为多个 NOT IN，避免单个列表过长
+	for start := 0; start < len(query.ExcludeIDs); start += batchQuerySize {
+		chunk := query.ExcludeIDs[start:min(start+batchQuerySize, len(query.ExcludeIDs))]
+		vals := make([]interface{}, 0, len(chunk))
+		for _, id := range chunk {
+			vals = append(vals, id)
+		}
+		add(prefix+"id NOT IN ("+placeholders(len(chunk))+")", vals...)
+	}
+	if name := query.Name; name != "" {
+		if utils.IsPrefixWildName(name) {
+			// 用户组下的用户按照名称前缀匹配，用户列表按照名称包含匹配
+			pattern := "%" + name[:len(name)-1] + "%"
+			if inGroup {
+				pattern = name[:len(name)-1] + "%"
+			}
+			add(prefix+"name like ?", pattern)
+		} else if names := strings.Split(name, ","); len(names) > 1 {
+			// 多个名称以逗号分隔，批量精确查询
+			vals := make([]interface{}, 0, len(names))
+			for i := range names {
+				vals = append(vals, names[i])
+			}
+			add(prefix+"name IN ("+placeholders(len(names))+")", vals...)
+		} else {
+			add(prefix+"name = ?", name)
+		}
+	}
+	if query.Keyword != "" {
+		keyword := "%" + escapeLike(query.Keyword) + "%"
+		add("("+prefix+"name LIKE ? OR "+prefix+"comment LIKE ?)", keyword, keyword)
+	}
+	if query.Owner != "" {
+		if inGroup {
+			add(prefix+"owner = ?", query.Owner)
+		} else if query.OwnerRecursive {
+			// 主账户自身以及逐级展开的整棵子账户树，UNION 去重避免 owner 成环时无限递归
+			add("("+prefix+"id = ? OR "+prefix+"id IN ("+ownerSubtreeQuery+"))", query.Owner, query.Owner)
+		} else {
+			// 主账户自身以及其下的直接子账户
+			add("("+prefix+"id = ? OR "+prefix+"owner = ?)", query.Owner, query.Owner)
+		}
+	}
+	if query.Source != "" {
+		add(prefix+"source = ?", query.Source)
+	}
+	if query.CreatedBy != "" {
+		add(prefix+"created_by = ?", query.CreatedBy)
+	}
+	if query.ModifiedBy != "" {
+		add(prefix+"modified_by = ?", query.ModifiedBy)
+	}
+	if query.TokenEnable != nil {
+		add(prefix+"token_enable = ?", boolToInt(*query.TokenEnable))
+	}
+	if !query.CreatedAfter.IsZero() {
+		add(prefix+"ctime >= FROM_UNIXTIME(?)", timeToTimestamp(query.CreatedAfter))
+	}
+	if !query.ModifiedAfter.IsZero() {
+		add(prefix+"mtime >= FROM_UNIXTIME(?)", timeToTimestamp(query.ModifiedAfter))
+	}
+	if !query.ModifiedBefore.IsZero() {
+		add(prefix+"mtime < FROM_UNIXTIME(?)", timeToTimestamp(query.ModifiedBefore))
+	}
+	if query.IncludeDeleted && !query.DeletedAfter.IsZero() {
+		add(prefix+"deleted_at >= FROM_UNIXTIME(?)", query.DeletedAfter.Unix())
+	}
+	if query.IncludeDeleted && !query.DeletedBefore.IsZero() {
+		add(prefix+"deleted_at < FROM_UNIXTIME(?)", query.DeletedBefore.Unix())
+	}
+	if !query.TokenRotatedBefore.IsZero() {
+		// token 更换时间未知的用户同样视为在该时间之后没有更换过 token
+		add("("+prefix+"token_rotated_time IS NULL OR "+prefix+"token_rotated_time < FROM_UNIXTIME(?))",
+			query.TokenRotatedBefore.Unix())
+	}
+	if !query.LastLoginBefore.IsZero() {
+		if query.ExcludeNeverLogin {
+			add(prefix+"last_login_time < FROM_UNIXTIME(?)", query.LastLoginBefore.Unix())
+		} else {
+			// 从未登录过的用户 last_login_time 为 NULL，同样视为在该时间之后没有登录
+			add("("+prefix+"last_login_time IS NULL OR "+prefix+"last_login_time < FROM_UNIXTIME(?))",
+				query.LastLoginBefore.Unix())
+		}
+	}
+	// 用户组下的用户必然加入了用户组，由 QueryUsers 处理
+	if query.HasGroup != nil && !inGroup {
+		if *query.HasGroup {
+			conds.WriteString(" AND" + hasGroupSubQuery)
+		} else {
+			conds.WriteString(" AND NOT" + hasGroupSubQuery)
+		}
+	}
+	return conds.String(), args
+}
+
+// userFlagCondition 用户列表中用户是否删除的条件，IncludeDeleted 时同时查询已经删除的用户，
+// 按照删除时间过滤时只查询已经删除的用户
+func userFlagCondition(query *store.UserQuery, prefix string) string {
+	if !query.IncludeDeleted {
+		return prefix + "flag = 0"
+	}
+	if query.HasDeleteTimeRange() {
+		return prefix + "flag = 1"
+	}
+	return "1 = 1"
+}
+
+// GetAddableUsersForGroup 查询主账户下还没有加入该用户组的有效用户，用于编辑用户组成员
+// 用户组不存在或者不属于该主账户时返回 NotFoundUserGroup
+func (u *userStore) GetAddableUsersForGroup(groupId, ownerId string, offset uint32, limit uint32) (_ uint32,
+	_ []*model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetAddableUsersForGroup")
+	defer func() { span.finish(err) }()
+
+	if groupId == "" || ownerId == "" {
+		return 0, nil, store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"get addable users missing some params, group is %s, owner is %s", groupId, ownerId))
+	}
+	members, err := u.getGroupMemberIds(groupId, ownerId)
+	if err != nil {
+		log.Error("[Store][User] get group members", zap.String("group", groupId), zap.String("owner", ownerId),
+			zap.Error(err))
+		return 0, nil, store.Error(err)
+	}
+	return u.QueryUsers(&store.UserQuery{Owner: ownerId, ExcludeIDs: members, HideAdmin: true}, offset, limit)
+}
+
+// getGroupMemberIds 查询属于 ownerId 的有效用户组当前的成员
+func (u *userStore) getGroupMemberIds(groupId, ownerId string) ([]string, error) {
+	var owner string
+	err := u.master.QueryRow("SELECT owner FROM user_group WHERE id = ? AND flag = 0", groupId).Scan(&owner)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == sql.ErrNoRows || owner != ownerId {
+		return nil, store.NewStatusError(store.NotFoundUserGroup, fmt.Sprintf(
+			"group(%s) of owner(%s) not found", groupId, ownerId))
+	}
+
+	rows, err := u.master.Query("SELECT user_id FROM user_group_relation WHERE group_id = ? AND flag = 0", groupId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	ids := make([]string, 0, 8)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetUsersByStrategyID 查询关联到某个鉴权策略的用户列表，不包含超级账户
+func (u *userStore) GetUsersByStrategyID(strategyID string, offset uint32, limit uint32) (_ uint32,
+	_ []*model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetUsersByStrategyID")
+	defer func() { span.finish(err) }()
+
+	if strategyID == "" {
+		return 0, nil, store.NewStatusError(store.EmptyParamsErr, "strategy id is missing")
+	}
+	if limit, err = store.CheckUserPage(offset, limit, u.queryMaxOffset); err != nil {
+		return 0, nil, err
+	}
+
+	args := []interface{}{strategyID, model.PrincipalUser}
+	countSql := `
+	  SELECT COUNT(*)
+	  FROM auth_principal ap
+		  INNER JOIN user u ON ap.principal_id = u.id AND u.flag = 0
+	  WHERE ap.strategy_id = ? AND ap.principal_role = ? AND u.user_type != 0
+	  `
+	querySql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "u.") + `
+	  FROM auth_principal ap
+		  INNER JOIN user u ON ap.principal_id = u.id AND u.flag = 0
+	  WHERE ap.strategy_id = ? AND ap.principal_role = ? AND u.user_type != 0
+	  ORDER BY u.mtime LIMIT ? , ?
+	  `
+
+	count, err := queryEntryCount(u.master, countSql, args)
+	if err != nil {
+		log.Error("[Store][User] count users by strategy", zap.String("strategy", strategyID), zap.Error(err))
+		return 0, nil, store.Error(err)
+	}
+
+	users, err := u.collectUsers("GetUsersByStrategyID", u.master.Query, querySql, append(args, offset, limit))
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, users, nil
+}
+
+// GetMainAccountsWithSubCounts 按照 ctime 分页查询有效的主账户（owner 为自身）以及每个主账户下有效子账户的个数，不包含超级账户
+func (u *userStore) GetMainAccountsWithSubCounts(offset uint32, limit uint32) (_ uint32,
+	_ []*model.UserWithSubCount, err error) {
+	u, span := u.traceOp(context.Background(), "GetMainAccountsWithSubCounts")
+	defer func() { span.finish(err) }()
+
+	if limit, err = store.CheckUserPage(offset, limit, u.queryMaxOffset); err != nil {
+		return 0, nil, err
+	}
+
+	countSql := "SELECT COUNT(*) FROM user WHERE flag = 0 AND id = owner AND user_type != 0"
+	querySql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "u.") +
+		", (SELECT COUNT(*) FROM user s WHERE s.owner = u.id AND s.id != s.owner AND s.flag = 0)" +
+		" FROM user u WHERE u.flag = 0 AND u.id = u.owner AND u.user_type != 0" +
+		" ORDER BY u.ctime, u.id LIMIT ? , ?"
+
+	count, err := queryEntryCount(u.master, countSql, nil)
+	if err != nil {
+		log.Error("[Store][User] count main accounts", zap.Error(err))
+		return 0, nil, store.Error(err)
+	}
+
+	logUserOp("GetMainAccountsWithSubCounts", "[Store][User] list main accounts with sub counts",
+		zap.Uint32("offset", offset), zap.Uint32("limit", limit))
+	rows, err := u.master.Query(querySql, offset, limit)
+	if err != nil {
+		log.Error("[Store][User] list main accounts with sub counts", zap.Error(err))
+		return 0, nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make([]*model.UserWithSubCount, 0, limit)
+	for rows.Next() {
+		var subCount uint32
+		user, err := fetchRown2User(rows, &subCount)
+		if err != nil {
+			log.Error("[Store][User] fetch main account rows", zap.Error(err))
+			return 0, nil, store.Error(err)
+		}
+		if err := u.decryptToken(user); err != nil {
+			return 0, nil, err
+		}
+		users = append(users, &model.UserWithSubCount{User: user, SubCount: subCount})
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, store.Error(err)
+	}
+	return count, users, nil
+}
+
+// GetRecentlyModifiedUsers 按照 mtime 倒序获取最近修改过的用户，不包含超级账户
+func (u *userStore) GetRecentlyModifiedUsers(limit uint32) (_ []*model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetRecentlyModifiedUsers")
+	defer func() { span.finish(err) }()
+
+	if limit == 0 {
+		return []*model.User{}, nil
+	}
+	if limit > store.MaxRecentlyModifiedUsers {
+		limit = store.MaxRecentlyModifiedUsers
+	}
+
+	querySql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "") +
+		" FROM user WHERE flag = 0 AND user_type != 0 ORDER BY mtime DESC, id LIMIT ?"
+	return u.collectUsers("GetRecentlyModifiedUsers", u.master.Query, querySql, []interface{}{limit})
+}
+
+// GetUserCountsBySource 按照用户来源统计有效用户的个数，不包含超级账户
+func (u *userStore) GetUserCountsBySource() (_ map[string]uint32, err error) {
+	u, span := u.traceOp(context.Background(), "GetUserCountsBySource")
+	defer func() { span.finish(err) }()
+
+	querySql := "SELECT source, COUNT(*) FROM user WHERE flag = 0 AND user_type != 0 GROUP BY source"
+	rows, err := u.readDB().Query(querySql)
+	if err != nil {
+		log.Error("[Store][User] get user counts by source", zap.Error(err))
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	counts := make(map[string]uint32)
+	for rows.Next() {
+		var (
+			source string
+			count  uint32
+		)
+		if err := rows.Scan(&source, &count); err != nil {
+			log.Error("[Store][User] fetch user counts by source", zap.Error(err))
+			return nil, store.Error(err)
+		}
+		counts[source] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return counts, nil
+}
+
+// GetUserCountsByType 按照用户类型统计有效的用户数量，不包含超级账户
+func (u *userStore) GetUserCountsByType() (_ map[model.UserRoleType]uint32, err error) {
+	u, span := u.traceOp(context.Background(), "GetUserCountsByType")
+	defer func() { span.finish(err) }()
+
+	querySql := "SELECT user_type, COUNT(*) FROM user WHERE flag = 0 AND user_type != 0 GROUP BY user_type"
+	rows, err := u.readDB().Query(querySql)
+	if err != nil {
+		log.Error("[Store][User] get user counts by type", zap.Error(err))
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	counts := make(map[model.UserRoleType]uint32)
+	for rows.Next() {
+		var (
+			userType int
+			count    uint32
+		)
+		if err := rows.Scan(&userType, &count); err != nil {
+			log.Error("[Store][User] fetch user counts by type", zap.Error(err))
+			return nil, store.Error(err)
+		}
+		counts[model.UserRoleType(userType)] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return counts, nil
+}
+
+// GetUsersForCache Get user information, mainly for cache
+func (u *userStore) GetUsersForCache(mtime time.Time, firstUpdate bool) (_ []*model.User, err error) {
+	u, span := u.traceOp(context.Background(), "GetUsersForCache")
+	defer func() { span.finish(err) }()
+
+	// 不需要加载的敏感字段直接查询空字符串，避免落入缓存
+	passwordCol, tokenCol := "u.password", "u.token"
+	if u.cacheExcludePassword {
+		passwordCol = "''"
+	}
+	if u.cacheExcludeToken {
+		tokenCol = "''"
+	}
+
+	if u.cacheProjection {
+		users, err := u.getProjectedUsersForCache(mtime, firstUpdate, tokenCol)
+		if err != nil {
+			return nil, err
+		}
+		return users, u.fillUserGroupsForCache(users, mtime, firstUpdate)
+	}
+
+	args := make([]interface{}, 0)
+	querySql := `
+	  SELECT u.id, u.name, ` + passwordCol + `, u.owner, u.comment, u.source
+		  , ` + tokenCol + `, u.token_enable, user_type, UNIX_TIMESTAMP(u.ctime)
+		  , UNIX_TIMESTAMP(u.mtime), u.flag, u.mobile, u.email
+		  , IFNULL(UNIX_TIMESTAMP(u.last_login_time), 0)
+		  , IFNULL(UNIX_TIMESTAMP(u.password_set_time), 0), u.must_change_password
+		  , IFNULL(UNIX_TIMESTAMP(u.deleted_at), 0)
+	  FROM user u 
+	  `
+
+	if !firstUpdate {
+		querySql += " WHERE u.mtime >= FROM_UNIXTIME(?) "
+		args = append(args, timeToTimestamp(mtime))
+	}
+
+	users, err := u.collectUsers("GetUsersForCache", u.master.Query, querySql, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.fillUserGroupsForCache(users, mtime, firstUpdate); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// fillUserGroupsForCache 开启 cacheWithGroups 时，通过一次关联查询得到本次同步的用户所属的有效用户组
+// 查询条件与 GetUsersForCache 一致，避免缓存再单独扫描全部的用户-用户组关联关系
+func (u *userStore) fillUserGroupsForCache(users []*model.User, mtime time.Time, firstUpdate bool) error {
+	if !u.cacheWithGroups || len(users) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, 1)
+	querySql := "SELECT ugr.user_id, ugr.group_id FROM user_group_relation ugr " +
+		"INNER JOIN user_group ug ON ug.id = ugr.group_id AND ug.flag = 0 " +
+		"INNER JOIN user u ON u.id = ugr.user_id AND u.flag = 0 WHERE ugr.flag = 0 "
+	if !firstUpdate {
+		querySql += " AND u.mtime >= FROM_UNIXTIME(?) "
+		args = append(args, timeToTimestamp(mtime))
+	}
+	querySql += " ORDER BY ugr.user_id, ugr.group_id"
+
+	rows, err := u.master.Query(querySql, args...)
+	if err != nil {
+		log.Error("[Store][User] list user groups for cache", zap.String("query sql", querySql),
+			zap.Any("args", args), zap.Error(err))
+		return store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	user2Groups := make(map[string][]string, len(users))
+	for rows.Next() {
+		var userId, groupId string
+		if err := rows.Scan(&userId, &groupId); err != nil {
+			log.Error("[Store][User] fetch user group rows", zap.Error(err))
+			return store.Error(err)
+		}
+		user2Groups[userId] = append(user2Groups[userId], groupId)
+	}
+	if err := rows.Err(); err != nil {
+		return store.Error(err)
+	}
+
+	for _, user := range users {
+		user.GroupIDs = user2Groups[user.ID]
+	}
+	return nil
+}
+
+// ExportUsersSnapshot 在同一个可重复读的事务中按照 ID 分批导出全部用户（包括已删除的用户），每个用户一行 JSON
+// 事务内的第一次查询建立一致性读视图，之后的分批都读取同一个快照，导出期间并发的写入不会出现在结果中
+// 导出的内容包含密码摘要以及与 GetUser 一致的明文 token，需要妥善保管
+func (u *userStore) ExportUsersSnapshot(ctx context.Context, w io.Writer) (err error) {
+	u, span := u.traceOp(ctx, "ExportUsersSnapshot")
+	defer func() { span.finish(err) }()
+
+	tx, err := u.master.WithIsolationLevel(sql.LevelRepeatableRead).Begin()
+	if err != nil {
+		log.Error("[Store][User] export users snapshot begin tx", zap.Error(err))
+		return store.Error(err)
+	}
+	// 只读事务，结束时直接回滚
+	defer func() { _ = tx.Rollback() }()
+
+	querySql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "u.") + userDetailColumns +
+		", u.modified_by FROM user u WHERE u.id > ? ORDER BY u.id LIMIT ?"
+	encoder := json.NewEncoder(w)
+	lastId, total := "", 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return store.Error(err)
+		}
+		users, err := collectUserSnapshots(tx, querySql, lastId)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := encoder.Encode(store.NewUserSnapshot(user)); err != nil {
+				log.Error("[Store][User] export users snapshot write", zap.String("id", user.ID), zap.Error(err))
+				return err
+			}
+		}
+		total += len(users)
+		if len(users) < batchQuerySize {
+			break
+		}
+		lastId = users[len(users)-1].ID
+	}
+
+	logUserOp("ExportUsersSnapshot", "[Store][User] export users snapshot", zap.Int("count", total))
+	return nil
+}
+
+// collectUserSnapshots 查询导出的一批用户，token 保持存储中的值，不进行解密
+func collectUserSnapshots(tx *BaseTx, querySql, lastId string) ([]*model.User, error) {
+	rows, err := tx.Query(querySql, lastId, batchQuerySize)
+	if err != nil {
+		log.Error("[Store][User] export users snapshot", zap.String("query sql", querySql), zap.Error(err))
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	users := make([]*model.User, 0)
+	for rows.Next() {
+		var (
+			createdBy, modifiedBy string
+			tokenRotatedTime      int64
+		)
+		user, err := fetchRown2User(rows, &createdBy, &tokenRotatedTime, &modifiedBy)
+		if err != nil {
+			log.Errorf("[Store][User] fetch user rows scan err: %s", err.Error())
+			return nil, store.Error(err)
+		}
+		user.CreatedBy = createdBy
+		user.ModifiedBy = modifiedBy
+		user.TokenRotatedTime = unixToOptionalTime(tokenRotatedTime)
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return users, nil
+}
+
+// getProjectedUsersForCache 只查询缓存需要的字段，密码、备注、来源等字段保持零值
+// 密码过期的校验基于缓存中的用户，因此保留 password_set_time 以及 must_change_password
+func (u *userStore) getProjectedUsersForCache(mtime time.Time, firstUpdate bool,
+	tokenCol string) ([]*model.User, error) {
+	args := make([]interface{}, 0)
+	querySql := "SELECT u.id, u.name, u.owner, " + tokenCol + ", u.token_enable, u.user_type" +
+		", UNIX_TIMESTAMP(u.mtime), u.flag, IFNULL(UNIX_TIMESTAMP(u.password_set_time), 0)" +
+		", u.must_change_password FROM user u "
+	if !firstUpdate {
+		querySql += " WHERE u.mtime >= FROM_UNIXTIME(?) "
+		args = append(args, timeToTimestamp(mtime))
+	}
+
+	logUserOp("GetUsersForCache", "[Store][User] list user", zap.String("query sql", querySql), zap.Any("args", args))
+	rows, err := u.master.Query(querySql, args...)
+	if err != nil {
+		log.Error("[Store][User] list user ", zap.String("query sql", querySql), zap.Any("args", args), zap.Error(err))
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	users := make([]*model.User, 0)
+	for rows.Next() {
+		var (
+			mtime, pwdSetTime          int64
+			tokenEnable                sql.NullInt64
+			flag, role, mustChangePass int
+			user                       = new(model.User)
+		)
+		if err := rows.Scan(&user.ID, &user.Name, &user.Owner, &user.Token, &tokenEnable, &role,
+			&mtime, &flag, &pwdSetTime, &mustChangePass); err != nil {
+			log.Errorf("[Store][User] fetch user rows scan err: %s", err.Error())
+			return nil, store.Error(err)
+		}
+		user.Valid = flag == 0
+		user.TokenEnable = isTokenEnabled(tokenEnable)
+		user.Type = model.UserRoleType(role)
+		user.ModifyTime = time.Unix(mtime, 0)
+		user.PasswordSetTime = unixToOptionalTime(pwdSetTime)
+		user.MustChangePassword = mustChangePass == 1
+		if err := u.decryptToken(user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return users, nil
+}
+
+// UpdateLastLogin 记录用户最近一次登录时间
+// 显式保持 mtime 不变，避免每次登录都触发用户缓存的增量刷新
+func (u *userStore) UpdateLastLogin(userId string) (err error) {
+	u, span := u.traceOp(context.Background(), "UpdateLastLogin")
+	defer func() { span.finish(err) }()
+
+	if userId == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "update last login missing user id")
+	}
+
+	updateSql := "UPDATE user SET last_login_time = sysdate(), mtime = mtime WHERE id = ? AND flag = 0"
+	if _, err := u.master.Exec(updateSql, userId); err != nil {
+		log.Error("[Store][User] update user last login time", zap.String("id", userId), zap.Error(err))
+		return store.Error(err)
+	}
+	logUserOp("UpdateLastLogin", "[Store][User] update user last login time", zap.String("id", userId))
+	return nil
+}
+
+// RenameUser 修改用户名称，在同一个事务中完成同 owner 下的重名校验以及名称的更新
+// 用户与用户组、鉴权策略之间的关联均基于用户 ID，默认策略的名称由用户名称生成，需要同步修改
+func (u *userStore) RenameUser(userId, newName, modifiedBy string) (err error) {
+	u, span := u.traceOp(context.Background(), "RenameUser")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if userId == "" || newName == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"rename user missing some params, id is %s, name is %s", userId, newName))
+	}
+
+	err = u.writeTransaction("renameUser", func(tx *BaseTx) error {
+		var oldName, owner string
+		row := tx.QueryRow("SELECT name, owner FROM user WHERE id = ? AND flag = 0 FOR UPDATE", userId)
+		if err := row.Scan(&oldName, &owner); err != nil {
+			if err == sql.ErrNoRows {
+				return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", userId))
+			}
+			return err
+		}
+
+		var count uint32
+		nameColumn, nameKey := u.userNameKey(newName)
+		countSql := "SELECT COUNT(*) FROM user WHERE " + nameColumn + " = ? AND owner = ? AND id != ? AND flag = 0"
+		if err := tx.QueryRow(countSql, nameKey, owner, userId).Scan(&count); err != nil {
+			return err
+		}
+		if count != 0 {
+			return store.NewStatusError(store.DuplicateEntryErr, fmt.Sprintf(
+				"user name(%s) existed in owner(%s)", newName, owner))
+		}
+
+		if _, err := u.cleanInValidUserTx(tx, newName, owner); err != nil {
+			return err
+		}
+		renameSql := "UPDATE user SET name = ?, name_lower = ?, mtime = sysdate(), modified_by = ? " +
+			"WHERE id = ? AND flag = 0"
+		if _, err := tx.Exec(renameSql, newName, strings.ToLower(newName), modifiedBy, userId); err != nil {
+			return err
+		}
+		if err := renameDefaultStrategy(tx, model.PrincipalUser, userId, oldName, newName); err != nil {
+			return err
+		}
+		if err := u.recordUserChanges(tx, model.UserChangeUpdate, []string{userId}); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Error("[Store][User] rename user tx commit", zap.String("id", userId), zap.Error(err))
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		logUserOp("RenameUser", "[Store][User] rename user", zap.String("id", userId), zap.String("name", newName))
+	}
+
+	return store.Error(err)
+}
+
+// SwapUserNames 交换同一个 owner 下两个用户以及其默认策略的名称，在同一个事务中先将其中一个用户改为临时名称，
+// 避免依次改名时触发 (name, owner) 唯一索引冲突
+func (u *userStore) SwapUserNames(userIdA, userIdB, modifiedBy string) (err error) {
+	u, span := u.traceOp(context.Background(), "SwapUserNames")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if userIdA == "" || userIdB == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"swap user names missing some params, ids are %s, %s", userIdA, userIdB))
+	}
+	if userIdA == userIdB {
+		return store.NewStatusError(store.InvalidParameter, fmt.Sprintf(
+			"swap user names requires two different users, id is %s", userIdA))
+	}
+
+	err = u.writeTransaction("swapUserNames", func(tx *BaseTx) error {
+		names, owner, err := lockSwapUsers(tx, userIdA, userIdB)
+		if err != nil {
+			return err
+		}
+
+		renameSql := "UPDATE user SET name = ?, name_lower = ?, mtime = sysdate(), modified_by = ? " +
+			"WHERE id = ? AND flag = 0"
+		// 临时名称只在事务内可见，使用随机值避免与同 owner 下的其他用户重名
+		placeholder := "swap-" + utils.NewUUID()
+		for _, step := range [][3]string{
+			{userIdA, names[userIdA], placeholder},
+			{userIdB, names[userIdB], names[userIdA]},
+			{userIdA, placeholder, names[userIdB]},
+		} {
+			if _, err := tx.Exec(renameSql, step[2], strings.ToLower(step[2]), modifiedBy, step[0]); err != nil {
+				log.Error("[Store][User] swap user names", zap.String("id", step[0]), zap.String("owner", owner),
+					zap.Error(err))
+				return err
+			}
+			// 默认策略的名称同样受 (name, owner) 唯一索引约束，按照相同的步骤经过临时名称完成交换
+			if err := renameDefaultStrategy(tx, model.PrincipalUser, step[0], step[1], step[2]); err != nil {
+				return err
+			}
+		}
+		if err := u.recordUserChanges(tx, model.UserChangeUpdate, []string{userIdA, userIdB}); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Error("[Store][User] swap user names tx commit", zap.String("id-a", userIdA),
+				zap.String("id-b", userIdB), zap.Error(err))
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		logUserOp("SwapUserNames", "[Store][User] swap user names", zap.String("id-a", userIdA),
+			zap.String("id-b", userIdB))
+	}
+
+	return store.Error(err)
+}
+
+// lockSwapUsers 按照 ID 的顺序锁定需要交换名称的两个用户，返回用户 ID 到名称的映射以及共同的 owner
+func lockSwapUsers(tx *BaseTx, userIdA, userIdB string) (map[string]string, string, error) {
+	rows, err := tx.Query("SELECT id, name, owner FROM user WHERE id IN (?, ?) AND flag = 0 ORDER BY id FOR UPDATE",
+		userIdA, userIdB)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = rows.Close() }()
+
+	names := make(map[string]string, 2)
+	owners := make(map[string]struct{}, 2)
+	var owner string
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name, &owner); err != nil {
+			return nil, "", err
+		}
+		names[id] = name
+		owners[owner] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	for _, id := range []string{userIdA, userIdB} {
+		if _, ok := names[id]; !ok {
+			return nil, "", store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", id))
+		}
+	}
+	if len(owners) != 1 {
+		return nil, "", store.NewStatusError(store.InvalidParameter, fmt.Sprintf(
+			"user(%s) and user(%s) belong to different owners", userIdA, userIdB))
+	}
+	return names, owner, nil
+}
+
+// ResetUserCredentials 在同一个事务中替换用户的密码以及 token，password 为已经计算过摘要的密码
+// 更新 mtime 使得 cache 增量刷新后旧的 token 立即失效
+func (u *userStore) ResetUserCredentials(userId, password, token, modifiedBy string) (err error) {
+	u, span := u.traceOp(context.Background(), "ResetUserCredentials")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if userId == "" || password == "" || token == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"reset user credentials missing some params, id is %s", userId))
+	}
+	encrypted, err := u.tokenCipher.Encrypt(token)
+	if err != nil {
+		log.Error("[Store][User] encrypt user token", zap.String("id", userId), zap.Error(err))
+		return store.Error(err)
+	}
+
+	err = u.writeTransaction("resetUserCredentials", func(tx *BaseTx) error {
+		// 与 updateUserTx 一致，密码发生变化时重置密码修改时间并清除强制修改密码标记，token 发生变化时记录更换时间
+		resetSql := "UPDATE user SET " +
+			" password_set_time = IF(password = ?, password_set_time, sysdate()), " +
+			" must_change_password = IF(password = ?, must_change_password, 0), " +
+			tokenRotatedTimeSet +
+			" password = ?, token = ?, mtime = sysdate(), modified_by = ? WHERE id = ? AND flag = 0"
+		result, err := tx.Exec(resetSql, password, password, encrypted, token, password, encrypted, modifiedBy,
+			userId)
+		if err != nil {
+			return convertUserTokenConflict(userId, err)
+		}
+		if err := checkUserAffectedRows(tx, result, userId); err != nil {
+			return err
+		}
+		if err := u.recordUserChanges(tx, model.UserChangeUpdate, []string{userId}); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Error("[Store][User] reset user credentials", zap.String("id", userId), zap.Error(err))
+		return store.Error(err)
+	}
+	logUserOp("ResetUserCredentials", "[Store][User] reset user credentials", zap.String("id", userId))
+	return nil
+}
+
+// RebuildDefaultStrategy 重建用户的默认策略，先清理残留的数据再通过 createDefaultStrategy 重新创建，整个过程在同一个事务中
+// 用户已经关联了有效的默认策略时只清理残留数据，因此可以重复调用
+func (u *userStore) RebuildDefaultStrategy(userId string) (err error) {
+	u, span := u.traceOp(context.Background(), "RebuildDefaultStrategy")
+	defer func() { span.finish(err) }()
+
+	if userId == "" {
+		return store.NewStatusError(store.EmptyParamsErr, "rebuild default strategy missing user id")
+	}
+
+	err = u.writeTransaction("rebuildDefaultStrategy", func(tx *BaseTx) error {
+		var name, owner string
+		querySql := "SELECT name, owner FROM user WHERE id = ? AND flag = 0 FOR UPDATE"
+		if err := tx.QueryRow(querySql, userId).Scan(&name, &owner); err != nil {
+			if err == sql.ErrNoRows {
+				return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", userId))
+			}
+			return err
+		}
+		if owner == "" {
+			owner = userId
+		}
+
+		rebuild, err := cleanBrokenDefaultStrategy(tx, model.PrincipalUser, userId, name, owner)
+		if err != nil {
+			return err
+		}
+		if rebuild {
+			if err := createDefaultStrategy(tx, model.PrincipalUser, userId, name, owner,
+				u.reuseDefaultStrategy); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Error("[Store][User] rebuild default strategy", zap.String("id", userId), zap.Error(err))
+		return store.Error(err)
+	}
+	logUserOp("RebuildDefaultStrategy", "[Store][User] rebuild default strategy", zap.String("id", userId))
+	return nil
+}
+
+// cleanBrokenDefaultStrategy 清理 principal 默认策略的残留数据，返回是否需要重新创建默认策略
+// 残留数据包括指向已删除或者不存在的策略的关联关系，以及没有关联任何 principal 的同名默认策略
+func cleanBrokenDefaultStrategy(tx *BaseTx, role model.PrincipalType, id, name, owner string) (bool, error) {
+	cleanLinkSql := "DELETE ap FROM auth_principal ap LEFT JOIN auth_strategy ag ON ag.id = ap.strategy_id " +
+		" WHERE ap.principal_id = ? AND ap.principal_role = ? AND (ag.id IS NULL OR ag.flag = 1)"
+	if _, err := tx.Exec(cleanLinkSql, id, role); err != nil {
+		return false, err
+	}
+
+	var linked uint32
+	countSql := "SELECT COUNT(*) FROM auth_principal ap INNER JOIN auth_strategy ag ON ag.id = ap.strategy_id " +
+		" WHERE ap.principal_id = ? AND ap.principal_role = ? AND ag.flag = 0 AND ag.`default` = 1"
+	if err := tx.QueryRow(countSql, id, role).Scan(&linked); err != nil {
+		return false, err
+	}
+	if linked > 0 {
+		return false, nil
+	}
+
+	var orphanId string
+	orphanSql := "SELECT id FROM auth_strategy ag WHERE name = ? AND owner = ? AND flag = 0 AND `default` = 1 " +
+		" AND NOT EXISTS (SELECT 1 FROM auth_principal ap WHERE ap.strategy_id = ag.id)"
+	switch err := tx.QueryRow(orphanSql, model.BuildDefaultStrategyName(role, name), owner).Scan(&orphanId); err {
+	case sql.ErrNoRows:
+		return true, nil
+	case nil:
+	default:
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM auth_strategy_resource WHERE strategy_id = ?", orphanId); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM auth_strategy WHERE id = ?", orphanId); err != nil {
+		return false, err
+	}
+	log.Info("[Store][Strategy] remove orphaned default strategy", zap.String("id", orphanId),
+		zap.String("principal", id), zap.String("name", name))
+	return true, nil
+}
+
+// PurgeDeletedUsers 物理删除在指定时间之前被逻辑删除的用户
+func (u *userStore) PurgeDeletedUsers(deletedBefore time.Time) (_ uint32, err error) {
+	u, span := u.traceOp(context.Background(), "PurgeDeletedUsers")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	// 被移除的关联关系保留到 deletedBefore 之后再清理，缓存在此之前已经同步到移除操作，
+	// 被清理的用户的关联关系不论是否已经移除都一起清理
+	relationSql := "DELETE FROM user_group_relation WHERE (flag = 1 AND mtime < FROM_UNIXTIME(?))" +
+		" OR user_id IN (SELECT id FROM user WHERE flag = 1 AND deleted_at IS NOT NULL" +
+		" AND deleted_at < FROM_UNIXTIME(?))"
+	purgeSql := "DELETE FROM user WHERE flag = 1 AND deleted_at IS NOT NULL AND deleted_at < FROM_UNIXTIME(?)"
+	before := timeToTimestamp(deletedBefore)
+	var rows int64
+	err = u.writeTransaction("purgeDeletedUsers", func(tx *BaseTx) error {
+		if _, err := tx.Exec(relationSql, before, before); err != nil {
+			return err
+		}
+		result, err := tx.Exec(purgeSql, before)
+		if err != nil {
+			return err
+		}
+		if rows, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Error("[Store][User] purge deleted users", zap.Time("before", deletedBefore), zap.Error(err))
+		return 0, store.Error(err)
+	}
+	logUserOp("PurgeDeletedUsers", "[Store][User] purge deleted users", zap.Time("before", deletedBefore),
+		zap.Int64("rows", rows))
+	return uint32(rows), nil
+}
+
+// SetUsersTokenEnable 批量启用或者禁用用户的 token，只更新状态发生变化的有效用户，返回发生变化的用户数量
+func (u *userStore) SetUsersTokenEnable(ids []string, enable bool, modifiedBy string) (_ uint32, err error) {
+	u, span := u.traceOp(context.Background(), "SetUsersTokenEnable")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if len(ids) == 0 {
+		return 0, store.NewStatusError(store.EmptyParamsErr, "set users token enable missing user ids")
+	}
+
+	var rows int64
+	err = u.writeTransaction("setUsersTokenEnable", func(tx *BaseTx) error {
+		var err error
+		if rows, err = u.setUsersTokenEnableTx(tx, ids, enable, modifiedBy); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Error("[Store][User] set users token enable", zap.Strings("ids", ids), zap.Bool("enable", enable),
+			zap.Error(err))
+		return 0, store.Error(err)
+	}
+	logUserOp("SetUsersTokenEnable", "[Store][User] set users token enable", zap.Strings("ids", ids),
+		zap.Bool("enable", enable), zap.Int64("rows", rows))
+	return uint32(rows), nil
+}
+
+// setUsersTokenEnableTx 开启了 userChangeLog 时需要先锁定状态会发生变化的用户，以便记录这些用户的变更
+func (u *userStore) setUsersTokenEnableTx(tx *BaseTx, ids []string, enable bool, modifiedBy string) (int64, error) {
+	return u.setUsersColumnTx(tx, "token_enable", boolToInt(enable), ids, modifiedBy)
+}
+
+// SetUsersComment 批量设置用户的备注，只更新备注发生变化的有效用户，返回发生变化的用户数量
+func (u *userStore) SetUsersComment(ids []string, comment, modifiedBy string) (_ uint32, err error) {
+	u, span := u.traceOp(context.Background(), "SetUsersComment")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if len(ids) == 0 {
+		return 0, store.NewStatusError(store.EmptyParamsErr, "set users comment missing user ids")
+	}
+	if err := store.CheckUserComment(comment); err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	err = u.writeTransaction("setUsersComment", func(tx *BaseTx) error {
+		var err error
+		if rows, err = u.setUsersColumnTx(tx, "comment", comment, ids, modifiedBy); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Error("[Store][User] set users comment", zap.Strings("ids", ids), zap.Error(err))
+		return 0, store.Error(err)
+	}
+	logUserOp("SetUsersComment", "[Store][User] set users comment", zap.Strings("ids", ids),
+		zap.Int64("rows", rows))
+	return uint32(rows), nil
+}
+
+// setUsersColumnTx 通过一条 UPDATE 将有效用户的 column 字段设置为 value，只更新取值发生变化的用户，
+// 开启了 userChangeLog 时需要先锁定取值会发生变化的用户，以便记录这些用户的变更，modifiedBy 记录为修改人
+func (u *userStore) setUsersColumnTx(tx *BaseTx, column string, value interface{}, ids []string,
+	modifiedBy string) (int64, error) {
+	args := make([]interface{}, 0, len(ids)+2)
+	args = append(args, value)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	if u.changeLog {
+		lockSql := "SELECT id FROM user WHERE flag = 0 AND " + column + " != ? AND id IN (" +
+			placeholders(len(ids)) + ") FOR UPDATE"
+		rows, err := tx.Query(lockSql, args...)
+		if err != nil {
+			return 0, err
+		}
+		changed := make([]string, 0, len(ids))
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return 0, err
+			}
+			changed = append(changed, id)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		if len(changed) == 0 {
+			return 0, nil
+		}
+		ids = changed
+		args = args[:1]
+		for _, id := range ids {
+			args = append(args, id)
+		}
 	}
 
-	if _, err = tx.Exec("DELETE FROM user_group_relation WHERE user_id = ?", user.ID); err != nil {
-		log.Error("[Store][User] delete usergroup relation", zap.Error(err))
-		return err
+	updateSql := "UPDATE user SET " + column + " = ?, mtime = sysdate(), modified_by = ? WHERE flag = 0 AND " + column +
+		" != ? AND id IN (" + placeholders(len(ids)) + ")"
+	result, err := tx.Exec(updateSql, append([]interface{}{value, modifiedBy}, args...)...)
+	if err != nil {
+		return 0, err
 	}
-
-	if err := tx.Commit(); err != nil {
-		log.Error("[Store][User] delete user tx commit", zap.Error(err))
-		return err
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return rows, u.recordUserChanges(tx, model.UserChangeUpdate, ids)
 }
 
-// GetSubCount get user's sub count
-func (u *userStore) GetSubCount(user *model.User) (uint32, error) {
-	var (
-		countSql   = "SELECT COUNT(*) FROM user WHERE owner = ? AND flag = 0"
-		count, err = queryEntryCount(u.master, countSql, []interface{}{user.ID})
-	)
+// FindDuplicateTokens 查询共用同一个 token 的有效用户，只读的诊断接口
+// 不同 key 加密或者未加密的同一个 token 落库后的取值不同，唯一索引无法发现，因此需要解密后再比较
+func (u *userStore) FindDuplicateTokens() (_ [][]string, err error) {
+	u, span := u.traceOp(context.Background(), "FindDuplicateTokens")
+	defer func() { span.finish(err) }()
 
+	userTokens, err := u.loadUserTokens()
 	if err != nil {
-		log.Error("[Store][User] count sub-account", zap.String("owner", user.Owner), zap.Error(err))
+		return nil, err
 	}
-
-	return count, err
+	return store.DuplicateTokenGroups(userTokens), nil
 }
 
-// GetUser get user by user id
-func (u *userStore) GetUser(id string) (*model.User, error) {
-	var tokenEnable, userType int
-	getSql := `
-		 SELECT u.id, u.name, u.password, u.owner, u.comment, u.source, u.token, u.token_enable, 
-		 	u.user_type, u.mobile, u.email
-		 FROM user u
-		 WHERE u.flag = 0 AND u.id = ? 
-	  `
-	var (
-		row  = u.master.QueryRow(getSql, id)
-		user = new(model.User)
-	)
+// FindDuplicateUserNames 查询同一个 owner 下名称相同的有效用户，只读的诊断接口
+// nameCaseInsensitive 时按照 name_lower 比较，与唯一索引的字段保持一致
+func (u *userStore) FindDuplicateUserNames() (_ [][]string, err error) {
+	u, span := u.traceOp(context.Background(), "FindDuplicateUserNames")
+	defer func() { span.finish(err) }()
 
-	if err := row.Scan(&user.ID, &user.Name, &user.Password, &user.Owner, &user.Comment, &user.Source,
-		&user.Token, &tokenEnable, &userType, &user.Mobile, &user.Email); err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, nil
-		default:
+	nameColumn, _ := u.userNameKey("")
+	rows, err := u.readDB().Query("SELECT id, " + nameColumn + ", owner FROM user WHERE flag = 0")
+	if err != nil {
+		log.Error("[Store][User] find duplicate user names", zap.Error(err))
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	userNames := make(map[string]store.UserNameKey)
+	for rows.Next() {
+		var (
+			id  string
+			key store.UserNameKey
+		)
+		if err := rows.Scan(&id, &key.Name, &key.Owner); err != nil {
 			return nil, store.Error(err)
 		}
+		userNames[id] = key
 	}
-
-	user.TokenEnable = tokenEnable == 1
-	user.Type = model.UserRoleType(userType)
-	// 北极星后续不在保存用户的 mobile 以及 email 信息，这里针对原来保存的数据也不进行对外展示，强制屏蔽数据
-	user.Mobile = ""
-	user.Email = ""
-	return user, nil
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	return store.DuplicateUserNameGroups(userNames), nil
 }
 
-// GetUserByName 根据用户名、owner 获取用户
-func (u *userStore) GetUserByName(name, ownerId string) (*model.User, error) {
-	getSql := `
-		 SELECT u.id, u.name, u.password, u.owner, u.comment, u.source, u.token, u.token_enable, 
-		 	u.user_type, u.mobile, u.email
-		 FROM user u
-		 WHERE u.flag = 0
-			  AND u.name = ?
-			  AND u.owner = ? 
-	  `
+// FindWeakTokens 查询 token 的熵低于 minEntropyBits 的有效用户，只读的诊断接口
+func (u *userStore) FindWeakTokens(minEntropyBits float64) (_ []string, err error) {
+	u, span := u.traceOp(context.Background(), "FindWeakTokens")
+	defer func() { span.finish(err) }()
 
-	var (
-		row                   = u.master.QueryRow(getSql, name, ownerId)
-		user                  = new(model.User)
-		tokenEnable, userType int
-	)
+	if err := store.CheckMinEntropyBits(minEntropyBits); err != nil {
+		return nil, err
+	}
+	userTokens, err := u.loadUserTokens()
+	if err != nil {
+		return nil, err
+	}
+	return store.WeakTokenUsers(userTokens, minEntropyBits), nil
+}
 
-	if err := row.Scan(&user.ID, &user.Name, &user.Password, &user.Owner, &user.Comment, &user.Source,
-		&user.Token, &tokenEnable, &userType, &user.Mobile, &user.Email); err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, nil
-		default:
-			return nil, store.Error(err)
-		}
+// AuditDefaultStrategies 查询缺少默认策略的有效用户，只读的诊断接口
+// 默认策略不存在、已经被删除、名称与用户名不一致或者没有关联到用户时均视为缺少
+func (u *userStore) AuditDefaultStrategies() (_ []string, err error) {
+	u, span := u.traceOp(context.Background(), "AuditDefaultStrategies")
+	defer func() { span.finish(err) }()
+
+	db := u.readDB()
+	userNames := make(map[string]string)
+	if err := scanStringPairs(db, "SELECT id, name FROM user WHERE flag = 0", nil,
+		func(id, name string) {
+			userNames[id] = name
+		}); err != nil {
+		log.Error("[Store][User] audit default strategies load users", zap.Error(err))
+		return nil, store.Error(err)
 	}
 
-	user.TokenEnable = tokenEnable == 1
-	user.Type = model.UserRoleType(userType)
-	// 北极星后续不在保存用户的 mobile 以及 email 信息，这里针对原来保存的数据也不进行对外展示，强制屏蔽数据
-	user.Mobile = ""
-	user.Email = ""
-	return user, nil
+	linked := make(map[string][]string)
+	linkSql := "SELECT ap.principal_id, ag.name FROM auth_principal ap INNER JOIN auth_strategy ag " +
+		" ON ag.id = ap.strategy_id WHERE ap.principal_role = ? AND ag.flag = 0 AND ag.`default` = 1"
+	if err := scanStringPairs(db, linkSql, []interface{}{model.PrincipalUser},
+		func(id, name string) {
+			linked[id] = append(linked[id], name)
+		}); err != nil {
+		log.Error("[Store][User] audit default strategies load links", zap.Error(err))
+		return nil, store.Error(err)
+	}
+	return store.MissingDefaultStrategyUsers(userNames, linked), nil
 }
 
-// GetUserByIds Get user list data according to user ID
-func (u *userStore) GetUserByIds(ids []string) ([]*model.User, error) {
-	if len(ids) == 0 {
-		return nil, nil
+// scanStringPairs 执行返回两列字符串的查询，逐行回调
+func scanStringPairs(db *BaseDB, querySql string, args []interface{}, handle func(first, second string)) error {
+	rows, err := db.Query(querySql, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var first, second string
+		if err := rows.Scan(&first, &second); err != nil {
+			return err
+		}
+		handle(first, second)
 	}
+	return rows.Err()
+}
 
-	getSql := `
-	  SELECT u.id, u.name, u.password, u.owner, u.comment, u.source
-		  , u.token, u.token_enable, u.user_type, UNIX_TIMESTAMP(u.ctime)
-		  , UNIX_TIMESTAMP(u.mtime), u.flag, u.mobile, u.email
-	  FROM user u
-	  WHERE u.flag = 0 
-		  AND u.id IN ( 
-	  `
+// loadUserTokens 读取全部有效用户解密后的 token，返回用户 ID 到 token 的映射
+func (u *userStore) loadUserTokens() (map[string]string, error) {
+	rows, err := u.readDB().Query("SELECT id, token FROM user WHERE flag = 0")
+	if err != nil {
+		log.Error("[Store][User] load user tokens", zap.Error(err))
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
 
-	for i := range ids {
-		getSql += " ? "
-		if i != len(ids)-1 {
-			getSql += ","
+	userTokens := make(map[string]string)
+	for rows.Next() {
+		user := &model.User{}
+		if err := rows.Scan(&user.ID, &user.Token); err != nil {
+			return nil, store.Error(err)
 		}
+		if err := u.decryptToken(user); err != nil {
+			return nil, err
+		}
+		userTokens[user.ID] = user.Token
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
 	}
-	getSql += ")"
+	return userTokens, nil
+}
 
-	args := make([]interface{}, 0, 8)
-	for index := range ids {
-		args = append(args, ids[index])
+// decryptToken 解密从数据库中读取的用户 token
+func (u *userStore) decryptToken(user *model.User) error {
+	token, err := u.tokenCipher.Decrypt(user.Token)
+	if err != nil {
+		log.Error("[Store][User] decrypt user token", zap.String("id", user.ID), zap.Error(err))
+		return store.Error(err)
 	}
+	user.Token = token
+	return nil
+}
 
-	rows, err := u.master.Query(getSql, args...)
+// collectUsers General query user list, op is the name of the store method which is used to pick the log level
+func (u *userStore) collectUsers(op string, handler QueryHandler, querySql string,
+	args []interface{}) ([]*model.User, error) {
+	logUserOp(op, "[Store][User] list user", zap.String("query sql", querySql), zap.Any("args", args))
+	rows, err := handler(querySql, args...)
 	if err != nil {
+		log.Error("[Store][User] list user ", zap.String("query sql", querySql), zap.Any("args", args), zap.Error(err))
 		return nil, store.Error(err)
 	}
 	defer func() {
 		_ = rows.Close()
 	}()
-
 	users := make([]*model.User, 0)
 	for rows.Next() {
 		user, err := fetchRown2User(rows)
@@ -353,197 +2674,170 @@ func (u *userStore) GetUserByIds(ids []string) ([]*model.User, error) {
 			log.Errorf("[Store][User] fetch user rows scan err: %s", err.Error())
 			return nil, store.Error(err)
 		}
+		if err := u.decryptToken(user); err != nil {
+			return nil, err
+		}
 		users = append(users, user)
 	}
 
 	return users, nil
 }
 
-// GetUsers Query user list information
-// Case 1. From the user's perspective, normal query conditions
-// Case 2. From the perspective of the user group, query is the list of users involved under a user group.
-func (u *userStore) GetUsers(filters map[string]string, offset uint32, limit uint32) (uint32,
-	[]*model.User, error) {
-	if _, ok := filters["group_id"]; ok {
-		return u.listGroupUsers(filters, offset, limit)
-	}
-	return u.listUsers(filters, offset, limit)
+// defaultStrategyPrincipal 需要批量创建默认策略的 principal
+type defaultStrategyPrincipal struct {
+	ID    string
+	Name  string
+	Owner string
 }
 
-// listUsers Query user list information
-func (u *userStore) listUsers(filters map[string]string, offset uint32, limit uint32) (uint32,
-	[]*model.User, error) {
-	countSql := "SELECT COUNT(*) FROM user WHERE flag = 0 "
-	getSql := `
-	  SELECT id, name, password, owner, comment, source
-		  , token, token_enable, user_type, UNIX_TIMESTAMP(ctime)
-		  , UNIX_TIMESTAMP(mtime), flag, mobile, email
-	  FROM user
-	  WHERE flag = 0 
-	  `
-
-	if val, ok := filters["hide_admin"]; ok && val == "true" {
-		delete(filters, "hide_admin")
-		countSql += "  AND user_type != 0 "
-		getSql += "  AND user_type != 0 "
+// createDefaultStrategies 批量创建默认策略，语义与 createDefaultStrategy 一致，按 batchInsertSize 分批使用多行 INSERT 写入
+func createDefaultStrategies(tx *BaseTx, role model.PrincipalType, principals []defaultStrategyPrincipal,
+	reuseConflict bool) error {
+	ids := make(map[string]struct{}, len(principals))
+	for start := 0; start < len(principals); start += batchInsertSize {
+		chunk := principals[start:min(start+batchInsertSize, len(principals))]
+		if err := createDefaultStrategiesChunk(tx, role, chunk, ids, reuseConflict); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	args := make([]interface{}, 0)
-
-	if len(filters) != 0 {
-		for k, v := range filters {
-			getSql += " AND "
-			countSql += " AND "
-			if k == NameAttribute {
-				if utils.IsPrefixWildName(v) {
-					getSql += " " + k + " like ? "
-					countSql += " " + k + " like ? "
-					args = append(args, "%"+v[:len(v)-1]+"%")
-				} else {
-					getSql += " " + k + " = ? "
-					countSql += " " + k + " = ? "
-					args = append(args, v)
-				}
-			} else if k == OwnerAttribute {
-				getSql += " (id = ? OR owner = ?) "
-				countSql += " (id = ? OR owner = ?) "
-				args = append(args, v, v)
-				continue
-			} else {
-				getSql += " " + k + " = ? "
-				countSql += " " + k + " = ? "
-				args = append(args, v)
+func createDefaultStrategiesChunk(tx *BaseTx, role model.PrincipalType, principals []defaultStrategyPrincipal,
+	ids map[string]struct{}, reuseConflict bool) error {
+	// 生成的策略 ID 在整个批次内保证唯一
+	newID := func() string {
+		for {
+			id := utils.NewUUID()
+			if _, ok := ids[id]; !ok {
+				ids[id] = struct{}{}
+				return id
 			}
 		}
 	}
 
-	count, err := queryEntryCount(u.master, countSql, args)
-	if err != nil {
-		return 0, nil, store.Error(err)
-	}
-
-	getSql += " ORDER BY mtime LIMIT ? , ?"
-	getArgs := append(args, offset, limit)
-
-	users, err := u.collectUsers(u.master.Query, getSql, getArgs)
-	if err != nil {
-		return 0, nil, err
+	strategies := make([]*model.StrategyDetail, 0, len(principals))
+	keyArgs := make([]interface{}, 0, 2*len(principals))
+	for _, p := range principals {
+		owner := p.Owner
+		if owner == "" {
+			owner = p.ID
+		}
+		strategies = append(strategies, &model.StrategyDetail{
+			ID:       newID(),
+			Name:     model.BuildDefaultStrategyName(role, p.Name),
+			Action:   apisecurity.AuthAction_READ_WRITE.String(),
+			Default:  true,
+			Owner:    owner,
+			Revision: utils.NewUUID(),
+			Valid:    true,
+			Comment:  "Default Strategy",
+		})
+		keyArgs = append(keyArgs, strategies[len(strategies)-1].Name, owner)
 	}
-	return count, users, nil
-}
+	keyIn := "(name, owner) IN (" + repeatPlaceholders("(?,?)", len(principals)) + ")"
 
-// listGroupUsers Check the user information under a user group
-func (u *userStore) listGroupUsers(filters map[string]string, offset uint32, limit uint32) (uint32,
-	[]*model.User, error) {
-	if _, ok := filters[GroupIDAttribute]; !ok {
-		return 0, nil, store.NewStatusError(store.EmptyParamsErr, "group_id is missing")
+	// 需要清理过期的 auth_strategy
+	cleanInvalidRule := "DELETE FROM auth_strategy WHERE flag = 1 AND `default` = 1 AND " + keyIn
+	if _, err := tx.Exec(cleanInvalidRule, keyArgs...); err != nil {
+		return err
 	}
 
-	args := make([]interface{}, 0, len(filters))
-	querySql := `
-		  SELECT u.id, name, password, owner, u.comment, source
-			  , token, token_enable, user_type, UNIX_TIMESTAMP(u.ctime)
-			  , UNIX_TIMESTAMP(u.mtime), u.flag, u.mobile, u.email
-		  FROM user_group_relation ug
-			  LEFT JOIN user u ON ug.user_id = u.id AND u.flag = 0
-		  WHERE 1=1 
-	  `
-	countSql := `
-		  SELECT COUNT(*)
-		  FROM user_group_relation ug
-			  LEFT JOIN user u ON ug.user_id = u.id AND u.flag = 0 
-		  WHERE 1=1 
-	  `
-
-	if val, ok := filters["hide_admin"]; ok && val == "true" {
-		delete(filters, "hide_admin")
-		countSql += " AND u.user_type != 0 "
-		querySql += " AND u.user_type != 0 "
+	existing, err := loadDefaultStrategyPrincipals(tx, role, keyArgs)
+	if err != nil {
+		return err
 	}
 
-	for k, v := range filters {
-		if newK, ok := userLinkGroupAttributeMapping[k]; ok {
-			k = newK
-		}
-
-		if k == "ug.owner" {
-			k = "u.owner"
+	mainArgs := make([]interface{}, 0, 8*len(strategies))
+	principalArgs := make([]interface{}, 0, 3*len(strategies))
+	seen := make(map[string]struct{}, len(strategies))
+	for i, strategy := range strategies {
+		key := strategy.Name + "/" + strategy.Owner
+		if _, ok := seen[key]; ok {
+			return store.NewStatusError(store.DuplicateEntryErr, fmt.Sprintf(
+				"default strategy(%s) of owner(%s) is duplicated in batch", strategy.Name, strategy.Owner))
 		}
+		seen[key] = struct{}{}
 
-		if utils.IsPrefixWildName(v) {
-			querySql += " AND " + k + " like ?"
-			countSql += " AND " + k + " like ?"
-			args = append(args, v[:len(v)-1]+"%")
-		} else {
-			querySql += " AND " + k + " = ?"
-			countSql += " AND " + k + " = ?"
-			args = append(args, v)
+		// 同名的默认策略仍然有效时，若关联的就是当前 principal 则直接复用，否则按照配置复用或者明确报错
+		if linked, ok := existing[key]; ok {
+			if _, ok := linked.principals[principals[i].ID]; ok {
+				continue
+			}
+			if !reuseConflict {
+				return defaultStrategyConflictErr(strategy.Name, strategy.Owner)
+			}
+			if err := linkDefaultStrategy(tx, role, linked.id, principals[i].ID); err != nil {
+				return err
+			}
+			continue
 		}
+		mainArgs = append(mainArgs, strategy.ID, strategy.Name, strategy.Action, strategy.Owner,
+			strategy.Comment, 0, strategy.Default, strategy.Revision)
+		principalArgs = append(principalArgs, strategy.ID, principals[i].ID, role)
 	}
-
-	count, err := queryEntryCount(u.slave, countSql, args)
-	if err != nil {
-		return 0, nil, err
+	if len(principalArgs) == 0 {
+		return nil
 	}
 
-	querySql += " ORDER BY u.mtime LIMIT ? , ?"
-	args = append(args, offset, limit)
-
-	users, err := u.collectUsers(u.master.Query, querySql, args)
-	if err != nil {
-		return 0, nil, err
+	count := len(principalArgs) / 3
+	saveMainSql := "INSERT INTO auth_strategy(`id`, `name`, `action`, `owner`, `comment`, `flag`, " +
+		" `default`, `revision`) VALUES " + repeatPlaceholders("(?,?,?,?,?,?,?,?)", count)
+	if _, err := tx.Exec(saveMainSql, mainArgs...); err != nil {
+		return err
 	}
 
-	return count, users, nil
+	savePrincipalSql := "INSERT INTO auth_principal(`strategy_id`, `principal_id`, `principal_role`) VALUES " +
+		repeatPlaceholders("(?,?,?)", count)
+	_, err = tx.Exec(savePrincipalSql, principalArgs...)
+	return err
 }
 
-// GetUsersForCache Get user information, mainly for cache
-func (u *userStore) GetUsersForCache(mtime time.Time, firstUpdate bool) ([]*model.User, error) {
-	args := make([]interface{}, 0)
-	querySql := `
-	  SELECT u.id, u.name, u.password, u.owner, u.comment, u.source
-		  , u.token, u.token_enable, user_type, UNIX_TIMESTAMP(u.ctime)
-		  , UNIX_TIMESTAMP(u.mtime), u.flag, u.mobile, u.email
-	  FROM user u 
-	  `
-
-	if !firstUpdate {
-		querySql += " WHERE u.mtime >= FROM_UNIXTIME(?) "
-		args = append(args, timeToTimestamp(mtime))
-	}
-
-	users, err := u.collectUsers(u.master.Query, querySql, args)
-	if err != nil {
-		return nil, err
-	}
-
-	return users, nil
+// existingDefaultStrategy 仍然有效的同名默认策略以及其已经关联的 principal
+type existingDefaultStrategy struct {
+	id         string
+	principals map[string]struct{}
 }
 
-// collectUsers General query user list
-func (u *userStore) collectUsers(handler QueryHandler, querySql string, args []interface{}) ([]*model.User, error) {
-	rows, err := u.master.Query(querySql, args...)
+// loadDefaultStrategyPrincipals 查询仍然有效的同名默认策略，返回 name/owner 到策略以及已关联 principal 的映射
+func loadDefaultStrategyPrincipals(tx *BaseTx, role model.PrincipalType,
+	keyArgs []interface{}) (map[string]*existingDefaultStrategy, error) {
+	querySql := "SELECT s.id, s.name, s.owner, IFNULL(p.principal_id, '') FROM auth_strategy s " +
+		" LEFT JOIN auth_principal p ON p.strategy_id = s.id AND p.principal_role = ? " +
+		" WHERE s.flag = 0 AND s.`default` = 1 AND (s.name, s.owner) IN (" +
+		repeatPlaceholders("(?,?)", len(keyArgs)/2) + ")"
+	rows, err := tx.Query(querySql, append([]interface{}{role}, keyArgs...)...)
 	if err != nil {
-		log.Error("[Store][User] list user ", zap.String("query sql", querySql), zap.Any("args", args), zap.Error(err))
-		return nil, store.Error(err)
+		return nil, err
 	}
 	defer func() {
 		_ = rows.Close()
 	}()
-	users := make([]*model.User, 0)
+
+	ret := make(map[string]*existingDefaultStrategy)
 	for rows.Next() {
-		user, err := fetchRown2User(rows)
-		if err != nil {
-			log.Errorf("[Store][User] fetch user rows scan err: %s", err.Error())
-			return nil, store.Error(err)
+		var id, name, owner, principalId string
+		if err := rows.Scan(&id, &name, &owner, &principalId); err != nil {
+			return nil, err
+		}
+		key := name + "/" + owner
+		if _, ok := ret[key]; !ok {
+			ret[key] = &existingDefaultStrategy{id: id, principals: make(map[string]struct{})}
+		}
+		if principalId != "" {
+			ret[key].principals[principalId] = struct{}{}
 		}
-		users = append(users, user)
 	}
+	return ret, rows.Err()
+}
 
-	return users, nil
+// repeatPlaceholders 将单行的占位符重复 n 次，用于拼接多行 INSERT 或者多列 IN 条件
+func repeatPlaceholders(row string, n int) string {
+	return strings.TrimSuffix(strings.Repeat(row+",", n), ",")
 }
 
-func createDefaultStrategy(tx *BaseTx, role model.PrincipalType, id, name, owner string) error {
+// reuseConflict 为 true 时，同名的默认策略属于其他 principal 则将当前 principal 关联到该策略上，否则返回 DataConflictErr
+func createDefaultStrategy(tx *BaseTx, role model.PrincipalType, id, name, owner string, reuseConflict bool) error {
 	if strings.Compare(owner, "") == 0 {
 		owner = id
 	}
@@ -568,6 +2862,15 @@ func createDefaultStrategy(tx *BaseTx, role model.PrincipalType, id, name, owner
 		return err
 	}
 
+	// 同名的默认策略仍然有效时，若关联的就是当前 principal 则直接复用，否则按照配置复用或者明确报错
+	reuse, err := checkDefaultStrategyConflict(tx, role, id, strategy.Name, strategy.Owner, reuseConflict)
+	if err != nil {
+		return err
+	}
+	if reuse {
+		return nil
+	}
+
 	// Save policy master information
 	saveMainSql := "INSERT INTO auth_strategy(`id`, `name`, `action`, `owner`, `comment`, `flag`, " +
 		" `default`, `revision`) VALUES (?,?,?,?,?,?,?,?)"
@@ -579,29 +2882,135 @@ func createDefaultStrategy(tx *BaseTx, role model.PrincipalType, id, name, owner
 
 	// Insert User / Group and Policy Association
 	savePrincipalSql := "INSERT INTO auth_principal(`strategy_id`, `principal_id`, `principal_role`) VALUES (?,?,?)"
-	_, err := tx.Exec(savePrincipalSql, []interface{}{strategy.ID, id, role}...)
+	_, err = tx.Exec(savePrincipalSql, []interface{}{strategy.ID, id, role}...)
+	return err
+}
+
+// checkDefaultStrategyConflict 检查是否已经存在同名且有效的默认策略，返回是否已经复用该策略
+func checkDefaultStrategyConflict(tx *BaseTx, role model.PrincipalType, id, name, owner string,
+	reuseConflict bool) (bool, error) {
+	var strategyId string
+	querySql := "SELECT id FROM auth_strategy WHERE name = ? AND owner = ? AND flag = 0 AND `default` = 1"
+	if err := tx.QueryRow(querySql, name, owner).Scan(&strategyId); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var count uint32
+	countSql := "SELECT COUNT(*) FROM auth_principal WHERE strategy_id = ? AND principal_id = ? AND principal_role = ?"
+	if err := tx.QueryRow(countSql, strategyId, id, role).Scan(&count); err != nil {
+		return false, err
+	}
+	if count == 0 {
+		if !reuseConflict {
+			return false, defaultStrategyConflictErr(name, owner)
+		}
+		if err := linkDefaultStrategy(tx, role, strategyId, id); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	log.Info("[Store][Strategy] reuse existing default strategy", zap.String("id", strategyId),
+		zap.String("principal", id), zap.String("name", name))
+	return true, nil
+}
+
+// linkDefaultStrategy 将 principal 关联到其他 principal 的同名默认策略上，同时更新策略的 mtime 触发缓存刷新
+func linkDefaultStrategy(tx *BaseTx, role model.PrincipalType, strategyId, principalId string) error {
+	log.Warn("[Store][Strategy] default strategy belongs to other principal, reuse it",
+		zap.String("id", strategyId), zap.String("principal", principalId))
+	savePrincipalSql := "INSERT INTO auth_principal(`strategy_id`, `principal_id`, `principal_role`) VALUES (?,?,?)"
+	if _, err := tx.Exec(savePrincipalSql, strategyId, principalId, role); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE auth_strategy SET revision = ?, mtime = sysdate() WHERE id = ?",
+		utils.NewUUID(), strategyId)
 	return err
 }
 
-func fetchRown2User(rows *sql.Rows) (*model.User, error) {
+// renameDefaultStrategy principal 改名时同步修改其默认策略的名称，同时更新策略的 revision 以及 mtime 触发缓存刷新
+// 只修改关联了该 principal 并且名称由原名称生成的默认策略，没有这样的策略时直接跳过
+func renameDefaultStrategy(tx *BaseTx, role model.PrincipalType, id, oldName, newName string) error {
+	oldStrategyName := model.BuildDefaultStrategyName(role, oldName)
+	newStrategyName := model.BuildDefaultStrategyName(role, newName)
+	if oldStrategyName == newStrategyName {
+		return nil
+	}
+
+	var strategyId, owner string
+	querySql := "SELECT ag.id, ag.owner FROM auth_strategy ag INNER JOIN auth_principal ap ON ap.strategy_id = ag.id " +
+		" WHERE ap.principal_id = ? AND ap.principal_role = ? AND ag.name = ? AND ag.flag = 0 AND ag.`default` = 1 " +
+		" FOR UPDATE"
+	switch err := tx.QueryRow(querySql, id, role, oldStrategyName).Scan(&strategyId, &owner); err {
+	case sql.ErrNoRows:
+		return nil
+	case nil:
+	default:
+		return err
+	}
+
+	// 与创建默认策略一致，先清理同名的过期策略，仍然有效的同名策略属于其他 principal，不能覆盖
+	cleanInvalidRule := "DELETE FROM auth_strategy WHERE name = ? AND owner = ? AND flag = 1 AND `default` = 1"
+	if _, err := tx.Exec(cleanInvalidRule, newStrategyName, owner); err != nil {
+		return err
+	}
+	var count uint32
+	countSql := "SELECT COUNT(*) FROM auth_strategy WHERE name = ? AND owner = ? AND id != ? AND flag = 0"
+	if err := tx.QueryRow(countSql, newStrategyName, owner, strategyId).Scan(&count); err != nil {
+		return err
+	}
+	if count != 0 {
+		return defaultStrategyConflictErr(newStrategyName, owner)
+	}
+
+	renameSql := "UPDATE auth_strategy SET name = ?, revision = ?, mtime = sysdate() WHERE id = ?"
+	if _, err := tx.Exec(renameSql, newStrategyName, utils.NewUUID(), strategyId); err != nil {
+		return err
+	}
+	log.Info("[Store][Strategy] rename default strategy", zap.String("id", strategyId),
+		zap.String("principal", id), zap.String("name", newStrategyName))
+	return nil
+}
+
+// defaultStrategyConflictErr 同名的默认策略属于其他 principal，通常是导入的数据中复用了相同的 ID 或者名称
+func defaultStrategyConflictErr(name, owner string) error {
+	return store.NewStatusError(store.DataConflictErr, fmt.Sprintf(
+		"default strategy(%s) of owner(%s) already exists and belongs to other principal, "+
+			"set defaultStrategyConflict to reuse to share it", name, owner))
+}
+
+// rowScanner *sql.Row 以及 *sql.Rows 共有的读取方法
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// fetchRown2User 读取按照 userListColumns 顺序查询的用户，extra 为追加在用户列之后的列
+func fetchRown2User(rows rowScanner, extra ...interface{}) (*model.User, error) {
 	var (
-		ctime, mtime                int64
-		flag, tokenEnable, userType int
-		user                        = new(model.User)
-		err                         = rows.Scan(&user.ID, &user.Name, &user.Password, &user.Owner,
-			&user.Comment, &user.Source, &user.Token, &tokenEnable, &userType, &ctime, &mtime,
-			&flag, &user.Mobile, &user.Email)
+		ctime, mtime, lastLogin, pwdSetTime, dtime int64
+		tokenEnable                                sql.NullInt64
+		flag, userType, mustChangePass             int
+		user                                       = new(model.User)
 	)
+	dest := append([]interface{}{&user.ID, &user.Name, &user.Password, &user.Owner,
+		&user.Comment, &user.Source, &user.Token, &tokenEnable, &userType, &ctime, &mtime,
+		&flag, &user.Mobile, &user.Email, &lastLogin, &pwdSetTime, &mustChangePass, &dtime}, extra...)
 
-	if err != nil {
+	if err := rows.Scan(dest...); err != nil {
 		return nil, err
 	}
 
 	user.Valid = flag == 0
-	user.TokenEnable = tokenEnable == 1
+	user.TokenEnable = isTokenEnabled(tokenEnable)
 	user.CreateTime = time.Unix(ctime, 0)
 	user.ModifyTime = time.Unix(mtime, 0)
 	user.Type = model.UserRoleType(userType)
+	user.LastLoginTime = unixToOptionalTime(lastLogin)
+	user.PasswordSetTime = unixToOptionalTime(pwdSetTime)
+	user.MustChangePassword = mustChangePass == 1
+	user.DeleteTime = unixToOptionalTime(dtime)
 
 	// 北极星后续不在保存用户的 mobile 以及 email 信息，这里针对原来保存的数据也不进行对外展示，强制屏蔽数据
 	user.Mobile = ""
@@ -610,13 +3019,79 @@ func fetchRown2User(rows *sql.Rows) (*model.User, error) {
 	return user, nil
 }
 
+// genUserOrderSQL 生成用户列表的排序语句，未指定排序参数时按 mtime 排序
+// collation 已经在 store.ParseUserOrder 中经过白名单校验，可以直接拼接
+func genUserOrderSQL(order *store.UserOrder, prefix string) string {
+	if order == nil {
+		return " ORDER BY " + prefix + "mtime"
+	}
+	column := prefix + order.Field
+	if order.Collation != "" {
+		column += " COLLATE " + order.Collation
+	}
+	sequence := "ASC"
+	if order.Desc {
+		sequence = "DESC"
+	}
+	// 排序字段相同时按 id 排序，保证分页结果稳定
+	return fmt.Sprintf(" ORDER BY %s %s, %sid %s", column, sequence, prefix, sequence)
+}
+
+// isTokenEnabled 老版本数据中 token_enable 可能为 NULL，按照启用处理，避免整批用户查询失败
+func isTokenEnabled(v sql.NullInt64) bool {
+	return !v.Valid || v.Int64 == 1
+}
+
+// unixToOptionalTime 将数据库中可能为 NULL 的时间戳转换为 time.Time，NULL 对应零值
+func unixToOptionalTime(ts int64) time.Time {
+	if ts <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
+
 func (u *userStore) cleanInValidUser(name, owner string) error {
 	log.Infof("[Store][User] clean user, name=(%s), owner=(%s)", name, owner)
-	str := "delete from user where name = ? and owner = ? and flag = 1"
-	if _, err := u.master.Exec(str, name, owner); err != nil {
+	var err error
+	if u.archiveInvalidUser {
+		err = u.writeTransaction("cleanInValidUser", func(tx *BaseTx) error {
+			if _, err := u.cleanInValidUserTx(tx, name, owner); err != nil {
+				return err
+			}
+			return tx.Commit()
+		})
+	} else {
+		nameColumn, nameKey := u.userNameKey(name)
+		_, err = u.master.Exec(fmt.Sprintf(cleanInValidUserSql, nameColumn), nameKey, owner)
+	}
+	if err != nil {
 		log.Errorf("[Store][User] clean user(%s) err: %s", name, err.Error())
 		return err
 	}
 
 	return nil
 }
+
+// cleanInValidUserTx 在事务中清理同名的已删除用户，开启了 archiveInvalidUser 时先将其归档，返回清理的用户个数
+func (u *userStore) cleanInValidUserTx(tx *BaseTx, name, owner string) (int64, error) {
+	nameColumn, nameKey := u.userNameKey(name)
+	if u.archiveInvalidUser {
+		if _, err := tx.Exec(archiveInValidUserSql+nameColumn+" = ? AND owner = ?", nameKey, owner); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.Exec(fmt.Sprintf(cleanInValidUserSql, nameColumn), nameKey, owner)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// userNameKey 按照名称匹配用户时使用的字段以及取值，nameCaseInsensitive 时匹配小写的 name_lower，
+// 返回的用户名称仍然为写入时的原始名称
+func (u *userStore) userNameKey(name string) (string, string) {
+	if u.nameCaseInsensitive {
+		return "name_lower", strings.ToLower(name)
+	}
+	return "name", name
+}
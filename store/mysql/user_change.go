@@ -0,0 +1,144 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/store"
+)
+
+// GetUserChanges 按照序号顺序查询 sinceSeq 之后的用户变更记录
+// 开启了 userChangeCompact 时，同一个用户在本次读取范围内的多条变更只返回最后一条
+func (u *userStore) GetUserChanges(sinceSeq uint64, limit uint32) (_ []*model.UserChange, err error) {
+	u, span := u.traceOp(context.Background(), "GetUserChanges")
+	defer func() { span.finish(err) }()
+
+	querySql := "SELECT seq, op, user_id, payload, UNIX_TIMESTAMP(ctime) FROM user_change_log " +
+		" WHERE seq > ? ORDER BY seq LIMIT ?"
+	rows, err := u.master.Query(querySql, sinceSeq, limit)
+	if err != nil {
+		log.Error("[Store][User] get user changes", zap.Uint64("since", sinceSeq), zap.Error(err))
+		return nil, store.Error(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	changes := make([]*model.UserChange, 0)
+	for rows.Next() {
+		var (
+			change = &model.UserChange{}
+			op     string
+			ctime  int64
+		)
+		if err := rows.Scan(&change.Seq, &op, &change.UserID, &change.Payload, &ctime); err != nil {
+			return nil, store.Error(err)
+		}
+		change.Op = model.UserChangeOp(op)
+		change.CreateTime = time.Unix(ctime, 0)
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.Error(err)
+	}
+	if u.changeCompact {
+		return store.CompactUserChanges(changes), nil
+	}
+	return changes, nil
+}
+
+// recordUserChanges 开启了 userChangeLog 时，在同一个事务中记录用户变更后的快照
+// 序号通过 user_change_seq 分配，其行锁持有到事务提交，因此序号的顺序与事务提交的顺序一致，
+// 消费方按照序号增量读取时不会遗漏序号较小但是提交较晚的变更
+func (u *userStore) recordUserChanges(tx *BaseTx, op model.UserChangeOp, ids []string) error {
+	if !u.changeLog || len(ids) == 0 {
+		return nil
+	}
+	for start := 0; start < len(ids); start += batchInsertSize {
+		if err := recordUserChangesChunk(tx, op, ids[start:min(start+batchInsertSize, len(ids))]); err != nil {
+			log.Error("[Store][User] record user changes", zap.String("op", string(op)), zap.Error(err))
+			return store.Error(err)
+		}
+	}
+	return nil
+}
+
+func recordUserChangesChunk(tx *BaseTx, op model.UserChangeOp, ids []string) error {
+	snapshots, err := loadUserSnapshots(tx, ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE user_change_seq SET seq = seq + ? WHERE id = 1", len(ids)); err != nil {
+		return err
+	}
+	var last uint64
+	if err := tx.QueryRow("SELECT seq FROM user_change_seq WHERE id = 1").Scan(&last); err != nil {
+		return err
+	}
+
+	args := make([]interface{}, 0, 4*len(ids))
+	for i, id := range ids {
+		user, ok := snapshots[id]
+		if !ok {
+			user = &model.User{ID: id}
+		}
+		payload, err := store.EncodeUserChangePayload(user)
+		if err != nil {
+			return err
+		}
+		args = append(args, last-uint64(len(ids)-i-1), string(op), id, payload)
+	}
+	insertSql := "INSERT INTO user_change_log(`seq`, `op`, `user_id`, `payload`, `ctime`) VALUES " +
+		repeatPlaceholders("(?,?,?,?,sysdate())", len(ids))
+	_, err = tx.Exec(insertSql, args...)
+	return err
+}
+
+// loadUserSnapshots 在事务中读取用户当前的数据，包括已经删除的用户
+func loadUserSnapshots(tx *BaseTx, ids []string) (map[string]*model.User, error) {
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	querySql := "SELECT " + strings.ReplaceAll(userListColumns, "{p}", "") + " FROM user WHERE id IN (" +
+		placeholders(len(ids)) + ")"
+	rows, err := tx.Query(querySql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make(map[string]*model.User, len(ids))
+	for rows.Next() {
+		user, err := fetchRown2User(rows)
+		if err != nil {
+			return nil, err
+		}
+		users[user.ID] = user
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,3616 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	commonlog "github.com/polarismesh/polaris/common/log"
+	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/store"
+)
+
+func newTestUserStore(t *testing.T) (*userStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	baseDB := &BaseDB{DB: db}
+	return &userStore{master: baseDB, slave: baseDB}, mock
+}
+
+func Test_userStore_UpdateLastLogin(t *testing.T) {
+	t.Run("正常场景", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(`UPDATE user SET last_login_time = sysdate\(\), mtime = mtime WHERE id = \? AND flag = 0`).
+			WithArgs("u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, us.UpdateLastLogin("u1"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户ID为空", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		err := us.UpdateLastLogin("")
+		assert.Error(t, err)
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+	})
+
+	t.Run("SQL执行出错", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(`UPDATE user SET last_login_time`).WithArgs("u1").
+			WillReturnError(errors.New("mock error"))
+
+		assert.Error(t, us.UpdateLastLogin("u1"))
+	})
+}
+
+func Test_userStore_ListUsersLastLoginBefore(t *testing.T) {
+	t.Run("按最近登录时间过滤", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +` +
+			`AND \(last_login_time IS NULL OR last_login_time < FROM_UNIXTIME\(\?\)\)`).
+			WithArgs(int64(1700000000)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`AND \(last_login_time IS NULL OR last_login_time < FROM_UNIXTIME\(\?\)\) +ORDER BY mtime`).
+			WithArgs(int64(1700000000), 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+				"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+				AddRow("u1", "u1", "", "polaris", "", "Polaris", "", 1, 1, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0))
+
+		total, users, err := us.GetUsers(map[string]string{
+			LastLoginBeforeAttribute: "1700000000",
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, 1, len(users))
+		assert.True(t, users[0].LastLoginTime.IsZero())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("非法的时间参数", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		_, _, err := us.GetUsers(map[string]string{
+			LastLoginBeforeAttribute: "abc",
+		}, 0, 10)
+		assert.Error(t, err)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_ListUsersInactiveSince(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+	before := func(days int) interface{} {
+		return inactiveSinceArg(time.Now().Add(-time.Duration(days) * 24 * time.Hour).Unix())
+	}
+
+	t.Run("从未登录过的用户默认视为不活跃", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +` +
+			`AND \(last_login_time IS NULL OR last_login_time < FROM_UNIXTIME\(\?\)\)`).
+			WithArgs(before(30)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(`AND \(last_login_time IS NULL OR last_login_time < FROM_UNIXTIME\(\?\)\) +ORDER BY mtime`).
+			WithArgs(before(30), 0, 10).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("u1", "u1", "", "polaris", "", "Polaris", "", 1, 1, 1600000000, 1600000000, 0, "", "",
+					1600000000, 0, 0, 0).
+				AddRow("u2", "u2", "", "polaris", "", "Polaris", "", 1, 1, 1600000000, 1600000000, 0, "", "",
+					0, 0, 0, 0))
+
+		total, users, err := us.GetUsers(map[string]string{InactiveSinceAttribute: "30"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.Equal(t, 2, len(users))
+		assert.True(t, users[1].LastLoginTime.IsZero())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("不返回从未登录过的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND last_login_time < FROM_UNIXTIME\(\?\)`).
+			WithArgs(before(30)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`AND last_login_time < FROM_UNIXTIME\(\?\) +ORDER BY mtime`).
+			WithArgs(before(30), 0, 10).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("u1", "u1", "", "polaris", "", "Polaris", "", 1, 1, 1600000000, 1600000000, 0, "", "",
+					1600000000, 0, 0, 0))
+
+		total, users, err := us.GetUsers(map[string]string{
+			InactiveSinceAttribute:      "30",
+			NeverLoginInactiveAttribute: "false",
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, "u1", users[0].ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("同时指定last_login_before时取更早的时间点", func(t *testing.T) {
+		query, err := store.ParseUserQuery(map[string]string{
+			InactiveSinceAttribute:   "30",
+			LastLoginBeforeAttribute: "1600000000",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1600000000), query.LastLoginBefore.Unix())
+
+		query, err = store.ParseUserQuery(map[string]string{
+			InactiveSinceAttribute:   "30",
+			LastLoginBeforeAttribute: fmt.Sprint(time.Now().Unix()),
+		})
+		assert.NoError(t, err)
+		assert.True(t, inactiveSinceArg(time.Now().Add(-30*24*time.Hour).Unix()).Match(query.LastLoginBefore.Unix()))
+	})
+
+	t.Run("非法的天数", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		for _, days := range []string{"-1", "abc", "1000000"} {
+			_, _, err := us.GetUsers(map[string]string{InactiveSinceAttribute: days}, 0, 10)
+			assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		}
+	})
+}
+
+// inactiveSinceArg 匹配按天数换算出的时间点，允许测试执行期间秒数的跳变
+type inactiveSinceArg int64
+
+func (a inactiveSinceArg) Match(v driver.Value) bool {
+	got, ok := v.(int64)
+	return ok && got >= int64(a)-1 && got <= int64(a)+1
+}
+
+func Test_userStore_ListUsersByDeleteTime(t *testing.T) {
+	t.Run("按删除时间范围过滤", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 1 +`+
+			`AND deleted_at >= FROM_UNIXTIME\(\?\) +AND deleted_at < FROM_UNIXTIME\(\?\)`).
+			WithArgs(int64(1700000000), int64(1700086400)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`WHERE flag = 1 +AND deleted_at >= FROM_UNIXTIME\(\?\) +AND deleted_at < FROM_UNIXTIME\(\?\) +ORDER BY mtime`).
+			WithArgs(int64(1700000000), int64(1700086400), 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+				"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+				AddRow("u1", "u1", "", "polaris", "", "Polaris", "", 1, 1, 1600000000, 1600000000, 1, "", "", 0, 0, 0,
+					1700000100))
+
+		total, users, err := us.GetUsers(map[string]string{
+			"include_deleted": "true",
+			"deleted_after":   "1700000000",
+			"deleted_before":  "1700086400",
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, 1, len(users))
+		assert.False(t, users[0].Valid)
+		assert.Equal(t, int64(1700000100), users[0].DeleteTime.Unix())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("包含已经删除的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE 1 = 1$`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`WHERE 1 = 1 +ORDER BY mtime`).
+			WithArgs(0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{"include_deleted": "true"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("未指定include_deleted时忽略删除时间", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0$`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`WHERE flag = 0 +ORDER BY mtime`).
+			WithArgs(0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{"deleted_after": "1700000000"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("非法的时间范围", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		for _, filters := range []map[string]string{
+			{"include_deleted": "true", "deleted_after": "1700086400", "deleted_before": "1700000000"},
+			{"include_deleted": "true", "deleted_after": "1700000000", "deleted_before": "1700000000"},
+			{"include_deleted": "true", "deleted_after": "-1"},
+			{"include_deleted": "true", "deleted_before": "abc"},
+		} {
+			_, _, err := us.GetUsers(filters, 0, 10)
+			assert.Equal(t, store.OutOfRangeErr, store.Code(err), filters)
+		}
+	})
+}
+
+func Test_userStore_ListUsersByHasGroup(t *testing.T) {
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+			"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+			"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+			AddRow("u1", "u1", "", "polaris", "", "Polaris", "", 1, 1, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0)
+	}
+
+	t.Run("加入了用户组的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND EXISTS \(SELECT 1 FROM user_group_relation ugr ` +
+			`+INNER JOIN user_group ug ON ug.id = ugr.group_id +WHERE ugr.user_id = user.id AND ugr.flag = 0 AND ug.flag = 0\)`).
+			WithArgs().
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`AND EXISTS \(SELECT 1 FROM user_group_relation ugr`).
+			WithArgs(0, 10).
+			WillReturnRows(userRows())
+
+		total, users, err := us.GetUsers(map[string]string{HasGroupAttribute: "true"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, 1, len(users))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("没有加入任何用户组的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND NOT EXISTS \(SELECT 1 FROM user_group_relation`).
+			WithArgs().
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`AND NOT EXISTS \(SELECT 1 FROM user_group_relation ugr`).
+			WithArgs(0, 10).
+			WillReturnRows(userRows())
+
+		total, users, err := us.GetUsers(map[string]string{HasGroupAttribute: "false"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, 1, len(users))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户组下查询没有加入用户组的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		total, users, err := us.GetUsers(map[string]string{HasGroupAttribute: "false", "group_id": "g1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+		assert.Empty(t, users)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("非法的参数", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		_, _, err := us.GetUsers(map[string]string{HasGroupAttribute: "yes"}, 0, 10)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+	})
+}
+
+func Test_userStore_ListUsersByNames(t *testing.T) {
+	t.Run("多个名称使用IN查询", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND +name IN \(\?,\?,\?\)`).
+			WithArgs("u1", "u2", "u3").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`AND +name IN \(\?,\?,\?\) +ORDER BY mtime`).
+			WithArgs("u1", "u2", "u3", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{NameAttribute: "u1,u2,u3"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("单个名称带通配符使用LIKE查询", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND +name like \?`).
+			WithArgs("%u1,u2%").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`AND +name like \? +ORDER BY mtime`).
+			WithArgs("%u1,u2%", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{NameAttribute: "u1,u2*"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("单个名称精确查询", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND +name = \?`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`AND +name = \? +ORDER BY mtime`).
+			WithArgs("u1", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{NameAttribute: "u1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_QueryUsers(t *testing.T) {
+	enable, hasGroup := false, true
+	cases := []struct {
+		name    string
+		filters map[string]string
+		query   *store.UserQuery
+		sql     string
+		args    []driver.Value
+	}{
+		{
+			name: "用户列表",
+			filters: map[string]string{"hide_admin": "true", "name": "u*", "owner": "o1", "source": "Polaris",
+				"token_enable": "false", "created_after": "1600000000", "has_group": "true"},
+			query: &store.UserQuery{HideAdmin: true, Name: "u*", Owner: "o1", Source: "Polaris",
+				TokenEnable: &enable, CreatedAfter: time.Unix(1600000000, 0), HasGroup: &hasGroup},
+			sql: `SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND user_type != 0 +AND name like \? +` +
+				`AND \(id = \? OR owner = \?\) +AND source = \? +AND token_enable = \? +` +
+				`AND ctime >= FROM_UNIXTIME\(\?\) +AND EXISTS`,
+			args: []driver.Value{"%u%", "o1", "o1", "Polaris", 0, int64(1600000000)},
+		},
+		{
+			name:    "用户组下的用户",
+			filters: map[string]string{"group_id": "g1", "user_id": "u1", "last_login_before": "1600000000"},
+			query:   &store.UserQuery{GroupID: "g1", ID: "u1", LastLoginBefore: time.Unix(1600000000, 0)},
+			sql: `SELECT COUNT\(\*\) FROM user_group_relation ug .* WHERE ug.flag = 0 +AND ug.group_id = \? +` +
+				`AND u.id = \? +AND \(u.last_login_time IS NULL OR u.last_login_time < FROM_UNIXTIME\(\?\)\)`,
+			args: []driver.Value{"g1", "u1", int64(1600000000)},
+		},
+		{
+			name:    "按照名称或者备注搜索",
+			filters: map[string]string{"q": `50%_off\`},
+			query:   &store.UserQuery{Keyword: `50%_off\`},
+			sql:     `SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND \(name LIKE \? OR comment LIKE \?\)`,
+			args:    []driver.Value{`%50\%\_off\\%`, `%50\%\_off\\%`},
+		},
+		{
+			name:    "在用户组下按照名称或者备注搜索",
+			filters: map[string]string{"group_id": "g1", "q": "ops"},
+			query:   &store.UserQuery{GroupID: "g1", Keyword: "ops"},
+			sql: `SELECT COUNT\(\*\) FROM user_group_relation ug .* WHERE ug.flag = 0 +AND ug.group_id = \? +` +
+				`AND \(u.name LIKE \? OR u.comment LIKE \?\)`,
+			args: []driver.Value{"g1", "%ops%", "%ops%"},
+		},
+		{
+			name:    "排除指定的用户",
+			filters: map[string]string{"exclude_ids": "u1, u2,,u1", "owner": "o1"},
+			query:   &store.UserQuery{ExcludeIDs: []string{"u1", "u2"}, Owner: "o1"},
+			sql: `SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND id NOT IN \(\?,\?\) +` +
+				`AND \(id = \? OR owner = \?\)`,
+			args: []driver.Value{"u1", "u2", "o1", "o1"},
+		},
+		{
+			name:    "排除的用户为空",
+			filters: map[string]string{"exclude_ids": ""},
+			query:   &store.UserQuery{},
+			sql:     `SELECT COUNT\(\*\) FROM user WHERE flag = 0$`,
+			args:    []driver.Value{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			us, mock := newTestUserStore(t)
+			// map 与类型化的查询条件生成相同的 SQL 以及参数
+			for i := 0; i < 2; i++ {
+				mock.ExpectQuery(c.sql).WithArgs(c.args...).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`LIMIT \? , \?`).WithArgs(append(c.args, 0, 10)...).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			}
+
+			_, _, err := us.GetUsers(c.filters, 0, 10)
+			assert.NoError(t, err)
+			_, _, err = us.QueryUsers(c.query, 0, 10)
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("排除的用户较多时拆分为多个NOT IN", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		ids := newTestUserIds(batchQuerySize + 1)
+		args := make([]driver.Value, 0, len(ids))
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND id NOT IN \((\?,){999}\?\) +` +
+			`AND id NOT IN \(\?\)$`).WithArgs(args...).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`LIMIT \? , \?`).WithArgs(append(args, 0, 10)...).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.QueryUsers(&store.UserQuery{ExcludeIDs: ids}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("不支持的查询参数", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, _, err := us.GetUsers(map[string]string{"password": "x"}, 0, 10)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		_, _, err = us.GetUsers(map[string]string{"created_after": "yesterday"}, 0, 10)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_QueryUsersPageBounds(t *testing.T) {
+	userColumns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+
+	t.Run("offset等于上限", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`LIMIT \? , \?`).
+			WithArgs(store.DefaultUserQueryMaxOffset, 10).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+
+		_, _, err := us.GetUsers(map[string]string{}, store.DefaultUserQueryMaxOffset, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("limit超过上限时截断", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`LIMIT \? , \?`).
+			WithArgs(0, store.UserQueryMaxLimit).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+
+		_, _, err := us.GetUsers(map[string]string{}, 0, math.MaxUint32)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("offset超过默认上限", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, _, err := us.GetUsers(map[string]string{}, store.DefaultUserQueryMaxOffset+1, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		_, _, err = us.QueryUsers(&store.UserQuery{}, math.MaxUint32, math.MaxUint32)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("offset超过配置的上限", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.queryMaxOffset = 50
+		_, _, err := us.QueryUsers(&store.UserQuery{}, 51, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		_, _, err = us.QueryUsersWithOwner(&store.UserQuery{}, 51, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		_, _, err = us.GetUsersByStrategyID("s1", 51, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("offset与limit之和溢出", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.queryMaxOffset = math.MaxUint32
+		_, _, err := us.QueryUsers(&store.UserQuery{}, math.MaxUint32-10, 100)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_QueryUsersGuard(t *testing.T) {
+	userColumns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+	expectList := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`LIMIT \? , \?`).WillReturnRows(sqlmock.NewRows(userColumns))
+	}
+
+	t.Run("默认不拦截", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expectList(mock)
+
+		_, _, err := us.GetUsers(map[string]string{"hide_admin": "true"}, 0, store.UserQueryMaxLimit)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("要求至少一个过滤条件", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.queryGuard = store.UserQueryGuard{RequireFilter: true}
+
+		_, _, err := us.GetUsers(map[string]string{"hide_admin": "true", store.OrderFieldAttribute: "name"}, 0, 10)
+		assert.Equal(t, store.UnfilteredQueryErr, store.Code(err))
+		_, _, err = us.QueryUsersWithOwner(&store.UserQuery{IncludeDeleted: true}, 0, 10)
+		assert.Equal(t, store.UnfilteredQueryErr, store.Code(err))
+
+		expectList(mock)
+		_, _, err = us.GetUsers(map[string]string{"source": "LDAP"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("限制未过滤查询的分页大小", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.queryGuard = store.UserQueryGuard{MaxUnfilteredLimit: 100}
+
+		_, _, err := us.GetUsers(map[string]string{}, 0, 101)
+		assert.Equal(t, store.UnfilteredQueryErr, store.Code(err))
+
+		expectList(mock)
+		_, _, err = us.GetUsers(map[string]string{}, 0, 100)
+		assert.NoError(t, err)
+		expectList(mock)
+		_, _, err = us.GetUsers(map[string]string{"name": "user*"}, 0, 500)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_QueryUsersControlCharacters(t *testing.T) {
+	us, mock := newTestUserStore(t)
+
+	// 包含控制字符的过滤条件在查询前被拒绝，不会访问数据库
+	_, _, err := us.GetUsers(map[string]string{"name": "alice\x00"}, 0, 10)
+	assert.Equal(t, store.InvalidParameter, store.Code(err))
+	_, _, err = us.GetUsers(map[string]string{"q": "key\nword"}, 0, 10)
+	assert.Equal(t, store.InvalidParameter, store.Code(err))
+	_, _, err = us.QueryUsers(&store.UserQuery{ExcludeIDs: []string{"u1", "u2\x00"}}, 0, 10)
+	assert.Equal(t, store.InvalidParameter, store.Code(err))
+	_, _, err = us.QueryUsersWithOwner(&store.UserQuery{GroupName: "\x7fgroup"}, 0, 10)
+	assert.Equal(t, store.InvalidParameter, store.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_userStore_QueryUsersOwnerRecursive(t *testing.T) {
+	userColumns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+	row := func(rows *sqlmock.Rows, id, owner string) *sqlmock.Rows {
+		return rows.AddRow(id, id, "", owner, "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0)
+	}
+
+	// m1 -> s1 -> ss1 两层的子账户树
+	t.Run("只查询直接子账户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.recursiveCTE = true
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND \(id = \? OR owner = \?\)`).
+			WithArgs("m1", "m1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(`AND \(id = \? OR owner = \?\) +ORDER BY mtime LIMIT \? , \?`).
+			WithArgs("m1", "m1", 0, 10).
+			WillReturnRows(row(row(sqlmock.NewRows(userColumns), "m1", ""), "s1", "m1"))
+
+		total, users, err := us.QueryUsers(&store.UserQuery{Owner: "m1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.Equal(t, []string{"m1", "s1"}, []string{users[0].ID, users[1].ID})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("递归查询整棵子账户树", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.recursiveCTE = true
+		subtreeSql := `AND \(id = \? OR id IN \(WITH RECURSIVE sub_user \(id\) AS \( ` +
+			`SELECT id FROM user WHERE owner = \? AND id != owner AND flag = 0 ` +
+			`UNION SELECT c.id FROM user c INNER JOIN sub_user s ON c.owner = s.id ` +
+			`WHERE c.id != c.owner AND c.flag = 0\) SELECT id FROM sub_user\)\)`
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +`+subtreeSql).
+			WithArgs("m1", "m1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(subtreeSql+` +ORDER BY mtime LIMIT \? , \?`).
+			WithArgs("m1", "m1", 0, 10).
+			WillReturnRows(row(row(row(sqlmock.NewRows(userColumns), "m1", ""), "s1", "m1"), "ss1", "s1"))
+
+		total, users, err := us.QueryUsers(&store.UserQuery{Owner: "m1", OwnerRecursive: true}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), total)
+		assert.Equal(t, []string{"m1", "s1", "ss1"}, []string{users[0].ID, users[1].ID, users[2].ID})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("数据库不支持递归查询", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, _, err := us.QueryUsers(&store.UserQuery{Owner: "m1", OwnerRecursive: true}, 0, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		_, _, err = us.QueryUsersWithOwner(&store.UserQuery{Owner: "m1", OwnerRecursive: true}, 0, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_QueryUsersWithOwner(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source", "token", "token_enable",
+		"user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time", "password_set_time",
+		"must_change_password", "deleted_at", "owner_name"}
+
+	t.Run("解析子账户的主账户名称", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE user.flag = 0 +AND user.user_type != 0 +`+
+			`AND \(user.id = \? OR user.owner = \?\)`).
+			WithArgs("o1", "o1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+		mock.ExpectQuery(`SELECT user.id, user.name, .*, IFNULL\(o.name, ''\) FROM user `+
+			`LEFT JOIN user o ON o.id = user.owner AND o.flag = 0 WHERE user.flag = 0 +AND user.user_type != 0 +`+
+			`AND \(user.id = \? OR user.owner = \?\) +ORDER BY user.mtime LIMIT \? , \?`).
+			WithArgs("o1", "o1", 0, 10).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("o1", "main", "", "o1", "", "Polaris", "", 1, 20, 0, 0, 0, "", "", 0, 0, 0, 0, "main").
+				AddRow("u1", "sub-1", "", "o1", "", "Polaris", "", 1, 50, 0, 0, 0, "", "", 0, 0, 0, 0, "main").
+				AddRow("u2", "sub-2", "", "missing", "", "Polaris", "", 1, 50, 0, 0, 0, "", "", 0, 0, 0, 0, "").
+				AddRow("o2", "main-2", "", "", "", "Polaris", "", 1, 20, 0, 0, 0, "", "", 0, 0, 0, 0, ""))
+
+		total, users, err := us.QueryUsersWithOwner(&store.UserQuery{HideAdmin: true, Owner: "o1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(4), total)
+		ownerNames := map[string]string{}
+		for _, user := range users {
+			ownerNames[user.ID] = user.OwnerName
+		}
+		// 主账户的 owner 为空时使用自身的名称，主账户不存在时为空
+		assert.Equal(t, map[string]string{"o1": "main", "u1": "main", "u2": "", "o2": "main-2"}, ownerNames)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户组下的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_group_relation ug LEFT JOIN user u ON ug.user_id = u.id `+
+			`AND u.flag = 0 WHERE ug.flag = 0 +AND ug.group_id = \? +AND u.owner = \?`).
+			WithArgs("g1", "o1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`SELECT u.id, u.name, .* LEFT JOIN user o ON o.id = u.owner AND o.flag = 0 `+
+			`WHERE ug.flag = 0 +AND ug.group_id = \? +AND u.owner = \? +ORDER BY u.mtime LIMIT \? , \?`).
+			WithArgs("g1", "o1", 0, 10).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("u1", "sub-1", "", "o1", "", "Polaris", "", 1, 50, 0, 0, 0, "", "", 0, 0, 0, 0, "main"))
+
+		_, users, err := us.QueryUsersWithOwner(&store.UserQuery{GroupID: "g1", Owner: "o1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, users, 1)
+		assert.Equal(t, "main", users[0].OwnerName)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// oneOfArg 匹配候选值中的任意一个，用于 filters 遍历顺序不固定时的参数校验
+type oneOfArg []driver.Value
+
+func (a oneOfArg) Match(v driver.Value) bool {
+	for i := range a {
+		if a[i] == v {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_userStore_ListGroupUsersByName(t *testing.T) {
+	for _, key := range []string{NameAttribute, "user_name"} {
+		t.Run("用户组下按"+key+"前缀查询", func(t *testing.T) {
+			us, mock := newTestUserStore(t)
+			args := oneOfArg{"g1", "foo%"}
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_group_relation ug +LEFT JOIN user u ON ug.user_id = u.id `+
+				`AND u.flag = 0 +WHERE ug.flag = 0 +AND (u.name like \? AND ug.group_id = \?|ug.group_id = \? AND u.name like \?)$`).
+				WithArgs(args, args).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectQuery(`FROM user_group_relation ug .* AND u.name like \?`).
+				WithArgs(args, args, 0, 10).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			_, _, err := us.GetUsers(map[string]string{GroupIDAttribute: "g1", key: "foo*"}, 0, 10)
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("用户组下按名称精确查询", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		args := oneOfArg{"g1", "foo"}
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_group_relation ug .* AND u.name = \?`).
+			WithArgs(args, args).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM user_group_relation ug .* AND u.name = \?`).
+			WithArgs(args, args, 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{GroupIDAttribute: "g1", NameAttribute: "foo"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_ListUsersByGroupName(t *testing.T) {
+	t.Run("按照用户组名称查询所有同名用户组的成员", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND id IN \(SELECT gnr.user_id FROM ` +
+			`user_group_relation gnr +INNER JOIN user_group gn ON gn.id = gnr.group_id +WHERE gnr.flag = 0 ` +
+			`AND gn.flag = 0 AND gn.name = \?\)`).
+			WithArgs("dev").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM user +WHERE flag = 0 +AND id IN \(SELECT gnr.user_id .* gn.name = \?\)`).
+			WithArgs("dev", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		total, users, err := us.GetUsers(map[string]string{"group_name": "dev"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+		assert.Empty(t, users)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("同名的用户组通过group_owner限定主账户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND id IN \(SELECT gnr.user_id .* `+
+			`gn.name = \? AND gn.owner = \?\)`).
+			WithArgs("dev", "owner-1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`AND id IN \(SELECT gnr.user_id .* gn.name = \? AND gn.owner = \?\)`).
+			WithArgs("dev", "owner-1", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{"group_name": "dev", "group_owner": "owner-1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("同时指定group_id时在用户组下按照名称过滤", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_group_relation ug .* AND ug.group_id = \? +`+
+			`AND u.id IN \(SELECT gnr.user_id .* gn.name = \?\)`).
+			WithArgs("g1", "dev").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM user_group_relation ug .* AND u.id IN \(SELECT gnr.user_id`).
+			WithArgs("g1", "dev", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{GroupIDAttribute: "g1", "group_name": "dev"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_ListGroupUsersByGroupOwner(t *testing.T) {
+	t.Run("只查询属于指定主账户的用户组", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_group_relation ug +INNER JOIN user_group g ON g.id = ug.group_id `+
+			`AND g.flag = 0 AND g.owner = \? +LEFT JOIN user u ON ug.user_id = u.id AND u.flag = 0 +WHERE ug.flag = 0 +`+
+			`AND ug.group_id = \?`).
+			WithArgs("owner-1", "g1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM user_group_relation ug +INNER JOIN user_group g .* AND ug.group_id = \?`).
+			WithArgs("owner-1", "g1", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		total, users, err := us.GetUsers(map[string]string{GroupIDAttribute: "g1", "group_owner": "owner-1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+		assert.Empty(t, users)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询用户所属主账户时同样限制用户组的主账户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_group_relation ug +INNER JOIN user_group g .* AND ug.group_id = \?`).
+			WithArgs("owner-1", "g1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INNER JOIN user_group g .* LEFT JOIN user o ON o.id = u.owner`).
+			WithArgs("owner-1", "g1", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.QueryUsersWithOwner(&store.UserQuery{GroupID: "g1", GroupOwner: "owner-1"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_stableStore_WithTx(t *testing.T) {
+	newStore := func(t *testing.T) (*stableStore, sqlmock.Sqlmock) {
+		us, mock := newTestUserStore(t)
+		return &stableStore{master: us.master, slave: us.slave, userStore: us}, mock
+	}
+
+	t.Run("fn执行成功提交事务", func(t *testing.T) {
+		s, mock := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := s.WithTx(context.Background(), func(ts store.TxStore) error {
+			return ts.UpdateUser(&model.User{ID: "u1", Name: "u1", Token: "t", Password: "p"})
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("fn执行失败回滚事务", func(t *testing.T) {
+		s, mock := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO user`).WillReturnError(errors.New("mock error"))
+		mock.ExpectRollback()
+
+		err := s.WithTx(context.Background(), func(ts store.TxStore) error {
+			return ts.AddUser(&model.User{ID: "u1", Name: "u1", Token: "t", Password: "p"})
+		})
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("关联记录失败回滚事务", func(t *testing.T) {
+		s, mock := newStore(t)
+		mockErr := errors.New("grant fail")
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		err := s.WithTx(context.Background(), func(ts store.TxStore) error {
+			if err := ts.UpdateUser(&model.User{ID: "u1", Name: "u1", Token: "t", Password: "p"}); err != nil {
+				return err
+			}
+			return mockErr
+		})
+		assert.ErrorIs(t, err, mockErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_ListUsersOrderByName(t *testing.T) {
+	t.Run("按名称忽略大小写排序", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`ORDER BY name COLLATE utf8mb4_general_ci ASC, id ASC LIMIT \? , \?`).
+			WithArgs(0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{
+			store.OrderFieldAttribute:     "name",
+			store.OrderCollationAttribute: "utf8mb4_general_ci",
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户组下的用户按名称倒序", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\)`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`ORDER BY u.name COLLATE utf8mb4_unicode_ci DESC, u.id DESC LIMIT \? , \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{
+			GroupIDAttribute:              "g1",
+			store.OrderFieldAttribute:     "name",
+			store.OrderTypeAttribute:      "desc",
+			store.OrderCollationAttribute: "utf8mb4_unicode_ci",
+		}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("排序规则不在白名单中", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, _, err := us.GetUsers(map[string]string{
+			store.OrderFieldAttribute:     "name",
+			store.OrderCollationAttribute: "utf8mb4_general_ci, (SELECT 1)",
+		}, 0, 10)
+		assert.Error(t, err)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("排序字段不支持", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		_, _, err := us.GetUsers(map[string]string{
+			store.OrderFieldAttribute: "password",
+		}, 0, 10)
+		assert.Error(t, err)
+	})
+}
+
+func Test_userStore_UpdateUserPassword(t *testing.T) {
+	t.Run("修改密码时重置密码修改时间", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		user := &model.User{ID: "u1", Name: "u1", Token: "t", Password: "new-pwd", TokenEnable: true}
+
+		mock.ExpectBegin()
+		// password_set_time、must_change_password 必须在 password 之前赋值，才能和旧密码进行比较
+		mock.ExpectExec(`UPDATE user SET +password_set_time = IF\(password = \?, password_set_time, sysdate\(\)\), +`+
+			`must_change_password = IF\(password = \?, must_change_password, 0\), +`+
+			`token_rotated_time = IF\(token = \? OR token = \?, token_rotated_time, sysdate\(\)\), +password = \?`).
+			WithArgs("new-pwd", "new-pwd", "t", "t", "new-pwd", "t", "", 1, "", "", "", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(user))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询用户时返回密码过期相关字段", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`IFNULL\(UNIX_TIMESTAMP\(u.password_set_time\), 0\), u.must_change_password`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time",
+				"password_set_time", "must_change_password", "deleted_at", "created_by", "token_rotated_time"}).
+				AddRow("u1", "u1", "", "", "", "Polaris", "", 1, 20, 1600000000, 1600000000, 0,
+					"", "", 0, 1600000000, 1, 0, "", 0))
+
+		user, err := us.GetUser("u1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1600000000), user.PasswordSetTime.Unix())
+		assert.True(t, user.MustChangePassword)
+		assert.True(t, user.LastLoginTime.IsZero())
+	})
+}
+
+func Test_userStore_TokenRotatedTime(t *testing.T) {
+	t.Run("token发生变化时记录更换时间", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.tokenCipher = newTestTokenCipher(t, "k1", "k1")
+		encrypted, err := us.tokenCipher.Encrypt("t")
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		// token_rotated_time 必须在 token 之前赋值，同时与加密后以及明文的 token 比较，存量明文 token 没有变化时不更新
+		mock.ExpectExec(`token_rotated_time = IF\(token = \? OR token = \?, token_rotated_time, sysdate\(\)\), +`+
+			`password = \?, token = \?`).
+			WithArgs("p", "p", encrypted, "t", "p", encrypted, "", 1, "", "", "", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(&model.User{ID: "u1", Name: "u1", Token: "t", Password: "p", TokenEnable: true}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询用户时返回token更换时间", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`IFNULL\(UNIX_TIMESTAMP\(u.token_rotated_time\), 0\) +FROM user u`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time",
+				"password_set_time", "must_change_password", "deleted_at", "created_by", "token_rotated_time"}).
+				AddRow("u1", "u1", "", "", "", "Polaris", "", 1, 20, 1600000000, 1600000000, 0,
+					"", "", 0, 0, 0, 0, "", 1600000000))
+
+		user, err := us.GetUser("u1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1600000000), user.TokenRotatedTime.Unix())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询长期没有更换token的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND \(token_rotated_time IS NULL OR ` +
+			`token_rotated_time < FROM_UNIXTIME\(\?\)\)`).
+			WithArgs(int64(1600000000)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`AND \(token_rotated_time IS NULL OR token_rotated_time < FROM_UNIXTIME\(\?\)\)`).
+			WithArgs(int64(1600000000), 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, _, err := us.GetUsers(map[string]string{"token_rotated_before": "1600000000"}, 0, 10)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_PasswordAge(t *testing.T) {
+	lockSql := `SELECT password, IFNULL\(UNIX_TIMESTAMP\(password_set_time\), 0\), must_change_password ` +
+		`FROM user WHERE id = \? AND flag = 0 FOR UPDATE`
+	newUser := func() *model.User {
+		return &model.User{ID: "u1", Name: "u1", Token: "t", Password: "new-pwd", TokenEnable: true}
+	}
+
+	t.Run("未达到最短使用时间时拒绝修改密码", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.passwordAge = store.PasswordAgePolicy{MinAge: time.Hour}
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockSql).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"password", "password_set_time", "must_change_password"}).
+				AddRow("old-pwd", time.Now().Add(-10*time.Minute).Unix(), 0))
+		mock.ExpectRollback()
+
+		err := us.UpdateUser(newUser())
+		assert.Equal(t, store.PasswordTooRecent, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("超过最短使用时间后允许修改密码", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.passwordAge = store.PasswordAgePolicy{MinAge: time.Hour}
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockSql).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"password", "password_set_time", "must_change_password"}).
+				AddRow("old-pwd", time.Now().Add(-2*time.Hour).Unix(), 0))
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(newUser()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("超级管理员不受限制", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.passwordAge = store.PasswordAgePolicy{MinAge: time.Hour, AdminBypass: true}
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockSql).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"password", "password_set_time", "must_change_password"}).
+				AddRow("old-pwd", time.Now().Add(-10*time.Minute).Unix(), 0))
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		user := newUser()
+		user.AdminOperated = true
+		assert.NoError(t, us.UpdateUser(user))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("密码未变化时不做限制", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.passwordAge = store.PasswordAgePolicy{MinAge: time.Hour}
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockSql).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"password", "password_set_time", "must_change_password"}).
+				AddRow("new-pwd", time.Now().Unix(), 0))
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(newUser()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_UpdateUserNotFound(t *testing.T) {
+	user := &model.User{ID: "u1", Name: "u1", Token: "t", Password: "pwd", TokenEnable: true}
+
+	t.Run("更新有效的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(user))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("数据没有变化", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE id = \? AND flag = 0`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(user))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// 已经删除以及不存在的用户都无法命中 flag = 0 的条件
+	for _, name := range []string{"更新已经删除的用户", "更新不存在的用户"} {
+		t.Run(name, func(t *testing.T) {
+			us, mock := newTestUserStore(t)
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE user SET +password_set_time`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE id = \? AND flag = 0`).
+				WithArgs("u1").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectRollback()
+
+			err := us.UpdateUser(user)
+			assert.Equal(t, store.NotFoundUser, store.Code(err))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_userStore_GetUsersByStrategyID(t *testing.T) {
+	userColumns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+
+	t.Run("查询关联到策略的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) +FROM auth_principal ap +INNER JOIN user u ON ap.principal_id = u.id `+
+			`AND u.flag = 0 +WHERE ap.strategy_id = \? AND ap.principal_role = \? AND u.user_type != 0`).
+			WithArgs("s1", model.PrincipalUser).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(`FROM auth_principal ap .* ORDER BY u.mtime LIMIT \? , \?`).
+			WithArgs("s1", model.PrincipalUser, 0, 10).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow("u1", "u1", "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0).
+				AddRow("u2", "u2", "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0))
+
+		total, users, err := us.GetUsersByStrategyID("s1", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), total)
+		assert.Equal(t, 2, len(users))
+		assert.Equal(t, "u1", users[0].ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("策略没有关联任何用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\)`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM auth_principal ap`).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+
+		total, users, err := us.GetUsersByStrategyID("s2", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), total)
+		assert.Empty(t, users)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("策略ID为空", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		_, _, err := us.GetUsersByStrategyID("", 0, 10)
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+	})
+}
+
+func Test_userStore_RenameUser(t *testing.T) {
+	oldStrategyName := model.BuildDefaultStrategyName(model.PrincipalUser, "old-name")
+	newStrategyName := model.BuildDefaultStrategyName(model.PrincipalUser, "new-name")
+	expectRename := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user WHERE id = \? AND flag = 0 FOR UPDATE`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}).AddRow("old-name", "owner"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE name = \? AND owner = \? AND id != \? AND flag = 0`).
+			WithArgs("new-name", "owner", "u1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WithArgs("new-name", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE user SET name = \?, name_lower = \?, mtime = sysdate\(\), modified_by = \? WHERE id = \? AND flag = 0`).
+			WithArgs("new-name", "new-name", "admin", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT ag.id, ag.owner FROM auth_strategy ag INNER JOIN auth_principal ap`).
+			WithArgs("u1", model.PrincipalUser, oldStrategyName).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner"}).AddRow("s1", "owner"))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WithArgs(newStrategyName, "owner").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_strategy WHERE name = \? AND owner = \? AND id != \?`).
+			WithArgs(newStrategyName, "owner", "s1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`UPDATE auth_strategy SET name = \?, revision = \?, mtime = sysdate\(\) WHERE id = \?`).
+			WithArgs(newStrategyName, sqlmock.AnyArg(), "s1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	t.Run("修改用户名称同时修改默认策略名称", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expectRename(mock)
+
+		assert.NoError(t, us.RenameUser("u1", "new-name", "admin"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("改名后重新创建原名称的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expectRename(mock)
+		// 原名称的默认策略已经随着改名释放，新用户创建自己的默认策略，不会与改名的用户冲突
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WithArgs(oldStrategyName, "owner", true).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WithArgs(oldStrategyName, "owner").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).
+			WithArgs(sqlmock.AnyArg(), "u2", model.PrincipalUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, us.RenameUser("u1", "new-name", "admin"))
+		tx, err := us.master.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, createDefaultStrategy(tx, model.PrincipalUser, "u2", "old-name", "owner", false))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("新名称的默认策略属于其他用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user`).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}).AddRow("old-name", "owner"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`delete from user`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE user SET name = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT ag.id, ag.owner FROM auth_strategy ag`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner"}).AddRow("s1", "owner"))
+		mock.ExpectExec(`DELETE FROM auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_strategy`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		err := us.RenameUser("u1", "new-name", "admin")
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("同一个owner下名称冲突", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user`).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}).AddRow("old-name", "owner"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		err := us.RenameUser("u1", "new-name", "admin")
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user`).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}))
+		mock.ExpectRollback()
+
+		err := us.RenameUser("u1", "new-name", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_SwapUserNames(t *testing.T) {
+	lockSql := `SELECT id, name, owner FROM user WHERE id IN \(\?, \?\) AND flag = 0 ORDER BY id FOR UPDATE`
+	renameSql := `UPDATE user SET name = \?, name_lower = \?, mtime = sysdate\(\), modified_by = \? WHERE id = \? AND flag = 0`
+
+	// expectRenameStrategy 用户改名后将其默认策略 strategyId 从 oldName 生成的名称修改为 newStrategyName
+	expectRenameStrategy := func(mock sqlmock.Sqlmock, userId, strategyId string, oldName interface{},
+		newStrategyName driver.Value) {
+		if name, ok := oldName.(string); ok {
+			oldName = model.BuildDefaultStrategyName(model.PrincipalUser, name)
+		}
+		mock.ExpectQuery(`SELECT ag.id, ag.owner FROM auth_strategy ag INNER JOIN auth_principal ap`).
+			WithArgs(userId, model.PrincipalUser, oldName).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner"}).AddRow(strategyId, "owner"))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_strategy`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`UPDATE auth_strategy SET name = \?, revision = \?, mtime = sysdate\(\) WHERE id = \?`).
+			WithArgs(newStrategyName, sqlmock.AnyArg(), strategyId).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("通过临时名称交换两个用户以及默认策略的名称", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		var placeholder, strategyNames []string
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockSql).WithArgs("u1", "u2").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner"}).
+				AddRow("u1", "Alice", "owner").AddRow("u2", "bob", "owner"))
+		mock.ExpectExec(renameSql).WithArgs(recordArg{&placeholder}, sqlmock.AnyArg(), "admin", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRenameStrategy(mock, "u1", "s1", "Alice", recordArg{&strategyNames})
+		mock.ExpectExec(renameSql).WithArgs("Alice", "alice", "admin", "u2").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRenameStrategy(mock, "u2", "s2", "bob", model.BuildDefaultStrategyName(model.PrincipalUser, "Alice"))
+		mock.ExpectExec(renameSql).WithArgs("bob", "bob", "admin", "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRenameStrategy(mock, "u1", "s1", recordArg{&placeholder},
+			model.BuildDefaultStrategyName(model.PrincipalUser, "bob"))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.SwapUserNames("u1", "u2", "admin"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Len(t, placeholder, 2)
+		assert.NotEqual(t, "Alice", placeholder[0])
+		assert.NotEqual(t, "bob", placeholder[0])
+		// 默认策略经过由临时名称生成的策略名称完成交换
+		assert.Equal(t, model.BuildDefaultStrategyName(model.PrincipalUser, placeholder[0]), placeholder[1])
+		assert.Equal(t, []string{placeholder[1]}, strategyNames)
+	})
+
+	t.Run("两个用户属于不同的owner", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockSql).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner"}).
+				AddRow("u1", "alice", "owner").AddRow("u2", "bob", "other"))
+		mock.ExpectRollback()
+
+		err := us.SwapUserNames("u1", "u2", "admin")
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockSql).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner"}).AddRow("u1", "alice", "owner"))
+		mock.ExpectRollback()
+
+		err := us.SwapUserNames("u1", "u2", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("参数不合法", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		assert.Equal(t, store.EmptyParamsErr, store.Code(us.SwapUserNames("u1", "", "admin")))
+		assert.Equal(t, store.InvalidParameter, store.Code(us.SwapUserNames("u1", "u1", "admin")))
+	})
+}
+
+func Test_userStore_ExportUsersSnapshot(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at",
+		"created_by", "token_rotated_time", "modified_by"}
+	exportSql := `SELECT u.id, u.name, u.password, .* FROM user u WHERE u.id > \? ORDER BY u.id LIMIT \?`
+
+	// sqlmock 只是按顺序返回预设的结果，这里只能校验全部分批使用同一个事务，
+	// 导出期间的写入不可见只由 boltdb 的 Test_userStore_ExportUsersSnapshot 校验
+	t.Run("全部分批在同一个事务中读取", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		first := sqlmock.NewRows(columns)
+		for i := 0; i < batchQuerySize; i++ {
+			id := fmt.Sprintf("u%04d", i)
+			first.AddRow(id, id, "", "polaris", "", "Polaris", "t"+id, 1, 1, 1600000000, 1600000000, 0, "", "",
+				0, 0, 0, 0, "", 0, "")
+		}
+		mock.ExpectBegin()
+		mock.ExpectQuery(exportSql).WithArgs("", batchQuerySize).WillReturnRows(first)
+		mock.ExpectQuery(exportSql).WithArgs(fmt.Sprintf("u%04d", batchQuerySize-1), batchQuerySize).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("u9999", "u9999", "", "polaris", "", "Polaris", "", 1, 1, 1600000000, 1600000000, 1, "", "",
+					0, 0, 0, 1600000000, "admin", 0, "admin"))
+		// 导出的过程中不会在事务外执行任何语句
+		mock.ExpectRollback()
+
+		buf := &strings.Builder{}
+		assert.NoError(t, us.ExportUsersSnapshot(context.Background(), buf))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.Len(t, lines, batchQuerySize+1)
+		last := &store.UserSnapshot{}
+		assert.NoError(t, json.Unmarshal([]byte(lines[batchQuerySize]), last))
+		assert.Equal(t, "u9999", last.ID)
+		assert.False(t, last.Valid)
+		assert.Equal(t, int64(1600000000), last.DeleteTime)
+		assert.Equal(t, "admin", last.CreatedBy)
+		assert.Equal(t, "admin", last.ModifiedBy)
+	})
+
+	t.Run("导出存储中加密后的token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.tokenCipher = newTestTokenCipher(t, "k1", "k1")
+		encrypted, err := us.tokenCipher.Encrypt("plain-token")
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(exportSql).WithArgs("", batchQuerySize).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("u1", "u1", "hash", "polaris", "", "Polaris", encrypted, 1, 1, 1600000000, 1600000000, 0, "", "",
+				0, 0, 0, 0, "", 0, ""))
+		mock.ExpectRollback()
+
+		buf := &strings.Builder{}
+		assert.NoError(t, us.ExportUsersSnapshot(context.Background(), buf))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NotContains(t, buf.String(), "plain-token")
+		assert.NotContains(t, buf.String(), "AdminOperated")
+
+		exported := &store.UserSnapshot{}
+		assert.NoError(t, json.Unmarshal([]byte(buf.String()), exported))
+		assert.Equal(t, encrypted, exported.Token)
+	})
+
+	t.Run("ctx取消后停止导出", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		assert.Error(t, us.ExportUsersSnapshot(ctx, &strings.Builder{}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_AddUserCleanInvalidOnConflict(t *testing.T) {
+	insertSql := `INSERT INTO user\(.id., .name., .name_lower.,`
+	cleanSql := `delete from user where name = \? and owner = \? and flag = 1`
+	conflictErr := errors.New("Error 1062: Duplicate entry 'alice-owner' for key 'name'")
+	expectDefaultStrategy := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	newUser := func() *model.User {
+		return &model.User{ID: "u1", Name: "alice", Owner: "owner", Token: "t", Password: "p",
+			Type: model.SubAccountUserRole}
+	}
+
+	t.Run("没有冲突时不执行DELETE", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cleanInvalidOnConflict = true
+		mock.ExpectBegin()
+		mock.ExpectExec(insertSql).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectDefaultStrategy(mock)
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.AddUser(newUser()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("与已删除的用户冲突时清理后重新写入", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cleanInvalidOnConflict = true
+		mock.ExpectBegin()
+		mock.ExpectExec(insertSql).WillReturnError(conflictErr)
+		mock.ExpectExec(cleanSql).WithArgs("alice", "owner").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insertSql).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectDefaultStrategy(mock)
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.AddUser(newUser()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("与有效用户冲突", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cleanInvalidOnConflict = true
+		mock.ExpectBegin()
+		mock.ExpectExec(insertSql).WillReturnError(conflictErr)
+		mock.ExpectExec(cleanSql).WithArgs("alice", "owner").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := us.AddUser(newUser())
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("默认在写入前清理", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(cleanSql).WithArgs("alice", "owner").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(insertSql).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectDefaultStrategy(mock)
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.AddUser(newUser()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_NameCaseInsensitive(t *testing.T) {
+	t.Run("写入时保留原始名称并维护小写名称", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.nameCaseInsensitive = true
+		mock.ExpectExec(`delete from user where name_lower = \? and owner = \? and flag = 1`).
+			WithArgs("alice", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user\(.id., .name., .name_lower.,`).
+			WithArgs("u1", "Alice", "alice", "p", "owner", "", "t", "", 0, model.SubAccountUserRole, "", "", 0, "", "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.AddUser(&model.User{ID: "u1", Name: "Alice", Owner: "owner", Token: "t", Password: "p",
+			Type: model.SubAccountUserRole}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("按照小写名称查询并返回原始名称", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.nameCaseInsensitive = true
+		mock.ExpectQuery(`FROM user u +WHERE u.flag = 0 +AND u.name_lower = \? +AND u.owner = \?`).
+			WithArgs("alice", "owner").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source", "token",
+				"token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time", "password_set_time",
+				"must_change_password", "deleted_at", "created_by", "token_rotated_time"}).
+				AddRow("u1", "Alice", "p", "owner", "", "Polaris", "t", 1, int(model.SubAccountUserRole), 1600000000, 1600000000, 0,
+					"", "", 0, 0, 0, 0, "", 0))
+
+		user, err := us.GetUserByName("ALICE", "owner")
+		assert.NoError(t, err)
+		assert.Equal(t, "Alice", user.Name)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("默认区分大小写", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`FROM user u +WHERE u.flag = 0 +AND u.name = \? +AND u.owner = \?`).
+			WithArgs("ALICE", "owner").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		user, err := us.GetUserByName("ALICE", "owner")
+		assert.NoError(t, err)
+		assert.Nil(t, user)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("只有大小写不同的名称冲突", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.nameCaseInsensitive = true
+		mock.ExpectExec(`delete from user where name_lower = \?`).WithArgs("alice", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user\(`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'alice-owner' for key 'user.name_lower'"))
+		mock.ExpectRollback()
+
+		err := us.AddUser(&model.User{ID: "u2", Name: "alice", Owner: "owner", Token: "t2", Password: "p",
+			Type: model.SubAccountUserRole})
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+
+		// 修改名称时同样忽略大小写检查重名
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user WHERE id = \? AND flag = 0 FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}).AddRow("bob", "owner"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE name_lower = \? AND owner = \? AND id != \?`).
+			WithArgs("alice", "owner", "u3").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		err = us.RenameUser("u3", "ALICE", "admin")
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_ArchiveInvalidUser(t *testing.T) {
+	archiveSql := `INSERT INTO user_archive\(.*\) SELECT id, name, owner, .* FROM user WHERE flag = 1 AND `
+
+	t.Run("回收名称前归档已删除的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.archiveInvalidUser = true
+		mock.ExpectBegin()
+		mock.ExpectExec(archiveSql+`name = \? AND owner = \?$`).WithArgs("user-1", "owner").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).WithArgs("user-1", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.cleanInValidUser("user-1", "owner"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("归档失败时不删除", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.archiveInvalidUser = true
+		mock.ExpectBegin()
+		mock.ExpectExec(archiveSql).WillReturnError(errors.New("table user_archive doesn't exist"))
+		mock.ExpectRollback()
+
+		assert.Error(t, us.cleanInValidUser("user-1", "owner"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("修改名称时归档已删除的同名用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.archiveInvalidUser = true
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user`).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}).AddRow("old-name", "owner"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(archiveSql+`name = \? AND owner = \?$`).WithArgs("new-name", "owner").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE user SET name = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT ag.id, ag.owner FROM auth_strategy ag`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner"}))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.RenameUser("u1", "new-name", "admin"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("批量写入时归档已删除的同名用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.archiveInvalidUser = true
+		mock.ExpectBegin()
+		mock.ExpectExec(archiveSql+`\(name, owner\) IN \(\(\?,\?\),\(\?,\?\)\)$`).
+			WithArgs("user-1", "owner", "user-2", "owner").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`DELETE FROM user WHERE flag = 1 AND \(name, owner\) IN`).
+			WithArgs("user-1", "owner", "user-2", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO user\(`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'polaris_token' for key 'user.token'"))
+		mock.ExpectRollback()
+
+		err := us.BatchAddUser([]*model.User{
+			{ID: "u1", Name: "user-1", Owner: "owner", Token: "t1", Password: "p", Type: model.SubAccountUserRole},
+			{ID: "u2", Name: "user-2", Owner: "owner", Token: "t2", Password: "p", Type: model.SubAccountUserRole},
+		})
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("未开启时直接删除", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).WithArgs("user-1", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, us.cleanInValidUser("user-1", "owner"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_ResetUserCredentials(t *testing.T) {
+	resetSql := `UPDATE user SET +password_set_time = IF\(password = \?, password_set_time, sysdate\(\)\), +` +
+		`must_change_password = IF\(password = \?, must_change_password, 0\), +` +
+		`token_rotated_time = IF\(token = \? OR token = \?, token_rotated_time, sysdate\(\)\), +` +
+		`password = \?, token = \?, mtime = sysdate\(\), modified_by = \? WHERE id = \? AND flag = 0`
+
+	t.Run("同时重置密码以及token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(resetSql).WithArgs("new-pwd", "new-pwd", "new-token", "new-token", "new-pwd", "new-token",
+			"admin", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.ResetUserCredentials("u1", "new-pwd", "new-token", "admin"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户不存在或者已经删除", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(resetSql).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE id = \? AND flag = 0`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectRollback()
+
+		err := us.ResetUserCredentials("u1", "new-pwd", "new-token", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("token冲突时不修改密码", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(resetSql).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'new-token' for key 'user.token'"))
+		mock.ExpectRollback()
+
+		err := us.ResetUserCredentials("u1", "new-pwd", "new-token", "admin")
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("参数为空", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		err := us.ResetUserCredentials("u1", "", "new-token", "admin")
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_AddUserAndReturn(t *testing.T) {
+	userColumns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+
+	t.Run("返回数据库生成的字段", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT id, name, password, owner, .* FROM user WHERE id = \?`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow("u1", "user-1", "polaris", "owner", "", "Polaris",
+				"polaris_token", 1, int(model.SubAccountUserRole), 1700000000, 1700000001, 0, "", "", 0,
+				1700000000, 0, 0))
+		mock.ExpectCommit()
+
+		user, err := us.AddUserAndReturn(&model.User{
+			ID:       "u1",
+			Name:     "user-1",
+			Password: "polaris",
+			Owner:    "owner",
+			Source:   "Polaris",
+			Token:    "polaris_token",
+			Type:     model.SubAccountUserRole,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, "u1", user.ID)
+		assert.Equal(t, "polaris_token", user.Token)
+		assert.True(t, user.Valid)
+		assert.True(t, user.TokenEnable)
+		assert.Equal(t, time.Unix(1700000000, 0), user.CreateTime)
+		assert.Equal(t, time.Unix(1700000001, 0), user.ModifyTime)
+		assert.Equal(t, time.Unix(1700000000, 0), user.PasswordSetTime)
+	})
+
+	t.Run("写入失败时不返回用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'polaris_token' for key 'user.token'"))
+		mock.ExpectRollback()
+
+		user, err := us.AddUserAndReturn(&model.User{ID: "u1", Name: "user-1", Password: "polaris",
+			Owner: "owner", Token: "polaris_token", Type: model.SubAccountUserRole})
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.Nil(t, user)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_AddUserOwnerInvariant(t *testing.T) {
+	t.Run("主账户的owner为其他账户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		user := &model.User{ID: "m1", Name: "main-1", Password: "p", Token: "t", Owner: "m2",
+			Type: model.OwnerUserRole}
+
+		assert.Equal(t, store.InvalidUserOwner, store.Code(us.AddUser(user)))
+		_, err := us.AddUserAndReturn(user)
+		assert.Equal(t, store.InvalidUserOwner, store.Code(err))
+		assert.Equal(t, store.InvalidUserOwner, store.Code(us.BatchAddUser([]*model.User{user})))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("子账户的owner为自身", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		user := &model.User{ID: "s1", Name: "sub-1", Password: "p", Token: "t", Owner: "s1",
+			Type: model.SubAccountUserRole}
+
+		assert.Equal(t, store.InvalidUserOwner, store.Code(us.AddUser(user)))
+		user.Owner = ""
+		assert.Equal(t, store.InvalidUserOwner, store.Code(us.AddUser(user)))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_TokenConflict(t *testing.T) {
+	t.Run("新增用户token冲突", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'polaris_token' for key 'user.token'"))
+		mock.ExpectRollback()
+
+		err := us.AddUser(&model.User{
+			ID:       "u2",
+			Name:     "user-2",
+			Password: "polaris",
+			Owner:    "owner",
+			Token:    "polaris_token",
+			Type:     model.SubAccountUserRole,
+		})
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("更新用户token冲突", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'polaris_token' for key 'token'"))
+		mock.ExpectRollback()
+
+		err := us.UpdateUser(&model.User{
+			ID:       "u2",
+			Name:     "user-2",
+			Password: "polaris",
+			Owner:    "owner",
+			Token:    "polaris_token",
+		})
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("其他主键冲突", func(t *testing.T) {
+		err := convertUserTokenConflict("u2",
+			errors.New("Error 1062: Duplicate entry 'u2' for key 'PRIMARY'"))
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+	})
+}
+
+func Test_userStore_OpLogLevel(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "store.log")
+	err := commonlog.Configure(map[string]*commonlog.Options{
+		log.Name(): {
+			OutputPaths:      []string{logFile},
+			ErrorOutputPaths: []string{"stderr"},
+			OutputLevel:      "info",
+		},
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		ResetUserOpLogLevel("DeleteUser")
+		_ = commonlog.Configure(map[string]*commonlog.Options{
+			log.Name(): commonlog.DefaultOptions()[log.Name()],
+		})
+	})
+
+	// 只将 DeleteUser 的日志级别调整为 info，其他操作仍然保持 debug
+	SetUserOpLogLevel("DeleteUser", commonlog.InfoLevel)
+
+	us, mock := newTestUserStore(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE user SET flag = 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT group_id FROM user_group_relation`).WillReturnRows(sqlmock.NewRows([]string{"group_id"}))
+	mock.ExpectExec(`UPDATE auth_strategy AS ag`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT u.id, u.name`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	assert.NoError(t, us.DeleteUser(&model.User{ID: "u1", Name: "user-1", Owner: "owner"}))
+	_, err = us.GetUsersForCache(time.Time{}, true)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_ = log.Sync()
+	content, err := os.ReadFile(logFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "[Store][User] delete user")
+	assert.NotContains(t, string(content), "[Store][User] list user")
+}
+
+func Test_userStore_DeleteUserCascadeSubAccounts(t *testing.T) {
+	expectDeleteMain := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET flag = 1`).WithArgs("admin", "m1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT group_id FROM user_group_relation`).
+			WillReturnRows(sqlmock.NewRows([]string{"group_id"}))
+		mock.ExpectExec(`UPDATE auth_strategy AS ag`).WithArgs("m1", model.PrincipalUser, "m1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	main := &model.User{ID: "m1", Name: "main-1", Type: model.OwnerUserRole, ModifiedBy: "admin"}
+
+	t.Run("开启级联时按批清理子账户及其默认策略", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cascadeSubAccounts = true
+		expectDeleteMain(mock)
+		mock.ExpectQuery(`SELECT id FROM user WHERE owner = \? AND flag = 0 FOR UPDATE`).WithArgs("m1").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("s1").AddRow("s2"))
+		mock.ExpectExec(`UPDATE user SET flag = 1, deleted_at = sysdate\(\), modified_by = \? WHERE id IN \(\?,\?\)`).
+			WithArgs("admin", "s1", "s2").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(`SELECT group_id FROM user_group_relation WHERE user_id IN \(\?,\?\) AND flag = 0 FOR UPDATE`).
+			WithArgs("s1", "s2").WillReturnRows(sqlmock.NewRows([]string{"group_id"}).AddRow("g1"))
+		mock.ExpectExec(`UPDATE user_group_relation SET flag = 1, mtime = sysdate\(\) WHERE user_id IN \(\?,\?\)`).
+			WithArgs("s1", "s2").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`UPDATE user_group SET mtime = sysdate\(\) WHERE id IN \(\?\)`).WithArgs("g1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy AS ag`).WithArgs("s1", "s2", model.PrincipalUser, "m1").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WithArgs("s1", "s2", model.PrincipalUser).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM auth_strategy_resource`).WithArgs("m1", "s1", "s2", model.PrincipalUser).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM auth_principal WHERE principal_id IN \(\?,\?\) AND principal_role = \?`).
+			WithArgs("s1", "s2", model.PrincipalUser).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.DeleteUser(main))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("删除子账户时不级联", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cascadeSubAccounts = true
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET flag = 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT group_id FROM user_group_relation`).
+			WillReturnRows(sqlmock.NewRows([]string{"group_id"}))
+		mock.ExpectExec(`UPDATE auth_strategy AS ag`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.DeleteUser(&model.User{ID: "s1", Name: "sub-1", Owner: "m1",
+			Type: model.SubAccountUserRole}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("关闭级联时不处理子账户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expectDeleteMain(mock)
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.DeleteUser(main))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_DefaultStrategyOnRecreate(t *testing.T) {
+	t.Run("删除主账户时按照创建时的owner清理默认策略", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET flag = 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT group_id FROM user_group_relation`).
+			WillReturnRows(sqlmock.NewRows([]string{"group_id"}))
+		mock.ExpectExec(`UPDATE auth_strategy AS ag`).
+			WithArgs("u1", model.PrincipalUser, "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy_resource`).
+			WithArgs("u1", "u1", model.PrincipalUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.DeleteUser(&model.User{ID: "u1", Name: "user-1"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("重建用户时复用仍然有效的默认策略", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WithArgs(model.BuildDefaultStrategyName(model.PrincipalUser, "user-1"), "u1", true).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("s1"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_principal`).
+			WithArgs("s1", "u1", model.PrincipalUser).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		tx, err := us.master.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, createDefaultStrategy(tx, model.PrincipalUser, "u1", "user-1", "", false))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("同名默认策略属于其他用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("s1"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_principal`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		tx, err := us.master.Begin()
+		assert.NoError(t, err)
+		err = createDefaultStrategy(tx, model.PrincipalUser, "u2", "user-1", "owner", false)
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.Contains(t, err.Error(), "defaultStrategyConflict")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("同名默认策略属于其他用户时复用", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("s1"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_principal`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`INSERT INTO auth_principal`).
+			WithArgs("s1", "u2", model.PrincipalUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy SET revision = \?, mtime = sysdate\(\) WHERE id = \?`).
+			WithArgs(sqlmock.AnyArg(), "s1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		tx, err := us.master.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, createDefaultStrategy(tx, model.PrincipalUser, "u2", "user-1", "owner", true))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_RebuildDefaultStrategy(t *testing.T) {
+	strategyName := model.BuildDefaultStrategyName(model.PrincipalUser, "user-1")
+	cleanLinkSql := `DELETE ap FROM auth_principal ap LEFT JOIN auth_strategy ag ON ag.id = ap.strategy_id +` +
+		`WHERE ap.principal_id = \? AND ap.principal_role = \? AND \(ag.id IS NULL OR ag.flag = 1\)`
+	orphanSql := `SELECT id FROM auth_strategy ag WHERE name = \? AND owner = \? AND flag = 0 AND .default. = 1 +` +
+		`AND NOT EXISTS`
+	expectUser := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user WHERE id = \? AND flag = 0 FOR UPDATE`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}).AddRow("user-1", "owner"))
+		mock.ExpectExec(cleanLinkSql).
+			WithArgs("u1", model.PrincipalUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	expectCreate := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WithArgs(strategyName, "owner", true).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	t.Run("默认策略被删除后重建", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expectUser(mock)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_principal ap INNER JOIN auth_strategy ag`).
+			WithArgs("u1", model.PrincipalUser).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(orphanSql).
+			WithArgs(strategyName, "owner").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		expectCreate(mock)
+
+		assert.NoError(t, us.RebuildDefaultStrategy("u1"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("移除没有关联任何用户的默认策略后重建", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expectUser(mock)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_principal ap INNER JOIN auth_strategy ag`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`AND NOT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("s1"))
+		mock.ExpectExec(`DELETE FROM auth_strategy_resource WHERE strategy_id = \?`).WithArgs("s1").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE id = \?`).WithArgs("s1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectCreate(mock)
+
+		assert.NoError(t, us.RebuildDefaultStrategy("u1"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("已经关联了有效的默认策略", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expectUser(mock)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_principal ap INNER JOIN auth_strategy ag`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.RebuildDefaultStrategy("u1"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user WHERE id = \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}))
+		mock.ExpectRollback()
+
+		err := us.RebuildDefaultStrategy("u1")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_SoftDeleteAndRecoverUser(t *testing.T) {
+	expectCleanStrategy := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(`UPDATE auth_strategy AS ag`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("级联删除用户组关联关系", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET flag = 1`).WithArgs("", "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT group_id FROM user_group_relation WHERE user_id IN \(\?\) AND flag = 0 FOR UPDATE`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"group_id"}).AddRow("g1").AddRow("g2"))
+		mock.ExpectExec(`UPDATE user_group_relation SET flag = 1`).WithArgs("u1").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`UPDATE user_group SET mtime = sysdate\(\) WHERE id IN \(\?,\?\)`).WithArgs("g1", "g2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		expectCleanStrategy(mock)
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.SoftDeleteUser(&model.User{ID: "u1", Name: "user-1", Owner: "owner"}, true))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("保留用户组关联关系后恢复", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		// 只标记用户为删除，不修改 user_group_relation
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET flag = 1`).WithArgs("", "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectCleanStrategy(mock)
+		mock.ExpectCommit()
+		assert.NoError(t, us.SoftDeleteUser(&model.User{ID: "u1", Name: "user-1", Owner: "owner"}, false))
+
+		// 恢复用户后保留的关联关系重新生效，并重建默认策略
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user WHERE id = \? AND flag = 1 FOR UPDATE`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}).AddRow("user-1", "owner"))
+		mock.ExpectExec(`UPDATE user SET flag = 0, deleted_at = NULL, mtime = sysdate\(\), modified_by = \? WHERE id = \?`).
+			WithArgs("admin", "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT group_id FROM user_group_relation WHERE user_id IN \(\?\) AND flag = 0 FOR UPDATE`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"group_id"}).AddRow("g1"))
+		mock.ExpectExec(`UPDATE user_group_relation SET mtime = sysdate\(\) WHERE user_id = \? AND flag = 0`).
+			WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE user_group SET mtime = sysdate\(\) WHERE id IN \(\?\)`).WithArgs("g1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE ap FROM auth_principal ap LEFT JOIN auth_strategy ag`).
+			WithArgs("u1", model.PrincipalUser).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_principal ap INNER JOIN auth_strategy ag`).
+			WithArgs("u1", model.PrincipalUser).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`AND NOT EXISTS`).
+			WithArgs(model.BuildDefaultStrategyName(model.PrincipalUser, "user-1"), "owner").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.RecoverUser("u1", "admin"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("恢复未删除的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, owner FROM user WHERE id = \? AND flag = 1 FOR UPDATE`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "owner"}))
+		mock.ExpectRollback()
+
+		err := us.RecoverUser("u1", "admin")
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_DeleteAndPurgeUsers(t *testing.T) {
+	t.Run("删除用户时记录删除时间", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET flag = 1, deleted_at = sysdate\(\), modified_by = \? WHERE id = \?`).
+			WithArgs("", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT group_id FROM user_group_relation WHERE user_id IN \(\?\) AND flag = 0 FOR UPDATE`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"group_id"}).AddRow("g1"))
+		mock.ExpectExec(`UPDATE user_group_relation SET flag = 1, mtime = sysdate\(\) WHERE user_id IN \(\?\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE user_group SET mtime = sysdate\(\) WHERE id IN \(\?\)`).
+			WithArgs("g1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy AS ag`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.DeleteUser(&model.User{ID: "u1", Name: "u1", Owner: "owner"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("按照删除时间清理用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		before := time.Unix(1700000000, 0)
+		mock.ExpectBegin()
+		// 被清理的用户的关联关系以及已经移除的关联关系先被清理
+		mock.ExpectExec(`DELETE FROM user_group_relation WHERE \(flag = 1 AND mtime < FROM_UNIXTIME\(\?\)\)`+
+			` OR user_id IN \(SELECT id FROM user WHERE flag = 1 AND deleted_at IS NOT NULL`).
+			WithArgs(int64(1700000000), int64(1700000000)).
+			WillReturnResult(sqlmock.NewResult(0, 5))
+		mock.ExpectExec(`DELETE FROM user WHERE flag = 1 AND deleted_at IS NOT NULL AND deleted_at < FROM_UNIXTIME\(\?\)`).
+			WithArgs(int64(1700000000)).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		count, err := us.PurgeDeletedUsers(before)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), count)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询结果中携带删除时间", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`IFNULL\(UNIX_TIMESTAMP\(u.deleted_at\), 0\) +FROM user u`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+				"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+				AddRow("u1", "u1", "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 1, "", "", 0, 0, 0,
+					1650000000).
+				AddRow("u2", "u2", "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0))
+
+		users, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(users))
+		assert.False(t, users[0].Valid)
+		assert.Equal(t, int64(1650000000), users[0].DeleteTime.Unix())
+		assert.True(t, users[1].DeleteTime.IsZero())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// recordArg 记录匹配到的参数值，用于校验随机生成的字段
+type recordArg struct {
+	values *[]string
+}
+
+func (a recordArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.values = append(*a.values, s)
+	return ok && s != ""
+}
+
+func Test_userStore_SetUsersTokenEnable(t *testing.T) {
+	t.Run("批量冻结部分用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET token_enable = \?, mtime = sysdate\(\), modified_by = \? WHERE flag = 0 `+
+			`AND token_enable != \? AND id IN \(\?,\?,\?\)`).
+			WithArgs(0, "admin", 0, "u1", "u3", "u5").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		count, err := us.SetUsersTokenEnable([]string{"u1", "u3", "u5"}, false, "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), count)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户ID为空", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, err := us.SetUsersTokenEnable(nil, true, "admin")
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_SetUsersComment(t *testing.T) {
+	t.Run("批量设置部分用户的备注", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET comment = \?, mtime = sysdate\(\), modified_by = \? WHERE flag = 0 `+
+			`AND comment != \? AND id IN \(\?,\?\)$`).
+			WithArgs("migrated 2024-Q1", "admin", "migrated 2024-Q1", "u1", "u3").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		count, err := us.SetUsersComment([]string{"u1", "u3"}, "migrated 2024-Q1", "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), count)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("备注超长", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, err := us.SetUsersComment([]string{"u1"}, strings.Repeat("备", store.MaxUserCommentLength+1), "admin")
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户ID为空", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, err := us.SetUsersComment(nil, "comment", "admin")
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_GetUserCountsByType(t *testing.T) {
+	t.Run("按用户类型统计", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT user_type, COUNT\(\*\) FROM user WHERE flag = 0 AND user_type != 0 GROUP BY user_type`).
+			WillReturnRows(sqlmock.NewRows([]string{"user_type", "count"}).AddRow(20, 12).AddRow(50, 340))
+
+		counts, err := us.GetUserCountsByType()
+		assert.NoError(t, err)
+		assert.Equal(t, map[model.UserRoleType]uint32{model.OwnerUserRole: 12, model.SubAccountUserRole: 340}, counts)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询失败", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`GROUP BY user_type`).WillReturnError(errors.New("mock error"))
+
+		_, err := us.GetUserCountsByType()
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_GetUserCountsBySource(t *testing.T) {
+	t.Run("按来源统计", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT source, COUNT\(\*\) FROM user WHERE flag = 0 AND user_type != 0 GROUP BY source`).
+			WillReturnRows(sqlmock.NewRows([]string{"source", "count"}).
+				AddRow("LDAP", 1200).AddRow("OIDC", 340).AddRow("Polaris", 50))
+
+		counts, err := us.GetUserCountsBySource()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]uint32{"LDAP": 1200, "OIDC": 340, "Polaris": 50}, counts)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询失败", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`GROUP BY source`).WillReturnError(errors.New("mock error"))
+
+		_, err := us.GetUserCountsBySource()
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_FindDuplicateTokens(t *testing.T) {
+	us, mock := newTestUserStore(t)
+	us.tokenCipher = newTestTokenCipher(t, "k2", "k1", "k2")
+	oldCipher := newTestTokenCipher(t, "k1", "k1", "k2")
+
+	// 同一个 token 分别以明文、旧 key 以及新 key 的形式落库，唯一索引无法发现
+	oldToken, err := oldCipher.Encrypt("dup-token")
+	assert.NoError(t, err)
+	newToken, err := us.tokenCipher.Encrypt("dup-token")
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldToken, newToken)
+
+	mock.ExpectQuery(`SELECT id, token FROM user WHERE flag = 0$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token"}).
+			AddRow("u3", newToken).
+			AddRow("u1", "dup-token").
+			AddRow("u2", "unique-token").
+			AddRow("u4", oldToken).
+			AddRow("u5", "other-dup").
+			AddRow("u6", "other-dup").
+			AddRow("u7", ""))
+
+	groups, err := us.FindDuplicateTokens()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"u1", "u3", "u4"}, {"u5", "u6"}}, groups)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_userStore_FindDuplicateUserNames(t *testing.T) {
+	t.Run("按照名称以及owner查询重复的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT id, name, owner FROM user WHERE flag = 0$`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner"}).
+				AddRow("u3", "dup", "owner-1").
+				AddRow("u1", "dup", "owner-1").
+				AddRow("u2", "dup", "owner-2").
+				AddRow("u4", "Dup", "owner-1").
+				AddRow("u5", "other", "owner-2").
+				AddRow("u6", "other", "owner-2"))
+
+		groups, err := us.FindDuplicateUserNames()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"u1", "u3"}, {"u5", "u6"}}, groups)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("忽略大小写时按照name_lower比较", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.nameCaseInsensitive = true
+		mock.ExpectQuery(`SELECT id, name_lower, owner FROM user WHERE flag = 0$`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name_lower", "owner"}).
+				AddRow("u1", "dup", "owner-1").
+				AddRow("u4", "dup", "owner-1").
+				AddRow("u2", "unique", "owner-1"))
+
+		groups, err := us.FindDuplicateUserNames()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"u1", "u4"}}, groups)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("没有重复的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT id, name, owner FROM user WHERE flag = 0$`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner"}).
+				AddRow("u1", "user-1", "owner-1").
+				AddRow("u2", "user-1", "owner-2"))
+
+		groups, err := us.FindDuplicateUserNames()
+		assert.NoError(t, err)
+		assert.Empty(t, groups)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_FindWeakTokens(t *testing.T) {
+	t.Run("按照熵阈值查询弱token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT id, token FROM user WHERE flag = 0$`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "token"}).
+				AddRow("u1", "aaaaaaaaaaaaaaaa").
+				AddRow("u2", "Zr8qK2xLw9TfB4nVj7HsD1mPc6YgE3uA").
+				AddRow("u3", "abababababababab").
+				AddRow("u4", ""))
+
+		ids, err := us.FindWeakTokens(64)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"u1", "u3", "u4"}, ids)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("非法的熵阈值", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, err := us.FindWeakTokens(0)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_AuditDefaultStrategies(t *testing.T) {
+	us, mock := newTestUserStore(t)
+	mock.ExpectQuery(`SELECT id, name FROM user WHERE flag = 0$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+			AddRow("u1", "user-1").
+			AddRow("u2", "user-2").
+			AddRow("u3", "user-3").
+			AddRow("u4", "user-4"))
+	// u2 的默认策略已经被删除，u3 关联的默认策略名称与用户名不一致，u4 没有关联任何默认策略
+	mock.ExpectQuery(`SELECT ap.principal_id, ag.name FROM auth_principal ap INNER JOIN auth_strategy ag +` +
+		`ON ag.id = ap.strategy_id WHERE ap.principal_role = \? AND ag.flag = 0 AND ag..default. = 1`).
+		WithArgs(model.PrincipalUser).
+		WillReturnRows(sqlmock.NewRows([]string{"principal_id", "name"}).
+			AddRow("u1", model.BuildDefaultStrategyName(model.PrincipalUser, "user-1")).
+			AddRow("u3", model.BuildDefaultStrategyName(model.PrincipalUser, "renamed")))
+
+	ids, err := us.AuditDefaultStrategies()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u2", "u3", "u4"}, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_userStore_UserChangeLog(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source", "token", "token_enable",
+		"user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time", "password_set_time",
+		"must_change_password", "deleted_at"}
+
+	t.Run("修改用户时记录变更", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.changeLog = true
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT id, name, .* FROM user WHERE id IN \(\?\)`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("u1", "user-1", "", "owner", "new", "Polaris", "", 0, 50, 0, 1700000000, 0, "", "", 0, 0, 0, 0))
+		mock.ExpectExec(`UPDATE user_change_seq SET seq = seq \+ \? WHERE id = 1`).WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT seq FROM user_change_seq WHERE id = 1`).
+			WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow(8))
+		mock.ExpectExec(`INSERT INTO user_change_log`).
+			WithArgs(uint64(8), "update", "u1", `{"id":"u1","name":"user-1","owner":"owner","source":"Polaris",`+
+				`"type":50,"comment":"new","token_enable":false,"valid":true,"must_change_password":false,`+
+				`"mtime":1700000000}`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(&model.User{ID: "u1", Name: "user-1", Password: "polaris",
+			Owner: "owner", Token: "polaris_token", Comment: "new"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("批量冻结时按照顺序分配序号", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.changeLog = true
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM user WHERE flag = 0 AND token_enable != \? AND id IN \(\?,\?,\?\) FOR UPDATE`).
+			WithArgs(0, "u1", "u2", "u3").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("u1").AddRow("u3"))
+		mock.ExpectExec(`UPDATE user SET token_enable = \?`).WithArgs(0, "admin", 0, "u1", "u3").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(`SELECT id, name, .* FROM user WHERE id IN \(\?,\?\)`).WithArgs("u1", "u3").
+			WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectExec(`UPDATE user_change_seq`).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT seq FROM user_change_seq`).
+			WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow(12))
+		mock.ExpectExec(`INSERT INTO user_change_log\(.*\) VALUES \(\?,\?,\?,\?,sysdate\(\)\),\(\?,\?,\?,\?,sysdate\(\)\)`).
+			WithArgs(uint64(11), "update", "u1", sqlmock.AnyArg(), uint64(12), "update", "u3", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		count, err := us.SetUsersTokenEnable([]string{"u1", "u2", "u3"}, false, "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), count)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("增量读取变更", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT seq, op, user_id, payload, UNIX_TIMESTAMP\(ctime\) FROM user_change_log `+
+			`+WHERE seq > \? ORDER BY seq LIMIT \?`).
+			WithArgs(uint64(10), uint32(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"seq", "op", "user_id", "payload", "ctime"}).
+				AddRow(11, "update", "u1", "{}", 1700000000).
+				AddRow(12, "delete", "u3", "{}", 1700000001))
+
+		changes, err := us.GetUserChanges(10, 2)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 2)
+		assert.Equal(t, uint64(11), changes[0].Seq)
+		assert.Equal(t, model.UserChangeUpdate, changes[0].Op)
+		assert.Equal(t, uint64(12), changes[1].Seq)
+		assert.Equal(t, model.UserChangeDelete, changes[1].Op)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("合并同一个用户的多次变更", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.changeCompact = true
+		mock.ExpectQuery(`SELECT seq, op, user_id, payload, UNIX_TIMESTAMP\(ctime\) FROM user_change_log `).
+			WithArgs(uint64(10), uint32(5)).
+			WillReturnRows(sqlmock.NewRows([]string{"seq", "op", "user_id", "payload", "ctime"}).
+				AddRow(11, "update", "u1", `{"comment":"c1"}`, 1700000000).
+				AddRow(12, "update", "u2", `{"comment":"c1"}`, 1700000001).
+				AddRow(13, "update", "u1", `{"comment":"c2"}`, 1700000002).
+				AddRow(14, "update", "u1", `{"comment":"c3"}`, 1700000003).
+				AddRow(15, "delete", "u2", `{"valid":false}`, 1700000004))
+
+		changes, err := us.GetUserChanges(10, 5)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 2)
+		assert.Equal(t, uint64(14), changes[0].Seq)
+		assert.Equal(t, "u1", changes[0].UserID)
+		assert.Equal(t, `{"comment":"c3"}`, changes[0].Payload)
+		assert.Equal(t, uint64(15), changes[1].Seq)
+		assert.Equal(t, model.UserChangeDelete, changes[1].Op)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_BatchAddUser(t *testing.T) {
+	newUsers := func(n int) []*model.User {
+		users := make([]*model.User, 0, n)
+		for i := 0; i < n; i++ {
+			users = append(users, &model.User{ID: fmt.Sprintf("u%d", i), Name: fmt.Sprintf("user_%d", i),
+				Owner: "polaris", Token: fmt.Sprintf("t%d", i), Password: "p", Source: "Polaris",
+				Type: model.SubAccountUserRole, CreatedBy: "admin"})
+		}
+		return users
+	}
+
+	t.Run("单个事务内批量写入用户及默认策略", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		users := newUsers(200)
+
+		var strategyIds, revisions, principalStrategyIds []string
+		keyArgs := make([]driver.Value, 0, 400)
+		userArgs := make([]driver.Value, 0, 200*15)
+		mainArgs := make([]driver.Value, 0, 200*8)
+		principalArgs := make([]driver.Value, 0, 200*3)
+		for _, user := range users {
+			keyArgs = append(keyArgs, user.Name, user.Owner)
+			userArgs = append(userArgs, user.ID, user.Name, user.Name, user.Password, user.Owner, user.Source,
+				user.Token, "", 0, model.SubAccountUserRole, "", "", 0, user.CreatedBy, user.CreatedBy)
+			mainArgs = append(mainArgs, recordArg{&strategyIds}, model.BuildDefaultStrategyName(model.PrincipalUser,
+				user.Name), "READ_WRITE", user.Owner, "Default Strategy", 0, true, recordArg{&revisions})
+			principalArgs = append(principalArgs, recordArg{&principalStrategyIds}, user.ID, model.PrincipalUser)
+		}
+		strategyKeyArgs := make([]driver.Value, 0, 400)
+		for _, user := range users {
+			strategyKeyArgs = append(strategyKeyArgs, model.BuildDefaultStrategyName(model.PrincipalUser, user.Name),
+				user.Owner)
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM user WHERE flag = 1 AND \(name, owner\) IN`).WithArgs(keyArgs...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO user`).WithArgs(userArgs...).WillReturnResult(sqlmock.NewResult(0, 200))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE flag = 1`).WithArgs(strategyKeyArgs...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT s.id, s.name, s.owner`).
+			WithArgs(append([]driver.Value{model.PrincipalUser}, strategyKeyArgs...)...).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner", "principal_id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WithArgs(mainArgs...).WillReturnResult(sqlmock.NewResult(0, 200))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WithArgs(principalArgs...).
+			WillReturnResult(sqlmock.NewResult(0, 200))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.BatchAddUser(users))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		unique := make(map[string]struct{}, len(strategyIds))
+		for _, id := range strategyIds {
+			unique[id] = struct{}{}
+		}
+		assert.Len(t, unique, len(users))
+		assert.Len(t, revisions, len(users))
+		assert.Equal(t, strategyIds, principalStrategyIds)
+	})
+
+	t.Run("默认策略属于其他用户时整体回滚", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		users := newUsers(2)
+		name := model.BuildDefaultStrategyName(model.PrincipalUser, users[1].Name)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM user WHERE flag = 1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO user`).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE flag = 1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT s.id, s.name, s.owner`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner", "principal_id"}).
+				AddRow("s1", name, "polaris", "other"))
+		mock.ExpectRollback()
+
+		err := us.BatchAddUser(users)
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("导入的用户ID冲突时复用默认策略", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.reuseDefaultStrategy = true
+		users := newUsers(1)
+		name := model.BuildDefaultStrategyName(model.PrincipalUser, users[0].Name)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM user WHERE flag = 1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO user`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE flag = 1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT s.id, s.name, s.owner`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner", "principal_id"}).
+				AddRow("s1", name, "polaris", "other"))
+		mock.ExpectExec(`INSERT INTO auth_principal`).
+			WithArgs("s1", users[0].ID, model.PrincipalUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE auth_strategy SET revision = \?, mtime = sysdate\(\) WHERE id = \?`).
+			WithArgs(sqlmock.AnyArg(), "s1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.BatchAddUser(users))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("token冲突时指明冲突的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		users := newUsers(3)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM user WHERE flag = 1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO user`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 't2' for key 'user.token'"))
+		mock.ExpectRollback()
+
+		err := us.BatchAddUser(users)
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.Contains(t, err.Error(), "user(u2)")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("无法确定冲突的用户时不指明用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		users := newUsers(3)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM user WHERE flag = 1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO user`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 'truncated' for key 'token'"))
+		mock.ExpectRollback()
+
+		err := us.BatchAddUser(users)
+		assert.Equal(t, store.DataConflictErr, store.Code(err))
+		assert.NotContains(t, err.Error(), "user(u0)")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("参数缺失", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		err := us.BatchAddUser([]*model.User{{ID: "u1"}})
+		assert.Equal(t, store.EmptyParamsErr, store.Code(err))
+	})
+}
+
+func Test_userStore_BatchUsersBestEffort(t *testing.T) {
+	t.Run("逐个添加用户，冲突的用户不影响其他用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		users := []*model.User{
+			{ID: "u1", Name: "user-1", Password: "p", Owner: "polaris", Token: "t1", Type: model.SubAccountUserRole},
+			{ID: "u2", Name: "user-2", Password: "p", Owner: "polaris", Token: "t1", Type: model.SubAccountUserRole},
+			{ID: "u3", Name: "user-3", Owner: "polaris", Token: "t3", Type: model.SubAccountUserRole},
+		}
+
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WithArgs("user-1", "polaris").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WithArgs("user-2", "polaris").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user`).
+			WillReturnError(errors.New("Error 1062: Duplicate entry 't1' for key 'user.token'"))
+		mock.ExpectRollback()
+
+		results := us.BatchAddUsersBestEffort(users)
+		assert.Equal(t, []string{"u1", "u2", "u3"}, []string{results[0].ID, results[1].ID, results[2].ID})
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, store.DataConflictErr, store.Code(results[1].Err))
+		assert.Equal(t, store.EmptyParamsErr, store.Code(results[2].Err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("逐个删除用户，参数缺失的用户不影响其他用户", func(t *testing.T) {
+		us, _ := newTestUserStore(t)
+		results := us.BatchDeleteUsersBestEffort([]*model.User{{ID: "u1"}, {Name: "user-2"}})
+		assert.Len(t, results, 2)
+		assert.Equal(t, "u1", results[0].ID)
+		assert.Equal(t, store.EmptyParamsErr, store.Code(results[0].Err))
+		assert.Equal(t, store.EmptyParamsErr, store.Code(results[1].Err))
+	})
+}
+
+func Test_userStore_GetMainAccountsWithSubCounts(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at", "sub_count"}
+
+	t.Run("查询主账户以及子账户个数", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 AND id = owner AND user_type != 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(`SELECT u.id, .*, \(SELECT COUNT\(\*\) FROM user s WHERE s.owner = u.id AND s.id != s.owner `+
+			`AND s.flag = 0\) FROM user u WHERE u.flag = 0 AND u.id = u.owner AND u.user_type != 0 `+
+			`ORDER BY u.ctime, u.id LIMIT \? , \?`).
+			WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("m2", "m2", "", "m2", "", "Polaris", "", 1, 20, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0, 0).
+				AddRow("m3", "m3", "", "m3", "", "Polaris", "", 1, 20, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0, 5))
+
+		total, users, err := us.GetMainAccountsWithSubCounts(1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), total)
+		assert.Len(t, users, 2)
+		assert.Equal(t, "m2", users[0].ID)
+		assert.Equal(t, uint32(0), users[0].SubCount)
+		assert.Equal(t, "m3", users[1].ID)
+		assert.Equal(t, uint32(5), users[1].SubCount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("offset超过上限", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, _, err := us.GetMainAccountsWithSubCounts(store.DefaultUserQueryMaxOffset+1, 10)
+		assert.Equal(t, store.InvalidParameter, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_GetRecentlyModifiedUsers(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+
+	t.Run("按照mtime倒序返回最近修改的用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		rows := sqlmock.NewRows(columns)
+		for _, i := range []int{4, 3, 2} {
+			rows.AddRow(fmt.Sprintf("u%d", i), fmt.Sprintf("u%d", i), "", "polaris", "", "Polaris", "", 1, 1,
+				1600000000, 1600000000+i*60, 0, "", "", 0, 0, 0, 0)
+		}
+		mock.ExpectQuery(`WHERE flag = 0 AND user_type != 0 +ORDER BY mtime DESC, id LIMIT \?`).
+			WithArgs(uint32(3)).
+			WillReturnRows(rows)
+
+		users, err := us.GetRecentlyModifiedUsers(3)
+		assert.NoError(t, err)
+		assert.Len(t, users, 3)
+		for i, id := range []string{"u4", "u3", "u2"} {
+			assert.Equal(t, id, users[i].ID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("limit超过上限时按上限处理", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`ORDER BY mtime DESC, id LIMIT \?`).
+			WithArgs(store.MaxRecentlyModifiedUsers).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		users, err := us.GetRecentlyModifiedUsers(store.MaxRecentlyModifiedUsers + 1)
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_GetUsersForCacheExcludeSecrets(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"}
+
+	t.Run("默认加载密码以及token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT u.id, u.name, u.password, u.owner, u.comment, u.source +, u.token, u.token_enable`).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("u1", "u1", "p", "polaris", "", "Polaris", "t", 1, 1,
+				1600000000, 1600000000, 0, "", "", 0, 0, 0, 0))
+
+		users, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, "p", users[0].Password)
+		assert.Equal(t, "t", users[0].Token)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("不加载密码以及token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cacheExcludePassword = true
+		us.cacheExcludeToken = true
+		mock.ExpectQuery(`SELECT u.id, u.name, '', u.owner, u.comment, u.source +, '', u.token_enable`).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("u1", "u1", "", "polaris", "", "Polaris", "", 1, 1,
+				1600000000, 1600000000, 0, "", "", 0, 0, 0, 0))
+
+		users, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Empty(t, users[0].Password)
+		assert.Empty(t, users[0].Token)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_GetUsersForCacheProjection(t *testing.T) {
+	columns := []string{"id", "name", "owner", "token", "token_enable", "user_type", "mtime", "flag",
+		"password_set_time", "must_change_password"}
+
+	t.Run("只查询缓存需要的字段", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cacheProjection = true
+		mock.ExpectQuery(`^SELECT u.id, u.name, u.owner, u.token, u.token_enable, u.user_type, ` +
+			`UNIX_TIMESTAMP\(u.mtime\), u.flag, IFNULL\(UNIX_TIMESTAMP\(u.password_set_time\), 0\), ` +
+			`u.must_change_password FROM user u +WHERE u.mtime >= FROM_UNIXTIME\(\?\)$`).
+			WithArgs(int64(1600000000)).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("u1", "u1", "polaris", "polaris_token", 1, 50,
+				1600000001, 1, 1600000000, 1))
+
+		users, err := us.GetUsersForCache(time.Unix(1600000000, 0), false)
+		assert.NoError(t, err)
+		assert.Len(t, users, 1)
+		user := users[0]
+		assert.Equal(t, "u1", user.ID)
+		assert.Equal(t, "u1", user.Name)
+		assert.Equal(t, "polaris", user.Owner)
+		assert.Equal(t, "polaris_token", user.Token)
+		assert.True(t, user.TokenEnable)
+		assert.Equal(t, model.SubAccountUserRole, user.Type)
+		assert.Equal(t, time.Unix(1600000001, 0), user.ModifyTime)
+		assert.False(t, user.Valid)
+
+		assert.Empty(t, user.Password)
+		assert.Empty(t, user.Comment)
+		assert.Empty(t, user.Source)
+		assert.True(t, user.CreateTime.IsZero())
+		assert.True(t, user.LastLoginTime.IsZero())
+		assert.True(t, user.DeleteTime.IsZero())
+		// 密码过期的校验依赖这两个字段
+		assert.Equal(t, time.Unix(1600000000, 0), user.PasswordSetTime)
+		assert.True(t, user.MustChangePassword)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("同时不加载token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cacheProjection = true
+		us.cacheExcludeToken = true
+		mock.ExpectQuery(`^SELECT u.id, u.name, u.owner, '', u.token_enable`).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("u1", "u1", "polaris", "", 1, 50, 1600000000, 0, 0, 0))
+
+		users, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Empty(t, users[0].Token)
+		assert.True(t, users[0].Valid)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_GetAddableUsersForGroup(t *testing.T) {
+	t.Run("排除用户组当前的成员", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT owner FROM user_group WHERE id = \? AND flag = 0`).WithArgs("g1").
+			WillReturnRows(sqlmock.NewRows([]string{"owner"}).AddRow("o1"))
+		mock.ExpectQuery(`SELECT user_id FROM user_group_relation WHERE group_id = \? AND flag = 0`).WithArgs("g1").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("u1").AddRow("u2"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND user_type != 0 +`+
+			`AND id NOT IN \(\?,\?\) +AND \(id = \? OR owner = \?\)`).
+			WithArgs("u1", "u2", "o1", "o1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`FROM user +WHERE flag = 0 +AND user_type != 0 +AND id NOT IN \(\?,\?\)`).
+			WithArgs("u1", "u2", "o1", "o1", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+				"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+				AddRow("u3", "u3", "", "o1", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0))
+
+		total, users, err := us.GetAddableUsersForGroup("g1", "o1", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Len(t, users, 1)
+		assert.Equal(t, "u3", users[0].ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户组不属于该主账户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT owner FROM user_group WHERE id = \? AND flag = 0`).WithArgs("g1").
+			WillReturnRows(sqlmock.NewRows([]string{"owner"}).AddRow("o2"))
+
+		_, _, err := us.GetAddableUsersForGroup("g1", "o1", 0, 10)
+		assert.Equal(t, store.NotFoundUserGroup, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户组不存在", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT owner FROM user_group WHERE id = \? AND flag = 0`).WithArgs("g1").
+			WillReturnRows(sqlmock.NewRows([]string{"owner"}))
+
+		_, _, err := us.GetAddableUsersForGroup("g1", "o1", 0, 10)
+		assert.Equal(t, store.NotFoundUserGroup, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_GetUsersForCacheWithGroups(t *testing.T) {
+	columns := []string{"id", "name", "owner", "token", "token_enable", "user_type", "mtime", "flag",
+		"password_set_time", "must_change_password"}
+
+	t.Run("同时返回用户所属的用户组", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cacheProjection = true
+		us.cacheWithGroups = true
+		mock.ExpectQuery(`^SELECT u.id, u.name, u.owner, u.token`).WithArgs(int64(1600000000)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("u1", "u1", "polaris", "t1", 1, 50, 1600000001, 0, 0, 0).
+				AddRow("u2", "u2", "polaris", "t2", 1, 50, 1600000001, 0, 0, 0).
+				AddRow("u3", "u3", "polaris", "t3", 1, 50, 1600000001, 0, 0, 0))
+		mock.ExpectQuery(`^SELECT ugr.user_id, ugr.group_id FROM user_group_relation ugr ` +
+			`INNER JOIN user_group ug ON ug.id = ugr.group_id AND ug.flag = 0 ` +
+			`INNER JOIN user u ON u.id = ugr.user_id AND u.flag = 0 WHERE ugr.flag = 0 +` +
+			`AND u.mtime >= FROM_UNIXTIME\(\?\) +ORDER BY ugr.user_id, ugr.group_id$`).
+			WithArgs(int64(1600000000)).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "group_id"}).
+				AddRow("u1", "g1").AddRow("u1", "g2").AddRow("u2", "g1"))
+
+		users, err := us.GetUsersForCache(time.Unix(1600000000, 0), false)
+		assert.NoError(t, err)
+		assert.Len(t, users, 3)
+		assert.Equal(t, []string{"g1", "g2"}, users[0].GroupIDs)
+		assert.Equal(t, []string{"g1"}, users[1].GroupIDs)
+		assert.Nil(t, users[2].GroupIDs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询用户组失败", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cacheWithGroups = true
+		mock.ExpectQuery(`SELECT u.id, u.name, u.password`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+				"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+				AddRow("u1", "u1", "p", "polaris", "", "Polaris", "t", 1, 1, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0))
+		mock.ExpectQuery(`FROM user_group_relation ugr`).WillReturnError(errors.New("mock error"))
+
+		_, err := us.GetUsersForCache(time.Time{}, true)
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("没有用户时不查询用户组", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.cacheProjection = true
+		us.cacheWithGroups = true
+		mock.ExpectQuery(`^SELECT u.id, u.name, u.owner, u.token`).WillReturnRows(sqlmock.NewRows(columns))
+
+		users, err := us.GetUsersForCache(time.Time{}, true)
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// expectUserIdsChunk 期望一次按照 ids 批量查询用户的 SQL，并返回这些 ID 对应的用户
+func expectUserIdsChunk(mock sqlmock.Sqlmock, ids []string) *sqlmock.ExpectedQuery {
+	args := make([]driver.Value, 0, len(ids))
+	rows := sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+		"last_login_time", "password_set_time", "must_change_password", "deleted_at"})
+	for _, id := range ids {
+		args = append(args, id)
+		rows.AddRow(id, id, "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0)
+	}
+	return mock.ExpectQuery(`FROM user u +WHERE u.flag = 0 AND u.id IN \(`).
+		WithArgs(args...).
+		WillReturnRows(rows)
+}
+
+func newTestUserIds(n int) []string {
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ids = append(ids, fmt.Sprintf("u-%d", i))
+	}
+	return ids
+}
+
+func Test_userStore_GetUserByIds(t *testing.T) {
+	t.Run("串行分批查询", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		ids := newTestUserIds(batchQuerySize + 10)
+		expectUserIdsChunk(mock, ids[:batchQuerySize])
+		expectUserIdsChunk(mock, ids[batchQuerySize:])
+
+		users, err := us.GetUserByIds(append(ids, ids[0], ids[batchQuerySize]))
+		assert.NoError(t, err)
+		assert.Equal(t, len(ids), len(users))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("并发分批查询返回全部用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.queryConcurrency = 2
+		mock.MatchExpectationsInOrder(false)
+		ids := newTestUserIds(2*batchQuerySize + 500)
+		for start := 0; start < len(ids); start += batchQuerySize {
+			expectUserIdsChunk(mock, ids[start:min(start+batchQuerySize, len(ids))])
+		}
+
+		// 重复的 ID 只查询一次
+		users, err := us.GetUserByIdsWithContext(context.Background(), append(ids, ids[:100]...))
+		assert.NoError(t, err)
+		assert.Equal(t, len(ids), len(users))
+		found := make(map[string]struct{}, len(users))
+		for _, user := range users {
+			found[user.ID] = struct{}{}
+		}
+		for _, id := range ids {
+			_, ok := found[id]
+			assert.True(t, ok, id)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("任意分批失败时返回错误", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.queryConcurrency = 2
+		mock.MatchExpectationsInOrder(false)
+		ids := newTestUserIds(2 * batchQuerySize)
+		expectUserIdsChunk(mock, ids[:batchQuerySize])
+		mock.ExpectQuery(`FROM user u +WHERE u.flag = 0 AND u.id IN \(`).
+			WillReturnError(errors.New("mock error"))
+
+		_, err := us.GetUserByIdsWithContext(context.Background(), ids)
+		assert.Error(t, err)
+	})
+
+	t.Run("ctx已经取消时不再查询", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		us.queryConcurrency = 2
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := us.GetUserByIdsWithContext(ctx, newTestUserIds(2*batchQuerySize))
+		assert.ErrorContains(t, err, context.Canceled.Error())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("token_enable为NULL时按照启用处理", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		rows := sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+			"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+			"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+			AddRow("u1", "u1", "", "owner", "", "Polaris", "", 0, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0).
+			AddRow("u2", "u2", "", "owner", "", "Polaris", "", nil, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0).
+			AddRow("u3", "u3", "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "", 0, 0, 0, 0)
+		mock.ExpectQuery(`FROM user u +WHERE u.flag = 0 AND u.id IN \(`).
+			WithArgs("u1", "u2", "u3").
+			WillReturnRows(rows)
+
+		users, err := us.GetUserByIds([]string{"u1", "u2", "u3"})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(users))
+		enabled := make(map[string]bool, len(users))
+		for _, user := range users {
+			enabled[user.ID] = user.TokenEnable
+		}
+		assert.Equal(t, map[string]bool{"u1": false, "u2": true, "u3": true}, enabled)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Benchmark_userStore_GetUserByIds(b *testing.B) {
+	ids := newTestUserIds(8 * batchQuerySize)
+	for _, concurrency := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() {
+				_ = db.Close()
+			}()
+			mock.MatchExpectationsInOrder(false)
+			baseDB := &BaseDB{DB: db}
+			us := &userStore{master: baseDB, slave: baseDB, queryConcurrency: concurrency}
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for start := 0; start < len(ids); start += batchQuerySize {
+					// 模拟数据库的查询耗时
+					expectUserIdsChunk(mock, ids[start:start+batchQuerySize]).WillDelayFor(5 * time.Millisecond)
+				}
+				b.StartTimer()
+				if _, err := us.GetUserByIds(ids); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newLockOrderDB 返回为每条语句创建 span 的 BaseDB，通过 span 记录的语句校验事务内的加锁顺序
+func newLockOrderDB(t *testing.T) (*BaseDB, sqlmock.Sqlmock, *fakeTracer) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	tracer := &fakeTracer{}
+	baseDB := &BaseDB{DB: db}
+	return baseDB.withTrace(startTraceSpan(context.Background(), tracer, "LockOrder")), mock, tracer
+}
+
+var (
+	lockWriteStatement  = regexp.MustCompile(`^(?:UPDATE|DELETE FROM|INSERT (?:IGNORE )?INTO|REPLACE INTO) (\w+)`)
+	lockSelectStatement = regexp.MustCompile(`^SELECT .*? FROM (\w+) .*FOR UPDATE$`)
+)
+
+// assertLockOrder 校验每次事务尝试中加锁的表都没有违反 authLockOrder
+func assertLockOrder(t *testing.T, tracer *fakeTracer) {
+	rank := make(map[string]int, len(authLockOrder))
+	for i, table := range authLockOrder {
+		rank[table] = i
+	}
+
+	last := -1
+	for _, span := range tracer.spans {
+		if span.name == "mysql.Begin" {
+			last = -1
+			continue
+		}
+		statement := strings.TrimSpace(span.attrs[traceAttrStatement])
+		matches := lockWriteStatement.FindStringSubmatch(statement)
+		if matches == nil {
+			matches = lockSelectStatement.FindStringSubmatch(statement)
+		}
+		if matches == nil {
+			continue
+		}
+		current, ok := rank[matches[1]]
+		if !assert.True(t, ok, "table %s is not in authLockOrder", matches[1]) {
+			continue
+		}
+		assert.GreaterOrEqual(t, current, last, "statement %s violates authLockOrder", statement)
+		last = current
+	}
+}
+
+func Test_userStore_DeleteUserLockOrder(t *testing.T) {
+	userDB, userMock, userTracer := newLockOrderDB(t)
+	us := &userStore{master: userDB, slave: userDB}
+	// 第一次删除在清理默认策略时发生死锁，由 RetryTransaction 重试后成功
+	for _, deadlock := range []bool{true, false} {
+		userMock.ExpectBegin()
+		userMock.ExpectExec(`UPDATE user SET flag = 1`).WithArgs("", "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+		userMock.ExpectQuery(`SELECT group_id FROM user_group_relation WHERE user_id IN \(\?\) AND flag = 0 FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows([]string{"group_id"}).AddRow("g1").AddRow("g2"))
+		userMock.ExpectExec(`UPDATE user_group_relation SET flag = 1`).WillReturnResult(sqlmock.NewResult(0, 2))
+		userMock.ExpectExec(`UPDATE user_group SET mtime = sysdate\(\) WHERE id IN \(\?,\?\)`).
+			WithArgs("g1", "g2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		if deadlock {
+			userMock.ExpectExec(`UPDATE auth_strategy AS ag`).
+				WillReturnError(errors.New("Error 1213: Deadlock found when trying to get lock"))
+			userMock.ExpectRollback()
+			continue
+		}
+		userMock.ExpectExec(`UPDATE auth_strategy AS ag`).WillReturnResult(sqlmock.NewResult(0, 1))
+		userMock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		userMock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+		userMock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		userMock.ExpectCommit()
+	}
+
+	updateGroupDB, updateGroupMock, updateGroupTracer := newLockOrderDB(t)
+	updateGroup := &groupStore{master: updateGroupDB, slave: updateGroupDB}
+	updateGroupMock.ExpectBegin()
+	updateGroupMock.ExpectExec(`INSERT INTO user_group_relation`).WithArgs("g1", "u2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	updateGroupMock.ExpectExec(`UPDATE user_group_relation SET flag = 1`).WithArgs("g1", "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	updateGroupMock.ExpectExec(`UPDATE user_group SET token = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	updateGroupMock.ExpectCommit()
+
+	deleteGroupDB, deleteGroupMock, deleteGroupTracer := newLockOrderDB(t)
+	deleteGroup := &groupStore{master: deleteGroupDB, slave: deleteGroupDB}
+	deleteGroupMock.ExpectBegin()
+	deleteGroupMock.ExpectExec(`UPDATE user_group_relation SET flag = 1`).WithArgs("g2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	deleteGroupMock.ExpectExec(`UPDATE user_group SET flag = 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	deleteGroupMock.ExpectExec(`UPDATE auth_strategy AS ag`).WillReturnResult(sqlmock.NewResult(0, 1))
+	deleteGroupMock.ExpectExec(`UPDATE auth_strategy SET mtime = sysdate\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+	deleteGroupMock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+	deleteGroupMock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+	deleteGroupMock.ExpectCommit()
+
+	strategyDB, strategyMock, strategyTracer := newLockOrderDB(t)
+	ss := &strategyStore{master: strategyDB, slave: strategyDB}
+	strategyMock.ExpectBegin()
+	strategyMock.ExpectExec(`UPDATE auth_strategy SET action = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	strategyMock.ExpectExec(`REPLACE INTO auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+	strategyMock.ExpectExec(`DELETE FROM auth_strategy_resource`).WillReturnResult(sqlmock.NewResult(0, 1))
+	strategyMock.ExpectExec(`INSERT IGNORE INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+	strategyMock.ExpectExec(`DELETE FROM auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+	strategyMock.ExpectCommit()
+
+	var wg sync.WaitGroup
+	run := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, f())
+		}()
+	}
+	run(func() error {
+		return us.DeleteUser(&model.User{ID: "u1", Name: "user-1", Owner: "owner"})
+	})
+	run(func() error {
+		return updateGroup.UpdateGroup(&model.ModifyUserGroup{ID: "g1", AddUserIds: []string{"u2"},
+			RemoveUserIds: []string{"u1"}})
+	})
+	run(func() error {
+		return deleteGroup.DeleteGroup(&model.UserGroupDetail{UserGroup: &model.UserGroup{ID: "g2", Name: "group-2",
+			Owner: "owner"}})
+	})
+	run(func() error {
+		return ss.UpdateStrategy(&model.ModifyStrategyDetail{
+			ID:              "s1",
+			AddResources:    []model.StrategyResource{{StrategyID: "s1", ResType: 0, ResID: "ns1"}},
+			RemoveResources: []model.StrategyResource{{StrategyID: "s1", ResType: 0, ResID: "ns2"}},
+			AddPrincipals:   []model.Principal{{PrincipalID: "u2", PrincipalRole: model.PrincipalUser}},
+			RemovePrincipals: []model.Principal{
+				{PrincipalID: "u1", PrincipalRole: model.PrincipalUser},
+			},
+		})
+	})
+	wg.Wait()
+
+	for _, item := range []struct {
+		mock   sqlmock.Sqlmock
+		tracer *fakeTracer
+	}{
+		{userMock, userTracer},
+		{updateGroupMock, updateGroupTracer},
+		{deleteGroupMock, deleteGroupTracer},
+		{strategyMock, strategyTracer},
+	} {
+		assert.NoError(t, item.mock.ExpectationsWereMet())
+		assertLockOrder(t, item.tracer)
+	}
+}
+
+func Test_userStore_GetUserIfModified(t *testing.T) {
+	columns := []string{"id", "name", "password", "owner", "comment", "source",
+		"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time",
+		"password_set_time", "must_change_password", "deleted_at", "created_by", "token_rotated_time"}
+	expectGetUser := func(mock sqlmock.Sqlmock, comment string, lastLogin int64) {
+		mock.ExpectQuery(`SELECT u.id, u.name, u.password, u.owner, u.comment, u.source, u.token`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("u1", "u1", "p", "polaris", comment, "Polaris", "t", 1, 20, 1600000000, 1600000000, 0,
+					"", "", lastLogin, 0, 0, 0, "", 0))
+	}
+
+	us, mock := newTestUserStore(t)
+	expectGetUser(mock, "", 0)
+	user, err := us.GetUser("u1")
+	assert.NoError(t, err)
+	etag := user.Revision()
+
+	t.Run("用户未变化时返回NotModified", func(t *testing.T) {
+		// 登录时间不属于用户资料，不影响版本号
+		expectGetUser(mock, "", 1600000000)
+		ret, err := us.GetUserIfModified("u1", etag)
+		assert.Equal(t, store.NotModified, store.Code(err))
+		assert.Nil(t, ret)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户变化后返回最新的用户", func(t *testing.T) {
+		expectGetUser(mock, "changed", 0)
+		ret, err := us.GetUserIfModified("u1", etag)
+		assert.NoError(t, err)
+		assert.Equal(t, "changed", ret.Comment)
+		assert.NotEqual(t, etag, ret.Revision())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT u.id, u.name, u.password`).WithArgs("u1").WillReturnError(sql.ErrNoRows)
+		ret, err := us.GetUserIfModified("u1", etag)
+		assert.NoError(t, err)
+		assert.Nil(t, ret)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_CreatedBy(t *testing.T) {
+	t.Run("写入用户时记录创建者", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectExec(`delete from user where name = \? and owner = \? and flag = 1`).
+			WithArgs("alice", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO user\(.*, .created_by., +.modified_by., .token_rotated_time.\)`).
+			WithArgs("u1", "alice", "alice", "p", "owner", "", "t", "", 0, model.SubAccountUserRole, "", "", 0,
+				"admin", "admin").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 1`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT id FROM auth_strategy WHERE name = \? AND owner = \? AND flag = 0`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`INSERT INTO auth_strategy`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO auth_principal`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.AddUser(&model.User{ID: "u1", Name: "alice", Owner: "owner", Token: "t", Password: "p",
+			Type: model.SubAccountUserRole, CreatedBy: "admin"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询用户时返回创建者", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`IFNULL\(UNIX_TIMESTAMP\(u.deleted_at\), 0\), u.created_by,`).
+			WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email", "last_login_time",
+				"password_set_time", "must_change_password", "deleted_at", "created_by", "token_rotated_time"}).
+				AddRow("u1", "alice", "p", "owner", "", "Polaris", "t", 1, 50, 1600000000, 1600000000, 0,
+					"", "", 0, 0, 0, 0, "admin", 0))
+
+		user, err := us.GetUser("u1")
+		assert.NoError(t, err)
+		assert.Equal(t, "admin", user.CreatedBy)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("按照创建者过滤用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +AND created_by = \?`).
+			WithArgs("admin").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`AND created_by = \? +ORDER BY mtime`).
+			WithArgs("admin", 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+				"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+				AddRow("u1", "alice", "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1600000000, 0, "", "",
+					0, 0, 0, 0))
+
+		total, users, err := us.GetUsers(map[string]string{"created_by": "admin"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, "u1", users[0].ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_ModifiedBy(t *testing.T) {
+	t.Run("更新用户时记录修改者", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`mtime = sysdate\(\), modified_by = \? WHERE id = \? AND flag = 0`).
+			WithArgs("p", "p", "t", "t", "p", "t", "", 1, "", "", "admin", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.UpdateUser(&model.User{ID: "u1", Name: "u1", Token: "t", Password: "p",
+			TokenEnable: true, ModifiedBy: "admin"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("批量修改备注时记录修改者", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user SET comment = \?, mtime = sysdate\(\), modified_by = \? WHERE flag = 0`).
+			WithArgs("c", "admin", "c", "u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		_, err := us.SetUsersComment([]string{"u1"}, "c", "admin")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("按照修改者以及修改时间过滤用户", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		cond := `AND modified_by = \? +AND mtime >= FROM_UNIXTIME\(\?\) +AND mtime < FROM_UNIXTIME\(\?\)`
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user WHERE flag = 0 +`+cond).
+			WithArgs("admin", 1700000000, 1700003600).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(cond+` +ORDER BY mtime`).
+			WithArgs("admin", 1700000000, 1700003600, 0, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password", "owner", "comment", "source",
+				"token", "token_enable", "user_type", "ctime", "mtime", "flag", "mobile", "email",
+				"last_login_time", "password_set_time", "must_change_password", "deleted_at"}).
+				AddRow("u1", "alice", "", "owner", "", "Polaris", "", 1, 50, 1600000000, 1700001800, 0, "", "",
+					0, 0, 0, 0))
+
+		total, users, err := us.GetUsers(map[string]string{"modified_by": "admin",
+			"modified_after": "1700000000", "modified_before": "1700003600"}, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), total)
+		assert.Equal(t, "u1", users[0].ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("修改时间范围非法", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		_, _, err := us.GetUsers(map[string]string{"modified_after": "1700003600",
+			"modified_before": "1700000000"}, 0, 10)
+		assert.Equal(t, store.OutOfRangeErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_userStore_UserToken(t *testing.T) {
+	columns := []string{"id", "user_id", "name", "token", "scope", "expire_time", "ctime", "mtime"}
+
+	t.Run("创建附加token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		expire := time.Now().Add(time.Hour)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM user WHERE id = \? AND flag = 0 FOR UPDATE`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("u1"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_token WHERE user_id = \? AND name = \? AND flag = 0`).
+			WithArgs("u1", "ci").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`INSERT INTO user_token.* VALUES \(\?, \?, \?, \?, \?, FROM_UNIXTIME\(\?\), 0`).
+			WithArgs("t1", "u1", "ci", "value", "read_only", expire.Unix()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, us.AddUserToken(&model.UserToken{ID: "t1", UserID: "u1", Name: "ci", Token: "value",
+			Scope: model.UserTokenReadOnly, ExpireTime: expire}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("附加token名称重复", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM user WHERE id = \? AND flag = 0 FOR UPDATE`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("u1"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_token`).
+			WithArgs("u1", "ci").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		err := us.AddUserToken(&model.UserToken{ID: "t1", UserID: "u1", Name: "ci", Token: "value",
+			Scope: model.UserTokenReadWrite})
+		assert.Equal(t, store.DuplicateEntryErr, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM user WHERE id = \? AND flag = 0 FOR UPDATE`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		err := us.AddUserToken(&model.UserToken{ID: "t1", UserID: "u1", Name: "ci", Token: "value",
+			Scope: model.UserTokenReadWrite})
+		assert.Equal(t, store.NotFoundUser, store.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("查询附加token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		now := time.Now().Unix()
+		mock.ExpectQuery(`FROM user_token WHERE id = \? AND flag = 0`).WithArgs("t1").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("t1", "u1", "ci", "value", "read_only", 0, now, now))
+		mock.ExpectQuery(`FROM user_token WHERE user_id = \? AND flag = 0 ORDER BY ctime, id`).WithArgs("u1").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("t1", "u1", "ci", "value", "read_only", now+60, now, now))
+
+		ret, err := us.GetUserTokenByID("t1")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", ret.Token)
+		assert.True(t, ret.Valid)
+		assert.True(t, ret.ExpireTime.IsZero())
+		assert.Equal(t, model.UserTokenReadOnly, ret.Scope)
+
+		list, err := us.ListUserTokens("u1")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(list))
+		assert.Empty(t, list[0].Token)
+		assert.Equal(t, now+60, list[0].ExpireTime.Unix())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("撤销不存在的附加token", func(t *testing.T) {
+		us, mock := newTestUserStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE user_token SET flag = 1, mtime = sysdate\(\) WHERE id = \? AND user_id = \? AND flag = 0`).
+			WithArgs("t1", "u1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		assert.Equal(t, store.NotFoundResource, store.Code(us.RevokeUserToken("u1", "t1")))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,198 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/polarismesh/polaris/common/model"
+	"github.com/polarismesh/polaris/store"
+)
+
+const userTokenColumns = "id, user_id, name, token, scope, IFNULL(UNIX_TIMESTAMP(expire_time), 0), " +
+	"UNIX_TIMESTAMP(ctime), UNIX_TIMESTAMP(mtime)"
+
+// AddUserToken 为有效用户创建附加 token，同一个用户有效的附加 token 之间名称不能重复
+func (u *userStore) AddUserToken(token *model.UserToken) (err error) {
+	u, span := u.traceOp(context.Background(), "AddUserToken")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if token.ID == "" || token.UserID == "" || token.Name == "" || token.Token == "" || token.Scope == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"add user token missing some params, id is %s, user is %s, name is %s", token.ID, token.UserID, token.Name))
+	}
+	encrypted, err := u.tokenCipher.Encrypt(token.Token)
+	if err != nil {
+		log.Error("[Store][User] encrypt user token", zap.String("id", token.ID), zap.Error(err))
+		return store.Error(err)
+	}
+
+	err = u.writeTransaction("addUserToken", func(tx *BaseTx) error {
+		var userId string
+		lockSql := "SELECT id FROM user WHERE id = ? AND flag = 0 FOR UPDATE"
+		if err := tx.QueryRow(lockSql, token.UserID).Scan(&userId); err != nil {
+			if err == sql.ErrNoRows {
+				return store.NewStatusError(store.NotFoundUser, fmt.Sprintf("user(%s) not found", token.UserID))
+			}
+			return err
+		}
+
+		var count uint32
+		countSql := "SELECT COUNT(*) FROM user_token WHERE user_id = ? AND name = ? AND flag = 0"
+		if err := tx.QueryRow(countSql, token.UserID, token.Name).Scan(&count); err != nil {
+			return err
+		}
+		if count != 0 {
+			return store.NewStatusError(store.DuplicateEntryErr, fmt.Sprintf(
+				"user token name(%s) existed in user(%s)", token.Name, token.UserID))
+		}
+
+		expireTime, args := "NULL", []interface{}{token.ID, token.UserID, token.Name, encrypted, string(token.Scope)}
+		if !token.ExpireTime.IsZero() {
+			expireTime = "FROM_UNIXTIME(?)"
+			args = append(args, timeToTimestamp(token.ExpireTime))
+		}
+		addSql := "INSERT INTO user_token(id, user_id, name, token, scope, expire_time, flag, ctime, mtime) " +
+			" VALUES (?, ?, ?, ?, ?, " + expireTime + ", 0, sysdate(), sysdate())"
+		if _, err := tx.Exec(addSql, args...); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Error("[Store][User] add user token", zap.String("id", token.ID), zap.String("user", token.UserID),
+			zap.Error(err))
+		return store.Error(err)
+	}
+	logUserOp("AddUserToken", "[Store][User] add user token", zap.String("id", token.ID),
+		zap.String("user", token.UserID), zap.String("scope", string(token.Scope)))
+	return nil
+}
+
+// GetUserTokenByID 根据 ID 获取有效的附加 token，用于 token 校验，因此始终读主库，避免撤销后只读库仍然可以读到
+func (u *userStore) GetUserTokenByID(id string) (_ *model.UserToken, err error) {
+	u, span := u.traceOp(context.Background(), "GetUserTokenByID")
+	defer func() { span.finish(err) }()
+
+	if id == "" {
+		return nil, store.NewStatusError(store.EmptyParamsErr, "get user token missing id")
+	}
+	querySql := "SELECT " + userTokenColumns + " FROM user_token WHERE id = ? AND flag = 0"
+	tokens, err := u.collectUserTokens(u.master.Query, querySql, id)
+	if err != nil {
+		log.Error("[Store][User] get user token", zap.String("id", id), zap.Error(err))
+		return nil, store.Error(err)
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return tokens[0], nil
+}
+
+// ListUserTokens 按照创建时间查询用户有效的附加 token，不返回 token 的内容
+func (u *userStore) ListUserTokens(userId string) (_ []*model.UserToken, err error) {
+	u, span := u.traceOp(context.Background(), "ListUserTokens")
+	defer func() { span.finish(err) }()
+
+	if userId == "" {
+		return nil, store.NewStatusError(store.EmptyParamsErr, "list user tokens missing user id")
+	}
+	querySql := "SELECT " + userTokenColumns + " FROM user_token WHERE user_id = ? AND flag = 0 ORDER BY ctime, id"
+	tokens, err := u.collectUserTokens(u.readDB().Query, querySql, userId)
+	if err != nil {
+		log.Error("[Store][User] list user tokens", zap.String("user", userId), zap.Error(err))
+		return nil, store.Error(err)
+	}
+	for _, token := range tokens {
+		token.Token = ""
+	}
+	return tokens, nil
+}
+
+// RevokeUserToken 撤销用户有效的附加 token，token 不存在、已经被撤销或者不属于该用户时返回 NotFoundResource
+func (u *userStore) RevokeUserToken(userId, id string) (err error) {
+	u, span := u.traceOp(context.Background(), "RevokeUserToken")
+	defer func() { span.finish(err) }()
+
+	defer u.consistency.markWrite()
+
+	if userId == "" || id == "" {
+		return store.NewStatusError(store.EmptyParamsErr, fmt.Sprintf(
+			"revoke user token missing some params, id is %s, user is %s", id, userId))
+	}
+	err = u.writeTransaction("revokeUserToken", func(tx *BaseTx) error {
+		result, err := tx.Exec("UPDATE user_token SET flag = 1, mtime = sysdate() WHERE id = ? AND user_id = ? AND flag = 0",
+			id, userId)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return store.NewStatusError(store.NotFoundResource, fmt.Sprintf(
+				"token(%s) of user(%s) not found", id, userId))
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Error("[Store][User] revoke user token", zap.String("id", id), zap.String("user", userId), zap.Error(err))
+		return store.Error(err)
+	}
+	logUserOp("RevokeUserToken", "[Store][User] revoke user token", zap.String("id", id), zap.String("user", userId))
+	return nil
+}
+
+func (u *userStore) collectUserTokens(handler QueryHandler, querySql string,
+	args ...interface{}) ([]*model.UserToken, error) {
+	rows, err := handler(querySql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	tokens := make([]*model.UserToken, 0, 4)
+	for rows.Next() {
+		var (
+			token                = &model.UserToken{Valid: true}
+			scope                string
+			expire, ctime, mtime int64
+		)
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Token, &scope, &expire,
+			&ctime, &mtime); err != nil {
+			return nil, err
+		}
+		if token.Token, err = u.tokenCipher.Decrypt(token.Token); err != nil {
+			return nil, err
+		}
+		token.Scope = model.UserTokenScope(scope)
+		token.ExpireTime = unixToOptionalTime(expire)
+		token.CreateTime = time.Unix(ctime, 0)
+		token.ModifyTime = time.Unix(mtime, 0)
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
@@ -17,6 +17,11 @@
 
 package store
 
+import (
+	"fmt"
+	"strings"
+)
+
 // InstanceArgs 用于通过服务实例查询服务的参数
 type InstanceArgs struct {
 	Hosts []string
@@ -30,3 +35,103 @@ type InstanceMetadataRequest struct {
 	Keys       []string
 	Metadata   map[string]string
 }
+
+const (
+	// OrderFieldAttribute 排序字段
+	OrderFieldAttribute = "order_field"
+	// OrderTypeAttribute 排序方式，asc 或者 desc
+	OrderTypeAttribute = "order_type"
+	// OrderCollationAttribute 按名称排序时使用的字符集排序规则
+	OrderCollationAttribute = "order_collation"
+)
+
+// MaxRecentlyModifiedUsers GetRecentlyModifiedUsers 单次最多返回的用户数量
+const MaxRecentlyModifiedUsers uint32 = 100
+
+const (
+	// DefaultStrategyConflictReject 同名的默认策略属于其他 principal 时返回 DataConflictErr
+	DefaultStrategyConflictReject = "reject"
+	// DefaultStrategyConflictReuse 同名的默认策略属于其他 principal 时，将当前 principal 也关联到该策略上
+	DefaultStrategyConflictReuse = "reuse"
+)
+
+// ParseDefaultStrategyConflict 解析存储配置中的 defaultStrategyConflict，返回是否复用其他 principal 的同名默认策略
+func ParseDefaultStrategyConflict(opt interface{}) (bool, error) {
+	val, _ := opt.(string)
+	switch val {
+	case "", DefaultStrategyConflictReject:
+		return false, nil
+	case DefaultStrategyConflictReuse:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid defaultStrategyConflict value: %v", opt)
+	}
+}
+
+// userOrderCollations 允许使用的排序规则，排序规则会直接拼接到 SQL 中，必须经过白名单校验
+var userOrderCollations = map[string]bool{
+	"utf8mb4_bin":        true,
+	"utf8mb4_general_ci": true,
+	"utf8mb4_unicode_ci": true,
+	"utf8mb4_0900_ai_ci": true,
+}
+
+// UserOrder 用户列表的排序参数
+type UserOrder struct {
+	// Field 排序字段，支持 name、mtime
+	Field string
+	// Desc 是否倒序
+	Desc bool
+	// Collation 按 name 排序时使用的排序规则，为空时使用字段本身的排序规则
+	Collation string
+}
+
+// CaseInsensitive 排序规则是否忽略大小写
+func (o *UserOrder) CaseInsensitive() bool {
+	return strings.HasSuffix(o.Collation, "_ci")
+}
+
+// ParseUserOrder 从查询参数中解析用户列表的排序参数，解析过的参数会从 filters 中删除
+// 未指定任何排序参数时返回 nil，由存储层使用默认的排序方式
+func ParseUserOrder(filters map[string]string) (*UserOrder, error) {
+	field, hasField := filters[OrderFieldAttribute]
+	orderType, hasType := filters[OrderTypeAttribute]
+	collation, hasCollation := filters[OrderCollationAttribute]
+	delete(filters, OrderFieldAttribute)
+	delete(filters, OrderTypeAttribute)
+	delete(filters, OrderCollationAttribute)
+
+	if !hasField && !hasType && !hasCollation {
+		return nil, nil
+	}
+
+	order := &UserOrder{Field: strings.ToLower(field), Collation: collation}
+	if order.Field == "" {
+		order.Field = "mtime"
+		if hasCollation {
+			order.Field = "name"
+		}
+	}
+	if order.Field != "name" && order.Field != "mtime" {
+		return nil, NewStatusError(OutOfRangeErr, fmt.Sprintf("invalid %s value: %s", OrderFieldAttribute, field))
+	}
+
+	switch strings.ToLower(orderType) {
+	case "", "asc":
+	case "desc":
+		order.Desc = true
+	default:
+		return nil, NewStatusError(OutOfRangeErr, fmt.Sprintf("invalid %s value: %s", OrderTypeAttribute, orderType))
+	}
+
+	if hasCollation {
+		if order.Field != "name" {
+			return nil, NewStatusError(OutOfRangeErr, "order_collation only support order by name")
+		}
+		if !userOrderCollations[collation] {
+			return nil, NewStatusError(OutOfRangeErr,
+				fmt.Sprintf("invalid %s value: %s", OrderCollationAttribute, collation))
+		}
+	}
+	return order, nil
+}
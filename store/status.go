@@ -18,6 +18,8 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"strings"
 )
 
@@ -67,6 +69,20 @@ const (
 	// 非法的用户ID列表
 	InvalidUserIDSlice
 	NotFoundResource
+	// 查询超时，包括 context 超时以及 MySQL 的 max_execution_time 超时，可以稍后重试
+	Timeout
+	// 参数取值明显不合理，比如分页查询的 offset 过大
+	InvalidParameter
+	// 未设置任何过滤条件的查询被拦截，需要补充过滤条件或者减小分页大小
+	UnfilteredQueryErr
+	// 用户的 owner 与用户类型不匹配，比如主账户的 owner 为其他账户或者子账户的 owner 为自身
+	InvalidUserOwner
+	// 条件查询时数据没有发生变化，比如用户的版本号与客户端持有的 ETag 一致
+	NotModified
+	// 距离上一次修改密码的时间未达到密码的最短使用时间
+	PasswordTooRecent
+	// 同时执行的写事务个数达到上限，可以稍后重试
+	Busy
 )
 
 // Error 普通error转StatusError
@@ -81,7 +97,11 @@ func Error(err error) error {
 	}
 
 	s := &StatusError{message: err.Error()}
-	if strings.Contains(s.message, "Data too long") {
+	if errors.Is(err, context.DeadlineExceeded) ||
+		strings.Contains(s.message, "maximum statement execution time exceeded") {
+		// MySQL 3024: Query execution was interrupted, maximum statement execution time exceeded
+		s.code = Timeout
+	} else if strings.Contains(s.message, "Data too long") {
 		s.code = OutOfRangeErr
 	} else if strings.Contains(s.message, "Duplicate entry") {
 		s.code = DuplicateEntryErr
@@ -0,0 +1,96 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/polarismesh/polaris/common/model"
+)
+
+// TxStore 绑定在同一个事务上的存储操作，只在 Store.WithTx 的回调中有效
+type TxStore interface {
+	// Tx 当前的事务对象，可以传给其他存储的 XxxTx(tx Tx, ...) 方法，实现跨存储的原子操作
+	Tx() Tx
+	// AddUser Create a user
+	AddUser(user *model.User) error
+	// UpdateUser Update user
+	UpdateUser(user *model.User) error
+	// DeleteUser delete users
+	DeleteUser(user *model.User) error
+	// GetUser Obtain user
+	GetUser(id string) (*model.User, error)
+}
+
+// NewTxStore 将 UserStore 的事务方法绑定到 tx 上
+func NewTxStore(tx Tx, users UserStore) TxStore {
+	return &txStore{tx: tx, users: users}
+}
+
+type txStore struct {
+	tx    Tx
+	users UserStore
+}
+
+func (t *txStore) Tx() Tx {
+	return t.tx
+}
+
+func (t *txStore) AddUser(user *model.User) error {
+	return t.users.AddUserTx(t.tx, user)
+}
+
+func (t *txStore) UpdateUser(user *model.User) error {
+	return t.users.UpdateUserTx(t.tx, user)
+}
+
+func (t *txStore) DeleteUser(user *model.User) error {
+	return t.users.DeleteUserTx(t.tx, user)
+}
+
+func (t *txStore) GetUser(id string) (*model.User, error) {
+	return t.users.GetUserTx(t.tx, id)
+}
+
+// RunWithTx 供各存储插件实现 WithTx 使用，fn 返回 error 或者 panic 时回滚 tx，否则提交 tx
+func RunWithTx(ctx context.Context, tx Tx, txStore TxStore, fn func(TxStore) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback()
+			panic(r)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = fn(txStore); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
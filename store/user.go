@@ -0,0 +1,225 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/polarismesh/polaris/common/model"
+)
+
+// MaxUserCommentLength 用户备注的最大字符数，与 user 表 comment 字段的长度一致
+const MaxUserCommentLength = 255
+
+// CheckUserComment 检查用户备注的长度，超出时返回 OutOfRangeErr
+func CheckUserComment(comment string) error {
+	if utf8.RuneCountInString(comment) > MaxUserCommentLength {
+		return NewStatusError(OutOfRangeErr,
+			fmt.Sprintf("user comment exceeds the max length %d", MaxUserCommentLength))
+	}
+	return nil
+}
+
+// CheckUserOwner 检查用户的 owner 与用户类型是否一致，不一致时返回 InvalidUserOwner
+// 超级管理员以及主账户的 owner 为空或者为自身，子账户的 owner 为所属的主账户，不能为空也不能为自身
+func CheckUserOwner(user *model.User) error {
+	if user.Type == model.SubAccountUserRole {
+		if user.Owner == "" || user.Owner == user.ID {
+			return NewStatusError(InvalidUserOwner, fmt.Sprintf(
+				"owner of sub account(%s) must be another main account, but got %q", user.ID, user.Owner))
+		}
+		return nil
+	}
+	if user.Owner != "" && user.Owner != user.ID {
+		return NewStatusError(InvalidUserOwner, fmt.Sprintf(
+			"owner of %s account(%s) must be empty or itself, but got %q", model.UserRoleNames[user.Type],
+			user.ID, user.Owner))
+	}
+	return nil
+}
+
+// CheckUserModified 用户的版本号与 etag 一致时返回 NotModified，用户不存在或者 etag 为空时视为已变化
+func CheckUserModified(user *model.User, etag string) error {
+	if user != nil && etag != "" && user.Revision() == etag {
+		return NewStatusError(NotModified, fmt.Sprintf("user(%s) not modified", user.ID))
+	}
+	return nil
+}
+
+// PasswordAgePolicy 密码的最短使用时间，避免短时间内连续修改密码绕过历史密码的校验，零值不做任何限制
+type PasswordAgePolicy struct {
+	// MinAge 两次修改密码之间的最短间隔，为 0 时不限制
+	MinAge time.Duration
+	// AdminBypass 超级管理员发起的修改不受限制
+	AdminBypass bool
+}
+
+// ParsePasswordAgePolicy 解析存储配置中的 userMinPasswordAge（秒）以及 userMinPasswordAgeAdminBypass
+func ParsePasswordAgePolicy(option map[string]interface{}) PasswordAgePolicy {
+	seconds, _ := option["userMinPasswordAge"].(int)
+	bypass, _ := option["userMinPasswordAgeAdminBypass"].(bool)
+	if seconds <= 0 {
+		return PasswordAgePolicy{}
+	}
+	return PasswordAgePolicy{MinAge: time.Duration(seconds) * time.Second, AdminBypass: bypass}
+}
+
+// Check 修改用户密码前检查距离上一次修改密码是否已经超过 MinAge，未超过时返回 PasswordTooRecent
+// saved 为修改前的用户，密码修改时间未知或者用户被标记为必须修改密码时不做限制
+func (p PasswordAgePolicy) Check(saved *model.User, adminOperated bool, now time.Time) error {
+	if p.MinAge <= 0 || (p.AdminBypass && adminOperated) {
+		return nil
+	}
+	if saved.MustChangePassword || saved.PasswordSetTime.IsZero() {
+		return nil
+	}
+	if elapsed := now.Sub(saved.PasswordSetTime); elapsed < p.MinAge {
+		return NewStatusError(PasswordTooRecent, fmt.Sprintf(
+			"password of user(%s) was changed %s ago, it can be changed again after %s", saved.ID,
+			elapsed.Truncate(time.Second), p.MinAge))
+	}
+	return nil
+}
+
+// TokenEntropyBits 按照 token 中各字符的出现频率估算 token 的香农熵（比特），即单字符的熵乘以 token 的字符数
+func TokenEntropyBits(token string) float64 {
+	counts := make(map[rune]int, len(token))
+	total := 0
+	for _, r := range token {
+		counts[r]++
+		total++
+	}
+	var bits float64
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		bits -= p * math.Log2(p)
+	}
+	return bits * float64(total)
+}
+
+// DuplicateTokenGroups 将 token 相同的用户分组，userTokens 为用户 ID 到明文 token 的映射，空 token 不参与比较
+// 只返回包含两个及以上用户的分组，分组内以及分组之间均按照用户 ID 排序
+func DuplicateTokenGroups(userTokens map[string]string) [][]string {
+	byToken := make(map[string][]string, len(userTokens))
+	for id, token := range userTokens {
+		if token == "" {
+			continue
+		}
+		byToken[token] = append(byToken[token], id)
+	}
+	return duplicateGroups(byToken)
+}
+
+// BatchItemResult 尽力而为的批量操作中单个用户的处理结果，Err 为 nil 时表示处理成功
+type BatchItemResult struct {
+	ID  string
+	Err error
+}
+
+// BestEffortUsers 逐个对用户执行 handle，某个用户失败时继续处理后续的用户
+// 返回的结果与 users 一一对应，以及失败的用户数量
+func BestEffortUsers(users []*model.User, handle func(user *model.User) error) ([]BatchItemResult, int) {
+	results := make([]BatchItemResult, 0, len(users))
+	failed := 0
+	for _, user := range users {
+		err := handle(user)
+		if err != nil {
+			failed++
+		}
+		results = append(results, BatchItemResult{ID: user.ID, Err: err})
+	}
+	return results, failed
+}
+
+// UserNameKey 用户名称唯一性的维度，同一个 owner 下的用户名称不允许重复
+type UserNameKey struct {
+	Name  string
+	Owner string
+}
+
+// DuplicateUserNameGroups 将 name + owner 相同的用户分组，userNames 为用户 ID 到 UserNameKey 的映射
+// 只返回包含两个及以上用户的分组，分组内以及分组之间均按照用户 ID 排序
+func DuplicateUserNameGroups(userNames map[string]UserNameKey) [][]string {
+	byName := make(map[UserNameKey][]string, len(userNames))
+	for id, key := range userNames {
+		byName[key] = append(byName[key], id)
+	}
+	return duplicateGroups(byName)
+}
+
+// duplicateGroups 返回包含两个及以上用户 ID 的分组并排序
+func duplicateGroups[K comparable](byKey map[K][]string) [][]string {
+	groups := make([][]string, 0)
+	for _, ids := range byKey {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Strings(ids)
+		groups = append(groups, ids)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	return groups
+}
+
+// WeakTokenUsers 返回 token 的熵低于 minEntropyBits 的用户 ID，按照用户 ID 排序，空 token 同样视为弱 token
+func WeakTokenUsers(userTokens map[string]string, minEntropyBits float64) []string {
+	ids := make([]string, 0)
+	for id, token := range userTokens {
+		if TokenEntropyBits(token) < minEntropyBits {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// MissingDefaultStrategyUsers 返回缺少默认策略的用户 ID，按照用户 ID 排序
+// userNames 为用户 ID 到用户名的映射，linked 为用户 ID 到其关联的有效默认策略名称的映射，
+// 关联的默认策略中没有与 BuildDefaultStrategyName 一致的名称时视为缺少默认策略
+func MissingDefaultStrategyUsers(userNames map[string]string, linked map[string][]string) []string {
+	ids := make([]string, 0)
+	for id, name := range userNames {
+		expect := model.BuildDefaultStrategyName(model.PrincipalUser, name)
+		found := false
+		for _, strategyName := range linked[id] {
+			if strategyName == expect {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// CheckMinEntropyBits 检查弱 token 的熵阈值，需要为正数
+func CheckMinEntropyBits(minEntropyBits float64) error {
+	if !(minEntropyBits > 0) || math.IsInf(minEntropyBits, 1) {
+		return NewStatusError(OutOfRangeErr, fmt.Sprintf("invalid min entropy bits: %v", minEntropyBits))
+	}
+	return nil
+}
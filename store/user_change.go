@@ -0,0 +1,80 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/polarismesh/polaris/common/model"
+)
+
+// userChangePayload 用户变更记录中保存的用户快照，不包含密码以及 token
+type userChangePayload struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Owner              string `json:"owner"`
+	Source             string `json:"source"`
+	Type               int    `json:"type"`
+	Comment            string `json:"comment"`
+	TokenEnable        bool   `json:"token_enable"`
+	Valid              bool   `json:"valid"`
+	MustChangePassword bool   `json:"must_change_password"`
+	ModifyTime         int64  `json:"mtime"`
+}
+
+// EncodeUserChangePayload 生成用户变更记录的 payload
+func EncodeUserChangePayload(user *model.User) (string, error) {
+	data, err := json.Marshal(&userChangePayload{
+		ID:                 user.ID,
+		Name:               user.Name,
+		Owner:              user.Owner,
+		Source:             user.Source,
+		Type:               int(user.Type),
+		Comment:            user.Comment,
+		TokenEnable:        user.TokenEnable,
+		Valid:              user.Valid,
+		MustChangePassword: user.MustChangePassword,
+		ModifyTime:         user.ModifyTime.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// CompactUserChanges 将同一批次内同一个用户的多条变更合并为最后一条，结果仍然按照序号排序
+// 批次中序号最大的变更一定会保留，消费方依然可以使用最后一条变更的序号作为下一次读取的起点
+func CompactUserChanges(changes []*model.UserChange) []*model.UserChange {
+	if len(changes) < 2 {
+		return changes
+	}
+	latest := make(map[string]int, len(changes))
+	for i, change := range changes {
+		latest[change.UserID] = i
+	}
+	if len(latest) == len(changes) {
+		return changes
+	}
+	compacted := make([]*model.UserChange, 0, len(latest))
+	for i, change := range changes {
+		if latest[change.UserID] == i {
+			compacted = append(compacted, change)
+		}
+	}
+	return compacted
+}
@@ -0,0 +1,363 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// UserQuery 用户列表的查询条件，零值的字段表示不按该条件过滤
+type UserQuery struct {
+	// ID 用户 ID
+	ID string
+	// Name 用户名称，以 * 结尾时模糊查询，多个名称以逗号分隔时批量精确查询
+	Name string
+	// Owner 主账户 ID，查询该主账户及其直接子账户；在用户组下查询时只匹配用户的 owner
+	Owner string
+	// OwnerRecursive 按照 owner 逐级展开，查询 Owner 自身及其下整棵子账户树，在用户组下查询时不生效
+	OwnerRecursive bool
+	// Source 用户来源
+	Source string
+	// Keyword 用户名称或者备注中包含该关键字即满足条件，不区分大小写
+	Keyword string
+	// GroupID 只查询该用户组下的用户
+	GroupID string
+	// GroupName 只查询名称为该值的有效用户组下的用户，不同主账户下存在同名的用户组时返回所有同名用户组的成员，
+	// 可以通过 GroupOwner 限定用户组所属的主账户
+	GroupName string
+	// GroupOwner 查询用户组下的用户时，只允许查询属于该主账户的用户组，避免越权查看其他主账户的用户组成员
+	GroupOwner string
+	// TokenEnable 按照用户 token 是否启用过滤
+	TokenEnable *bool
+	// HasGroup 按照用户是否加入了任意一个有效的用户组过滤
+	HasGroup *bool
+	// ExcludeIDs 不返回这些 ID 的用户，为空时不过滤
+	ExcludeIDs []string
+	// HideAdmin 不返回超级管理员
+	HideAdmin bool
+	// CreatedBy 只查询由该操作者创建的用户
+	CreatedBy string
+	// CreatedAfter 只查询在该时间（含）之后创建的用户
+	CreatedAfter time.Time
+	// ModifiedBy 只查询最后一次由该操作者修改的用户
+	ModifiedBy string
+	// ModifiedAfter 只查询在该时间（含）之后最后一次修改的用户
+	ModifiedAfter time.Time
+	// ModifiedBefore 只查询在该时间之前最后一次修改的用户
+	ModifiedBefore time.Time
+	// LastLoginBefore 只查询在该时间之前最后一次登录的用户，从未登录过的用户同样返回
+	LastLoginBefore time.Time
+	// ExcludeNeverLogin 按照 LastLoginBefore 过滤时不返回从未登录过的用户
+	ExcludeNeverLogin bool
+	// TokenRotatedBefore 只查询在该时间之前最后一次更换 token 的用户，更换时间未知的用户同样返回
+	TokenRotatedBefore time.Time
+	// IncludeDeleted 同时返回已经删除的用户，查询用户组下的用户时不生效
+	IncludeDeleted bool
+	// DeletedAfter 只查询在该时间（含）之后删除的用户，仅在 IncludeDeleted 时生效
+	DeletedAfter time.Time
+	// DeletedBefore 只查询在该时间之前删除的用户，仅在 IncludeDeleted 时生效
+	DeletedBefore time.Time
+	// Order 排序参数，为空时使用默认的排序方式
+	Order *UserOrder
+	// Consistency 读一致性要求，默认为 ReadStrong
+	Consistency ReadConsistency
+}
+
+// ReadConsistency 查询对读一致性的要求，决定查询读主库还是只读库
+type ReadConsistency int
+
+const (
+	// ReadStrong 读主库，保证读到最新写入的数据
+	ReadStrong ReadConsistency = iota
+	// ReadEventual 允许读只读库，可能读到复制延迟内的旧数据，刚刚写入过时仍然读主库
+	ReadEventual
+)
+
+// ParseReadConsistency 解析读一致性参数，取值为 strong 或者 eventual
+func ParseReadConsistency(val string) (ReadConsistency, error) {
+	switch val {
+	case "strong":
+		return ReadStrong, nil
+	case "eventual":
+		return ReadEventual, nil
+	default:
+		return ReadStrong, NewStatusError(OutOfRangeErr, fmt.Sprintf("user filter consistency %s is not supported", val))
+	}
+}
+
+// maxQueryDays 按天数过滤时允许的最大天数，避免换算成时间时溢出
+const maxQueryDays = 100 * 365
+
+const (
+	// DefaultUserQueryMaxOffset 用户列表分页查询默认允许的最大 offset
+	DefaultUserQueryMaxOffset uint32 = 100000
+	// UserQueryMaxLimit 用户列表分页查询单页返回的最大条数
+	UserQueryMaxLimit uint32 = 1000
+)
+
+// CheckUserPage 校验用户列表分页查询的 offset 以及 limit，返回实际使用的 limit
+// offset 超过 maxOffset 时返回 InvalidParameter，maxOffset 为 0 时使用 DefaultUserQueryMaxOffset；
+// limit 超过 UserQueryMaxLimit 时截断为 UserQueryMaxLimit
+func CheckUserPage(offset, limit, maxOffset uint32) (uint32, error) {
+	if maxOffset == 0 {
+		maxOffset = DefaultUserQueryMaxOffset
+	}
+	if offset > maxOffset {
+		return 0, NewStatusError(InvalidParameter, fmt.Sprintf("user query offset %d exceeds %d", offset, maxOffset))
+	}
+	if limit > UserQueryMaxLimit {
+		limit = UserQueryMaxLimit
+	}
+	if uint64(offset)+uint64(limit) > math.MaxUint32 {
+		return 0, NewStatusError(InvalidParameter, fmt.Sprintf("user query offset %d with limit %d overflows",
+			offset, limit))
+	}
+	return limit, nil
+}
+
+// UserQueryGuard 未设置任何过滤条件的用户列表查询需要全表扫描并且 COUNT(*)，在用户量很大时用于拦截此类查询
+// 零值不做任何拦截
+type UserQueryGuard struct {
+	// RequireFilter 拒绝未设置任何过滤条件的查询
+	RequireFilter bool
+	// MaxUnfilteredLimit 未设置过滤条件时单页允许返回的最大条数，为 0 时不限制
+	MaxUnfilteredLimit uint32
+}
+
+// Check 查询未设置任何过滤条件并且命中拦截规则时返回 UnfilteredQueryErr
+func (g UserQueryGuard) Check(query *UserQuery, limit uint32) error {
+	if !query.IsUnfiltered() {
+		return nil
+	}
+	if g.RequireFilter {
+		return NewStatusError(UnfilteredQueryErr, "user query requires at least one filter")
+	}
+	if g.MaxUnfilteredLimit > 0 && limit > g.MaxUnfilteredLimit {
+		return NewStatusError(UnfilteredQueryErr, fmt.Sprintf("user query without filters is limited to %d users",
+			g.MaxUnfilteredLimit))
+	}
+	return nil
+}
+
+// ParseUserQuery 将 map 形式的用户查询参数转换为 UserQuery，不支持的参数以及非法的取值均返回 OutOfRangeErr
+// filters 不会被修改
+func ParseUserQuery(filters map[string]string) (*UserQuery, error) {
+	rest := make(map[string]string, len(filters))
+	for k, v := range filters {
+		rest[k] = v
+	}
+	order, err := ParseUserOrder(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		query            = &UserQuery{Order: order}
+		inactiveDays     *uint64
+		tokenRotatedDays *uint64
+	)
+	for k, v := range rest {
+		switch k {
+		case "id", "user_id":
+			query.ID = v
+		case "name", "user_name":
+			query.Name = v
+		case "owner":
+			query.Owner = v
+		case "owner_recursive":
+			query.OwnerRecursive = v == "true"
+		case "source":
+			query.Source = v
+		case "q":
+			query.Keyword = v
+		case "group_id":
+			query.GroupID = v
+		case "group_name":
+			query.GroupName = v
+		case "group_owner":
+			query.GroupOwner = v
+		case "exclude_ids":
+			query.ExcludeIDs = parseQueryIds(v)
+		case "hide_admin":
+			query.HideAdmin = v == "true"
+		case "token_enable":
+			query.TokenEnable, err = parseQueryBool(k, v)
+		case "has_group":
+			query.HasGroup, err = parseQueryBool(k, v)
+		case "created_by":
+			query.CreatedBy = v
+		case "created_after":
+			query.CreatedAfter, err = parseQueryUnix(k, v)
+		case "modified_by":
+			query.ModifiedBy = v
+		case "modified_after":
+			query.ModifiedAfter, err = parseQueryUnix(k, v)
+		case "modified_before":
+			query.ModifiedBefore, err = parseQueryUnix(k, v)
+		case "last_login_before":
+			query.LastLoginBefore, err = parseQueryUnix(k, v)
+		case "inactive_since":
+			inactiveDays, err = parseQueryDays(k, v)
+		case "never_login_inactive":
+			var inactive *bool
+			if inactive, err = parseQueryBool(k, v); err == nil {
+				query.ExcludeNeverLogin = !*inactive
+			}
+		case "token_rotated_before":
+			query.TokenRotatedBefore, err = parseQueryUnix(k, v)
+		case "token_not_rotated_days":
+			tokenRotatedDays, err = parseQueryDays(k, v)
+		case "include_deleted":
+			query.IncludeDeleted = v == "true"
+		case "deleted_after":
+			query.DeletedAfter, err = parseQueryUnix(k, v)
+		case "deleted_before":
+			query.DeletedBefore, err = parseQueryUnix(k, v)
+		case "consistency":
+			query.Consistency, err = ParseReadConsistency(v)
+		default:
+			return nil, NewStatusError(OutOfRangeErr, fmt.Sprintf("user filter %s is not supported", k))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if inactiveDays != nil {
+		// 同时指定 last_login_before 时取更早的时间点
+		before := time.Unix(time.Now().Add(-time.Duration(*inactiveDays)*24*time.Hour).Unix(), 0)
+		if query.LastLoginBefore.IsZero() || before.Before(query.LastLoginBefore) {
+			query.LastLoginBefore = before
+		}
+	}
+	if tokenRotatedDays != nil {
+		// 同时指定 token_rotated_before 时取更早的时间点
+		before := time.Unix(time.Now().Add(-time.Duration(*tokenRotatedDays)*24*time.Hour).Unix(), 0)
+		if query.TokenRotatedBefore.IsZero() || before.Before(query.TokenRotatedBefore) {
+			query.TokenRotatedBefore = before
+		}
+	}
+	if err := query.verifyDeleteTimeRange(); err != nil {
+		return nil, err
+	}
+	if !query.ModifiedAfter.IsZero() && !query.ModifiedBefore.IsZero() &&
+		!query.ModifiedAfter.Before(query.ModifiedBefore) {
+		return nil, NewStatusError(OutOfRangeErr, "modified_after must be earlier than modified_before")
+	}
+	return query, nil
+}
+
+// IsUnfiltered 是否未设置任何过滤条件，排序、hide_admin、exclude_ids 以及 include_deleted 不会缩小扫描的范围，不视为过滤条件
+func (q *UserQuery) IsUnfiltered() bool {
+	return q.ID == "" && q.Name == "" && q.Owner == "" && q.Source == "" && q.Keyword == "" && q.GroupID == "" && q.GroupName == "" &&
+		q.TokenEnable == nil && q.HasGroup == nil && q.CreatedBy == "" && q.CreatedAfter.IsZero() && q.LastLoginBefore.IsZero() &&
+		q.TokenRotatedBefore.IsZero() &&
+		q.ModifiedBy == "" && q.ModifiedAfter.IsZero() && q.ModifiedBefore.IsZero() &&
+		!q.HasDeleteTimeRange()
+}
+
+// CheckValues 校验字符串类型的过滤条件，取值中包含 NUL 等控制字符时返回 InvalidParameter
+// 过滤条件以绑定参数的方式传入不存在注入的问题，但是控制字符会导致驱动报错或者匹配到异常的数据，需要在查询前拒绝
+func (q *UserQuery) CheckValues() error {
+	values := []struct {
+		key, val string
+	}{
+		{"id", q.ID}, {"name", q.Name}, {"owner", q.Owner}, {"source", q.Source}, {"q", q.Keyword},
+		{"group_id", q.GroupID}, {"group_name", q.GroupName}, {"group_owner", q.GroupOwner},
+		{"created_by", q.CreatedBy}, {"modified_by", q.ModifiedBy},
+	}
+	for _, id := range q.ExcludeIDs {
+		values = append(values, struct{ key, val string }{"exclude_ids", id})
+	}
+	for _, item := range values {
+		if strings.IndexFunc(item.val, unicode.IsControl) >= 0 {
+			return NewStatusError(InvalidParameter, fmt.Sprintf("user filter %s contains control characters", item.key))
+		}
+	}
+	return nil
+}
+
+// HasDeleteTimeRange 是否需要按照用户的删除时间过滤
+func (q *UserQuery) HasDeleteTimeRange() bool {
+	return q.IncludeDeleted && (!q.DeletedAfter.IsZero() || !q.DeletedBefore.IsZero())
+}
+
+// verifyDeleteTimeRange 删除时间范围只在 include_deleted 时生效，否则忽略；同时指定两端时需要 deleted_after 早于 deleted_before
+func (q *UserQuery) verifyDeleteTimeRange() error {
+	if !q.IncludeDeleted {
+		q.DeletedAfter, q.DeletedBefore = time.Time{}, time.Time{}
+		return nil
+	}
+	if (!q.DeletedAfter.IsZero() && q.DeletedAfter.Unix() < 0) || (!q.DeletedBefore.IsZero() && q.DeletedBefore.Unix() < 0) {
+		return NewStatusError(OutOfRangeErr, "deleted_after and deleted_before must not be negative")
+	}
+	if !q.DeletedAfter.IsZero() && !q.DeletedBefore.IsZero() && !q.DeletedAfter.Before(q.DeletedBefore) {
+		return NewStatusError(OutOfRangeErr, "deleted_after must be earlier than deleted_before")
+	}
+	return nil
+}
+
+// parseQueryIds 解析以逗号分隔的 ID 列表，忽略空白以及重复的 ID
+func parseQueryIds(val string) []string {
+	var (
+		ids  []string
+		seen = make(map[string]struct{})
+	)
+	for _, id := range strings.Split(val, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func parseQueryBool(key, val string) (*bool, error) {
+	ret, err := strconv.ParseBool(val)
+	if err != nil {
+		return nil, NewStatusError(OutOfRangeErr, fmt.Sprintf("invalid %s value: %s", key, val))
+	}
+	return &ret, nil
+}
+
+// parseQueryDays 解析天数，取值为非负整数并且不超过 maxQueryDays
+func parseQueryDays(key, val string) (*uint64, error) {
+	ret, err := strconv.ParseUint(val, 10, 64)
+	if err != nil || ret > maxQueryDays {
+		return nil, NewStatusError(OutOfRangeErr, fmt.Sprintf("invalid %s value: %s", key, val))
+	}
+	return &ret, nil
+}
+
+// parseQueryUnix 解析 unix 秒
+func parseQueryUnix(key, val string) (time.Time, error) {
+	ret, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}, NewStatusError(OutOfRangeErr, fmt.Sprintf("invalid %s value: %s", key, val))
+	}
+	return time.Unix(ret, 0), nil
+}
@@ -0,0 +1,81 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"time"
+
+	"github.com/polarismesh/polaris/common/model"
+)
+
+// UserSnapshot ExportUsersSnapshot 导出的一行用户记录，字段均为存储中保存的值：
+// 密码为哈希值，开启 token 加密时 token 为密文，只在写入时使用的字段不导出
+type UserSnapshot struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Password           string `json:"password"`
+	Owner              string `json:"owner"`
+	Source             string `json:"source"`
+	Type               int    `json:"type"`
+	Comment            string `json:"comment"`
+	Token              string `json:"token"`
+	TokenEnable        bool   `json:"token_enable"`
+	Valid              bool   `json:"valid"`
+	MustChangePassword bool   `json:"must_change_password"`
+	CreatedBy          string `json:"created_by"`
+	ModifiedBy         string `json:"modified_by"`
+	CreateTime         int64  `json:"ctime"`
+	ModifyTime         int64  `json:"mtime"`
+	// 以下时间未知时为 0
+	LastLoginTime    int64 `json:"last_login_time"`
+	PasswordSetTime  int64 `json:"password_set_time"`
+	TokenRotatedTime int64 `json:"token_rotated_time"`
+	DeleteTime       int64 `json:"deleted_at"`
+}
+
+// NewUserSnapshot 根据存储中的用户生成导出记录，user 中的 token 需要是存储中保存的值
+func NewUserSnapshot(user *model.User) *UserSnapshot {
+	return &UserSnapshot{
+		ID:                 user.ID,
+		Name:               user.Name,
+		Password:           user.Password,
+		Owner:              user.Owner,
+		Source:             user.Source,
+		Type:               int(user.Type),
+		Comment:            user.Comment,
+		Token:              user.Token,
+		TokenEnable:        user.TokenEnable,
+		Valid:              user.Valid,
+		MustChangePassword: user.MustChangePassword,
+		CreatedBy:          user.CreatedBy,
+		ModifiedBy:         user.ModifiedBy,
+		CreateTime:         optionalUnix(user.CreateTime),
+		ModifyTime:         optionalUnix(user.ModifyTime),
+		LastLoginTime:      optionalUnix(user.LastLoginTime),
+		PasswordSetTime:    optionalUnix(user.PasswordSetTime),
+		TokenRotatedTime:   optionalUnix(user.TokenRotatedTime),
+		DeleteTime:         optionalUnix(user.DeleteTime),
+	}
+}
+
+func optionalUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}